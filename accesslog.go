@@ -0,0 +1,109 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"io"
+	"net/http"
+	"sync"
+	"time"
+)
+
+// AccessLogEntry describes a request and the response to it. It identifies the session only by a
+// salted digest of its ID, so that operators can follow a client's sequence of requests without the
+// log revealing IDs that could be used to hijack sessions, or that could be matched against the
+// unsalted digests in AuditEvents.
+type AccessLogEntry struct {
+	Time   time.Time `json:"time"`
+	Method string    `json:"method"`
+	Path   string    `json:"path"`
+	Status int       `json:"status"`
+	// Bytes is the number of bytes written in the response body.
+	Bytes   int64         `json:"bytes"`
+	Latency time.Duration `json:"latency_ns"`
+	// SessionRef is a salted digest of the ID of the session bound to the request once the response
+	// is complete, or empty if no session with an ID is bound.
+	SessionRef string `json:"session_ref,omitempty"`
+	// RequestID is the value of the request's X-Request-ID header, if any.
+	RequestID string `json:"request_id,omitempty"`
+	// Tags holds the tags recorded via SetTag on the session bound to the request once the
+	// response is complete, if any.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// AccessLogger receives AccessLogEntries. Its Log method must be safe for concurrent use, and
+// should return promptly, since it's called while handling requests.
+type AccessLogger interface {
+	Log(ctx context.Context, e AccessLogEntry)
+}
+
+// AccessLoggerFunc adapts an ordinary function to serve as an AccessLogger.
+type AccessLoggerFunc func(ctx context.Context, e AccessLogEntry)
+
+// Log calls f(ctx, e).
+func (f AccessLoggerFunc) Log(ctx context.Context, e AccessLogEntry) {
+	f(ctx, e)
+}
+
+// JSONAccessLogger returns an AccessLogger that writes each entry to the supplied writer as a line
+// of JSON, serializing concurrent writes. It ignores errors writing entries.
+func JSONAccessLogger(w io.Writer) AccessLogger {
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	return AccessLoggerFunc(func(_ context.Context, e AccessLogEntry) {
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(e)
+	})
+}
+
+// WithAccessLog returns an HTTP handler that delegates each request to the supplied handler, and
+// then reports the request and its response to the given AccessLogger. It identifies the singular
+// session bound to the request via WithSession, which must then enclose the returned handler, by
+// a digest of its ID keyed with the given salt, which should be secret and long-lived enough to
+// correlate entries across the period of interest. Note that sessions.CookieStore doesn't assign
+// IDs to sessions. It panics if the supplied AccessLogger or handler is nil.
+func WithAccessLog(salt []byte, logger AccessLogger, h http.Handler) http.Handler {
+	if logger == nil {
+		panic("no access logger supplied")
+	}
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	ref := func(id string) string {
+		mac := hmac.New(sha256.New, salt)
+		mac.Write([]byte(id))
+		return hex.EncodeToString(mac.Sum(nil)[:12])
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		start := SystemClock.Now()
+		recorder := &statusRecorder{ResponseWriter: w}
+		h.ServeHTTP(recorder, r)
+		e := AccessLogEntry{
+			Time:      start,
+			Method:    r.Method,
+			Path:      r.URL.Path,
+			Status:    recorder.status,
+			Bytes:     recorder.bytes,
+			Latency:   SystemClock.Now().Sub(start),
+			RequestID: r.Header.Get("X-Request-ID"),
+		}
+		if e.Status == 0 {
+			e.Status = http.StatusOK
+		}
+		// Read the session's ID only now, as saving the session may have assigned or rotated it.
+		if s, ok := ExtractSession(r); ok {
+			if len(s.ID) != 0 {
+				e.SessionRef = ref(s.ID)
+			}
+			e.Tags = Tags(s)
+		}
+		logger.Log(r.Context(), e)
+	})
+}
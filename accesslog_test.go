@@ -0,0 +1,72 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestWithAccessLogPanicsWithNoLogger(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithAccessLog(nil, nil, http.NotFoundHandler())
+}
+
+func TestWithAccessLogPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithAccessLog(nil, handler.JSONAccessLogger(&bytes.Buffer{}), nil)
+}
+
+func TestWithAccessLog(t *testing.T) {
+	store := kvstore.New(kvstore.NewMemory(), securecookie.GenerateRandomKey(32))
+	var log bytes.Buffer
+	var ids []string
+	h := handler.WithSession("s", store, handler.WithAccessLog([]byte("salt"), handler.JSONAccessLogger(&log), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := handler.MustExtractSession(r)
+		if err := session.Save(r, w); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+		ids = append(ids, session.ID)
+		w.WriteHeader(http.StatusCreated)
+		w.Write([]byte("hello"))
+	})), nil)
+	r := httptest.NewRequest(http.MethodPost, "/items", nil)
+	r.Header.Set("X-Request-ID", "req1")
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	r = httptest.NewRequest(http.MethodGet, "/items", nil)
+	r.Header.Set("X-Request-ID", "req2")
+	r.AddCookie(recorder.Result().Cookies()[0])
+	h.ServeHTTP(httptest.NewRecorder(), r)
+
+	var entries []handler.AccessLogEntry
+	for dec := json.NewDecoder(&log); dec.More(); {
+		var e handler.AccessLogEntry
+		if err := dec.Decode(&e); err != nil {
+			t.Fatalf("failed to decode entry: %v", err)
+		}
+		entries = append(entries, e)
+	}
+	if len(entries) != 2 {
+		t.Fatalf("entries: got %d, want 2", len(entries))
+	}
+	first, second := entries[0], entries[1]
+	if first.Method != http.MethodPost || first.Path != "/items" || first.Status != http.StatusCreated || first.Bytes != 5 || first.RequestID != "req1" {
+		t.Errorf("first entry: got %+v", first)
+	}
+	if len(first.SessionRef) == 0 || first.SessionRef != second.SessionRef {
+		t.Errorf("session refs: got %q and %q, want equal and not empty", first.SessionRef, second.SessionRef)
+	}
+	if strings.Contains(log.String(), ids[0]) {
+		t.Error("log reveals session ID")
+	}
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// DefaultAffinityCookieName is the name of the cookie that WithAffinity issues unless told
+// otherwise.
+const DefaultAffinityCookieName = "affinity"
+
+// AffinityPolicy configures WithAffinity.
+type AffinityPolicy struct {
+	// InstanceID identifies this instance of the application to the load balancer, such as by its
+	// host name. It's required.
+	InstanceID string
+	// Codecs sign, and optionally encrypt, the affinity cookie, as with the cookies of
+	// sessions.CookieStore. It's required.
+	Codecs []securecookie.Codec
+	// CookieName is the name of the affinity cookie. If empty, WithAffinity uses
+	// DefaultAffinityCookieName.
+	CookieName string
+	// Options holds the attributes of the affinity cookie. If nil, WithAffinity issues cookies
+	// scoped to the path "/", hidden from scripts, and lasting until the browser closes.
+	Options *sessions.Options
+}
+
+type affinityContextKey struct{}
+
+// WithAffinity returns an HTTP handler that issues a cookie naming this instance of the
+// application, per the AffinityPolicy, for load balancers that route requests bearing such a
+// cookie back to the instance it names, as deployments keeping sessions in memory require. Point
+// the load balancer at the cookie's name; it needn't verify the cookie's signature, which instead
+// lets the application trust the instance the cookie names.
+//
+// It reissues the cookie whenever a request bears none, one that fails to decode, or one naming
+// another instance, as happens when the load balancer moves a client after its instance departs.
+// Before delegating further request processing to the supplied handler, it binds the instance
+// named by the request's cookie to the request, for retrieval with ExtractAffinity. It panics if
+// the policy lacks an instance ID or codecs, or if the supplied handler is nil.
+func WithAffinity(p AffinityPolicy, h http.Handler) http.Handler {
+	if len(p.InstanceID) == 0 {
+		panic("no instance ID supplied")
+	}
+	if len(p.Codecs) == 0 {
+		panic("no affinity cookie codecs supplied")
+	}
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	name := p.CookieName
+	if len(name) == 0 {
+		name = DefaultAffinityCookieName
+	}
+	options := p.Options
+	if options == nil {
+		options = &sessions.Options{Path: "/", HttpOnly: true}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var instance string
+		if c, err := r.Cookie(name); err == nil {
+			if err := securecookie.DecodeMulti(name, c.Value, &instance, p.Codecs...); err != nil {
+				instance = ""
+			}
+		}
+		if instance != p.InstanceID {
+			if encoded, err := securecookie.EncodeMulti(name, p.InstanceID, p.Codecs...); err == nil {
+				http.SetCookie(w, sessions.NewCookie(name, encoded, options))
+			}
+		}
+		if len(instance) != 0 {
+			r = r.WithContext(context.WithValue(r.Context(), affinityContextKey{}, instance))
+		}
+		h.ServeHTTP(w, r)
+	})
+}
+
+// ExtractAffinity retrieves the instance named by the valid affinity cookie that the request bore,
+// per WithAffinity, together with a boolean indicating whether it bore any such cookie. A handler
+// can compare the instance with its own ID to notice that the load balancer moved the client from
+// another instance, whose in-memory sessions are unavailable here.
+func ExtractAffinity(r *http.Request) (string, bool) {
+	instance, ok := r.Context().Value(affinityContextKey{}).(string)
+	return instance, ok
+}
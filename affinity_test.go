@@ -0,0 +1,58 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler"
+)
+
+func TestWithAffinityPanicsWithNoInstanceID(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithAffinity(handler.AffinityPolicy{Codecs: securecookie.CodecsFromPairs([]byte("key"))}, http.NotFoundHandler())
+}
+
+func TestWithAffinityPanicsWithNoCodecs(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithAffinity(handler.AffinityPolicy{InstanceID: "a"}, http.NotFoundHandler())
+}
+
+func TestWithAffinity(t *testing.T) {
+	codecs := securecookie.CodecsFromPairs(securecookie.GenerateRandomKey(32))
+	serve := func(instance string, cookies []*http.Cookie) (string, bool, []*http.Cookie) {
+		r := httptest.NewRequest("", "/", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		var affinity string
+		var ok bool
+		recorder := httptest.NewRecorder()
+		handler.WithAffinity(handler.AffinityPolicy{InstanceID: instance, Codecs: codecs}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			affinity, ok = handler.ExtractAffinity(r)
+		})).ServeHTTP(recorder, r)
+		return affinity, ok, recorder.Result().Cookies()
+	}
+
+	affinity, ok, issued := serve("a", nil)
+	if ok {
+		t.Errorf("affinity of request bearing no cookie: got %q", affinity)
+	}
+	if len(issued) != 1 || issued[0].Name != handler.DefaultAffinityCookieName || !issued[0].HttpOnly {
+		t.Fatalf("issued cookies: got %v, want one affinity cookie", issued)
+	}
+	if affinity, ok, cookies := serve("a", issued); !ok || affinity != "a" || len(cookies) != 0 {
+		t.Errorf("same instance: got affinity (%q, %t) and cookies %v, want (%q, true) and none", affinity, ok, cookies, "a")
+	}
+	if affinity, ok, cookies := serve("b", issued); !ok || affinity != "a" || len(cookies) != 1 {
+		t.Errorf("other instance: got affinity (%q, %t) and cookies %v, want (%q, true) and one", affinity, ok, cookies, "a")
+	}
+	forged := []*http.Cookie{{Name: handler.DefaultAffinityCookieName, Value: "b"}}
+	if affinity, ok, cookies := serve("a", forged); ok || len(cookies) != 1 {
+		t.Errorf("forged cookie: got affinity (%q, %t) and cookies %v, want none and one", affinity, ok, cookies)
+	}
+}
@@ -0,0 +1,172 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"math/rand"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// LifecycleEventKind identifies a milestone in the lifecycle of a session in a LifecycleEvent.
+type LifecycleEventKind string
+
+// These are the kinds of LifecycleEvent.
+const (
+	// LifecycleSessionCreated denotes the first save of a new session.
+	LifecycleSessionCreated LifecycleEventKind = "session.created"
+	// LifecycleFirstAuthenticated denotes the first save of a session bearing a principal, per
+	// SetPrincipal, that bore none before.
+	LifecycleFirstAuthenticated LifecycleEventKind = "session.first_authenticated"
+	// LifecycleSessionExpired denotes a request bearing the cookie of a session that its store no
+	// longer recognizes, whether because it expired or because the cookie is otherwise invalid.
+	LifecycleSessionExpired LifecycleEventKind = "session.expired"
+	// LifecycleSessionDestroyed denotes a save of a session that deleted it.
+	LifecycleSessionDestroyed LifecycleEventKind = "session.destroyed"
+)
+
+// LifecycleEvent describes a milestone in the lifecycle of a session, for funnel analysis. Unlike
+// an AuditEvent, it carries nothing about the request during which it occurred, nor the session's
+// principal.
+type LifecycleEvent struct {
+	Kind        LifecycleEventKind `json:"kind"`
+	Time        time.Time          `json:"time"`
+	SessionName string             `json:"session_name"`
+	// SessionRef is a digest of the session's ID, as in AuditEvents, or empty if the session has
+	// no ID.
+	SessionRef string `json:"session_ref,omitempty"`
+	// Tags holds the tags recorded on the session via SetTag, if any.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// LifecycleSink receives LifecycleEvents, such as to forward them to an analytics pipeline. Its
+// Record method must be safe for concurrent use, and should return promptly, since it's called
+// while handling requests.
+type LifecycleSink interface {
+	Record(ctx context.Context, e LifecycleEvent)
+}
+
+// LifecycleSinkFunc adapts an ordinary function to serve as a LifecycleSink.
+type LifecycleSinkFunc func(ctx context.Context, e LifecycleEvent)
+
+// Record calls f(ctx, e).
+func (f LifecycleSinkFunc) Record(ctx context.Context, e LifecycleEvent) {
+	f(ctx, e)
+}
+
+// sampled reports whether the session identified by the given key falls within the given fraction
+// of sessions, deciding consistently for each key, or at random if the key is empty.
+func sampled(fraction float64, key string) bool {
+	switch {
+	case fraction >= 1:
+		return true
+	case fraction <= 0:
+		return false
+	case len(key) == 0:
+		return rand.Float64() < fraction
+	}
+	sum := sha256.Sum256([]byte(key))
+	return float64(binary.BigEndian.Uint64(sum[:8])) < fraction*(1<<64)
+}
+
+func newLifecycleEvent(kind LifecycleEventKind, s *sessions.Session, id string) LifecycleEvent {
+	return LifecycleEvent{
+		Kind:        kind,
+		Time:        SystemClock.Now(),
+		SessionName: s.Name(),
+		SessionRef:  sessionRef(id),
+		Tags:        Tags(s),
+	}
+}
+
+// lifecycleStore is a sessions.Store that reports the lifecycle milestones reached by saving a
+// single session to a LifecycleSink.
+type lifecycleStore struct {
+	sessions.Store
+	fraction      float64
+	sink          LifecycleSink
+	boundID       string
+	boundIsNew    bool
+	authenticated bool
+}
+
+func (l *lifecycleStore) unwrapStore() sessions.Store {
+	return l.Store
+}
+
+func (l *lifecycleStore) record(ctx context.Context, kind LifecycleEventKind, s *sessions.Session, id string) {
+	if sampled(l.fraction, id) {
+		l.sink.Record(ctx, newLifecycleEvent(kind, s, id))
+	}
+}
+
+func (l *lifecycleStore) Save(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	if err := l.Store.Save(r, w, s); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	if s.Options != nil && s.Options.MaxAge < 0 {
+		id := s.ID
+		if len(id) == 0 {
+			id = l.boundID
+		}
+		l.record(ctx, LifecycleSessionDestroyed, s, id)
+		return nil
+	}
+	if l.boundIsNew && len(l.boundID) == 0 {
+		l.record(ctx, LifecycleSessionCreated, s, s.ID)
+	}
+	if _, ok := SessionPrincipal(s); ok && !l.authenticated {
+		l.record(ctx, LifecycleFirstAuthenticated, s, s.ID)
+	}
+	// Compare subsequent saves of this session against this one.
+	l.boundID, l.boundIsNew = s.ID, false
+	_, l.authenticated = SessionPrincipal(s)
+	return nil
+}
+
+// SampleLifecycle returns an Option that reports the lifecycle milestones of the given fraction of
+// bound sessions to the given LifecycleSink: creation, first authentication, and destruction when
+// saving a session, and expiry when binding a fresh session to a request bearing a cookie for the
+// session it replaces. Reporting only a sample suits funnel analysis without recording an event
+// for every session.
+//
+// It samples sessions by a digest of their IDs, so that it reports either all or none of the
+// milestones for each session whose store assigns IDs, such as kvstore.Store, or at random for
+// sessions without IDs. It samples expired sessions by a digest of their cookies' values. It
+// panics if the sink is nil or if the fraction is not between zero and one.
+func SampleLifecycle(fraction float64, sink LifecycleSink) Option {
+	if sink == nil {
+		panic("no lifecycle sink supplied")
+	}
+	if fraction < 0 || fraction > 1 {
+		panic("sampling fraction must be between zero and one")
+	}
+	return func(c *bindingConfig) {
+		c.decorators = append(c.decorators, func(r *http.Request, s *sessions.Session) *sessions.Session {
+			if s.IsNew {
+				if cookie, err := r.Cookie(s.Name()); err == nil && sampled(fraction, cookie.Value) {
+					sink.Record(r.Context(), newLifecycleEvent(LifecycleSessionExpired, s, ""))
+				}
+			}
+			if s.Store() == nil {
+				return s
+			}
+			_, authenticated := SessionPrincipal(s)
+			return rebindSession(s, &lifecycleStore{
+				Store:         s.Store(),
+				fraction:      fraction,
+				sink:          sink,
+				boundID:       s.ID,
+				boundIsNew:    s.IsNew,
+				authenticated: authenticated,
+			})
+		})
+	}
+}
@@ -0,0 +1,94 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestSampleLifecyclePanicsWithNoSink(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.SampleLifecycle(1, nil)
+}
+
+func TestSampleLifecyclePanicsWithInvalidFraction(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.SampleLifecycle(1.5, handler.LifecycleSinkFunc(func(context.Context, handler.LifecycleEvent) {}))
+}
+
+func TestSampleLifecycle(t *testing.T) {
+	for _, test := range []struct {
+		fraction float64
+		want     []handler.LifecycleEventKind
+	}{
+		{1, []handler.LifecycleEventKind{
+			handler.LifecycleSessionCreated,
+			handler.LifecycleFirstAuthenticated,
+			handler.LifecycleSessionDestroyed,
+			handler.LifecycleSessionExpired,
+		}},
+		{0, nil},
+	} {
+		store := kvstore.New(kvstore.NewMemory(), securecookie.GenerateRandomKey(32))
+		var events []handler.LifecycleEvent
+		sink := handler.LifecycleSinkFunc(func(_ context.Context, e handler.LifecycleEvent) {
+			events = append(events, e)
+		})
+		serve := func(cookies []*http.Cookie, f func(w http.ResponseWriter, r *http.Request)) []*http.Cookie {
+			r := httptest.NewRequest("", "/", nil)
+			for _, c := range cookies {
+				r.AddCookie(c)
+			}
+			recorder := httptest.NewRecorder()
+			handler.WithSession("s", store, http.HandlerFunc(f), nil, handler.SampleLifecycle(test.fraction, sink)).ServeHTTP(recorder, r)
+			return recorder.Result().Cookies()
+		}
+		save := func(w http.ResponseWriter, r *http.Request) {
+			if err := handler.MustExtractSession(r).Save(r, w); err != nil {
+				t.Fatalf("failed to save session: %v", err)
+			}
+		}
+
+		created := serve(nil, func(w http.ResponseWriter, r *http.Request) {
+			handler.SetTag(handler.MustExtractSession(r), "client", "web")
+			save(w, r)
+		})
+		serve(created, func(w http.ResponseWriter, r *http.Request) {
+			handler.SetPrincipal(handler.MustExtractSession(r), "alice")
+			save(w, r)
+		})
+		// Saving again doesn't authenticate the session for the first time again.
+		serve(created, save)
+		serve(created, func(w http.ResponseWriter, r *http.Request) {
+			handler.MustExtractSession(r).Options.MaxAge = -1
+			save(w, r)
+		})
+		serve(created, func(http.ResponseWriter, *http.Request) {})
+
+		var kinds []handler.LifecycleEventKind
+		for _, e := range events {
+			kinds = append(kinds, e.Kind)
+		}
+		if !reflect.DeepEqual(kinds, test.want) {
+			t.Fatalf("fraction %v: event kinds: got %v, want %v", test.fraction, kinds, test.want)
+		}
+		if len(events) == 0 {
+			continue
+		}
+		if ref := events[0].SessionRef; len(ref) == 0 || events[1].SessionRef != ref || events[2].SessionRef != ref {
+			t.Errorf("session refs: got %q, %q, and %q, want the same", ref, events[1].SessionRef, events[2].SessionRef)
+		}
+		if got, want := events[0].Tags, map[string]string{"client": "web"}; !reflect.DeepEqual(got, want) {
+			t.Errorf("tags: got %v, want %v", got, want)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// DefaultIdentityAssertionHeader is the request header that an IdentityAsserter uses unless told
+// otherwise.
+const DefaultIdentityAssertionHeader = "X-Identity-Assertion"
+
+// identityAssertionVersion prefixes the assertions that an IdentityAsserter issues, allowing their
+// format to change.
+const identityAssertionVersion = "v1"
+
+// IdentityAsserter propagates the principal of a request to the internal services it calls on the
+// request's behalf, as short-lived assertions signed with keys shared with those services. Each
+// assertion names the service for which it was issued, so that a service can't replay assertions
+// it receives to other services.
+type IdentityAsserter struct {
+	// Keys holds the HMAC keys with which to sign assertions. The IdentityAsserter signs with the
+	// first key, and accepts signatures made with any of them, permitting rotation of the keys.
+	// It's required.
+	Keys [][]byte
+	// Audience identifies the receiving service, such as "billing". Calling and receiving sides
+	// must agree on it.
+	Audience string
+	// TTL is how long assertions remain valid. Keep it short, since assertions can be replayed to
+	// the same service until they expire. If not positive, the IdentityAsserter uses 30 seconds.
+	TTL time.Duration
+	// Header is the request header bearing assertions. If empty, the IdentityAsserter uses
+	// DefaultIdentityAssertionHeader.
+	Header string
+	// Clock reports the current time. If nil, the IdentityAsserter uses SystemClock.
+	Clock Clock
+}
+
+func (a *IdentityAsserter) ttl() time.Duration {
+	if a.TTL > 0 {
+		return a.TTL
+	}
+	return 30 * time.Second
+}
+
+func (a *IdentityAsserter) header() string {
+	if len(a.Header) != 0 {
+		return a.Header
+	}
+	return DefaultIdentityAssertionHeader
+}
+
+func (a *IdentityAsserter) checkKeys() {
+	if len(a.Keys) == 0 {
+		panic("no identity assertion keys supplied")
+	}
+}
+
+func (a *IdentityAsserter) sign(key []byte, expires, principal string) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(identityAssertionVersion))
+	mac.Write([]byte{0})
+	mac.Write([]byte(a.Audience))
+	mac.Write([]byte{0})
+	mac.Write([]byte(expires))
+	mac.Write([]byte{0})
+	mac.Write([]byte(principal))
+	return mac.Sum(nil)
+}
+
+// Assert returns an assertion that the given principal is making a request to the service named by
+// the IdentityAsserter's Audience, valid for its TTL. It's composed of the format's version, the
+// expiration time in seconds since the Unix epoch, the principal, and the signature, separated by
+// periods, with the principal and signature encoded in unpadded base64url. It panics if the
+// IdentityAsserter has no keys.
+func (a *IdentityAsserter) Assert(principal string) string {
+	a.checkKeys()
+	expires := strconv.FormatInt(clockOrSystem(a.Clock).Now().Add(a.ttl()).Unix(), 10)
+	return identityAssertionVersion + "." + expires + "." +
+		base64.RawURLEncoding.EncodeToString([]byte(principal)) + "." +
+		base64.RawURLEncoding.EncodeToString(a.sign(a.Keys[0], expires, principal))
+}
+
+// Verify returns the principal named by the assertion, together with a boolean indicating whether
+// the assertion bears a valid signature for the IdentityAsserter's Audience and has yet to
+// expire. It panics if the IdentityAsserter has no keys.
+func (a *IdentityAsserter) Verify(assertion string) (string, bool) {
+	a.checkKeys()
+	parts := strings.Split(assertion, ".")
+	if len(parts) != 4 || parts[0] != identityAssertionVersion {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(parts[1], 10, 64)
+	if err != nil || !clockOrSystem(a.Clock).Now().Before(time.Unix(expires, 0)) {
+		return "", false
+	}
+	principal, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(principal) == 0 {
+		return "", false
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[3])
+	if err != nil {
+		return "", false
+	}
+	for _, key := range a.Keys {
+		if hmac.Equal(sig, a.sign(key, parts[1], string(principal))) {
+			return string(principal), true
+		}
+	}
+	return "", false
+}
+
+// assertingTransport is an http.RoundTripper that adds identity assertions to outbound requests.
+type assertingTransport struct {
+	asserter *IdentityAsserter
+	base     http.RoundTripper
+}
+
+func (t assertingTransport) RoundTrip(r *http.Request) (*http.Response, error) {
+	header := t.asserter.header()
+	principal, ok := ExtractPrincipal(r)
+	if !ok && len(r.Header.Values(header)) == 0 {
+		return t.base.RoundTrip(r)
+	}
+	// A RoundTripper must not modify the request it's given.
+	r = r.Clone(r.Context())
+	r.Header.Del(header)
+	if ok {
+		r.Header.Set(header, t.asserter.Assert(principal))
+	}
+	return t.base.RoundTrip(r)
+}
+
+// Transport returns an http.RoundTripper that delegates to the supplied one, or to
+// http.DefaultTransport if nil, adding to each outbound request an assertion of the principal of
+// the inbound request on whose behalf it's made, per ExtractPrincipal. It finds that principal in
+// the outbound request's context, so create outbound requests with the inbound request's context,
+// such as via http.NewRequestWithContext(r.Context(), ...). It removes any assertion already borne
+// by outbound requests made on behalf of no principal. It panics if the IdentityAsserter has no
+// keys.
+func (a *IdentityAsserter) Transport(base http.RoundTripper) http.RoundTripper {
+	a.checkKeys()
+	if base == nil {
+		base = http.DefaultTransport
+	}
+	return assertingTransport{a, base}
+}
+
+// VerifyIdentityAssertion returns an HTTP handler for receiving services that accepts only
+// requests bearing a valid identity assertion issued by an IdentityAsserter with the same keys and
+// Audience, delegating them to the supplied handler, bound to the asserted principal via
+// BindPrincipal so that ExtractPrincipal reports it.
+//
+// It delegates rejected requests to the onReject handler. If no such onReject handler is supplied,
+// it will respond with HTTP status code 401 with no body. It panics if the supplied handler is nil
+// or if the IdentityAsserter has no keys.
+func (a *IdentityAsserter) VerifyIdentityAssertion(h http.Handler, onReject http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	a.checkKeys()
+	if onReject == nil {
+		onReject = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	}
+	header := a.header()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, ok := a.Verify(r.Header.Get(header))
+		if !ok {
+			onReject.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, BindPrincipal(r, principal))
+	})
+}
@@ -0,0 +1,90 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+)
+
+func TestIdentityAsserterPanicsWithNoKeys(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	(&handler.IdentityAsserter{}).Transport(nil)
+}
+
+func TestVerifyIdentityAssertionPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	(&handler.IdentityAsserter{Keys: [][]byte{[]byte("key")}}).VerifyIdentityAssertion(nil, nil)
+}
+
+func TestIdentityAssertion(t *testing.T) {
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	receiver := &handler.IdentityAsserter{
+		Keys:     [][]byte{[]byte("new"), []byte("old")},
+		Audience: "billing",
+		Clock:    clock,
+	}
+	service := httptest.NewServer(receiver.VerifyIdentityAssertion(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := handler.ExtractPrincipal(r)
+		io.WriteString(w, p)
+	}), nil))
+	defer service.Close()
+
+	call := func(caller *handler.IdentityAsserter, principal string, spoofed string) (int, string) {
+		t.Helper()
+		inbound := httptest.NewRequest("", "/", nil)
+		if len(principal) != 0 {
+			inbound = handler.BindPrincipal(inbound, principal)
+		}
+		outbound, err := http.NewRequestWithContext(inbound.Context(), http.MethodGet, service.URL, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(spoofed) != 0 {
+			outbound.Header.Set(handler.DefaultIdentityAssertionHeader, spoofed)
+		}
+		res, err := (&http.Client{Transport: caller.Transport(nil)}).Do(outbound)
+		if err != nil {
+			t.Fatalf("failed to call service: %v", err)
+		}
+		defer res.Body.Close()
+		body, _ := io.ReadAll(res.Body)
+		if got := outbound.Header.Get(handler.DefaultIdentityAssertionHeader); got != spoofed {
+			t.Errorf("transport modified the outbound request's header: got %q", got)
+		}
+		return res.StatusCode, string(body)
+	}
+
+	caller := &handler.IdentityAsserter{Keys: [][]byte{[]byte("old")}, Audience: "billing", Clock: clock}
+	if code, body := call(caller, "alice", ""); code != http.StatusOK || body != "alice" {
+		t.Errorf("call on behalf of alice: got status %d with body %q, want 200 with %q", code, body, "alice")
+	}
+	forged := (&handler.IdentityAsserter{Keys: [][]byte{[]byte("new")}, Audience: "billing", Clock: clock}).Assert("mallory")
+	if code, _ := call(caller, "", forged); code != http.StatusUnauthorized {
+		t.Errorf("call on behalf of no principal bearing an assertion: got status %d, want 401", code)
+	}
+	other := &handler.IdentityAsserter{Keys: [][]byte{[]byte("new")}, Audience: "search", Clock: clock}
+	if code, _ := call(other, "alice", ""); code != http.StatusUnauthorized {
+		t.Errorf("call bearing assertion for another audience: got status %d, want 401", code)
+	}
+
+	assertion := caller.Assert("alice")
+	if p, ok := receiver.Verify(assertion); !ok || p != "alice" {
+		t.Errorf("Verify: got (%q, %t), want (%q, true)", p, ok, "alice")
+	}
+	if _, ok := receiver.Verify(strings.Replace(assertion, "v1.", "v2.", 1)); ok {
+		t.Error("verified assertion in unknown format")
+	}
+	clock.Advance(30 * time.Second)
+	if _, ok := receiver.Verify(assertion); ok {
+		t.Error("verified expired assertion")
+	}
+}
@@ -0,0 +1,185 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// AuditEventKind identifies what happened to a session in an AuditEvent.
+type AuditEventKind string
+
+// These are the kinds of AuditEvent.
+const (
+	// AuditSessionCreated denotes the first save of a new session.
+	AuditSessionCreated AuditEventKind = "session.created"
+	// AuditSessionLoaded denotes the binding of an existing session to a request.
+	AuditSessionLoaded AuditEventKind = "session.loaded"
+	// AuditSessionRotated denotes a save of a session under an ID different from its former ID.
+	AuditSessionRotated AuditEventKind = "session.rotated"
+	// AuditSessionDestroyed denotes a save of a session that deleted it.
+	AuditSessionDestroyed AuditEventKind = "session.destroyed"
+	// AuditPrincipalAttached denotes a save of a session bearing a principal, per SetPrincipal,
+	// that it didn't bear when bound to the request.
+	AuditPrincipalAttached AuditEventKind = "session.principal_attached"
+	// AuditSessionMutated denotes a save of a session whose values changed since it was bound to
+	// the request, as reported by the AuditMutations Option.
+	AuditSessionMutated AuditEventKind = "session.mutated"
+)
+
+// AuditKeyChange describes a change to the value stored under a session key, identifying the
+// values only by digests. A missing value has an empty digest.
+type AuditKeyChange struct {
+	Key     string `json:"key"`
+	OldHash string `json:"old_hash,omitempty"`
+	NewHash string `json:"new_hash,omitempty"`
+}
+
+// AuditEvent describes an occurrence in the lifecycle of a session, together with the request
+// during which it occurred. It identifies sessions only by a digest of their IDs, so that the
+// audit trail doesn't reveal IDs that could be used to hijack sessions.
+type AuditEvent struct {
+	Kind        AuditEventKind `json:"kind"`
+	Time        time.Time      `json:"time"`
+	SessionName string         `json:"session_name"`
+	// SessionRef is a digest of the session's ID, or empty if the session has no ID.
+	SessionRef string `json:"session_ref,omitempty"`
+	// PreviousSessionRef is a digest of the session's former ID, for AuditSessionRotated events.
+	PreviousSessionRef string `json:"previous_session_ref,omitempty"`
+	// Principal is the principal associated with the session, per SessionPrincipal, if any.
+	Principal  string `json:"principal,omitempty"`
+	Method     string `json:"method"`
+	Path       string `json:"path"`
+	RemoteAddr string `json:"remote_addr"`
+	UserAgent  string `json:"user_agent,omitempty"`
+	// RequestID is the value of the request's X-Request-ID header, if any.
+	RequestID string `json:"request_id,omitempty"`
+	// Changes describes the changed values, for AuditSessionMutated events.
+	Changes []AuditKeyChange `json:"changes,omitempty"`
+	// Tags holds the tags recorded on the session via SetTag, if any.
+	Tags map[string]string `json:"tags,omitempty"`
+}
+
+// AuditSink receives AuditEvents, such as to forward them to a security information and event
+// management (SIEM) system. Its Audit method must be safe for concurrent use, and should return
+// promptly, since it's called while handling requests.
+type AuditSink interface {
+	Audit(ctx context.Context, e AuditEvent)
+}
+
+// AuditSinkFunc adapts an ordinary function to serve as an AuditSink.
+type AuditSinkFunc func(ctx context.Context, e AuditEvent)
+
+// Audit calls f(ctx, e).
+func (f AuditSinkFunc) Audit(ctx context.Context, e AuditEvent) {
+	f(ctx, e)
+}
+
+// sessionRef returns a digest of the given session ID suitable for correlating audit events, or an
+// empty string if the ID is empty.
+func sessionRef(id string) string {
+	if len(id) == 0 {
+		return ""
+	}
+	sum := sha256.Sum256([]byte(id))
+	return hex.EncodeToString(sum[:12])
+}
+
+func newAuditEvent(kind AuditEventKind, clock Clock, r *http.Request, s *sessions.Session) AuditEvent {
+	e := AuditEvent{
+		Kind:        kind,
+		Time:        clock.Now(),
+		SessionName: s.Name(),
+		SessionRef:  sessionRef(s.ID),
+		Method:      r.Method,
+		Path:        r.URL.Path,
+		RemoteAddr:  r.RemoteAddr,
+		UserAgent:   r.UserAgent(),
+		RequestID:   r.Header.Get("X-Request-ID"),
+	}
+	e.Principal, _ = SessionPrincipal(s)
+	e.Tags = Tags(s)
+	return e
+}
+
+// auditingStore is a sessions.Store that reports the effects of saving a single session to an
+// AuditSink, comparing the session to its state when bound to the request.
+type auditingStore struct {
+	sessions.Store
+	sink         AuditSink
+	clock        Clock
+	boundID      string
+	boundIsNew   bool
+	hadPrincipal string
+}
+
+func (a *auditingStore) unwrapStore() sessions.Store {
+	return a.Store
+}
+
+func (a *auditingStore) Save(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	if err := a.Store.Save(r, w, s); err != nil {
+		return err
+	}
+	ctx := r.Context()
+	if s.Options != nil && s.Options.MaxAge < 0 {
+		e := newAuditEvent(AuditSessionDestroyed, a.clock, r, s)
+		if len(e.SessionRef) == 0 {
+			e.SessionRef = sessionRef(a.boundID)
+		}
+		a.sink.Audit(ctx, e)
+		return nil
+	}
+	switch {
+	case a.boundIsNew && len(a.boundID) == 0:
+		a.sink.Audit(ctx, newAuditEvent(AuditSessionCreated, a.clock, r, s))
+	case s.ID != a.boundID:
+		e := newAuditEvent(AuditSessionRotated, a.clock, r, s)
+		e.PreviousSessionRef = sessionRef(a.boundID)
+		a.sink.Audit(ctx, e)
+	}
+	if p, ok := SessionPrincipal(s); ok && p != a.hadPrincipal {
+		a.sink.Audit(ctx, newAuditEvent(AuditPrincipalAttached, a.clock, r, s))
+	}
+	// Compare subsequent saves of this session against this one.
+	a.boundID, a.boundIsNew = s.ID, false
+	a.hadPrincipal, _ = SessionPrincipal(s)
+	return nil
+}
+
+// Audit returns an Option that reports the lifecycle events of bound sessions to the given
+// AuditSink: loading when binding an existing session to a request, and creation, rotation,
+// destruction, and attachment of a principal when saving a session. It records the time of each
+// event per the Clock supplied via UseClock, if any. It panics if the sink is nil.
+func Audit(sink AuditSink) Option {
+	if sink == nil {
+		panic("no audit sink supplied")
+	}
+	return func(c *bindingConfig) {
+		c.decorators = append(c.decorators, func(r *http.Request, s *sessions.Session) *sessions.Session {
+			clock := clockOrSystem(c.clock)
+			if !s.IsNew {
+				sink.Audit(r.Context(), newAuditEvent(AuditSessionLoaded, clock, r, s))
+			}
+			if s.Store() == nil {
+				return s
+			}
+			principal, _ := SessionPrincipal(s)
+			return rebindSession(s, &auditingStore{
+				Store:        s.Store(),
+				sink:         sink,
+				clock:        clock,
+				boundID:      s.ID,
+				boundIsNew:   s.IsNew,
+				hadPrincipal: principal,
+			})
+		})
+	}
+}
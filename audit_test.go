@@ -0,0 +1,188 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+type recordingAuditSink struct {
+	events []handler.AuditEvent
+}
+
+func (s *recordingAuditSink) Audit(_ context.Context, e handler.AuditEvent) {
+	s.events = append(s.events, e)
+}
+
+func (s *recordingAuditSink) kinds() []handler.AuditEventKind {
+	kinds := make([]handler.AuditEventKind, len(s.events))
+	for i, e := range s.events {
+		kinds[i] = e.Kind
+	}
+	return kinds
+}
+
+func TestAuditPanicsWithNoSink(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.Audit(nil)
+}
+
+func TestAudit(t *testing.T) {
+	store := kvstore.New(kvstore.NewMemory(), securecookie.GenerateRandomKey(32))
+	var sink recordingAuditSink
+	serve := func(r *http.Request, f func(w http.ResponseWriter, r *http.Request)) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.WithSession("s", store, http.HandlerFunc(f), nil, handler.Audit(&sink)).ServeHTTP(recorder, r)
+		return recorder
+	}
+	save := func(w http.ResponseWriter, r *http.Request) {
+		if err := handler.MustExtractSession(r).Save(r, w); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+	}
+	withCookiesFrom := func(recorder *httptest.ResponseRecorder) *http.Request {
+		r := httptest.NewRequest("", "/", nil)
+		r.Header.Set("X-Request-ID", "req")
+		for _, c := range recorder.Result().Cookies() {
+			r.AddCookie(c)
+		}
+		return r
+	}
+
+	recorder := serve(httptest.NewRequest("", "/", nil), save)
+	var id string
+	recorder = serve(withCookiesFrom(recorder), func(w http.ResponseWriter, r *http.Request) {
+		session := handler.MustExtractSession(r)
+		id = session.ID
+		handler.SetPrincipal(session, "alice")
+		session.ID = ""
+		save(w, r)
+	})
+	serve(withCookiesFrom(recorder), func(w http.ResponseWriter, r *http.Request) {
+		session := handler.MustExtractSession(r)
+		session.Options.MaxAge = -1
+		save(w, r)
+	})
+
+	want := []handler.AuditEventKind{
+		handler.AuditSessionCreated,
+		handler.AuditSessionLoaded,
+		handler.AuditSessionRotated,
+		handler.AuditPrincipalAttached,
+		handler.AuditSessionLoaded,
+		handler.AuditSessionDestroyed,
+	}
+	if got := sink.kinds(); !reflect.DeepEqual(got, want) {
+		t.Fatalf("event kinds: got %v, want %v", got, want)
+	}
+	rotated := sink.events[2]
+	if len(rotated.PreviousSessionRef) == 0 || rotated.PreviousSessionRef == rotated.SessionRef {
+		t.Errorf("rotation refs: previous %q, current %q", rotated.PreviousSessionRef, rotated.SessionRef)
+	}
+	if rotated.SessionRef == id || rotated.PreviousSessionRef == id {
+		t.Error("audit event reveals a session ID")
+	}
+	if got, want := rotated.Principal, "alice"; got != want {
+		t.Errorf("principal: got %q, want %q", got, want)
+	}
+	if got, want := rotated.RequestID, "req"; got != want {
+		t.Errorf("request ID: got %q, want %q", got, want)
+	}
+	if destroyed := sink.events[5]; destroyed.SessionRef != rotated.SessionRef {
+		t.Errorf("destroyed session ref: got %q, want %q", destroyed.SessionRef, rotated.SessionRef)
+	}
+}
+
+func TestAuditWithClock(t *testing.T) {
+	now := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	var sink recordingAuditSink
+	handler.WithSession("s", simpleStore{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := handler.MustExtractSession(r)
+		session.Values["k"] = "v"
+		session.Save(r, w)
+	}), nil, handler.Audit(&sink), handler.AuditMutations(&sink, nil), handler.UseClock(handlertest.NewFakeClock(now))).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+
+	if len(sink.events) == 0 {
+		t.Fatal("no events were reported")
+	}
+	for _, e := range sink.events {
+		if !e.Time.Equal(now) {
+			t.Errorf("time of %s event: got %v, want %v", e.Kind, e.Time, now)
+		}
+	}
+}
+
+func TestAuditMutationsPanicsWithNoSink(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.AuditMutations(nil, nil)
+}
+
+func TestAuditMutations(t *testing.T) {
+	var sink recordingAuditSink
+	key := []byte("key")
+	var first, second []handler.AuditKeyChange
+	handler.WithSession("s", simpleStore{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := handler.MustExtractSession(r)
+		session.Values["a"] = "1"
+		session.Values["b"] = map[string]int{"x": 1, "y": 2}
+		session.Save(r, w)
+		if len(sink.events) == 1 {
+			first = sink.events[0].Changes
+		}
+		session.Values["a"] = "2"
+		delete(session.Values, "b")
+		session.Save(r, w)
+		session.Save(r, w)
+		if len(sink.events) == 2 {
+			second = sink.events[1].Changes
+		}
+	}), nil, handler.AuditMutations(&sink, key)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+
+	if got, want := len(sink.events), 2; got != want {
+		t.Fatalf("event count: got %d, want %d", got, want)
+	}
+	if len(first) != 2 || first[0].Key != "a" || first[1].Key != "b" || len(first[0].OldHash) != 0 || len(first[0].NewHash) == 0 {
+		t.Errorf("first changes: got %+v", first)
+	}
+	if len(second) != 2 || second[0].OldHash != first[0].NewHash || second[0].NewHash == second[0].OldHash || len(second[1].NewHash) != 0 {
+		t.Errorf("second changes: got %+v", second)
+	}
+	for _, c := range append(first, second...) {
+		if c.OldHash == "1" || c.NewHash == "1" || c.NewHash == "2" {
+			t.Errorf("change reveals a value: %+v", c)
+		}
+	}
+}
+
+func TestAuditMutationsWithKeysPrintedAlike(t *testing.T) {
+	var sink recordingAuditSink
+	handler.WithSession("s", simpleStore{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := handler.MustExtractSession(r)
+		session.Values[1] = "a"
+		session.Values["1"] = "a"
+		session.Save(r, w)
+		session.Values[1] = "b"
+		session.Save(r, w)
+	}), nil, handler.AuditMutations(&sink, []byte("key"))).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+
+	if got, want := len(sink.events), 2; got != want {
+		t.Fatalf("event count: got %d, want %d", got, want)
+	}
+	if first := sink.events[0].Changes; len(first) != 2 || first[0].Key != "1" || first[1].Key != "1" {
+		t.Errorf("first changes: got %+v, want both keys added", first)
+	}
+	if second := sink.events[1].Changes; len(second) != 1 || len(second[0].OldHash) == 0 || len(second[0].NewHash) == 0 {
+		t.Errorf("second changes: got %+v, want one key changed", second)
+	}
+}
@@ -0,0 +1,96 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"net/http"
+	"strings"
+)
+
+// CredentialValidator checks the user name and password submitted with a request, returning the
+// principal that they identify, together with a boolean indicating whether they're valid.
+type CredentialValidator func(r *http.Request, user, password string) (principal string, ok bool)
+
+// CredentialsEqual reports whether the given credential matches the expected one, taking time
+// independent of their content and lengths, so that callers can compare secrets without revealing
+// how much of a guess was correct.
+func CredentialsEqual(given, expected string) bool {
+	g, e := sha256.Sum256([]byte(given)), sha256.Sum256([]byte(expected))
+	return subtle.ConstantTimeCompare(g[:], e[:]) == 1
+}
+
+// StaticCredentials returns a CredentialValidator that accepts the passwords in the supplied map,
+// keyed by user name, using the user name as the principal.
+func StaticCredentials(passwords map[string]string) CredentialValidator {
+	// Copy the map, so that later changes by the caller don't race with validation.
+	m := make(map[string]string, len(passwords))
+	for user, password := range passwords {
+		m[user] = password
+	}
+	return func(_ *http.Request, user, password string) (string, bool) {
+		expected, ok := m[user]
+		// Compare even for unknown users, so as not to reveal which user names exist.
+		if !CredentialsEqual(password, expected) || !ok {
+			return "", false
+		}
+		return user, true
+	}
+}
+
+func quoteRealm(realm string) string {
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(realm) + `"`
+}
+
+// WithBasicAuth returns an HTTP handler that authenticates requests using HTTP basic
+// authentication, checking the submitted credentials with the supplied CredentialValidator. It
+// binds the principal that the credentials identify to the request via BindPrincipal before
+// delegating further request processing to the supplied handler, which can retrieve it with
+// ExtractPrincipal. It responds to requests bearing no credentials or invalid ones with HTTP status
+// code 401 and a challenge naming the given realm.
+//
+// If a singular session is bound to the request via WithSession, it records the principal in the
+// session with SetPrincipal and saves the session under a fresh ID, so that subsequent requests in
+// the session skip validating the credentials again; it accepts requests whose session already
+// has a principal without checking their credentials at all. If saving the session fails, it
+// responds with HTTP status code 500 with no body. It panics if either the supplied
+// CredentialValidator or handler is nil.
+func WithBasicAuth(validate CredentialValidator, realm string, h http.Handler) http.Handler {
+	if validate == nil {
+		panic("no credential validator supplied")
+	}
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	challenge := "Basic realm=" + quoteRealm(realm) + `, charset="UTF-8"`
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, bound := ExtractSession(r)
+		if bound {
+			if principal, ok := SessionPrincipal(session); ok {
+				h.ServeHTTP(w, BindPrincipal(r, principal))
+				return
+			}
+		}
+		user, password, ok := r.BasicAuth()
+		if ok {
+			var principal string
+			if principal, ok = validate(r, user, password); ok && len(principal) != 0 {
+				if bound {
+					SetPrincipal(session, principal)
+					// Rotate the session ID upon authentication, defeating session fixation.
+					session.ID = ""
+					if err := session.Save(r, w); err != nil {
+						sendDefaultResponse(w)
+						return
+					}
+				}
+				h.ServeHTTP(w, BindPrincipal(r, principal))
+				return
+			}
+		}
+		w.Header().Set("WWW-Authenticate", challenge)
+		w.WriteHeader(http.StatusUnauthorized)
+	})
+}
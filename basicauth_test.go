@@ -0,0 +1,115 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestWithBasicAuthPanicsWithNoValidator(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithBasicAuth(nil, "app", http.NotFoundHandler())
+}
+
+func TestWithBasicAuthPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithBasicAuth(handler.StaticCredentials(nil), "app", nil)
+}
+
+func TestCredentialsEqual(t *testing.T) {
+	if !handler.CredentialsEqual("s3cret", "s3cret") {
+		t.Error("equal credentials compared unequal")
+	}
+	for _, given := range []string{"", "s3cre", "s3cret!", "S3cret"} {
+		if handler.CredentialsEqual(given, "s3cret") {
+			t.Errorf("credential %q compared equal to %q", given, "s3cret")
+		}
+	}
+}
+
+func TestWithBasicAuth(t *testing.T) {
+	validations := 0
+	validate := handler.StaticCredentials(map[string]string{"alice": "s3cret"})
+	h := handler.WithBasicAuth(func(r *http.Request, user, password string) (string, bool) {
+		validations++
+		return validate(r, user, password)
+	}, `the "app"`, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := handler.ExtractPrincipal(r)
+		w.Write([]byte(p))
+	}))
+	tests := []struct {
+		description    string
+		user, password string
+		want           int
+	}{
+		{"no credentials", "", "", http.StatusUnauthorized},
+		{"wrong password", "alice", "guess", http.StatusUnauthorized},
+		{"unknown user", "bob", "s3cret", http.StatusUnauthorized},
+		{"valid credentials", "alice", "s3cret", http.StatusOK},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if len(test.user) != 0 {
+				r.SetBasicAuth(test.user, test.password)
+			}
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, r)
+			if recorder.Code != test.want {
+				t.Fatalf("status: got %d, want %d", recorder.Code, test.want)
+			}
+			if test.want == http.StatusUnauthorized {
+				if got, want := recorder.Header().Get("WWW-Authenticate"), `Basic realm="the \"app\"", charset="UTF-8"`; got != want {
+					t.Errorf("challenge: got %q, want %q", got, want)
+				}
+				return
+			}
+			if got := recorder.Body.String(); got != test.user {
+				t.Errorf("principal: got %q, want %q", got, test.user)
+			}
+		})
+	}
+	if validations != 3 {
+		t.Errorf("validations: got %d, want 3", validations)
+	}
+}
+
+func TestWithBasicAuthEstablishesSession(t *testing.T) {
+	validations := 0
+	validate := handler.StaticCredentials(map[string]string{"alice": "s3cret"})
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	h := handler.WithSession("s", store, handler.WithBasicAuth(func(r *http.Request, user, password string) (string, bool) {
+		validations++
+		return validate(r, user, password)
+	}, "app", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := handler.ExtractPrincipal(r)
+		w.Write([]byte(p))
+	})), nil)
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.SetBasicAuth("alice", "s3cret")
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	cookies := recorder.Result().Cookies()
+	if recorder.Code != http.StatusOK || len(cookies) != 1 {
+		t.Fatalf("first request: got status %d with %d cookies, want %d with 1", recorder.Code, len(cookies), http.StatusOK)
+	}
+
+	r = httptest.NewRequest(http.MethodGet, "/", nil)
+	r.AddCookie(cookies[0])
+	recorder = httptest.NewRecorder()
+	h.ServeHTTP(recorder, r)
+	if recorder.Code != http.StatusOK || recorder.Body.String() != "alice" {
+		t.Errorf("second request: got status %d with principal %q, want %d with %q", recorder.Code, recorder.Body.String(), http.StatusOK, "alice")
+	}
+	if validations != 1 {
+		t.Errorf("validations: got %d, want 1", validations)
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"errors"
+	"io"
+	"net/http"
+)
+
+// limitedBody is a request body that notes when a handler attempted to read beyond its limit.
+type limitedBody struct {
+	io.ReadCloser
+	exceeded *bool
+}
+
+func (b limitedBody) Read(p []byte) (int, error) {
+	n, err := b.ReadCloser.Read(p)
+	var tooLarge *http.MaxBytesError
+	if errors.As(err, &tooLarge) {
+		*b.exceeded = true
+	}
+	return n, err
+}
+
+// bodyLimitWriter is an http.ResponseWriter that diverts the response to a handler for requests
+// whose bodies were too large, provided that the handler that read the body hadn't yet begun its
+// own response by then.
+type bodyLimitWriter struct {
+	http.ResponseWriter
+	r          *http.Request
+	onTooLarge http.Handler
+	// header holds the response header fields set before delegating to the handler.
+	header   http.Header
+	exceeded bool
+	started  bool
+	diverted bool
+}
+
+func (w *bodyLimitWriter) divert() {
+	w.diverted = true
+	// Discard the header fields that the handler set for its own response, such as its
+	// Content-Type or a session's cookie.
+	h := w.ResponseWriter.Header()
+	for k := range h {
+		delete(h, k)
+	}
+	for k, vs := range w.header {
+		h[k] = vs
+	}
+	w.onTooLarge.ServeHTTP(w.ResponseWriter, w.r)
+}
+
+func (w *bodyLimitWriter) WriteHeader(code int) {
+	if !w.started {
+		w.started = true
+		if w.exceeded {
+			w.divert()
+			return
+		}
+	}
+	if !w.diverted {
+		w.ResponseWriter.WriteHeader(code)
+	}
+}
+
+func (w *bodyLimitWriter) Write(b []byte) (int, error) {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.diverted {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, flushing the underlying http.ResponseWriter if it supports doing
+// so.
+func (w *bodyLimitWriter) Flush() {
+	if !w.started {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.diverted {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for use by http.ResponseController.
+func (w *bodyLimitWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// WithBodyLimit returns an HTTP handler that limits the size of request bodies to maxBytes before
+// delegating further request processing to the supplied handler. It rejects requests declaring a
+// larger Content-Length without delegating them at all. For other requests, it limits the body per
+// http.MaxBytesReader, so that reading beyond the limit fails with an *http.MaxBytesError, and if
+// the supplied handler hasn't begun its response once reading fails, it discards the handler's
+// response, including the header fields it set, in favor of rejecting the request.
+//
+// It delegates rejected requests to the onTooLarge handler, and adds one to the rejected Counter,
+// if supplied, for each. If no such onTooLarge handler is supplied, it will respond with HTTP
+// status code 413 with no body. It panics if maxBytes is negative or the supplied handler is nil.
+func WithBodyLimit(maxBytes int64, h http.Handler, onTooLarge http.Handler, rejected Counter) http.Handler {
+	if maxBytes < 0 {
+		panic("body size limit must not be negative")
+	}
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	if onTooLarge == nil {
+		onTooLarge = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusRequestEntityTooLarge)
+		})
+	}
+	reject := func(w http.ResponseWriter, r *http.Request) {
+		if rejected != nil {
+			rejected.Add(1)
+		}
+		onTooLarge.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ContentLength > maxBytes {
+			reject(w, r)
+			return
+		}
+		if r.Body == nil || r.Body == http.NoBody {
+			h.ServeHTTP(w, r)
+			return
+		}
+		lw := &bodyLimitWriter{ResponseWriter: w, r: r, onTooLarge: http.HandlerFunc(reject), header: w.Header().Clone()}
+		limited := r.WithContext(r.Context())
+		limited.Body = limitedBody{http.MaxBytesReader(w, r.Body, maxBytes), &lw.exceeded}
+		h.ServeHTTP(lw, limited)
+		if lw.exceeded && !lw.started {
+			lw.divert()
+		}
+	})
+}
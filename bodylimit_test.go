@@ -0,0 +1,93 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/seh/handler"
+)
+
+func TestWithBodyLimitPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithBodyLimit(10, nil, nil, nil)
+}
+
+func TestWithBodyLimitPanicsWithNegativeLimit(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithBodyLimit(-1, http.NotFoundHandler(), nil, nil)
+}
+
+func TestWithBodyLimit(t *testing.T) {
+	var rejected int64
+	counter := handler.CounterFunc(func(delta int64) { rejected += delta })
+	h := handler.WithBodyLimit(10, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b, err := ioutil.ReadAll(r.Body)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		w.Write(b)
+	}), nil, counter)
+	tests := []struct {
+		description   string
+		body          io.Reader
+		contentLength int64
+		want          int
+	}{
+		{"no body", nil, 0, http.StatusOK},
+		{"small body", strings.NewReader("hello"), 5, http.StatusOK},
+		{"body at limit", strings.NewReader("0123456789"), 10, http.StatusOK},
+		{"declared too large", strings.NewReader("0123456789a"), 11, http.StatusRequestEntityTooLarge},
+		{"undeclared too large", ioutil.NopCloser(strings.NewReader("0123456789a")), -1, http.StatusRequestEntityTooLarge},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodPost, "/", test.body)
+			r.ContentLength = test.contentLength
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, r)
+			if recorder.Code != test.want {
+				t.Errorf("status: got %d, want %d", recorder.Code, test.want)
+			}
+			if test.want != http.StatusOK && recorder.Body.Len() != 0 {
+				t.Errorf("body: got %q, want none", recorder.Body.String())
+			}
+		})
+	}
+	if rejected != 2 {
+		t.Errorf("rejections: got %d, want 2", rejected)
+	}
+}
+
+func TestWithBodyLimitDiscardsHandlerHeader(t *testing.T) {
+	h := handler.WithBodyLimit(10, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		http.SetCookie(w, &http.Cookie{Name: "s", Value: "v"})
+		if _, err := ioutil.ReadAll(r.Body); err != nil {
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	}), nil, nil)
+	r := httptest.NewRequest(http.MethodPost, "/", ioutil.NopCloser(strings.NewReader("0123456789a")))
+	r.ContentLength = -1
+	recorder := httptest.NewRecorder()
+	recorder.Header().Set("X-Frame-Options", "DENY")
+	h.ServeHTTP(recorder, r)
+	if got, want := recorder.Code, http.StatusRequestEntityTooLarge; got != want {
+		t.Errorf("status: got %d, want %d", got, want)
+	}
+	header := recorder.Header()
+	if len(header.Get("Content-Type")) != 0 || len(header.Get("Set-Cookie")) != 0 {
+		t.Errorf("header: got %v, want none of the handler's fields", header)
+	}
+	if got, want := header.Get("X-Frame-Options"), "DENY"; got != want {
+		t.Errorf("header field set beforehand: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,127 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// emitsCookie reports whether the response header sets a cookie with the given name.
+func emitsCookie(h http.Header, name string) bool {
+	prefix := name + "="
+	for _, v := range h["Set-Cookie"] {
+		if strings.HasPrefix(v, prefix) {
+			return true
+		}
+	}
+	return false
+}
+
+// personalizes reports whether a response bearing the given header may depend on the bound
+// session, either because the session carries values or because the response sets its cookie.
+func personalizes(h http.Header, s *sessions.Session) bool {
+	return !s.IsNew || len(s.Values) != 0 || emitsCookie(h, s.Name())
+}
+
+// mergeCacheControl returns the value of a Cache-Control header that combines the directives in
+// the current value with those supplied, dropping any "public" directive.
+func mergeCacheControl(current string, directives ...string) string {
+	var merged []string
+	present := make(map[string]bool)
+	for _, d := range strings.Split(current, ",") {
+		d = strings.TrimSpace(d)
+		name := strings.ToLower(d)
+		if i := strings.IndexByte(name, '='); i >= 0 {
+			name = name[:i]
+		}
+		if len(d) == 0 || name == "public" {
+			continue
+		}
+		present[name] = true
+		merged = append(merged, d)
+	}
+	for _, d := range directives {
+		if !present[d] {
+			merged = append(merged, d)
+		}
+	}
+	return strings.Join(merged, ", ")
+}
+
+// PrivateCaching returns an Option that marks responses that may depend on a bound session as
+// unsuitable for shared caches, by adding the "private" directive to their Cache-Control header
+// and removing any "public" directive. It considers a response to depend on a session if the
+// session carried stored values when bound or gained values since, or if the response sets the
+// session's cookie. If such a session bears one of the given sensitive names, it adds the
+// "no-store" directive as well, barring all caches from retaining the response.
+//
+// Directives that the handler set in the Cache-Control header otherwise remain intact.
+func PrivateCaching(sensitiveNames ...string) Option {
+	sensitive := make(map[string]bool, len(sensitiveNames))
+	for _, name := range sensitiveNames {
+		sensitive[name] = true
+	}
+	return func(c *bindingConfig) {
+		c.responseHooks = append(c.responseHooks, func(h http.Header, _ *http.Request, bound []*sessions.Session) {
+			private, noStore := false, false
+			for _, s := range bound {
+				if personalizes(h, s) {
+					private = true
+					if sensitive[s.Name()] {
+						noStore = true
+						break
+					}
+				}
+			}
+			switch {
+			case noStore:
+				h.Set("Cache-Control", mergeCacheControl(h.Get("Cache-Control"), "private", "no-store"))
+			case private:
+				h.Set("Cache-Control", mergeCacheControl(h.Get("Cache-Control"), "private"))
+			}
+		})
+	}
+}
+
+// AddVary adds the given field names to the response header's Vary field, omitting any already
+// present there, compared without regard to case. If Vary already contains "*", it leaves it
+// intact.
+func AddVary(h http.Header, fields ...string) {
+	present := make(map[string]bool)
+	for _, v := range h["Vary"] {
+		for _, f := range strings.Split(v, ",") {
+			present[strings.ToLower(strings.TrimSpace(f))] = true
+		}
+	}
+	if present["*"] {
+		return
+	}
+	for _, f := range fields {
+		if lower := strings.ToLower(f); !present[lower] {
+			present[lower] = true
+			h.Add("Vary", f)
+		}
+	}
+}
+
+// VaryOnCookie returns an Option that adds "Cookie" to the Vary header of responses that may depend
+// on a bound session, as PrivateCaching judges it, so that caches keyed by URL alone don't serve
+// one client's response to another. Any additional field names supplied, such as "Authorization",
+// are added along with it.
+func VaryOnCookie(fields ...string) Option {
+	fields = append([]string{"Cookie"}, fields...)
+	return func(c *bindingConfig) {
+		c.responseHooks = append(c.responseHooks, func(h http.Header, _ *http.Request, bound []*sessions.Session) {
+			for _, s := range bound {
+				if personalizes(h, s) {
+					AddVary(h, fields...)
+					return
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestPrivateCaching(t *testing.T) {
+	tests := []struct {
+		description string
+		names       []string
+		handle      func(w http.ResponseWriter, r *http.Request)
+		want        string
+	}{
+		{"untouched", []string{"s"}, func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Cache-Control", "public, max-age=60")
+		}, "public, max-age=60"},
+		{"untouched without writing", []string{"s"}, func(w http.ResponseWriter, r *http.Request) {}, ""},
+		{"values", []string{"s"}, func(w http.ResponseWriter, r *http.Request) {
+			handler.MustExtractSessionNamed("s", r).Values["k"] = "v"
+			w.Header().Set("Cache-Control", "public, max-age=60")
+			w.Write(nil)
+		}, "max-age=60, private"},
+		{"values without writing", []string{"s"}, func(w http.ResponseWriter, r *http.Request) {
+			handler.MustExtractSessionNamed("s", r).Values["k"] = "v"
+		}, "private"},
+		{"cookie", []string{"s", "t"}, func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, sessions.NewCookie("t", "v", &sessions.Options{}))
+			w.WriteHeader(http.StatusNoContent)
+		}, "private"},
+		{"other cookie", []string{"s"}, func(w http.ResponseWriter, r *http.Request) {
+			http.SetCookie(w, sessions.NewCookie("other", "v", &sessions.Options{}))
+			w.WriteHeader(http.StatusNoContent)
+		}, ""},
+		{"sensitive", []string{"s", "auth"}, func(w http.ResponseWriter, r *http.Request) {
+			handler.MustExtractSessionNamed("auth", r).Values["k"] = "v"
+			w.Header().Set("Cache-Control", "private")
+			w.Write(nil)
+		}, "private, no-store"},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			h := handler.WithSessionsNamed(test.names, handler.NopSource{}, http.HandlerFunc(test.handle), nil, handler.PrivateCaching("auth"))
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+			if got := recorder.Header().Get("Cache-Control"); got != test.want {
+				t.Errorf("Cache-Control: got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPrivateCachingWithSingleSession(t *testing.T) {
+	h := handler.WithSession("s", handler.NopSource{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.MustExtractSession(r).Values["k"] = "v"
+		w.Write([]byte("body"))
+	}), nil, handler.PrivateCaching())
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+	if got, want := recorder.Header().Get("Cache-Control"), "private"; got != want {
+		t.Errorf("Cache-Control: got %q, want %q", got, want)
+	}
+}
+
+func TestAddVary(t *testing.T) {
+	tests := []struct {
+		description string
+		current     []string
+		fields      []string
+		want        []string
+	}{
+		{"empty", nil, []string{"Cookie"}, []string{"Cookie"}},
+		{"present", []string{"Accept-Encoding, cookie"}, []string{"Cookie", "Authorization"}, []string{"Accept-Encoding, cookie", "Authorization"}},
+		{"duplicates", nil, []string{"Cookie", "cookie"}, []string{"Cookie"}},
+		{"wildcard", []string{"*"}, []string{"Cookie"}, []string{"*"}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			h := http.Header{}
+			for _, v := range test.current {
+				h.Add("Vary", v)
+			}
+			handler.AddVary(h, test.fields...)
+			if got := h["Vary"]; !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Vary: got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestVaryOnCookie(t *testing.T) {
+	for _, personalized := range []bool{false, true} {
+		h := handler.WithSession("s", handler.NopSource{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if personalized {
+				handler.MustExtractSession(r).Values["k"] = "v"
+			}
+			w.Header().Set("Vary", "Accept-Encoding")
+		}), nil, handler.VaryOnCookie("Authorization"))
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+		want := []string{"Accept-Encoding"}
+		if personalized {
+			want = append(want, "Cookie", "Authorization")
+		}
+		if got := recorder.Header()["Vary"]; !reflect.DeepEqual(got, want) {
+			t.Errorf("Vary (personalized: %t): got %q, want %q", personalized, got, want)
+		}
+	}
+}
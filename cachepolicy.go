@@ -0,0 +1,145 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CacheScope governs which caches may store a response.
+type CacheScope int
+
+const (
+	// CacheDefault leaves the scope to the caches' defaults, sending neither "public" nor
+	// "private".
+	CacheDefault CacheScope = iota
+	// CachePublic permits shared caches to store the response, even if it would otherwise be
+	// uncacheable, such as for requests bearing an Authorization header.
+	CachePublic
+	// CachePrivate permits only the user's own browser to store the response.
+	CachePrivate
+	// CacheNone forbids all caches from storing the response.
+	CacheNone
+)
+
+// CachePolicy describes how caches may store and reuse responses, as WithCachePolicy sends it in
+// the Cache-Control and Expires headers.
+type CachePolicy struct {
+	// Scope governs which caches may store the response.
+	Scope CacheScope
+	// MaxAge, if positive, is how long caches may reuse the response without revalidating it. It
+	// also determines the Expires header.
+	MaxAge time.Duration
+	// SharedMaxAge, if positive, overrides MaxAge for shared caches.
+	SharedMaxAge time.Duration
+	// NoCache requires caches to revalidate the response before each reuse.
+	NoCache bool
+	// MustRevalidate forbids caches from reusing the response once it's stale, even when they
+	// can't reach the server.
+	MustRevalidate bool
+	// Immutable promises that the response won't change while fresh, so browsers needn't
+	// revalidate it even when the user reloads the page.
+	Immutable bool
+	// StaleWhileRevalidate, if positive, is how long caches may reuse the response after it goes
+	// stale while revalidating it in the background.
+	StaleWhileRevalidate time.Duration
+	// NoStoreWithSession makes responses that set a cookie, such as those that save a session,
+	// forbid all caches from storing them, rather than only shared caches.
+	NoStoreWithSession bool
+	// Clock reports the current time, used to compute the Expires header. If nil, WithCachePolicy
+	// uses SystemClock.
+	Clock Clock
+}
+
+func seconds(d time.Duration) string {
+	return strconv.FormatInt(int64(d/time.Second), 10)
+}
+
+// directives returns the Cache-Control directives for the policy, with the given scope.
+func (p CachePolicy) directives(scope CacheScope) []string {
+	if scope == CacheNone {
+		return []string{"no-store"}
+	}
+	var d []string
+	switch scope {
+	case CachePublic:
+		d = append(d, "public")
+	case CachePrivate:
+		d = append(d, "private")
+	}
+	if p.NoCache {
+		d = append(d, "no-cache")
+	}
+	if p.MaxAge > 0 {
+		d = append(d, "max-age="+seconds(p.MaxAge))
+	}
+	if p.SharedMaxAge > 0 && scope != CachePrivate {
+		d = append(d, "s-maxage="+seconds(p.SharedMaxAge))
+	}
+	if p.MustRevalidate {
+		d = append(d, "must-revalidate")
+	}
+	if p.Immutable {
+		d = append(d, "immutable")
+	}
+	if p.StaleWhileRevalidate > 0 {
+		d = append(d, "stale-while-revalidate="+seconds(p.StaleWhileRevalidate))
+	}
+	return d
+}
+
+// WithCachePolicy returns an HTTP handler that sets the Cache-Control and Expires headers of
+// responses from the supplied handler per the CachePolicy, just before the response header is
+// written, unless the handler set the Cache-Control header itself. Apply a different policy to each
+// route as befits its content.
+//
+// Responses that set a cookie, such as those saving a session, carry a credential that no shared
+// cache may hand to another user, so it restricts them to private caches regardless of the policy
+// or any Cache-Control header set by the handler, and with NoStoreWithSession, forbids caching them
+// at all. To see cookies set by the AutoSave Option, it must enclose WithSession. It panics if the
+// supplied handler is nil.
+func WithCachePolicy(p CachePolicy, h http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	clock := clockOrSystem(p.Clock)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		hw := &hookedResponseWriter{
+			ResponseWriter: w,
+			beforeHeader: func(header http.Header) {
+				setsCookie := len(header["Set-Cookie"]) != 0
+				if current := header.Get("Cache-Control"); len(current) != 0 {
+					switch {
+					case setsCookie && p.NoStoreWithSession:
+						header.Set("Cache-Control", mergeCacheControl(current, "private", "no-store"))
+					case setsCookie:
+						header.Set("Cache-Control", mergeCacheControl(current, "private"))
+					}
+					return
+				}
+				scope := p.Scope
+				if setsCookie && scope != CacheNone {
+					scope = CachePrivate
+					if p.NoStoreWithSession {
+						scope = CacheNone
+					}
+				}
+				if d := p.directives(scope); len(d) != 0 {
+					header.Set("Cache-Control", strings.Join(d, ", "))
+				}
+				switch {
+				case scope == CacheNone || p.NoCache:
+					header.Set("Expires", "0")
+				case p.MaxAge > 0:
+					header.Set("Expires", clock.Now().Add(p.MaxAge).UTC().Format(http.TimeFormat))
+				}
+			},
+		}
+		h.ServeHTTP(hw, r)
+		hw.finish()
+	})
+}
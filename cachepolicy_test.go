@@ -0,0 +1,66 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+)
+
+func TestWithCachePolicyPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithCachePolicy(handler.CachePolicy{}, nil)
+}
+
+func TestWithCachePolicy(t *testing.T) {
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	assets := handler.CachePolicy{Scope: handler.CachePublic, MaxAge: time.Hour, SharedMaxAge: 2 * time.Hour, Immutable: true, Clock: clock}
+	strict := assets
+	strict.NoStoreWithSession = true
+	tests := []struct {
+		description  string
+		policy       handler.CachePolicy
+		cacheControl string
+		saveSession  bool
+		wantControl  string
+		wantExpires  string
+	}{
+		{"public", assets, "", false, "public, max-age=3600, s-maxage=7200, immutable", "Sun, 01 Jan 2017 01:00:00 GMT"},
+		{"public with session", assets, "", true, "private, max-age=3600, immutable", "Sun, 01 Jan 2017 01:00:00 GMT"},
+		{"public with session and no-store", strict, "", true, "no-store", "0"},
+		{"none", handler.CachePolicy{Scope: handler.CacheNone}, "", false, "no-store", "0"},
+		{"revalidated", handler.CachePolicy{Scope: handler.CachePrivate, NoCache: true, MustRevalidate: true}, "", false, "private, no-cache, must-revalidate", "0"},
+		{"set by handler", assets, "public, max-age=60", false, "public, max-age=60", ""},
+		{"set by handler with session", assets, "public, max-age=60", true, "max-age=60, private", ""},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			h := handler.WithCachePolicy(test.policy, handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if len(test.cacheControl) != 0 {
+					w.Header().Set("Cache-Control", test.cacheControl)
+				}
+				if test.saveSession {
+					handler.MustExtractSession(r).Values["uid"] = "alice"
+				}
+				w.Write([]byte("content"))
+			}), nil, handler.AutoSave(nil)))
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			if got := recorder.Header().Get("Cache-Control"); got != test.wantControl {
+				t.Errorf("Cache-Control: got %q, want %q", got, test.wantControl)
+			}
+			if got := recorder.Header().Get("Expires"); got != test.wantExpires {
+				t.Errorf("Expires: got %q, want %q", got, test.wantExpires)
+			}
+		})
+	}
+}
@@ -0,0 +1,117 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// CanaryKey is the session value key under which the Canary Option plants its canary value.
+const CanaryKey = "handler.canary"
+
+// canaryNonceSize is the number of random bytes in each canary's nonce.
+const canaryNonceSize = 16
+
+// canaryMAC derives the MAC binding a canary's nonce to the name of the session bearing it.
+func canaryMAC(key []byte, name string, nonce []byte) []byte {
+	mac := hmac.New(sha256.New, key)
+	fmt.Fprintf(mac, "%q\n", name)
+	mac.Write(nonce)
+	return mac.Sum(nil)
+}
+
+// newCanary returns a canary value for the named session, bearing a fresh random nonce.
+func newCanary(key []byte, name string) string {
+	nonce := securecookie.GenerateRandomKey(canaryNonceSize)
+	return base64.RawURLEncoding.EncodeToString(nonce) + "." + base64.RawURLEncoding.EncodeToString(canaryMAC(key, name, nonce))
+}
+
+// hasValidCanary reports whether the session bears a canary value derived from the given key for a
+// session with its name.
+func hasValidCanary(key []byte, s *sessions.Session) bool {
+	v, ok := s.Values[CanaryKey].(string)
+	if !ok {
+		return false
+	}
+	i := strings.IndexByte(v, '.')
+	if i < 0 {
+		return false
+	}
+	nonce, err := base64.RawURLEncoding.DecodeString(v[:i])
+	if err != nil {
+		return false
+	}
+	mac, err := base64.RawURLEncoding.DecodeString(v[i+1:])
+	if err != nil {
+		return false
+	}
+	return hmac.Equal(mac, canaryMAC(key, s.Name(), nonce))
+}
+
+// canaryStore is a sessions.Store that plants a canary value in each session lacking a valid one
+// before saving it.
+type canaryStore struct {
+	sessions.Store
+	key []byte
+}
+
+func (c canaryStore) unwrapStore() sessions.Store {
+	return c.Store
+}
+
+func (c canaryStore) Save(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	if s.Values == nil {
+		s.Values = make(map[interface{}]interface{})
+	}
+	if !hasValidCanary(c.key, s) {
+		s.Values[CanaryKey] = newCanary(c.key, s.Name())
+	}
+	return c.Store.Save(r, w, s)
+}
+
+// Canary returns an Option that plants a canary value, derived from the given secret key, a random
+// nonce, and the session's name, in each bound session lacking one when saving it, and verifies
+// that value when binding a previously saved session to a request. A session that decodes
+// successfully but lacks a valid canary value—such as one forged by a party holding the store's
+// cookie keys but not this secret, one saved under another session name, or one corrupted in
+// storage—is reported to the onTamper function, if supplied, and then treated as invalid: its
+// values are discarded, and it's bound as a fresh session.
+//
+// The canary doesn't cover the session's other values, so that serializers that decode values with
+// other types, such as JSON ones, and Options that adjust values outside of saving, such as
+// RefreshNearExpiry, DetectLocationChange, Project, and Compact, don't spoil it. Hence a party that
+// can forge sessions and has obtained a valid canary, such as from its own session, can copy it
+// into a forged session with the same name.
+//
+// Enabling this Option invalidates all sessions saved without it. It panics if the key is empty.
+func Canary(key []byte, onTamper func(r *http.Request, s *sessions.Session)) Option {
+	if len(key) == 0 {
+		panic("no canary key supplied")
+	}
+	return func(c *bindingConfig) {
+		c.retainedKeys = append(c.retainedKeys, CanaryKey)
+		c.decorators = append(c.decorators, func(r *http.Request, s *sessions.Session) *sessions.Session {
+			if !s.IsNew && !hasValidCanary(key, s) {
+				if onTamper != nil {
+					onTamper(r, s)
+				}
+				s.Values = make(map[interface{}]interface{})
+				s.ID = ""
+				s.IsNew = true
+			}
+			if s.Store() == nil {
+				return s
+			}
+			return rebindSession(s, canaryStore{s.Store(), key})
+		})
+	}
+}
@@ -0,0 +1,193 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestCanaryPanicsWithNoKey(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.Canary(nil, nil)
+}
+
+func TestCanary(t *testing.T) {
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	serve := func(r *http.Request, key string, f func(w http.ResponseWriter, r *http.Request)) (*httptest.ResponseRecorder, bool) {
+		tampered := false
+		recorder := httptest.NewRecorder()
+		handler.WithSession("s", store, http.HandlerFunc(f), nil, handler.Canary([]byte(key), func(*http.Request, *sessions.Session) {
+			tampered = true
+		})).ServeHTTP(recorder, r)
+		return recorder, tampered
+	}
+	withCookiesFrom := func(recorder *httptest.ResponseRecorder) *http.Request {
+		r := httptest.NewRequest("", "/", nil)
+		for _, c := range recorder.Result().Cookies() {
+			r.AddCookie(c)
+		}
+		return r
+	}
+
+	recorder, _ := serve(httptest.NewRequest("", "/", nil), "k", func(w http.ResponseWriter, r *http.Request) {
+		session := handler.MustExtractSession(r)
+		session.Values["uid"] = "alice"
+		if err := session.Save(r, w); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+	})
+	if _, tampered := serve(withCookiesFrom(recorder), "k", func(w http.ResponseWriter, r *http.Request) {
+		if got, want := handler.MustExtractSession(r).Values["uid"], "alice"; got != want {
+			t.Errorf("value: got %v, want %v", got, want)
+		}
+	}); tampered {
+		t.Error("intact session was reported as tampered")
+	}
+	if _, tampered := serve(withCookiesFrom(recorder), "other", func(w http.ResponseWriter, r *http.Request) {
+		session := handler.MustExtractSession(r)
+		if !session.IsNew || len(session.Values) != 0 {
+			t.Errorf("session: got %v, want a fresh session", session.Values)
+		}
+	}); !tampered {
+		t.Error("session with a foreign canary was not reported as tampered")
+	}
+
+	// A session bearing a canary copied from a session with another name counts as tampered.
+	var canary interface{}
+	handler.WithSession("t", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := handler.MustExtractSession(r)
+		if err := session.Save(r, w); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+		canary = session.Values[handler.CanaryKey]
+	}), nil, handler.Canary([]byte("k"), nil)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+	if canary == nil {
+		t.Fatal("saved session bears no canary")
+	}
+	r := httptest.NewRequest("", "/", nil)
+	forged, _ := store.New(r, "s")
+	forged.Values["uid"] = "mallory"
+	forged.Values[handler.CanaryKey] = canary
+	recorder = httptest.NewRecorder()
+	forged.Save(r, recorder)
+	if _, tampered := serve(withCookiesFrom(recorder), "k", func(w http.ResponseWriter, r *http.Request) {
+		if uid, ok := handler.MustExtractSession(r).Values["uid"]; ok {
+			t.Errorf("value: got %v, want none", uid)
+		}
+	}); !tampered {
+		t.Error("session bearing a canary for another session was not reported as tampered")
+	}
+
+	// A session saved without the canary counts as tampered.
+	plain, _ := store.New(r, "s")
+	recorder = httptest.NewRecorder()
+	plain.Save(r, recorder)
+	if _, tampered := serve(withCookiesFrom(recorder), "k", func(http.ResponseWriter, *http.Request) {}); !tampered {
+		t.Error("session lacking a canary was not reported as tampered")
+	}
+}
+
+// signalingKV is a kvstore.KV that signals each write of a value.
+type signalingKV struct {
+	kvstore.KV
+	written chan struct{}
+}
+
+func (kv signalingKV) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	err := kv.KV.Set(ctx, key, value, ttl)
+	select {
+	case kv.written <- struct{}{}:
+	default:
+	}
+	return err
+}
+
+func TestCanaryWithOtherOptions(t *testing.T) {
+	start := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := handlertest.NewFakeClock(start)
+	canary := handler.Canary([]byte("k"), nil)
+	refresh := handler.RefreshNearExpiry(0.25, clock, func(_ *http.Request, _ *sessions.Session, err error) {
+		t.Errorf("failed to refresh session: %v", err)
+	})
+	location := handler.DetectLocationChange(func(r *http.Request) string {
+		return r.Header.Get("X-Location")
+	}, func(*http.Request, *sessions.Session, string, string) {})
+	for _, test := range []struct {
+		description string
+		opts        []handler.Option
+		refreshes   bool
+	}{
+		{"alone", []handler.Option{canary}, false},
+		{"refresh first", []handler.Option{refresh, canary}, true},
+		{"refresh last", []handler.Option{canary, refresh}, true},
+		{"location", []handler.Option{location, canary}, false},
+		{"projection", []handler.Option{handler.Project("uid"), canary}, false},
+		{"compaction", []handler.Option{handler.Compact(clock, nil), canary}, false},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			clock.Set(start)
+			memory := kvstore.NewMemory()
+			memory.Clock = clock
+			kv := signalingKV{memory, make(chan struct{}, 1)}
+			store := kvstore.New(kv, securecookie.GenerateRandomKey(32))
+			store.Serializer = handler.JSONValuesSerializer{}
+			store.Clock = clock
+			store.MaxAge(100)
+			serve := func(r *http.Request, location string, f func(w http.ResponseWriter, r *http.Request)) *httptest.ResponseRecorder {
+				r.Header.Set("X-Location", location)
+				recorder := httptest.NewRecorder()
+				handler.WithSession("s", store, http.HandlerFunc(f), nil, test.opts...).ServeHTTP(recorder, r)
+				return recorder
+			}
+			save := func(w http.ResponseWriter, r *http.Request) {
+				if err := handler.MustExtractSession(r).Save(r, w); err != nil {
+					t.Fatalf("failed to save session: %v", err)
+				}
+				<-kv.written
+			}
+			verify := func(w http.ResponseWriter, r *http.Request) {
+				session := handler.MustExtractSession(r)
+				if session.IsNew {
+					t.Fatal("intact session was discarded")
+				}
+				if got, want := session.Values["uid"], "alice"; got != want {
+					t.Errorf("value: got %v, want %v", got, want)
+				}
+			}
+
+			recorder := serve(httptest.NewRequest("", "/", nil), "home", func(w http.ResponseWriter, r *http.Request) {
+				session := handler.MustExtractSession(r)
+				session.Values["uid"] = "alice"
+				session.Values["since"] = start.Unix()
+				handler.SetExpiring(session, "notice", "welcome", start.Add(10*time.Second))
+				save(w, r)
+			})
+			r := httptest.NewRequest("", "/", nil)
+			for _, c := range recorder.Result().Cookies() {
+				r.AddCookie(c)
+			}
+			// Arrive near expiry, from elsewhere, once the notice has lapsed.
+			clock.Advance(80 * time.Second)
+			serve(r, "away", verify)
+			if test.refreshes {
+				<-kv.written
+			}
+			serve(r, "away", func(w http.ResponseWriter, r *http.Request) {
+				verify(w, r)
+				save(w, r)
+			})
+			serve(r, "home", verify)
+		})
+	}
+}
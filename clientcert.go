@@ -0,0 +1,77 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"crypto/x509"
+	"net/http"
+)
+
+// CertificateResolver maps the verified certificate that a client presented over TLS to the
+// principal that it identifies, together with a boolean indicating whether the certificate
+// identifies any principal acceptable to the application.
+type CertificateResolver func(r *http.Request, cert *x509.Certificate) (principal string, ok bool)
+
+// CertificateCommonName is a CertificateResolver that uses the common name of the certificate's
+// subject as the principal.
+func CertificateCommonName(_ *http.Request, cert *x509.Certificate) (string, bool) {
+	return cert.Subject.CommonName, len(cert.Subject.CommonName) != 0
+}
+
+// verifiedClientCertificate returns the client's leaf certificate, provided that the TLS server
+// verified its chain during the handshake and that it remains valid at the time reported by the
+// given Clock.
+func verifiedClientCertificate(r *http.Request, clock Clock) (*x509.Certificate, bool) {
+	if r.TLS == nil || len(r.TLS.VerifiedChains) == 0 || len(r.TLS.VerifiedChains[0]) == 0 {
+		return nil, false
+	}
+	cert := r.TLS.VerifiedChains[0][0]
+	// A connection may outlive the certificate presented when establishing it.
+	now := clock.Now()
+	if now.Before(cert.NotBefore) || now.After(cert.NotAfter) {
+		return nil, false
+	}
+	return cert, true
+}
+
+// WithClientCert returns an HTTP handler that authenticates requests by the certificate that the
+// client presented over TLS, mapping it to a principal with the supplied CertificateResolver. It
+// binds that principal to the request via BindPrincipal before delegating further request
+// processing to the supplied handler, which can retrieve it with ExtractPrincipal just as it would
+// a principal established with a session.
+//
+// It accepts only certificates that the TLS server verified during the handshake, which requires
+// the server's tls.Config to set ClientAuth to tls.VerifyClientCertIfGiven or
+// tls.RequireAndVerifyClientCert, and that remain valid at the time reported by the supplied Clock,
+// or by SystemClock if the Clock is nil. It delegates requests bearing no such certificate, or one
+// that the CertificateResolver rejects, to the onReject handler. If no such onReject handler is
+// supplied, it will respond with HTTP status code 403 with no body. It panics if either the
+// supplied CertificateResolver or handler is nil.
+func WithClientCert(resolve CertificateResolver, h http.Handler, onReject http.Handler, clock Clock) http.Handler {
+	if resolve == nil {
+		panic("no certificate resolver supplied")
+	}
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	if onReject == nil {
+		onReject = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+	clock = clockOrSystem(clock)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		cert, ok := verifiedClientCertificate(r, clock)
+		if !ok {
+			onReject.ServeHTTP(w, r)
+			return
+		}
+		principal, ok := resolve(r, cert)
+		if !ok || len(principal) == 0 {
+			onReject.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, BindPrincipal(r, principal))
+	})
+}
@@ -0,0 +1,109 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+)
+
+func TestWithClientCertPanicsWithNoResolver(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithClientCert(nil, http.NotFoundHandler(), nil, nil)
+}
+
+func TestWithClientCertPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithClientCert(handler.CertificateCommonName, nil, nil, nil)
+}
+
+func TestWithClientCert(t *testing.T) {
+	start := time.Date(2017, time.March, 1, 12, 0, 0, 0, time.UTC)
+	clock := handlertest.NewFakeClock(start)
+	h := handler.WithClientCert(func(r *http.Request, cert *x509.Certificate) (string, bool) {
+		if cert.Subject.CommonName == "mallory" {
+			return "", false
+		}
+		return handler.CertificateCommonName(r, cert)
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		p, _ := handler.ExtractPrincipal(r)
+		w.Write([]byte(p))
+	}), nil, clock)
+	certificate := func(name string, notAfter time.Time) *x509.Certificate {
+		return &x509.Certificate{
+			Subject:   pkix.Name{CommonName: name},
+			NotBefore: start.Add(-time.Hour),
+			NotAfter:  notAfter,
+		}
+	}
+	valid := start.Add(time.Hour)
+	tests := []struct {
+		description string
+		state       *tls.ConnectionState
+		want        string
+	}{
+		{"plain HTTP", nil, ""},
+		{"no certificate", &tls.ConnectionState{}, ""},
+		{"unverified certificate", &tls.ConnectionState{PeerCertificates: []*x509.Certificate{certificate("alice", valid)}}, ""},
+		{"expired certificate", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate("alice", start.Add(-time.Minute))}}}, ""},
+		{"rejected certificate", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate("mallory", valid)}}}, ""},
+		{"valid certificate", &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{certificate("alice", valid)}}}, "alice"},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.TLS = test.state
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, r)
+			want := http.StatusOK
+			if len(test.want) == 0 {
+				want = http.StatusForbidden
+			}
+			if recorder.Code != want {
+				t.Fatalf("status: got %d, want %d", recorder.Code, want)
+			}
+			if got := recorder.Body.String(); got != test.want {
+				t.Errorf("principal: got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
+
+func TestWithClientCertConsultsClock(t *testing.T) {
+	start := time.Date(2017, time.March, 1, 12, 0, 0, 0, time.UTC)
+	clock := handlertest.NewFakeClock(start)
+	h := handler.WithClientCert(handler.CertificateCommonName, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), nil, clock)
+	cert := &x509.Certificate{
+		Subject:   pkix.Name{CommonName: "alice"},
+		NotBefore: start.Add(time.Minute),
+		NotAfter:  start.Add(time.Hour),
+	}
+	tests := []struct {
+		description string
+		advance     time.Duration
+		want        int
+	}{
+		{"before validity", 0, http.StatusForbidden},
+		{"within validity", 30 * time.Minute, http.StatusOK},
+		{"after validity", time.Hour, http.StatusForbidden},
+	}
+	for _, test := range tests {
+		clock.Advance(test.advance)
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		r.TLS = &tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		if recorder.Code != test.want {
+			t.Errorf("%s: status: got %d, want %d", test.description, recorder.Code, test.want)
+		}
+	}
+}
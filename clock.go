@@ -0,0 +1,24 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import "time"
+
+// Clock reports the current time. Components with time-based behavior, such as session expiry,
+// consult a Clock rather than calling time.Now directly, so that tests can substitute a fake clock.
+type Clock interface {
+	// Now returns the current time.
+	Now() time.Time
+}
+
+// ClockFunc adapts an ordinary function to serve as a Clock.
+type ClockFunc func() time.Time
+
+// Now returns the result of calling f.
+func (f ClockFunc) Now() time.Time {
+	return f()
+}
+
+// SystemClock is a Clock that reports the current time per time.Now.
+var SystemClock Clock = ClockFunc(time.Now)
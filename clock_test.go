@@ -0,0 +1,27 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+func TestClockFunc(t *testing.T) {
+	want := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	c := handler.ClockFunc(func() time.Time { return want })
+	if got := c.Now(); !got.Equal(want) {
+		t.Errorf("time: got %v, want %v", got, want)
+	}
+}
+
+func TestSystemClock(t *testing.T) {
+	before := time.Now()
+	got := handler.SystemClock.Now()
+	if got.Before(before) || got.After(time.Now()) {
+		t.Errorf("time %v is not current", got)
+	}
+}
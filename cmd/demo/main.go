@@ -0,0 +1,196 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+/*
+Command demo serves a small web application that shows how the handler package's pieces fit
+together: sessions bound with WithSession and kept in a kvstore.Store backed by process memory,
+login recording the principal with SetPrincipal, logout via Logout, flash messages, and forms
+protected against cross-site request forgery and duplicate submission with single-use nonces.
+
+Usage:
+
+	demo [-addr host:port] [-user name:password]...
+
+It accepts the credentials given by each -user flag, or "demo:demo" if none are given. Since it
+keeps its sessions and its cookie keys in memory, restarting it signs out every user.
+
+The package's tests drive the same application end to end.
+*/
+package main
+
+import (
+	"flag"
+	"fmt"
+	"html/template"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/kvstore"
+)
+
+const (
+	sessionName = "demo"
+	nonceTTL    = 10 * time.Minute
+)
+
+var page = template.Must(template.New("page").Parse(`<!DOCTYPE html>
+<html>
+<head><title>Session demo</title></head>
+<body>
+{{range .Flashes}}<p class="flash">{{.}}</p>
+{{end}}{{if .Principal}}<p>Signed in as {{.Principal}}.</p>
+<form method="post" action="/logout">
+<input type="hidden" name="nonce" value="{{.Nonce}}">
+<button type="submit">Sign out</button>
+</form>
+{{else if .Login}}<form method="post" action="/login">
+<input type="hidden" name="nonce" value="{{.Nonce}}">
+<label>User <input name="user"></label>
+<label>Password <input name="password" type="password"></label>
+<button type="submit">Sign in</button>
+</form>
+{{else}}<p>Not signed in. <a href="/login">Sign in</a></p>
+{{end}}</body>
+</html>
+`))
+
+type pageData struct {
+	Flashes   []interface{}
+	Principal string
+	Login     bool
+	Nonce     string
+}
+
+// render consumes the session's flash messages and issues a nonce for any form on the page,
+// saving the session before writing the page.
+func render(w http.ResponseWriter, r *http.Request, login bool) {
+	s := handler.MustExtractSession(r)
+	d := pageData{Flashes: s.Flashes(), Login: login}
+	d.Principal, _ = handler.SessionPrincipal(s)
+	if login || len(d.Principal) != 0 {
+		d.Nonce = handler.IssueNonce(s, nonceTTL, nil)
+	}
+	if err := s.Save(r, w); err != nil {
+		http.Error(w, "failed to save session", http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	page.Execute(w, d)
+}
+
+// saveAndRedirect saves the session and redirects to the given path.
+func saveAndRedirect(w http.ResponseWriter, r *http.Request, s *sessions.Session, path string) {
+	if err := s.Save(r, w); err != nil {
+		http.Error(w, "failed to save session", http.StatusInternalServerError)
+		return
+	}
+	http.Redirect(w, r, path, http.StatusSeeOther)
+}
+
+func methods(get, post http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch {
+		case r.Method == http.MethodGet && get != nil:
+			get.ServeHTTP(w, r)
+		case r.Method == http.MethodPost && post != nil:
+			post.ServeHTTP(w, r)
+		default:
+			w.WriteHeader(http.StatusMethodNotAllowed)
+		}
+	})
+}
+
+// newDemo returns the demo application, keeping its sessions in the given store and accepting the
+// given credentials.
+func newDemo(store sessions.Store, validate handler.CredentialValidator) http.Handler {
+	rejectForm := http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+		http.Error(w, "form expired or already submitted", http.StatusConflict)
+	})
+	mux := http.NewServeMux()
+	mux.Handle("/", methods(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/" {
+			http.NotFound(w, r)
+			return
+		}
+		render(w, r, false)
+	}), nil))
+	mux.Handle("/login", methods(
+		http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			render(w, r, true)
+		}),
+		handler.RequireNonce(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := handler.MustExtractSession(r)
+			principal, ok := validate(r, r.PostFormValue("user"), r.PostFormValue("password"))
+			if !ok {
+				s.AddFlash("Unknown user or wrong password.")
+				saveAndRedirect(w, r, s, "/login")
+				return
+			}
+			// Issue a fresh session ID upon a change in privilege, so that an ID planted beforehand
+			// is of no use afterward.
+			s.ID = ""
+			handler.SetPrincipal(s, principal)
+			s.AddFlash("Welcome, " + principal + ".")
+			saveAndRedirect(w, r, s, "/")
+		}), nil, rejectForm)))
+	mux.Handle("/logout", methods(nil,
+		handler.RequireNonce(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := handler.MustExtractSession(r)
+			handler.Logout(s, handler.SoftLogout, nil)
+			s.AddFlash("Signed out.")
+			saveAndRedirect(w, r, s, "/")
+		}), nil, rejectForm)))
+	return handler.SecurityHeaders(handler.DefaultSecurityPolicy(),
+		handler.WithSession(sessionName, store, mux, nil, handler.SecureFromRequest(false)))
+}
+
+// userFlags collects the credentials given by each -user flag.
+type userFlags map[string]string
+
+func (u userFlags) String() string {
+	names := make([]string, 0, len(u))
+	for name := range u {
+		names = append(names, name)
+	}
+	return strings.Join(names, ",")
+}
+
+func (u userFlags) Set(s string) error {
+	name, password, ok := strings.Cut(s, ":")
+	if !ok || len(name) == 0 {
+		return fmt.Errorf("malformed credentials %q; want name:password", s)
+	}
+	u[name] = password
+	return nil
+}
+
+func main() {
+	users := make(userFlags)
+	addr := flag.String("addr", "localhost:8080", "address on which to serve")
+	flag.Var(users, "user", "name:password of a user to accept (repeatable)")
+	flag.Parse()
+	if len(users) == 0 {
+		users["demo"] = "demo"
+	}
+
+	store := kvstore.New(kvstore.NewMemory(),
+		securecookie.GenerateRandomKey(32), securecookie.GenerateRandomKey(32))
+	store.Options.HttpOnly = true
+	store.Options.SameSite = http.SameSiteLaxMode
+	srv := &http.Server{
+		Addr:              *addr,
+		Handler:           newDemo(store, handler.StaticCredentials(users)),
+		ReadHeaderTimeout: 10 * time.Second,
+	}
+	log.Printf("serving on http://%s", *addr)
+	if err := srv.ListenAndServe(); err != nil {
+		fmt.Fprintln(os.Stderr, "demo:", err)
+		os.Exit(1)
+	}
+}
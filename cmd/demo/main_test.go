@@ -0,0 +1,88 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"io"
+	"net/http"
+	"net/url"
+	"regexp"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+var noncePattern = regexp.MustCompile(`name="nonce" value="([^"]+)"`)
+
+func readPage(t *testing.T, res *http.Response) string {
+	t.Helper()
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		t.Fatalf("response for %s: got status %s, want 200", res.Request.URL.Path, res.Status)
+	}
+	b, err := io.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read response: %v", err)
+	}
+	return string(b)
+}
+
+func formNonce(t *testing.T, page string) string {
+	t.Helper()
+	m := noncePattern.FindStringSubmatch(page)
+	if m == nil {
+		t.Fatalf("page bears no form nonce:\n%s", page)
+	}
+	return m[1]
+}
+
+func TestDemo(t *testing.T) {
+	store := kvstore.New(kvstore.NewMemory(), securecookie.GenerateRandomKey(32))
+	c := handlertest.NewClient(t,
+		newDemo(store, handler.StaticCredentials(map[string]string{"alice": "secret"})), store)
+
+	if page := readPage(t, c.Get("/")); !strings.Contains(page, "Not signed in") {
+		t.Errorf("home page before signing in:\n%s", page)
+	}
+
+	nonce := formNonce(t, readPage(t, c.Get("/login")))
+	page := readPage(t, c.PostForm("/login", url.Values{"user": {"alice"}, "password": {"wrong"}, "nonce": {nonce}}))
+	if !strings.Contains(page, "wrong password") {
+		t.Errorf("login page after failed login bears no flash message:\n%s", page)
+	}
+	if res := c.PostForm("/login", url.Values{"user": {"alice"}, "password": {"secret"}, "nonce": {nonce}}); res.StatusCode != http.StatusConflict {
+		t.Errorf("login replaying a nonce: got status %s, want 409", res.Status)
+	}
+
+	before := c.Cookie(sessionName).Value
+	res := c.LoginAs("/login", url.Values{"user": {"alice"}, "password": {"secret"}, "nonce": {formNonce(t, page)}})
+	page = readPage(t, res)
+	if !strings.Contains(page, "Welcome, alice.") || !strings.Contains(page, "Signed in as alice.") {
+		t.Errorf("home page after signing in:\n%s", page)
+	}
+	if c.Cookie(sessionName).Value == before {
+		t.Error("session ID was not rotated upon signing in")
+	}
+	c.AssertSessionValue(sessionName, handler.PrincipalKey, "alice")
+
+	page = readPage(t, c.GetWithSession("/", sessionName))
+	if strings.Contains(page, "Welcome") {
+		t.Errorf("flash message shown again:\n%s", page)
+	}
+	if res := c.PostForm("/logout", nil); res.StatusCode != http.StatusConflict {
+		t.Errorf("logout with no nonce: got status %s, want 409", res.Status)
+	}
+
+	page = readPage(t, c.PostForm("/logout", url.Values{"nonce": {formNonce(t, page)}}))
+	if !strings.Contains(page, "Signed out.") || !strings.Contains(page, "Not signed in") {
+		t.Errorf("home page after signing out:\n%s", page)
+	}
+	if _, ok := handler.SessionPrincipal(c.Session(sessionName)); ok {
+		t.Error("session retains a principal after signing out")
+	}
+}
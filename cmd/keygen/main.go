@@ -0,0 +1,186 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+/*
+Command keygen generates a random pair of keys for authenticating and encrypting session cookies,
+and prints them in a form that a program can consume.
+
+Usage:
+
+	keygen [-hash-size n] [-block-size n] [-format env|json] [-prefix p] [-ring file [-keep n]]
+
+By default it generates a 64-byte hash key and a 32-byte block key, selecting AES-256. A block size
+of zero generates no block key, leaving cookie values authenticated but unencrypted.
+
+With -format env, the default, it prints the keys as variable assignments that
+storeconfig.ConfigFromEnv reads, with names beginning with the prefix given by -prefix:
+
+	SESSION_HASH_KEYS=...
+	SESSION_BLOCK_KEYS=...
+
+With -format json, it prints the keys as a key ring file, per keys.RingFile.
+
+Given -ring, keygen rotates the keys in the named key ring file, as read by keys.NewFileProvider:
+it places the new key pair first, so that it encodes new cookies, retains at most -keep of the
+pairs already present, newest first, so that cookies they encoded still decode, and replaces the
+file, creating it if it doesn't exist. It then prints every key pair in the ring, newest first.
+*/
+package main
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/seh/handler/keys"
+)
+
+func main() {
+	if err := run(os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "keygen:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+func run(args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("keygen", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var (
+		hashSize  = flags.Int("hash-size", 64, "length in bytes of the hash key: 32 or 64")
+		blockSize = flags.Int("block-size", 32, "length in bytes of the block key: 16, 24, 32, or 0 for none")
+		format    = flags.String("format", "env", "output format: env or json")
+		prefix    = flags.String("prefix", "SESSION_", "prefix for environment variable names")
+		ring      = flags.String("ring", "", "path to a key ring file to rotate")
+		keep      = flags.Int("keep", 1, "number of existing key pairs to retain when rotating")
+	)
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 0 {
+		return fmt.Errorf("unexpected arguments: %s", strings.Join(flags.Args(), " "))
+	}
+	switch *hashSize {
+	case 32, 64:
+	default:
+		return fmt.Errorf("invalid hash key size %d; want 32 or 64", *hashSize)
+	}
+	switch *blockSize {
+	case 0, 16, 24, 32:
+	default:
+		return fmt.Errorf("invalid block key size %d; want 16, 24, 32, or 0", *blockSize)
+	}
+	if *format != "env" && *format != "json" {
+		return fmt.Errorf("unknown format %q; want env or json", *format)
+	}
+	if *keep < 0 {
+		return fmt.Errorf("invalid number of key pairs to keep: %d", *keep)
+	}
+
+	var p keys.Pair
+	var err error
+	if p.Hash, err = randomKey(*hashSize); err != nil {
+		return err
+	}
+	if *blockSize != 0 {
+		if p.Block, err = randomKey(*blockSize); err != nil {
+			return err
+		}
+	}
+	pairs := []keys.Pair{p}
+	if len(*ring) != 0 {
+		if pairs, err = rotate(*ring, p, *keep); err != nil {
+			return err
+		}
+	}
+
+	if *format == "json" {
+		b, err := keys.FormatRingFile(pairs)
+		if err != nil {
+			return err
+		}
+		_, err = fmt.Fprintf(stdout, "%s\n", b)
+		return err
+	}
+	return writeEnv(stdout, *prefix, pairs)
+}
+
+func randomKey(size int) ([]byte, error) {
+	k := make([]byte, size)
+	if _, err := io.ReadFull(rand.Reader, k); err != nil {
+		return nil, fmt.Errorf("generating key: %w", err)
+	}
+	return k, nil
+}
+
+// rotate installs the key pair first in the key ring file at the given path, retaining at most
+// keep of the key pairs present there already, and returns the resulting key pairs.
+func rotate(path string, p keys.Pair, keep int) ([]keys.Pair, error) {
+	pairs := []keys.Pair{p}
+	b, err := os.ReadFile(path)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+	case err != nil:
+		return nil, err
+	default:
+		existing, err := keys.ParseRingFile(b)
+		if err != nil {
+			return nil, fmt.Errorf("reading %s: %w", path, err)
+		}
+		if len(existing) > keep {
+			existing = existing[:keep]
+		}
+		pairs = append(pairs, existing...)
+	}
+	if b, err = keys.FormatRingFile(pairs); err != nil {
+		return nil, err
+	}
+	// Replace the file atomically, so that a FileProvider never reads it partially written.
+	f, err := os.CreateTemp(filepath.Dir(path), filepath.Base(path)+".*")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.Write(append(b, '\n')); err != nil {
+		f.Close()
+		return nil, err
+	}
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+	if err := os.Rename(f.Name(), path); err != nil {
+		return nil, err
+	}
+	return pairs, nil
+}
+
+// writeEnv writes the key pairs as the HASH_KEYS and BLOCK_KEYS variables that
+// storeconfig.ConfigFromEnv reads.
+func writeEnv(w io.Writer, prefix string, pairs []keys.Pair) error {
+	hashes := make([]string, len(pairs))
+	blocks := make([]string, len(pairs))
+	anyBlocks := false
+	for i, p := range pairs {
+		hashes[i] = base64.StdEncoding.EncodeToString(p.Hash)
+		if len(p.Block) != 0 {
+			blocks[i] = base64.StdEncoding.EncodeToString(p.Block)
+			anyBlocks = true
+		}
+	}
+	if _, err := fmt.Fprintf(w, "%sHASH_KEYS=%s\n", prefix, strings.Join(hashes, ",")); err != nil {
+		return err
+	}
+	if !anyBlocks {
+		return nil
+	}
+	_, err := fmt.Fprintf(w, "%sBLOCK_KEYS=%s\n", prefix, strings.Join(blocks, ","))
+	return err
+}
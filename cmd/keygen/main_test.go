@@ -0,0 +1,88 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/seh/handler/keys"
+	"github.com/seh/handler/storeconfig"
+)
+
+func TestRunEnv(t *testing.T) {
+	var out bytes.Buffer
+	if err := run([]string{"-prefix", "KEYGEN_TEST_", "-hash-size", "32", "-block-size", "16"}, &out, &out); err != nil {
+		t.Fatal(err)
+	}
+	for _, line := range strings.Split(strings.TrimSpace(out.String()), "\n") {
+		name, value, _ := strings.Cut(line, "=")
+		t.Setenv(name, value)
+	}
+	t.Setenv("KEYGEN_TEST_KIND", storeconfig.KindCookie)
+	c, err := storeconfig.ConfigFromEnv("KEYGEN_TEST_")
+	if err != nil {
+		t.Fatalf("reading generated keys: %v", err)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("generated keys are invalid: %v", err)
+	}
+	if got, want := len(c.KeyPairs), 1; got != want {
+		t.Fatalf("key pairs: got %d, want %d", got, want)
+	}
+	if got, want := len(c.KeyPairs[0].Hash), 32; got != want {
+		t.Errorf("hash key size: got %d, want %d", got, want)
+	}
+	if got, want := len(c.KeyPairs[0].Block), 16; got != want {
+		t.Errorf("block key size: got %d, want %d", got, want)
+	}
+
+	for _, args := range [][]string{
+		{"-hash-size", "16"},
+		{"-block-size", "8"},
+		{"-format", "yaml"},
+		{"-keep", "-1"},
+		{"extra"},
+	} {
+		if err := run(args, &out, &out); err == nil {
+			t.Errorf("run with %v: got no error", args)
+		}
+	}
+}
+
+func TestRunRotatesRing(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "ring.json")
+	var generated []keys.Pair
+	for i := 0; i != 3; i++ {
+		var out bytes.Buffer
+		if err := run([]string{"-ring", path, "-format", "json", "-block-size", "0"}, &out, &out); err != nil {
+			t.Fatalf("rotation %d: %v", i, err)
+		}
+		pairs, err := keys.ParseRingFile(out.Bytes())
+		if err != nil {
+			t.Fatalf("rotation %d: parsing output: %v", i, err)
+		}
+		generated = append(generated, pairs[0])
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pairs, err := keys.ParseRingFile(b)
+	if err != nil {
+		t.Fatalf("parsing ring file: %v", err)
+	}
+	if got, want := len(pairs), 2; got != want {
+		t.Fatalf("key pairs in ring: got %d, want %d", got, want)
+	}
+	if !bytes.Equal(pairs[0].Hash, generated[2].Hash) || !bytes.Equal(pairs[1].Hash, generated[1].Hash) {
+		t.Error("ring does not hold the newest key pairs, newest first")
+	}
+	if len(pairs[0].Block) != 0 {
+		t.Error("ring holds a block key despite a block size of zero")
+	}
+}
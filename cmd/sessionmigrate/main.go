@@ -0,0 +1,301 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+/*
+Command sessionmigrate copies sessions from one server-side session store to another, such as when
+moving a store to new storage or changing the format in which it serializes session values.
+
+Usage:
+
+	sessionmigrate -from path -to path [-from-codec c] [-to-codec c] [-prefix p] [-batch n] [-checkpoint file] [-verify]
+
+It copies the values that a kvstore.File keeps in the source directory into a kvstore.File in the
+destination directory, preserving the time at which each value expires. Given differing codecs,
+each either "gob" or "json", it decodes each value with the source codec and encodes it with the
+destination codec, as kvstore.Store's Serializer would with securecookie.GobEncoder or
+handler.JSONValuesSerializer. It copies only the values whose keys begin with the given prefix,
+such as the store's KeyPrefix.
+
+It copies the values in batches of the given size, in order of their keys. With -checkpoint, it
+records the last key of each batch copied in the given file, and upon starting again, skips the
+keys up to the one recorded there, so that an interrupted migration can resume where it stopped.
+With -verify, it then reads each value back from the destination and compares it with the source,
+reporting those that differ.
+
+It prints the number of sessions copied and verified, and exits with a nonzero status if copying
+fails or any session fails verification.
+
+Stores that keep their sessions in external storage, such as a SQL database or Redis, do so through
+a kvstore.KV that adapts a client for that storage; this command can't construct such a client. For
+those stores, migrate through kvstore.DualWrite, which moves sessions as the program saves them.
+*/
+package main
+
+import (
+	"context"
+	"encoding/hex"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"reflect"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler"
+	"github.com/seh/handler/kvstore"
+)
+
+// sourceKV is implemented by KVs from which sessions can be migrated, such as kvstore.File.
+type sourceKV interface {
+	kvstore.KV
+	kvstore.Lister
+	kvstore.TTLReporter
+}
+
+type options struct {
+	prefix     string
+	batch      int
+	checkpoint string
+	verify     bool
+	from, to   securecookie.Serializer
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "sessionmigrate:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func serializerNamed(name string) (securecookie.Serializer, error) {
+	switch name {
+	case "gob":
+		return securecookie.GobEncoder{}, nil
+	case "json":
+		return handler.JSONValuesSerializer{}, nil
+	default:
+		return nil, fmt.Errorf("unsupported codec %q; want gob or json", name)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("sessionmigrate", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var o options
+	from := flags.String("from", "", "directory of the kvstore.File holding the sessions to copy (required)")
+	to := flags.String("to", "", "directory of the kvstore.File to copy the sessions into (required)")
+	fromCodec := flags.String("from-codec", "gob", "codec of the source's session values: gob or json")
+	toCodec := flags.String("to-codec", "", "codec of the destination's session values, if different: gob or json")
+	flags.StringVar(&o.prefix, "prefix", "", "copy only the sessions whose keys begin with this prefix")
+	flags.IntVar(&o.batch, "batch", 1000, "number of sessions to copy in each batch")
+	flags.StringVar(&o.checkpoint, "checkpoint", "", "file recording progress, for resuming an interrupted migration")
+	flags.BoolVar(&o.verify, "verify", false, "compare each session copied with the source afterward")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	switch {
+	case flags.NArg() != 0:
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	case len(*from) == 0:
+		return errors.New("no source directory supplied")
+	case len(*to) == 0:
+		return errors.New("no destination directory supplied")
+	case o.batch <= 0:
+		return fmt.Errorf("invalid batch size %d", o.batch)
+	}
+	if len(*toCodec) == 0 {
+		*toCodec = *fromCodec
+	}
+	var err error
+	if o.from, err = serializerNamed(*fromCodec); err != nil {
+		return err
+	}
+	if o.to, err = serializerNamed(*toCodec); err != nil {
+		return err
+	}
+	// NewFile would create a missing directory; copying from a mistyped path should fail instead.
+	if info, err := os.Stat(*from); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", *from)
+	}
+	src, err := kvstore.NewFile(*from)
+	if err != nil {
+		return err
+	}
+	dst, err := kvstore.NewFile(*to)
+	if err != nil {
+		return err
+	}
+	n, err := migrate(ctx, src, dst, o)
+	fmt.Fprintf(stdout, "copied %d sessions\n", n)
+	if err != nil || !o.verify {
+		return err
+	}
+	checked, mismatched, err := verify(ctx, src, dst, o, stdout)
+	fmt.Fprintf(stdout, "verified %d sessions, %d mismatched\n", checked, mismatched)
+	if err == nil && mismatched != 0 {
+		err = fmt.Errorf("%d sessions failed verification", mismatched)
+	}
+	return err
+}
+
+// convert decodes the value with the source codec and encodes it with the destination codec,
+// returning the value unchanged if the codecs are the same.
+func convert(value []byte, o options) ([]byte, error) {
+	if o.from == o.to {
+		return value, nil
+	}
+	values := make(map[interface{}]interface{})
+	if err := o.from.Deserialize(value, &values); err != nil {
+		return nil, err
+	}
+	return o.to.Serialize(values)
+}
+
+// readCheckpoint returns the last key recorded in the checkpoint file, or an empty string if the
+// file doesn't exist yet.
+func readCheckpoint(path string) (string, error) {
+	if len(path) == 0 {
+		return "", nil
+	}
+	b, err := os.ReadFile(path)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return "", nil
+		}
+		return "", err
+	}
+	key, err := hex.DecodeString(strings.TrimSpace(string(b)))
+	if err != nil {
+		return "", fmt.Errorf("reading checkpoint %s: %w", path, err)
+	}
+	return string(key), nil
+}
+
+// writeCheckpoint records the key in the checkpoint file, replacing its content atomically.
+func writeCheckpoint(path, key string) error {
+	if len(path) == 0 {
+		return nil
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, []byte(hex.EncodeToString([]byte(key))+"\n"), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// migrate copies the values whose keys begin with the prefix from the source to the destination,
+// in batches, resuming after the key recorded in the checkpoint file, if any. It returns the
+// number of values copied. Values that expire or vanish while it runs are skipped.
+func migrate(ctx context.Context, src sourceKV, dst kvstore.KV, o options) (int, error) {
+	after, err := readCheckpoint(o.checkpoint)
+	if err != nil {
+		return 0, err
+	}
+	keys, err := src.Keys(ctx, o.prefix)
+	if err != nil {
+		return 0, err
+	}
+	n, pending := 0, 0
+	var last string
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return n, err
+		}
+		if len(after) != 0 && key <= after {
+			continue
+		}
+		ttl, err := src.TTL(ctx, key)
+		if err == kvstore.ErrNotFound {
+			continue
+		} else if err != nil {
+			return n, fmt.Errorf("reading expiry of %q: %w", key, err)
+		}
+		value, err := src.Get(ctx, key)
+		if err == kvstore.ErrNotFound {
+			continue
+		} else if err != nil {
+			return n, fmt.Errorf("reading %q: %w", key, err)
+		}
+		if value, err = convert(value, o); err != nil {
+			return n, fmt.Errorf("converting %q: %w", key, err)
+		}
+		if err := dst.Set(ctx, key, value, ttl); err != nil {
+			return n, fmt.Errorf("writing %q: %w", key, err)
+		}
+		n++
+		pending++
+		last = key
+		if pending == o.batch {
+			if err := writeCheckpoint(o.checkpoint, last); err != nil {
+				return n, err
+			}
+			pending = 0
+		}
+	}
+	if pending != 0 {
+		return n, writeCheckpoint(o.checkpoint, last)
+	}
+	return n, nil
+}
+
+// decode decodes the value with the serializer, then normalizes it by encoding and decoding it
+// again, so that values decoded from different codecs compare equal.
+func decode(value []byte, from securecookie.Serializer, o options) (map[interface{}]interface{}, error) {
+	values := make(map[interface{}]interface{})
+	if err := from.Deserialize(value, &values); err != nil {
+		return nil, err
+	}
+	b, err := o.to.Serialize(values)
+	if err != nil {
+		return nil, err
+	}
+	normalized := make(map[interface{}]interface{})
+	return normalized, o.to.Deserialize(b, &normalized)
+}
+
+// verify compares each value whose key begins with the prefix in the source with that in the
+// destination, reporting each mismatch to the writer. It returns the number of values checked and
+// the number that failed to match.
+func verify(ctx context.Context, src sourceKV, dst kvstore.KV, o options, w io.Writer) (checked, mismatched int, err error) {
+	keys, err := src.Keys(ctx, o.prefix)
+	if err != nil {
+		return 0, 0, err
+	}
+	for _, key := range keys {
+		if err := ctx.Err(); err != nil {
+			return checked, mismatched, err
+		}
+		value, err := src.Get(ctx, key)
+		if err == kvstore.ErrNotFound {
+			continue
+		} else if err != nil {
+			return checked, mismatched, fmt.Errorf("reading %q: %w", key, err)
+		}
+		checked++
+		copied, err := dst.Get(ctx, key)
+		if err != nil {
+			mismatched++
+			fmt.Fprintf(w, "%q: missing from destination: %v\n", key, err)
+			continue
+		}
+		want, err := decode(value, o.from, o)
+		if err != nil {
+			return checked, mismatched, fmt.Errorf("decoding %q: %w", key, err)
+		}
+		got, err := decode(copied, o.to, o)
+		if err != nil || !reflect.DeepEqual(got, want) {
+			mismatched++
+			fmt.Fprintf(w, "%q: differs in destination\n", key)
+		}
+	}
+	return checked, mismatched, nil
+}
@@ -0,0 +1,111 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestMigrate(t *testing.T) {
+	ctx := context.Background()
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	newFile := func() *kvstore.File {
+		f, err := kvstore.NewFile(t.TempDir())
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.Clock = clock
+		return f
+	}
+	src, dst := newFile(), newFile()
+	set := func(key, value string, ttl time.Duration) {
+		b, err := securecookie.GobEncoder{}.Serialize(map[interface{}]interface{}{"k": value})
+		if err != nil {
+			t.Fatal(err)
+		}
+		src.Set(ctx, key, b, ttl)
+	}
+	set("session:a", "1", time.Hour)
+	set("session:b", "2", 0)
+	set("session:c", "3", time.Minute)
+	set("other:x", "4", 0)
+	clock.Advance(10 * time.Second)
+
+	o := options{
+		prefix:     "session:",
+		batch:      2,
+		checkpoint: filepath.Join(t.TempDir(), "checkpoint"),
+		from:       securecookie.GobEncoder{},
+		to:         handler.JSONValuesSerializer{},
+	}
+	if n, err := migrate(ctx, src, dst, o); err != nil || n != 3 {
+		t.Fatalf("migrated: got %d, %v, want 3", n, err)
+	}
+	if ttl, err := dst.TTL(ctx, "session:a"); err != nil || ttl != time.Hour-10*time.Second {
+		t.Errorf("TTL of copied session: got (%v, %v), want %v", ttl, err, time.Hour-10*time.Second)
+	}
+	if b, err := dst.Get(ctx, "session:b"); err != nil || string(b) != `{"k":"2"}` {
+		t.Errorf("converted session: got (%q, %v), want %q", b, err, `{"k":"2"}`)
+	}
+	if _, err := dst.Get(ctx, "other:x"); err != kvstore.ErrNotFound {
+		t.Errorf("session outside prefix: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+
+	// Resuming copies only the sessions after the checkpoint.
+	set("session:d", "5", 0)
+	dst.Delete(ctx, "session:a")
+	if n, err := migrate(ctx, src, dst, o); err != nil || n != 1 {
+		t.Errorf("resumed migration: got %d, %v, want 1", n, err)
+	}
+
+	var out bytes.Buffer
+	if checked, mismatched, err := verify(ctx, src, dst, o, &out); err != nil || checked != 4 || mismatched != 1 {
+		t.Errorf("verification: got %d checked, %d mismatched, %v, want 4 and 1", checked, mismatched, err)
+	}
+	dst.Set(ctx, "session:a", []byte(`{"k":"1"}`), 0)
+	dst.Set(ctx, "session:b", []byte(`{"k":"two"}`), 0)
+	if _, mismatched, err := verify(ctx, src, dst, o, &out); err != nil || mismatched != 1 {
+		t.Errorf("verification of altered session: got %d mismatched, %v, want 1", mismatched, err)
+	}
+}
+
+func TestRun(t *testing.T) {
+	ctx := context.Background()
+	from, to := t.TempDir(), t.TempDir()
+	var out bytes.Buffer
+	for _, args := range [][]string{
+		{},
+		{"-from", from},
+		{"-from", filepath.Join(from, "absent"), "-to", to},
+		{"-from", from, "-to", to, "-batch", "0"},
+		{"-from", from, "-to", to, "-to-codec", "xml"},
+		{"-from", from, "-to", to, "extra"},
+	} {
+		if err := run(ctx, args, &out, &out); err == nil {
+			t.Errorf("run with %v: got no error", args)
+		}
+	}
+	src, err := kvstore.NewFile(from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, _ := handler.JSONValuesSerializer{}.Serialize(map[interface{}]interface{}{"k": "v"})
+	src.Set(ctx, "a", b, time.Hour)
+	out.Reset()
+	if err := run(ctx, []string{"-from", from, "-to", to, "-from-codec", "json", "-verify"}, &out, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "copied 1 sessions\nverified 1 sessions, 0 mismatched\n"; got != want {
+		t.Errorf("output: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,156 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+/*
+Command sessionpurge deletes expired sessions from a server-side session store, for operators who
+prefer to purge from a scheduled job rather than running a handler.Purger within the program.
+
+Usage:
+
+	sessionpurge -dir path [-retention d] [-batch n] [-rate r] [-dry-run]
+
+It purges the store that a kvstore.File keeps in the given directory, deleting the sessions that
+expired longer ago than the retention period. It deletes them in batches of the given size,
+pausing between batches so as to delete no more than the given number of sessions per second,
+which keeps a large backlog from monopolizing the store while the program continues to use it.
+With -dry-run, it reports how many sessions it would delete, without deleting any.
+
+It prints the number of sessions deleted, and exits with a nonzero status if purging fails.
+
+Stores that keep their sessions in external storage, such as a SQL database or Redis, do so through
+a kvstore.KV that adapts a client for that storage; this command can't construct such a client. For
+those stores, purge through kvstore.Store's PurgeExpired method with the KV implementing
+kvstore.Expirer, or rely on the storage's own expiration.
+*/
+package main
+
+import (
+	"context"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"time"
+
+	"github.com/seh/handler"
+	"github.com/seh/handler/kvstore"
+)
+
+// batchPurger is implemented by KVs that can purge their expired values incrementally, such as
+// kvstore.File.
+type batchPurger interface {
+	PurgeExpiredLimit(ctx context.Context, before time.Time, limit int) (int, error)
+	CountExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+type options struct {
+	retention time.Duration
+	batch     int
+	rate      float64
+	dryRun    bool
+	clock     handler.Clock
+	// sleep pauses between batches, returning early with an error if the context is done.
+	sleep func(ctx context.Context, d time.Duration) error
+}
+
+func main() {
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt)
+	defer stop()
+	if err := run(ctx, os.Args[1:], os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "sessionpurge:", err)
+		}
+		os.Exit(1)
+	}
+}
+
+func run(ctx context.Context, args []string, stdout, stderr io.Writer) error {
+	flags := flag.NewFlagSet("sessionpurge", flag.ContinueOnError)
+	flags.SetOutput(stderr)
+	var o options
+	dir := flags.String("dir", "", "directory of the kvstore.File holding the sessions (required)")
+	flags.DurationVar(&o.retention, "retention", 0, "how long to retain sessions after they expire")
+	flags.IntVar(&o.batch, "batch", 1000, "maximum number of sessions to delete in each batch")
+	flags.Float64Var(&o.rate, "rate", 0, "maximum number of sessions to delete per second, or 0 for no limit")
+	flags.BoolVar(&o.dryRun, "dry-run", false, "report the number of sessions to delete without deleting them")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	switch {
+	case flags.NArg() != 0:
+		return fmt.Errorf("unexpected arguments: %v", flags.Args())
+	case len(*dir) == 0:
+		return errors.New("no store directory supplied")
+	case o.batch <= 0:
+		return fmt.Errorf("invalid batch size %d", o.batch)
+	case o.rate < 0:
+		return fmt.Errorf("invalid rate %v", o.rate)
+	}
+	// NewFile would create a missing directory; purging a mistyped path should fail instead.
+	if info, err := os.Stat(*dir); err != nil {
+		return err
+	} else if !info.IsDir() {
+		return fmt.Errorf("%s is not a directory", *dir)
+	}
+	kv, err := kvstore.NewFile(*dir)
+	if err != nil {
+		return err
+	}
+	n, err := purge(ctx, kv, o)
+	if o.dryRun {
+		fmt.Fprintf(stdout, "%d expired sessions to delete\n", n)
+	} else {
+		fmt.Fprintf(stdout, "deleted %d expired sessions\n", n)
+	}
+	return err
+}
+
+// purge deletes the sessions from the KV that expired longer than the retention period ago, in
+// batches, returning the number of sessions deleted, or that it would delete for a dry run.
+func purge(ctx context.Context, kv batchPurger, o options) (int, error) {
+	clock := o.clock
+	if clock == nil {
+		clock = handler.SystemClock
+	}
+	sleep := o.sleep
+	if sleep == nil {
+		sleep = sleepContext
+	}
+	before := clock.Now()
+	if o.retention > 0 {
+		before = before.Add(-o.retention)
+	}
+	if o.dryRun {
+		return kv.CountExpired(ctx, before)
+	}
+	var pause time.Duration
+	if o.rate > 0 {
+		pause = time.Duration(float64(o.batch) / o.rate * float64(time.Second))
+	}
+	total := 0
+	for {
+		n, err := kv.PurgeExpiredLimit(ctx, before, o.batch)
+		total += n
+		if err != nil || n < o.batch {
+			return total, err
+		}
+		if pause > 0 {
+			if err := sleep(ctx, pause); err != nil {
+				return total, err
+			}
+		}
+	}
+}
+
+func sleepContext(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-t.C:
+		return nil
+	}
+}
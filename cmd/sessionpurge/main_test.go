@@ -0,0 +1,95 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestPurge(t *testing.T) {
+	ctx := context.Background()
+	kv, err := kvstore.NewFile(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	kv.Clock = clock
+	for _, k := range []string{"a", "b", "c", "d", "e"} {
+		kv.Set(ctx, k, nil, time.Minute)
+	}
+	kv.Set(ctx, "f", nil, time.Hour)
+	clock.Advance(2 * time.Minute)
+
+	var pauses []time.Duration
+	o := options{
+		batch: 2,
+		rate:  4,
+		clock: clock,
+		sleep: func(_ context.Context, d time.Duration) error {
+			pauses = append(pauses, d)
+			return nil
+		},
+	}
+
+	o.retention = 5 * time.Minute
+	if n, err := purge(ctx, kv, o); err != nil || n != 0 {
+		t.Errorf("purged within retention: got %d, %v, want 0", n, err)
+	}
+
+	o.retention = 0
+	o.dryRun = true
+	if n, err := purge(ctx, kv, o); err != nil || n != 5 {
+		t.Errorf("dry run: got %d, %v, want 5", n, err)
+	}
+	if n, _ := kv.CountExpired(ctx, clock.Now()); n != 5 {
+		t.Errorf("expired sessions after dry run: got %d, want 5", n)
+	}
+
+	o.dryRun = false
+	if n, err := purge(ctx, kv, o); err != nil || n != 5 {
+		t.Errorf("purged: got %d, %v, want 5", n, err)
+	}
+	if got, want := len(pauses), 2; got != want {
+		t.Errorf("pauses: got %d, want %d", got, want)
+	}
+	for _, d := range pauses {
+		if want := 500 * time.Millisecond; d != want {
+			t.Errorf("pause: got %v, want %v", d, want)
+		}
+	}
+	if _, err := kv.Get(ctx, "f"); err != nil {
+		t.Errorf("unexpired session: %v", err)
+	}
+}
+
+func TestRunRejectsBadFlags(t *testing.T) {
+	ctx := context.Background()
+	dir := t.TempDir()
+	var out bytes.Buffer
+	for _, args := range [][]string{
+		{},
+		{"-dir", filepath.Join(dir, "absent")},
+		{"-dir", dir, "-batch", "0"},
+		{"-dir", dir, "-rate", "-1"},
+		{"-dir", dir, "extra"},
+	} {
+		if err := run(ctx, args, &out, &out); err == nil {
+			t.Errorf("run with %v: got no error", args)
+		}
+	}
+	out.Reset()
+	if err := run(ctx, []string{"-dir", dir, "-dry-run"}, &out, &out); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := out.String(), "0 expired sessions to delete\n"; got != want {
+		t.Errorf("output: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,196 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+/*
+Command sessiontool decodes a session cookie value using the store's keys and prints the session's
+values, and can re-encode the value with some of those values modified, which helps when
+diagnosing cookie problems in a deployed program.
+
+Usage:
+
+	sessiontool -name cookie-name [-ring file | -hash key [-block key]] [flags] [value]
+
+It reads the cookie value from the sole argument, or from standard input if there's no argument.
+It accepts the keys either as a key ring file, as read by keys.ParseRingFile, trying each key pair
+in turn, or as a single pair of base64-encoded keys. The cookie name is required, since the keys
+authenticate it together with the value.
+
+Cookies written by sessions.CookieStore carry the session's values, which sessiontool prints one
+per line, sorted by key. Cookies written by kvstore.Store carry only the session ID, which
+sessiontool prints instead. It can decode only values of the types that encoding/gob knows without
+registration, such as strings, numbers, and slices and maps of them.
+
+Given any -set or -delete flags, sessiontool applies them to the decoded values, each -set
+recording a string value, and prints the value re-encoded with the first key pair.
+*/
+package main
+
+import (
+	"encoding/base64"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler/keys"
+)
+
+// stringsFlag is a flag.Value collecting each occurrence of a repeatable flag.
+type stringsFlag []string
+
+func (f *stringsFlag) String() string {
+	return strings.Join(*f, ",")
+}
+
+func (f *stringsFlag) Set(s string) error {
+	*f = append(*f, s)
+	return nil
+}
+
+func main() {
+	if err := run(os.Args[1:], os.Stdin, os.Stdout, os.Stderr); err != nil {
+		if !errors.Is(err, flag.ErrHelp) {
+			fmt.Fprintln(os.Stderr, "sessiontool:", err)
+		}
+		os.Exit(2)
+	}
+}
+
+func run(args []string, stdin io.Reader, stdout, stderr io.Writer) error {
+	fs := flag.NewFlagSet("sessiontool", flag.ContinueOnError)
+	fs.SetOutput(stderr)
+	var (
+		name   = fs.String("name", "", "name of the session cookie (required)")
+		ring   = fs.String("ring", "", "path to a key ring file")
+		hash   = fs.String("hash", "", "base64-encoded authentication key")
+		block  = fs.String("block", "", "base64-encoded encryption key (optional)")
+		maxAge = fs.Int("maxage", 0, "maximum age in seconds of values to accept, or 0 for no limit")
+		sets   stringsFlag
+		dels   stringsFlag
+	)
+	fs.Var(&sets, "set", "key=value to record in the session before re-encoding (repeatable)")
+	fs.Var(&dels, "delete", "key to remove from the session before re-encoding (repeatable)")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+	if len(*name) == 0 {
+		return errors.New("no cookie name supplied")
+	}
+	pairs, err := loadPairs(*ring, *hash, *block)
+	if err != nil {
+		return err
+	}
+	codecs := make([]securecookie.Codec, len(pairs))
+	for i, p := range pairs {
+		c := securecookie.New(p.Hash, p.Block)
+		c.MaxAge(*maxAge)
+		codecs[i] = c
+	}
+	value, err := readValue(*name, fs.Args(), stdin)
+	if err != nil {
+		return err
+	}
+
+	values := make(map[interface{}]interface{})
+	if err := securecookie.DecodeMulti(*name, value, &values, codecs...); err != nil {
+		// Stores like kvstore.Store encode only the session ID.
+		var id string
+		if securecookie.DecodeMulti(*name, value, &id, codecs...) != nil {
+			return fmt.Errorf("decoding cookie value: %w", err)
+		}
+		if len(sets) != 0 || len(dels) != 0 {
+			return errors.New("cookie carries only a session ID, with no values to modify")
+		}
+		fmt.Fprintf(stdout, "session ID: %s\n", id)
+		return nil
+	}
+	printValues(stdout, values)
+	if len(sets) == 0 && len(dels) == 0 {
+		return nil
+	}
+
+	for _, k := range dels {
+		delete(values, k)
+	}
+	for _, kv := range sets {
+		k, v, ok := strings.Cut(kv, "=")
+		if !ok {
+			return fmt.Errorf("malformed -set %q; want key=value", kv)
+		}
+		values[k] = v
+	}
+	encoded, err := securecookie.EncodeMulti(*name, values, codecs...)
+	if err != nil {
+		return fmt.Errorf("encoding cookie value: %w", err)
+	}
+	fmt.Fprintf(stdout, "\n%s\n", encoded)
+	return nil
+}
+
+// loadPairs returns the key pairs read from the key ring file at the given path, or else the
+// single key pair decoded from the given base64-encoded keys.
+func loadPairs(ring, hash, block string) ([]keys.Pair, error) {
+	if len(ring) != 0 {
+		if len(hash) != 0 || len(block) != 0 {
+			return nil, errors.New("both -ring and -hash or -block supplied")
+		}
+		b, err := os.ReadFile(ring)
+		if err != nil {
+			return nil, err
+		}
+		return keys.ParseRingFile(b)
+	}
+	if len(hash) == 0 {
+		return nil, errors.New("no keys supplied; use either -ring or -hash")
+	}
+	var p keys.Pair
+	var err error
+	if p.Hash, err = base64.StdEncoding.DecodeString(hash); err != nil {
+		return nil, fmt.Errorf("decoding -hash: %w", err)
+	}
+	if len(block) != 0 {
+		if p.Block, err = base64.StdEncoding.DecodeString(block); err != nil {
+			return nil, fmt.Errorf("decoding -block: %w", err)
+		}
+	}
+	return []keys.Pair{p}, nil
+}
+
+// readValue returns the cookie value from the sole argument, or from the reader if there's no
+// argument. It tolerates a value prefixed with the cookie name and an equals sign, as copied from a
+// Cookie header.
+func readValue(name string, args []string, r io.Reader) (string, error) {
+	var value string
+	switch len(args) {
+	case 0:
+		b, err := io.ReadAll(r)
+		if err != nil {
+			return "", err
+		}
+		value = string(b)
+	case 1:
+		value = args[0]
+	default:
+		return "", errors.New("more than one cookie value supplied")
+	}
+	value = strings.TrimPrefix(strings.TrimSpace(value), name+"=")
+	if len(value) == 0 {
+		return "", errors.New("no cookie value supplied")
+	}
+	return value, nil
+}
+
+func printValues(w io.Writer, values map[interface{}]interface{}) {
+	lines := make([]string, 0, len(values))
+	for k, v := range values {
+		lines = append(lines, fmt.Sprintf("%#v = %#v", k, v))
+	}
+	sort.Strings(lines)
+	for _, l := range lines {
+		fmt.Fprintln(w, l)
+	}
+}
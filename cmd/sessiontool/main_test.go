@@ -0,0 +1,97 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package main
+
+import (
+	"bytes"
+	"encoding/base64"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler/keys"
+)
+
+func TestRun(t *testing.T) {
+	hash, block := securecookie.GenerateRandomKey(32), securecookie.GenerateRandomKey(32)
+	keyArgs := []string{
+		"-name", "s",
+		"-hash", base64.StdEncoding.EncodeToString(hash),
+		"-block", base64.StdEncoding.EncodeToString(block),
+	}
+	codec := securecookie.New(hash, block)
+	encoded, err := codec.Encode("s", map[interface{}]interface{}{"uid": "alice", "n": 3})
+	if err != nil {
+		t.Fatalf("failed to encode values: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := run(append(keyArgs, encoded), nil, &out, &out); err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if got, want := out.String(), "\"n\" = 3\n\"uid\" = \"alice\"\n"; got != want {
+		t.Errorf("decoded output: got %q, want %q", got, want)
+	}
+
+	out.Reset()
+	if err := run(keyArgs, strings.NewReader("s="+encoded+"\n"), &out, &out); err != nil {
+		t.Fatalf("decoding from standard input: %v", err)
+	}
+	if got, want := out.String(), "\"n\" = 3\n\"uid\" = \"alice\"\n"; got != want {
+		t.Errorf("decoded output from standard input: got %q, want %q", got, want)
+	}
+
+	out.Reset()
+	if err := run(append(keyArgs, "-set", "uid=bob", "-delete", "n", encoded), nil, &out, &out); err != nil {
+		t.Fatalf("re-encoding: %v", err)
+	}
+	lines := strings.Split(strings.TrimSpace(out.String()), "\n")
+	values := make(map[interface{}]interface{})
+	if err := codec.Decode("s", lines[len(lines)-1], &values); err != nil {
+		t.Fatalf("failed to decode re-encoded value: %v", err)
+	}
+	if got, want := len(values), 1; got != want {
+		t.Errorf("re-encoded value count: got %d, want %d", got, want)
+	}
+	if got, want := values["uid"], "bob"; got != want {
+		t.Errorf("re-encoded uid: got %v, want %v", got, want)
+	}
+
+	if err := run(append([]string{"-name", "other"}, append(keyArgs[2:], encoded)...), nil, &out, &out); err == nil {
+		t.Error("decoding with the wrong cookie name: got no error")
+	}
+	if err := run([]string{"-name", "s", encoded}, nil, &out, &out); err == nil {
+		t.Error("decoding with no keys: got no error")
+	}
+}
+
+func TestRunWithRingAndSessionID(t *testing.T) {
+	old := keys.Pair{Hash: securecookie.GenerateRandomKey(32)}
+	current := keys.Pair{Hash: securecookie.GenerateRandomKey(32)}
+	b, err := keys.FormatRingFile([]keys.Pair{current, old})
+	if err != nil {
+		t.Fatalf("failed to format ring file: %v", err)
+	}
+	path := filepath.Join(t.TempDir(), "ring.json")
+	if err := os.WriteFile(path, b, 0o600); err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := securecookie.New(old.Hash, nil).Encode("s", "abc123")
+	if err != nil {
+		t.Fatalf("failed to encode session ID: %v", err)
+	}
+
+	var out bytes.Buffer
+	if err := run([]string{"-name", "s", "-ring", path, encoded}, nil, &out, &out); err != nil {
+		t.Fatalf("decoding: %v", err)
+	}
+	if got, want := out.String(), "session ID: abc123\n"; got != want {
+		t.Errorf("decoded output: got %q, want %q", got, want)
+	}
+	if err := run([]string{"-name", "s", "-ring", path, "-set", "a=b", encoded}, nil, &out, &out); err == nil {
+		t.Error("modifying a session ID cookie: got no error")
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"encoding/gob"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// Expiring is a session value that lapses at a given time, such as a one-time notice or a
+// short-lived grant, stored via SetExpiring.
+type Expiring struct {
+	Value   interface{}
+	Expires time.Time
+}
+
+func init() {
+	gob.Register(Expiring{})
+}
+
+// SetExpiring stores the value in the session under the given key, to lapse at the given time.
+func SetExpiring(s *sessions.Session, key, value interface{}, expires time.Time) {
+	s.Values[key] = Expiring{Value: value, Expires: expires}
+}
+
+// ExpiringValue returns the value stored in the session under the given key via SetExpiring,
+// together with a boolean indicating whether such a value is present and had not lapsed as of the
+// given time.
+func ExpiringValue(s *sessions.Session, key interface{}, now time.Time) (interface{}, bool) {
+	e, ok := s.Values[key].(Expiring)
+	if !ok || !now.Before(e.Expires) {
+		return nil, false
+	}
+	return e.Value, true
+}
+
+// compactValues removes from the values, and from any maps nested within them, all nil values,
+// all Expiring values that lapsed as of the given time, and all maps left empty.
+func compactValues(values map[interface{}]interface{}, now time.Time) {
+	for k, v := range values {
+		if compactValue(v, now) {
+			delete(values, k)
+		}
+	}
+}
+
+// compactValue compacts the value, if it's a map, reporting whether it should be removed.
+func compactValue(v interface{}, now time.Time) bool {
+	switch v := v.(type) {
+	case nil:
+		return true
+	case Expiring:
+		return !now.Before(v.Expires)
+	case map[interface{}]interface{}:
+		compactValues(v, now)
+		return len(v) == 0
+	case map[string]interface{}:
+		for k, e := range v {
+			if compactValue(e, now) {
+				delete(v, k)
+			}
+		}
+		return len(v) == 0
+	}
+	return false
+}
+
+// encodedSize returns the length of the values as encoded by securecookie.GobEncoder, or -1 if
+// they can't be encoded.
+func encodedSize(values map[interface{}]interface{}) int {
+	b, err := securecookie.GobEncoder{}.Serialize(values)
+	if err != nil {
+		return -1
+	}
+	return len(b)
+}
+
+// compactingStore is a sessions.Store that compacts each session's values before saving it.
+type compactingStore struct {
+	sessions.Store
+	clock     Clock
+	onCompact func(r *http.Request, s *sessions.Session, saved int)
+}
+
+func (c compactingStore) unwrapStore() sessions.Store {
+	return c.Store
+}
+
+func (c compactingStore) Save(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	before := -1
+	if c.onCompact != nil {
+		before = encodedSize(s.Values)
+	}
+	compactValues(s.Values, c.clock.Now())
+	if before >= 0 {
+		if after := encodedSize(s.Values); after >= 0 && after < before {
+			c.onCompact(r, s, before-after)
+		}
+	}
+	return c.Store.Save(r, w, s)
+}
+
+// Compact returns an Option that compacts each bound session's values just before saving them,
+// countering the gradual growth of long-lived sessions. Compaction removes nil values, values
+// stored via SetExpiring that have lapsed according to the given Clock, and nested maps of values,
+// such as those grouping the values that one part of an application uses, left empty by such
+// removals. If the Clock is nil, it uses SystemClock.
+//
+// If onCompact is supplied, Compact calls it with the number of bytes that compaction saved
+// whenever it saves any, as measured by encoding the values with securecookie.GobEncoder before and
+// after compaction. Stores that encode values otherwise save a different amount.
+func Compact(clock Clock, onCompact func(r *http.Request, s *sessions.Session, saved int)) Option {
+	if clock == nil {
+		clock = SystemClock
+	}
+	return func(c *bindingConfig) {
+		c.decorators = append(c.decorators, func(r *http.Request, s *sessions.Session) *sessions.Session {
+			if s.Store() == nil {
+				return s
+			}
+			return rebindSession(s, compactingStore{s.Store(), clock, onCompact})
+		})
+	}
+}
@@ -0,0 +1,69 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"encoding/gob"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func init() {
+	gob.Register(map[string]interface{}{})
+	gob.Register(map[interface{}]interface{}{})
+}
+
+func TestExpiringValue(t *testing.T) {
+	now := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	s, _ := handler.NopSource{}.New(nil, "s")
+	handler.SetExpiring(s, "notice", "saved", now.Add(time.Minute))
+	if v, ok := handler.ExpiringValue(s, "notice", now); !ok || v != "saved" {
+		t.Errorf("before expiry: got (%v, %t), want (saved, true)", v, ok)
+	}
+	if v, ok := handler.ExpiringValue(s, "notice", now.Add(time.Minute)); ok {
+		t.Errorf("at expiry: got (%v, %t), want (nil, false)", v, ok)
+	}
+	if _, ok := handler.ExpiringValue(s, "absent", now); ok {
+		t.Error("absent value was reported as present")
+	}
+}
+
+func TestCompact(t *testing.T) {
+	now := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	var saved int
+	recorder := httptest.NewRecorder()
+	handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := handler.MustExtractSession(r)
+		session.Values["uid"] = "alice"
+		session.Values["gone"] = nil
+		session.Values["cart"] = map[string]interface{}{"coupon": nil}
+		session.Values["prefs"] = map[interface{}]interface{}{"theme": "dark", "draft": nil}
+		handler.SetExpiring(session, "notice", "saved", now)
+		handler.SetExpiring(session, "grant", "upload", now.Add(time.Minute))
+		if err := session.Save(r, w); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+		want := map[interface{}]interface{}{
+			"uid":   "alice",
+			"prefs": map[interface{}]interface{}{"theme": "dark"},
+			"grant": handler.Expiring{Value: "upload", Expires: now.Add(time.Minute)},
+		}
+		if got := session.Values; !reflect.DeepEqual(got, want) {
+			t.Errorf("values: got %v, want %v", got, want)
+		}
+	}), nil, handler.Compact(handler.ClockFunc(func() time.Time { return now }), func(_ *http.Request, _ *sessions.Session, n int) {
+		saved += n
+	})).ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+	if saved <= 0 {
+		t.Errorf("bytes saved: got %d, want a positive number", saved)
+	}
+}
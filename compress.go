@@ -0,0 +1,274 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"compress/gzip"
+	"io"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+// ContentEncoder compresses response bodies with a given content coding.
+type ContentEncoder struct {
+	// Name is the content coding, such as "gzip" or "br", as it appears in the Accept-Encoding and
+	// Content-Encoding headers.
+	Name string
+	// NewWriter returns a writer that compresses what's written to it into w, flushing any
+	// remaining compressed data upon Close. If the writer also has a Flush method returning an
+	// error, as *gzip.Writer does, Compress calls it when the handler flushes the response.
+	NewWriter func(w io.Writer) io.WriteCloser
+}
+
+// GzipEncoder returns a ContentEncoder for the "gzip" content coding at the given compression
+// level, per package compress/gzip. It panics if the level is invalid.
+func GzipEncoder(level int) ContentEncoder {
+	if _, err := gzip.NewWriterLevel(nil, level); err != nil {
+		panic(err)
+	}
+	return ContentEncoder{
+		Name: "gzip",
+		NewWriter: func(w io.Writer) io.WriteCloser {
+			zw, _ := gzip.NewWriterLevel(w, level)
+			return zw
+		},
+	}
+}
+
+// CompressionPolicy describes which responses Compress compresses, and how.
+type CompressionPolicy struct {
+	// Encoders lists the available content codings, in order of preference. Compress uses the
+	// first that the request accepts. If empty, Compress uses GzipEncoder with the default
+	// compression level. Encoders for other codings, such as Brotli, can be supplied by adapting
+	// their writers.
+	Encoders []ContentEncoder
+	// ContentTypes lists the media types eligible for compression, such as "application/json".
+	// An entry ending in "/", such as "text/", admits all subtypes of that type. If empty,
+	// Compress admits textual types and common textual application types: "text/",
+	// "application/json", "application/javascript", "application/xml", and "image/svg+xml".
+	ContentTypes []string
+	// MinSize is the smallest response body, in bytes, worth compressing. Compress buffers up to
+	// this much of each response before deciding. If not positive, Compress uses 1024.
+	MinSize int
+}
+
+func (p CompressionPolicy) encoders() []ContentEncoder {
+	if len(p.Encoders) != 0 {
+		return p.Encoders
+	}
+	return []ContentEncoder{GzipEncoder(gzip.DefaultCompression)}
+}
+
+func (p CompressionPolicy) contentTypes() []string {
+	if len(p.ContentTypes) != 0 {
+		return p.ContentTypes
+	}
+	return []string{"text/", "application/json", "application/javascript", "application/xml", "image/svg+xml"}
+}
+
+func (p CompressionPolicy) minSize() int {
+	if p.MinSize > 0 {
+		return p.MinSize
+	}
+	return 1024
+}
+
+// parseAcceptElement splits an element of an Accept or Accept-Encoding header into its value and
+// quality, which is 1 unless stated otherwise.
+func parseAcceptElement(part string) (name string, q float64) {
+	name, params, _ := strings.Cut(strings.TrimSpace(part), ";")
+	q = 1.0
+	for _, param := range strings.Split(params, ";") {
+		if k, v, ok := strings.Cut(strings.TrimSpace(param), "="); ok && strings.EqualFold(k, "q") {
+			if f, err := strconv.ParseFloat(v, 64); err == nil {
+				q = f
+			}
+		}
+	}
+	return strings.TrimSpace(name), q
+}
+
+// acceptsEncoding reports whether the Accept-Encoding header values admit the given content coding
+// with a nonzero quality.
+func acceptsEncoding(accept []string, coding string) bool {
+	wildcard := false
+	for _, v := range accept {
+		for _, part := range strings.Split(v, ",") {
+			switch name, q := parseAcceptElement(part); {
+			case strings.EqualFold(name, coding):
+				return q > 0
+			case name == "*":
+				wildcard = q > 0
+			}
+		}
+	}
+	return wildcard
+}
+
+// compressWriter is an http.ResponseWriter that buffers the start of the response until it can
+// decide whether to compress it.
+type compressWriter struct {
+	http.ResponseWriter
+	policy    *CompressionPolicy
+	types     []string
+	encoder   *ContentEncoder
+	status    int
+	buf       []byte
+	decided   bool
+	compress  io.WriteCloser
+	noContent bool
+}
+
+func (w *compressWriter) WriteHeader(code int) {
+	if w.decided || w.status != 0 {
+		return
+	}
+	if code < http.StatusOK {
+		// Pass informational responses through, awaiting the final one.
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+	if code == http.StatusNoContent || code == http.StatusNotModified {
+		w.noContent = true
+		w.decide()
+	}
+}
+
+func (w *compressWriter) eligibleType() bool {
+	ct := w.Header().Get("Content-Type")
+	if len(ct) == 0 {
+		// Settle the content type as net/http would, so that it reflects the uncompressed body.
+		ct = http.DetectContentType(w.buf)
+		w.Header().Set("Content-Type", ct)
+	}
+	mediaType, _, err := mime.ParseMediaType(ct)
+	if err != nil {
+		return false
+	}
+	for _, t := range w.types {
+		if mediaType == t || strings.HasSuffix(t, "/") && strings.HasPrefix(mediaType, t) {
+			return true
+		}
+	}
+	return false
+}
+
+// decide writes the response header, compressing the response if it's eligible, and then writes
+// any buffered body.
+func (w *compressWriter) decide() {
+	w.decided = true
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	h := w.Header()
+	// A Content-Range describes the uncompressed body.
+	partial := w.status == http.StatusPartialContent || len(h.Get("Content-Range")) != 0
+	if !w.noContent && !partial && len(h.Get("Content-Encoding")) == 0 && !strings.Contains(h.Get("Cache-Control"), "no-transform") && w.eligibleType() {
+		// The response's representation varies with Accept-Encoding even when it's too small to
+		// compress this time.
+		AddVary(h, "Accept-Encoding")
+		if w.encoder != nil && len(w.buf) >= w.policy.minSize() {
+			h.Set("Content-Encoding", w.encoder.Name)
+			h.Del("Content-Length")
+			if etag := h.Get("ETag"); !strings.HasPrefix(etag, "W/") && strings.HasSuffix(etag, `"`) {
+				// Distinguish the compressed representation from the uncompressed one.
+				h.Set("ETag", strings.TrimSuffix(etag, `"`)+"-"+w.encoder.Name+`"`)
+			}
+			w.ResponseWriter.WriteHeader(w.status)
+			w.compress = w.encoder.NewWriter(w.ResponseWriter)
+			w.compress.Write(w.buf)
+			w.buf = nil
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) != 0 {
+		w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+	}
+}
+
+func (w *compressWriter) Write(b []byte) (int, error) {
+	if !w.decided {
+		w.buf = append(w.buf, b...)
+		if len(w.buf) >= w.policy.minSize() {
+			w.decide()
+		}
+		return len(b), nil
+	}
+	if w.compress != nil {
+		return w.compress.Write(b)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, deciding whether to compress the response with what's buffered
+// so far, flushing any pending compressed data, and flushing the underlying http.ResponseWriter if
+// it supports doing so.
+func (w *compressWriter) Flush() {
+	if !w.decided {
+		w.decide()
+	}
+	if f, ok := w.compress.(interface{ Flush() error }); ok {
+		f.Flush()
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for use by http.ResponseController, such as to
+// set write deadlines.
+func (w *compressWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// finish completes the response once the handler returns.
+func (w *compressWriter) finish() {
+	if !w.decided {
+		w.decide()
+	}
+	if w.compress != nil {
+		w.compress.Close()
+	}
+}
+
+// Compress returns an HTTP handler that compresses the bodies of responses from the supplied
+// handler per the CompressionPolicy, using the most preferred content coding that the request
+// accepts. It compresses only responses with eligible content types and bodies at least as large as
+// the policy's minimum size, and leaves alone responses that are already encoded, carry partial
+// content or a Content-Range, bear the "no-transform" Cache-Control directive, or answer HEAD
+// requests. When it compresses a response, it removes its Content-Length header and marks any
+// strong ETag as specific to the coding.
+//
+// It buffers the start of each response until it decides whether to compress it, deferring the
+// writing of the response header until then, so it works in either order with WithSession and the
+// AutoSave Option: whichever is outermost, sessions are saved and their cookies are set before the
+// response header goes out. It panics if the supplied handler is nil.
+func Compress(p CompressionPolicy, h http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	encoders := p.encoders()
+	types := p.contentTypes()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method == http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		cw := &compressWriter{ResponseWriter: w, policy: &p, types: types}
+		accept := r.Header["Accept-Encoding"]
+		for i := range encoders {
+			if acceptsEncoding(accept, encoders[i].Name) {
+				cw.encoder = &encoders[i]
+				break
+			}
+		}
+		h.ServeHTTP(cw, r)
+		cw.finish()
+	})
+}
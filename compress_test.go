@@ -0,0 +1,158 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"compress/gzip"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestCompressPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.Compress(handler.CompressionPolicy{}, nil)
+}
+
+func TestCompress(t *testing.T) {
+	large := strings.Repeat("hello, world\n", 100)
+	tests := []struct {
+		description    string
+		acceptEncoding string
+		contentType    string
+		body           string
+		status         int
+		wantEncoding   string
+	}{
+		{"large text", "gzip, deflate", "text/plain; charset=utf-8", large, http.StatusOK, "gzip"},
+		{"sniffed type", "gzip", "", large, http.StatusOK, "gzip"},
+		{"error response", "gzip", "text/plain", large, http.StatusNotFound, "gzip"},
+		{"small text", "gzip", "text/plain", "hello", http.StatusOK, ""},
+		{"gzip not accepted", "br", "text/plain", large, http.StatusOK, ""},
+		{"gzip refused", "gzip;q=0, *", "text/plain", large, http.StatusOK, ""},
+		{"any coding accepted", "*", "text/plain", large, http.StatusOK, "gzip"},
+		{"ineligible type", "gzip", "image/png", large, http.StatusOK, ""},
+		{"no content", "gzip", "text/plain", "", http.StatusNoContent, ""},
+		{"partial content", "gzip", "text/plain", large, http.StatusPartialContent, ""},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			h := handler.Compress(handler.CompressionPolicy{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if len(test.contentType) != 0 {
+					w.Header().Set("Content-Type", test.contentType)
+				}
+				w.WriteHeader(test.status)
+				// Write in pieces, to exercise buffering below the minimum size.
+				for i := 0; i < len(test.body); i += 100 {
+					end := i + 100
+					if end > len(test.body) {
+						end = len(test.body)
+					}
+					w.Write([]byte(test.body[i:end]))
+				}
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", test.acceptEncoding)
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, r)
+			if recorder.Code != test.status {
+				t.Errorf("status: got %d, want %d", recorder.Code, test.status)
+			}
+			if got := recorder.Header().Get("Content-Encoding"); got != test.wantEncoding {
+				t.Fatalf("content encoding: got %q, want %q", got, test.wantEncoding)
+			}
+			body := recorder.Body.String()
+			if len(test.wantEncoding) != 0 {
+				zr, err := gzip.NewReader(recorder.Body)
+				if err != nil {
+					t.Fatalf("failed to read compressed body: %v", err)
+				}
+				b, err := ioutil.ReadAll(zr)
+				if err != nil {
+					t.Fatalf("failed to read compressed body: %v", err)
+				}
+				body = string(b)
+				if got := recorder.Header().Get("Vary"); got != "Accept-Encoding" {
+					t.Errorf("vary: got %q, want %q", got, "Accept-Encoding")
+				}
+			}
+			if body != test.body {
+				t.Errorf("body: got %d bytes, want %d", len(body), len(test.body))
+			}
+		})
+	}
+}
+
+func TestCompressWithValidatorsAndRanges(t *testing.T) {
+	large := strings.Repeat("hello, world\n", 100)
+	for _, test := range []struct {
+		description  string
+		header       http.Header
+		status       int
+		wantEncoding string
+		wantETag     string
+	}{
+		{"strong ETag", http.Header{"Etag": {`"v1"`}}, http.StatusOK, "gzip", `"v1-gzip"`},
+		{"weak ETag", http.Header{"Etag": {`W/"v1"`}}, http.StatusOK, "gzip", `W/"v1"`},
+		{"unsatisfiable range", http.Header{"Content-Range": {"bytes */1300"}}, http.StatusRequestedRangeNotSatisfiable, "", ""},
+		{"partial content", http.Header{"Content-Range": {"bytes 0-1299/2600"}, "Etag": {`"v1"`}}, http.StatusPartialContent, "", `"v1"`},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			h := handler.Compress(handler.CompressionPolicy{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				for k, vs := range test.header {
+					w.Header()[k] = vs
+				}
+				w.Header().Set("Content-Type", "text/plain")
+				w.WriteHeader(test.status)
+				w.Write([]byte(large))
+			}))
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, r)
+			if got := recorder.Header().Get("Content-Encoding"); got != test.wantEncoding {
+				t.Errorf("content encoding: got %q, want %q", got, test.wantEncoding)
+			}
+			if got := recorder.Header().Get("ETag"); got != test.wantETag {
+				t.Errorf("ETag: got %q, want %q", got, test.wantETag)
+			}
+		})
+	}
+}
+
+func TestCompressWithAutoSave(t *testing.T) {
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.MustExtractSession(r).Values["uid"] = "alice"
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(strings.Repeat("hello, world\n", 100)))
+	})
+	for _, test := range []struct {
+		description string
+		h           http.Handler
+	}{
+		{"compression outermost", handler.Compress(handler.CompressionPolicy{}, handler.WithSession("s", store, app, nil, handler.AutoSave(nil)))},
+		{"session outermost", handler.WithSession("s", store, handler.Compress(handler.CompressionPolicy{}, app), nil, handler.AutoSave(nil))},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Header.Set("Accept-Encoding", "gzip")
+			recorder := httptest.NewRecorder()
+			test.h.ServeHTTP(recorder, r)
+			result := recorder.Result()
+			if got := result.Header.Get("Content-Encoding"); got != "gzip" {
+				t.Errorf("content encoding: got %q, want %q", got, "gzip")
+			}
+			if cookies := result.Cookies(); len(cookies) != 1 || cookies[0].Name != "s" {
+				t.Errorf("cookies: got %v, want session cookie", cookies)
+			}
+		})
+	}
+}
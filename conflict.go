@@ -0,0 +1,147 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"sort"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// cookieRecordingStore is a sessions.Store that records the Set-Cookie header values added by
+// saving a single session.
+type cookieRecordingStore struct {
+	sessions.Store
+	set map[string]bool
+}
+
+func (c *cookieRecordingStore) unwrapStore() sessions.Store {
+	return c.Store
+}
+
+func (c *cookieRecordingStore) Save(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	before := len(w.Header()["Set-Cookie"])
+	if err := c.Store.Save(r, w, s); err != nil {
+		return err
+	}
+	if values := w.Header()["Set-Cookie"]; len(values) > before {
+		for _, v := range values[before:] {
+			c.set[v] = true
+		}
+	}
+	return nil
+}
+
+// cookieUse records the ways in which a response sets cookies bearing a given name.
+type cookieUse struct {
+	scopes  map[string]bool
+	setters map[string]bool
+}
+
+func sortedKeys(m map[string]bool) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// cookieScope describes the domain and path to which a cookie applies.
+func cookieScope(c *http.Cookie) string {
+	domain := strings.ToLower(strings.TrimPrefix(c.Domain, "."))
+	if len(domain) == 0 {
+		domain = "host-only"
+	}
+	return fmt.Sprintf("Domain=%s; Path=%s", domain, c.Path)
+}
+
+// otherSetter labels cookies set other than by saving a bound session.
+const otherSetter = "(other)"
+
+// DetectCookieConflicts returns an Option that inspects the cookies that each response sets just
+// before its header is written, and reports those bearing the same name that would confuse
+// clients: cookies with differing Domain or Path attributes, which clients keep side by side and
+// send together, leaving the store to read whichever comes first, and cookies set both by saving
+// different bound sessions, or by saving a bound session and by other middleware, of which clients
+// keep only the last. Saving the same session repeatedly, and deleting cookies, as SharedDomain does
+// to clean up host-only cookies, are not conflicts.
+//
+// It calls onConflict with an error matching ErrCookieConflict for each conflicting name, and
+// counts the error as a ConflictError per CountErrors. If onConflict is nil, it logs the error via
+// log.Printf instead. By then it's too late to alter the response status. Supply it after any
+// other Options that set cookies, such as SameSiteNoneFallback, so that it sees their cookies
+// too. It sees cookies set by outer middleware only if they set them before the header is
+// written.
+func DetectCookieConflicts(onConflict func(r *http.Request, err error)) Option {
+	if onConflict == nil {
+		onConflict = func(r *http.Request, err error) {
+			log.Printf("handler: %v in response to request for %s", err, r.URL.Path)
+		}
+	}
+	return func(c *bindingConfig) {
+		c.decorators = append(c.decorators, func(r *http.Request, s *sessions.Session) *sessions.Session {
+			if s.Store() == nil {
+				return s
+			}
+			return rebindSession(s, &cookieRecordingStore{Store: s.Store(), set: make(map[string]bool)})
+		})
+		c.responseHooks = append(c.responseHooks, func(h http.Header, r *http.Request, bound []*sessions.Session) {
+			setters := make(map[string]string)
+			for _, s := range bound {
+				findStore(s, func(store sessions.Store) bool {
+					rec, ok := store.(*cookieRecordingStore)
+					if ok {
+						for v := range rec.set {
+							setters[v] = fmt.Sprintf("session %q", s.Name())
+						}
+					}
+					return ok
+				})
+			}
+			now := SystemClock.Now()
+			uses := make(map[string]*cookieUse)
+			for _, raw := range h["Set-Cookie"] {
+				cookies := (&http.Response{Header: http.Header{"Set-Cookie": {raw}}}).Cookies()
+				if len(cookies) != 1 {
+					continue
+				}
+				cookie := cookies[0]
+				if cookie.MaxAge < 0 || !cookie.Expires.IsZero() && !cookie.Expires.After(now) {
+					continue
+				}
+				use, ok := uses[cookie.Name]
+				if !ok {
+					use = &cookieUse{make(map[string]bool), make(map[string]bool)}
+					uses[cookie.Name] = use
+				}
+				use.scopes[cookieScope(cookie)] = true
+				setter, ok := setters[raw]
+				if !ok {
+					setter = otherSetter
+				}
+				use.setters[setter] = true
+			}
+			names := make([]string, 0, len(uses))
+			for name := range uses {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+			for _, name := range names {
+				use := uses[name]
+				if len(use.scopes) < 2 && len(use.setters) < 2 {
+					continue
+				}
+				err := fmt.Errorf("%w: cookie %q set by %s with scopes %s", ErrCookieConflict, name,
+					strings.Join(sortedKeys(use.setters), ", "), strings.Join(sortedKeys(use.scopes), ", "))
+				c.countError(name, err)
+				onConflict(r, err)
+			}
+		})
+	}
+}
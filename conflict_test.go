@@ -0,0 +1,155 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+// fixedCookieStore is a sessions.Store that saves every session in a cookie bearing the same name,
+// regardless of the session's name.
+type fixedCookieStore struct {
+	cookieName string
+}
+
+func (s fixedCookieStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s fixedCookieStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.IsNew = true
+	return session, nil
+}
+
+func (s fixedCookieStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	http.SetCookie(w, &http.Cookie{Name: s.cookieName, Value: session.Name(), Path: "/"})
+	return nil
+}
+
+func TestDetectCookieConflicts(t *testing.T) {
+	tests := []struct {
+		description string
+		names       []string
+		store       func() sessions.Store
+		serve       func(w http.ResponseWriter, r *http.Request)
+		want        []string
+	}{
+		{
+			"single save",
+			[]string{"s"},
+			nil,
+			func(w http.ResponseWriter, r *http.Request) {
+				handler.MustExtractSessionNamed("s", r).Save(r, w)
+			},
+			nil,
+		},
+		{
+			"repeated save",
+			[]string{"s"},
+			nil,
+			func(w http.ResponseWriter, r *http.Request) {
+				s := handler.MustExtractSessionNamed("s", r)
+				s.Save(r, w)
+				s.Values["k"] = "v"
+				s.Save(r, w)
+			},
+			nil,
+		},
+		{
+			"saves with differing paths",
+			[]string{"s"},
+			nil,
+			func(w http.ResponseWriter, r *http.Request) {
+				s := handler.MustExtractSessionNamed("s", r)
+				s.Save(r, w)
+				s.Options.Path = "/app"
+				s.Save(r, w)
+			},
+			[]string{`"s"`, "Path=/,", "Path=/app"},
+		},
+		{
+			"session and other middleware",
+			[]string{"s"},
+			nil,
+			func(w http.ResponseWriter, r *http.Request) {
+				http.SetCookie(w, &http.Cookie{Name: "s", Value: "other", Path: "/"})
+				handler.MustExtractSessionNamed("s", r).Save(r, w)
+			},
+			[]string{`"s"`, `(other), session "s"`},
+		},
+		{
+			"deletion with differing domain",
+			[]string{"s"},
+			nil,
+			func(w http.ResponseWriter, r *http.Request) {
+				s := handler.MustExtractSessionNamed("s", r)
+				s.Options.Domain = "example.com"
+				s.Save(r, w)
+				http.SetCookie(w, &http.Cookie{Name: "s", Path: "/", MaxAge: -1})
+			},
+			nil,
+		},
+		{
+			"distinct sessions",
+			[]string{"a", "b"},
+			func() sessions.Store {
+				return fixedCookieStore{"shared"}
+			},
+			func(w http.ResponseWriter, r *http.Request) {
+				handler.MustExtractSessionNamed("a", r).Save(r, w)
+				handler.MustExtractSessionNamed("b", r).Save(r, w)
+			},
+			[]string{`"shared"`, `session "a", session "b"`},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var store sessions.Store = sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+			if test.store != nil {
+				store = test.store()
+			}
+			var reported []error
+			var counted int64
+			h := handler.WithSessionsNamed(test.names, store, http.HandlerFunc(test.serve), nil,
+				handler.DetectCookieConflicts(func(r *http.Request, err error) {
+					reported = append(reported, err)
+				}),
+				handler.CountErrors(func(category handler.ErrorCategory, _ string) handler.Counter {
+					if category != handler.ConflictError {
+						t.Errorf("counted error category: got %v, want %v", category, handler.ConflictError)
+					}
+					return handler.CounterFunc(func(delta int64) { counted += delta })
+				}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/", nil))
+
+			if len(test.want) == 0 {
+				if len(reported) != 0 || counted != 0 {
+					t.Errorf("got %v reported and %d counted, want none", reported, counted)
+				}
+				return
+			}
+			if len(reported) != 1 || counted != 1 {
+				t.Fatalf("got %v reported and %d counted, want one each", reported, counted)
+			}
+			err := reported[0]
+			if !errors.Is(err, handler.ErrCookieConflict) {
+				t.Errorf("error: got %v, want a cookie conflict", err)
+			}
+			for _, s := range test.want {
+				if !strings.Contains(err.Error(), s) {
+					t.Errorf("error: got %q, want it to mention %q", err, s)
+				}
+			}
+		})
+	}
+}
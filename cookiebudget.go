@@ -0,0 +1,121 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"fmt"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// CookieBudgetPolicy decides whether to keep the cookies set by saving the named session, given
+// that they would grow the cookies that the client holds to size bytes, beyond the budget that
+// CookieBudget enforces. Returning nil keeps the cookies regardless; returning an error discards
+// them, failing the save with that error.
+type CookieBudgetPolicy func(r *http.Request, name string, size, budget int) error
+
+// RejectOverBudget is a CookieBudgetPolicy that discards the cookies of every session whose saving
+// exceeds the budget, failing the save with an error matching ErrCookieBudgetExceeded.
+func RejectOverBudget(r *http.Request, name string, size, budget int) error {
+	return fmt.Errorf("%w: saving session %q would grow cookies to %d bytes, beyond %d",
+		ErrCookieBudgetExceeded, name, size, budget)
+}
+
+// cookieJarSize estimates the size in bytes of the Cookie header that the client will send in its
+// next request, having sent the cookies in the given request and received those set in the given
+// response headers, in order. It ignores the cookies' Domain and Path attributes.
+func cookieJarSize(r *http.Request, headers ...http.Header) int {
+	jar := make(map[string]int)
+	for _, c := range r.Cookies() {
+		jar[c.Name] = len(c.Name) + 1 + len(c.Value)
+	}
+	now := SystemClock.Now()
+	for _, h := range headers {
+		for _, c := range (&http.Response{Header: h}).Cookies() {
+			if c.MaxAge < 0 || !c.Expires.IsZero() && !c.Expires.After(now) {
+				delete(jar, c.Name)
+			} else {
+				jar[c.Name] = len(c.Name) + 1 + len(c.Value)
+			}
+		}
+	}
+	if len(jar) == 0 {
+		return 0
+	}
+	size := 2 * (len(jar) - 1) // Separators
+	for _, n := range jar {
+		size += n
+	}
+	return size
+}
+
+// budgetingStore is a sessions.Store that saves a single session into a scratch header, and copies
+// the cookies so set to the response only if they fit within the budget, or the policy allows them.
+type budgetingStore struct {
+	sessions.Store
+	budget int
+	policy CookieBudgetPolicy
+}
+
+func (b *budgetingStore) unwrapStore() sessions.Store {
+	return b.Store
+}
+
+func (b *budgetingStore) Save(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	scratch := make(http.Header)
+	if err := b.Store.Save(r, headerWriter(scratch), s); err != nil {
+		return err
+	}
+	h := w.Header()
+	var err error
+	if len(scratch["Set-Cookie"]) != 0 {
+		before := cookieJarSize(r, h)
+		if after := cookieJarSize(r, h, scratch); after > b.budget && after > before {
+			err = b.policy(r, s.Name(), after, b.budget)
+		}
+	}
+	for k, vs := range scratch {
+		if err != nil && k == "Set-Cookie" {
+			continue
+		}
+		for _, v := range vs {
+			h.Add(k, v)
+		}
+	}
+	return err
+}
+
+// CookieBudget returns an Option that limits the space that cookies occupy on the client to the
+// given number of bytes, since browsers cap the space available to each domain's cookies, and
+// servers cap the size of the Cookie request header, typically at around 8 KB; beyond those caps,
+// browsers drop cookies or servers reject requests, with no sign to the application.
+//
+// Upon saving each bound session, it projects the size of the Cookie header that the client will
+// send next: the cookies that the request carried, including those unrelated to sessions, as
+// replaced or removed by those that the response sets so far, ignoring their Domain and Path
+// attributes. If the cookies that saving the session sets would grow that size beyond the budget,
+// it calls the policy to decide whether to keep them, or, if the policy is nil, discards them per
+// RejectOverBudget. Saves that shrink the cookies or leave their size unchanged always proceed.
+//
+// Discarding a session's cookies leaves the client bearing its previous cookies, if any. Stores that
+// keep values server-side, such as kvstore.Store, still retain the values so saved, but a fresh
+// session is lost. AutoSave reports the resulting errors to its onError function, and counts them
+// as an OversizedError per CountErrors. It panics if the budget is not positive.
+func CookieBudget(budget int, policy CookieBudgetPolicy) Option {
+	if budget <= 0 {
+		panic("cookie budget must be positive")
+	}
+	if policy == nil {
+		policy = RejectOverBudget
+	}
+	return func(c *bindingConfig) {
+		c.decorators = append(c.decorators, func(r *http.Request, s *sessions.Session) *sessions.Session {
+			if s.Store() == nil {
+				return s
+			}
+			return rebindSession(s, &budgetingStore{Store: s.Store(), budget: budget, policy: policy})
+		})
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestCookieBudgetPanicsWithNonPositiveBudget(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.CookieBudget(0, nil)
+}
+
+func TestCookieBudget(t *testing.T) {
+	const budget = 1000
+	big := &http.Cookie{Name: "big", Value: strings.Repeat("x", budget+50)}
+	tests := []struct {
+		description string
+		carryBig    bool
+		allow       bool
+		serve       func(w http.ResponseWriter, r *http.Request) []error
+		wantErrs    []bool
+		wantCookies []string
+	}{
+		{
+			"within budget",
+			false, false,
+			func(w http.ResponseWriter, r *http.Request) []error {
+				return []error{handler.MustExtractSessionNamed("a", r).Save(r, w)}
+			},
+			[]bool{false},
+			[]string{"a"},
+		},
+		{
+			"beyond budget",
+			true, false,
+			func(w http.ResponseWriter, r *http.Request) []error {
+				return []error{handler.MustExtractSessionNamed("a", r).Save(r, w)}
+			},
+			[]bool{true},
+			nil,
+		},
+		{
+			"beyond budget with permissive policy",
+			true, true,
+			func(w http.ResponseWriter, r *http.Request) []error {
+				return []error{handler.MustExtractSessionNamed("a", r).Save(r, w)}
+			},
+			[]bool{false},
+			[]string{"a"},
+		},
+		{
+			"shrinking beyond budget",
+			true, false,
+			func(w http.ResponseWriter, r *http.Request) []error {
+				s := handler.MustExtractSessionNamed("a", r)
+				s.Options.MaxAge = -1
+				return []error{s.Save(r, w)}
+			},
+			[]bool{false},
+			[]string{"a"},
+		},
+		{
+			"second session beyond budget",
+			false, false,
+			func(w http.ResponseWriter, r *http.Request) []error {
+				a := handler.MustExtractSessionNamed("a", r)
+				b := handler.MustExtractSessionNamed("b", r)
+				b.Values["k"] = strings.Repeat("v", budget/2)
+				return []error{a.Save(r, w), b.Save(r, w)}
+			},
+			[]bool{false, true},
+			[]string{"a"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var policyCalls int
+			var policy handler.CookieBudgetPolicy
+			if test.allow {
+				policy = func(r *http.Request, name string, size, budget int) error {
+					policyCalls++
+					if size <= budget {
+						t.Errorf("policy called for size %d within budget %d", size, budget)
+					}
+					return nil
+				}
+			}
+			store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+			var errs []error
+			h := handler.WithSessionsNamed([]string{"a", "b"}, store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				errs = test.serve(w, r)
+			}), nil, handler.CookieBudget(budget, policy))
+			req := httptest.NewRequest(http.MethodGet, "/", nil)
+			if test.carryBig {
+				req.AddCookie(big)
+			}
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, req)
+
+			for i, err := range errs {
+				if got := errors.Is(err, handler.ErrCookieBudgetExceeded); got != test.wantErrs[i] {
+					t.Errorf("save %d error: got %v, want beyond budget: %t", i, err, test.wantErrs[i])
+				}
+			}
+			var names []string
+			for _, c := range recorder.Result().Cookies() {
+				names = append(names, c.Name)
+			}
+			if got, want := strings.Join(names, ","), strings.Join(test.wantCookies, ","); got != want {
+				t.Errorf("cookies set: got %q, want %q", got, want)
+			}
+			if test.allow && policyCalls != 1 {
+				t.Errorf("policy calls: got %d, want 1", policyCalls)
+			}
+		})
+	}
+}
+
+func TestCookieBudgetWithAutoSave(t *testing.T) {
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	var reported error
+	counts := make(map[handler.ErrorCategory]int64)
+	h := handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.MustExtractSession(r).Values["k"] = strings.Repeat("v", 200)
+	}), nil,
+		handler.AutoSave(func(r *http.Request, s *sessions.Session, err error) { reported = err }),
+		handler.CookieBudget(100, nil),
+		handler.CountErrors(func(category handler.ErrorCategory, _ string) handler.Counter {
+			return handler.CounterFunc(func(delta int64) { counts[category] += delta })
+		}))
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+
+	if !errors.Is(reported, handler.ErrCookieBudgetExceeded) {
+		t.Errorf("reported error: got %v, want beyond budget", reported)
+	}
+	if got, want := counts[handler.OversizedError], int64(1); got != want {
+		t.Errorf("oversized errors counted: got %d, want %d", got, want)
+	}
+	if cookies := recorder.Result().Cookies(); len(cookies) != 0 {
+		t.Errorf("cookies set: got %v, want none", cookies)
+	}
+}
@@ -0,0 +1,188 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// CORSPolicy describes which cross-origin requests CORS permits.
+type CORSPolicy struct {
+	// AllowedOrigins lists the origins permitted to make cross-origin requests, such as
+	// "https://app.example.com". An origin whose host begins with "*.", such as
+	// "https://*.example.com", permits all subdomains of the rest of the host under that scheme and
+	// port, but not the host itself. The sole origin "*" permits all origins, but may not be
+	// combined with AllowCredentials.
+	AllowedOrigins []string
+	// AllowedMethods lists the methods permitted in cross-origin requests. If empty, CORS permits
+	// GET, HEAD, and POST.
+	AllowedMethods []string
+	// AllowedHeaders lists the request headers permitted in cross-origin requests, beyond those
+	// that browsers always permit.
+	AllowedHeaders []string
+	// ExposedHeaders lists the response headers that browsers may reveal to the requesting page,
+	// beyond those that browsers always reveal.
+	ExposedHeaders []string
+	// MaxAge, if positive, is how long browsers may cache the outcome of a preflight request.
+	MaxAge time.Duration
+	// AllowCredentials permits cross-origin requests to bear cookies, such as those carrying
+	// sessions, and permits the requesting page to read the responses to such requests.
+	AllowCredentials bool
+}
+
+// originPattern matches origins, either exactly or, if wildcard is true, those with a scheme
+// equal to prefix and a host ending in suffix.
+type originPattern struct {
+	prefix   string
+	suffix   string
+	wildcard bool
+}
+
+func (p originPattern) matches(origin string) bool {
+	if !p.wildcard {
+		return origin == p.prefix
+	}
+	return len(origin) > len(p.prefix)+len(p.suffix) && strings.HasPrefix(origin, p.prefix) && strings.HasSuffix(origin, p.suffix)
+}
+
+func parseOriginPattern(s string) (originPattern, bool) {
+	i := strings.Index(s, "://*.")
+	if i < 0 {
+		origin, ok := normalizeOrigin(s)
+		return originPattern{prefix: origin}, ok
+	}
+	// Normalize the pattern as though its wildcard were a concrete label.
+	origin, ok := normalizeOrigin(s[:i] + "://x." + s[i+len("://*."):])
+	if !ok {
+		return originPattern{}, false
+	}
+	j := strings.Index(origin, "://x.") + len("://")
+	return originPattern{prefix: origin[:j], suffix: origin[j+1:], wildcard: true}, true
+}
+
+// CORS returns an HTTP handler that permits cross-origin requests from the origins allowed by the
+// CORSPolicy, designed for applications whose sessions ride in cookies. It answers preflight
+// requests itself, rejecting those from disallowed origins or for disallowed methods or headers
+// with HTTP status code 403, and adds the appropriate headers to responses to other requests from
+// allowed origins before delegating them to the supplied handler. It delegates requests from
+// disallowed origins to the supplied handler without such headers, leaving browsers to withhold
+// the responses from the requesting pages.
+//
+// When the CORSPolicy allows credentials, it echoes the requesting origin in the
+// Access-Control-Allow-Origin header and sends the Access-Control-Allow-Credentials header
+// automatically. It panics if the supplied handler is nil, if any of the allowed origins is
+// malformed, or if the CORSPolicy allows both all origins and credentials, which browsers reject.
+func CORS(p CORSPolicy, h http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	var patterns []originPattern
+	anyOrigin := false
+	for _, o := range p.AllowedOrigins {
+		if o == "*" {
+			anyOrigin = true
+			continue
+		}
+		pattern, ok := parseOriginPattern(o)
+		if !ok {
+			panic("allowed origin " + o + " is not an absolute URL")
+		}
+		patterns = append(patterns, pattern)
+	}
+	if anyOrigin && p.AllowCredentials {
+		panic(`CORS policy may not allow credentials from all origins ("*")`)
+	}
+	methods := p.AllowedMethods
+	if len(methods) == 0 {
+		methods = []string{http.MethodGet, http.MethodHead, http.MethodPost}
+	}
+	allowedMethods := make(map[string]struct{}, len(methods))
+	for _, m := range methods {
+		allowedMethods[strings.ToUpper(m)] = struct{}{}
+	}
+	allowedHeaders := make(map[string]struct{}, len(p.AllowedHeaders))
+	for _, name := range p.AllowedHeaders {
+		allowedHeaders[http.CanonicalHeaderKey(name)] = struct{}{}
+	}
+	allowed := func(origin string) bool {
+		if anyOrigin {
+			return true
+		}
+		normalized, ok := normalizeOrigin(origin)
+		if !ok {
+			return false
+		}
+		for _, pattern := range patterns {
+			if pattern.matches(normalized) {
+				return true
+			}
+		}
+		return false
+	}
+	allowOrigin := func(header http.Header, origin string) {
+		if anyOrigin {
+			header.Set("Access-Control-Allow-Origin", "*")
+			return
+		}
+		header.Set("Access-Control-Allow-Origin", origin)
+		if p.AllowCredentials {
+			header.Set("Access-Control-Allow-Credentials", "true")
+		}
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if len(origin) == 0 {
+			h.ServeHTTP(w, r)
+			return
+		}
+		header := w.Header()
+		header.Add("Vary", "Origin")
+		requestedMethod := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != http.MethodOptions || len(requestedMethod) == 0 {
+			if allowed(origin) {
+				allowOrigin(header, origin)
+				if len(p.ExposedHeaders) != 0 {
+					header.Set("Access-Control-Expose-Headers", strings.Join(p.ExposedHeaders, ", "))
+				}
+			}
+			h.ServeHTTP(w, r)
+			return
+		}
+		header.Add("Vary", "Access-Control-Request-Method")
+		header.Add("Vary", "Access-Control-Request-Headers")
+		if !allowed(origin) {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if _, ok := allowedMethods[strings.ToUpper(requestedMethod)]; !ok {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		var requestedHeaders []string
+		for _, v := range r.Header["Access-Control-Request-Headers"] {
+			for _, name := range strings.Split(v, ",") {
+				if name = strings.TrimSpace(name); len(name) == 0 {
+					continue
+				}
+				if _, ok := allowedHeaders[http.CanonicalHeaderKey(name)]; !ok {
+					w.WriteHeader(http.StatusForbidden)
+					return
+				}
+				requestedHeaders = append(requestedHeaders, name)
+			}
+		}
+		allowOrigin(header, origin)
+		header.Set("Access-Control-Allow-Methods", strings.Join(methods, ", "))
+		if len(requestedHeaders) != 0 {
+			header.Set("Access-Control-Allow-Headers", strings.Join(requestedHeaders, ", "))
+		}
+		if p.MaxAge > 0 {
+			header.Set("Access-Control-Max-Age", strconv.FormatInt(int64(p.MaxAge/time.Second), 10))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
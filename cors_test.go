@@ -0,0 +1,152 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+func TestCORSPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.CORS(handler.CORSPolicy{}, nil)
+}
+
+func TestCORSPanicsWithCredentialsFromAllOrigins(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.CORS(handler.CORSPolicy{AllowedOrigins: []string{"*"}, AllowCredentials: true}, http.NotFoundHandler())
+}
+
+func TestCORSPanicsWithMalformedOrigin(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.CORS(handler.CORSPolicy{AllowedOrigins: []string{"example.com"}}, http.NotFoundHandler())
+}
+
+func TestCORS(t *testing.T) {
+	h := handler.CORS(handler.CORSPolicy{
+		AllowedOrigins:   []string{"https://app.example.com", "https://*.example.org"},
+		AllowedMethods:   []string{http.MethodGet, http.MethodPut},
+		AllowedHeaders:   []string{"X-CSRF-Token"},
+		ExposedHeaders:   []string{"X-Request-ID"},
+		MaxAge:           10 * time.Minute,
+		AllowCredentials: true,
+	}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusTeapot)
+	}))
+	serve := func(method, origin string, header http.Header) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(method, "https://api.example.com/", nil)
+		for name, values := range header {
+			r.Header[name] = values
+		}
+		if len(origin) != 0 {
+			r.Header.Set("Origin", origin)
+		}
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		return recorder
+	}
+	expectHeaders := func(t *testing.T, header http.Header, want map[string]string) {
+		t.Helper()
+		for name, want := range want {
+			if got := header.Get(name); got != want {
+				t.Errorf("%s: got %q, want %q", name, got, want)
+			}
+		}
+	}
+
+	t.Run("same origin", func(t *testing.T) {
+		recorder := serve(http.MethodGet, "", nil)
+		if got, want := recorder.Code, http.StatusTeapot; got != want {
+			t.Errorf("status code: got %d, want %d", got, want)
+		}
+		expectHeaders(t, recorder.Header(), map[string]string{"Access-Control-Allow-Origin": ""})
+	})
+	t.Run("allowed origin", func(t *testing.T) {
+		recorder := serve(http.MethodGet, "https://app.example.com", nil)
+		if got, want := recorder.Code, http.StatusTeapot; got != want {
+			t.Errorf("status code: got %d, want %d", got, want)
+		}
+		expectHeaders(t, recorder.Header(), map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Expose-Headers":    "X-Request-ID",
+			"Vary":                             "Origin",
+		})
+	})
+	t.Run("wildcard origin", func(t *testing.T) {
+		for origin, want := range map[string]string{
+			"https://a.example.org":          "https://a.example.org",
+			"https://a.b.example.org":        "https://a.b.example.org",
+			"https://example.org":            "",
+			"http://a.example.org":           "",
+			"https://a.example.org.evil.com": "",
+		} {
+			got := serve(http.MethodGet, origin, nil).Header().Get("Access-Control-Allow-Origin")
+			if got != want {
+				t.Errorf("allowed origin for %q: got %q, want %q", origin, got, want)
+			}
+		}
+	})
+	t.Run("disallowed origin", func(t *testing.T) {
+		recorder := serve(http.MethodGet, "https://evil.example.com", nil)
+		if got, want := recorder.Code, http.StatusTeapot; got != want {
+			t.Errorf("status code: got %d, want %d", got, want)
+		}
+		expectHeaders(t, recorder.Header(), map[string]string{
+			"Access-Control-Allow-Origin":      "",
+			"Access-Control-Allow-Credentials": "",
+		})
+	})
+	t.Run("preflight", func(t *testing.T) {
+		recorder := serve(http.MethodOptions, "https://app.example.com", http.Header{
+			"Access-Control-Request-Method":  {http.MethodPut},
+			"Access-Control-Request-Headers": {"x-csrf-token"},
+		})
+		if got, want := recorder.Code, http.StatusNoContent; got != want {
+			t.Errorf("status code: got %d, want %d", got, want)
+		}
+		expectHeaders(t, recorder.Header(), map[string]string{
+			"Access-Control-Allow-Origin":      "https://app.example.com",
+			"Access-Control-Allow-Credentials": "true",
+			"Access-Control-Allow-Methods":     "GET, PUT",
+			"Access-Control-Allow-Headers":     "x-csrf-token",
+			"Access-Control-Max-Age":           "600",
+		})
+	})
+	for description, header := range map[string]http.Header{
+		"preflight with disallowed method": {"Access-Control-Request-Method": {http.MethodDelete}},
+		"preflight with disallowed header": {"Access-Control-Request-Method": {http.MethodGet}, "Access-Control-Request-Headers": {"X-Other"}},
+	} {
+		t.Run(description, func(t *testing.T) {
+			recorder := serve(http.MethodOptions, "https://app.example.com", header)
+			if got, want := recorder.Code, http.StatusForbidden; got != want {
+				t.Errorf("status code: got %d, want %d", got, want)
+			}
+			expectHeaders(t, recorder.Header(), map[string]string{"Access-Control-Allow-Origin": ""})
+		})
+	}
+	t.Run("preflight from disallowed origin", func(t *testing.T) {
+		recorder := serve(http.MethodOptions, "https://evil.example.com", http.Header{"Access-Control-Request-Method": {http.MethodGet}})
+		if got, want := recorder.Code, http.StatusForbidden; got != want {
+			t.Errorf("status code: got %d, want %d", got, want)
+		}
+	})
+}
+
+func TestCORSWithAllOrigins(t *testing.T) {
+	recorder := httptest.NewRecorder()
+	r := httptest.NewRequest("", "/", nil)
+	r.Header.Set("Origin", "https://anywhere.example")
+	handler.CORS(handler.CORSPolicy{AllowedOrigins: []string{"*"}}, http.NotFoundHandler()).ServeHTTP(recorder, r)
+	if got, want := recorder.Header().Get("Access-Control-Allow-Origin"), "*"; got != want {
+		t.Errorf("allowed origin: got %q, want %q", got, want)
+	}
+	if got := recorder.Header().Get("Access-Control-Allow-Credentials"); len(got) != 0 {
+		t.Errorf("allowed credentials: got %q", got)
+	}
+}
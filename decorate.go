@@ -0,0 +1,42 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"github.com/gorilla/sessions"
+)
+
+// rebindSession returns a session identical to s, sharing its values and options, except that it
+// belongs to the given store, so that saving it goes through that store. Since a session's store
+// can't be changed once created, this is how a binding Option interposes on saving.
+func rebindSession(s *sessions.Session, store sessions.Store) *sessions.Session {
+	c := sessions.NewSession(store, s.Name())
+	c.ID = s.ID
+	c.Values = s.Values
+	c.Options = s.Options
+	c.IsNew = s.IsNew
+	return c
+}
+
+// storeWrapper is implemented by the sessions.Store decorators that binding Options interpose, so
+// that helpers can find a particular decorator among several.
+type storeWrapper interface {
+	unwrapStore() sessions.Store
+}
+
+// findStore calls match with each store in the chain of decorators ending at the session's store,
+// outermost first, until it returns true, reporting whether it did so.
+func findStore(s *sessions.Session, match func(sessions.Store) bool) bool {
+	for store := s.Store(); store != nil; {
+		if match(store) {
+			return true
+		}
+		w, ok := store.(storeWrapper)
+		if !ok {
+			break
+		}
+		store = w.unwrapStore()
+	}
+	return false
+}
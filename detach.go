@@ -0,0 +1,174 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+
+	"github.com/gorilla/sessions"
+)
+
+// copiedRef identifies a value that deepCopy copied by its type and address, since values of
+// different types, such as a struct and its first field, may share an address.
+type copiedRef struct {
+	t reflect.Type
+	p uintptr
+}
+
+// deepCopy returns a copy of v sharing no mutable storage reachable through maps, slices, arrays,
+// pointers, interfaces, or exported struct fields. It preserves aliasing among pointers, maps, and
+// slices of the same type within v, and hence tolerates cycles.
+func deepCopy(v reflect.Value, seen map[copiedRef]reflect.Value) reflect.Value {
+	switch v.Kind() {
+	case reflect.Interface:
+		if v.IsNil() {
+			return v
+		}
+		c := reflect.New(v.Type()).Elem()
+		c.Set(deepCopy(v.Elem(), seen))
+		return c
+	case reflect.Ptr:
+		if v.IsNil() {
+			return v
+		}
+		ref := copiedRef{v.Type(), v.Pointer()}
+		if c, ok := seen[ref]; ok {
+			return c
+		}
+		c := reflect.New(v.Type().Elem())
+		seen[ref] = c
+		c.Elem().Set(deepCopy(v.Elem(), seen))
+		return c
+	case reflect.Map:
+		if v.IsNil() {
+			return v
+		}
+		ref := copiedRef{v.Type(), v.Pointer()}
+		if c, ok := seen[ref]; ok {
+			return c
+		}
+		c := reflect.MakeMapWithSize(v.Type(), v.Len())
+		seen[ref] = c
+		for _, k := range v.MapKeys() {
+			c.SetMapIndex(deepCopy(k, seen), deepCopy(v.MapIndex(k), seen))
+		}
+		return c
+	case reflect.Slice:
+		if v.IsNil() {
+			return v
+		}
+		ref := copiedRef{v.Type(), v.Pointer()}
+		if c, ok := seen[ref]; ok && c.Len() == v.Len() {
+			return c
+		}
+		c := reflect.MakeSlice(v.Type(), v.Len(), v.Len())
+		seen[ref] = c
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), seen))
+		}
+		return c
+	case reflect.Array:
+		c := reflect.New(v.Type()).Elem()
+		for i := 0; i < v.Len(); i++ {
+			c.Index(i).Set(deepCopy(v.Index(i), seen))
+		}
+		return c
+	case reflect.Struct:
+		c := reflect.New(v.Type()).Elem()
+		c.Set(v)
+		for i := 0; i < v.NumField(); i++ {
+			if f := c.Field(i); f.CanSet() {
+				f.Set(deepCopy(v.Field(i), seen))
+			}
+		}
+		return c
+	default:
+		return v
+	}
+}
+
+func copySession(s *sessions.Session) *sessions.Session {
+	c := sessions.NewSession(s.Store(), s.Name())
+	c.ID = s.ID
+	c.IsNew = s.IsNew
+	if s.Options != nil {
+		opts := *s.Options
+		c.Options = &opts
+	}
+	if s.Values != nil {
+		c.Values = deepCopy(reflect.ValueOf(s.Values), make(map[copiedRef]reflect.Value)).Interface().(map[interface{}]interface{})
+	}
+	return c
+}
+
+func detachSession(contextKey interface{}, r *http.Request) (*sessions.Session, bool) {
+	s, ok := extractSession(contextKey, r)
+	if !ok {
+		return nil, false
+	}
+	return copySession(s), true
+}
+
+// DetachSession returns a deep copy of the singular session most recently bound to this request via
+// WithSession, together with a boolean indicating whether such a session is available.
+//
+// The copy shares no mutable values with the bound session, so it's safe to pass to goroutines
+// that outlive the request, and changes made to either session don't affect the other. Note that
+// deep copying reaches only the exported fields of struct values; unexported pointer-like fields
+// remain shared.
+func DetachSession(r *http.Request) (*sessions.Session, bool) {
+	return detachSession(sessionContextKey{}, r)
+}
+
+// DetachSessionNamed returns a deep copy of the session most recently bound to this request with
+// the given name via WithSessionsNamed, together with a boolean indicating whether such a session
+// is available. See DetachSession for the guarantees this copy offers.
+func DetachSessionNamed(name string, r *http.Request) (*sessions.Session, bool) {
+	return detachSession(namedSessionContextKey(name), r)
+}
+
+// DetachedStore is implemented by session stores that keep session values on the server, and can
+// thus read and write a session's values without a request from or a response to its client.
+type DetachedStore interface {
+	// LoadDetached replaces the values of the given session with those stored for the session
+	// with the same name and ID, or returns an error matching ErrSessionExpired if the store no
+	// longer holds that session.
+	LoadDetached(ctx context.Context, s *sessions.Session) error
+	// SaveDetached writes the values of the given session to the store, without emitting a
+	// cookie.
+	SaveDetached(ctx context.Context, s *sessions.Session) error
+}
+
+// ErrNoSessionID is the error that MergeBack returns when given a snapshot of a session that has
+// never been saved, and hence has no ID with which to find its stored values.
+var ErrNoSessionID = errors.New("session has no ID")
+
+// MergeBack merges the values of a detached session snapshot, such as one obtained from
+// DetachSession, into the values stored for that session, overwriting stored values that share a
+// key with the snapshot's values, and saves the result to the given store. Stored values with
+// keys absent from the snapshot remain intact.
+//
+// If the store no longer holds the session, such as because it expired, MergeBack returns an error
+// matching ErrSessionExpired.
+//
+// MergeBack does not coordinate with concurrent requests that may also save the same session, so
+// changes it makes may be lost if a request that loaded the session earlier saves it later.
+func MergeBack(ctx context.Context, store DetachedStore, snapshot *sessions.Session) error {
+	if len(snapshot.ID) == 0 {
+		return ErrNoSessionID
+	}
+	current := sessions.NewSession(snapshot.Store(), snapshot.Name())
+	current.ID = snapshot.ID
+	current.Options = snapshot.Options
+	if err := store.LoadDetached(ctx, current); err != nil {
+		return err
+	}
+	for k, v := range snapshot.Values {
+		current.Values[k] = v
+	}
+	return store.SaveDetached(ctx, current)
+}
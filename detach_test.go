@@ -0,0 +1,174 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+type record struct {
+	Tags  []string
+	Child *record
+}
+
+func TestDetachSession(t *testing.T) {
+	var detached *sessions.Session
+	delegate := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := handler.MustExtractSession(r)
+		session.ID = "id"
+		shared := &record{Tags: []string{"a"}}
+		shared.Child = shared
+		session.Values["record"] = shared
+		session.Values["map"] = map[string]int{"n": 1}
+		var ok bool
+		detached, ok = handler.DetachSession(r)
+		if !ok {
+			t.Fatal("session is not available to detach")
+		}
+		shared.Tags[0] = "b"
+		session.Values["map"].(map[string]int)["n"] = 2
+		session.Values["extra"] = true
+		session.Options.MaxAge = 1
+	})
+	handler.WithSession("s", simpleStore{}, delegate, nil).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+	if detached == nil {
+		t.Fatal("delegate handler was not called")
+	}
+	if got, want := detached.Name(), "s"; got != want {
+		t.Errorf("name: got %q, want %q", got, want)
+	}
+	if got, want := detached.ID, "id"; got != want {
+		t.Errorf("ID: got %q, want %q", got, want)
+	}
+	rec := detached.Values["record"].(*record)
+	if got, want := rec.Tags[0], "a"; got != want {
+		t.Errorf("slice element: got %q, want %q", got, want)
+	}
+	if rec.Child != rec {
+		t.Error("cycle was not preserved")
+	}
+	if got, want := detached.Values["map"].(map[string]int)["n"], 1; got != want {
+		t.Errorf("map entry: got %d, want %d", got, want)
+	}
+	if _, ok := detached.Values["extra"]; ok {
+		t.Error("value added after detaching is present")
+	}
+	if detached.Options.MaxAge == 1 {
+		t.Error("options are shared")
+	}
+}
+
+// selfReferent is a struct holding a pointer to its own first field, which shares its address.
+type selfReferent struct {
+	N int
+	P *int
+}
+
+func TestDetachSessionWithPointerToFirstField(t *testing.T) {
+	var detached *sessions.Session
+	handler.WithSession("s", simpleStore{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		v := &selfReferent{N: 1}
+		v.P = &v.N
+		handler.MustExtractSession(r).Values["v"] = v
+		detached, _ = handler.DetachSession(r)
+	}), nil).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+	if detached == nil {
+		t.Fatal("delegate handler was not called")
+	}
+	if v := detached.Values["v"].(*selfReferent); v.N != 1 || *v.P != 1 {
+		t.Errorf("value: got %+v, want N and *P of 1", v)
+	}
+}
+
+func TestDetachSessionNamed(t *testing.T) {
+	called := false
+	delegate := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		session := handler.MustExtractSessionNamed("s1", r)
+		detached, ok := handler.DetachSessionNamed("s1", r)
+		if !ok {
+			t.Fatal("session is not available to detach")
+		}
+		if detached == session {
+			t.Error("detached session is the bound session")
+		}
+		if got, want := detached.Name(), "s1"; got != want {
+			t.Errorf("name: got %q, want %q", got, want)
+		}
+	})
+	handler.WithSessionsNamed([]string{"s1", "s2"}, simpleStore{}, delegate, nil).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+	if !called {
+		t.Error("delegate handler was not called")
+	}
+}
+
+func TestDetachSessionReportsAbsence(t *testing.T) {
+	r := httptest.NewRequest("", "/", nil)
+	if _, ok := handler.DetachSession(r); ok {
+		t.Error("got true, want false")
+	}
+	if _, ok := handler.DetachSessionNamed("s", r); ok {
+		t.Error("got true, want false")
+	}
+}
+
+type mapDetachedStore map[string]map[interface{}]interface{}
+
+func (m mapDetachedStore) LoadDetached(_ context.Context, s *sessions.Session) error {
+	values, ok := m[s.ID]
+	if !ok {
+		return errors.New("not found")
+	}
+	s.Values = make(map[interface{}]interface{}, len(values))
+	for k, v := range values {
+		s.Values[k] = v
+	}
+	return nil
+}
+
+func (m mapDetachedStore) SaveDetached(_ context.Context, s *sessions.Session) error {
+	m[s.ID] = s.Values
+	return nil
+}
+
+func TestMergeBack(t *testing.T) {
+	store := mapDetachedStore{
+		"id": {"kept": 1, "replaced": 1},
+	}
+	snapshot := sessions.NewSession(simpleStore{}, "s")
+	snapshot.ID = "id"
+	snapshot.Values["replaced"] = 2
+	snapshot.Values["added"] = 2
+	if err := handler.MergeBack(context.Background(), store, snapshot); err != nil {
+		t.Fatalf("failed to merge session: %v", err)
+	}
+	values := store["id"]
+	for k, want := range map[string]int{"kept": 1, "replaced": 2, "added": 2} {
+		if got := values[k]; got != want {
+			t.Errorf("value %q: got %v, want %d", k, got, want)
+		}
+	}
+}
+
+func TestMergeBackRequiresSessionID(t *testing.T) {
+	snapshot := sessions.NewSession(simpleStore{}, "s")
+	if got, want := handler.MergeBack(context.Background(), mapDetachedStore{}, snapshot), handler.ErrNoSessionID; got != want {
+		t.Errorf("error: got %v, want %v", got, want)
+	}
+}
+
+func TestMergeBackPropagatesLoadFailure(t *testing.T) {
+	snapshot := sessions.NewSession(simpleStore{}, "s")
+	snapshot.ID = "absent"
+	if err := handler.MergeBack(context.Background(), mapDetachedStore{}, snapshot); err == nil {
+		t.Error("got no error, want one")
+	}
+}
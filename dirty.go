@@ -0,0 +1,155 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"net/http"
+	"reflect"
+
+	"github.com/gorilla/sessions"
+)
+
+// dirtyTrackingKey keys the digests with which autoSavingStore detects changed values. Since the
+// digests never leave the process, the key need not be secret.
+var dirtyTrackingKey = []byte("handler: dirty tracking")
+
+// autoSavingStore is a sessions.Store that tracks whether a single session has changed since it was
+// bound to the request or last saved.
+type autoSavingStore struct {
+	sessions.Store
+	digests map[interface{}]string
+	dirty   bool
+}
+
+func (a *autoSavingStore) unwrapStore() sessions.Store {
+	return a.Store
+}
+
+func (a *autoSavingStore) Save(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	if err := a.Store.Save(r, w, s); err != nil {
+		return err
+	}
+	a.digests = valueDigests(dirtyTrackingKey, s.Values)
+	a.dirty = false
+	return nil
+}
+
+func (a *autoSavingStore) needsSave(s *sessions.Session) bool {
+	return a.dirty || !reflect.DeepEqual(a.digests, valueDigests(dirtyTrackingKey, s.Values))
+}
+
+// headerWriter is an http.ResponseWriter that offers only its header, for saving sessions just
+// before the response header is written.
+type headerWriter http.Header
+
+func (w headerWriter) Header() http.Header {
+	return http.Header(w)
+}
+
+func (headerWriter) Write(b []byte) (int, error) {
+	return 0, http.ErrBodyNotAllowed
+}
+
+func (headerWriter) WriteHeader(int) {}
+
+// MarkDirty marks the session as needing to be saved, even if its values haven't changed, such as
+// when its stored form should be refreshed. It reports whether the session was bound with the
+// AutoSave Option, which is what acts on the mark; otherwise, it has no effect.
+func MarkDirty(s *sessions.Session) bool {
+	return findStore(s, func(store sessions.Store) bool {
+		a, ok := store.(*autoSavingStore)
+		if ok {
+			a.dirty = true
+		}
+		return ok
+	})
+}
+
+// AutoSave returns an Option that saves each bound session whose values changed since it was bound
+// to the request or last saved, or that was marked via MarkDirty, just before the response header
+// is written, so that request handlers need not save sessions explicitly. It also saves sessions
+// whose cookies are stale, per any StaleCheckers supplied via ReencodeStale. If saving a session
+// fails, it calls onError, if supplied, with an error matching ErrSessionTooLarge if the session's
+// values were too large for its store to encode; by then it's too late to alter the response
+// status. Informational responses, such as 103 Early Hints, don't count as writing the header, so
+// changes made after sending them still get saved.
+//
+// It detects changes by comparing digests of the sessions' values, as printed by package fmt.
+// Changes to values that print identically, such as to the target of a pointer value, go
+// unnoticed; mark such sessions via MarkDirty.
+func AutoSave(onError func(r *http.Request, s *sessions.Session, err error)) Option {
+	return func(c *bindingConfig) {
+		c.decorators = append(c.decorators, func(r *http.Request, s *sessions.Session) *sessions.Session {
+			if s.Store() == nil {
+				return s
+			}
+			return rebindSession(s, &autoSavingStore{
+				Store:   s.Store(),
+				digests: valueDigests(dirtyTrackingKey, s.Values),
+				dirty:   c.stale(r, s),
+			})
+		})
+		c.saveHooks = append(c.saveHooks, func(h http.Header, r *http.Request, bound []*sessions.Session) {
+			for _, s := range bound {
+				var needsSave bool
+				findStore(s, func(store sessions.Store) bool {
+					a, ok := store.(*autoSavingStore)
+					if ok {
+						needsSave = a.needsSave(s)
+					}
+					return ok
+				})
+				if !needsSave {
+					continue
+				}
+				if err := s.Save(r, headerWriter(h)); err != nil {
+					err = saveError(s.Name(), err)
+					c.countError(s.Name(), err)
+					if onError != nil {
+						onError(r, s, err)
+					}
+				}
+			}
+		})
+	}
+}
+
+// StaleChecker is implemented by securecookie.Codecs that can tell whether an encoded value was
+// encoded with a key or format that's no longer current, though they can still decode it.
+type StaleChecker interface {
+	// IsStale reports whether the value, encoded for the given name, should be encoded anew.
+	IsStale(name, value string) bool
+}
+
+func (c *bindingConfig) stale(r *http.Request, s *sessions.Session) bool {
+	if len(c.staleCheckers) == 0 || s.IsNew {
+		return false
+	}
+	cookie, err := r.Cookie(s.Name())
+	if err != nil {
+		return false
+	}
+	for _, sc := range c.staleCheckers {
+		if sc.IsStale(s.Name(), cookie.Value) {
+			return true
+		}
+	}
+	return false
+}
+
+// ReencodeStale returns an Option that marks each bound session loaded from a cookie that the given
+// StaleChecker deems stale as needing to be saved, so that the AutoSave Option saves it, encoding
+// its cookie anew with the current key or codec. That way, clients converge on the current key or
+// codec as they make requests during a rotation, rather than being logged out once the old one is
+// retired. It panics if the StaleChecker is nil.
+//
+// It has no effect without the AutoSave Option.
+func ReencodeStale(sc StaleChecker) Option {
+	if sc == nil {
+		panic("no stale checker supplied")
+	}
+	return func(c *bindingConfig) {
+		c.staleCheckers = append(c.staleCheckers, sc)
+	}
+}
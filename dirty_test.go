@@ -0,0 +1,130 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/keys"
+)
+
+func TestAutoSave(t *testing.T) {
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	serve := func(r *http.Request, f func(s *sessions.Session)) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			f(handler.MustExtractSession(r))
+			w.WriteHeader(http.StatusNoContent)
+		}), nil, handler.AutoSave(func(_ *http.Request, _ *sessions.Session, err error) {
+			t.Errorf("failed to save session: %v", err)
+		})).ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	recorder := serve(httptest.NewRequest("", "/", nil), func(s *sessions.Session) {
+		s.Values["uid"] = "alice"
+	})
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("cookies: got %d, want 1", len(cookies))
+	}
+	r := httptest.NewRequest("", "/", nil)
+	r.AddCookie(cookies[0])
+	if got := serve(r, func(s *sessions.Session) {
+		if got, want := s.Values["uid"], "alice"; got != want {
+			t.Errorf("value: got %v, want %v", got, want)
+		}
+	}).Header().Get("Set-Cookie"); len(got) != 0 {
+		t.Errorf("unchanged session was saved: %q", got)
+	}
+	if got := serve(r, func(s *sessions.Session) {
+		if !handler.MarkDirty(s) {
+			t.Error("session bound with AutoSave was not marked")
+		}
+	}).Header().Get("Set-Cookie"); len(got) == 0 {
+		t.Error("session marked as dirty was not saved")
+	}
+}
+
+func TestMarkDirtyWithoutAutoSave(t *testing.T) {
+	s, _ := handler.NopSource{}.New(nil, "s")
+	if handler.MarkDirty(s) {
+		t.Error("session bound without AutoSave was marked")
+	}
+}
+
+func TestReencodeStalePanicsWithNoChecker(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.ReencodeStale(nil)
+}
+
+func TestReencodeStale(t *testing.T) {
+	oldPair := keys.Pair{Hash: securecookie.GenerateRandomKey(32)}
+	newPair := keys.Pair{Hash: securecookie.GenerateRandomKey(32)}
+	serve := func(codec *keys.EpochCodec, r *http.Request) *httptest.ResponseRecorder {
+		store := sessions.NewCookieStore()
+		store.Codecs = []securecookie.Codec{codec}
+		recorder := httptest.NewRecorder()
+		handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			session := handler.MustExtractSession(r)
+			if session.IsNew {
+				session.Values["uid"] = "alice"
+			}
+		}), nil, handler.AutoSave(nil), handler.ReencodeStale(codec)).ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	recorder := serve(keys.NewEpochCodec(keys.Epoch{ID: "a", Pair: oldPair}), httptest.NewRequest("", "/", nil))
+	cookies := recorder.Result().Cookies()
+	if len(cookies) != 1 || !strings.HasPrefix(cookies[0].Value, "a.") {
+		t.Fatalf("cookies: got %v, want one encoded in epoch a", cookies)
+	}
+	rotated := keys.NewEpochCodec(keys.Epoch{ID: "b", Pair: newPair}, keys.Epoch{ID: "a", Pair: oldPair})
+	r := httptest.NewRequest("", "/", nil)
+	r.AddCookie(cookies[0])
+	cookies = serve(rotated, r).Result().Cookies()
+	if len(cookies) != 1 || !strings.HasPrefix(cookies[0].Value, "b.") {
+		t.Fatalf("cookies: got %v, want one encoded in epoch b", cookies)
+	}
+	r = httptest.NewRequest("", "/", nil)
+	r.AddCookie(cookies[0])
+	if got := serve(rotated, r).Header().Get("Set-Cookie"); len(got) != 0 {
+		t.Errorf("current session was saved: %q", got)
+	}
+}
+
+// collidingKeysSource supplies sessions bearing values under distinct keys that print alike.
+type collidingKeysSource struct {
+	failingSaveStore
+}
+
+func (s collidingKeysSource) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s.failingSaveStore, name)
+	session.Values[1] = "a"
+	session.Values["1"] = "a"
+	return session, nil
+}
+
+func TestAutoSaveWithKeysPrintedAlike(t *testing.T) {
+	errSave := errors.New("saved")
+	// Map iteration order varies, so try several times to catch one key's digest hiding the other's.
+	for i := 0; i < 20; i++ {
+		saved := false
+		handler.WithSession("s", collidingKeysSource{failingSaveStore{errSave}}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.MustExtractSession(r).Values[1] = "b"
+		}), nil, handler.AutoSave(func(_ *http.Request, _ *sessions.Session, err error) {
+			saved = errors.Is(err, errSave)
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+		if !saved {
+			t.Fatal("changed session was not saved")
+		}
+	}
+}
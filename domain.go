@@ -0,0 +1,110 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// validateSharedDomain returns the normalized form of a domain suitable for scoping cookies shared
+// among its subdomains, or an error describing why it's unsuitable.
+func validateSharedDomain(domain string) (string, error) {
+	d := strings.ToLower(strings.TrimPrefix(domain, "."))
+	switch {
+	case len(d) == 0:
+		return "", fmt.Errorf("shared cookie domain %q is empty", domain)
+	case strings.ContainsAny(d, ":/ "):
+		return "", fmt.Errorf("shared cookie domain %q must be a bare host name", domain)
+	case net.ParseIP(d) != nil:
+		return "", fmt.Errorf("shared cookie domain %q is an IP address", domain)
+	case !strings.Contains(d, "."):
+		return "", fmt.Errorf("shared cookie domain %q has only a single label", domain)
+	case strings.HasSuffix(d, ".") || strings.Contains(d, ".."):
+		return "", fmt.Errorf("shared cookie domain %q has an empty label", domain)
+	}
+	return d, nil
+}
+
+// withinDomain reports whether the request's host is the given domain or one of its subdomains,
+// and hence whether a browser would accept a cookie scoped to that domain from it.
+func withinDomain(r *http.Request, domain string) bool {
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	return host == domain || strings.HasSuffix(host, "."+domain)
+}
+
+// SharedDomain returns an Option that scopes the cookies of bound sessions to the given parent
+// domain, so that the sessions are shared among all of its subdomains. It leaves the cookie scope
+// intact for requests to hosts outside that domain, since browsers would reject such cookies. It
+// panics if the domain is unsuitable, such as being empty, an IP address, or a single label like
+// "com".
+//
+// Switching an existing deployment to a shared domain leaves clients with both the former
+// host-only cookie and the new domain-scoped cookie, bearing the same name; browsers send both,
+// and a store may read the stale one. To repair this, the Option also instructs the client to
+// discard the host-only cookie whenever a request bears more than one cookie for a bound session,
+// per ExpireHostOnlyCookie.
+func SharedDomain(domain string) Option {
+	d, err := validateSharedDomain(domain)
+	if err != nil {
+		panic(err.Error())
+	}
+	return func(c *bindingConfig) {
+		c.preparers = append(c.preparers, func(r *http.Request, s *sessions.Session) {
+			if s.Options != nil && withinDomain(r, d) {
+				s.Options.Domain = d
+			}
+		})
+		c.responseHooks = append(c.responseHooks, func(h http.Header, r *http.Request, bound []*sessions.Session) {
+			for _, s := range bound {
+				if CountCookies(r, s.Name()) > 1 {
+					path := "/"
+					if s.Options != nil && len(s.Options.Path) != 0 {
+						path = s.Options.Path
+					}
+					h.Add("Set-Cookie", hostOnlyExpiringCookie(s.Name(), path).String())
+				}
+			}
+		})
+	}
+}
+
+// CountCookies returns the number of cookies with the given name that the request bears. More than
+// one indicates that the client holds cookies of that name with different scopes, such as both a
+// host-only cookie and a domain-scoped cookie.
+func CountCookies(r *http.Request, name string) int {
+	n := 0
+	for _, c := range r.Cookies() {
+		if c.Name == name {
+			n++
+		}
+	}
+	return n
+}
+
+func hostOnlyExpiringCookie(name, path string) *http.Cookie {
+	return &http.Cookie{
+		Name:    name,
+		Value:   "",
+		Path:    path,
+		MaxAge:  -1,
+		Expires: time.Unix(1, 0),
+	}
+}
+
+// ExpireHostOnlyCookie instructs the client to discard its host-only cookie with the given name and
+// path—that is, the cookie set without a Domain attribute—leaving any domain-scoped cookie with
+// the same name intact.
+func ExpireHostOnlyCookie(w http.ResponseWriter, name, path string) {
+	http.SetCookie(w, hostOnlyExpiringCookie(name, path))
+}
@@ -0,0 +1,73 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/seh/handler"
+)
+
+func TestSharedDomainPanicsWithUnsuitableDomain(t *testing.T) {
+	for _, domain := range []string{"", "com", "127.0.0.1", "example.com:8080", "example..com"} {
+		t.Run(domain, func(t *testing.T) {
+			defer ensurePanicWithValueOccured(t)
+			handler.SharedDomain(domain)
+		})
+	}
+}
+
+func TestSharedDomain(t *testing.T) {
+	tests := []struct {
+		description string
+		target      string
+		cookies     int
+		wantDomain  string
+		wantExpired bool
+	}{
+		{"parent", "http://example.com/", 0, "example.com", false},
+		{"subdomain", "http://app.Example.com:8080/", 1, "example.com", false},
+		{"outside", "http://example.org/", 0, "", false},
+		{"duplicates", "http://app.example.com/", 2, "example.com", true},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			r := httptest.NewRequest("", test.target, nil)
+			for i := 0; i < test.cookies; i++ {
+				r.AddCookie(&http.Cookie{Name: "s", Value: "v"})
+			}
+			h := handler.WithSession("s", handler.NopSource{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got := handler.MustExtractSession(r).Options.Domain; got != test.wantDomain {
+					t.Errorf("domain: got %q, want %q", got, test.wantDomain)
+				}
+			}), nil, handler.SharedDomain(".Example.com"))
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, r)
+			expired := false
+			for _, c := range recorder.Result().Cookies() {
+				if c.Name == "s" && c.MaxAge < 0 && len(c.Domain) == 0 {
+					expired = true
+				}
+			}
+			if expired != test.wantExpired {
+				t.Errorf("host-only cookie expired: got %t, want %t", expired, test.wantExpired)
+			}
+		})
+	}
+}
+
+func TestCountCookies(t *testing.T) {
+	r := httptest.NewRequest("", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "s", Value: "1"})
+	r.AddCookie(&http.Cookie{Name: "t", Value: "2"})
+	r.AddCookie(&http.Cookie{Name: "s", Value: "3"})
+	if got, want := handler.CountCookies(r, "s"), 2; got != want {
+		t.Errorf("count: got %d, want %d", got, want)
+	}
+	if got, want := handler.CountCookies(r, "u"), 0; got != want {
+		t.Errorf("count: got %d, want %d", got, want)
+	}
+}
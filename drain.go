@@ -0,0 +1,121 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// Drainer coordinates a graceful shutdown: once draining begins, it turns away clients that would
+// need new sessions, so that they start them on another instance, while continuing to serve clients
+// with existing sessions, and it waits for session saves running in the background to complete
+// before the process exits. The zero value is ready for use.
+//
+// A typical shutdown calls Drain as soon as the instance is told to stop, waits while the load
+// balancer stops routing to it, and then calls Shutdown.
+type Drainer struct {
+	// RetryAfter is how long rejected clients should wait before retrying, sent in the
+	// Retry-After header. If not positive, the Drainer uses five seconds.
+	RetryAfter time.Duration
+	mu         sync.Mutex
+	draining   bool
+	background sync.WaitGroup
+}
+
+func (d *Drainer) retryAfter() time.Duration {
+	if d.RetryAfter > 0 {
+		return d.RetryAfter
+	}
+	return 5 * time.Second
+}
+
+// Drain begins draining. It's safe to call more than once.
+func (d *Drainer) Drain() {
+	d.mu.Lock()
+	d.draining = true
+	d.mu.Unlock()
+}
+
+// Draining reports whether draining has begun.
+func (d *Drainer) Draining() bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	return d.draining
+}
+
+// Go calls f in a new goroutine, such as one saving a session detached from its request via
+// SaveDetached or MergeBack, and ensures that Shutdown waits for it to return.
+func (d *Drainer) Go(f func()) {
+	d.background.Add(1)
+	go func() {
+		defer d.background.Done()
+		f()
+	}()
+}
+
+// Shutdown begins draining, shuts down the supplied server gracefully per http.Server.Shutdown,
+// which waits for in-flight requests and the session saves they make to complete, and then waits
+// for the functions started via Go to return. It returns the error from shutting down the server,
+// or the error from the supplied context if it's done before the background functions return.
+func (d *Drainer) Shutdown(ctx context.Context, srv *http.Server) error {
+	d.Drain()
+	if err := srv.Shutdown(ctx); err != nil {
+		return err
+	}
+	done := make(chan struct{})
+	go func() {
+		d.background.Wait()
+		close(done)
+	}()
+	select {
+	case <-done:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+// RejectNewSessions returns an HTTP handler that, once draining begins, rejects requests bearing
+// none of the cookies with the given session names, which would otherwise start new sessions, and
+// asks clients to close their connections, so that they reach another instance, before delegating
+// other requests to the supplied handler. Before draining begins, it delegates all requests to the
+// supplied handler. It must enclose the handlers that bind sessions.
+//
+// It delegates rejected requests to the onReject handler, after setting the Retry-After header per
+// the Drainer's RetryAfter field. If no such onReject handler is supplied, it will respond with
+// HTTP status code 503 with no body. It panics if the supplied handler is nil or if no names are
+// supplied.
+func (d *Drainer) RejectNewSessions(names []string, h http.Handler, onReject http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	if len(names) == 0 {
+		panic("no session names supplied")
+	}
+	if onReject == nil {
+		onReject = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		})
+	}
+	retryAfter := strconv.FormatInt(int64((d.retryAfter()+time.Second-1)/time.Second), 10)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !d.Draining() {
+			h.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Set("Connection", "close")
+		for _, name := range names {
+			if _, err := r.Cookie(name); err == nil {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		w.Header().Set("Retry-After", retryAfter)
+		onReject.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+func TestRejectNewSessionsPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	(&handler.Drainer{}).RejectNewSessions([]string{"s"}, nil, nil)
+}
+
+func TestRejectNewSessionsPanicsWithNoNames(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	(&handler.Drainer{}).RejectNewSessions(nil, http.NotFoundHandler(), nil)
+}
+
+func TestDrainer(t *testing.T) {
+	var d handler.Drainer
+	h := d.RejectNewSessions([]string{"s"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), nil)
+	serve := func(withCookie bool) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if withCookie {
+			r.AddCookie(&http.Cookie{Name: "s", Value: "v"})
+		}
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		return recorder
+	}
+	if got, want := serve(false).Code, http.StatusNoContent; got != want {
+		t.Errorf("status before draining: got %d, want %d", got, want)
+	}
+
+	srv := httptest.NewServer(h)
+	released := make(chan struct{})
+	saved := false
+	d.Go(func() {
+		<-released
+		saved = true
+	})
+	shutdown := make(chan error)
+	go func() { shutdown <- d.Shutdown(context.Background(), srv.Config) }()
+	for !d.Draining() {
+		time.Sleep(time.Millisecond)
+	}
+	recorder := serve(false)
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") != "5" {
+		t.Errorf("new session while draining: got status %d with Retry-After %q, want %d with %q", recorder.Code, recorder.Header().Get("Retry-After"), http.StatusServiceUnavailable, "5")
+	}
+	if recorder := serve(true); recorder.Code != http.StatusNoContent || recorder.Header().Get("Connection") != "close" {
+		t.Errorf("existing session while draining: got status %d with Connection %q, want %d with %q", recorder.Code, recorder.Header().Get("Connection"), http.StatusNoContent, "close")
+	}
+	select {
+	case err := <-shutdown:
+		t.Fatalf("shutdown completed before background save: %v", err)
+	case <-time.After(10 * time.Millisecond):
+	}
+	close(released)
+	if err := <-shutdown; err != nil {
+		t.Errorf("failed to shut down: %v", err)
+	}
+	if !saved {
+		t.Error("shutdown completed before background save")
+	}
+	srv.Close()
+}
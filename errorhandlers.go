@@ -0,0 +1,98 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+)
+
+// ErrorHandler responds to an error that arose while processing a request, such as acquiring a
+// session, if it's suited to do so, reporting whether it did respond. An ErrorHandler that reports
+// false must not have written anything to the response.
+type ErrorHandler func(w http.ResponseWriter, r *http.Request, err error) bool
+
+// ErrorHandlers returns a function suitable for use as the onError handler for WithSession that
+// offers each error to the given ErrorHandlers in turn, until one of them responds, allowing
+// responses negotiated by content type to be composed from independent pieces:
+//
+//	handler.WithSession("s", store, h, handler.ErrorHandlers(
+//		handler.JSONError(http.StatusServiceUnavailable),
+//		handler.RedirectBrowsers("/unavailable"),
+//	))
+//
+// If none of them responds, it responds with HTTP status code 500 with no body. To use it with
+// WithSessionsNamed, discard the session name:
+//
+//	onError := handler.ErrorHandlers(...)
+//	handler.WithSessionsNamed(names, store, h, func(w http.ResponseWriter, r *http.Request, _ string, err error) {
+//		onError(w, r, err)
+//	})
+func ErrorHandlers(handlers ...ErrorHandler) func(w http.ResponseWriter, r *http.Request, err error) {
+	return func(w http.ResponseWriter, r *http.Request, err error) {
+		for _, h := range handlers {
+			if h(w, r, err) {
+				return
+			}
+		}
+		sendDefaultResponse(w)
+	}
+}
+
+// acceptsMediaType reports whether the given Accept header values name the media type explicitly,
+// with a nonzero quality value. Unlike acceptsEncoding, it disregards wildcards, since browsers
+// accept "*/*" from any resource.
+func acceptsMediaType(accept []string, mediaType string) bool {
+	for _, v := range accept {
+		for _, part := range strings.Split(v, ",") {
+			if name, q := parseAcceptElement(part); strings.EqualFold(name, mediaType) {
+				return q > 0
+			}
+		}
+	}
+	return false
+}
+
+// JSONError returns an ErrorHandler that responds to requests that accept JSON, per their Accept
+// header, with the given HTTP status code and a JSON object whose "error" member holds the status
+// text, such as {"error":"Service Unavailable"}. It doesn't disclose the error itself.
+func JSONError(code int) ErrorHandler {
+	body, _ := json.Marshal(struct {
+		Error string `json:"error"`
+	}{http.StatusText(code)})
+	body = append(body, '\n')
+	return func(w http.ResponseWriter, r *http.Request, _ error) bool {
+		if !acceptsMediaType(r.Header.Values("Accept"), "application/json") {
+			return false
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("X-Content-Type-Options", "nosniff")
+		w.WriteHeader(code)
+		w.Write(body)
+		return true
+	}
+}
+
+// RedirectBrowsers returns an ErrorHandler that redirects requests that accept HTML, per their
+// Accept header, as browsers' navigation requests do, to the given URL with HTTP status code 303,
+// such as to a page explaining that the site is temporarily unavailable.
+func RedirectBrowsers(url string) ErrorHandler {
+	return func(w http.ResponseWriter, r *http.Request, _ error) bool {
+		if !acceptsMediaType(r.Header.Values("Accept"), "text/html") {
+			return false
+		}
+		http.Redirect(w, r, url, http.StatusSeeOther)
+		return true
+	}
+}
+
+// ErrorStatus returns an ErrorHandler that responds to every request with the given HTTP status
+// code and no body, suitable as the last in a sequence supplied to ErrorHandlers.
+func ErrorStatus(code int) ErrorHandler {
+	return func(w http.ResponseWriter, _ *http.Request, _ error) bool {
+		w.WriteHeader(code)
+		return true
+	}
+}
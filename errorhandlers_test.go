@@ -0,0 +1,67 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/seh/handler"
+)
+
+func TestErrorHandlers(t *testing.T) {
+	onError := handler.ErrorHandlers(
+		handler.JSONError(http.StatusServiceUnavailable),
+		handler.RedirectBrowsers("/unavailable"),
+	)
+	tests := []struct {
+		accept          string
+		wantCode        int
+		wantContentType string
+		wantBody        string
+		wantLocation    string
+	}{
+		{"application/json", http.StatusServiceUnavailable, "application/json", "{\"error\":\"Service Unavailable\"}\n", ""},
+		{"text/html,application/xhtml+xml,*/*;q=0.8", http.StatusSeeOther, "", "", "/unavailable"},
+		{"application/json;q=0, text/html", http.StatusSeeOther, "", "", "/unavailable"},
+		{"*/*", http.StatusInternalServerError, "", "", ""},
+		{"", http.StatusInternalServerError, "", "", ""},
+	}
+	for _, test := range tests {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if len(test.accept) != 0 {
+			r.Header.Set("Accept", test.accept)
+		}
+		recorder := httptest.NewRecorder()
+		onError(recorder, r, errors.New("store unavailable"))
+		if got, want := recorder.Code, test.wantCode; got != want {
+			t.Errorf("Accept %q: status code: got %d, want %d", test.accept, got, want)
+		}
+		if len(test.wantContentType) != 0 {
+			if got, want := recorder.Header().Get("Content-Type"), test.wantContentType; got != want {
+				t.Errorf("Accept %q: content type: got %q, want %q", test.accept, got, want)
+			}
+		}
+		if test.wantCode != http.StatusSeeOther {
+			if got, want := recorder.Body.String(), test.wantBody; got != want {
+				t.Errorf("Accept %q: body: got %q, want %q", test.accept, got, want)
+			}
+		}
+		if got, want := recorder.Header().Get("Location"), test.wantLocation; got != want {
+			t.Errorf("Accept %q: location: got %q, want %q", test.accept, got, want)
+		}
+	}
+}
+
+func TestErrorHandlersWithSession(t *testing.T) {
+	h := handler.WithSession("s", failingSessionSource{errors.New("")}, http.NotFoundHandler(),
+		handler.ErrorHandlers(handler.RedirectBrowsers("/unavailable"), handler.ErrorStatus(http.StatusServiceUnavailable)))
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	if got, want := recorder.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("status code: got %d, want %d", got, want)
+	}
+}
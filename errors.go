@@ -0,0 +1,203 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// The following errors categorize failures that arise across the package and the stores that
+// accompany it, such as kvstore.Store, for callers to detect with errors.Is. The errors reported
+// typically wrap one of these together with the underlying cause. See also ErrNoSession.
+var (
+	// ErrSessionExpired indicates that a session sought by its ID is no longer held by its store,
+	// such as because it expired and was purged. DetachedStores report it from LoadDetached, and
+	// hence MergeBack reports it too.
+	ErrSessionExpired = errors.New("session expired")
+	// ErrSourceUnavailable indicates that a SessionSource, or the storage behind it, can't serve
+	// requests at the moment, such as when kvstore.Failover finds its primary down.
+	ErrSourceUnavailable = errors.New("session source unavailable")
+	// ErrSessionTooLarge indicates that a session's values encode to more than its store can
+	// accept, such as when a session kept in a cookie outgrows the size limit of its codecs. AutoSave
+	// reports it to its onError function.
+	ErrSessionTooLarge = errors.New("session too large")
+	// ErrHeaderWritten indicates that a session was saved after the response header was written,
+	// too late for the cookie that saving it set to reach the client. GuardLateSaves reports it.
+	ErrHeaderWritten = errors.New("response header already written")
+	// ErrCookieConflict indicates that a response set cookies bearing the same name that clients
+	// would keep side by side, or that distinct sessions or middleware set the same cookie.
+	// DetectCookieConflicts reports it.
+	ErrCookieConflict = errors.New("conflicting cookies")
+	// ErrCookieBudgetExceeded indicates that saving a session would grow the cookies that a client
+	// holds beyond the budget that CookieBudget enforces.
+	ErrCookieBudgetExceeded = errors.New("cookie budget exceeded")
+)
+
+// anySecureCookieError reports whether the predicate holds for the error or, if it's or wraps a
+// securecookie.MultiError, as DecodeMulti and EncodeMulti return, for any of the errors it groups.
+func anySecureCookieError(err error, pred func(error) bool) bool {
+	var multi securecookie.MultiError
+	if errors.As(err, &multi) {
+		for _, err := range multi {
+			if err != nil && anySecureCookieError(err, pred) {
+				return true
+			}
+		}
+		return false
+	}
+	return err != nil && pred(err)
+}
+
+// IsDecodeError reports whether the error arose from a securecookie codec failing to decode a
+// cookie value, such as because it's malformed, was encoded with keys no longer in use, has been
+// tampered with, or has expired. WithSession and the like treat such errors as the absence of a
+// session cookie, binding a fresh session, so they surface only from stores used directly.
+// IsValidationError reports the subset of these errors that arise from authentication or expiry.
+func IsDecodeError(err error) bool {
+	return anySecureCookieError(err, func(err error) bool {
+		var serr securecookie.Error
+		return errors.As(err, &serr) && serr.IsDecode()
+	})
+}
+
+// validationFailures holds the messages of the errors that securecookie reports when a value fails
+// authentication or bears an unacceptable timestamp, as it doesn't export all of those errors.
+var validationFailures = map[string]bool{
+	securecookie.ErrMacInvalid.Error():   true,
+	"securecookie: invalid timestamp":    true,
+	"securecookie: timestamp is too new": true,
+	"securecookie: expired timestamp":    true,
+}
+
+// IsValidationError reports whether the error arose from a securecookie codec rejecting a cookie
+// value that failed authentication—because it was encoded with other keys, or tampered with—or
+// whose timestamp lies outside the codec's maximum age. These are the decode errors that clients
+// with stale cookies provoke, as distinct from those that corrupt values provoke.
+func IsValidationError(err error) bool {
+	return anySecureCookieError(err, func(err error) bool {
+		for ; err != nil; err = errors.Unwrap(err) {
+			if validationFailures[err.Error()] {
+				return true
+			}
+		}
+		return false
+	})
+}
+
+// isValueTooLong reports whether the error arose from a securecookie codec refusing to encode a
+// value whose encoding exceeds its maximum length.
+func isValueTooLong(err error) bool {
+	return anySecureCookieError(err, func(err error) bool {
+		// securecookie doesn't export this error, and some versions report it only as text, so
+		// recognize it by its message.
+		return strings.HasPrefix(err.Error(), "securecookie: the value is too long")
+	})
+}
+
+// saveFailure is an error arising from saving a session, or otherwise extending its lifetime.
+type saveFailure struct {
+	// op describes the failed operation, such as "saving session".
+	op   string
+	name string
+	err  error
+}
+
+func (e *saveFailure) Error() string {
+	return fmt.Sprintf("%s %q: %v", e.op, e.name, e.err)
+}
+
+func (e *saveFailure) Unwrap() error {
+	return e.err
+}
+
+// saveError returns the error that saving the named session failed with, wrapped so as to name
+// the session, and to match ErrSessionTooLarge if the session's values were too large to encode.
+func saveError(name string, err error) error {
+	if isValueTooLong(err) {
+		err = fmt.Errorf("%w: %w", ErrSessionTooLarge, err)
+	}
+	return &saveFailure{"saving session", name, err}
+}
+
+// ErrorCategory classifies errors arising from acquiring and saving sessions by their likely
+// cause, such as for counting them separately.
+type ErrorCategory int
+
+const (
+	// OtherError covers errors that fit no other category.
+	OtherError ErrorCategory = iota
+	// DecodeError covers session cookies that couldn't be decoded, per IsDecodeError, other than
+	// those covered by ValidationError.
+	DecodeError
+	// ValidationError covers session cookies that failed authentication or had expired, per
+	// IsValidationError, as clients bearing stale cookies provoke.
+	ValidationError
+	// UnavailableError covers errors matching ErrSourceUnavailable.
+	UnavailableError
+	// TimeoutError covers errors matching context.DeadlineExceeded, or that report themselves as
+	// timeouts, as net.Error does.
+	TimeoutError
+	// SaveError covers failures to save or refresh sessions not covered by the preceding
+	// categories.
+	SaveError
+	// OversizedError covers errors matching ErrSessionTooLarge or ErrCookieBudgetExceeded.
+	OversizedError
+	// LateSaveError covers errors matching ErrHeaderWritten.
+	LateSaveError
+	// ConflictError covers errors matching ErrCookieConflict.
+	ConflictError
+)
+
+var errorCategoryNames = [...]string{
+	OtherError:       "other",
+	DecodeError:      "decode",
+	ValidationError:  "validation",
+	UnavailableError: "backend-unavailable",
+	TimeoutError:     "timeout",
+	SaveError:        "save-failure",
+	OversizedError:   "oversized",
+	LateSaveError:    "late-save",
+	ConflictError:    "cookie-conflict",
+}
+
+// String returns the category's name, such as "decode" or "backend-unavailable", suitable for use
+// as a metric label.
+func (c ErrorCategory) String() string {
+	if c < 0 || int(c) >= len(errorCategoryNames) {
+		return fmt.Sprintf("ErrorCategory(%d)", int(c))
+	}
+	return errorCategoryNames[c]
+}
+
+// CategorizeError returns the category that best describes the cause of the error, preferring the
+// underlying cause to where it arose: saving a session fails with TimeoutError if the store's
+// backend took too long to respond.
+func CategorizeError(err error) ErrorCategory {
+	var timeout interface{ Timeout() bool }
+	var save *saveFailure
+	switch {
+	case IsValidationError(err):
+		return ValidationError
+	case IsDecodeError(err):
+		return DecodeError
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &timeout) && timeout.Timeout():
+		return TimeoutError
+	case errors.Is(err, ErrSourceUnavailable):
+		return UnavailableError
+	case errors.Is(err, ErrSessionTooLarge), errors.Is(err, ErrCookieBudgetExceeded):
+		return OversizedError
+	case errors.Is(err, ErrHeaderWritten):
+		return LateSaveError
+	case errors.Is(err, ErrCookieConflict):
+		return ConflictError
+	case errors.As(err, &save):
+		return SaveError
+	}
+	return OtherError
+}
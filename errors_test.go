@@ -0,0 +1,89 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestAutoSaveReportsSessionTooLarge(t *testing.T) {
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	for _, test := range []struct {
+		size    int
+		tooBig  bool
+		wantErr bool
+	}{
+		{10, false, false},
+		{10000, true, true},
+	} {
+		var saveErr error
+		h := handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			handler.MustExtractSession(r).Values["v"] = strings.Repeat("x", test.size)
+			w.WriteHeader(http.StatusNoContent)
+		}), nil, handler.AutoSave(func(_ *http.Request, _ *sessions.Session, err error) {
+			saveErr = err
+		}))
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+		if got, want := saveErr != nil, test.wantErr; got != want {
+			t.Errorf("value of size %d: got error %v, want error: %t", test.size, saveErr, want)
+		}
+		if got, want := errors.Is(saveErr, handler.ErrSessionTooLarge), test.tooBig; got != want {
+			t.Errorf("value of size %d: error %v matches ErrSessionTooLarge: got %t, want %t", test.size, saveErr, got, want)
+		}
+	}
+}
+
+func TestErrorPredicates(t *testing.T) {
+	hashKey := securecookie.GenerateRandomKey(32)
+	codec := securecookie.New(hashKey, nil)
+	encoded, err := codec.Encode("s", "v")
+	if err != nil {
+		t.Fatalf("failed to encode value: %v", err)
+	}
+	decodeWith := func(codecs ...securecookie.Codec) func(value string) error {
+		return func(value string) error {
+			var v string
+			return securecookie.DecodeMulti("s", value, &v, codecs...)
+		}
+	}
+	otherKeys := decodeWith(securecookie.New(securecookie.GenerateRandomKey(32), nil))
+	// Demanding a minimum age rejects the value just encoded as too new.
+	tooNew := decodeWith(securecookie.New(hashKey, nil).MinAge(3600))
+	for _, test := range []struct {
+		description  string
+		err          error
+		isDecode     bool
+		isValidation bool
+	}{
+		{"none", nil, false, false},
+		{"other", errors.New("failed"), false, false},
+		{"no cookie", http.ErrNoCookie, false, false},
+		{"non-decode", fakeSecureCookieError(false), false, false},
+		{"decode", fakeSecureCookieError(true), true, false},
+		{"valid", decodeWith(codec)(encoded), false, false},
+		{"malformed", decodeWith(codec)("!"), true, false},
+		{"other keys", otherKeys(encoded), true, true},
+		{"other keys among several", decodeWith(securecookie.New(securecookie.GenerateRandomKey(32), nil), securecookie.New(securecookie.GenerateRandomKey(32), nil))(encoded), true, true},
+		{"too new", tooNew(encoded), true, true},
+		{"wrapped", fmt.Errorf("acquiring session: %w", otherKeys(encoded)), true, true},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			if got, want := handler.IsDecodeError(test.err), test.isDecode; got != want {
+				t.Errorf("IsDecodeError(%v): got %t, want %t", test.err, got, want)
+			}
+			if got, want := handler.IsValidationError(test.err), test.isValidation; got != want {
+				t.Errorf("IsValidationError(%v): got %t, want %t", test.err, got, want)
+			}
+		})
+	}
+}
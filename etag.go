@@ -0,0 +1,152 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"crypto/sha256"
+	"encoding/base64"
+	"net/http"
+	"strings"
+)
+
+// ETagMode governs how WithETag derives entity tags.
+type ETagMode int
+
+const (
+	// ETagShared derives entity tags from response bodies alone.
+	ETagShared ETagMode = iota
+	// ETagPerSession mixes the identity of the request's principal or session into entity tags,
+	// so that identical responses to different users bear different tags, and a cache shared among
+	// users can't validate one user's cached response with another user's request.
+	ETagPerSession
+)
+
+// etagWriter is an http.ResponseWriter that buffers the response so that its entity tag can be
+// computed before the response header goes out, unless the handler flushes the response.
+type etagWriter struct {
+	http.ResponseWriter
+	status    int
+	buf       []byte
+	streaming bool
+}
+
+func (w *etagWriter) WriteHeader(code int) {
+	if w.streaming || w.status != 0 {
+		return
+	}
+	if code < http.StatusOK {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.status = code
+}
+
+func (w *etagWriter) Write(b []byte) (int, error) {
+	if w.streaming {
+		return w.ResponseWriter.Write(b)
+	}
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.buf = append(w.buf, b...)
+	return len(b), nil
+}
+
+// Flush implements http.Flusher, abandoning the computation of an entity tag in favor of writing
+// the response as it's produced, and flushing the underlying http.ResponseWriter if it supports
+// doing so.
+func (w *etagWriter) Flush() {
+	if !w.streaming {
+		w.send()
+		w.streaming = true
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for use by http.ResponseController. Hijacking
+// the connection that way abandons any buffered response.
+func (w *etagWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+func (w *etagWriter) send() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	w.ResponseWriter.WriteHeader(w.status)
+	if len(w.buf) != 0 {
+		w.ResponseWriter.Write(w.buf)
+		w.buf = nil
+	}
+}
+
+// etagMatches reports whether the If-None-Match header values list the entity tag, per the weak
+// comparison that RFC 7232 prescribes for If-None-Match.
+func etagMatches(ifNoneMatch []string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, v := range ifNoneMatch {
+		for _, candidate := range strings.Split(v, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if candidate == "*" || strings.TrimPrefix(candidate, "W/") == etag {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// WithETag returns an HTTP handler that buffers each successful response to a GET or HEAD request
+// from the supplied handler, sets its ETag header to a digest of its body, unless the handler set
+// one itself, and answers requests whose If-None-Match header lists that entity tag with HTTP
+// status code 304 and no body. If the handler flushes the response, it abandons buffering and
+// writes the response without an entity tag.
+//
+// With ETagPerSession, it identifies the user per ExtractPrincipal or, absent a principal, by the
+// ID of the singular session bound to the request via WithSession, which must then enclose the
+// returned handler. Since it defers writing the response header until the handler returns, it
+// works in either order with the AutoSave Option. It panics if the supplied handler is nil.
+func WithETag(mode ETagMode, h http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodGet && r.Method != http.MethodHead {
+			h.ServeHTTP(w, r)
+			return
+		}
+		ew := &etagWriter{ResponseWriter: w}
+		h.ServeHTTP(ew, r)
+		if ew.streaming {
+			return
+		}
+		if ew.status != http.StatusOK && ew.status != 0 {
+			ew.send()
+			return
+		}
+		header := w.Header()
+		etag := header.Get("ETag")
+		if len(etag) == 0 {
+			digest := sha256.New()
+			if mode == ETagPerSession {
+				if identity, ok := requestIdentity(r); ok {
+					digest.Write([]byte(identity))
+					digest.Write([]byte{0})
+				}
+			}
+			digest.Write(ew.buf)
+			etag = `"` + base64.RawURLEncoding.EncodeToString(digest.Sum(nil)[:18]) + `"`
+			header.Set("ETag", etag)
+		}
+		if etagMatches(r.Header["If-None-Match"], etag) {
+			for _, name := range []string{"Content-Length", "Content-Type", "Content-Encoding"} {
+				header.Del(name)
+			}
+			w.WriteHeader(http.StatusNotModified)
+			return
+		}
+		ew.send()
+	})
+}
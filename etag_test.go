@@ -0,0 +1,75 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/seh/handler"
+)
+
+func TestWithETagPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithETag(handler.ETagShared, nil)
+}
+
+func TestWithETag(t *testing.T) {
+	body := "the same for everyone"
+	app := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain")
+		w.Write([]byte(body))
+	})
+	serve := func(h http.Handler, principal, ifNoneMatch string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodGet, "/", nil)
+		if len(principal) != 0 {
+			r = handler.BindPrincipal(r, principal)
+		}
+		if len(ifNoneMatch) != 0 {
+			r.Header.Set("If-None-Match", ifNoneMatch)
+		}
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	shared := handler.WithETag(handler.ETagShared, app)
+	first := serve(shared, "alice", "")
+	etag := first.Header().Get("ETag")
+	if first.Code != http.StatusOK || len(etag) == 0 || first.Body.String() != body {
+		t.Fatalf("first response: got status %d with ETag %q and body %q", first.Code, etag, first.Body.String())
+	}
+	if got := serve(shared, "bob", "").Header().Get("ETag"); got != etag {
+		t.Errorf("ETag for other user: got %q, want %q", got, etag)
+	}
+	for _, ifNoneMatch := range []string{etag, `"other", W/` + etag, "*"} {
+		recorder := serve(shared, "alice", ifNoneMatch)
+		if recorder.Code != http.StatusNotModified || recorder.Body.Len() != 0 {
+			t.Errorf("response to If-None-Match %s: got status %d with %d bytes, want %d with none", ifNoneMatch, recorder.Code, recorder.Body.Len(), http.StatusNotModified)
+		}
+	}
+	if got, want := serve(shared, "alice", `"other"`).Code, http.StatusOK; got != want {
+		t.Errorf("status for stale entity tag: got %d, want %d", got, want)
+	}
+
+	perSession := handler.WithETag(handler.ETagPerSession, app)
+	alice := serve(perSession, "alice", "").Header().Get("ETag")
+	if bob := serve(perSession, "bob", "").Header().Get("ETag"); alice == bob {
+		t.Errorf("per-session ETags for different users are both %q", alice)
+	}
+	if got, want := serve(perSession, "bob", alice).Code, http.StatusOK; got != want {
+		t.Errorf("status for other user's entity tag: got %d, want %d", got, want)
+	}
+	if got, want := serve(perSession, "alice", alice).Code, http.StatusNotModified; got != want {
+		t.Errorf("status for own entity tag: got %d, want %d", got, want)
+	}
+
+	failing := handler.WithETag(handler.ETagShared, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		http.Error(w, "oops", http.StatusInternalServerError)
+	}))
+	if recorder := serve(failing, "", "*"); recorder.Code != http.StatusInternalServerError || len(recorder.Header().Get("ETag")) != 0 {
+		t.Errorf("error response: got status %d with ETag %q", recorder.Code, recorder.Header().Get("ETag"))
+	}
+}
@@ -0,0 +1,215 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// SessionEventKind identifies what happened to a session in a SessionEvent.
+type SessionEventKind string
+
+// These are the kinds of SessionEvent.
+const (
+	// SessionDestroyedEvent denotes a save of a session that deleted it.
+	SessionDestroyedEvent SessionEventKind = "session.destroyed"
+	// SessionRotatedEvent denotes a save of a session that replaced its ID with a fresh one.
+	SessionRotatedEvent SessionEventKind = "session.rotated"
+)
+
+// SessionEvent describes a change to a session that other instances of the application may need
+// to learn about, such as to evict the session from an in-process cache or to add its ID to a
+// revocation list. Unlike AuditEvents and LifecycleEvents, it bears the session's actual IDs,
+// so publish it only over channels as trusted as the session store itself.
+type SessionEvent struct {
+	Kind        SessionEventKind `json:"kind"`
+	Time        time.Time        `json:"time"`
+	SessionName string           `json:"session_name"`
+	// ID is the ID of the session destroyed, or the ID that the session bore before rotation.
+	ID string `json:"id"`
+	// NewID is the ID that the session bears after rotation, or empty for destroyed sessions.
+	NewID string `json:"new_id,omitempty"`
+	// Origin identifies the instance of the application that published the event, letting
+	// subscribers ignore the events they published themselves.
+	Origin string `json:"origin,omitempty"`
+}
+
+// EventBus carries SessionEvents among the instances of an application. Its methods must be safe
+// for concurrent use.
+type EventBus interface {
+	// Publish delivers the event to the subscribers of every instance, including this one.
+	Publish(ctx context.Context, e SessionEvent) error
+	// Subscribe arranges to call fn with each event published hereafter, until the returned cancel
+	// function is called. Implementations may call fn concurrently.
+	Subscribe(fn func(SessionEvent)) (cancel func(), err error)
+}
+
+// LocalBus is an EventBus that delivers events only to subscribers within this process, suiting
+// applications running as a single instance, and tests. Its zero value is ready for use.
+type LocalBus struct {
+	mu          sync.Mutex
+	subscribers map[int]func(SessionEvent)
+	next        int
+}
+
+// Publish calls each subscriber's function with the event in turn before returning.
+func (b *LocalBus) Publish(_ context.Context, e SessionEvent) error {
+	b.mu.Lock()
+	fns := make([]func(SessionEvent), 0, len(b.subscribers))
+	for _, fn := range b.subscribers {
+		fns = append(fns, fn)
+	}
+	b.mu.Unlock()
+	for _, fn := range fns {
+		fn(e)
+	}
+	return nil
+}
+
+// Subscribe arranges to call fn with each event published hereafter, until the returned cancel
+// function is called. It never fails.
+func (b *LocalBus) Subscribe(fn func(SessionEvent)) (func(), error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	if b.subscribers == nil {
+		b.subscribers = make(map[int]func(SessionEvent))
+	}
+	id := b.next
+	b.next++
+	b.subscribers[id] = fn
+	return func() {
+		b.mu.Lock()
+		defer b.mu.Unlock()
+		delete(b.subscribers, id)
+	}, nil
+}
+
+// PubSub is the publish-subscribe messaging that a PubSubBus needs, satisfied with a few lines
+// atop clients for systems such as NATS or Redis. With NATS, Publish calls the connection's
+// Publish method with the channel as the subject, and Subscribe calls its Subscribe method,
+// passing each message's Data to deliver and returning the subscription's Unsubscribe method as
+// the cancel function. With Redis, Publish calls the client's Publish method, and Subscribe calls
+// its Subscribe method, consuming the resulting subscription's Channel in a goroutine that passes
+// each message's Payload to deliver, and returning a function that closes the subscription.
+type PubSub interface {
+	Publish(ctx context.Context, channel string, payload []byte) error
+	Subscribe(channel string, deliver func(payload []byte)) (cancel func(), err error)
+}
+
+// PubSubBus is an EventBus that carries events encoded as JSON over a PubSub channel shared by all
+// instances of the application.
+//
+// Create a PubSubBus with NewPubSubBus.
+type PubSubBus struct {
+	pubsub  PubSub
+	channel string
+}
+
+// NewPubSubBus returns a PubSubBus that carries events over the given channel of the PubSub. It
+// panics if the PubSub is nil or the channel is empty.
+func NewPubSubBus(ps PubSub, channel string) *PubSubBus {
+	if ps == nil {
+		panic("no publish-subscribe messaging supplied")
+	}
+	if len(channel) == 0 {
+		panic("no channel supplied")
+	}
+	return &PubSubBus{ps, channel}
+}
+
+// Publish encodes the event as JSON and publishes it over the PubSubBus's channel.
+func (b *PubSubBus) Publish(ctx context.Context, e SessionEvent) error {
+	payload, err := json.Marshal(e)
+	if err != nil {
+		return err
+	}
+	return b.pubsub.Publish(ctx, b.channel, payload)
+}
+
+// Subscribe subscribes to the PubSubBus's channel, calling fn with each event it carries hereafter,
+// and ignoring messages that fail to decode as events.
+func (b *PubSubBus) Subscribe(fn func(SessionEvent)) (func(), error) {
+	return b.pubsub.Subscribe(b.channel, func(payload []byte) {
+		var e SessionEvent
+		if err := json.Unmarshal(payload, &e); err != nil || len(e.Kind) == 0 {
+			return
+		}
+		fn(e)
+	})
+}
+
+// publishingStore is a sessions.Store that publishes the destruction and rotation of a single
+// session to an EventBus.
+type publishingStore struct {
+	sessions.Store
+	bus     EventBus
+	origin  string
+	boundID string
+	failed  func(err error)
+}
+
+func (p *publishingStore) unwrapStore() sessions.Store {
+	return p.Store
+}
+
+func (p *publishingStore) Save(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	if err := p.Store.Save(r, w, s); err != nil {
+		return err
+	}
+	e := SessionEvent{Time: SystemClock.Now(), SessionName: s.Name(), Origin: p.origin}
+	switch {
+	case s.Options != nil && s.Options.MaxAge < 0:
+		e.Kind, e.ID = SessionDestroyedEvent, s.ID
+		if len(e.ID) == 0 {
+			e.ID = p.boundID
+		}
+	case len(p.boundID) != 0 && s.ID != p.boundID:
+		e.Kind, e.ID, e.NewID = SessionRotatedEvent, p.boundID, s.ID
+	}
+	p.boundID = s.ID
+	if len(e.Kind) == 0 || len(e.ID) == 0 {
+		return nil
+	}
+	// The session is saved regardless, so report failure to publish without failing the save.
+	if err := p.bus.Publish(r.Context(), e); err != nil {
+		p.failed(err)
+	}
+	return nil
+}
+
+// PublishSessionEvents returns an Option that publishes a SessionEvent to the given EventBus
+// whenever saving a bound session destroys it or rotates its ID, naming the given origin as the
+// publishing instance. It applies only to sessions from stores that assign IDs, such as
+// kvstore.Store. Failing to publish an event doesn't fail the save; it counts the error per
+// CountErrors instead.
+//
+// Subscribe to the EventBus to keep state held in each instance, such as cached sessions,
+// coherent across the fleet. It panics if the EventBus is nil.
+func PublishSessionEvents(bus EventBus, origin string) Option {
+	if bus == nil {
+		panic("no event bus supplied")
+	}
+	return func(c *bindingConfig) {
+		c.decorators = append(c.decorators, func(r *http.Request, s *sessions.Session) *sessions.Session {
+			if s.Store() == nil {
+				return s
+			}
+			return rebindSession(s, &publishingStore{
+				Store:   s.Store(),
+				bus:     bus,
+				origin:  origin,
+				boundID: s.ID,
+				failed: func(err error) {
+					c.countError(s.Name(), err)
+				},
+			})
+		})
+	}
+}
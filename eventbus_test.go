@@ -0,0 +1,139 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/kvstore"
+)
+
+// memoryPubSub is a handler.PubSub that delivers messages among the subscribers within this
+// process, or fails to publish them if err is not nil.
+type memoryPubSub struct {
+	mu       sync.Mutex
+	channels map[string][]func([]byte)
+	err      error
+}
+
+func (m *memoryPubSub) Publish(_ context.Context, channel string, payload []byte) error {
+	if m.err != nil {
+		return m.err
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	for _, deliver := range m.channels[channel] {
+		deliver(payload)
+	}
+	return nil
+}
+
+func (m *memoryPubSub) Subscribe(channel string, deliver func([]byte)) (func(), error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	if m.channels == nil {
+		m.channels = make(map[string][]func([]byte))
+	}
+	m.channels[channel] = append(m.channels[channel], deliver)
+	return func() {}, nil
+}
+
+func TestPublishSessionEventsPanicsWithNoBus(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.PublishSessionEvents(nil, "a")
+}
+
+func TestNewPubSubBusPanicsWithNoChannel(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.NewPubSubBus(&memoryPubSub{}, "")
+}
+
+func TestLocalBus(t *testing.T) {
+	var bus handler.LocalBus
+	var received []handler.SessionEvent
+	cancel, err := bus.Subscribe(func(e handler.SessionEvent) {
+		received = append(received, e)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bus.Publish(context.Background(), handler.SessionEvent{Kind: handler.SessionDestroyedEvent, ID: "1"})
+	cancel()
+	bus.Publish(context.Background(), handler.SessionEvent{Kind: handler.SessionDestroyedEvent, ID: "2"})
+	if len(received) != 1 || received[0].ID != "1" {
+		t.Errorf("received events: got %+v, want only the first", received)
+	}
+}
+
+func TestPublishSessionEvents(t *testing.T) {
+	bus := handler.NewPubSubBus(&memoryPubSub{}, "sessions")
+	var received []handler.SessionEvent
+	if _, err := bus.Subscribe(func(e handler.SessionEvent) {
+		received = append(received, e)
+	}); err != nil {
+		t.Fatal(err)
+	}
+	store := kvstore.New(kvstore.NewMemory(), securecookie.GenerateRandomKey(32))
+	var ids []string
+	serve := func(cookies []*http.Cookie, f func(s *sessions.Session)) []*http.Cookie {
+		r := httptest.NewRequest("", "/", nil)
+		for _, c := range cookies {
+			r.AddCookie(c)
+		}
+		recorder := httptest.NewRecorder()
+		handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := handler.MustExtractSession(r)
+			f(s)
+			if err := s.Save(r, w); err != nil {
+				t.Fatalf("failed to save session: %v", err)
+			}
+			ids = append(ids, s.ID)
+		}), nil, handler.PublishSessionEvents(bus, "a")).ServeHTTP(recorder, r)
+		return recorder.Result().Cookies()
+	}
+
+	created := serve(nil, func(s *sessions.Session) { s.Values["k"] = "v" })
+	serve(created, func(*sessions.Session) {})
+	rotated := serve(created, func(s *sessions.Session) { s.ID = "" })
+	serve(rotated, func(s *sessions.Session) { s.Options.MaxAge = -1 })
+
+	if len(received) != 2 {
+		t.Fatalf("received events: got %+v, want two", received)
+	}
+	if e := received[0]; e.Kind != handler.SessionRotatedEvent || e.ID != ids[0] || e.NewID != ids[2] || e.Origin != "a" || e.SessionName != "s" {
+		t.Errorf("rotation event: got %+v, want rotation from %q to %q", e, ids[0], ids[2])
+	}
+	if e := received[1]; e.Kind != handler.SessionDestroyedEvent || e.ID != ids[2] {
+		t.Errorf("destruction event: got %+v, want destruction of %q", e, ids[2])
+	}
+}
+
+func TestPublishSessionEventsCountsFailures(t *testing.T) {
+	bus := handler.NewPubSubBus(&memoryPubSub{err: errors.New("unavailable")}, "sessions")
+	var failures int64
+	counters := func(handler.ErrorCategory, string) handler.Counter {
+		return handler.CounterFunc(func(delta int64) { failures += delta })
+	}
+	store := kvstore.New(kvstore.NewMemory(), securecookie.GenerateRandomKey(32))
+	recorder := httptest.NewRecorder()
+	handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := handler.MustExtractSession(r)
+		s.Save(r, w)
+		s.Options.MaxAge = -1
+		if err := s.Save(r, w); err != nil {
+			t.Errorf("failed to save session: %v", err)
+		}
+	}), nil, handler.PublishSessionEvents(bus, "a"), handler.CountErrors(counters)).ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+	if failures != 1 {
+		t.Errorf("counted failures: got %d, want 1", failures)
+	}
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// ExpiryEventPolicy describes when ExpiryEvents notifies clients of their sessions' impending
+// expiry.
+type ExpiryEventPolicy struct {
+	// Deadlines determines when sessions expire, per its Deadlines method.
+	Deadlines TouchPolicy
+	// WarnBefore is how long before a session expires to send the "expiring" event. If not
+	// positive, ExpiryEvents uses one minute.
+	WarnBefore time.Duration
+	// PollInterval is how often to consult the Clock. If not positive, ExpiryEvents uses one
+	// second.
+	PollInterval time.Duration
+	// Source, if not nil, is the SessionSource from which to acquire the session afresh whenever
+	// an event comes due, detecting extensions made by other requests, such as those handled by
+	// TouchHandler, since the stream began. This is only effective with stores that keep session
+	// values on the server, since the request bears the session's cookie as it was when the stream
+	// began.
+	Source SessionSource
+	// Clock reports the current time. If nil, ExpiryEvents uses SystemClock.
+	Clock Clock
+}
+
+func (p ExpiryEventPolicy) warnBefore() time.Duration {
+	if p.WarnBefore > 0 {
+		return p.WarnBefore
+	}
+	return time.Minute
+}
+
+func (p ExpiryEventPolicy) pollInterval() time.Duration {
+	if p.PollInterval > 0 {
+		return p.PollInterval
+	}
+	return time.Second
+}
+
+// writeExpiryEvent writes a server-sent event of the given type bearing the expiration time, and
+// flushes it to the client.
+func writeExpiryEvent(w http.ResponseWriter, f http.Flusher, event string, expires time.Time) {
+	data, _ := json.Marshal(touchResponse{expires.UTC()})
+	fmt.Fprintf(w, "event: %s\ndata: %s\n\n", event, data)
+	f.Flush()
+}
+
+// ExpiryEvents returns an HTTP handler that streams server-sent events (per the text/event-stream
+// media type) notifying the client of the impending expiry of the singular session bound to the
+// request via WithSession, per the policy, for as long as the client stays connected. Each event's
+// data is a JSON object whose "expires" member holds the session's expiration time, as
+// TouchHandler reports. It sends the following events:
+//
+//	expiring  the session expires within the policy's warning period
+//	extended  the session, having been reported as expiring, was since extended
+//	expired   the session expired; the stream ends thereafter
+//
+// It detects extensions only if the policy supplies a Source. It responds to requests bound to a
+// fresh session with HTTP status code 401 with no body, and to requests with no bound session or
+// whose response writer can't flush events with HTTP status code 500 with no body.
+func ExpiryEvents(p ExpiryEventPolicy) http.Handler {
+	clock := clockOrSystem(p.Clock)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := ExtractSession(r)
+		if !ok {
+			sendDefaultResponse(w)
+			return
+		}
+		f, ok := w.(http.Flusher)
+		if !ok {
+			sendDefaultResponse(w)
+			return
+		}
+		if s.IsNew {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		h := w.Header()
+		h.Set("Content-Type", "text/event-stream")
+		h.Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		f.Flush()
+
+		reload := func() {
+			if p.Source == nil {
+				return
+			}
+			if fresh, err := getValidOrNewSessionFrom(s.Name(), p.Source, r); err == nil && !fresh.IsNew {
+				s = fresh
+			}
+		}
+		due := func(s *sessions.Session, now time.Time) (expires time.Time, ok bool) {
+			expires = p.Deadlines.Deadlines(s).Expires()
+			return expires, !expires.IsZero() && !now.Before(expires.Add(-p.warnBefore()))
+		}
+		var warned time.Time
+		ticker := time.NewTicker(p.pollInterval())
+		defer ticker.Stop()
+		for {
+			now := clock.Now()
+			if expires, ok := due(s, now); ok || !warned.IsZero() {
+				reload()
+				expires, ok = due(s, now)
+				switch {
+				case !ok:
+					if !warned.IsZero() && !expires.IsZero() {
+						writeExpiryEvent(w, f, "extended", expires)
+						warned = time.Time{}
+					}
+				case !now.Before(expires):
+					writeExpiryEvent(w, f, "expired", expires)
+					return
+				case !warned.Equal(expires):
+					writeExpiryEvent(w, f, "expiring", expires)
+					warned = expires
+				}
+			}
+			select {
+			case <-r.Context().Done():
+				return
+			case <-ticker.C:
+			}
+		}
+	})
+}
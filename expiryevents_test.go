@@ -0,0 +1,80 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"bufio"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+)
+
+// touchedSource supplies sessions last extended at a time that can change concurrently.
+type touchedSource struct {
+	mu      sync.Mutex
+	touched time.Time
+}
+
+func (s *touchedSource) touch(t time.Time) {
+	s.mu.Lock()
+	s.touched = t
+	s.mu.Unlock()
+}
+
+func (s *touchedSource) New(r *http.Request, name string) (*sessions.Session, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return valuesSource{handler.TouchedAtKey: s.touched.Unix()}.New(r, name)
+}
+
+func TestExpiryEvents(t *testing.T) {
+	start := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := handlertest.NewFakeClock(start)
+	source := &touchedSource{touched: start}
+	server := httptest.NewServer(handler.WithSession("s", source, handler.ExpiryEvents(handler.ExpiryEventPolicy{
+		Deadlines:    handler.TouchPolicy{IdleTimeout: 10 * time.Minute},
+		PollInterval: time.Millisecond,
+		Source:       source,
+		Clock:        clock,
+	}), nil))
+	defer server.Close()
+
+	res, err := http.Get(server.URL)
+	if err != nil {
+		t.Fatalf("failed to connect: %v", err)
+	}
+	defer res.Body.Close()
+	if got, want := res.Header.Get("Content-Type"), "text/event-stream"; got != want {
+		t.Fatalf("content type: got %q, want %q", got, want)
+	}
+	lines := bufio.NewScanner(res.Body)
+	expectEvent := func(event, expires string) {
+		t.Helper()
+		var got []string
+		for len(got) < 3 && lines.Scan() {
+			got = append(got, lines.Text())
+		}
+		want := []string{"event: " + event, `data: {"expires":"` + expires + `"}`, ""}
+		if strings.Join(got, "\n") != strings.Join(want, "\n") {
+			t.Fatalf("event: got %q, want %q", got, want)
+		}
+	}
+
+	clock.Advance(9*time.Minute + 30*time.Second)
+	expectEvent("expiring", "2017-01-01T00:10:00Z")
+	source.touch(clock.Now())
+	expectEvent("extended", "2017-01-01T00:19:30Z")
+	clock.Advance(10 * time.Minute)
+	expectEvent("expired", "2017-01-01T00:19:30Z")
+	if lines.Scan() {
+		t.Errorf("stream continued after expiry with %q", lines.Text())
+	}
+}
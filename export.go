@@ -0,0 +1,124 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"time"
+)
+
+// SessionEnumerator is implemented by server-side session stores that can enumerate the sessions
+// they hold and read their values, such as kvstore.Store.
+type SessionEnumerator interface {
+	// SessionIDs returns the IDs of all the sessions the store holds.
+	SessionIDs(ctx context.Context) ([]string, error)
+	// Values returns the values stored for the session with the given ID.
+	Values(ctx context.Context, id string) (map[interface{}]interface{}, error)
+}
+
+// Exporter collects the values of all the sessions associated with a principal across a set of
+// server-side stores, such as to answer a data subject's request for access to their personal data.
+type Exporter struct {
+	// Stores holds the stores to search, keyed by a name that identifies each in the export.
+	Stores map[string]SessionEnumerator
+	// Matches reports whether a session with the given values belongs to the given principal. If
+	// nil, the Exporter matches sessions whose principal, per SessionPrincipal, is that given.
+	Matches func(values map[interface{}]interface{}, principal string) bool
+	// Clock reports the time of export recorded in the exported document. If nil, the Exporter
+	// uses SystemClock.
+	Clock Clock
+}
+
+// ExportedSession is the record of a single session within an Export.
+type ExportedSession struct {
+	Store  string                 `json:"store"`
+	ID     string                 `json:"id"`
+	Values map[string]interface{} `json:"values"`
+}
+
+// Export is the document that an Exporter produces for a principal.
+type Export struct {
+	Principal  string            `json:"principal"`
+	ExportedAt time.Time         `json:"exported_at"`
+	Sessions   []ExportedSession `json:"sessions"`
+}
+
+func (e *Exporter) matches(values map[interface{}]interface{}, principal string) bool {
+	if e.Matches != nil {
+		return e.Matches(values, principal)
+	}
+	p, ok := principalIn(values)
+	return ok && p == principal
+}
+
+// exportableValue returns v if it can be encoded as JSON, or a textual rendering of it otherwise.
+func exportableValue(v interface{}) interface{} {
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return v
+}
+
+// Collect gathers the sessions associated with the given principal from each of the Exporter's
+// stores, ordered by store name and then by session ID. If a store fails to read a session that it
+// enumerated, such as one that expired in the interim, Collect fails; callers may retry.
+func (e *Exporter) Collect(ctx context.Context, principal string) (*Export, error) {
+	clock := e.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	export := &Export{
+		Principal:  principal,
+		ExportedAt: clock.Now(),
+		Sessions:   []ExportedSession{},
+	}
+	names := make([]string, 0, len(e.Stores))
+	for name := range e.Stores {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		store := e.Stores[name]
+		ids, err := store.SessionIDs(ctx)
+		if err != nil {
+			return nil, fmt.Errorf("enumerating sessions in store %q: %w", name, err)
+		}
+		sort.Strings(ids)
+		for _, id := range ids {
+			values, err := store.Values(ctx, id)
+			if err != nil {
+				return nil, fmt.Errorf("reading session %q in store %q: %w", id, name, err)
+			}
+			if !e.matches(values, principal) {
+				continue
+			}
+			exported := make(map[string]interface{}, len(values))
+			for k, v := range values {
+				exported[fmt.Sprintf("%v", k)] = exportableValue(v)
+			}
+			export.Sessions = append(export.Sessions, ExportedSession{
+				Store:  name,
+				ID:     id,
+				Values: exported,
+			})
+		}
+	}
+	return export, nil
+}
+
+// Export writes the sessions associated with the given principal, as gathered by Collect, to w as
+// an indented JSON document.
+func (e *Exporter) Export(ctx context.Context, principal string, w io.Writer) error {
+	export, err := e.Collect(ctx, principal)
+	if err != nil {
+		return err
+	}
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(export)
+}
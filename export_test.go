@@ -0,0 +1,88 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+type mapEnumerator struct {
+	sessions map[string]map[interface{}]interface{}
+	err      error
+}
+
+func (m mapEnumerator) SessionIDs(context.Context) ([]string, error) {
+	if m.err != nil {
+		return nil, m.err
+	}
+	var ids []string
+	for id := range m.sessions {
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (m mapEnumerator) Values(_ context.Context, id string) (map[interface{}]interface{}, error) {
+	return m.sessions[id], nil
+}
+
+func TestExporter(t *testing.T) {
+	now := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	e := handler.Exporter{
+		Stores: map[string]handler.SessionEnumerator{
+			"b": mapEnumerator{sessions: map[string]map[interface{}]interface{}{
+				"2": {handler.PrincipalKey: "alice", "cart": []string{"book"}},
+				"1": {handler.PrincipalKey: "alice", 3: func() {}},
+				"3": {handler.PrincipalKey: "bob"},
+			}},
+			"a": mapEnumerator{sessions: map[string]map[interface{}]interface{}{
+				"9": {handler.PrincipalKey: "alice"},
+				"8": {},
+			}},
+		},
+		Clock: handler.ClockFunc(func() time.Time { return now }),
+	}
+	export, err := e.Collect(context.Background(), "alice")
+	if err != nil {
+		t.Fatalf("failed to collect sessions: %v", err)
+	}
+	if got, want := export.ExportedAt, now; !got.Equal(want) {
+		t.Errorf("export time: got %v, want %v", got, want)
+	}
+	var got [][2]string
+	for _, s := range export.Sessions {
+		got = append(got, [2]string{s.Store, s.ID})
+	}
+	if want := [][2]string{{"a", "9"}, {"b", "1"}, {"b", "2"}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("sessions: got %v, want %v", got, want)
+	}
+
+	var buf bytes.Buffer
+	if err := e.Export(context.Background(), "alice", &buf); err != nil {
+		t.Fatalf("failed to export sessions: %v", err)
+	}
+	var decoded handler.Export
+	if err := json.Unmarshal(buf.Bytes(), &decoded); err != nil {
+		t.Fatalf("failed to decode export: %v", err)
+	}
+	if got, want := len(decoded.Sessions), 3; got != want {
+		t.Errorf("exported session count: got %d, want %d", got, want)
+	}
+}
+
+func TestExporterFailure(t *testing.T) {
+	expectedError := errors.New("")
+	e := handler.Exporter{Stores: map[string]handler.SessionEnumerator{"a": mapEnumerator{err: expectedError}}}
+	if _, err := e.Collect(context.Background(), "alice"); !errors.Is(err, expectedError) {
+		t.Errorf("error: got %v, want %v", err, expectedError)
+	}
+}
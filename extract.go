@@ -0,0 +1,76 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// ExtractSessionOr retrieves the singular session most recently bound to this request via
+// WithSession, or returns the supplied fallback session if no such session is available.
+func ExtractSessionOr(r *http.Request, fallback *sessions.Session) *sessions.Session {
+	if s, ok := ExtractSession(r); ok {
+		return s
+	}
+	return fallback
+}
+
+// ExtractSessionOrNew retrieves the singular session most recently bound to this request via
+// WithSession, or, if no such session is available, acquires a session with the given name from the
+// supplied SessionSource, treating errors the same way that WithSession does.
+//
+// Note that the session so acquired is not bound to the request, so subsequent calls acquire a
+// session from the SessionSource again.
+func ExtractSessionOrNew(r *http.Request, s SessionSource, name string) (*sessions.Session, error) {
+	if session, ok := ExtractSession(r); ok {
+		return session, nil
+	}
+	return getValidOrNewSessionFrom(name, s, r)
+}
+
+// ExtractSessionNamedOr retrieves the session most recently bound to this request with the given
+// name via WithSessionsNamed, or returns the supplied fallback session if no such session is
+// available.
+func ExtractSessionNamedOr(name string, r *http.Request, fallback *sessions.Session) *sessions.Session {
+	if s, ok := ExtractSessionNamed(name, r); ok {
+		return s
+	}
+	return fallback
+}
+
+// ExtractSessionNamedOrNew retrieves the session most recently bound to this request with the given
+// name via WithSessionsNamed, or, if no such session is available, acquires a session with that
+// name from the supplied SessionSource, treating errors the same way that WithSessionsNamed does.
+// See ExtractSessionOrNew for the caveat about such acquired sessions.
+func ExtractSessionNamedOrNew(name string, r *http.Request, s SessionSource) (*sessions.Session, error) {
+	if session, ok := ExtractSessionNamed(name, r); ok {
+		return session, nil
+	}
+	return getValidOrNewSessionFrom(name, s, r)
+}
+
+// ErrNoSession is the error that ExtractSessionE and ExtractSessionNamedE return when no session is
+// bound to the request.
+var ErrNoSession = errors.New("no session available")
+
+// ExtractSessionE retrieves the singular session most recently bound to this request via
+// WithSession, or returns ErrNoSession if no such session is available.
+func ExtractSessionE(r *http.Request) (*sessions.Session, error) {
+	if s, ok := ExtractSession(r); ok {
+		return s, nil
+	}
+	return nil, ErrNoSession
+}
+
+// ExtractSessionNamedE retrieves the session most recently bound to this request with the given
+// name via WithSessionsNamed, or returns ErrNoSession if no such session is available.
+func ExtractSessionNamedE(name string, r *http.Request) (*sessions.Session, error) {
+	if s, ok := ExtractSessionNamed(name, r); ok {
+		return s, nil
+	}
+	return nil, ErrNoSession
+}
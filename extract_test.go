@@ -0,0 +1,129 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+// requestBoundTo returns a request that has passed through the supplied binding middleware, which
+// must call the handler it's given.
+func requestBoundTo(t *testing.T, bind func(http.Handler) http.Handler) *http.Request {
+	var bound *http.Request
+	bind(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		bound = r
+	})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+	if bound == nil {
+		t.Fatal("delegate handler was not called")
+	}
+	return bound
+}
+
+func TestExtractSessionOr(t *testing.T) {
+	fallback := sessions.NewSession(simpleStore{}, "fallback")
+	if got := handler.ExtractSessionOr(httptest.NewRequest("", "/", nil), fallback); got != fallback {
+		t.Errorf("session: got %v, want fallback", got)
+	}
+	r := requestBoundTo(t, func(h http.Handler) http.Handler {
+		return handler.WithSession("s", simpleStore{}, h, nil)
+	})
+	if got := handler.ExtractSessionOr(r, fallback); got == fallback || got != handler.MustExtractSession(r) {
+		t.Errorf("session: got %v, want bound session", got)
+	}
+}
+
+func TestExtractSessionOrNew(t *testing.T) {
+	var source countingSessionSource
+	session, err := handler.ExtractSessionOrNew(httptest.NewRequest("", "/", nil), &source, "s")
+	if err != nil {
+		t.Fatalf("failed to acquire session: %v", err)
+	}
+	if session == nil || session.Name() != "s" || !session.IsNew {
+		t.Errorf("session: got %v, want a new session named %q", session, "s")
+	}
+	if got, want := source.callCount(), uint(1); got != want {
+		t.Errorf("source call count: got %d, want %d", got, want)
+	}
+
+	r := requestBoundTo(t, func(h http.Handler) http.Handler {
+		return handler.WithSession("s", simpleStore{}, h, nil)
+	})
+	if session, err := handler.ExtractSessionOrNew(r, &source, "s"); err != nil || session != handler.MustExtractSession(r) {
+		t.Errorf("got (%v, %v), want bound session", session, err)
+	}
+	if got, want := source.callCount(), uint(1); got != want {
+		t.Errorf("source call count: got %d, want %d", got, want)
+	}
+
+	expectedError := errors.New("")
+	if _, err := handler.ExtractSessionOrNew(httptest.NewRequest("", "/", nil), failingSessionSource{expectedError}, "s"); !errors.Is(err, expectedError) {
+		t.Errorf("error: got %v, want %v", err, expectedError)
+	}
+	if _, err := handler.ExtractSessionOrNew(httptest.NewRequest("", "/", nil), failingSessionSource{fakeSecureCookieError(true)}, "s"); err != nil {
+		t.Errorf("error: got %v, want none for decoding error", err)
+	}
+}
+
+func TestExtractSessionNamedOr(t *testing.T) {
+	fallback := sessions.NewSession(simpleStore{}, "fallback")
+	r := requestBoundTo(t, func(h http.Handler) http.Handler {
+		return handler.WithSessionsNamed([]string{"s"}, simpleStore{}, h, nil)
+	})
+	if got := handler.ExtractSessionNamedOr("s", r, fallback); got == fallback || got != handler.MustExtractSessionNamed("s", r) {
+		t.Errorf("session: got %v, want bound session", got)
+	}
+	if got := handler.ExtractSessionNamedOr("other", r, fallback); got != fallback {
+		t.Errorf("session: got %v, want fallback", got)
+	}
+}
+
+func TestExtractSessionNamedOrNew(t *testing.T) {
+	var source countingSessionSource
+	r := requestBoundTo(t, func(h http.Handler) http.Handler {
+		return handler.WithSessionsNamed([]string{"s"}, simpleStore{}, h, nil)
+	})
+	if session, err := handler.ExtractSessionNamedOrNew("s", r, &source); err != nil || session != handler.MustExtractSessionNamed("s", r) {
+		t.Errorf("got (%v, %v), want bound session", session, err)
+	}
+	session, err := handler.ExtractSessionNamedOrNew("other", r, &source)
+	if err != nil {
+		t.Fatalf("failed to acquire session: %v", err)
+	}
+	if session == nil || session.Name() != "other" {
+		t.Errorf("session: got %v, want a session named %q", session, "other")
+	}
+	if got, want := source.callCount(), uint(1); got != want {
+		t.Errorf("source call count: got %d, want %d", got, want)
+	}
+}
+
+func TestExtractSessionE(t *testing.T) {
+	if s, err := handler.ExtractSessionE(httptest.NewRequest("", "/", nil)); s != nil || !errors.Is(err, handler.ErrNoSession) {
+		t.Errorf("got (%v, %v), want (nil, %v)", s, err, handler.ErrNoSession)
+	}
+	r := requestBoundTo(t, func(h http.Handler) http.Handler {
+		return handler.WithSession("s", simpleStore{}, h, nil)
+	})
+	if s, err := handler.ExtractSessionE(r); err != nil || s != handler.MustExtractSession(r) {
+		t.Errorf("got (%v, %v), want bound session", s, err)
+	}
+}
+
+func TestExtractSessionNamedE(t *testing.T) {
+	r := requestBoundTo(t, func(h http.Handler) http.Handler {
+		return handler.WithSessionsNamed([]string{"s"}, simpleStore{}, h, nil)
+	})
+	if s, err := handler.ExtractSessionNamedE("s", r); err != nil || s != handler.MustExtractSessionNamed("s", r) {
+		t.Errorf("got (%v, %v), want bound session", s, err)
+	}
+	if s, err := handler.ExtractSessionNamedE("other", r); s != nil || !errors.Is(err, handler.ErrNoSession) {
+		t.Errorf("got (%v, %v), want (nil, %v)", s, err, handler.ErrNoSession)
+	}
+}
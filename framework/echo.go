@@ -0,0 +1,117 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package framework
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo/v4"
+	"github.com/seh/handler"
+)
+
+type echoInvocation struct {
+	c   echo.Context
+	err error
+}
+
+type echoInvocationKey struct{}
+
+func echoInvocationFrom(r *http.Request) *echoInvocation {
+	return r.Context().Value(echoInvocationKey{}).(*echoInvocation)
+}
+
+func adaptEcho(makeHandler func(delegate http.Handler) http.Handler) echo.MiddlewareFunc {
+	return func(next echo.HandlerFunc) echo.HandlerFunc {
+		h := makeHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			inv := echoInvocationFrom(r)
+			inv.c.SetRequest(r)
+			// Route the next handler's response through w, so that Options that adjust the response
+			// header, such as handler.AutoSave, see it before it's written.
+			res := inv.c.Response()
+			original := res.Writer
+			res.Writer = w
+			defer func() { res.Writer = original }()
+			inv.err = next(inv.c)
+		}))
+		return func(c echo.Context) error {
+			inv := echoInvocation{c: c}
+			r := c.Request()
+			h.ServeHTTP(c.Response().Writer, r.WithContext(context.WithValue(r.Context(), echoInvocationKey{}, &inv)))
+			return inv.err
+		}
+	}
+}
+
+func sendEchoDefaultResponse(c echo.Context) error {
+	return c.NoContent(http.StatusInternalServerError)
+}
+
+// EchoWithSession returns Echo middleware that binds a session with the given name to each request,
+// per handler.WithSession, before invoking the next handler. It panics if the supplied
+// SessionSource is nil. If the SessionSource yields an error instead of a session, it returns the
+// result of calling the onError function instead of invoking the next handler. If no such onError
+// function is supplied and an error arises acquiring a session, it will respond with HTTP status
+// code 500 with no body. The supplied Options adjust the binding as they do for
+// handler.WithSession.
+func EchoWithSession(name string, s handler.SessionSource, onError func(c echo.Context, err error) error, opts ...handler.Option) echo.MiddlewareFunc {
+	return adaptEcho(func(delegate http.Handler) http.Handler {
+		return handler.WithSession(name, s, delegate, func(w http.ResponseWriter, r *http.Request, err error) {
+			inv := echoInvocationFrom(r)
+			if onError == nil {
+				inv.err = sendEchoDefaultResponse(inv.c)
+				return
+			}
+			inv.err = onError(inv.c, err)
+		}, opts...)
+	})
+}
+
+// EchoWithSessionsNamed returns Echo middleware that binds sessions with each of the given names to
+// each request, per handler.WithSessionsNamed, before invoking the next handler. It panics if the
+// supplied SessionSource is nil. If the SessionSource yields an error instead of a session, it
+// returns the result of calling the onError function instead of invoking the next handler. If no
+// such onError function is supplied and an error arises acquiring a session, it will respond with
+// HTTP status code 500 with no body. The supplied Options adjust the binding as they do for
+// handler.WithSessionsNamed.
+func EchoWithSessionsNamed(names []string, s handler.SessionSource, onError func(c echo.Context, name string, err error) error, opts ...handler.Option) echo.MiddlewareFunc {
+	return adaptEcho(func(delegate http.Handler) http.Handler {
+		return handler.WithSessionsNamed(names, s, delegate, func(w http.ResponseWriter, r *http.Request, name string, err error) {
+			inv := echoInvocationFrom(r)
+			if onError == nil {
+				inv.err = sendEchoDefaultResponse(inv.c)
+				return
+			}
+			inv.err = onError(inv.c, name, err)
+		}, opts...)
+	})
+}
+
+// ExtractEchoSession retrieves the singular session most recently bound to this request via
+// EchoWithSession, together with a boolean indicating whether such a session is available.
+func ExtractEchoSession(c echo.Context) (*sessions.Session, bool) {
+	return handler.ExtractSession(c.Request())
+}
+
+// MustExtractEchoSession retrieves the singular session most recently bound to this request via
+// EchoWithSession. If no such session is available, it defers to handler.OnMissingSession, which
+// panics by default.
+func MustExtractEchoSession(c echo.Context) *sessions.Session {
+	return handler.MustExtractSession(c.Request())
+}
+
+// ExtractEchoSessionNamed retrieves the session most recently bound to this request with the given
+// name via EchoWithSessionsNamed, together with a boolean indicating whether such a session is
+// available.
+func ExtractEchoSessionNamed(name string, c echo.Context) (*sessions.Session, bool) {
+	return handler.ExtractSessionNamed(name, c.Request())
+}
+
+// MustExtractEchoSessionNamed retrieves the session most recently bound to this request with the
+// given name via EchoWithSessionsNamed. If no such session is available, it defers to
+// handler.OnMissingSession, which panics by default.
+func MustExtractEchoSessionNamed(name string, c echo.Context) *sessions.Session {
+	return handler.MustExtractSessionNamed(name, c.Request())
+}
@@ -0,0 +1,135 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package framework_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/labstack/echo/v4"
+	"github.com/seh/handler"
+	"github.com/seh/handler/framework"
+)
+
+func serveEcho(middleware echo.MiddlewareFunc, h echo.HandlerFunc) *httptest.ResponseRecorder {
+	e := echo.New()
+	e.GET("/", h, middleware)
+	recorder := httptest.NewRecorder()
+	e.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	return recorder
+}
+
+func TestEchoWithSession(t *testing.T) {
+	called := false
+	recorder := serveEcho(framework.EchoWithSession("s", sessionSource{}, nil), func(c echo.Context) error {
+		called = true
+		session, ok := framework.ExtractEchoSession(c)
+		if !ok {
+			t.Fatal("session is not available")
+		}
+		if got, want := session.Name(), "s"; got != want {
+			t.Errorf("session name: got %q, want %q", got, want)
+		}
+		if handler.MustExtractSession(c.Request()) != framework.MustExtractEchoSession(c) {
+			t.Error("sessions extracted from Echo context and request don't match")
+		}
+		return c.NoContent(http.StatusNoContent)
+	})
+	if !called {
+		t.Error("delegate handler was not called")
+	}
+	if got, want := recorder.Code, http.StatusNoContent; got != want {
+		t.Errorf("status code: got %d, want %d", got, want)
+	}
+}
+
+func TestEchoWithSessionPropagatesHandlerError(t *testing.T) {
+	recorder := serveEcho(framework.EchoWithSession("s", sessionSource{}, nil), func(c echo.Context) error {
+		return echo.NewHTTPError(http.StatusTeapot)
+	})
+	if got, want := recorder.Code, http.StatusTeapot; got != want {
+		t.Errorf("status code: got %d, want %d", got, want)
+	}
+}
+
+func TestEchoWithSessionSourceFailure(t *testing.T) {
+	var received error
+	onError := func(c echo.Context, err error) error {
+		received = err
+		return echo.NewHTTPError(http.StatusServiceUnavailable)
+	}
+	recorder := serveEcho(framework.EchoWithSession("s", sessionSource{errSource}, onError), func(c echo.Context) error {
+		t.Error("delegate handler should not have been called")
+		return nil
+	})
+	if !errors.Is(received, errSource) {
+		t.Errorf("error: got %v, want %v", received, errSource)
+	}
+	if got, want := recorder.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("status code: got %d, want %d", got, want)
+	}
+}
+
+func TestEchoWithSessionSourceFailureWithNoErrorHandler(t *testing.T) {
+	recorder := serveEcho(framework.EchoWithSession("s", sessionSource{errSource}, nil), func(c echo.Context) error {
+		t.Error("delegate handler should not have been called")
+		return nil
+	})
+	if got, want := recorder.Code, http.StatusInternalServerError; got != want {
+		t.Errorf("status code: got %d, want %d", got, want)
+	}
+}
+
+func TestEchoWithSessionsNamed(t *testing.T) {
+	names := []string{"s1", "s2"}
+	called := false
+	serveEcho(framework.EchoWithSessionsNamed(names, sessionSource{}, nil), func(c echo.Context) error {
+		called = true
+		for _, name := range names {
+			if _, ok := framework.ExtractEchoSessionNamed(name, c); !ok {
+				t.Errorf("session %q is not available", name)
+			}
+			if got, want := framework.MustExtractEchoSessionNamed(name, c).Name(), name; got != want {
+				t.Errorf("session name: got %q, want %q", got, want)
+			}
+		}
+		return nil
+	})
+	if !called {
+		t.Error("delegate handler was not called")
+	}
+}
+
+func TestEchoWithSessionsNamedSourceFailure(t *testing.T) {
+	var failedName string
+	onError := func(c echo.Context, name string, err error) error {
+		failedName = name
+		return nil
+	}
+	serveEcho(framework.EchoWithSessionsNamed([]string{"s1", "s2"}, sessionSource{errSource}, onError), func(c echo.Context) error {
+		t.Error("delegate handler should not have been called")
+		return nil
+	})
+	if len(failedName) == 0 {
+		t.Error("onError handler was not called")
+	}
+}
+
+func TestEchoWithSessionAutoSave(t *testing.T) {
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	recorder := serveEcho(framework.EchoWithSession("s", store, nil, handler.AutoSave(nil)), func(c echo.Context) error {
+		framework.MustExtractEchoSession(c).Values["k"] = "v"
+		return c.String(http.StatusOK, "body")
+	})
+	if cookies := recorder.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != "s" {
+		t.Errorf("cookies: got %v, want one for session s", cookies)
+	}
+	if got, want := recorder.Body.String(), "body"; got != want {
+		t.Errorf("body: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,23 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package framework_test
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+var errSource = errors.New("failed")
+
+type sessionSource struct {
+	err error
+}
+
+func (s sessionSource) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(nil, name)
+	session.IsNew = true
+	return session, s.err
+}
@@ -0,0 +1,155 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+/*
+Package framework adapts the session-binding HTTP handlers from package handler for use as
+middleware with the Gin and Echo web frameworks.
+
+The middleware binds sessions to the framework's underlying *http.Request exactly as the handlers
+from package handler do, so sessions remain available through both the extraction functions in
+this package and those in package handler.
+*/
+package framework
+
+import (
+	"context"
+	"io"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+type ginContextKey struct{}
+
+func ginContextFrom(r *http.Request) *gin.Context {
+	return r.Context().Value(ginContextKey{}).(*gin.Context)
+}
+
+// ginResponseWriter is a gin.ResponseWriter that writes the response through the
+// http.ResponseWriter that package handler supplies, which may adjust the response header just
+// before it's written, while deferring to Gin's own writer for the rest.
+type ginResponseWriter struct {
+	gin.ResponseWriter
+	w http.ResponseWriter
+}
+
+func (g *ginResponseWriter) WriteHeader(code int) {
+	g.w.WriteHeader(code)
+}
+
+func (g *ginResponseWriter) Write(b []byte) (int, error) {
+	return g.w.Write(b)
+}
+
+func (g *ginResponseWriter) WriteString(s string) (int, error) {
+	return io.WriteString(g.w, s)
+}
+
+func (g *ginResponseWriter) WriteHeaderNow() {
+	if !g.Written() {
+		g.w.WriteHeader(g.Status())
+	}
+	g.ResponseWriter.WriteHeaderNow()
+}
+
+func (g *ginResponseWriter) Flush() {
+	if f, ok := g.w.(http.Flusher); ok {
+		f.Flush()
+	}
+	g.ResponseWriter.Flush()
+}
+
+func (g *ginResponseWriter) Unwrap() http.ResponseWriter {
+	return g.w
+}
+
+func adaptGin(makeHandler func(delegate http.Handler) http.Handler) gin.HandlerFunc {
+	h := makeHandler(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		c := ginContextFrom(r)
+		c.Request = r
+		// Route the remaining handlers' responses through w, so that Options that adjust the
+		// response header, such as handler.AutoSave, see it before it's written.
+		if original := c.Writer; w != http.ResponseWriter(original) {
+			c.Writer = &ginResponseWriter{ResponseWriter: original, w: w}
+			defer func() { c.Writer = original }()
+		}
+		c.Next()
+	}))
+	return func(c *gin.Context) {
+		r := c.Request
+		h.ServeHTTP(c.Writer, r.WithContext(context.WithValue(r.Context(), ginContextKey{}, c)))
+	}
+}
+
+func abortGinWithDefaultResponse(c *gin.Context) {
+	c.AbortWithStatus(http.StatusInternalServerError)
+}
+
+// GinWithSession returns Gin middleware that binds a session with the given name to each request,
+// per handler.WithSession, before invoking the remaining handlers in the chain. It panics if the
+// supplied SessionSource is nil. If the SessionSource yields an error instead of a session, it
+// calls the onError function and aborts the chain. If no such onError function is supplied and an
+// error arises acquiring a session, it will respond with HTTP status code 500 with no body. The
+// supplied Options adjust the binding as they do for handler.WithSession.
+func GinWithSession(name string, s handler.SessionSource, onError func(c *gin.Context, err error), opts ...handler.Option) gin.HandlerFunc {
+	return adaptGin(func(delegate http.Handler) http.Handler {
+		return handler.WithSession(name, s, delegate, func(w http.ResponseWriter, r *http.Request, err error) {
+			c := ginContextFrom(r)
+			if onError == nil {
+				abortGinWithDefaultResponse(c)
+				return
+			}
+			onError(c, err)
+			c.Abort()
+		}, opts...)
+	})
+}
+
+// GinWithSessionsNamed returns Gin middleware that binds sessions with each of the given names to
+// each request, per handler.WithSessionsNamed, before invoking the remaining handlers in the chain.
+// It panics if the supplied SessionSource is nil. If the SessionSource yields an error instead of a
+// session, it calls the onError function and aborts the chain. If no such onError function is
+// supplied and an error arises acquiring a session, it will respond with HTTP status code 500 with
+// no body. The supplied Options adjust the binding as they do for handler.WithSessionsNamed.
+func GinWithSessionsNamed(names []string, s handler.SessionSource, onError func(c *gin.Context, name string, err error), opts ...handler.Option) gin.HandlerFunc {
+	return adaptGin(func(delegate http.Handler) http.Handler {
+		return handler.WithSessionsNamed(names, s, delegate, func(w http.ResponseWriter, r *http.Request, name string, err error) {
+			c := ginContextFrom(r)
+			if onError == nil {
+				abortGinWithDefaultResponse(c)
+				return
+			}
+			onError(c, name, err)
+			c.Abort()
+		}, opts...)
+	})
+}
+
+// ExtractGinSession retrieves the singular session most recently bound to this request via
+// GinWithSession, together with a boolean indicating whether such a session is available.
+func ExtractGinSession(c *gin.Context) (*sessions.Session, bool) {
+	return handler.ExtractSession(c.Request)
+}
+
+// MustExtractGinSession retrieves the singular session most recently bound to this request via
+// GinWithSession. If no such session is available, it defers to handler.OnMissingSession, which
+// panics by default.
+func MustExtractGinSession(c *gin.Context) *sessions.Session {
+	return handler.MustExtractSession(c.Request)
+}
+
+// ExtractGinSessionNamed retrieves the session most recently bound to this request with the given
+// name via GinWithSessionsNamed, together with a boolean indicating whether such a session is
+// available.
+func ExtractGinSessionNamed(name string, c *gin.Context) (*sessions.Session, bool) {
+	return handler.ExtractSessionNamed(name, c.Request)
+}
+
+// MustExtractGinSessionNamed retrieves the session most recently bound to this request with the
+// given name via GinWithSessionsNamed. If no such session is available, it defers to
+// handler.OnMissingSession, which panics by default.
+func MustExtractGinSessionNamed(name string, c *gin.Context) *sessions.Session {
+	return handler.MustExtractSessionNamed(name, c.Request)
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package framework_test
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/framework"
+)
+
+func init() {
+	gin.SetMode(gin.TestMode)
+}
+
+func serveGin(middleware gin.HandlerFunc, h gin.HandlerFunc) *httptest.ResponseRecorder {
+	engine := gin.New()
+	engine.GET("/", middleware, h)
+	recorder := httptest.NewRecorder()
+	engine.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+	return recorder
+}
+
+func TestGinWithSession(t *testing.T) {
+	called := false
+	recorder := serveGin(framework.GinWithSession("s", sessionSource{}, nil), func(c *gin.Context) {
+		called = true
+		session, ok := framework.ExtractGinSession(c)
+		if !ok {
+			t.Fatal("session is not available")
+		}
+		if got, want := session.Name(), "s"; got != want {
+			t.Errorf("session name: got %q, want %q", got, want)
+		}
+		if handler.MustExtractSession(c.Request) != framework.MustExtractGinSession(c) {
+			t.Error("sessions extracted from Gin context and request don't match")
+		}
+		c.Status(http.StatusNoContent)
+	})
+	if !called {
+		t.Error("delegate handler was not called")
+	}
+	if got, want := recorder.Code, http.StatusNoContent; got != want {
+		t.Errorf("status code: got %d, want %d", got, want)
+	}
+}
+
+func TestGinWithSessionSourceFailure(t *testing.T) {
+	var received error
+	onError := func(c *gin.Context, err error) {
+		received = err
+		c.Status(http.StatusServiceUnavailable)
+	}
+	recorder := serveGin(framework.GinWithSession("s", sessionSource{errSource}, onError), func(c *gin.Context) {
+		t.Error("delegate handler should not have been called")
+	})
+	if !errors.Is(received, errSource) {
+		t.Errorf("error: got %v, want %v", received, errSource)
+	}
+	if got, want := recorder.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("status code: got %d, want %d", got, want)
+	}
+}
+
+func TestGinWithSessionSourceFailureWithNoErrorHandler(t *testing.T) {
+	recorder := serveGin(framework.GinWithSession("s", sessionSource{errSource}, nil), func(c *gin.Context) {
+		t.Error("delegate handler should not have been called")
+	})
+	if got, want := recorder.Code, http.StatusInternalServerError; got != want {
+		t.Errorf("status code: got %d, want %d", got, want)
+	}
+}
+
+func TestGinWithSessionsNamed(t *testing.T) {
+	names := []string{"s1", "s2"}
+	called := false
+	serveGin(framework.GinWithSessionsNamed(names, sessionSource{}, nil), func(c *gin.Context) {
+		called = true
+		for _, name := range names {
+			if _, ok := framework.ExtractGinSessionNamed(name, c); !ok {
+				t.Errorf("session %q is not available", name)
+			}
+			if got, want := framework.MustExtractGinSessionNamed(name, c).Name(), name; got != want {
+				t.Errorf("session name: got %q, want %q", got, want)
+			}
+		}
+	})
+	if !called {
+		t.Error("delegate handler was not called")
+	}
+}
+
+func TestGinWithSessionsNamedSourceFailure(t *testing.T) {
+	var failedName string
+	onError := func(c *gin.Context, name string, err error) {
+		failedName = name
+	}
+	serveGin(framework.GinWithSessionsNamed([]string{"s1", "s2"}, sessionSource{errSource}, onError), func(c *gin.Context) {
+		t.Error("delegate handler should not have been called")
+	})
+	if len(failedName) == 0 {
+		t.Error("onError handler was not called")
+	}
+}
+
+func TestGinWithSessionAutoSave(t *testing.T) {
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	recorder := serveGin(framework.GinWithSession("s", store, nil, handler.AutoSave(nil)), func(c *gin.Context) {
+		framework.MustExtractGinSession(c).Values["k"] = "v"
+		c.String(http.StatusOK, "body")
+	})
+	if cookies := recorder.Result().Cookies(); len(cookies) != 1 || cookies[0].Name != "s" {
+		t.Errorf("cookies: got %v, want one for session s", cookies)
+	}
+	if got, want := recorder.Body.String(), "body"; got != want {
+		t.Errorf("body: got %q, want %q", got, want)
+	}
+}
@@ -0,0 +1,169 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+/*
+Package handlertest provides utilities for testing HTTP handlers that use sessions bound by package
+handler, exercising them across multiple requests as a browser would.
+*/
+package handlertest
+
+import (
+	"net/http"
+	"net/http/cookiejar"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+// Client issues requests against an HTTP handler served by a test server, retaining the cookies
+// that the handler sets in a cookie jar for use in subsequent requests, so that sessions created
+// in one request are available in later requests.
+//
+// Client's methods report failures through the testing.TB supplied to NewClient, stopping the test
+// upon failure to issue a request.
+type Client struct {
+	// Server is the test server serving the handler.
+	Server *httptest.Server
+	// HTTP is the HTTP client used to issue requests, bearing the cookie jar.
+	HTTP   *http.Client
+	t      testing.TB
+	source handler.SessionSource
+}
+
+// NewClient starts a test server serving the given HTTP handler and returns a Client that issues
+// requests against it, closing the server when the test completes. The given SessionSource must be
+// able to recover the sessions that the handler saves, so that Session and AssertSessionValue can
+// inspect them; if no such inspection is necessary, the SessionSource may be nil.
+func NewClient(t testing.TB, h http.Handler, s handler.SessionSource) *Client {
+	t.Helper()
+	jar, err := cookiejar.New(nil)
+	if err != nil {
+		t.Fatalf("failed to create cookie jar: %v", err)
+	}
+	server := httptest.NewServer(h)
+	t.Cleanup(server.Close)
+	return &Client{
+		Server: server,
+		HTTP:   &http.Client{Jar: jar},
+		t:      t,
+		source: s,
+	}
+}
+
+func (c *Client) url(path string) string {
+	return c.Server.URL + path
+}
+
+func (c *Client) cookies() []*http.Cookie {
+	u, err := url.Parse(c.Server.URL)
+	if err != nil {
+		c.t.Fatalf("failed to parse server URL: %v", err)
+	}
+	return c.HTTP.Jar.Cookies(u)
+}
+
+// Cookie returns the cookie with the given name that the client would send with its next request,
+// or nil if the client holds no such cookie.
+func (c *Client) Cookie(name string) *http.Cookie {
+	for _, cookie := range c.cookies() {
+		if cookie.Name == name {
+			return cookie
+		}
+	}
+	return nil
+}
+
+// Do issues the given request against the test server.
+func (c *Client) Do(r *http.Request) *http.Response {
+	c.t.Helper()
+	res, err := c.HTTP.Do(r)
+	if err != nil {
+		c.t.Fatalf("failed to issue %s request for %s: %v", r.Method, r.URL, err)
+	}
+	return res
+}
+
+// Get issues a GET request for the given path against the test server.
+func (c *Client) Get(path string) *http.Response {
+	c.t.Helper()
+	r, err := http.NewRequest(http.MethodGet, c.url(path), nil)
+	if err != nil {
+		c.t.Fatalf("failed to create request for %s: %v", path, err)
+	}
+	return c.Do(r)
+}
+
+// PostForm issues a POST request for the given path against the test server, bearing the given
+// form values as its body.
+func (c *Client) PostForm(path string, form url.Values) *http.Response {
+	c.t.Helper()
+	r, err := http.NewRequest(http.MethodPost, c.url(path), strings.NewReader(form.Encode()))
+	if err != nil {
+		c.t.Fatalf("failed to create request for %s: %v", path, err)
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	return c.Do(r)
+}
+
+// LoginAs submits the given credentials as a form to the login handler at the given path,
+// following any redirects, and fails the test unless the final response indicates success and the
+// client holds at least one cookie afterward.
+func (c *Client) LoginAs(path string, credentials url.Values) *http.Response {
+	c.t.Helper()
+	res := c.PostForm(path, credentials)
+	if res.StatusCode >= http.StatusBadRequest {
+		c.t.Fatalf("login at %s failed with status %s", path, res.Status)
+	}
+	if len(c.cookies()) == 0 {
+		c.t.Fatalf("login at %s established no cookies", path)
+	}
+	return res
+}
+
+// GetWithSession issues a GET request for the given path against the test server, first failing
+// the test if the client holds no cookie for the session with the given name.
+func (c *Client) GetWithSession(path, name string) *http.Response {
+	c.t.Helper()
+	if c.Cookie(name) == nil {
+		c.t.Fatalf("client holds no cookie for session %q", name)
+	}
+	return c.Get(path)
+}
+
+// Session recovers the session with the given name from the cookies the client holds, using the
+// SessionSource supplied to NewClient.
+func (c *Client) Session(name string) *sessions.Session {
+	c.t.Helper()
+	if c.source == nil {
+		c.t.Fatal("client has no session source")
+	}
+	r := httptest.NewRequest(http.MethodGet, c.Server.URL, nil)
+	for _, cookie := range c.cookies() {
+		r.AddCookie(cookie)
+	}
+	s, err := c.source.New(r, name)
+	if err != nil {
+		c.t.Fatalf("failed to recover session %q: %v", name, err)
+	}
+	return s
+}
+
+// AssertSessionValue reports an error unless the session with the given name, as recovered by
+// Session, holds a value for the given key that's deeply equal to the wanted value.
+func (c *Client) AssertSessionValue(name string, key, want interface{}) {
+	c.t.Helper()
+	s := c.Session(name)
+	got, ok := s.Values[key]
+	if !ok {
+		c.t.Errorf("session %q holds no value for key %v", name, key)
+		return
+	}
+	if !reflect.DeepEqual(got, want) {
+		c.t.Errorf("session %q value for key %v: got %v, want %v", name, key, got, want)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handlertest_test
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+func makeApp(store *kvstore.Store) http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/login", func(w http.ResponseWriter, r *http.Request) {
+		session := handler.MustExtractSession(r)
+		user := r.PostFormValue("user")
+		if len(user) == 0 {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		session.Values["user"] = user
+		if err := session.Save(r, w); err != nil {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		http.Redirect(w, r, "/whoami", http.StatusSeeOther)
+	})
+	mux.HandleFunc("/whoami", func(w http.ResponseWriter, r *http.Request) {
+		session := handler.MustExtractSession(r)
+		fmt.Fprint(w, session.Values["user"])
+	})
+	return handler.WithSession("s", store, mux, nil)
+}
+
+func readBody(t *testing.T, res *http.Response) string {
+	defer res.Body.Close()
+	b, err := ioutil.ReadAll(res.Body)
+	if err != nil {
+		t.Fatalf("failed to read response body: %v", err)
+	}
+	return string(b)
+}
+
+func TestClientLoginFlow(t *testing.T) {
+	store := kvstore.New(kvstore.NewMemory(), securecookie.GenerateRandomKey(32))
+	c := handlertest.NewClient(t, makeApp(store), store)
+	if c.Cookie("s") != nil {
+		t.Fatal("client holds a session cookie before logging in")
+	}
+	res := c.LoginAs("/login", url.Values{"user": {"alice"}})
+	if got, want := readBody(t, res), "alice"; got != want {
+		t.Errorf("body after login: got %q, want %q", got, want)
+	}
+	if got, want := readBody(t, c.GetWithSession("/whoami", "s")), "alice"; got != want {
+		t.Errorf("body of later request: got %q, want %q", got, want)
+	}
+	c.AssertSessionValue("s", "user", "alice")
+	if session := c.Session("s"); session.IsNew {
+		t.Error("recovered session is new")
+	}
+}
+
+func TestClientWithoutSession(t *testing.T) {
+	store := kvstore.New(kvstore.NewMemory(), securecookie.GenerateRandomKey(32))
+	c := handlertest.NewClient(t, makeApp(store), store)
+	res := c.PostForm("/login", url.Values{})
+	res.Body.Close()
+	if got, want := res.StatusCode, http.StatusUnauthorized; got != want {
+		t.Errorf("status code: got %d, want %d", got, want)
+	}
+	if session := c.Session("s"); !session.IsNew {
+		t.Error("recovered session is not new")
+	}
+}
@@ -0,0 +1,30 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handlertest_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+)
+
+var _ handler.Clock = (*handlertest.FakeClock)(nil)
+
+func TestFakeClock(t *testing.T) {
+	start := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	c := handlertest.NewFakeClock(start)
+	if got := c.Now(); !got.Equal(start) {
+		t.Errorf("initial time: got %v, want %v", got, start)
+	}
+	c.Advance(time.Hour)
+	if got, want := c.Now(), start.Add(time.Hour); !got.Equal(want) {
+		t.Errorf("advanced time: got %v, want %v", got, want)
+	}
+	c.Set(start)
+	if got := c.Now(); !got.Equal(start) {
+		t.Errorf("set time: got %v, want %v", got, start)
+	}
+}
@@ -0,0 +1,153 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handlertest
+
+import (
+	"bytes"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base32"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/gob"
+	"fmt"
+	"math/rand"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler"
+	"github.com/seh/handler/kvstore"
+)
+
+// sortedSerializer is a securecookie.Serializer that encodes maps of session values with their
+// entries sorted by key, so that equal maps always yield equal encodings.
+type sortedSerializer struct{}
+
+type sortedEntry struct {
+	Key   interface{}
+	Value interface{}
+}
+
+func (sortedSerializer) Serialize(src interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	enc := gob.NewEncoder(&buf)
+	m, ok := src.(map[interface{}]interface{})
+	if !ok {
+		if err := enc.Encode(src); err != nil {
+			return nil, err
+		}
+		return buf.Bytes(), nil
+	}
+	entries := make([]sortedEntry, 0, len(m))
+	for k, v := range m {
+		entries = append(entries, sortedEntry{k, v})
+	}
+	sortKey := func(i int) string {
+		k := entries[i].Key
+		return fmt.Sprintf("%T:%v", k, k)
+	}
+	sort.Slice(entries, func(i, j int) bool { return sortKey(i) < sortKey(j) })
+	if err := enc.Encode(entries); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (sortedSerializer) Deserialize(src []byte, dst interface{}) error {
+	dec := gob.NewDecoder(bytes.NewReader(src))
+	m, ok := dst.(*map[interface{}]interface{})
+	if !ok {
+		return dec.Decode(dst)
+	}
+	var entries []sortedEntry
+	if err := dec.Decode(&entries); err != nil {
+		return err
+	}
+	if *m == nil {
+		*m = make(map[interface{}]interface{}, len(entries))
+	}
+	for _, e := range entries {
+		(*m)[e.Key] = e.Value
+	}
+	return nil
+}
+
+// deterministicCodec is a securecookie.Codec that signs but does not encrypt the values it encodes,
+// stamping them with the time reported by its clock rather than the current time.
+type deterministicCodec struct {
+	hashKey []byte
+	clock   handler.Clock
+}
+
+func (c deterministicCodec) mac(name, payload string) []byte {
+	h := hmac.New(sha256.New, c.hashKey)
+	fmt.Fprintf(h, "%s|%s", name, payload)
+	return h.Sum(nil)
+}
+
+func (c deterministicCodec) Encode(name string, value interface{}) (string, error) {
+	b, err := sortedSerializer{}.Serialize(value)
+	if err != nil {
+		return "", err
+	}
+	payload := fmt.Sprintf("%d|%s", c.clock.Now().Unix(), base64.RawURLEncoding.EncodeToString(b))
+	return payload + "|" + base64.RawURLEncoding.EncodeToString(c.mac(name, payload)), nil
+}
+
+func (c deterministicCodec) Decode(name, value string, dst interface{}) error {
+	i := strings.LastIndexByte(value, '|')
+	if i < 0 {
+		return securecookie.ErrMacInvalid
+	}
+	payload := value[:i]
+	mac, err := base64.RawURLEncoding.DecodeString(value[i+1:])
+	if err != nil || !hmac.Equal(mac, c.mac(name, payload)) {
+		return securecookie.ErrMacInvalid
+	}
+	b, err := base64.RawURLEncoding.DecodeString(payload[strings.IndexByte(payload, '|')+1:])
+	if err != nil {
+		return securecookie.ErrMacInvalid
+	}
+	return sortedSerializer{}.Deserialize(b, dst)
+}
+
+// fixedTime is the time that a deterministic store reports when not supplied with a clock.
+var fixedTime = time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+
+// NewDeterministicStore returns a kvstore.Store that keeps session values in the given KV, whose
+// session IDs, cookie values, and stored value encodings depend only on the given seed, the values
+// saved, and the time reported by the given clock, so that tests can compare Set-Cookie headers and
+// stored session payloads against expected values without flaking.
+//
+// Cookies emitted by the store are signed but not encrypted. If the supplied clock is nil, the
+// store uses a FakeClock reporting a fixed time. If the supplied KV is nil, the store keeps its
+// values in a new kvstore.Memory that consults the same clock.
+func NewDeterministicStore(seed int64, clock handler.Clock, kv kvstore.KV) *kvstore.Store {
+	if clock == nil {
+		clock = NewFakeClock(fixedTime)
+	}
+	if kv == nil {
+		kv = &kvstore.Memory{Clock: clock}
+	}
+	var seedBytes [8]byte
+	binary.BigEndian.PutUint64(seedBytes[:], uint64(seed))
+	hashKey := sha256.Sum256(append([]byte("handlertest:"), seedBytes[:]...))
+	s := kvstore.New(kv)
+	s.Codecs = []securecookie.Codec{deterministicCodec{hashKey[:], clock}}
+	s.Serializer = sortedSerializer{}
+	s.Clock = clock
+	var mu sync.Mutex
+	rnd := rand.New(rand.NewSource(seed))
+	s.GenerateID = func() string {
+		var b [20]byte
+		mu.Lock()
+		rnd.Read(b[:])
+		mu.Unlock()
+		return base32.StdEncoding.EncodeToString(b[:])
+	}
+	return s
+}
@@ -0,0 +1,95 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handlertest_test
+
+import (
+	"bytes"
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+type savedState struct {
+	setCookie string
+	payload   []byte
+}
+
+func saveSessionIn(t *testing.T, seed int64, clock handler.Clock) savedState {
+	kv := kvstore.NewMemory()
+	store := handlertest.NewDeterministicStore(seed, clock, kv)
+	r := httptest.NewRequest("", "/", nil)
+	session, err := store.New(r, "s")
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	for i, k := range []string{"a", "b", "c", "d", "e", "f", "g", "h"} {
+		session.Values[k] = i
+	}
+	session.Values[1] = "one"
+	recorder := httptest.NewRecorder()
+	if err := session.Save(r, recorder); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	payload, err := kv.Get(context.Background(), session.ID)
+	if err != nil {
+		t.Fatalf("failed to read stored session: %v", err)
+	}
+	return savedState{recorder.Header().Get("Set-Cookie"), payload}
+}
+
+func TestDeterministicStoreIsStable(t *testing.T) {
+	first := saveSessionIn(t, 1, nil)
+	for i := 0; i < 5; i++ {
+		next := saveSessionIn(t, 1, nil)
+		if got, want := next.setCookie, first.setCookie; got != want {
+			t.Fatalf("Set-Cookie header: got %q, want %q", got, want)
+		}
+		if got, want := next.payload, first.payload; !bytes.Equal(got, want) {
+			t.Fatalf("stored payload: got %x, want %x", got, want)
+		}
+	}
+	if other := saveSessionIn(t, 2, nil); other.setCookie == first.setCookie {
+		t.Error("stores with different seeds produced the same Set-Cookie header")
+	}
+	later := handlertest.NewFakeClock(time.Date(2020, time.January, 1, 0, 0, 0, 0, time.UTC))
+	if other := saveSessionIn(t, 1, later); other.setCookie == first.setCookie {
+		t.Error("stores with different clocks produced the same Set-Cookie header")
+	}
+}
+
+func TestDeterministicStoreRoundTrip(t *testing.T) {
+	store := handlertest.NewDeterministicStore(1, nil, nil)
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	session.Values["k"] = "v"
+	recorder := httptest.NewRecorder()
+	if err := session.Save(r, recorder); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	cookie := recorder.Result().Cookies()[0]
+
+	r = httptest.NewRequest("", "/", nil)
+	r.AddCookie(cookie)
+	session, err := store.New(r, "s")
+	if err != nil {
+		t.Fatalf("failed to recover session: %v", err)
+	}
+	if got, want := session.Values["k"], "v"; got != want {
+		t.Errorf("value: got %v, want %v", got, want)
+	}
+
+	cookie.Value += "x"
+	r = httptest.NewRequest("", "/", nil)
+	r.AddCookie(cookie)
+	_, err = store.New(r, "s")
+	if serr, ok := err.(securecookie.Error); !ok || !serr.IsDecode() {
+		t.Errorf("error for tampered cookie: got %v, want a decoding error", err)
+	}
+}
@@ -0,0 +1,78 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"net/http"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// Handoff transfers sessions between contexts that can't share cookies, such as between sites on
+// different domains or from a browser into a native application's web view. The originating
+// context issues a short-lived, single-use token capturing the current session's values, and the
+// receiving context redeems that token to establish an equivalent session of its own.
+type Handoff struct {
+	// Tokens holds the session values captured for each outstanding token.
+	Tokens TokenStore
+	// Serializer encodes the captured session values. If nil, the Handoff uses
+	// securecookie.GobEncoder, which requires that the types of the values be registered with
+	// encoding/gob.
+	Serializer securecookie.Serializer
+}
+
+func (h *Handoff) serializer() securecookie.Serializer {
+	if h.Serializer != nil {
+		return h.Serializer
+	}
+	return securecookie.GobEncoder{}
+}
+
+// IssueHandoffToken captures the values of the singular session bound to the request via
+// WithSession and returns a token that RedeemHandoffToken can exchange for them once, within the
+// given time to live. It returns ErrNoSession if no session is bound to the request.
+func (h *Handoff) IssueHandoffToken(r *http.Request, ttl time.Duration) (string, error) {
+	session, err := ExtractSessionE(r)
+	if err != nil {
+		return "", err
+	}
+	b, err := h.serializer().Serialize(session.Values)
+	if err != nil {
+		return "", err
+	}
+	token, key := newToken()
+	if err := h.Tokens.Put(r.Context(), key, b, ttl); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// RedeemHandoffToken replaces the values of the singular session bound to the request via
+// WithSession with those captured for the given token, and saves the session, establishing it in
+// the receiving context. For stores that identify sessions by ID, it saves the session under a
+// fresh ID, so that a session ID planted in the receiving context can't acquire the values. It
+// returns ErrTokenNotFound if the token is unknown, expired, or already redeemed, and ErrNoSession
+// if no session is bound to the request.
+func (h *Handoff) RedeemHandoffToken(w http.ResponseWriter, r *http.Request, token string) (*sessions.Session, error) {
+	session, err := ExtractSessionE(r)
+	if err != nil {
+		return nil, err
+	}
+	b, err := h.Tokens.Take(r.Context(), tokenKey(token))
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[interface{}]interface{})
+	if err := h.serializer().Deserialize(b, &values); err != nil {
+		return nil, err
+	}
+	session.Values = values
+	session.ID = ""
+	if err := session.Save(r, w); err != nil {
+		return nil, err
+	}
+	return session, nil
+}
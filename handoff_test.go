@@ -0,0 +1,68 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestHandoff(t *testing.T) {
+	handoff := handler.Handoff{Tokens: &handler.MemoryTokenStore{}}
+	origin := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	destination := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+
+	var token string
+	handler.WithSession("s", origin, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.MustExtractSession(r).Values["uid"] = "alice"
+		var err error
+		if token, err = handoff.IssueHandoffToken(r, time.Minute); err != nil {
+			t.Fatalf("failed to issue token: %v", err)
+		}
+	}), nil).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+
+	redeem := func() (*httptest.ResponseRecorder, error) {
+		recorder := httptest.NewRecorder()
+		var err error
+		handler.WithSession("d", destination, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			_, err = handoff.RedeemHandoffToken(w, r, token)
+		}), nil).ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+		return recorder, err
+	}
+	recorder, err := redeem()
+	if err != nil {
+		t.Fatalf("failed to redeem token: %v", err)
+	}
+	r := httptest.NewRequest("", "/", nil)
+	for _, c := range recorder.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	session, err := destination.New(r, "d")
+	if err != nil {
+		t.Fatalf("failed to recover redeemed session: %v", err)
+	}
+	if got, want := session.Values["uid"], "alice"; got != want {
+		t.Errorf("value: got %v, want %v", got, want)
+	}
+	if _, err := redeem(); err != handler.ErrTokenNotFound {
+		t.Errorf("error redeeming token twice: got %v, want %v", err, handler.ErrTokenNotFound)
+	}
+}
+
+func TestHandoffWithNoSession(t *testing.T) {
+	handoff := handler.Handoff{Tokens: &handler.MemoryTokenStore{}}
+	r := httptest.NewRequest("", "/", nil)
+	if _, err := handoff.IssueHandoffToken(r, time.Minute); err != handler.ErrNoSession {
+		t.Errorf("error: got %v, want %v", err, handler.ErrNoSession)
+	}
+	if _, err := handoff.RedeemHandoffToken(httptest.NewRecorder(), r, "token"); err != handler.ErrNoSession {
+		t.Errorf("error: got %v, want %v", err, handler.ErrNoSession)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+)
+
+// Healther is implemented by session stores and other components that depend on a backend, which
+// can report whether that backend is reachable.
+type Healther interface {
+	// Health returns nil if the component's backend is reachable and functioning, or an error
+	// describing the problem otherwise.
+	Health(ctx context.Context) error
+}
+
+// HealthCheck is the outcome of consulting a single Healther, as reported by HealthHandler.
+type HealthCheck struct {
+	Name    string        `json:"name"`
+	Healthy bool          `json:"healthy"`
+	Latency time.Duration `json:"latency_ns"`
+	Error   string        `json:"error,omitempty"`
+}
+
+// HealthReport is the body of the response from HealthHandler.
+type HealthReport struct {
+	Healthy bool          `json:"healthy"`
+	Checks  []HealthCheck `json:"checks"`
+}
+
+// CheckHealth consults each of the given Healthers concurrently, allowing each at most the given
+// timeout, if positive, and reports their outcomes, ordered by name.
+func CheckHealth(ctx context.Context, checks map[string]Healther, timeout time.Duration) HealthReport {
+	report := HealthReport{
+		Healthy: true,
+		Checks:  make([]HealthCheck, 0, len(checks)),
+	}
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	for name, h := range checks {
+		wg.Add(1)
+		go func(name string, h Healther) {
+			defer wg.Done()
+			ctx := ctx
+			if timeout > 0 {
+				var cancel context.CancelFunc
+				ctx, cancel = context.WithTimeout(ctx, timeout)
+				defer cancel()
+			}
+			start := time.Now()
+			err := h.Health(ctx)
+			check := HealthCheck{
+				Name:    name,
+				Healthy: err == nil,
+				Latency: time.Since(start),
+			}
+			if err != nil {
+				check.Error = err.Error()
+			}
+			mu.Lock()
+			report.Checks = append(report.Checks, check)
+			if err != nil {
+				report.Healthy = false
+			}
+			mu.Unlock()
+		}(name, h)
+	}
+	wg.Wait()
+	sort.Slice(report.Checks, func(i, j int) bool { return report.Checks[i].Name < report.Checks[j].Name })
+	return report
+}
+
+// HealthHandler returns an HTTP handler that reports the health of each of the given Healthers,
+// per CheckHealth, as a JSON-encoded HealthReport. It responds with HTTP status code 200 if all the
+// Healthers are healthy, or 503 otherwise, making it suitable for use as a readiness probe.
+func HealthHandler(checks map[string]Healther, timeout time.Duration) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		report := CheckHealth(r.Context(), checks, timeout)
+		h := w.Header()
+		h.Set("Content-Type", "application/json")
+		h.Set("Cache-Control", "no-store")
+		if !report.Healthy {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}
+		json.NewEncoder(w).Encode(report)
+	})
+}
+
+// Paths at which Healthz serves its probes.
+const (
+	LivenessPath  = "/healthz"
+	ReadinessPath = "/readyz"
+)
+
+// Healthz returns an HTTP handler that answers liveness probes at LivenessPath and readiness probes
+// at ReadinessPath itself, delegating all other requests to the supplied handler. It answers
+// liveness probes with HTTP status code 200 as long as the process can serve requests at all, and
+// readiness probes per HealthHandler with the given Healthers and timeout.
+//
+// Wrap the outermost handler of an application with it, enclosing any middleware that binds
+// sessions, guards against cross-site request forgery, or requires authentication, so that probes
+// bypass all of them: probes bear no cookies or credentials, and shouldn't create sessions or
+// consult the session store except as the readiness checks do. Requests for paths that differ only
+// in a trailing slash or case reach the supplied handler. It panics if the supplied handler is nil.
+func Healthz(checks map[string]Healther, timeout time.Duration, h http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	readiness := HealthHandler(checks, timeout)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.URL.Path {
+		case LivenessPath:
+			header := w.Header()
+			header.Set("Content-Type", "text/plain; charset=utf-8")
+			header.Set("Cache-Control", "no-store")
+			w.Write([]byte("ok\n"))
+		case ReadinessPath:
+			readiness.ServeHTTP(w, r)
+		default:
+			h.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+type healtherFunc func(ctx context.Context) error
+
+func (f healtherFunc) Health(ctx context.Context) error {
+	return f(ctx)
+}
+
+var healthy = healtherFunc(func(context.Context) error { return nil })
+
+func TestCheckHealth(t *testing.T) {
+	report := handler.CheckHealth(context.Background(), map[string]handler.Healther{
+		"b": healthy,
+		"a": healtherFunc(func(context.Context) error { return errors.New("down") }),
+		"c": healtherFunc(func(ctx context.Context) error {
+			<-ctx.Done()
+			return ctx.Err()
+		}),
+	}, time.Millisecond)
+	if report.Healthy {
+		t.Error("report is healthy")
+	}
+	if got, want := len(report.Checks), 3; got != want {
+		t.Fatalf("check count: got %d, want %d", got, want)
+	}
+	for i, want := range []struct {
+		name    string
+		healthy bool
+		err     string
+	}{
+		{"a", false, "down"},
+		{"b", true, ""},
+		{"c", false, context.DeadlineExceeded.Error()},
+	} {
+		got := report.Checks[i]
+		if got.Name != want.name || got.Healthy != want.healthy || got.Error != want.err {
+			t.Errorf("check %d: got %+v, want %+v", i, got, want)
+		}
+	}
+}
+
+func TestHealthHandler(t *testing.T) {
+	tests := []struct {
+		description string
+		checks      map[string]handler.Healther
+		status      int
+	}{
+		{"none", nil, http.StatusOK},
+		{"healthy", map[string]handler.Healther{"store": healthy}, http.StatusOK},
+		{"unhealthy", map[string]handler.Healther{
+			"store": healthy,
+			"cache": healtherFunc(func(context.Context) error { return errors.New("down") }),
+		}, http.StatusServiceUnavailable},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.HealthHandler(test.checks, 0).ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+			if got, want := recorder.Code, test.status; got != want {
+				t.Errorf("status code: got %d, want %d", got, want)
+			}
+			var report handler.HealthReport
+			if err := json.NewDecoder(recorder.Body).Decode(&report); err != nil {
+				t.Fatalf("failed to decode report: %v", err)
+			}
+			if got, want := report.Healthy, test.status == http.StatusOK; got != want {
+				t.Errorf("healthy: got %t, want %t", got, want)
+			}
+			if got, want := len(report.Checks), len(test.checks); got != want {
+				t.Errorf("check count: got %d, want %d", got, want)
+			}
+		})
+	}
+}
+
+func TestHealthzPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.Healthz(nil, 0, nil)
+}
+
+func TestHealthz(t *testing.T) {
+	down := healtherFunc(func(context.Context) error { return errors.New("down") })
+	guarded := func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	h := handler.Healthz(map[string]handler.Healther{"store": down}, time.Second,
+		handler.WithSession("s", simpleStore{}, http.HandlerFunc(guarded), nil))
+	tests := []struct {
+		path string
+		want int
+	}{
+		{handler.LivenessPath, http.StatusOK},
+		{handler.ReadinessPath, http.StatusServiceUnavailable},
+		{"/app", http.StatusUnauthorized},
+		{handler.LivenessPath + "/", http.StatusUnauthorized},
+	}
+	for _, test := range tests {
+		t.Run(test.path, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, test.path, nil))
+			if recorder.Code != test.want {
+				t.Errorf("status: got %d, want %d", recorder.Code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,92 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"net"
+	"net/http"
+	"strings"
+)
+
+// hostPattern matches request hosts, either exactly or, if wildcard is true, those that are
+// subdomains of host. If port is not empty, it matches only hosts bearing that port.
+type hostPattern struct {
+	host     string
+	port     string
+	wildcard bool
+}
+
+func splitHost(s string) (host, port string) {
+	host = s
+	if h, p, err := net.SplitHostPort(s); err == nil {
+		host, port = h, p
+	}
+	host = strings.TrimSuffix(strings.TrimPrefix(host, "["), "]")
+	return strings.TrimSuffix(strings.ToLower(host), "."), port
+}
+
+func parseHostPattern(s string) (hostPattern, bool) {
+	host, port := splitHost(s)
+	p := hostPattern{host: host, port: port}
+	if strings.HasPrefix(host, "*.") {
+		p.host, p.wildcard = host[len("*."):], true
+	}
+	if len(p.host) == 0 || strings.ContainsAny(p.host, "*/ ") {
+		return hostPattern{}, false
+	}
+	return p, true
+}
+
+func (p hostPattern) matches(host, port string) bool {
+	if len(p.port) != 0 && port != p.port {
+		return false
+	}
+	if p.wildcard {
+		return strings.HasSuffix(host, "."+p.host)
+	}
+	return host == p.host
+}
+
+// WithAllowedHosts returns an HTTP handler that delegates only requests whose Host header matches
+// one of the given patterns to the supplied handler, rejecting all others before any session is
+// bound, so that a forged Host header can't influence cookie domains, derived session names, or
+// absolute URLs built from the request. A pattern names a host, such as "example.com", or, by
+// beginning with "*.", all subdomains of a host, such as "*.example.com", which excludes the host
+// itself. A pattern may include a port, such as "localhost:8080", to match only that port;
+// otherwise, it matches the host with any port. Matching ignores case and a trailing period.
+//
+// It delegates rejected requests to the onReject handler. If no such onReject handler is supplied,
+// it will respond with HTTP status code 400 with no body. It panics if the supplied handler is
+// nil, if no patterns are supplied, or if any pattern is malformed.
+func WithAllowedHosts(patterns []string, h http.Handler, onReject http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	if len(patterns) == 0 {
+		panic("no allowed host patterns supplied")
+	}
+	parsed := make([]hostPattern, len(patterns))
+	for i, s := range patterns {
+		p, ok := parseHostPattern(s)
+		if !ok {
+			panic("allowed host pattern " + s + " is malformed")
+		}
+		parsed[i] = p
+	}
+	if onReject == nil {
+		onReject = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		host, port := splitHost(r.Host)
+		for _, p := range parsed {
+			if p.matches(host, port) {
+				h.ServeHTTP(w, r)
+				return
+			}
+		}
+		onReject.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/seh/handler"
+)
+
+func TestWithAllowedHostsPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithAllowedHosts([]string{"example.com"}, nil, nil)
+}
+
+func TestWithAllowedHostsPanicsWithNoPatterns(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithAllowedHosts(nil, http.NotFoundHandler(), nil)
+}
+
+func TestWithAllowedHostsPanicsWithMalformedPattern(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithAllowedHosts([]string{"app.*.example.com"}, http.NotFoundHandler(), nil)
+}
+
+func TestWithAllowedHosts(t *testing.T) {
+	h := handler.WithAllowedHosts([]string{"example.com", "*.example.com", "localhost:8080", "[::1]"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), nil)
+	tests := []struct {
+		host string
+		want int
+	}{
+		{"example.com", http.StatusNoContent},
+		{"EXAMPLE.com.", http.StatusNoContent},
+		{"example.com:8443", http.StatusNoContent},
+		{"app.example.com", http.StatusNoContent},
+		{"deep.app.example.com", http.StatusNoContent},
+		{"localhost:8080", http.StatusNoContent},
+		{"localhost", http.StatusBadRequest},
+		{"localhost:9090", http.StatusBadRequest},
+		{"evilexample.com", http.StatusBadRequest},
+		{"example.com.evil", http.StatusBadRequest},
+		{"[::1]:8080", http.StatusNoContent},
+		{"", http.StatusBadRequest},
+	}
+	for _, test := range tests {
+		t.Run(test.host, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.Host = test.host
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, r)
+			if recorder.Code != test.want {
+				t.Errorf("status: got %d, want %d", recorder.Code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,89 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"net"
+	"net/http"
+)
+
+// trustedPeer reports whether the peer that sent the request, per its RemoteAddr field, has an
+// address within any of the given networks.
+func trustedPeer(r *http.Request, trusted []*net.IPNet) bool {
+	ip := remoteIP(r)
+	if ip == nil {
+		return false
+	}
+	for _, n := range trusted {
+		if n.Contains(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// WithTrustedIdentityHeaders returns an HTTP handler that accepts the principal named in the given
+// request header, or DefaultForwardedUserHeader if empty, by an authenticating proxy in front of
+// the application, such as oauth2-proxy or Pomerium, bridging single sign-on performed by the
+// proxy into sessions. It trusts the header only in requests sent by peers whose addresses lie
+// within the given networks, per the requests' RemoteAddr fields, and so requires that clients
+// can reach the application only through those proxies. It binds the principal to the request via
+// BindPrincipal before delegating further request processing to the supplied handler.
+//
+// If a singular session is bound to the request via WithSession and its principal differs from
+// the one named in the header, it records the named principal in the session with SetPrincipal
+// and saves the session under a fresh ID, defeating session fixation. Should the session belong to
+// a different principal, it first discards all of the session's values, so that the values of one
+// user never carry over to another. If saving the session fails, it responds with HTTP status
+// code 500 with no body.
+//
+// It delegates requests from untrusted peers, and those from trusted peers naming no principal, to
+// the onReject handler. If no such onReject handler is supplied, it will respond with HTTP status
+// code 403 with no body. It panics if no trusted networks or handler are supplied.
+func WithTrustedIdentityHeaders(trusted []*net.IPNet, header string, h http.Handler, onReject http.Handler) http.Handler {
+	if len(trusted) == 0 {
+		panic("no trusted networks supplied")
+	}
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	if onReject == nil {
+		onReject = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+	if len(header) == 0 {
+		header = DefaultForwardedUserHeader
+	}
+	// Copy the networks, so that later changes by the caller don't race with handling requests.
+	trusted = append([]*net.IPNet(nil), trusted...)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !trustedPeer(r, trusted) {
+			onReject.ServeHTTP(w, r)
+			return
+		}
+		principal := r.Header.Get(header)
+		if len(principal) == 0 {
+			onReject.ServeHTTP(w, r)
+			return
+		}
+		if session, ok := ExtractSession(r); ok {
+			if current, ok := SessionPrincipal(session); !ok || current != principal {
+				if ok {
+					for k := range session.Values {
+						delete(session.Values, k)
+					}
+				}
+				SetPrincipal(session, principal)
+				// Rotate the session ID upon authentication, defeating session fixation.
+				session.ID = ""
+				if err := session.Save(r, w); err != nil {
+					sendDefaultResponse(w)
+					return
+				}
+			}
+		}
+		h.ServeHTTP(w, BindPrincipal(r, principal))
+	})
+}
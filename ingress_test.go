@@ -0,0 +1,115 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"errors"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/seh/handler"
+)
+
+func mustParseCIDRs(t *testing.T, cidrs ...string) []*net.IPNet {
+	t.Helper()
+	var nets []*net.IPNet
+	for _, cidr := range cidrs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		nets = append(nets, n)
+	}
+	return nets
+}
+
+func TestWithTrustedIdentityHeadersPanicsWithNoNetworks(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithTrustedIdentityHeaders(nil, "", http.NotFoundHandler(), nil)
+}
+
+func TestWithTrustedIdentityHeadersPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.WithTrustedIdentityHeaders(mustParseCIDRs(t, "10.0.0.0/8"), "", nil, nil)
+}
+
+func TestWithTrustedIdentityHeaders(t *testing.T) {
+	trusted := mustParseCIDRs(t, "10.0.0.0/8")
+	tests := []struct {
+		description string
+		remoteAddr  string
+		user        string
+		source      valuesSource
+		code        int
+		values      map[interface{}]interface{}
+	}{
+		{"untrusted peer", "192.0.2.1:1234", "alice", valuesSource{}, http.StatusForbidden, nil},
+		{"no principal", "10.0.0.1:1234", "", valuesSource{}, http.StatusForbidden, nil},
+		{
+			"anonymous session",
+			"10.0.0.1:1234", "alice",
+			valuesSource{"cart": "socks"},
+			http.StatusOK,
+			map[interface{}]interface{}{"cart": "socks", handler.PrincipalKey: "alice"},
+		},
+		{
+			"same principal",
+			"10.0.0.1:1234", "alice",
+			valuesSource{"cart": "socks", handler.PrincipalKey: "alice"},
+			http.StatusOK,
+			map[interface{}]interface{}{"cart": "socks", handler.PrincipalKey: "alice"},
+		},
+		{
+			"different principal",
+			"10.0.0.1:1234", "bob",
+			valuesSource{"cart": "socks", handler.PrincipalKey: "alice"},
+			http.StatusOK,
+			map[interface{}]interface{}{handler.PrincipalKey: "bob"},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			r := httptest.NewRequest("", "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			if len(test.user) != 0 {
+				r.Header.Set(handler.DefaultForwardedUserHeader, test.user)
+			}
+			recorder := httptest.NewRecorder()
+			h := handler.WithTrustedIdentityHeaders(trusted, "", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if p, _ := handler.ExtractPrincipal(r); p != test.user {
+					t.Errorf("principal: got %q, want %q", p, test.user)
+				}
+				values := handler.MustExtractSession(r).Values
+				if len(values) != len(test.values) {
+					t.Errorf("session values: got %v, want %v", values, test.values)
+				}
+				for k, v := range test.values {
+					if values[k] != v {
+						t.Errorf("session value %v: got %v, want %v", k, values[k], v)
+					}
+				}
+			}), nil)
+			handler.WithSession("s", test.source, h, nil).ServeHTTP(recorder, r)
+			if recorder.Code != test.code {
+				t.Errorf("status code: got %d, want %d", recorder.Code, test.code)
+			}
+		})
+	}
+}
+
+func TestWithTrustedIdentityHeadersSaveFailure(t *testing.T) {
+	r := httptest.NewRequest("", "/", nil)
+	r.RemoteAddr = "10.0.0.1:1234"
+	r.Header.Set("X-Auth-Request-User", "alice")
+	recorder := httptest.NewRecorder()
+	h := handler.WithTrustedIdentityHeaders(mustParseCIDRs(t, "10.0.0.0/8"), "X-Auth-Request-User", http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Error("consuming handler called unexpectedly")
+	}), nil)
+	handler.WithSession("s", failingSaveStore{errors.New("unavailable")}, h, nil).ServeHTTP(recorder, r)
+	if recorder.Code != http.StatusInternalServerError {
+		t.Errorf("status code: got %d, want %d", recorder.Code, http.StatusInternalServerError)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package keys
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+)
+
+// Epoch identifies a key pair, so that values encoded with it can name the key pair needed to
+// decode them.
+type Epoch struct {
+	// ID names the epoch. It must be nonempty and consist only of ASCII letters and digits.
+	ID string
+	Pair
+}
+
+// epochSeparator separates the epoch ID from the encoded value. It's absent from the URL-safe
+// base64 alphabet that securecookie uses.
+const epochSeparator = '.'
+
+func validEpochID(id string) bool {
+	if len(id) == 0 {
+		return false
+	}
+	for _, c := range id {
+		if !('a' <= c && c <= 'z' || 'A' <= c && c <= 'Z' || '0' <= c && c <= '9') {
+			return false
+		}
+	}
+	return true
+}
+
+// epochError is a securecookie.Error reporting that a value names an epoch the EpochCodec doesn't
+// know. It counts as a decoding error, as for a value encoded with an unknown key.
+type epochError string
+
+func (e epochError) Error() string {
+	return fmt.Sprintf("keys: value encoded with unknown key epoch %q", string(e))
+}
+
+func (epochError) IsUsage() bool    { return false }
+func (epochError) IsDecode() bool   { return true }
+func (epochError) IsInternal() bool { return false }
+func (epochError) Cause() error     { return nil }
+
+// EpochCodec is a securecookie.Codec that prefixes each encoded value with the ID of the epoch
+// whose key pair encoded it, so that decoding can go straight to that key pair, rather than trying
+// each key pair in turn as Ring does. That saves effort during long key rotation windows, when many
+// key pairs remain in use. It decodes values lacking such a prefix, such as those encoded before
+// adopting EpochCodec, by trying each key pair in turn.
+type EpochCodec struct {
+	current string
+	byID    map[string]*securecookie.SecureCookie
+	codecs  []securecookie.Codec
+}
+
+// NewEpochCodec returns an EpochCodec that encodes values with the key pair of the first of the
+// given epochs, and decodes values with that of any of them. It panics if no epochs are supplied,
+// or if any epoch's ID is invalid or duplicated.
+func NewEpochCodec(epochs ...Epoch) *EpochCodec {
+	if len(epochs) == 0 {
+		panic(ErrNoKeys)
+	}
+	c := &EpochCodec{
+		current: epochs[0].ID,
+		byID:    make(map[string]*securecookie.SecureCookie, len(epochs)),
+		codecs:  make([]securecookie.Codec, len(epochs)),
+	}
+	for i, e := range epochs {
+		if !validEpochID(e.ID) {
+			panic(fmt.Sprintf("invalid key epoch ID %q", e.ID))
+		}
+		if _, ok := c.byID[e.ID]; ok {
+			panic(fmt.Sprintf("duplicate key epoch ID %q", e.ID))
+		}
+		sc := securecookie.New(e.Hash, e.Block)
+		c.byID[e.ID] = sc
+		c.codecs[i] = sc
+	}
+	return c
+}
+
+// MaxAge sets the maximum age in seconds for the values that the EpochCodec decodes, per
+// securecookie.SecureCookie's MaxAge method. Call it only before using the EpochCodec.
+func (c *EpochCodec) MaxAge(age int) {
+	for _, sc := range c.byID {
+		sc.MaxAge(age)
+	}
+}
+
+// Encode encodes the value with the current epoch's key pair, prefixing the result with that
+// epoch's ID.
+func (c *EpochCodec) Encode(name string, value interface{}) (string, error) {
+	encoded, err := c.byID[c.current].Encode(name, value)
+	if err != nil {
+		return "", err
+	}
+	return c.current + string(epochSeparator) + encoded, nil
+}
+
+// Decode decodes the value with the key pair of the epoch named in its prefix, or, if it bears no
+// such prefix, with each of the key pairs in turn, until one succeeds.
+func (c *EpochCodec) Decode(name, value string, dst interface{}) error {
+	i := strings.IndexByte(value, epochSeparator)
+	if i < 0 {
+		return securecookie.DecodeMulti(name, value, dst, c.codecs...)
+	}
+	id := value[:i]
+	sc, ok := c.byID[id]
+	if !ok {
+		return epochError(id)
+	}
+	return sc.Decode(name, value[i+1:], dst)
+}
+
+// IsStale reports whether the value was encoded with the key pair of an epoch other than the
+// current one, or before adopting EpochCodec, implementing handler.StaleChecker.
+func (c *EpochCodec) IsStale(_, value string) bool {
+	i := strings.IndexByte(value, epochSeparator)
+	return i < 0 || value[:i] != c.current
+}
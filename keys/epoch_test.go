@@ -0,0 +1,70 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package keys_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler/keys"
+)
+
+func TestNewEpochCodecPanics(t *testing.T) {
+	p := newPair()
+	tests := []struct {
+		description string
+		epochs      []keys.Epoch
+	}{
+		{"none", nil},
+		{"empty ID", []keys.Epoch{{ID: "", Pair: p}}},
+		{"invalid ID", []keys.Epoch{{ID: "a.b", Pair: p}}},
+		{"duplicate ID", []keys.Epoch{{ID: "a", Pair: p}, {ID: "a", Pair: newPair()}}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			defer ensurePanicWithValueOccured(t)
+			keys.NewEpochCodec(test.epochs...)
+		})
+	}
+}
+
+func TestEpochCodec(t *testing.T) {
+	oldPair, newPair := newPair(), newPair()
+	old := keys.NewEpochCodec(keys.Epoch{ID: "1", Pair: oldPair})
+	encodedOld, err := old.Encode("s", "old")
+	if err != nil {
+		t.Fatalf("failed to encode value: %v", err)
+	}
+	if !strings.HasPrefix(encodedOld, "1.") {
+		t.Errorf("encoded value %q lacks epoch prefix", encodedOld)
+	}
+	legacy, err := securecookie.New(oldPair.Hash, oldPair.Block).Encode("s", "legacy")
+	if err != nil {
+		t.Fatalf("failed to encode value: %v", err)
+	}
+
+	c := keys.NewEpochCodec(keys.Epoch{ID: "2", Pair: newPair}, keys.Epoch{ID: "1", Pair: oldPair})
+	encodedNew, err := c.Encode("s", "new")
+	if err != nil {
+		t.Fatalf("failed to encode value: %v", err)
+	}
+	if !strings.HasPrefix(encodedNew, "2.") {
+		t.Errorf("encoded value %q lacks epoch prefix", encodedNew)
+	}
+	for encoded, want := range map[string]string{encodedOld: "old", encodedNew: "new", legacy: "legacy"} {
+		var got string
+		if err := c.Decode("s", encoded, &got); err != nil {
+			t.Errorf("failed to decode %q: %v", want, err)
+		} else if got != want {
+			t.Errorf("decoded value: got %q, want %q", got, want)
+		}
+	}
+
+	var dst string
+	err = old.Decode("s", encodedNew, &dst)
+	if serr, ok := err.(securecookie.Error); !ok || !serr.IsDecode() {
+		t.Errorf("error for unknown epoch: got %v, want a decoding error", err)
+	}
+}
@@ -0,0 +1,129 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package keys
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+)
+
+// Provider fetches key pairs from some source, such as a file or a secret manager.
+type Provider interface {
+	// Keys fetches the current key pairs, newest first.
+	Keys(ctx context.Context) ([]Pair, error)
+}
+
+// ProviderFunc adapts an ordinary function to serve as a Provider.
+type ProviderFunc func(ctx context.Context) ([]Pair, error)
+
+// Keys returns the result of calling f.
+func (f ProviderFunc) Keys(ctx context.Context) ([]Pair, error) {
+	return f(ctx)
+}
+
+// RingFile is the JSON representation of a set of key pairs, as read by ParseRingFile. Keys are
+// encoded in standard base64, newest first:
+//
+//	{"keys": [{"hash": "...", "block": "..."}, {"hash": "..."}]}
+type RingFile struct {
+	Keys []RingFileEntry `json:"keys"`
+}
+
+// RingFileEntry is the JSON representation of a single key pair within a RingFile.
+type RingFileEntry struct {
+	Hash  []byte `json:"hash"`
+	Block []byte `json:"block,omitempty"`
+}
+
+// ParseRingFile decodes key pairs from the JSON representation described by RingFile.
+func ParseRingFile(b []byte) ([]Pair, error) {
+	var f RingFile
+	if err := json.Unmarshal(b, &f); err != nil {
+		return nil, fmt.Errorf("keys: malformed key ring: %v", err)
+	}
+	if len(f.Keys) == 0 {
+		return nil, ErrNoKeys
+	}
+	pairs := make([]Pair, len(f.Keys))
+	for i, e := range f.Keys {
+		if len(e.Hash) == 0 {
+			return nil, fmt.Errorf("keys: key pair %d has no hash key", i)
+		}
+		pairs[i] = Pair{Hash: e.Hash, Block: e.Block}
+	}
+	return pairs, nil
+}
+
+// FormatRingFile encodes key pairs in the JSON representation described by RingFile.
+func FormatRingFile(pairs []Pair) ([]byte, error) {
+	f := RingFile{Keys: make([]RingFileEntry, len(pairs))}
+	for i, p := range pairs {
+		f.Keys[i] = RingFileEntry{Hash: p.Hash, Block: p.Block}
+	}
+	return json.MarshalIndent(f, "", "  ")
+}
+
+// SecretProvider returns a Provider that fetches a secret with the given function and parses it as
+// a key ring per ParseRingFile. It adapts secret managers such as HashiCorp Vault or AWS Secrets
+// Manager, whose clients can fetch the secret's value as a string or byte slice:
+//
+//	keys.SecretProvider(func(ctx context.Context) ([]byte, error) {
+//		out, err := client.GetSecretValue(ctx, &secretsmanager.GetSecretValueInput{SecretId: &id})
+//		if err != nil {
+//			return nil, err
+//		}
+//		return []byte(*out.SecretString), nil
+//	})
+func SecretProvider(fetch func(ctx context.Context) ([]byte, error)) Provider {
+	return ProviderFunc(func(ctx context.Context) ([]Pair, error) {
+		b, err := fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+		return ParseRingFile(b)
+	})
+}
+
+// FileProvider is a Provider that reads key pairs from a key ring file, per ParseRingFile. It
+// rereads the file only when its modification time or size changes, so it's cheap to consult
+// frequently, such as via Ring's RefreshEvery method, to watch the file for changes.
+type FileProvider struct {
+	path    string
+	mu      sync.Mutex
+	modTime time.Time
+	size    int64
+	pairs   []Pair
+}
+
+// NewFileProvider returns a FileProvider reading the key ring file at the given path.
+func NewFileProvider(path string) *FileProvider {
+	return &FileProvider{path: path}
+}
+
+// Keys returns the key pairs in the file, rereading it if it has changed since last read.
+func (p *FileProvider) Keys(context.Context) ([]Pair, error) {
+	info, err := os.Stat(p.path)
+	if err != nil {
+		return nil, err
+	}
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.pairs != nil && info.ModTime().Equal(p.modTime) && info.Size() == p.size {
+		return p.pairs, nil
+	}
+	b, err := os.ReadFile(p.path)
+	if err != nil {
+		return nil, err
+	}
+	pairs, err := ParseRingFile(b)
+	if err != nil {
+		return nil, err
+	}
+	p.pairs, p.modTime, p.size = pairs, info.ModTime(), info.Size()
+	return pairs, nil
+}
@@ -0,0 +1,108 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package keys_test
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/seh/handler/keys"
+)
+
+func TestRingFileRoundTrip(t *testing.T) {
+	pairs := []keys.Pair{newPair(), {Hash: newPair().Hash}}
+	b, err := keys.FormatRingFile(pairs)
+	if err != nil {
+		t.Fatalf("failed to format key ring: %v", err)
+	}
+	parsed, err := keys.ParseRingFile(b)
+	if err != nil {
+		t.Fatalf("failed to parse key ring: %v", err)
+	}
+	if got, want := len(parsed), len(pairs); got != want {
+		t.Fatalf("key pair count: got %d, want %d", got, want)
+	}
+	for i := range pairs {
+		if !bytes.Equal(parsed[i].Hash, pairs[i].Hash) || !bytes.Equal(parsed[i].Block, pairs[i].Block) {
+			t.Errorf("key pair %d differs", i)
+		}
+	}
+}
+
+func TestParseRingFileRejectsMalformedInput(t *testing.T) {
+	for _, input := range []string{``, `{}`, `{"keys": []}`, `{"keys": [{"block": "AAAA"}]}`, `{"keys": [{"hash": "!"}]}`} {
+		if _, err := keys.ParseRingFile([]byte(input)); err == nil {
+			t.Errorf("parsed %q without error", input)
+		}
+	}
+}
+
+func TestSecretProvider(t *testing.T) {
+	pair := newPair()
+	b, _ := keys.FormatRingFile([]keys.Pair{pair})
+	p := keys.SecretProvider(func(context.Context) ([]byte, error) { return b, nil })
+	pairs, err := p.Keys(context.Background())
+	if err != nil {
+		t.Fatalf("failed to fetch keys: %v", err)
+	}
+	if len(pairs) != 1 || !bytes.Equal(pairs[0].Hash, pair.Hash) {
+		t.Errorf("key pairs: got %v", pairs)
+	}
+}
+
+func TestFileProviderNoticesChanges(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "keys.json")
+	write := func(pairs []keys.Pair, modTime time.Time) {
+		b, _ := keys.FormatRingFile(pairs)
+		if err := os.WriteFile(path, b, 0600); err != nil {
+			t.Fatalf("failed to write key ring: %v", err)
+		}
+		if err := os.Chtimes(path, modTime, modTime); err != nil {
+			t.Fatalf("failed to set modification time: %v", err)
+		}
+	}
+	first, second := newPair(), newPair()
+	start := time.Now().Add(-time.Hour)
+	write([]keys.Pair{first}, start)
+	p := keys.NewFileProvider(path)
+	r := keys.NewRing(newPair())
+	if changed, err := r.Refresh(context.Background(), p); err != nil || !changed {
+		t.Fatalf("first refresh: got (%v, %v), want (true, nil)", changed, err)
+	}
+	if changed, err := r.Refresh(context.Background(), p); err != nil || changed {
+		t.Fatalf("unchanged refresh: got (%v, %v), want (false, nil)", changed, err)
+	}
+	write([]keys.Pair{second, first}, start.Add(time.Minute))
+	if changed, err := r.Refresh(context.Background(), p); err != nil || !changed {
+		t.Fatalf("refresh after change: got (%v, %v), want (true, nil)", changed, err)
+	}
+	if got := r.Pairs(); !bytes.Equal(got[0].Hash, second.Hash) {
+		t.Error("ring is not using the newest key")
+	}
+}
+
+func TestRefreshEveryStopsWithContext(t *testing.T) {
+	r := keys.NewRing(newPair())
+	next := newPair()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	refreshed := make(chan struct{}, 1)
+	go func() {
+		r.RefreshEvery(ctx, keys.ProviderFunc(func(context.Context) ([]keys.Pair, error) {
+			select {
+			case refreshed <- struct{}{}:
+			default:
+			}
+			return []keys.Pair{next}, nil
+		}), time.Millisecond, nil)
+		close(done)
+	}()
+	<-refreshed
+	cancel()
+	<-done
+}
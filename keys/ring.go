@@ -0,0 +1,165 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+/*
+Package keys supplies cookie authentication and encryption keys to session stores, allowing the keys
+to be loaded from external sources and replaced while the program runs.
+*/
+package keys
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// Pair holds the keys used to authenticate and optionally encrypt cookie values.
+type Pair struct {
+	// Hash authenticates cookie values using HMAC.
+	Hash []byte
+	// Block encrypts cookie values using AES. It's optional.
+	Block []byte
+}
+
+func pairsEqual(a, b []Pair) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if !bytes.Equal(a[i].Hash, b[i].Hash) || !bytes.Equal(a[i].Block, b[i].Block) {
+			return false
+		}
+	}
+	return true
+}
+
+// ErrNoKeys is the error that Ring's Update method returns when given no key pairs.
+var ErrNoKeys = errors.New("keys: no key pairs supplied")
+
+// Ring is a securecookie.Codec that encodes values with the first of its key pairs, and decodes
+// values with each of its key pairs in turn, until one succeeds. Its key pairs can be replaced at
+// any time, such as when an external source rotates them, without disturbing the stores that use
+// the Ring as their codec.
+//
+// Ring is safe for concurrent use.
+type Ring struct {
+	mu     sync.RWMutex
+	pairs  []Pair
+	codecs []securecookie.Codec
+	// probe verifies values against the first key pair without deserializing them.
+	probe  *securecookie.SecureCookie
+	maxAge int
+}
+
+// NewRing returns a Ring using the given key pairs. It panics if no key pairs are supplied.
+func NewRing(pairs ...Pair) *Ring {
+	var r Ring
+	if _, err := r.Update(pairs); err != nil {
+		panic(err)
+	}
+	return &r
+}
+
+func (r *Ring) buildCodecs() {
+	codecs := make([]securecookie.Codec, len(r.pairs))
+	for i, p := range r.pairs {
+		c := securecookie.New(p.Hash, p.Block)
+		if r.maxAge != 0 {
+			c.MaxAge(r.maxAge)
+		}
+		codecs[i] = c
+	}
+	r.codecs = codecs
+	r.probe = securecookie.New(r.pairs[0].Hash, r.pairs[0].Block).SetSerializer(securecookie.NopEncoder{})
+	r.probe.MaxAge(0)
+}
+
+// Update replaces the Ring's key pairs, reporting whether they differ from the key pairs in use
+// previously.
+func (r *Ring) Update(pairs []Pair) (changed bool, err error) {
+	if len(pairs) == 0 {
+		return false, ErrNoKeys
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if pairsEqual(r.pairs, pairs) {
+		return false, nil
+	}
+	r.pairs = append([]Pair(nil), pairs...)
+	r.buildCodecs()
+	return true, nil
+}
+
+// Pairs returns a copy of the Ring's current key pairs.
+func (r *Ring) Pairs() []Pair {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return append([]Pair(nil), r.pairs...)
+}
+
+// MaxAge sets the maximum age in seconds for the values that the Ring decodes, per
+// securecookie.SecureCookie's MaxAge method. It persists across key pair updates.
+func (r *Ring) MaxAge(age int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.maxAge = age
+	r.buildCodecs()
+}
+
+func (r *Ring) currentCodecs() []securecookie.Codec {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+	return r.codecs
+}
+
+// Encode encodes the value using the Ring's first key pair.
+func (r *Ring) Encode(name string, value interface{}) (string, error) {
+	return securecookie.EncodeMulti(name, value, r.currentCodecs()...)
+}
+
+// Decode decodes the value using each of the Ring's key pairs in turn, until one succeeds.
+func (r *Ring) Decode(name, value string, dst interface{}) error {
+	return securecookie.DecodeMulti(name, value, dst, r.currentCodecs()...)
+}
+
+// IsStale reports whether the value was encoded with a key pair other than the Ring's first,
+// implementing handler.StaleChecker. It doesn't consider whether the value has expired.
+func (r *Ring) IsStale(name, value string) bool {
+	r.mu.RLock()
+	probe := r.probe
+	r.mu.RUnlock()
+	var b []byte
+	return probe.Decode(name, value, &b) != nil
+}
+
+// Refresh fetches key pairs from the given Provider and installs them in the Ring, reporting
+// whether they differ from the key pairs in use previously.
+func (r *Ring) Refresh(ctx context.Context, p Provider) (changed bool, err error) {
+	pairs, err := p.Keys(ctx)
+	if err != nil {
+		return false, err
+	}
+	return r.Update(pairs)
+}
+
+// RefreshEvery calls Refresh with the given Provider at the given interval until the supplied
+// context is done. It reports any errors encountered to the onError function, if supplied, and
+// continues to use the Ring's current key pairs after such a failure.
+func (r *Ring) RefreshEvery(ctx context.Context, p Provider, interval time.Duration, onError func(error)) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := r.Refresh(ctx, p); err != nil && onError != nil {
+				onError(err)
+			}
+		}
+	}
+}
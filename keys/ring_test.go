@@ -0,0 +1,151 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package keys_test
+
+import (
+	"context"
+	"errors"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler/keys"
+)
+
+func ensurePanicWithValueOccured(t *testing.T) {
+	if p := recover(); p == nil {
+		t.Error("panic was not called with a non-nil argument")
+	}
+}
+
+func newPair() keys.Pair {
+	return keys.Pair{
+		Hash:  securecookie.GenerateRandomKey(32),
+		Block: securecookie.GenerateRandomKey(16),
+	}
+}
+
+func TestNewRingPanicsWithNoKeys(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	keys.NewRing()
+}
+
+func TestRingRotation(t *testing.T) {
+	oldPair, newPair := newPair(), newPair()
+	r := keys.NewRing(oldPair)
+	encoded, err := r.Encode("s", "v")
+	if err != nil {
+		t.Fatalf("failed to encode value: %v", err)
+	}
+	changed, err := r.Update([]keys.Pair{newPair, oldPair})
+	if err != nil {
+		t.Fatalf("failed to update keys: %v", err)
+	}
+	if !changed {
+		t.Error("update with new keys reported no change")
+	}
+	var decoded string
+	if err := r.Decode("s", encoded, &decoded); err != nil {
+		t.Fatalf("failed to decode value encoded with old key: %v", err)
+	}
+	if decoded != "v" {
+		t.Errorf("decoded value: got %q, want %q", decoded, "v")
+	}
+	if changed, _ := r.Update([]keys.Pair{newPair, oldPair}); changed {
+		t.Error("update with same keys reported a change")
+	}
+	if _, err := r.Update([]keys.Pair{newPair}); err != nil {
+		t.Fatalf("failed to update keys: %v", err)
+	}
+	if err := r.Decode("s", encoded, &decoded); err == nil {
+		t.Error("decoded value encoded with retired key")
+	}
+	if _, err := r.Update(nil); err != keys.ErrNoKeys {
+		t.Errorf("error updating with no keys: got %v, want %v", err, keys.ErrNoKeys)
+	}
+	if got, want := len(r.Pairs()), 1; got != want {
+		t.Errorf("key pair count: got %d, want %d", got, want)
+	}
+}
+
+func TestRingServesAsStoreCodec(t *testing.T) {
+	r := keys.NewRing(newPair())
+	store := sessions.NewCookieStore()
+	store.Codecs = []securecookie.Codec{r}
+	req := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(req, "s")
+	session.Values["k"] = "v"
+	recorder := httptest.NewRecorder()
+	if err := session.Save(req, recorder); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if _, err := r.Update([]keys.Pair{newPair(), r.Pairs()[0]}); err != nil {
+		t.Fatalf("failed to rotate keys: %v", err)
+	}
+	req = httptest.NewRequest("", "/", nil)
+	req.AddCookie(recorder.Result().Cookies()[0])
+	session, err := store.New(req, "s")
+	if err != nil {
+		t.Fatalf("failed to recover session after rotation: %v", err)
+	}
+	if got, want := session.Values["k"], "v"; got != want {
+		t.Errorf("value: got %v, want %v", got, want)
+	}
+}
+
+func TestRingRefresh(t *testing.T) {
+	r := keys.NewRing(newPair())
+	next := newPair()
+	changed, err := r.Refresh(context.Background(), keys.ProviderFunc(func(context.Context) ([]keys.Pair, error) {
+		return []keys.Pair{next}, nil
+	}))
+	if err != nil || !changed {
+		t.Fatalf("refresh: got (%v, %v), want (true, nil)", changed, err)
+	}
+	expected := errors.New("unavailable")
+	_, err = r.Refresh(context.Background(), keys.ProviderFunc(func(context.Context) ([]keys.Pair, error) {
+		return nil, expected
+	}))
+	if err != expected {
+		t.Errorf("error: got %v, want %v", err, expected)
+	}
+	if got := r.Pairs(); len(got) != 1 || string(got[0].Hash) != string(next.Hash) {
+		t.Error("failed refresh disturbed the current keys")
+	}
+}
+
+func TestIsStale(t *testing.T) {
+	oldPair, newPair := newPair(), newPair()
+	ring := keys.NewRing(oldPair)
+	epochs := keys.NewEpochCodec(keys.Epoch{ID: "1", Pair: oldPair})
+	encodedRing, err := ring.Encode("s", "v")
+	if err != nil {
+		t.Fatalf("failed to encode value: %v", err)
+	}
+	encodedEpoch, err := epochs.Encode("s", "v")
+	if err != nil {
+		t.Fatalf("failed to encode value: %v", err)
+	}
+	if ring.IsStale("s", encodedRing) {
+		t.Error("ring: value encoded with the current key pair is stale")
+	}
+	if epochs.IsStale("s", encodedEpoch) {
+		t.Error("epochs: value encoded in the current epoch is stale")
+	}
+
+	if _, err := ring.Update([]keys.Pair{newPair, oldPair}); err != nil {
+		t.Fatalf("failed to update key pairs: %v", err)
+	}
+	epochs = keys.NewEpochCodec(keys.Epoch{ID: "2", Pair: newPair}, keys.Epoch{ID: "1", Pair: oldPair})
+	if !ring.IsStale("s", encodedRing) {
+		t.Error("ring: value encoded with a former key pair is not stale")
+	}
+	if !epochs.IsStale("s", encodedEpoch) {
+		t.Error("epochs: value encoded in a former epoch is not stale")
+	}
+	if !epochs.IsStale("s", encodedRing) {
+		t.Error("epochs: value encoded without an epoch is not stale")
+	}
+}
@@ -0,0 +1,133 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"sort"
+	"strings"
+)
+
+// Redactor transforms a session value before an administrator sees it, such as by masking it. It
+// receives the value's key along with the value itself.
+type Redactor func(key, value interface{}) interface{}
+
+const redacted = "[redacted]"
+
+// RedactAll is a Redactor that masks every value, revealing only its type.
+func RedactAll(_, value interface{}) interface{} {
+	return fmt.Sprintf("%s %T", redacted, value)
+}
+
+// RedactKeys returns a Redactor that masks the values for the given keys, revealing only their
+// types, and reveals all other values.
+func RedactKeys(keys ...interface{}) Redactor {
+	masked := make(map[interface{}]struct{}, len(keys))
+	for _, k := range keys {
+		masked[k] = struct{}{}
+	}
+	return func(key, value interface{}) interface{} {
+		if _, ok := masked[key]; ok {
+			return RedactAll(key, value)
+		}
+		return value
+	}
+}
+
+// AdminHandler is an http.Handler that lets operators list, inspect, and delete the sessions held
+// in a Store whose KV implements Lister. It serves the following requests, with paths relative to
+// where it's mounted (for example, via http.StripPrefix):
+//
+//	GET    /      list the IDs of all sessions, as a JSON array
+//	GET    /{id}  show the session's values, as a JSON object, after redaction
+//	DELETE /{id}  delete the session
+type AdminHandler struct {
+	store     *Store
+	authorize func(r *http.Request) bool
+	redact    Redactor
+}
+
+// NewAdminHandler returns an AdminHandler for the given Store. Every request must first pass the
+// given authorize function, or the handler responds with HTTP status code 403. Session values pass
+// through the given Redactor before display; if it's nil, the handler uses RedactAll. It panics if
+// either the Store or authorize function is nil.
+func NewAdminHandler(s *Store, authorize func(r *http.Request) bool, redact Redactor) *AdminHandler {
+	if s == nil {
+		panic("no store supplied")
+	}
+	if authorize == nil {
+		panic("no authorization function supplied")
+	}
+	if redact == nil {
+		redact = RedactAll
+	}
+	return &AdminHandler{s, authorize, redact}
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(v)
+}
+
+func (h *AdminHandler) writeError(w http.ResponseWriter, err error) {
+	if err == ErrNotFound {
+		http.Error(w, "session not found", http.StatusNotFound)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+// displayableValue returns v if it can be encoded as JSON, or a textual rendering of it otherwise.
+func displayableValue(v interface{}) interface{} {
+	if _, err := json.Marshal(v); err != nil {
+		return fmt.Sprintf("%v", v)
+	}
+	return v
+}
+
+func (h *AdminHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	if !h.authorize(r) {
+		http.Error(w, http.StatusText(http.StatusForbidden), http.StatusForbidden)
+		return
+	}
+	ctx := r.Context()
+	id := strings.TrimPrefix(r.URL.Path, "/")
+	switch {
+	case len(id) == 0 && r.Method == http.MethodGet:
+		ids, err := h.store.SessionIDs(ctx)
+		if err != nil {
+			h.writeError(w, err)
+			return
+		}
+		sort.Strings(ids)
+		if ids == nil {
+			ids = []string{}
+		}
+		writeJSON(w, ids)
+	case len(id) != 0 && r.Method == http.MethodGet:
+		values, err := h.store.Values(ctx, id)
+		if err != nil {
+			h.writeError(w, err)
+			return
+		}
+		shown := make(map[string]interface{}, len(values))
+		for k, v := range values {
+			shown[fmt.Sprintf("%v", k)] = displayableValue(h.redact(k, v))
+		}
+		writeJSON(w, struct {
+			ID     string                 `json:"id"`
+			Values map[string]interface{} `json:"values"`
+		}{id, shown})
+	case len(id) != 0 && r.Method == http.MethodDelete:
+		if err := h.store.Delete(ctx, id); err != nil {
+			h.writeError(w, err)
+			return
+		}
+		w.WriteHeader(http.StatusNoContent)
+	default:
+		http.Error(w, http.StatusText(http.StatusMethodNotAllowed), http.StatusMethodNotAllowed)
+	}
+}
@@ -0,0 +1,132 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/seh/handler/kvstore"
+)
+
+func saveSessionWith(t *testing.T, store *kvstore.Store, values map[interface{}]interface{}) string {
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	for k, v := range values {
+		session.Values[k] = v
+	}
+	if err := session.Save(r, httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	return session.ID
+}
+
+func allow(*http.Request) bool { return true }
+
+func serveAdmin(h http.Handler, method, path string) *httptest.ResponseRecorder {
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest(method, path, nil))
+	return recorder
+}
+
+func TestNewAdminHandlerPanicsWithNoAuthorization(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	kvstore.NewAdminHandler(makeStore(kvstore.NewMemory()), nil, nil)
+}
+
+func TestAdminHandlerRequiresAuthorization(t *testing.T) {
+	h := kvstore.NewAdminHandler(makeStore(kvstore.NewMemory()), func(*http.Request) bool { return false }, nil)
+	if got, want := serveAdmin(h, http.MethodGet, "/").Code, http.StatusForbidden; got != want {
+		t.Errorf("status code: got %d, want %d", got, want)
+	}
+}
+
+func TestAdminHandlerListsSessions(t *testing.T) {
+	store := makeStore(kvstore.NewMemory())
+	h := kvstore.NewAdminHandler(store, allow, nil)
+	recorder := serveAdmin(h, http.MethodGet, "/")
+	if got, want := strings.TrimSpace(recorder.Body.String()), "[]"; got != want {
+		t.Errorf("empty listing: got %s, want %s", got, want)
+	}
+	id1 := saveSessionWith(t, store, nil)
+	id2 := saveSessionWith(t, store, nil)
+	var ids []string
+	if err := json.NewDecoder(serveAdmin(h, http.MethodGet, "/").Body).Decode(&ids); err != nil {
+		t.Fatalf("failed to decode listing: %v", err)
+	}
+	if got, want := len(ids), 2; got != want {
+		t.Fatalf("session count: got %d, want %d", got, want)
+	}
+	for _, id := range []string{id1, id2} {
+		if id != ids[0] && id != ids[1] {
+			t.Errorf("session %q is not listed", id)
+		}
+	}
+}
+
+func TestAdminHandlerRedactsValues(t *testing.T) {
+	store := makeStore(kvstore.NewMemory())
+	id := saveSessionWith(t, store, map[interface{}]interface{}{"user": "alice", "token": "secret"})
+	tests := []struct {
+		description string
+		redact      kvstore.Redactor
+		want        map[string]string
+	}{
+		{"default", nil, map[string]string{"user": "[redacted] string", "token": "[redacted] string"}},
+		{"selected keys", kvstore.RedactKeys("token"), map[string]string{"user": "alice", "token": "[redacted] string"}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			h := kvstore.NewAdminHandler(store, allow, test.redact)
+			recorder := serveAdmin(h, http.MethodGet, "/"+id)
+			if got, want := recorder.Code, http.StatusOK; got != want {
+				t.Fatalf("status code: got %d, want %d", got, want)
+			}
+			var body struct {
+				ID     string            `json:"id"`
+				Values map[string]string `json:"values"`
+			}
+			if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+				t.Fatalf("failed to decode session: %v", err)
+			}
+			if body.ID != id {
+				t.Errorf("ID: got %q, want %q", body.ID, id)
+			}
+			for k, want := range test.want {
+				if got := body.Values[k]; got != want {
+					t.Errorf("value %q: got %q, want %q", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAdminHandlerDeletesSessions(t *testing.T) {
+	store := makeStore(kvstore.NewMemory())
+	id := saveSessionWith(t, store, nil)
+	h := kvstore.NewAdminHandler(store, allow, nil)
+	if got, want := serveAdmin(h, http.MethodDelete, "/"+id).Code, http.StatusNoContent; got != want {
+		t.Errorf("status code for deletion: got %d, want %d", got, want)
+	}
+	if got, want := serveAdmin(h, http.MethodGet, "/"+id).Code, http.StatusNotFound; got != want {
+		t.Errorf("status code for deleted session: got %d, want %d", got, want)
+	}
+	if _, err := store.Values(context.Background(), id); err != kvstore.ErrNotFound {
+		t.Errorf("error: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+	if got, want := serveAdmin(h, http.MethodPost, "/").Code, http.StatusMethodNotAllowed; got != want {
+		t.Errorf("status code for unsupported method: got %d, want %d", got, want)
+	}
+}
+
+func TestAdminHandlerWithoutLister(t *testing.T) {
+	h := kvstore.NewAdminHandler(makeStore(failingKV{}), allow, nil)
+	if got, want := serveAdmin(h, http.MethodGet, "/").Code, http.StatusInternalServerError; got != want {
+		t.Errorf("status code: got %d, want %d", got, want)
+	}
+}
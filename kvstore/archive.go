@@ -0,0 +1,165 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore
+
+import (
+	"bufio"
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// archivedSession is a line of the archive that Export writes.
+type archivedSession struct {
+	ID string `json:"id"`
+	// ExpiresAt is when the session expires, or nil if the KV can't tell or the session never
+	// expires.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+	// Values holds the session's values, encoded by the store's Serializer and, if Encrypted,
+	// encrypted with the store's ArchiveKey.
+	Values    []byte `json:"values"`
+	Encrypted bool   `json:"encrypted,omitempty"`
+}
+
+// ErrArchiveKeyRequired is the error that Import returns upon encountering an encrypted session
+// in an archive when the Store has no ArchiveKey.
+var ErrArchiveKeyRequired = errors.New("kvstore: archive is encrypted, but store has no archive key")
+
+func (s *Store) archiveCipher() (cipher.AEAD, error) {
+	block, err := aes.NewCipher(s.ArchiveKey)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
+
+// Export writes all the sessions that the store holds to w as an archive, for disaster recovery or
+// for cloning the sessions into another environment via Import. The archive holds a JSON object
+// per line for each session, bearing its ID, its values as encoded by the store's Serializer, and
+// when it expires, if the KV reports that via TTLReporter. If the store has an ArchiveKey, it encrypts
+// each session's values with that key using AES-GCM. Sessions that expire while it runs are
+// skipped. It returns ErrListingUnsupported if the KV does not implement Lister.
+func (s *Store) Export(ctx context.Context, w io.Writer) error {
+	ids, err := s.SessionIDs(ctx)
+	if err != nil {
+		return err
+	}
+	var aead cipher.AEAD
+	if s.ArchiveKey != nil {
+		if aead, err = s.archiveCipher(); err != nil {
+			return err
+		}
+	}
+	ttls, _ := s.kv.(TTLReporter)
+	bw := bufio.NewWriter(w)
+	enc := json.NewEncoder(bw)
+	for _, id := range ids {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		a := archivedSession{ID: id}
+		if ttls != nil {
+			ttl, err := ttls.TTL(ctx, s.key(id))
+			if err == ErrNotFound {
+				continue
+			} else if err == ErrTTLUnsupported {
+				ttl = 0
+			} else if err != nil {
+				return fmt.Errorf("reading expiry of session %q: %w", id, err)
+			}
+			if ttl > 0 {
+				expires := s.now().Add(ttl)
+				a.ExpiresAt = &expires
+			}
+		}
+		values, err := s.Values(ctx, id)
+		if err == ErrNotFound {
+			continue
+		} else if err != nil {
+			return fmt.Errorf("reading session %q: %w", id, err)
+		}
+		if a.Values, err = s.serializer().Serialize(values); err != nil {
+			return fmt.Errorf("encoding session %q: %w", id, err)
+		}
+		if aead != nil {
+			nonce := securecookie.GenerateRandomKey(aead.NonceSize())
+			a.Values = aead.Seal(nonce, nonce, a.Values, []byte(id))
+			a.Encrypted = true
+		}
+		if err := enc.Encode(a); err != nil {
+			return err
+		}
+	}
+	return bw.Flush()
+}
+
+// Import reads an archive written by Export from r, saving each session it holds to the store's
+// KV under the session's original ID, so that clients' cookies for those sessions remain valid
+// provided the store shares the exporting store's codecs. It gives each session the lifetime it
+// had left when exported, skipping those that have since expired, or the lifetime per the store's
+// Options if the archive doesn't record when the session expires. It decrypts encrypted sessions
+// with the store's ArchiveKey, returning ErrArchiveKeyRequired if the store has none.
+func (s *Store) Import(ctx context.Context, r io.Reader) error {
+	var aead cipher.AEAD
+	var err error
+	dec := json.NewDecoder(r)
+	for n := 1; ; n++ {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		var a archivedSession
+		if err := dec.Decode(&a); err == io.EOF {
+			return nil
+		} else if err != nil {
+			return fmt.Errorf("reading archived session %d: %w", n, err)
+		}
+		if len(a.ID) == 0 {
+			return fmt.Errorf("archived session %d has no ID", n)
+		}
+		ttl := time.Duration(s.Options.MaxAge) * time.Second
+		if a.ExpiresAt != nil {
+			if ttl = a.ExpiresAt.Sub(s.now()); ttl <= 0 {
+				continue
+			}
+		}
+		b := a.Values
+		if a.Encrypted {
+			if s.ArchiveKey == nil {
+				return ErrArchiveKeyRequired
+			}
+			if aead == nil {
+				if aead, err = s.archiveCipher(); err != nil {
+					return err
+				}
+			}
+			if len(b) < aead.NonceSize() {
+				return fmt.Errorf("archived session %q is truncated", a.ID)
+			}
+			if b, err = aead.Open(nil, b[:aead.NonceSize()], b[aead.NonceSize():], []byte(a.ID)); err != nil {
+				return fmt.Errorf("decrypting archived session %q: %w", a.ID, err)
+			}
+		}
+		values := make(map[interface{}]interface{})
+		if err := s.serializer().Deserialize(b, &values); err != nil {
+			return fmt.Errorf("decoding archived session %q: %w", a.ID, err)
+		}
+		stored, err := s.externalizeBlobs(ctx, a.ID, values, ttl)
+		if err != nil {
+			return err
+		}
+		if b, err = s.serializer().Serialize(stored); err != nil {
+			return err
+		}
+		if err := s.kv.Set(ctx, s.key(a.ID), b, ttl); err != nil {
+			return fmt.Errorf("saving archived session %q: %w", a.ID, err)
+		}
+	}
+}
@@ -0,0 +1,75 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestExportImport(t *testing.T) {
+	ctx := context.Background()
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	newStore := func(archiveKey []byte) *kvstore.Store {
+		kv := kvstore.NewMemory()
+		kv.Clock = clock
+		s := kvstore.New(kv, []byte("hash-key"))
+		s.Clock = clock
+		s.ArchiveKey = archiveKey
+		return s
+	}
+	src := newStore(securecookie.GenerateRandomKey(32))
+	for id, maxAge := range map[string]int{"a": 3600, "b": 60} {
+		session := sessions.NewSession(src, "s")
+		session.ID = id
+		session.Options = &sessions.Options{MaxAge: maxAge}
+		session.Values["user"] = "user-" + id
+		if err := src.SaveDetached(ctx, session); err != nil {
+			t.Fatalf("failed to save session %q: %v", id, err)
+		}
+	}
+	var archive bytes.Buffer
+	if err := src.Export(ctx, &archive); err != nil {
+		t.Fatalf("failed to export sessions: %v", err)
+	}
+	if lines := strings.Count(archive.String(), "\n"); lines != 2 {
+		t.Errorf("archive lines: got %d, want 2", lines)
+	}
+	if strings.Contains(archive.String(), "user-a") {
+		t.Error("encrypted archive reveals session values")
+	}
+
+	if err := newStore(nil).Import(ctx, bytes.NewReader(archive.Bytes())); !errors.Is(err, kvstore.ErrArchiveKeyRequired) {
+		t.Errorf("import without archive key: got %v, want %v", err, kvstore.ErrArchiveKeyRequired)
+	}
+	if err := newStore(securecookie.GenerateRandomKey(32)).Import(ctx, bytes.NewReader(archive.Bytes())); err == nil {
+		t.Error("imported archive with the wrong key")
+	}
+
+	clock.Advance(2 * time.Minute)
+	dst := newStore(src.ArchiveKey)
+	if err := dst.Import(ctx, &archive); err != nil {
+		t.Fatalf("failed to import sessions: %v", err)
+	}
+	ids, err := dst.SessionIDs(ctx)
+	if err != nil || len(ids) != 1 || ids[0] != "a" {
+		t.Fatalf("imported sessions: got %v, %v, want only the unexpired one", ids, err)
+	}
+	if values, err := dst.Values(ctx, "a"); err != nil || values["user"] != "user-a" {
+		t.Errorf("imported values: got %v, %v, want user %q", values, err, "user-a")
+	}
+	clock.Advance(time.Hour)
+	if _, err := dst.Values(ctx, "a"); err != kvstore.ErrNotFound {
+		t.Errorf("imported session after its original expiry: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+}
@@ -0,0 +1,81 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+// attemptStore is a handler.AttemptStore that records failures in a KV.
+type attemptStore struct {
+	kv     KV
+	prefix string
+}
+
+// NewAttemptStore returns a handler.AttemptStore that records failed login attempts in the
+// supplied KV, under keys beginning with the given prefix, so that a handler.LoginThrottle shares
+// its counts among all instances of an application using the same KV. It increments counts
+// atomically only if the KV implements CompareAndSwapper; otherwise, concurrent failures may be
+// undercounted. It panics if the supplied KV is nil.
+func NewAttemptStore(kv KV, prefix string) handler.AttemptStore {
+	if kv == nil {
+		panic("no KV supplied")
+	}
+	return attemptStore{kv, prefix}
+}
+
+func parseAttempts(b []byte) (count int, latest time.Time) {
+	var nanos int64
+	if _, err := fmt.Sscanf(string(b), "%d %d", &count, &nanos); err != nil {
+		return 0, time.Time{}
+	}
+	return count, time.Unix(0, nanos)
+}
+
+func (s attemptStore) Failures(ctx context.Context, key string) (int, time.Time, error) {
+	b, err := s.kv.Get(ctx, s.prefix+key)
+	if err == ErrNotFound {
+		return 0, time.Time{}, nil
+	}
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	count, latest := parseAttempts(b)
+	return count, latest, nil
+}
+
+func (s attemptStore) RecordFailure(ctx context.Context, key string, now time.Time, ttl time.Duration) (int, error) {
+	key = s.prefix + key
+	cas, ok := s.kv.(CompareAndSwapper)
+	if !ok {
+		cas = setter{s.kv}
+	}
+	// Each failed swap means that a concurrent failure was recorded, so retrying makes progress.
+	for {
+		current, err := s.kv.Get(ctx, key)
+		if err == ErrNotFound {
+			current, err = nil, nil
+		}
+		if err != nil {
+			return 0, err
+		}
+		count, _ := parseAttempts(current)
+		count++
+		swapped, err := cas.CompareAndSwap(ctx, key, current, []byte(fmt.Sprintf("%d %d", count, now.UnixNano())), ttl)
+		if err != nil {
+			return 0, err
+		}
+		if swapped {
+			return count, nil
+		}
+	}
+}
+
+func (s attemptStore) Reset(ctx context.Context, key string) error {
+	return s.kv.Delete(ctx, s.prefix+key)
+}
@@ -0,0 +1,40 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/seh/handler/kvstore"
+)
+
+func TestAttemptStore(t *testing.T) {
+	ctx := context.Background()
+	now := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	kv := kvstore.NewMemory()
+	s := kvstore.NewAttemptStore(kv, "attempts:")
+	if count, _, err := s.Failures(ctx, "ip:192.0.2.1"); err != nil || count != 0 {
+		t.Errorf("failures: got (%d, %v), want (0, nil)", count, err)
+	}
+	for want := 1; want <= 3; want++ {
+		if count, err := s.RecordFailure(ctx, "ip:192.0.2.1", now, time.Minute); err != nil || count != want {
+			t.Errorf("recorded failures: got (%d, %v), want (%d, nil)", count, err, want)
+		}
+	}
+	count, latest, err := s.Failures(ctx, "ip:192.0.2.1")
+	if err != nil || count != 3 || !latest.Equal(now) {
+		t.Errorf("failures: got (%d, %v, %v), want (3, %v, nil)", count, latest, err, now)
+	}
+	if _, err := kv.Get(ctx, "attempts:ip:192.0.2.1"); err != nil {
+		t.Errorf("failed to find prefixed key: %v", err)
+	}
+	if err := s.Reset(ctx, "ip:192.0.2.1"); err != nil {
+		t.Fatalf("failed to reset: %v", err)
+	}
+	if count, _, err := s.Failures(ctx, "ip:192.0.2.1"); err != nil || count != 0 {
+		t.Errorf("failures after reset: got (%d, %v), want (0, nil)", count, err)
+	}
+}
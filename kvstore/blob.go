@@ -0,0 +1,120 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/gob"
+	"encoding/hex"
+	"time"
+)
+
+// BlobRef stands in for a large session value in the values that a Store keeps in its KV, naming
+// the key under which the Store's Blobs KV holds the value itself.
+type BlobRef struct {
+	Key string
+}
+
+func init() {
+	gob.Register(BlobRef{})
+}
+
+// defaultBlobThreshold is the encoded size in bytes above which a Store with Blobs configured moves
+// a value into Blobs, absent a positive BlobThreshold.
+const defaultBlobThreshold = 4096
+
+func (s *Store) blobThreshold() int {
+	if s.BlobThreshold > 0 {
+		return s.BlobThreshold
+	}
+	return defaultBlobThreshold
+}
+
+// blobKeyPrefix returns the prefix shared by the keys of all blobs belonging to the session with
+// the given ID.
+func blobKeyPrefix(id string) string {
+	return id + "."
+}
+
+// externalizeBlobs returns the values to store in the KV for the session with the given ID, after
+// moving each value whose encoded size exceeds the threshold into Blobs and replacing it with a
+// BlobRef. It deletes any of the session's blobs no longer referenced, if Blobs implements Lister.
+func (s *Store) externalizeBlobs(ctx context.Context, id string, values map[interface{}]interface{}, ttl time.Duration) (map[interface{}]interface{}, error) {
+	if s.Blobs == nil {
+		return values, nil
+	}
+	stored := make(map[interface{}]interface{}, len(values))
+	referenced := make(map[string]struct{})
+	for k, v := range values {
+		stored[k] = v
+		b, err := s.serializer().Serialize(map[interface{}]interface{}{k: v})
+		if err != nil {
+			return nil, err
+		}
+		if len(b) <= s.blobThreshold() {
+			continue
+		}
+		sum := sha256.Sum256(b)
+		key := blobKeyPrefix(id) + hex.EncodeToString(sum[:16])
+		if err := s.Blobs.Set(ctx, key, b, ttl); err != nil {
+			return nil, err
+		}
+		stored[k] = BlobRef{key}
+		referenced[key] = struct{}{}
+	}
+	return stored, s.deleteBlobs(ctx, id, referenced)
+}
+
+// resolveBlobs replaces each BlobRef among the values with the value held in Blobs, or removes the
+// BlobRef if Blobs no longer holds the value.
+func (s *Store) resolveBlobs(ctx context.Context, values map[interface{}]interface{}) error {
+	if s.Blobs == nil {
+		return nil
+	}
+	for k, v := range values {
+		ref, ok := v.(BlobRef)
+		if !ok {
+			continue
+		}
+		b, err := s.Blobs.Get(ctx, ref.Key)
+		switch err {
+		case nil:
+		case ErrNotFound:
+			delete(values, k)
+			continue
+		default:
+			return err
+		}
+		var blob map[interface{}]interface{}
+		if err := s.serializer().Deserialize(b, &blob); err != nil {
+			return err
+		}
+		values[k] = blob[k]
+	}
+	return nil
+}
+
+// deleteBlobs deletes the blobs belonging to the session with the given ID, other than those
+// whose keys are retained, if Blobs implements Lister. Otherwise, the blobs linger until they
+// expire.
+func (s *Store) deleteBlobs(ctx context.Context, id string, retained map[string]struct{}) error {
+	l, ok := s.Blobs.(Lister)
+	if !ok {
+		return nil
+	}
+	keys, err := l.Keys(ctx, blobKeyPrefix(id))
+	if err != nil {
+		return err
+	}
+	for _, k := range keys {
+		if _, ok := retained[k]; ok {
+			continue
+		}
+		if err := s.Blobs.Delete(ctx, k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/seh/handler/kvstore"
+)
+
+func TestBlobs(t *testing.T) {
+	ctx := context.Background()
+	kv, blobs := kvstore.NewMemory(), kvstore.NewMemory()
+	store := makeStore(kv)
+	store.Blobs = blobs
+	store.BlobThreshold = 64
+	large := strings.Repeat("x", 128)
+
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	session.Values["uid"] = "alice"
+	session.Values["draft"] = large
+	recorder := httptest.NewRecorder()
+	if err := session.Save(r, recorder); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if got := session.Values["draft"]; got != large {
+		t.Errorf("in-memory value was replaced: got %v", got)
+	}
+	keys, _ := blobs.Keys(ctx, "")
+	if len(keys) != 1 {
+		t.Fatalf("blobs: got %v, want one", keys)
+	}
+	b, _ := kv.Get(ctx, session.ID)
+	if strings.Contains(string(b), large) {
+		t.Error("KV holds the large value")
+	}
+
+	loaded, err := store.New(requestBearingCookiesFrom(recorder), "s")
+	if err != nil {
+		t.Fatalf("failed to load session: %v", err)
+	}
+	if got := loaded.Values["draft"]; got != large {
+		t.Errorf("resolved value: got %v, want the large value", got)
+	}
+	loaded.Values["draft"] = strings.Repeat("y", 128)
+	if err := loaded.Save(r, httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if replaced, _ := blobs.Keys(ctx, ""); len(replaced) != 1 || replaced[0] == keys[0] {
+		t.Errorf("blobs after replacement: got %v, want one other than %v", replaced, keys)
+	}
+
+	if err := store.Delete(ctx, session.ID); err != nil {
+		t.Fatalf("failed to delete session: %v", err)
+	}
+	if keys, _ := blobs.Keys(ctx, ""); len(keys) != 0 {
+		t.Errorf("blobs after deletion: got %v, want none", keys)
+	}
+}
+
+func TestMissingBlobIsDropped(t *testing.T) {
+	ctx := context.Background()
+	blobs := kvstore.NewMemory()
+	store := makeStore(kvstore.NewMemory())
+	store.Blobs = blobs
+	store.BlobThreshold = 64
+
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	session.Values["uid"] = "alice"
+	session.Values["draft"] = strings.Repeat("x", 128)
+	if err := session.Save(r, httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	keys, _ := blobs.Keys(ctx, "")
+	for _, k := range keys {
+		blobs.Delete(ctx, k)
+	}
+	values, err := store.Values(ctx, session.ID)
+	if err != nil {
+		t.Fatalf("failed to read values: %v", err)
+	}
+	if _, ok := values["draft"]; ok || values["uid"] != "alice" {
+		t.Errorf("values: got %v, want only uid", values)
+	}
+}
@@ -0,0 +1,282 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore
+
+import (
+	"context"
+	"sort"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+// pointsPerNode is the number of points that each node occupies on a ConsistentHash ring, evening
+// out the share of keys that each node receives.
+const pointsPerNode = 160
+
+// ringHash hashes the string for placement on a ConsistentHash ring. It mixes the bits of the
+// FNV-1a hash, which on its own places short, similar strings close together.
+func ringHash(s string) uint64 {
+	h := fnv1a(s)
+	h ^= h >> 33
+	h *= 0xff51afd7ed558ccd
+	h ^= h >> 33
+	h *= 0xc4ceb9fe1a85ec53
+	h ^= h >> 33
+	return h
+}
+
+type ringPoint struct {
+	hash uint64
+	name string
+}
+
+// ConsistentHash is a KV that spreads its values across several named nodes, such as the members
+// of a set of Redis servers each adapted as a KV, by consistent hashing of their keys. Unlike with
+// Sharded, adding or removing a node reroutes only the keys that the node gains or held.
+//
+// ConsistentHash can eject nodes that fail their health checks, rerouting their keys to the
+// remaining nodes until they recover; see CheckNodes. Values held on an ejected node are
+// unavailable meanwhile, so the sessions they belong to appear to have ended. If all nodes are
+// ejected, it routes keys as though none were.
+//
+// Create a ConsistentHash with NewConsistentHash.
+type ConsistentHash struct {
+	// OnStateChange, if not nil, is called whenever CheckNodes ejects a node, with the error that
+	// its health check returned, or restores a node, with a nil error.
+	OnStateChange func(name string, err error)
+	nodes         map[string]KV
+	mu            sync.RWMutex
+	ejected       map[string]error
+	live          []ringPoint
+	all           []ringPoint
+}
+
+// NewConsistentHash returns a ConsistentHash KV routing keys to the given nodes, keyed by name.
+// Names should remain stable across restarts and deployments, as they determine the routing. It
+// panics if no nodes are supplied, or if any of them is nil.
+func NewConsistentHash(nodes map[string]KV) *ConsistentHash {
+	if len(nodes) == 0 {
+		panic("no nodes supplied")
+	}
+	c := &ConsistentHash{
+		nodes:   make(map[string]KV, len(nodes)),
+		ejected: make(map[string]error),
+	}
+	for name, kv := range nodes {
+		if kv == nil {
+			panic("nil node supplied")
+		}
+		c.nodes[name] = kv
+	}
+	c.all = c.buildRing()
+	c.live = c.all
+	return c
+}
+
+// buildRing returns the points for the nodes not ejected.
+func (c *ConsistentHash) buildRing() []ringPoint {
+	points := make([]ringPoint, 0, len(c.nodes)*pointsPerNode)
+	for name := range c.nodes {
+		if _, ok := c.ejected[name]; ok {
+			continue
+		}
+		for i := 0; i < pointsPerNode; i++ {
+			points = append(points, ringPoint{ringHash(name + "#" + strconv.Itoa(i)), name})
+		}
+	}
+	sort.Slice(points, func(i, j int) bool {
+		if points[i].hash != points[j].hash {
+			return points[i].hash < points[j].hash
+		}
+		return points[i].name < points[j].name
+	})
+	return points
+}
+
+// node returns the name of the node to which the key routes.
+func (c *ConsistentHash) node(key string) string {
+	c.mu.RLock()
+	points := c.live
+	c.mu.RUnlock()
+	if len(points) == 0 {
+		points = c.all
+	}
+	h := ringHash(key)
+	i := sort.Search(len(points), func(i int) bool { return points[i].hash >= h })
+	if i == len(points) {
+		i = 0
+	}
+	return points[i].name
+}
+
+// Get retrieves the value stored for the given key from the node to which the key routes.
+func (c *ConsistentHash) Get(ctx context.Context, key string) ([]byte, error) {
+	return c.nodes[c.node(key)].Get(ctx, key)
+}
+
+// Set stores the value for the given key in the node to which the key routes.
+func (c *ConsistentHash) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return c.nodes[c.node(key)].Set(ctx, key, value, ttl)
+}
+
+// Delete removes any value stored for the given key from the node to which the key routes.
+func (c *ConsistentHash) Delete(ctx context.Context, key string) error {
+	return c.nodes[c.node(key)].Delete(ctx, key)
+}
+
+// CompareAndSwap stores the new value for the given key in the node to which the key routes, only
+// if the value stored there equals old, implementing CompareAndSwapper. If that node does not
+// implement CompareAndSwapper, it stores the new value unconditionally.
+func (c *ConsistentHash) CompareAndSwap(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+	return compareAndSwap(ctx, c.nodes[c.node(key)], key, old, new, ttl)
+}
+
+// TTL returns the time remaining before the value stored for the given key in the node to which
+// the key routes expires, implementing TTLReporter. It returns ErrTTLUnsupported if that node does
+// not implement TTLReporter.
+func (c *ConsistentHash) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return reportTTL(ctx, c.nodes[c.node(key)], key)
+}
+
+// Keys returns the keys of all unexpired values whose keys begin with the given prefix across the
+// nodes not ejected, in lexicographic order. It returns ErrListingUnsupported if any such node does
+// not implement Lister.
+func (c *ConsistentHash) Keys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for _, name := range c.liveNodes() {
+		l, ok := c.nodes[name].(Lister)
+		if !ok {
+			return nil, ErrListingUnsupported
+		}
+		k, err := l.Keys(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k...)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// liveNodes returns the names of the nodes not ejected, in lexicographic order.
+func (c *ConsistentHash) liveNodes() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	names := make([]string, 0, len(c.nodes))
+	for name := range c.nodes {
+		if _, ok := c.ejected[name]; !ok {
+			names = append(names, name)
+		}
+	}
+	sort.Strings(names)
+	return names
+}
+
+// Ejected returns the names of the nodes currently ejected, in lexicographic order.
+func (c *ConsistentHash) Ejected() []string {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.sortedEjected()
+}
+
+func (c *ConsistentHash) sortedEjected() []string {
+	names := make([]string, 0, len(c.ejected))
+	for name := range c.ejected {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+func checkNode(ctx context.Context, kv KV) error {
+	if h, ok := kv.(handler.Healther); ok {
+		return h.Health(ctx)
+	}
+	if _, err := kv.Get(ctx, healthProbeKey); err != nil && err != ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// CheckNodes checks the health of each node, deferring to nodes that implement handler.Healther and
+// attempting to read a value that's not expected to be present from the others. It ejects nodes
+// that fail and restores ejected nodes that pass, rerouting keys accordingly.
+func (c *ConsistentHash) CheckNodes(ctx context.Context) {
+	results := make(map[string]error, len(c.nodes))
+	for name, kv := range c.nodes {
+		results[name] = checkNode(ctx, kv)
+	}
+	type change struct {
+		name string
+		err  error
+	}
+	var changes []change
+	c.mu.Lock()
+	for name, err := range results {
+		_, wasEjected := c.ejected[name]
+		switch {
+		case err != nil && !wasEjected:
+			c.ejected[name] = err
+			changes = append(changes, change{name, err})
+		case err == nil && wasEjected:
+			delete(c.ejected, name)
+			changes = append(changes, change{name, nil})
+		}
+	}
+	if len(changes) != 0 {
+		c.live = c.buildRing()
+	}
+	c.mu.Unlock()
+	if c.OnStateChange != nil {
+		sort.Slice(changes, func(i, j int) bool { return changes[i].name < changes[j].name })
+		for _, ch := range changes {
+			c.OnStateChange(ch.name, ch.err)
+		}
+	}
+}
+
+// CheckNodesEvery calls CheckNodes at the given interval until the supplied context is done.
+func (c *ConsistentHash) CheckNodesEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			c.CheckNodes(ctx)
+		}
+	}
+}
+
+// Health checks the nodes per CheckNodes, and reports an error if all of them are ejected,
+// returning the error that the first of them by name failed with.
+func (c *ConsistentHash) Health(ctx context.Context) error {
+	c.CheckNodes(ctx)
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	if len(c.ejected) < len(c.nodes) {
+		return nil
+	}
+	return c.ejected[c.sortedEjected()[0]]
+}
+
+// Warmup establishes connections to all the nodes, including those ejected, returning the error
+// that the first of them by name failed with.
+func (c *ConsistentHash) Warmup(ctx context.Context) error {
+	names := make([]string, 0, len(c.nodes))
+	for name := range c.nodes {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		if err := warmNode(ctx, c.nodes[name]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
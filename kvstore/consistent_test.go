@@ -0,0 +1,150 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestNewConsistentHashPanics(t *testing.T) {
+	tests := []struct {
+		description string
+		nodes       map[string]kvstore.KV
+	}{
+		{"none", nil},
+		{"nil node", map[string]kvstore.KV{"a": kvstore.NewMemory(), "b": nil}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			defer ensurePanicWithValueOccured(t)
+			kvstore.NewConsistentHash(test.nodes)
+		})
+	}
+}
+
+// switchableKV is a KV whose health can be switched off.
+type switchableKV struct {
+	*kvstore.Memory
+	err error
+}
+
+func (s *switchableKV) Health(context.Context) error {
+	return s.err
+}
+
+func TestConsistentHashRoutesStably(t *testing.T) {
+	ctx := context.Background()
+	nodes := map[string]kvstore.KV{"a": kvstore.NewMemory(), "b": kvstore.NewMemory(), "c": kvstore.NewMemory()}
+	c := kvstore.NewConsistentHash(nodes)
+	for i := 0; i < 300; i++ {
+		c.Set(ctx, fmt.Sprint(i), []byte{1}, 0)
+	}
+	for name, kv := range nodes {
+		if keys, _ := kv.(kvstore.Lister).Keys(ctx, ""); len(keys) < 50 {
+			t.Errorf("node %s: got %d keys, want a fair share of 300", name, len(keys))
+		}
+	}
+	if keys, err := c.Keys(ctx, ""); err != nil || len(keys) != 300 {
+		t.Errorf("keys: got %d (%v), want 300", len(keys), err)
+	}
+
+	// With a node added, keys move only to that node.
+	nodes["d"] = kvstore.NewMemory()
+	grown := kvstore.NewConsistentHash(nodes)
+	moved := 0
+	for i := 0; i < 300; i++ {
+		if _, err := grown.Get(ctx, fmt.Sprint(i)); err != nil {
+			moved++
+		}
+	}
+	if moved == 0 || moved > 150 {
+		t.Errorf("moved keys: got %d, want some but fewer than half", moved)
+	}
+}
+
+func TestConsistentHashEjection(t *testing.T) {
+	ctx := context.Background()
+	expectedError := errors.New("")
+	a := &switchableKV{Memory: kvstore.NewMemory()}
+	b := &switchableKV{Memory: kvstore.NewMemory()}
+	c := kvstore.NewConsistentHash(map[string]kvstore.KV{"a": a, "b": b})
+	var changes []string
+	c.OnStateChange = func(name string, err error) {
+		changes = append(changes, fmt.Sprintf("%s:%t", name, err == nil))
+	}
+
+	a.err = expectedError
+	c.CheckNodes(ctx)
+	if got := c.Ejected(); len(got) != 1 || got[0] != "a" {
+		t.Errorf("ejected: got %v, want [a]", got)
+	}
+	for i := 0; i < 20; i++ {
+		c.Set(ctx, fmt.Sprint(i), []byte{1}, 0)
+	}
+	if keys, _ := a.Keys(ctx, ""); len(keys) != 0 {
+		t.Errorf("ejected node received keys: %v", keys)
+	}
+	if err := c.Health(ctx); err != nil {
+		t.Errorf("health with a live node: got %v, want nil", err)
+	}
+	b.err = expectedError
+	if err := c.Health(ctx); err != expectedError {
+		t.Errorf("health with no live nodes: got %v, want %v", err, expectedError)
+	}
+	a.err, b.err = nil, nil
+	c.CheckNodes(ctx)
+	if got := c.Ejected(); len(got) != 0 {
+		t.Errorf("ejected after recovery: got %v, want none", got)
+	}
+	want := []string{"a:false", "b:false", "a:true", "b:true"}
+	if fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Errorf("state changes: got %v, want %v", changes, want)
+	}
+}
+
+func TestConsistentHashForwardsSwapsAndTTLs(t *testing.T) {
+	ctx := context.Background()
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	nodes := make(map[string]kvstore.KV)
+	for _, name := range []string{"a", "b", "c"} {
+		m := kvstore.NewMemory()
+		m.Clock = clock
+		nodes[name] = m
+	}
+	c := kvstore.NewConsistentHash(nodes)
+	for i := 0; i < 10; i++ {
+		k := fmt.Sprintf("session-%d", i)
+		c.Set(ctx, k, []byte("v"), time.Minute)
+		if swapped, err := c.CompareAndSwap(ctx, k, []byte("other"), []byte("w"), time.Minute); err != nil || swapped {
+			t.Errorf("swap of stale value for %q: got (%t, %v), want (false, nil)", k, swapped, err)
+		}
+		if swapped, err := c.CompareAndSwap(ctx, k, []byte("v"), []byte("w"), 2*time.Minute); err != nil || !swapped {
+			t.Errorf("swap of current value for %q: got (%t, %v), want (true, nil)", k, swapped, err)
+		}
+		if v, err := c.Get(ctx, k); err != nil || string(v) != "w" {
+			t.Errorf("swapped value for %q: got (%q, %v), want (%q, nil)", k, v, err, "w")
+		}
+		if ttl, err := c.TTL(ctx, k); err != nil || ttl != 2*time.Minute {
+			t.Errorf("TTL for %q: got (%v, %v), want (%v, nil)", k, ttl, err, 2*time.Minute)
+		}
+	}
+	single := kvstore.NewConsistentHash(map[string]kvstore.KV{"a": failingKV{errors.New("")}})
+	if _, err := single.TTL(ctx, "k"); err != kvstore.ErrTTLUnsupported {
+		t.Errorf("TTL error: got %v, want %v", err, kvstore.ErrTTLUnsupported)
+	}
+}
+
+var (
+	_ handler.Healther          = (*kvstore.ConsistentHash)(nil)
+	_ kvstore.CompareAndSwapper = (*kvstore.ConsistentHash)(nil)
+	_ kvstore.TTLReporter       = (*kvstore.ConsistentHash)(nil)
+)
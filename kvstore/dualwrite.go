@@ -0,0 +1,102 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+// DualWrite is a KV for moving sessions from one KV to another without downtime. It writes to both
+// the old and the new KV, but treats the old KV as authoritative, reading from it and failing only
+// when writing to it fails. It reads from the new KV only when the old KV holds no value or fails,
+// so that values written only to the new KV, such as after the old one lost them, remain
+// available.
+//
+// Run the application with a DualWrite for at least the lifetime of its sessions, or copy the
+// sessions saved before it started, then switch to the new KV once Diverged stops rising.
+//
+// Create a DualWrite with NewDualWrite.
+type DualWrite struct {
+	// Diverged, if not nil, counts reads for which the new KV held a value that differed from the
+	// old KV's, or held no value when the old KV held one. Setting it makes each read consult both
+	// KVs.
+	Diverged handler.Counter
+	// FellBack, if not nil, counts reads answered by the new KV because the old KV held no value or
+	// failed.
+	FellBack handler.Counter
+	// NewWriteFailures, if not nil, counts writes to the new KV that failed, which don't fail the
+	// write to the DualWrite.
+	NewWriteFailures handler.Counter
+	old, new         KV
+}
+
+// NewDualWrite returns a DualWrite KV that migrates values from the old KV to the new one. It
+// panics if either KV is nil.
+func NewDualWrite(old, new KV) *DualWrite {
+	if old == nil {
+		panic("no old KV supplied")
+	}
+	if new == nil {
+		panic("no new KV supplied")
+	}
+	return &DualWrite{old: old, new: new}
+}
+
+// increment adds one to the counter, if any.
+func increment(c handler.Counter) {
+	if c != nil {
+		c.Add(1)
+	}
+}
+
+// Get retrieves the value stored for the given key from the old KV or, if the old KV holds no
+// such value or fails, from the new KV. If the new KV fails too, it returns the old KV's error.
+func (d *DualWrite) Get(ctx context.Context, key string) ([]byte, error) {
+	v, err := d.old.Get(ctx, key)
+	if err == nil && d.Diverged == nil {
+		return v, nil
+	}
+	nv, nerr := d.new.Get(ctx, key)
+	if err == nil {
+		if nerr != nil || !bytes.Equal(v, nv) {
+			increment(d.Diverged)
+		}
+		return v, nil
+	}
+	if nerr == nil {
+		increment(d.FellBack)
+		return nv, nil
+	}
+	return nil, err
+}
+
+// Set stores the value for the given key in both KVs, failing only if storing it in the old KV
+// fails.
+func (d *DualWrite) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := d.old.Set(ctx, key, value, ttl); err != nil {
+		return err
+	}
+	if err := d.new.Set(ctx, key, value, ttl); err != nil {
+		increment(d.NewWriteFailures)
+	}
+	return nil
+}
+
+// Delete removes any value stored for the given key from both KVs. Unlike Set, it fails if
+// removing the value from the new KV fails, since reads would otherwise revive the value from the
+// new KV.
+func (d *DualWrite) Delete(ctx context.Context, key string) error {
+	if err := d.old.Delete(ctx, key); err != nil {
+		return err
+	}
+	if err := d.new.Delete(ctx, key); err != nil {
+		increment(d.NewWriteFailures)
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/seh/handler"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestNewDualWritePanics(t *testing.T) {
+	tests := []struct {
+		description string
+		old, new    kvstore.KV
+	}{
+		{"no old KV", nil, kvstore.NewMemory()},
+		{"no new KV", kvstore.NewMemory(), nil},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			defer ensurePanicWithValueOccured(t)
+			kvstore.NewDualWrite(test.old, test.new)
+		})
+	}
+}
+
+func TestDualWrite(t *testing.T) {
+	ctx := context.Background()
+	old, new := &flakyKV{Memory: kvstore.NewMemory()}, &flakyKV{Memory: kvstore.NewMemory()}
+	var diverged, fellBack, failures int64
+	d := kvstore.NewDualWrite(old, new)
+	d.Diverged = handler.CounterFunc(func(delta int64) { diverged += delta })
+	d.FellBack = handler.CounterFunc(func(delta int64) { fellBack += delta })
+	d.NewWriteFailures = handler.CounterFunc(func(delta int64) { failures += delta })
+
+	if err := d.Set(ctx, "a", []byte("1"), 0); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	if v, err := new.Get(ctx, "a"); err != nil || string(v) != "1" {
+		t.Errorf("new KV: got (%q, %v), want %q", v, err, "1")
+	}
+	old.Set(ctx, "b", []byte("2"), 0)
+	if v, err := d.Get(ctx, "b"); err != nil || string(v) != "2" {
+		t.Errorf("value only in old KV: got (%q, %v), want %q", v, err, "2")
+	}
+	if diverged != 1 {
+		t.Errorf("divergences: got %d, want 1", diverged)
+	}
+	new.Set(ctx, "c", []byte("3"), 0)
+	if v, err := d.Get(ctx, "c"); err != nil || string(v) != "3" || fellBack != 1 {
+		t.Errorf("value only in new KV: got (%q, %v) after %d fallbacks, want %q after 1", v, err, fellBack, "3")
+	}
+	if _, err := d.Get(ctx, "d"); err != kvstore.ErrNotFound {
+		t.Errorf("absent value: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+
+	new.err = errors.New("unavailable")
+	if err := d.Set(ctx, "a", []byte("4"), 0); err != nil || failures != 1 {
+		t.Errorf("set with new KV down: got %v after %d failures, want none after 1", err, failures)
+	}
+	if err := d.Delete(ctx, "a"); err == nil {
+		t.Error("deleted value with new KV down")
+	}
+	new.err = nil
+	old.err = errors.New("unavailable")
+	if err := d.Set(ctx, "a", []byte("5"), 0); err == nil {
+		t.Error("set value with old KV down")
+	}
+	if v, err := d.Get(ctx, "a"); err != nil || string(v) != "1" {
+		t.Errorf("value with old KV down: got (%q, %v), want %q", v, err, "1")
+	}
+}
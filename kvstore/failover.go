@@ -0,0 +1,272 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+// ErrWriteQueueFull is the error that a Failover KV returns when its primary is down and its queue
+// of pending writes is full. It matches handler.ErrSourceUnavailable, as does the error that
+// Failover returns for writes while its primary is down and it doesn't queue writes.
+var ErrWriteQueueFull = fmt.Errorf("kvstore: primary is down and write queue is full: %w", handler.ErrSourceUnavailable)
+
+// pendingWrite is a write that a Failover KV queued while its primary was down.
+type pendingWrite struct {
+	key     string
+	value   []byte
+	ttl     time.Duration
+	expires time.Time
+	delete  bool
+}
+
+// Failover is a KV that reads from and writes to a primary KV, failing over to replica KVs while
+// the primary is down. It deems the primary down once an operation on it fails with an error other
+// than ErrNotFound, and then leaves it alone for RetryInterval before trying it again, by applying
+// the queued writes or, lacking any, by checking its health.
+//
+// While the primary is down, it reads from each replica in turn until one answers. Writes fail,
+// unless MaxQueuedWrites is positive, in which case it queues them to apply to the primary once it
+// recovers. Reads consult the queued writes first, so that they reflect them.
+//
+// Create a Failover with NewFailover.
+type Failover struct {
+	// RetryInterval is how long to wait after the primary fails before trying it again. If not
+	// positive, Failover waits five seconds.
+	RetryInterval time.Duration
+	// MaxQueuedWrites is the number of writes to queue while the primary is down. If not positive,
+	// writes fail while the primary is down.
+	MaxQueuedWrites int
+	// OnStateChange, if not nil, is called whenever the primary goes down, with the error that it
+	// failed with, and whenever it recovers, with a nil error.
+	OnStateChange func(err error)
+	// Clock reports the current time, used to schedule retries of the primary. If nil, Failover uses
+	// handler.SystemClock.
+	Clock    handler.Clock
+	primary  KV
+	replicas []KV
+	mu       sync.Mutex
+	downAt   time.Time
+	down     bool
+	queue    []pendingWrite
+}
+
+// NewFailover returns a Failover KV using the given primary and replica KVs. It panics if the
+// primary or any replica is nil.
+func NewFailover(primary KV, replicas ...KV) *Failover {
+	if primary == nil {
+		panic("no primary KV supplied")
+	}
+	for _, kv := range replicas {
+		if kv == nil {
+			panic("nil replica KV supplied")
+		}
+	}
+	return &Failover{primary: primary, replicas: append([]KV(nil), replicas...)}
+}
+
+func (f *Failover) now() time.Time {
+	if f.Clock != nil {
+		return f.Clock.Now()
+	}
+	return handler.SystemClock.Now()
+}
+
+func (f *Failover) retryInterval() time.Duration {
+	if f.RetryInterval > 0 {
+		return f.RetryInterval
+	}
+	return 5 * time.Second
+}
+
+// usePrimary reports whether to try the primary, applying any queued writes first, or otherwise
+// checking its health, if it has been down for long enough.
+func (f *Failover) usePrimary(ctx context.Context) bool {
+	f.mu.Lock()
+	if !f.down {
+		f.mu.Unlock()
+		return true
+	}
+	if f.now().Sub(f.downAt) < f.retryInterval() {
+		f.mu.Unlock()
+		return false
+	}
+	// Claim this retry, so that other callers keep using the replicas meanwhile.
+	f.downAt = f.now()
+	queue := f.queue
+	f.queue = nil
+	f.mu.Unlock()
+	if len(queue) == 0 {
+		// With no queued writes to serve as a probe, check the primary before declaring it
+		// recovered.
+		if err := checkNode(ctx, f.primary); err != nil {
+			f.failed(err)
+			return false
+		}
+	}
+	for i, w := range queue {
+		if err := f.apply(ctx, w); err != nil {
+			f.mu.Lock()
+			f.queue = append(queue[i:], f.queue...)
+			f.mu.Unlock()
+			f.failed(err)
+			return false
+		}
+	}
+	f.mu.Lock()
+	recovered := f.down
+	f.down = false
+	f.mu.Unlock()
+	if recovered && f.OnStateChange != nil {
+		f.OnStateChange(nil)
+	}
+	return true
+}
+
+func (f *Failover) apply(ctx context.Context, w pendingWrite) error {
+	if w.delete {
+		return f.primary.Delete(ctx, w.key)
+	}
+	ttl := w.ttl
+	if ttl > 0 {
+		if ttl = w.expires.Sub(f.now()); ttl <= 0 {
+			return f.primary.Delete(ctx, w.key)
+		}
+	}
+	return f.primary.Set(ctx, w.key, w.value, ttl)
+}
+
+// failed records that the primary failed with the given error.
+func (f *Failover) failed(err error) {
+	f.mu.Lock()
+	wasDown := f.down
+	f.down = true
+	f.downAt = f.now()
+	f.mu.Unlock()
+	if !wasDown && f.OnStateChange != nil {
+		f.OnStateChange(err)
+	}
+}
+
+// queued returns the latest write queued for the given key, if any.
+func (f *Failover) queued(key string) (pendingWrite, bool) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	for i := len(f.queue) - 1; i >= 0; i-- {
+		if f.queue[i].key == key {
+			return f.queue[i], true
+		}
+	}
+	return pendingWrite{}, false
+}
+
+// enqueue queues the write, or returns ErrWriteQueueFull if there's no room for it.
+func (f *Failover) enqueue(w pendingWrite) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if len(f.queue) >= f.MaxQueuedWrites {
+		return ErrWriteQueueFull
+	}
+	f.queue = append(f.queue, w)
+	return nil
+}
+
+// Get retrieves the value stored for the given key from the primary or, while it's down, from
+// the queued writes or the first replica that answers.
+func (f *Failover) Get(ctx context.Context, key string) ([]byte, error) {
+	if f.usePrimary(ctx) {
+		v, err := f.primary.Get(ctx, key)
+		if err == nil || err == ErrNotFound {
+			return v, err
+		}
+		f.failed(err)
+		if len(f.replicas) == 0 {
+			return nil, err
+		}
+	}
+	if w, ok := f.queued(key); ok {
+		if w.delete || w.ttl > 0 && !f.now().Before(w.expires) {
+			return nil, ErrNotFound
+		}
+		return append([]byte(nil), w.value...), nil
+	}
+	var err error
+	for _, kv := range f.replicas {
+		var v []byte
+		if v, err = kv.Get(ctx, key); err == nil || err == ErrNotFound {
+			return v, err
+		}
+	}
+	if err == nil {
+		err = errPrimaryDown
+	}
+	return nil, err
+}
+
+// write applies the write to the primary or, while it's down, queues it if permitted.
+func (f *Failover) write(ctx context.Context, w pendingWrite) error {
+	if f.usePrimary(ctx) {
+		err := f.apply(ctx, w)
+		if err == nil {
+			return nil
+		}
+		f.failed(err)
+		if f.MaxQueuedWrites <= 0 {
+			return err
+		}
+	} else if f.MaxQueuedWrites <= 0 {
+		return errPrimaryDown
+	}
+	return f.enqueue(w)
+}
+
+var errPrimaryDown = fmt.Errorf("kvstore: primary is down: %w", handler.ErrSourceUnavailable)
+
+// Set stores the value for the given key in the primary or, while it's down, queues the write if
+// permitted.
+func (f *Failover) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	w := pendingWrite{key: key, value: append([]byte(nil), value...), ttl: ttl}
+	if ttl > 0 {
+		w.expires = f.now().Add(ttl)
+	}
+	return f.write(ctx, w)
+}
+
+// Delete removes any value stored for the given key from the primary or, while it's down, queues
+// the deletion if permitted.
+func (f *Failover) Delete(ctx context.Context, key string) error {
+	return f.write(ctx, pendingWrite{key: key, delete: true})
+}
+
+// Health reports an error if the primary is down.
+func (f *Failover) Health(ctx context.Context) error {
+	if !f.usePrimary(ctx) {
+		return errPrimaryDown
+	}
+	if err := checkNode(ctx, f.primary); err != nil {
+		f.failed(err)
+		return err
+	}
+	return nil
+}
+
+// Warmup establishes connections to the primary and to all the replicas, returning the first
+// failure encountered. A failure of the primary marks it as down.
+func (f *Failover) Warmup(ctx context.Context) error {
+	if err := warmNode(ctx, f.primary); err != nil {
+		f.failed(err)
+		return err
+	}
+	for _, kv := range f.replicas {
+		if err := warmNode(ctx, kv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
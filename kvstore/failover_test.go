@@ -0,0 +1,144 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestNewFailoverPanics(t *testing.T) {
+	tests := []struct {
+		description string
+		primary     kvstore.KV
+		replicas    []kvstore.KV
+	}{
+		{"no primary", nil, nil},
+		{"nil replica", kvstore.NewMemory(), []kvstore.KV{nil}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			defer ensurePanicWithValueOccured(t)
+			kvstore.NewFailover(test.primary, test.replicas...)
+		})
+	}
+}
+
+// flakyKV is a KV that fails every operation while its err field is set.
+type flakyKV struct {
+	*kvstore.Memory
+	err error
+}
+
+func (f *flakyKV) Get(ctx context.Context, key string) ([]byte, error) {
+	if f.err != nil {
+		return nil, f.err
+	}
+	return f.Memory.Get(ctx, key)
+}
+
+func (f *flakyKV) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.Memory.Set(ctx, key, value, ttl)
+}
+
+func (f *flakyKV) Delete(ctx context.Context, key string) error {
+	if f.err != nil {
+		return f.err
+	}
+	return f.Memory.Delete(ctx, key)
+}
+
+func TestFailover(t *testing.T) {
+	ctx := context.Background()
+	expectedError := errors.New("")
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	primary := &flakyKV{Memory: kvstore.NewMemory()}
+	replica := kvstore.NewMemory()
+	f := kvstore.NewFailover(primary, replica)
+	f.Clock = clock
+	f.RetryInterval = time.Minute
+	var changes []string
+	f.OnStateChange = func(err error) {
+		changes = append(changes, fmt.Sprint(err == nil))
+	}
+
+	f.Set(ctx, "k", []byte("primary"), 0)
+	replica.Set(ctx, "k", []byte("replica"), 0)
+	primary.err = expectedError
+	if v, err := f.Get(ctx, "k"); err != nil || string(v) != "replica" {
+		t.Errorf("read during failover: got (%q, %v), want (replica, nil)", v, err)
+	}
+	if err := f.Set(ctx, "k", []byte("new"), 0); err == nil {
+		t.Error("write without a queue succeeded while the primary was down")
+	}
+	if err := f.Health(ctx); err == nil {
+		t.Error("health while the primary was down: got nil, want an error")
+	}
+
+	// Retrying the primary while it's still down doesn't report it as having recovered.
+	clock.Advance(time.Minute)
+	if v, _ := f.Get(ctx, "k"); string(v) != "replica" {
+		t.Errorf("read after failed retry: got %q, want replica", v)
+	}
+
+	primary.err = nil
+	if v, _ := f.Get(ctx, "k"); string(v) != "replica" {
+		t.Errorf("read before retry: got %q, want replica", v)
+	}
+	clock.Advance(time.Minute)
+	if v, _ := f.Get(ctx, "k"); string(v) != "primary" {
+		t.Errorf("read after recovery: got %q, want primary", v)
+	}
+	if want := []string{"false", "true"}; fmt.Sprint(changes) != fmt.Sprint(want) {
+		t.Errorf("state changes: got %v, want %v", changes, want)
+	}
+}
+
+func TestFailoverQueuesWrites(t *testing.T) {
+	ctx := context.Background()
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	primary := &flakyKV{Memory: kvstore.NewMemory(), err: errors.New("")}
+	f := kvstore.NewFailover(primary, kvstore.NewMemory())
+	f.Clock = clock
+	f.RetryInterval = time.Minute
+	f.MaxQueuedWrites = 2
+
+	if err := f.Set(ctx, "a", []byte("1"), 0); err != nil {
+		t.Fatalf("failed to queue write: %v", err)
+	}
+	if err := f.Delete(ctx, "b"); err != nil {
+		t.Fatalf("failed to queue deletion: %v", err)
+	}
+	if err := f.Set(ctx, "c", []byte("3"), 0); err != kvstore.ErrWriteQueueFull {
+		t.Errorf("error with a full queue: got %v, want %v", err, kvstore.ErrWriteQueueFull)
+	}
+	if !errors.Is(kvstore.ErrWriteQueueFull, handler.ErrSourceUnavailable) {
+		t.Errorf("%v does not match %v", kvstore.ErrWriteQueueFull, handler.ErrSourceUnavailable)
+	}
+	if v, err := f.Get(ctx, "a"); err != nil || string(v) != "1" {
+		t.Errorf("read of queued write: got (%q, %v), want (1, nil)", v, err)
+	}
+
+	primary.err = nil
+	primary.Set(ctx, "b", []byte("2"), 0)
+	clock.Advance(time.Minute)
+	if v, err := f.Get(ctx, "a"); err != nil || string(v) != "1" {
+		t.Errorf("read after recovery: got (%q, %v), want (1, nil)", v, err)
+	}
+	if _, err := primary.Memory.Get(ctx, "b"); err != kvstore.ErrNotFound {
+		t.Errorf("queued deletion was not applied: got %v", err)
+	}
+}
+
+var _ handler.Healther = (*kvstore.Failover)(nil)
@@ -0,0 +1,252 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+// File is a KV that keeps each value in its own file within a directory, suitable for
+// single-host deployments that need sessions to survive restarts. Each file begins with the value's
+// expiration time, so that expired values can be found without reading the values themselves.
+//
+// Create a File with NewFile.
+type File struct {
+	// Clock reports the current time, used to determine when values expire. If nil, File uses
+	// handler.SystemClock.
+	Clock handler.Clock
+	dir   string
+	// mu serializes writes and deletions, so that a purge can't remove a value just rewritten.
+	mu sync.Mutex
+}
+
+// NewFile returns a File KV that keeps its values in the given directory, creating the directory
+// if it doesn't exist yet.
+func NewFile(dir string) (*File, error) {
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, err
+	}
+	return &File{dir: dir}, nil
+}
+
+const fileHeaderLen = 8
+
+func (f *File) now() time.Time {
+	if f.Clock != nil {
+		return f.Clock.Now()
+	}
+	return handler.SystemClock.Now()
+}
+
+func (f *File) path(key string) string {
+	return filepath.Join(f.dir, hex.EncodeToString([]byte(key)))
+}
+
+// expiredAt reports whether a value bearing the given header has expired as of time t.
+func expiredAt(header []byte, t time.Time) bool {
+	expires := int64(binary.BigEndian.Uint64(header))
+	return expires != 0 && t.UnixNano() >= expires
+}
+
+// Get retrieves the value stored for the given key, or returns ErrNotFound if no such value is
+// present or the value has expired.
+func (f *File) Get(_ context.Context, key string) ([]byte, error) {
+	b, err := ioutil.ReadFile(f.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, ErrNotFound
+		}
+		return nil, err
+	}
+	if len(b) < fileHeaderLen || expiredAt(b, f.now()) {
+		return nil, ErrNotFound
+	}
+	return b[fileHeaderLen:], nil
+}
+
+// Set stores the value for the given key, expiring after ttl if it's positive. It writes the value
+// to a temporary file and then renames it into place, so that readers never see a partial value.
+func (f *File) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	var header [fileHeaderLen]byte
+	if ttl > 0 {
+		binary.BigEndian.PutUint64(header[:], uint64(f.now().Add(ttl).UnixNano()))
+	}
+	tmp, err := ioutil.TempFile(f.dir, ".tmp-")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(header[:]); err != nil {
+		tmp.Close()
+		return err
+	}
+	if _, err := tmp.Write(value); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return os.Rename(tmp.Name(), f.path(key))
+}
+
+// TTL returns the time remaining before the value stored for the given key expires, or zero if it
+// never expires, or returns ErrNotFound if no such value is present or it has expired. It reads
+// only the value's header.
+func (f *File) TTL(_ context.Context, key string) (time.Duration, error) {
+	file, err := os.Open(f.path(key))
+	if err != nil {
+		if os.IsNotExist(err) {
+			return 0, ErrNotFound
+		}
+		return 0, err
+	}
+	defer file.Close()
+	header := make([]byte, fileHeaderLen)
+	if _, err := io.ReadFull(file, header); err != nil {
+		return 0, ErrNotFound
+	}
+	now := f.now()
+	if expiredAt(header, now) {
+		return 0, ErrNotFound
+	}
+	expires := int64(binary.BigEndian.Uint64(header))
+	if expires == 0 {
+		return 0, nil
+	}
+	return time.Unix(0, expires).Sub(now), nil
+}
+
+// Delete removes any value stored for the given key.
+func (f *File) Delete(_ context.Context, key string) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if err := os.Remove(f.path(key)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
+
+// entries calls fn with the key and header of each value in the directory, stopping at the first
+// error that fn returns.
+func (f *File) entries(fn func(key, path string, header []byte) error) error {
+	names, err := ioutil.ReadDir(f.dir)
+	if err != nil {
+		return err
+	}
+	header := make([]byte, fileHeaderLen)
+	for _, info := range names {
+		if info.IsDir() || strings.HasPrefix(info.Name(), ".") {
+			continue
+		}
+		key, err := hex.DecodeString(info.Name())
+		if err != nil {
+			continue
+		}
+		path := filepath.Join(f.dir, info.Name())
+		file, err := os.Open(path)
+		if err != nil {
+			if os.IsNotExist(err) {
+				continue
+			}
+			return err
+		}
+		_, err = io.ReadFull(file, header)
+		file.Close()
+		if err != nil {
+			continue
+		}
+		if err := fn(string(key), path, header); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Keys returns the keys of all unexpired values whose keys begin with the given prefix, in
+// lexicographic order.
+func (f *File) Keys(_ context.Context, prefix string) ([]string, error) {
+	now := f.now()
+	var keys []string
+	err := f.entries(func(key, _ string, header []byte) error {
+		if strings.HasPrefix(key, prefix) && !expiredAt(header, now) {
+			keys = append(keys, key)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// PurgeExpired deletes all values that expired before the given time, returning the number of
+// values deleted.
+func (f *File) PurgeExpired(ctx context.Context, before time.Time) (int, error) {
+	return f.PurgeExpiredLimit(ctx, before, 0)
+}
+
+// errEnoughEntries stops a traversal of a File's entries early.
+var errEnoughEntries = errors.New("kvstore: enough entries visited")
+
+// PurgeExpiredLimit is like PurgeExpired, but deletes at most limit values, allowing a large
+// backlog of expired values to be purged in batches without holding up writes for long. If limit is
+// not positive, it deletes all the expired values.
+func (f *File) PurgeExpiredLimit(ctx context.Context, before time.Time, limit int) (int, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	n := 0
+	err := f.entries(func(_, path string, header []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if !expiredAt(header, before) {
+			return nil
+		}
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		n++
+		if n == limit {
+			return errEnoughEntries
+		}
+		return nil
+	})
+	if err == errEnoughEntries {
+		err = nil
+	}
+	return n, err
+}
+
+// CountExpired returns the number of values that expired before the given time, without deleting
+// them.
+func (f *File) CountExpired(ctx context.Context, before time.Time) (int, error) {
+	n := 0
+	err := f.entries(func(_, _ string, header []byte) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if expiredAt(header, before) {
+			n++
+		}
+		return nil
+	})
+	return n, err
+}
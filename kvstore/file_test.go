@@ -0,0 +1,172 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore_test
+
+import (
+	"bytes"
+	"context"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+func makeFile(t *testing.T) (*kvstore.File, *handlertest.FakeClock) {
+	f, err := kvstore.NewFile(filepath.Join(t.TempDir(), "sessions"))
+	if err != nil {
+		t.Fatalf("failed to create File KV: %v", err)
+	}
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	f.Clock = clock
+	return f, clock
+}
+
+func TestFile(t *testing.T) {
+	ctx := context.Background()
+	f, _ := makeFile(t)
+	if _, err := f.Get(ctx, "k"); err != kvstore.ErrNotFound {
+		t.Errorf("error for absent key: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+	if err := f.Set(ctx, "session:k/1", []byte("v"), 0); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	got, err := f.Get(ctx, "session:k/1")
+	if err != nil {
+		t.Fatalf("failed to get value: %v", err)
+	}
+	if want := []byte("v"); !bytes.Equal(got, want) {
+		t.Errorf("value: got %q, want %q", got, want)
+	}
+	if err := f.Delete(ctx, "session:k/1"); err != nil {
+		t.Fatalf("failed to delete value: %v", err)
+	}
+	if _, err := f.Get(ctx, "session:k/1"); err != kvstore.ErrNotFound {
+		t.Errorf("error for deleted key: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+	if err := f.Delete(ctx, "session:k/1"); err != nil {
+		t.Errorf("error deleting absent key: got %v, want none", err)
+	}
+}
+
+func TestFileExpiryAndKeys(t *testing.T) {
+	ctx := context.Background()
+	f, clock := makeFile(t)
+	f.Set(ctx, "a:2", nil, 0)
+	f.Set(ctx, "a:1", nil, 0)
+	f.Set(ctx, "a:3", nil, time.Second)
+	f.Set(ctx, "b:1", nil, 0)
+	clock.Advance(time.Second - time.Nanosecond)
+	if _, err := f.Get(ctx, "a:3"); err != nil {
+		t.Errorf("failed to get unexpired value: %v", err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := f.Get(ctx, "a:3"); err != kvstore.ErrNotFound {
+		t.Errorf("error for expired key: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+	keys, err := f.Keys(ctx, "a:")
+	if err != nil {
+		t.Fatalf("failed to list keys: %v", err)
+	}
+	if got, want := strings.Join(keys, ","), "a:1,a:2"; got != want {
+		t.Errorf("keys: got %q, want %q", got, want)
+	}
+}
+
+func TestFilePurgeExpired(t *testing.T) {
+	ctx := context.Background()
+	f, clock := makeFile(t)
+	f.Set(ctx, "a", nil, time.Second)
+	f.Set(ctx, "b", nil, time.Minute)
+	f.Set(ctx, "c", nil, 0)
+	n, err := f.PurgeExpired(ctx, clock.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("failed to purge: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("purged count: got %d, want 1", n)
+	}
+	clock.Advance(time.Hour)
+	if keys, _ := f.Keys(ctx, ""); len(keys) != 1 || keys[0] != "c" {
+		t.Errorf("keys: got %q, want [c]", keys)
+	}
+}
+
+func TestFilePurgeExpiredLimit(t *testing.T) {
+	ctx := context.Background()
+	f, clock := makeFile(t)
+	for _, k := range []string{"a", "b", "c"} {
+		f.Set(ctx, k, nil, time.Second)
+	}
+	f.Set(ctx, "d", nil, 0)
+	before := clock.Now().Add(time.Second)
+	if n, err := f.CountExpired(ctx, before); err != nil || n != 3 {
+		t.Errorf("expired count: got %d, %v, want 3", n, err)
+	}
+	for _, want := range []int{2, 1, 0} {
+		n, err := f.PurgeExpiredLimit(ctx, before, 2)
+		if err != nil {
+			t.Fatalf("failed to purge: %v", err)
+		}
+		if n != want {
+			t.Errorf("purged count: got %d, want %d", n, want)
+		}
+	}
+	if n, err := f.CountExpired(ctx, before); err != nil || n != 0 {
+		t.Errorf("expired count after purging: got %d, %v, want 0", n, err)
+	}
+}
+
+func TestFileBacksStore(t *testing.T) {
+	f, _ := makeFile(t)
+	store := makeStore(f)
+	id := saveSessionWith(t, store, map[interface{}]interface{}{"k": "v"})
+	values, err := store.Values(context.Background(), id)
+	if err != nil {
+		t.Fatalf("failed to read session values: %v", err)
+	}
+	if got, want := values["k"], "v"; got != want {
+		t.Errorf("value: got %v, want %v", got, want)
+	}
+}
+
+func TestTTL(t *testing.T) {
+	ctx := context.Background()
+	f, fileClock := makeFile(t)
+	m := kvstore.NewMemory()
+	memoryClock := handlertest.NewFakeClock(fileClock.Now())
+	m.Clock = memoryClock
+	for _, test := range []struct {
+		description string
+		kv          interface {
+			kvstore.KV
+			kvstore.TTLReporter
+		}
+		clock *handlertest.FakeClock
+	}{
+		{"File", f, fileClock},
+		{"Memory", m, memoryClock},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			test.kv.Set(ctx, "expiring", []byte("v"), time.Minute)
+			test.kv.Set(ctx, "lasting", []byte("v"), 0)
+			test.clock.Advance(20 * time.Second)
+			if ttl, err := test.kv.TTL(ctx, "expiring"); err != nil || ttl != 40*time.Second {
+				t.Errorf("TTL of expiring value: got (%v, %v), want %v", ttl, err, 40*time.Second)
+			}
+			if ttl, err := test.kv.TTL(ctx, "lasting"); err != nil || ttl != 0 {
+				t.Errorf("TTL of lasting value: got (%v, %v), want 0", ttl, err)
+			}
+			test.clock.Advance(time.Minute)
+			if _, err := test.kv.TTL(ctx, "expiring"); err != kvstore.ErrNotFound {
+				t.Errorf("TTL of expired value: got %v, want %v", err, kvstore.ErrNotFound)
+			}
+			if _, err := test.kv.TTL(ctx, "absent"); err != kvstore.ErrNotFound {
+				t.Errorf("TTL of absent value: got %v, want %v", err, kvstore.ErrNotFound)
+			}
+		})
+	}
+}
@@ -0,0 +1,162 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore
+
+import (
+	"bytes"
+	"context"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+type memoryEntry struct {
+	value   []byte
+	expires time.Time
+}
+
+func (e memoryEntry) expiredAt(t time.Time) bool {
+	return !e.expires.IsZero() && !t.Before(e.expires)
+}
+
+// Memory is a KV that holds its values in process memory, suitable for tests and for single-process
+// deployments. Expired values are discarded lazily, as they're encountered. The zero value is ready
+// for use.
+type Memory struct {
+	// Clock reports the current time, used to determine when values expire. If nil, Memory uses
+	// handler.SystemClock.
+	Clock   handler.Clock
+	mu      sync.RWMutex
+	entries map[string]memoryEntry
+}
+
+func (m *Memory) now() time.Time {
+	if m.Clock != nil {
+		return m.Clock.Now()
+	}
+	return handler.SystemClock.Now()
+}
+
+// NewMemory returns an empty Memory KV.
+func NewMemory() *Memory {
+	return &Memory{}
+}
+
+// Get retrieves the value stored for the given key, or returns ErrNotFound if no such value is
+// present or the value has expired.
+func (m *Memory) Get(_ context.Context, key string) ([]byte, error) {
+	m.mu.RLock()
+	e, ok := m.entries[key]
+	m.mu.RUnlock()
+	if !ok {
+		return nil, ErrNotFound
+	}
+	if e.expiredAt(m.now()) {
+		m.mu.Lock()
+		if e, ok := m.entries[key]; ok && e.expiredAt(m.now()) {
+			delete(m.entries, key)
+		}
+		m.mu.Unlock()
+		return nil, ErrNotFound
+	}
+	return append([]byte(nil), e.value...), nil
+}
+
+// Set stores a copy of the value for the given key, expiring after ttl if it's positive.
+func (m *Memory) Set(_ context.Context, key string, value []byte, ttl time.Duration) error {
+	e := memoryEntry{value: append([]byte(nil), value...)}
+	if ttl > 0 {
+		e.expires = m.now().Add(ttl)
+	}
+	m.mu.Lock()
+	if m.entries == nil {
+		m.entries = make(map[string]memoryEntry)
+	}
+	m.entries[key] = e
+	m.mu.Unlock()
+	return nil
+}
+
+// CompareAndSwap stores a copy of the new value for the given key, expiring after ttl if it's
+// positive, only if the value stored for the key equals old, or, if old is nil, only if no
+// unexpired value is present. It reports whether it stored the new value.
+func (m *Memory) CompareAndSwap(_ context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+	now := m.now()
+	e := memoryEntry{value: append([]byte(nil), new...)}
+	if ttl > 0 {
+		e.expires = now.Add(ttl)
+	}
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	current, ok := m.entries[key]
+	if ok && current.expiredAt(now) {
+		ok = false
+	}
+	if ok != (old != nil) || ok && !bytes.Equal(current.value, old) {
+		return false, nil
+	}
+	if m.entries == nil {
+		m.entries = make(map[string]memoryEntry)
+	}
+	m.entries[key] = e
+	return true, nil
+}
+
+// TTL returns the time remaining before the value stored for the given key expires, or zero if it
+// never expires, or returns ErrNotFound if no such value is present or it has expired.
+func (m *Memory) TTL(_ context.Context, key string) (time.Duration, error) {
+	m.mu.RLock()
+	e, ok := m.entries[key]
+	m.mu.RUnlock()
+	now := m.now()
+	if !ok || e.expiredAt(now) {
+		return 0, ErrNotFound
+	}
+	if e.expires.IsZero() {
+		return 0, nil
+	}
+	return e.expires.Sub(now), nil
+}
+
+// Delete removes any value stored for the given key.
+func (m *Memory) Delete(_ context.Context, key string) error {
+	m.mu.Lock()
+	delete(m.entries, key)
+	m.mu.Unlock()
+	return nil
+}
+
+// Keys returns the keys of all unexpired values whose keys begin with the given prefix, in
+// lexicographic order.
+func (m *Memory) Keys(_ context.Context, prefix string) ([]string, error) {
+	now := m.now()
+	m.mu.RLock()
+	var keys []string
+	for k, e := range m.entries {
+		if strings.HasPrefix(k, prefix) && !e.expiredAt(now) {
+			keys = append(keys, k)
+		}
+	}
+	m.mu.RUnlock()
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// PurgeExpired deletes all values that expired before the given time, returning the number of
+// values deleted.
+func (m *Memory) PurgeExpired(_ context.Context, before time.Time) (int, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	n := 0
+	for k, e := range m.entries {
+		if e.expiredAt(before) {
+			delete(m.entries, k)
+			n++
+		}
+	}
+	return n, nil
+}
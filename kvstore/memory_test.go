@@ -0,0 +1,99 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore_test
+
+import (
+	"bytes"
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestMemory(t *testing.T) {
+	ctx := context.Background()
+	var m kvstore.Memory
+	if _, err := m.Get(ctx, "k"); err != kvstore.ErrNotFound {
+		t.Errorf("error for absent key: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+	value := []byte("v")
+	if err := m.Set(ctx, "k", value, 0); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	value[0] = 'x'
+	got, err := m.Get(ctx, "k")
+	if err != nil {
+		t.Fatalf("failed to get value: %v", err)
+	}
+	if want := []byte("v"); !bytes.Equal(got, want) {
+		t.Errorf("value: got %q, want %q", got, want)
+	}
+	if err := m.Delete(ctx, "k"); err != nil {
+		t.Fatalf("failed to delete value: %v", err)
+	}
+	if _, err := m.Get(ctx, "k"); err != kvstore.ErrNotFound {
+		t.Errorf("error for deleted key: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+	if err := m.Delete(ctx, "k"); err != nil {
+		t.Errorf("error deleting absent key: got %v, want none", err)
+	}
+}
+
+func TestMemoryExpiry(t *testing.T) {
+	ctx := context.Background()
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	m := kvstore.Memory{Clock: clock}
+	if err := m.Set(ctx, "k", []byte("v"), time.Minute); err != nil {
+		t.Fatalf("failed to set value: %v", err)
+	}
+	clock.Advance(time.Minute - time.Nanosecond)
+	if _, err := m.Get(ctx, "k"); err != nil {
+		t.Errorf("failed to get unexpired value: %v", err)
+	}
+	clock.Advance(time.Nanosecond)
+	if _, err := m.Get(ctx, "k"); err != kvstore.ErrNotFound {
+		t.Errorf("error for expired key: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+}
+
+func TestMemoryKeys(t *testing.T) {
+	ctx := context.Background()
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	m := kvstore.Memory{Clock: clock}
+	m.Set(ctx, "a:2", nil, 0)
+	m.Set(ctx, "a:1", nil, 0)
+	m.Set(ctx, "a:3", nil, time.Second)
+	m.Set(ctx, "b:1", nil, 0)
+	clock.Advance(time.Second)
+	keys, err := m.Keys(ctx, "a:")
+	if err != nil {
+		t.Fatalf("failed to list keys: %v", err)
+	}
+	if got, want := strings.Join(keys, ","), "a:1,a:2"; got != want {
+		t.Errorf("keys: got %q, want %q", got, want)
+	}
+}
+
+func TestMemoryPurgeExpired(t *testing.T) {
+	ctx := context.Background()
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	m := kvstore.Memory{Clock: clock}
+	m.Set(ctx, "a", nil, time.Second)
+	m.Set(ctx, "b", nil, time.Minute)
+	m.Set(ctx, "c", nil, 0)
+	n, err := m.PurgeExpired(ctx, clock.Now().Add(time.Second))
+	if err != nil {
+		t.Fatalf("failed to purge: %v", err)
+	}
+	if n != 1 {
+		t.Errorf("purged count: got %d, want 1", n)
+	}
+	clock.Advance(time.Hour)
+	if keys, _ := m.Keys(ctx, ""); len(keys) != 1 || keys[0] != "c" {
+		t.Errorf("keys: got %q, want [c]", keys)
+	}
+}
@@ -0,0 +1,144 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore
+
+import (
+	"context"
+	"sync"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+type recentWrite struct {
+	value   []byte
+	deleted bool
+	// until is when the write stops answering reads, at the end of the window or at the value's
+	// expiration, whichever comes first.
+	until time.Time
+}
+
+// ReadYourWrites is a KV that remembers each value written through it for a short window, answering
+// reads of that key from memory rather than from the underlying KV meanwhile. Over an eventually
+// consistent KV, such as one that reads from replicas that lag behind the node accepting writes, it
+// keeps a session saved by one request from appearing to be missing or stale on the very next
+// request, provided that both reach the same process.
+//
+// Create a ReadYourWrites with NewReadYourWrites.
+type ReadYourWrites struct {
+	// Clock reports the current time, used to end each write's window. If nil, ReadYourWrites uses
+	// handler.SystemClock.
+	Clock     handler.Clock
+	kv        KV
+	window    time.Duration
+	mu        sync.Mutex
+	recent    map[string]recentWrite
+	lastSweep time.Time
+}
+
+// NewReadYourWrites returns a ReadYourWrites KV that writes through to the given KV, answering
+// reads of each key from memory for the given window after writing it, which should exceed the
+// KV's typical replication lag. It panics if the KV is nil or the window is not positive.
+func NewReadYourWrites(kv KV, window time.Duration) *ReadYourWrites {
+	if kv == nil {
+		panic("no KV supplied")
+	}
+	if window <= 0 {
+		panic("window must be positive")
+	}
+	return &ReadYourWrites{kv: kv, window: window, recent: make(map[string]recentWrite)}
+}
+
+func (p *ReadYourWrites) now() time.Time {
+	if p.Clock != nil {
+		return p.Clock.Now()
+	}
+	return handler.SystemClock.Now()
+}
+
+// remember records the write, and discards writes whose windows have ended, at most once per
+// window.
+func (p *ReadYourWrites) remember(key string, w recentWrite, now time.Time) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if now.Sub(p.lastSweep) >= p.window {
+		for k, r := range p.recent {
+			if !now.Before(r.until) {
+				delete(p.recent, k)
+			}
+		}
+		p.lastSweep = now
+	}
+	p.recent[key] = w
+}
+
+// Get retrieves the value for the given key written through the ReadYourWrites within the window,
+// or else from the underlying KV.
+func (p *ReadYourWrites) Get(ctx context.Context, key string) ([]byte, error) {
+	p.mu.Lock()
+	w, ok := p.recent[key]
+	p.mu.Unlock()
+	if ok && p.now().Before(w.until) {
+		if w.deleted {
+			return nil, ErrNotFound
+		}
+		return append([]byte(nil), w.value...), nil
+	}
+	return p.kv.Get(ctx, key)
+}
+
+// Set stores the value for the given key in the underlying KV, remembering it for the window.
+func (p *ReadYourWrites) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	if err := p.kv.Set(ctx, key, value, ttl); err != nil {
+		p.forget(key)
+		return err
+	}
+	now := p.now()
+	until := now.Add(p.window)
+	if ttl > 0 && ttl < p.window {
+		until = now.Add(ttl)
+	}
+	p.remember(key, recentWrite{value: append([]byte(nil), value...), until: until}, now)
+	return nil
+}
+
+// Delete removes any value stored for the given key from the underlying KV, remembering its
+// absence for the window.
+func (p *ReadYourWrites) Delete(ctx context.Context, key string) error {
+	if err := p.kv.Delete(ctx, key); err != nil {
+		p.forget(key)
+		return err
+	}
+	now := p.now()
+	p.remember(key, recentWrite{deleted: true, until: now.Add(p.window)}, now)
+	return nil
+}
+
+// forget discards any write remembered for the key, whose outcome is uncertain after a failure.
+func (p *ReadYourWrites) forget(key string) {
+	p.mu.Lock()
+	delete(p.recent, key)
+	p.mu.Unlock()
+}
+
+// Keys returns the keys of all unexpired values whose keys begin with the given prefix from the
+// underlying KV, or ErrListingUnsupported if it does not implement Lister. The listing may not yet
+// reflect recent writes.
+func (p *ReadYourWrites) Keys(ctx context.Context, prefix string) ([]string, error) {
+	l, ok := p.kv.(Lister)
+	if !ok {
+		return nil, ErrListingUnsupported
+	}
+	return l.Keys(ctx, prefix)
+}
+
+// Health reports whether the underlying KV is reachable.
+func (p *ReadYourWrites) Health(ctx context.Context) error {
+	return checkNode(ctx, p.kv)
+}
+
+// Warmup establishes connections to the underlying KV.
+func (p *ReadYourWrites) Warmup(ctx context.Context) error {
+	return warmNode(ctx, p.kv)
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestNewReadYourWritesPanics(t *testing.T) {
+	tests := []struct {
+		description string
+		kv          kvstore.KV
+		window      time.Duration
+	}{
+		{"no KV", nil, time.Second},
+		{"no window", kvstore.NewMemory(), 0},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			defer ensurePanicWithValueOccured(t)
+			kvstore.NewReadYourWrites(test.kv, test.window)
+		})
+	}
+}
+
+// laggingKV is a KV whose reads never observe writes, like a replica that has yet to catch up.
+type laggingKV struct {
+	primary, replica *kvstore.Memory
+}
+
+func (l laggingKV) Get(ctx context.Context, key string) ([]byte, error) {
+	return l.replica.Get(ctx, key)
+}
+
+func (l laggingKV) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return l.primary.Set(ctx, key, value, ttl)
+}
+
+func (l laggingKV) Delete(ctx context.Context, key string) error {
+	return l.primary.Delete(ctx, key)
+}
+
+func TestReadYourWrites(t *testing.T) {
+	ctx := context.Background()
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	lagging := laggingKV{kvstore.NewMemory(), kvstore.NewMemory()}
+	lagging.replica.Set(ctx, "k", []byte("old"), 0)
+	p := kvstore.NewReadYourWrites(lagging, time.Second)
+	p.Clock = clock
+
+	if err := p.Set(ctx, "k", []byte("new"), 0); err != nil {
+		t.Fatalf("failed to write value: %v", err)
+	}
+	if v, err := p.Get(ctx, "k"); err != nil || string(v) != "new" {
+		t.Errorf("read within window: got (%q, %v), want (new, nil)", v, err)
+	}
+	if err := p.Delete(ctx, "k"); err != nil {
+		t.Fatalf("failed to delete value: %v", err)
+	}
+	if _, err := p.Get(ctx, "k"); err != kvstore.ErrNotFound {
+		t.Errorf("read of deleted value: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+	clock.Advance(time.Second)
+	if v, _ := p.Get(ctx, "k"); string(v) != "old" {
+		t.Errorf("read after window: got %q, want the replica's value", v)
+	}
+
+	p.Set(ctx, "short", []byte("v"), time.Millisecond)
+	clock.Advance(time.Millisecond)
+	if _, err := p.Get(ctx, "short"); err != kvstore.ErrNotFound {
+		t.Errorf("read of expired value: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+}
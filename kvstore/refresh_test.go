@@ -0,0 +1,107 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+var _ handler.Refresher = (*kvstore.Store)(nil)
+
+func TestRefreshNearExpiry(t *testing.T) {
+	start := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := handlertest.NewFakeClock(start)
+	kv := kvstore.NewMemory()
+	kv.Clock = clock
+	store := makeStore(kv)
+	store.Clock = clock
+	store.MaxAge(100)
+	serve := func(r *http.Request, save bool) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if save {
+				session := handler.MustExtractSession(r)
+				session.Values["uid"] = "alice"
+				if err := session.Save(r, w); err != nil {
+					t.Errorf("failed to save session: %v", err)
+				}
+			}
+			w.WriteHeader(http.StatusNoContent)
+		}), nil, handler.RefreshNearExpiry(0.25, clock, func(_ *http.Request, _ *sessions.Session, err error) {
+			t.Errorf("failed to refresh session: %v", err)
+		})).ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	r := requestBearingCookiesFrom(serve(httptest.NewRequest("", "/", nil), true))
+	ids, _ := store.SessionIDs(context.Background())
+	if len(ids) != 1 {
+		t.Fatalf("session IDs: got %d, want 1", len(ids))
+	}
+	clock.Advance(50 * time.Second)
+	if got := serve(r, false).Header().Get("Set-Cookie"); len(got) != 0 {
+		t.Errorf("session not near expiry was refreshed: %q", got)
+	}
+	clock.Advance(30 * time.Second)
+	if got := serve(r, false).Header().Get("Set-Cookie"); len(got) == 0 {
+		t.Error("cookie of session near expiry was not reissued")
+	}
+	for deadline := time.Now().Add(5 * time.Second); ; {
+		ttl, err := kv.TTL(context.Background(), ids[0])
+		if err != nil {
+			t.Fatalf("failed to read session lifetime: %v", err)
+		}
+		if ttl == 100*time.Second {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("session lifetime: got %v, want %v", ttl, 100*time.Second)
+		}
+		time.Sleep(time.Millisecond)
+	}
+	values, err := store.Values(context.Background(), ids[0])
+	if err != nil {
+		t.Fatalf("failed to read session values: %v", err)
+	}
+	if got, want := values[handler.RefreshedAtKey], start.Unix(); got != want {
+		t.Errorf("refresh time: got %v, want %v", got, want)
+	}
+	if got, want := values["uid"], "alice"; got != want {
+		t.Errorf("value: got %v, want %v", got, want)
+	}
+	clock.Advance(50 * time.Second)
+	if _, err := store.Values(context.Background(), ids[0]); err != nil {
+		t.Errorf("refreshed session expired: %v", err)
+	}
+	// Until saved again, the session still appears to be near expiry.
+	if got := serve(r, false).Header().Get("Set-Cookie"); len(got) == 0 {
+		t.Error("cookie of refreshed but unsaved session was not reissued")
+	}
+	serve(r, true)
+	clock.Advance(10 * time.Second)
+	if got := serve(r, false).Header().Get("Set-Cookie"); len(got) != 0 {
+		t.Errorf("recently saved session was refreshed: %q", got)
+	}
+}
+
+func TestRefreshStoredWithEvictedValues(t *testing.T) {
+	store := makeStore(kvstore.NewMemory())
+	session := sessions.NewSession(store, "s")
+	session.ID = "absent"
+	if err := store.RefreshStored(context.Background(), session); err != nil {
+		t.Errorf("failed to refresh evicted session: %v", err)
+	}
+	if _, err := store.Values(context.Background(), "absent"); err != kvstore.ErrNotFound {
+		t.Errorf("error: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+}
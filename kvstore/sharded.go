@@ -0,0 +1,181 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore
+
+import (
+	"context"
+	"hash/fnv"
+	"sort"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+// Sharded is a KV that spreads its values across several KVs, routing each key to one of them by
+// its hash. Since a Store's keys consist of its KeyPrefix followed by a session ID, a Store using a
+// Sharded KV spreads its sessions across the shards by session ID, without any change to the
+// application.
+//
+// Changing the number of shards, the Hash function, or the ShardMap function reroutes keys,
+// stranding the values already stored under them.
+//
+// Create a Sharded with NewSharded.
+type Sharded struct {
+	// Hash hashes a key for routing. If nil, Sharded uses 64-bit FNV-1a.
+	Hash func(key string) uint64
+	// ShardMap chooses the index of the shard, among n shards, for a key with the given hash. If
+	// nil, Sharded uses the hash modulo n.
+	ShardMap func(hash uint64, n int) int
+	shards   []KV
+}
+
+// NewSharded returns a Sharded KV routing keys to the given shards. It panics if no shards are
+// supplied, or if any of them is nil.
+func NewSharded(shards ...KV) *Sharded {
+	if len(shards) == 0 {
+		panic("no shards supplied")
+	}
+	for _, kv := range shards {
+		if kv == nil {
+			panic("nil shard supplied")
+		}
+	}
+	return &Sharded{shards: append([]KV(nil), shards...)}
+}
+
+func fnv1a(key string) uint64 {
+	h := fnv.New64a()
+	h.Write([]byte(key))
+	return h.Sum64()
+}
+
+// shard returns the shard to which the key routes.
+func (s *Sharded) shard(key string) KV {
+	hash := fnv1a
+	if s.Hash != nil {
+		hash = s.Hash
+	}
+	h, n := hash(key), len(s.shards)
+	if s.ShardMap != nil {
+		return s.shards[s.ShardMap(h, n)]
+	}
+	return s.shards[h%uint64(n)]
+}
+
+// Get retrieves the value stored for the given key from the shard to which the key routes.
+func (s *Sharded) Get(ctx context.Context, key string) ([]byte, error) {
+	return s.shard(key).Get(ctx, key)
+}
+
+// Set stores the value for the given key in the shard to which the key routes.
+func (s *Sharded) Set(ctx context.Context, key string, value []byte, ttl time.Duration) error {
+	return s.shard(key).Set(ctx, key, value, ttl)
+}
+
+// Delete removes any value stored for the given key from the shard to which the key routes.
+func (s *Sharded) Delete(ctx context.Context, key string) error {
+	return s.shard(key).Delete(ctx, key)
+}
+
+// compareAndSwap swaps the value for the given key in the KV if it implements CompareAndSwapper,
+// or otherwise stores the new value unconditionally, as a Store does with such KVs.
+func compareAndSwap(ctx context.Context, kv KV, key string, old, new []byte, ttl time.Duration) (bool, error) {
+	cas, ok := kv.(CompareAndSwapper)
+	if !ok {
+		cas = setter{kv}
+	}
+	return cas.CompareAndSwap(ctx, key, old, new, ttl)
+}
+
+// reportTTL returns the time remaining before the value for the given key in the KV expires, or
+// ErrTTLUnsupported if the KV does not implement TTLReporter.
+func reportTTL(ctx context.Context, kv KV, key string) (time.Duration, error) {
+	r, ok := kv.(TTLReporter)
+	if !ok {
+		return 0, ErrTTLUnsupported
+	}
+	return r.TTL(ctx, key)
+}
+
+// CompareAndSwap stores the new value for the given key in the shard to which the key routes, only
+// if the value stored there equals old, implementing CompareAndSwapper. If that shard does not
+// implement CompareAndSwapper, it stores the new value unconditionally.
+func (s *Sharded) CompareAndSwap(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+	return compareAndSwap(ctx, s.shard(key), key, old, new, ttl)
+}
+
+// TTL returns the time remaining before the value stored for the given key in the shard to which
+// the key routes expires, implementing TTLReporter. It returns ErrTTLUnsupported if that shard
+// does not implement TTLReporter.
+func (s *Sharded) TTL(ctx context.Context, key string) (time.Duration, error) {
+	return reportTTL(ctx, s.shard(key), key)
+}
+
+// Keys returns the keys of all unexpired values whose keys begin with the given prefix across all
+// the shards, in lexicographic order. It returns ErrListingUnsupported if any shard does not
+// implement Lister.
+func (s *Sharded) Keys(ctx context.Context, prefix string) ([]string, error) {
+	var keys []string
+	for _, kv := range s.shards {
+		l, ok := kv.(Lister)
+		if !ok {
+			return nil, ErrListingUnsupported
+		}
+		k, err := l.Keys(ctx, prefix)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, k...)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+// PurgeExpired deletes all values that expired before the given time across all the shards,
+// returning the number of values deleted. It returns ErrPurgeUnsupported if any shard does not
+// implement Expirer.
+func (s *Sharded) PurgeExpired(ctx context.Context, before time.Time) (int, error) {
+	for _, kv := range s.shards {
+		if _, ok := kv.(Expirer); !ok {
+			return 0, ErrPurgeUnsupported
+		}
+	}
+	total := 0
+	for _, kv := range s.shards {
+		n, err := kv.(Expirer).PurgeExpired(ctx, before)
+		total += n
+		if err != nil {
+			return total, err
+		}
+	}
+	return total, nil
+}
+
+// Health reports whether all the shards are reachable, returning the first failure encountered. It
+// defers to each shard that implements handler.Healther, and attempts to read a value that's not
+// expected to be present from each other shard.
+func (s *Sharded) Health(ctx context.Context) error {
+	for _, kv := range s.shards {
+		if h, ok := kv.(handler.Healther); ok {
+			if err := h.Health(ctx); err != nil {
+				return err
+			}
+			continue
+		}
+		if _, err := kv.Get(ctx, healthProbeKey); err != nil && err != ErrNotFound {
+			return err
+		}
+	}
+	return nil
+}
+
+// Warmup establishes connections to all the shards, returning the first failure encountered.
+func (s *Sharded) Warmup(ctx context.Context) error {
+	for _, kv := range s.shards {
+		if err := warmNode(ctx, kv); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestNewShardedPanics(t *testing.T) {
+	tests := []struct {
+		description string
+		shards      []kvstore.KV
+	}{
+		{"none", nil},
+		{"nil shard", []kvstore.KV{kvstore.NewMemory(), nil}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			defer ensurePanicWithValueOccured(t)
+			kvstore.NewSharded(test.shards...)
+		})
+	}
+}
+
+func TestShardedSpreadsSessions(t *testing.T) {
+	ctx := context.Background()
+	a, b := kvstore.NewMemory(), kvstore.NewMemory()
+	sharded := kvstore.NewSharded(a, b)
+	store := makeStore(sharded)
+	for i := 0; i < 32; i++ {
+		saveSessionWith(t, store, map[interface{}]interface{}{"i": i})
+	}
+	inA, _ := a.Keys(ctx, "")
+	inB, _ := b.Keys(ctx, "")
+	if len(inA) == 0 || len(inB) == 0 || len(inA)+len(inB) != 32 {
+		t.Errorf("shard sizes: got %d and %d, want a split of 32", len(inA), len(inB))
+	}
+	ids, err := store.SessionIDs(ctx)
+	if err != nil || len(ids) != 32 {
+		t.Fatalf("session IDs: got %d (%v), want 32", len(ids), err)
+	}
+	for _, id := range ids {
+		if _, err := store.Values(ctx, id); err != nil {
+			t.Errorf("failed to read session %q: %v", id, err)
+		}
+	}
+}
+
+func TestShardedCustomRouting(t *testing.T) {
+	ctx := context.Background()
+	a, b := kvstore.NewMemory(), kvstore.NewMemory()
+	sharded := kvstore.NewSharded(a, b)
+	sharded.Hash = func(key string) uint64 { return uint64(len(key)) }
+	sharded.ShardMap = func(hash uint64, n int) int {
+		if hash > 1 {
+			return 1
+		}
+		return 0
+	}
+	sharded.Set(ctx, "k", []byte("short"), 0)
+	sharded.Set(ctx, "kk", []byte("long"), 0)
+	if v, err := a.Get(ctx, "k"); err != nil || string(v) != "short" {
+		t.Errorf("first shard: got (%q, %v), want (short, nil)", v, err)
+	}
+	if v, err := b.Get(ctx, "kk"); err != nil || string(v) != "long" {
+		t.Errorf("second shard: got (%q, %v), want (long, nil)", v, err)
+	}
+	if err := sharded.Delete(ctx, "kk"); err != nil {
+		t.Fatalf("failed to delete value: %v", err)
+	}
+	if _, err := sharded.Get(ctx, "kk"); err != kvstore.ErrNotFound {
+		t.Errorf("error after deletion: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+}
+
+func TestShardedCapabilities(t *testing.T) {
+	ctx := context.Background()
+	expectedError := errors.New("")
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	a, b := kvstore.NewMemory(), kvstore.NewMemory()
+	a.Clock, b.Clock = clock, clock
+	sharded := kvstore.NewSharded(a, b)
+	for _, k := range []string{"a", "b", "c", "d"} {
+		sharded.Set(ctx, k, nil, time.Minute)
+	}
+	if n, err := sharded.PurgeExpired(ctx, clock.Now().Add(time.Hour)); err != nil || n != 4 {
+		t.Errorf("purge: got (%d, %v), want (4, nil)", n, err)
+	}
+
+	sharded.Set(ctx, "k", []byte("v"), time.Minute)
+	if swapped, err := sharded.CompareAndSwap(ctx, "k", []byte("other"), []byte("w"), time.Minute); err != nil || swapped {
+		t.Errorf("swap of stale value: got (%t, %v), want (false, nil)", swapped, err)
+	}
+	if swapped, err := sharded.CompareAndSwap(ctx, "k", []byte("v"), []byte("w"), 2*time.Minute); err != nil || !swapped {
+		t.Errorf("swap of current value: got (%t, %v), want (true, nil)", swapped, err)
+	}
+	if v, err := sharded.Get(ctx, "k"); err != nil || string(v) != "w" {
+		t.Errorf("swapped value: got (%q, %v), want (%q, nil)", v, err, "w")
+	}
+	if ttl, err := sharded.TTL(ctx, "k"); err != nil || ttl != 2*time.Minute {
+		t.Errorf("TTL: got (%v, %v), want (%v, nil)", ttl, err, 2*time.Minute)
+	}
+	if _, err := kvstore.NewSharded(failingKV{expectedError}).TTL(ctx, "k"); err != kvstore.ErrTTLUnsupported {
+		t.Errorf("TTL error: got %v, want %v", err, kvstore.ErrTTLUnsupported)
+	}
+
+	mixed := kvstore.NewSharded(a, failingKV{expectedError})
+	if _, err := mixed.Keys(ctx, ""); err != kvstore.ErrListingUnsupported {
+		t.Errorf("listing error: got %v, want %v", err, kvstore.ErrListingUnsupported)
+	}
+	if _, err := mixed.PurgeExpired(ctx, clock.Now()); err != kvstore.ErrPurgeUnsupported {
+		t.Errorf("purge error: got %v, want %v", err, kvstore.ErrPurgeUnsupported)
+	}
+	if err := mixed.Health(ctx); err != expectedError {
+		t.Errorf("health: got %v, want %v", err, expectedError)
+	}
+	if err := kvstore.NewSharded(a, healthyKV{}).Health(ctx); err != nil {
+		t.Errorf("health: got %v, want nil", err)
+	}
+}
+
+var (
+	_ handler.Healther          = (*kvstore.Sharded)(nil)
+	_ kvstore.CompareAndSwapper = (*kvstore.Sharded)(nil)
+	_ kvstore.TTLReporter       = (*kvstore.Sharded)(nil)
+)
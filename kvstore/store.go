@@ -0,0 +1,594 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+/*
+Package kvstore provides a gorilla/sessions Store that keeps session data in any storage facility
+that can implement the small KV interface, sending only a signed session ID to the client.
+*/
+package kvstore
+
+import (
+	"context"
+	"encoding/base32"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+// ErrNotFound is the error that a KV returns when it holds no value for a requested key.
+var ErrNotFound = errors.New("kvstore: key not found")
+
+// KV is a minimal key-value storage facility, sufficient to back a Store.
+type KV interface {
+	// Get retrieves the value stored for the given key, or returns ErrNotFound if no such value is
+	// present or the value has expired.
+	Get(ctx context.Context, key string) ([]byte, error)
+	// Set stores the value for the given key, replacing any value already present. If ttl is
+	// positive, the value should expire and become unavailable once that much time has elapsed.
+	Set(ctx context.Context, key string, value []byte, ttl time.Duration) error
+	// Delete removes any value stored for the given key. Deleting an absent key is not an error.
+	Delete(ctx context.Context, key string) error
+}
+
+// Lister is implemented by KVs that can enumerate the keys they hold, enabling a Store to enumerate
+// its sessions.
+type Lister interface {
+	// Keys returns the keys of all unexpired values whose keys begin with the given prefix.
+	Keys(ctx context.Context, prefix string) ([]string, error)
+}
+
+// Expirer is implemented by KVs that can delete their expired values eagerly, rather than only
+// lazily as they're encountered, enabling a Store to purge its expired sessions.
+type Expirer interface {
+	// PurgeExpired deletes all values that expired before the given time, returning the number of
+	// values deleted.
+	PurgeExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+// TTLReporter is implemented by KVs that can report how long their values have left to live,
+// enabling values to be copied between KVs without changing when they expire.
+type TTLReporter interface {
+	// TTL returns the time remaining before the value stored for the given key expires, or zero
+	// if it never expires, or returns ErrNotFound if no such value is present or it has expired.
+	TTL(ctx context.Context, key string) (time.Duration, error)
+}
+
+// ErrPurgeUnsupported is the error that a Store returns when asked to purge its expired sessions
+// if its KV does not implement Expirer.
+var ErrPurgeUnsupported = errors.New("kvstore: KV cannot purge its expired values")
+
+// ErrListingUnsupported is the error that a Store returns when asked to enumerate its sessions if
+// its KV does not implement Lister.
+var ErrListingUnsupported = errors.New("kvstore: KV cannot enumerate its keys")
+
+// ErrTTLUnsupported is the error that KVs routing keys to other KVs, such as Sharded, return when
+// asked for the lifetime of a value held by a KV that does not implement TTLReporter.
+var ErrTTLUnsupported = errors.New("kvstore: KV cannot report the lifetimes of its values")
+
+// Store is a sessions.Store that keeps the values of each session in a KV, keyed by a randomly
+// generated session ID. The session cookie carries only that ID, encoded by the store's codecs.
+type Store struct {
+	Codecs  []securecookie.Codec
+	Options *sessions.Options // default configuration
+	// KeyPrefix is prepended to each session ID to form the key used with the KV.
+	KeyPrefix string
+	// Serializer encodes session values for storage in the KV. If nil, the store uses
+	// securecookie.GobEncoder.
+	Serializer securecookie.Serializer
+	// GenerateID creates the ID for a newly saved session. If nil, the store uses 32 random bytes,
+	// encoded in unpadded base32.
+	GenerateID func() string
+	// Clock reports the current time, used to compute cookie expiration times. If nil, the store
+	// uses handler.SystemClock.
+	Clock handler.Clock
+	// Blobs, if not nil, holds each session value whose encoded size exceeds BlobThreshold, while
+	// the KV holds only a BlobRef in its place, keeping large values from weighing on every load of
+	// their session. The store resolves BlobRefs transparently as it loads sessions, gives blobs the
+	// same lifetime as their sessions, and deletes blobs no longer referenced if Blobs implements
+	// Lister. Blobs may be the store's KV only if KeyPrefix is not empty, as blob keys begin with
+	// the ID of their session. The store's Serializer must preserve the BlobRef type, as the default
+	// gob encoder does.
+	Blobs KV
+	// BlobThreshold is the encoded size in bytes above which a value moves into Blobs. If not
+	// positive, the store uses 4 KiB.
+	BlobThreshold int
+	// OnConflict, if not nil, enables optimistic concurrency control: the store records a version
+	// number among each session's values under VersionKey, and when saving a session finds that
+	// another request saved it since it was loaded, calls OnConflict with the session and the values
+	// stored by the other request, which are nil if the session has since been deleted. If
+	// OnConflict returns an error, saving fails with that error; otherwise, saving proceeds with the
+	// session's values, as OnConflict may have adjusted them. RejectConflicts refuses all such
+	// saves.
+	//
+	// Saves are atomic only if the KV implements CompareAndSwapper; otherwise, a narrow window
+	// remains in which concurrent saves can overwrite each other undetected.
+	OnConflict func(ctx context.Context, session *sessions.Session, stored map[interface{}]interface{}) error
+	// ArchiveKey, if not nil, is the AES key, 16, 24, or 32 bytes long, with which Export encrypts
+	// the values of the sessions it writes, and with which Import decrypts them.
+	ArchiveKey []byte
+	kv         KV
+	// fresh holds sessions reclaimed via Recycle, for reuse by requests bearing no session cookie.
+	fresh sync.Pool
+}
+
+// New returns a Store that keeps session values in the supplied KV, encoding session IDs in
+// cookies with codecs built from the supplied key pairs, per securecookie.CodecsFromPairs. It
+// panics if the supplied KV is nil.
+//
+// Sessions and their cookies expire after thirty days by default.
+func New(kv KV, keyPairs ...[]byte) *Store {
+	if kv == nil {
+		panic("no KV supplied")
+	}
+	s := &Store{
+		Codecs: securecookie.CodecsFromPairs(keyPairs...),
+		Options: &sessions.Options{
+			Path:   "/",
+			MaxAge: 86400 * 30,
+		},
+		kv: kv,
+	}
+	s.MaxAge(s.Options.MaxAge)
+	return s
+}
+
+// Get returns a cached session with the given name, creating it via New if the request has no such
+// session cached yet.
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+// New creates a session with the given name. If the request bears a cookie for that session
+// naming a session that the KV still holds, it populates the session with the stored values.
+// If the KV no longer holds those values, it returns a fresh session without error.
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	if s.OnConflict == nil {
+		return s.newSession(r, name, s, s.load)
+	}
+	vs := &versionedStore{Store: s}
+	return s.newSession(r, name, vs, func(ctx context.Context, session *sessions.Session) error {
+		b, err := s.kv.Get(ctx, s.key(session.ID))
+		if err != nil {
+			return err
+		}
+		if err := s.decode(ctx, b, &session.Values); err != nil {
+			return err
+		}
+		return s.decode(ctx, b, &vs.baseline)
+	})
+}
+
+// NewProjected is like New, but populates the session with only the values for the given keys,
+// implementing handler.ProjectingSource. If the store's Serializer implements
+// handler.ProjectingSerializer, it decodes only those values; otherwise, it decodes all the values
+// and discards the rest.
+//
+// Saving the session preserves the stored values for keys not projected, by reading the stored
+// values again and merging the session's values into them. Concurrent requests that save the same
+// session may lose each other's changes to keys that only one of them projected.
+func (s *Store) NewProjected(r *http.Request, name string, keys []interface{}) (*sessions.Session, error) {
+	return s.newSession(r, name, projectedStore{s, keys}, func(ctx context.Context, session *sessions.Session) error {
+		return s.loadProjected(ctx, session, keys)
+	})
+}
+
+func (s *Store) newSession(r *http.Request, name string, store sessions.Store, load func(context.Context, *sessions.Session) error) (*sessions.Session, error) {
+	c, err := r.Cookie(name)
+	if err != nil && store == sessions.Store(s) {
+		if session := s.reuseSession(name); session != nil {
+			return session, nil
+		}
+	}
+	session := sessions.NewSession(store, name)
+	opts := *s.Options
+	session.Options = &opts
+	session.IsNew = true
+	if err != nil {
+		return session, nil
+	}
+	if err := securecookie.DecodeMulti(name, c.Value, &session.ID, s.Codecs...); err != nil {
+		return session, err
+	}
+	switch err := load(r.Context(), session); err {
+	case nil:
+		session.IsNew = false
+	case ErrNotFound:
+		session.ID = ""
+	default:
+		return session, err
+	}
+	return session, nil
+}
+
+// reuseSession returns a fresh session with the given name reclaimed via Recycle, or nil if none
+// is available.
+func (s *Store) reuseSession(name string) *sessions.Session {
+	session, _ := s.fresh.Get().(*sessions.Session)
+	if session == nil || session.Name() != name {
+		return nil
+	}
+	*session.Options = *s.Options
+	return session
+}
+
+// Recycle reclaims a session supplied by New for reuse by a later request bearing no session
+// cookie, implementing handler.Recycler. It ignores sessions supplied by other stores, or by New
+// while OnConflict is set or by NewProjected.
+func (s *Store) Recycle(session *sessions.Session) {
+	if session.Store() != sessions.Store(s) || session.Options == nil || session.Values == nil {
+		return
+	}
+	for k := range session.Values {
+		delete(session.Values, k)
+	}
+	session.ID = ""
+	session.IsNew = true
+	s.fresh.Put(session)
+}
+
+var base32RawStdEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Save writes the session's values to the KV and adds a cookie bearing the session's ID to the
+// response. If the session's MaxAge option is not positive, it instead deletes the session's
+// values from the KV and adds a cookie instructing the client to discard the session.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	ctx := r.Context()
+	if session.Options.MaxAge <= 0 {
+		if len(session.ID) != 0 {
+			if err := s.Delete(ctx, session.ID); err != nil {
+				return err
+			}
+		}
+		http.SetCookie(w, s.newCookie(session.Name(), "", session.Options))
+		return nil
+	}
+	if len(session.ID) == 0 {
+		session.ID = s.generateID()
+	}
+	if err := s.save(ctx, session); err != nil {
+		return err
+	}
+	return s.RefreshCookie(w, session)
+}
+
+// RefreshCookie adds a cookie bearing the session's ID to the response, with its expiration time
+// reset per the session's options, implementing handler.Refresher.
+func (s *Store) RefreshCookie(w http.ResponseWriter, session *sessions.Session) error {
+	encoded, err := securecookie.EncodeMulti(session.Name(), session.ID, s.Codecs...)
+	if err != nil {
+		return err
+	}
+	http.SetCookie(w, s.newCookie(session.Name(), encoded, session.Options))
+	return nil
+}
+
+// RefreshStored extends the lifetime of the values that the KV holds for the session, and of any
+// of its values held in Blobs, per the session's MaxAge option, implementing handler.Refresher. It
+// rewrites the stored values as they are, regardless of the session's values in memory. If the KV
+// no longer holds the session, or if the KV implements CompareAndSwapper and another save
+// intervenes, it does nothing.
+func (s *Store) RefreshStored(ctx context.Context, session *sessions.Session) error {
+	key := s.key(session.ID)
+	current, err := s.kv.Get(ctx, key)
+	switch err {
+	case nil:
+	case ErrNotFound:
+		return nil
+	default:
+		return err
+	}
+	var values map[interface{}]interface{}
+	if err := s.serializer().Deserialize(current, &values); err != nil {
+		return err
+	}
+	opts := session.Options
+	if opts == nil {
+		opts = s.Options
+	}
+	ttl := time.Duration(opts.MaxAge) * time.Second
+	for _, v := range values {
+		if ref, ok := v.(BlobRef); ok && s.Blobs != nil {
+			b, err := s.Blobs.Get(ctx, ref.Key)
+			if err == ErrNotFound {
+				continue
+			} else if err != nil {
+				return err
+			}
+			if err := s.Blobs.Set(ctx, ref.Key, b, ttl); err != nil {
+				return err
+			}
+		}
+	}
+	if cas, ok := s.kv.(CompareAndSwapper); ok {
+		_, err := cas.CompareAndSwap(ctx, key, current, current, ttl)
+		return err
+	}
+	return s.kv.Set(ctx, key, current, ttl)
+}
+
+func (s *Store) generateID() string {
+	if s.GenerateID != nil {
+		return s.GenerateID()
+	}
+	return base32RawStdEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+}
+
+func (s *Store) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock.Now()
+	}
+	return handler.SystemClock.Now()
+}
+
+func (s *Store) serializer() securecookie.Serializer {
+	if s.Serializer != nil {
+		return s.Serializer
+	}
+	return securecookie.GobEncoder{}
+}
+
+// newCookie is like sessions.NewCookie, but computes the expiration time using the store's clock.
+func (s *Store) newCookie(name, value string, options *sessions.Options) *http.Cookie {
+	cookie := sessions.NewCookie(name, value, options)
+	if options.MaxAge > 0 {
+		cookie.Expires = s.now().Add(time.Duration(options.MaxAge) * time.Second)
+	}
+	return cookie
+}
+
+// MaxAge sets the maximum age for the store's sessions and their cookies. Individual sessions can
+// be deleted by setting their Options.MaxAge to -1.
+func (s *Store) MaxAge(age int) {
+	s.Options.MaxAge = age
+	for _, codec := range s.Codecs {
+		if sc, ok := codec.(*securecookie.SecureCookie); ok {
+			sc.MaxAge(age)
+		}
+	}
+}
+
+// LoadDetached replaces the values of the given session with those the KV holds for the session
+// with the same ID, returning an error matching both ErrNotFound and handler.ErrSessionExpired if
+// the KV holds no such values.
+func (s *Store) LoadDetached(ctx context.Context, session *sessions.Session) error {
+	session.Values = make(map[interface{}]interface{})
+	err := s.load(ctx, session)
+	if err == ErrNotFound {
+		return fmt.Errorf("%w: %w", handler.ErrSessionExpired, err)
+	}
+	return err
+}
+
+// SaveDetached writes the values of the given session, which must have been saved previously and
+// thus bear an ID, to the KV without emitting a cookie.
+func (s *Store) SaveDetached(ctx context.Context, session *sessions.Session) error {
+	if len(session.ID) == 0 {
+		return errNoSessionID
+	}
+	return s.save(ctx, session)
+}
+
+var errNoSessionID = errors.New("kvstore: session has no ID")
+
+// SessionIDs returns the IDs of all the sessions held in the store's KV, or ErrListingUnsupported
+// if the KV does not implement Lister.
+func (s *Store) SessionIDs(ctx context.Context) ([]string, error) {
+	l, ok := s.kv.(Lister)
+	if !ok {
+		return nil, ErrListingUnsupported
+	}
+	keys, err := l.Keys(ctx, s.KeyPrefix)
+	if err != nil {
+		return nil, err
+	}
+	for i, k := range keys {
+		keys[i] = k[len(s.KeyPrefix):]
+	}
+	return keys, nil
+}
+
+// ActiveSessions returns the number of sessions held in the store's KV, or ErrListingUnsupported if
+// the KV does not implement Lister.
+func (s *Store) ActiveSessions(ctx context.Context) (int, error) {
+	ids, err := s.SessionIDs(ctx)
+	return len(ids), err
+}
+
+// PurgeExpired deletes all sessions that expired before the given time from the store's KV,
+// returning the number of sessions deleted, or returns ErrPurgeUnsupported if the KV does not
+// implement Expirer. Note that it purges all expired values in the KV, including those outside
+// the store's KeyPrefix.
+func (s *Store) PurgeExpired(ctx context.Context, before time.Time) (int, error) {
+	e, ok := s.kv.(Expirer)
+	if !ok {
+		return 0, ErrPurgeUnsupported
+	}
+	return e.PurgeExpired(ctx, before)
+}
+
+// Values returns the values stored for the session with the given ID, or ErrNotFound if the KV
+// holds no such session.
+func (s *Store) Values(ctx context.Context, id string) (map[interface{}]interface{}, error) {
+	b, err := s.kv.Get(ctx, s.key(id))
+	if err != nil {
+		return nil, err
+	}
+	values := make(map[interface{}]interface{})
+	if err := s.serializer().Deserialize(b, &values); err != nil {
+		return nil, err
+	}
+	if err := s.resolveBlobs(ctx, values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+// healthProbeKey is the key that Health attempts to read from KVs that can't report their own
+// health.
+const healthProbeKey = "\x00health-probe"
+
+// Health reports whether the store's KV is reachable. If the KV implements handler.Healther, it
+// defers to the KV; otherwise, it attempts to read a value that's not expected to be present.
+func (s *Store) Health(ctx context.Context) error {
+	if h, ok := s.kv.(handler.Healther); ok {
+		return h.Health(ctx)
+	}
+	if _, err := s.kv.Get(ctx, s.key(healthProbeKey)); err != nil && err != ErrNotFound {
+		return err
+	}
+	return nil
+}
+
+// Validate reports the problems with the store's configuration, or returns nil if it's suitable
+// for use, implementing handler.Validator. It checks that the store has codecs and that its
+// cookie attributes are consistent per handler.ValidateCookieOptions.
+func (s *Store) Validate() error {
+	var problems []string
+	if len(s.Codecs) == 0 {
+		problems = append(problems, "store has no codecs")
+	}
+	if err := handler.ValidateCookieOptions("", s.Options); err != nil {
+		problems = append(problems, err.Error())
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid session store configuration: " + strings.Join(problems, "; "))
+}
+
+// warmNode establishes connections to the KV's backend, deferring to KVs that implement
+// handler.Warmer and otherwise checking its health per checkNode.
+func warmNode(ctx context.Context, kv KV) error {
+	if w, ok := kv.(handler.Warmer); ok {
+		return w.Warmup(ctx)
+	}
+	return checkNode(ctx, kv)
+}
+
+// Warmup establishes connections to the store's KV and to its Blobs KV, if any, implementing
+// handler.Warmer. It defers to KVs that implement handler.Warmer, and otherwise checks their health
+// as Health does.
+func (s *Store) Warmup(ctx context.Context) error {
+	if err := warmNode(ctx, s.kv); err != nil {
+		return err
+	}
+	if s.Blobs != nil {
+		return warmNode(ctx, s.Blobs)
+	}
+	return nil
+}
+
+// Delete removes the session with the given ID from the KV, ending it regardless of whether its
+// client still holds a cookie for it.
+func (s *Store) Delete(ctx context.Context, id string) error {
+	if err := s.kv.Delete(ctx, s.key(id)); err != nil {
+		return err
+	}
+	if s.Blobs != nil {
+		return s.deleteBlobs(ctx, id, nil)
+	}
+	return nil
+}
+
+func (s *Store) key(id string) string {
+	return s.KeyPrefix + id
+}
+
+func (s *Store) save(ctx context.Context, session *sessions.Session) error {
+	opts := session.Options
+	if opts == nil {
+		opts = s.Options
+	}
+	ttl := time.Duration(opts.MaxAge) * time.Second
+	if s.OnConflict != nil {
+		return s.saveVersioned(ctx, session, ttl)
+	}
+	b, err := s.encode(ctx, session, ttl)
+	if err != nil {
+		return err
+	}
+	return s.kv.Set(ctx, s.key(session.ID), b, ttl)
+}
+
+// encode returns the session's values as stored in the KV.
+func (s *Store) encode(ctx context.Context, session *sessions.Session, ttl time.Duration) ([]byte, error) {
+	values, err := s.externalizeBlobs(ctx, session.ID, session.Values, ttl)
+	if err != nil {
+		return nil, err
+	}
+	return s.serializer().Serialize(values)
+}
+
+func (s *Store) load(ctx context.Context, session *sessions.Session) error {
+	b, err := s.kv.Get(ctx, s.key(session.ID))
+	if err != nil {
+		return err
+	}
+	return s.decode(ctx, b, &session.Values)
+}
+
+// decode decodes the values stored in the KV into dst, resolving any BlobRefs among them.
+func (s *Store) decode(ctx context.Context, b []byte, dst *map[interface{}]interface{}) error {
+	if err := s.serializer().Deserialize(b, dst); err != nil {
+		return err
+	}
+	return s.resolveBlobs(ctx, *dst)
+}
+
+func (s *Store) loadProjected(ctx context.Context, session *sessions.Session, keys []interface{}) error {
+	b, err := s.kv.Get(ctx, s.key(session.ID))
+	if err != nil {
+		return err
+	}
+	if ps, ok := s.serializer().(handler.ProjectingSerializer); ok {
+		if err := ps.DeserializeProjected(b, &session.Values, keys); err != nil {
+			return err
+		}
+		return s.resolveBlobs(ctx, session.Values)
+	}
+	var all map[interface{}]interface{}
+	if err := s.serializer().Deserialize(b, &all); err != nil {
+		return err
+	}
+	session.Values = make(map[interface{}]interface{}, len(keys))
+	for _, k := range keys {
+		if v, ok := all[k]; ok {
+			session.Values[k] = v
+		}
+	}
+	return s.resolveBlobs(ctx, session.Values)
+}
+
+// projectedStore is a sessions.Store that saves sessions populated with only the values for its
+// keys, preserving the stored values for all other keys.
+type projectedStore struct {
+	*Store
+	keys []interface{}
+}
+
+func (p projectedStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	if session.Options.MaxAge <= 0 || len(session.ID) == 0 {
+		return p.Store.Save(r, w, session)
+	}
+	merged := *session
+	merged.Values = make(map[interface{}]interface{})
+	switch err := p.load(r.Context(), &merged); err {
+	case nil, ErrNotFound:
+	default:
+		return err
+	}
+	for _, k := range p.keys {
+		delete(merged.Values, k)
+	}
+	for k, v := range session.Values {
+		merged.Values[k] = v
+	}
+	return p.Store.Save(r, w, &merged)
+}
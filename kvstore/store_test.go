@@ -0,0 +1,508 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+func ensurePanicWithValueOccured(t *testing.T) {
+	if p := recover(); p == nil {
+		t.Error("panic was not called with a non-nil argument")
+	}
+}
+
+func makeStore(kv kvstore.KV) *kvstore.Store {
+	return kvstore.New(kv, securecookie.GenerateRandomKey(32))
+}
+
+func requestBearingCookiesFrom(recorder *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest("", "/", nil)
+	for _, c := range recorder.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestNewPanicsWithNoKV(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	kvstore.New(nil)
+}
+
+func TestRoundTrip(t *testing.T) {
+	store := makeStore(kvstore.NewMemory())
+	r := httptest.NewRequest("", "/", nil)
+	session, err := store.New(r, "s")
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if !session.IsNew {
+		t.Error("session is not new")
+	}
+	session.Values["k"] = "v"
+	recorder := httptest.NewRecorder()
+	if err := session.Save(r, recorder); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	id := session.ID
+	if len(id) == 0 {
+		t.Fatal("saved session has no ID")
+	}
+
+	session, err = store.New(requestBearingCookiesFrom(recorder), "s")
+	if err != nil {
+		t.Fatalf("failed to recover session: %v", err)
+	}
+	if session.IsNew {
+		t.Error("recovered session is new")
+	}
+	if got, want := session.ID, id; got != want {
+		t.Errorf("session ID: got %q, want %q", got, want)
+	}
+	if got, want := session.Values["k"], "v"; got != want {
+		t.Errorf("value: got %v, want %v", got, want)
+	}
+}
+
+func TestNewWithEvictedValues(t *testing.T) {
+	kv := kvstore.NewMemory()
+	store := makeStore(kv)
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	recorder := httptest.NewRecorder()
+	if err := session.Save(r, recorder); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if err := kv.Delete(context.Background(), session.ID); err != nil {
+		t.Fatalf("failed to delete session values: %v", err)
+	}
+
+	session, err := store.New(requestBearingCookiesFrom(recorder), "s")
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if !session.IsNew {
+		t.Error("session is not new")
+	}
+	if len(session.ID) != 0 {
+		t.Errorf("session ID: got %q, want none", session.ID)
+	}
+}
+
+func TestNewWithForeignCookie(t *testing.T) {
+	store := makeStore(kvstore.NewMemory())
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	recorder := httptest.NewRecorder()
+	session.Save(r, recorder)
+
+	other := makeStore(kvstore.NewMemory())
+	_, err := other.New(requestBearingCookiesFrom(recorder), "s")
+	if serr, ok := err.(securecookie.Error); !ok || !serr.IsDecode() {
+		t.Errorf("error: got %v, want a decoding error", err)
+	}
+}
+
+type failingKV struct {
+	err error
+}
+
+func (f failingKV) Get(context.Context, string) ([]byte, error) {
+	return nil, f.err
+}
+
+func (f failingKV) Set(context.Context, string, []byte, time.Duration) error {
+	return f.err
+}
+
+func (f failingKV) Delete(context.Context, string) error {
+	return f.err
+}
+
+func TestKVFailure(t *testing.T) {
+	expectedError := errors.New("")
+	kv := kvstore.NewMemory()
+	store := makeStore(kv)
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	recorder := httptest.NewRecorder()
+	session.Save(r, recorder)
+
+	failing := makeStore(failingKV{expectedError})
+	failing.Codecs = store.Codecs
+	if _, err := failing.New(requestBearingCookiesFrom(recorder), "s"); err != expectedError {
+		t.Errorf("error from New: got %v, want %v", err, expectedError)
+	}
+	if err := failing.Save(r, httptest.NewRecorder(), session); err != expectedError {
+		t.Errorf("error from Save: got %v, want %v", err, expectedError)
+	}
+}
+
+func TestSaveWithNonPositiveMaxAgeDeletesSession(t *testing.T) {
+	kv := kvstore.NewMemory()
+	store := makeStore(kv)
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	recorder := httptest.NewRecorder()
+	if err := session.Save(r, recorder); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	session.Options.MaxAge = -1
+	recorder = httptest.NewRecorder()
+	if err := session.Save(r, recorder); err != nil {
+		t.Fatalf("failed to delete session: %v", err)
+	}
+	if _, err := kv.Get(context.Background(), session.ID); err != kvstore.ErrNotFound {
+		t.Errorf("error: got %v, want %v", err, kvstore.ErrNotFound)
+	}
+	cookies := recorder.Result().Cookies()
+	if got, want := len(cookies), 1; got != want {
+		t.Fatalf("cookie count: got %d, want %d", got, want)
+	}
+	if cookies[0].MaxAge >= 0 {
+		t.Errorf("cookie MaxAge: got %d, want a negative value", cookies[0].MaxAge)
+	}
+}
+
+func TestKeyPrefix(t *testing.T) {
+	kv := kvstore.NewMemory()
+	store := makeStore(kv)
+	store.KeyPrefix = "session:"
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	if err := session.Save(r, httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if _, err := kv.Get(context.Background(), "session:"+session.ID); err != nil {
+		t.Errorf("failed to find session values under prefixed key: %v", err)
+	}
+}
+
+func TestServesAsSessionSource(t *testing.T) {
+	var source handler.SessionSource = makeStore(kvstore.NewMemory())
+	called := false
+	h := handler.WithSession("s", source, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		if session := handler.MustExtractSession(r); !session.IsNew {
+			t.Error("extracted session is not new")
+		}
+	}), nil)
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+	if !called {
+		t.Error("delegate handler was not called")
+	}
+}
+
+func TestDetachedRoundTrip(t *testing.T) {
+	ctx := context.Background()
+	store := makeStore(kvstore.NewMemory())
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	session.Values["k"] = "v"
+	if err := store.SaveDetached(ctx, session); err == nil {
+		t.Error("saved a session with no ID")
+	}
+	if err := session.Save(r, httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	session.Values["k"] = "w"
+	if err := store.SaveDetached(ctx, session); err != nil {
+		t.Fatalf("failed to save detached session: %v", err)
+	}
+
+	loaded := sessions.NewSession(store, "s")
+	loaded.ID = session.ID
+	if err := store.LoadDetached(ctx, loaded); err != nil {
+		t.Fatalf("failed to load detached session: %v", err)
+	}
+	if got, want := loaded.Values["k"], "w"; got != want {
+		t.Errorf("value: got %v, want %v", got, want)
+	}
+	loaded.ID = "absent"
+	err := store.LoadDetached(ctx, loaded)
+	if !errors.Is(err, kvstore.ErrNotFound) || !errors.Is(err, handler.ErrSessionExpired) {
+		t.Errorf("error: got %v, want %v and %v", err, kvstore.ErrNotFound, handler.ErrSessionExpired)
+	}
+}
+
+var _ handler.DetachedStore = (*kvstore.Store)(nil)
+
+func TestCustomIDAndClock(t *testing.T) {
+	store := makeStore(kvstore.NewMemory())
+	store.GenerateID = func() string { return "fixed" }
+	now := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	store.Clock = handler.ClockFunc(func() time.Time { return now })
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	recorder := httptest.NewRecorder()
+	if err := session.Save(r, recorder); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if got, want := session.ID, "fixed"; got != want {
+		t.Errorf("session ID: got %q, want %q", got, want)
+	}
+	cookie := recorder.Result().Cookies()[0]
+	if got, want := cookie.Expires, now.Add(time.Duration(store.Options.MaxAge)*time.Second); !got.Equal(want) {
+		t.Errorf("cookie expiration: got %v, want %v", got, want)
+	}
+}
+
+func TestSessionIDsWithPrefix(t *testing.T) {
+	kv := kvstore.NewMemory()
+	kv.Set(context.Background(), "other", nil, 0)
+	store := makeStore(kv)
+	store.KeyPrefix = "session:"
+	id := saveSessionWith(t, store, nil)
+	ids, err := store.SessionIDs(context.Background())
+	if err != nil {
+		t.Fatalf("failed to list sessions: %v", err)
+	}
+	if len(ids) != 1 || ids[0] != id {
+		t.Errorf("session IDs: got %q, want [%q]", ids, id)
+	}
+	if _, err := makeStore(failingKV{}).SessionIDs(context.Background()); err != kvstore.ErrListingUnsupported {
+		t.Errorf("error: got %v, want %v", err, kvstore.ErrListingUnsupported)
+	}
+}
+
+type healthyKV struct {
+	failingKV
+	err error
+}
+
+func (h healthyKV) Health(context.Context) error {
+	return h.err
+}
+
+func TestHealth(t *testing.T) {
+	ctx := context.Background()
+	expectedError := errors.New("")
+	tests := []struct {
+		description string
+		kv          kvstore.KV
+		want        error
+	}{
+		{"memory", kvstore.NewMemory(), nil},
+		{"failing", failingKV{expectedError}, expectedError},
+		{"healther", healthyKV{failingKV: failingKV{expectedError}}, nil},
+		{"unhealthy healther", healthyKV{err: expectedError}, expectedError},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if got := makeStore(test.kv).Health(ctx); got != test.want {
+				t.Errorf("error: got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+var _ handler.Healther = (*kvstore.Store)(nil)
+
+// warmingKV is a KV that records whether it was warmed up.
+type warmingKV struct {
+	failingKV
+	warmed bool
+}
+
+func (w *warmingKV) Warmup(context.Context) error {
+	w.warmed = true
+	return w.err
+}
+
+func TestWarmup(t *testing.T) {
+	ctx := context.Background()
+	expectedError := errors.New("")
+	if err := makeStore(kvstore.NewMemory()).Warmup(ctx); err != nil {
+		t.Errorf("failed to warm up store: %v", err)
+	}
+	if got := makeStore(failingKV{expectedError}).Warmup(ctx); got != expectedError {
+		t.Errorf("error: got %v, want %v", got, expectedError)
+	}
+	var shards []kvstore.KV
+	for i := 0; i < 2; i++ {
+		shards = append(shards, &warmingKV{})
+	}
+	store := makeStore(kvstore.NewSharded(shards...))
+	blobs := &warmingKV{failingKV: failingKV{expectedError}}
+	store.Blobs = blobs
+	if got := store.Warmup(ctx); got != expectedError {
+		t.Errorf("error: got %v, want %v", got, expectedError)
+	}
+	for i, kv := range append(shards, blobs) {
+		if !kv.(*warmingKV).warmed {
+			t.Errorf("KV %d was not warmed up", i)
+		}
+	}
+}
+
+var _ handler.Warmer = (*kvstore.Store)(nil)
+
+func TestValidate(t *testing.T) {
+	store := makeStore(kvstore.NewMemory())
+	if err := store.Validate(); err != nil {
+		t.Errorf("failed to validate store: %v", err)
+	}
+	store.Codecs = nil
+	store.Options.SameSite = http.SameSiteNoneMode
+	err := store.Validate()
+	if err == nil {
+		t.Fatal("invalid store was validated")
+	}
+	for _, want := range []string{"codecs", "SameSite=None"} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+}
+
+func TestActiveSessions(t *testing.T) {
+	ctx := context.Background()
+	store := makeStore(kvstore.NewMemory())
+	saveSessionWith(t, store, nil)
+	saveSessionWith(t, store, nil)
+	if n, err := store.ActiveSessions(ctx); err != nil || n != 2 {
+		t.Errorf("active sessions: got (%d, %v), want (2, nil)", n, err)
+	}
+	if _, err := makeStore(failingKV{}).ActiveSessions(ctx); err != kvstore.ErrListingUnsupported {
+		t.Errorf("error: got %v, want %v", err, kvstore.ErrListingUnsupported)
+	}
+}
+
+var _ handler.SessionCounter = (*kvstore.Store)(nil)
+
+var _ handler.SessionEnumerator = (*kvstore.Store)(nil)
+
+func TestPurgeExpired(t *testing.T) {
+	ctx := context.Background()
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	store := makeStore(&kvstore.Memory{Clock: clock})
+	store.Clock = clock
+	saveSessionWith(t, store, nil)
+	if n, err := store.PurgeExpired(ctx, clock.Now()); err != nil || n != 0 {
+		t.Errorf("purge: got (%d, %v), want (0, nil)", n, err)
+	}
+	clock.Advance(time.Duration(store.Options.MaxAge) * time.Second)
+	if n, err := store.PurgeExpired(ctx, clock.Now()); err != nil || n != 1 {
+		t.Errorf("purge: got (%d, %v), want (1, nil)", n, err)
+	}
+	if _, err := makeStore(failingKV{}).PurgeExpired(ctx, clock.Now()); err != kvstore.ErrPurgeUnsupported {
+		t.Errorf("error: got %v, want %v", err, kvstore.ErrPurgeUnsupported)
+	}
+}
+
+var _ handler.Purgeable = (*kvstore.Store)(nil)
+
+func TestProjectedLoading(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		serializer  securecookie.Serializer
+	}{
+		{"gob", nil},
+		{"JSON", handler.JSONValuesSerializer{}},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			store := makeStore(kvstore.NewMemory())
+			store.Serializer = test.serializer
+			r := httptest.NewRequest("", "/", nil)
+			session, _ := store.New(r, "s")
+			session.Values["uid"] = "alice"
+			session.Values["cart"] = "large"
+			recorder := httptest.NewRecorder()
+			if err := session.Save(r, recorder); err != nil {
+				t.Fatalf("failed to save session: %v", err)
+			}
+
+			var bound *sessions.Session
+			handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				bound = handler.MustExtractSession(r)
+				if got, want := len(bound.Values), 1; got != want {
+					t.Errorf("values: got %v, want only uid", bound.Values)
+				}
+				bound.Values["uid"] = "bob"
+				bound.Values["visits"] = "1"
+				if err := bound.Save(r, w); err != nil {
+					t.Fatalf("failed to save session: %v", err)
+				}
+			}), nil, handler.Project("uid")).ServeHTTP(httptest.NewRecorder(), requestBearingCookiesFrom(recorder))
+			if bound == nil || bound.IsNew {
+				t.Fatal("stored session was not bound")
+			}
+
+			values, err := store.Values(context.Background(), session.ID)
+			if err != nil {
+				t.Fatalf("failed to read values: %v", err)
+			}
+			for k, want := range map[string]string{"uid": "bob", "cart": "large", "visits": "1"} {
+				if got := values[k]; got != want {
+					t.Errorf("value for %q: got %v, want %v", k, got, want)
+				}
+			}
+		})
+	}
+}
+
+var _ handler.Recycler = (*kvstore.Store)(nil)
+
+func TestRecycle(t *testing.T) {
+	store := makeStore(kvstore.NewMemory())
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	session.Values["k"] = "v"
+	session.Options.MaxAge = 1
+	if err := session.Save(r, httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	store.Recycle(session)
+
+	// The pool may or may not yield the recycled session; either way, it must be fresh.
+	for _, name := range []string{"s", "other"} {
+		fresh, err := store.New(httptest.NewRequest("", "/", nil), name)
+		if err != nil {
+			t.Fatalf("failed to create session: %v", err)
+		}
+		if !fresh.IsNew || len(fresh.ID) != 0 || len(fresh.Values) != 0 || fresh.Name() != name {
+			t.Errorf("session %q is not fresh: %+v", name, fresh)
+		}
+		if got, want := fresh.Options.MaxAge, store.Options.MaxAge; got != want {
+			t.Errorf("session %q max age: got %d, want %d", name, got, want)
+		}
+	}
+}
+
+func BenchmarkFreshSession(b *testing.B) {
+	store := makeStore(kvstore.NewMemory())
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.MustExtractSession(r).Values["visited"] = true
+	})
+	for _, bench := range []struct {
+		name string
+		opts []handler.Option
+	}{
+		{"allocate", nil},
+		{"recycle", []handler.Option{handler.RecycleSessions()}},
+	} {
+		b.Run(bench.name, func(b *testing.B) {
+			wrapped := handler.WithSession("s", store, h, nil, bench.opts...)
+			r := httptest.NewRequest("", "/", nil)
+			w := httptest.NewRecorder()
+			b.ReportAllocs()
+			b.ResetTimer()
+			for i := 0; i < b.N; i++ {
+				wrapped.ServeHTTP(w, r)
+			}
+		})
+	}
+}
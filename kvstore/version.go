@@ -0,0 +1,168 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+// VersionKey is the session value key under which a Store with OnConflict set records the version
+// of the session's stored values, incremented with each save.
+const VersionKey = "kvstore.version"
+
+// ErrConflict is the error that RejectConflicts returns, and that a Store returns when it can't
+// save a session without conflict after several attempts.
+var ErrConflict = errors.New("kvstore: session was saved concurrently")
+
+// CompareAndSwapper is implemented by KVs that can replace a value atomically, only if it's still
+// the value last read, enabling a Store to detect concurrent saves reliably.
+type CompareAndSwapper interface {
+	// CompareAndSwap stores the new value for the given key, expiring after ttl if it's positive,
+	// only if the value stored for the key equals old, or, if old is nil, only if no value is
+	// present. It reports whether it stored the new value.
+	CompareAndSwap(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error)
+}
+
+// RejectConflicts is a function suitable for use as a Store's OnConflict field that refuses to
+// save a session saved concurrently since it was loaded, returning ErrConflict.
+func RejectConflicts(context.Context, *sessions.Session, map[interface{}]interface{}) error {
+	return ErrConflict
+}
+
+// maxSaveAttempts is the number of times a Store with OnConflict set attempts to save a session
+// before giving up with ErrConflict, when other saves keep intervening.
+const maxSaveAttempts = 3
+
+func versionOf(values map[interface{}]interface{}) int64 {
+	switch v := values[VersionKey].(type) {
+	case int64:
+		return v
+	case float64:
+		// Some serializers, such as JSON ones, decode numbers as float64 values.
+		return int64(v)
+	}
+	return 0
+}
+
+// saveVersioned writes the session's values to the KV, calling OnConflict first if the stored
+// version differs from that loaded, and then recording the next version.
+func (s *Store) saveVersioned(ctx context.Context, session *sessions.Session, ttl time.Duration) error {
+	key := s.key(session.ID)
+	// Compare the stored version against that loaded, rather than against the session's version,
+	// which records the version that each attempt tried to save; a concurrent save that foiled an
+	// attempt may have saved that same version.
+	loaded := versionOf(session.Values)
+	prior, hadVersion := session.Values[VersionKey]
+	ref, _ := ctx.Value(baselineKey{}).(*versionedStore)
+	for attempt := 1; ; attempt++ {
+		current, err := s.kv.Get(ctx, key)
+		var stored map[interface{}]interface{}
+		switch err {
+		case nil:
+			if err := s.decode(ctx, current, &stored); err != nil {
+				return err
+			}
+		case ErrNotFound:
+			current = nil
+		default:
+			return err
+		}
+		version := versionOf(stored)
+		if version != loaded {
+			if err := s.OnConflict(ctx, session, stored); err != nil {
+				return err
+			}
+			// The session now reconciles the values saved concurrently, from which any further
+			// concurrent changes diverge.
+			loaded = version
+			if ref != nil {
+				ref.baseline = stored
+			}
+		}
+		session.Values[VersionKey] = version + 1
+		b, err := s.encode(ctx, session, ttl)
+		if err == nil {
+			cas, ok := s.kv.(CompareAndSwapper)
+			if !ok {
+				cas = setter{s.kv}
+			}
+			var swapped bool
+			if swapped, err = cas.CompareAndSwap(ctx, key, current, b, ttl); err == nil && swapped {
+				if ref != nil {
+					ref.baseline = nil
+					return s.decode(ctx, b, &ref.baseline)
+				}
+				return nil
+			}
+		}
+		if hadVersion {
+			session.Values[VersionKey] = prior
+		} else {
+			delete(session.Values, VersionKey)
+		}
+		if err != nil {
+			return err
+		}
+		if attempt == maxSaveAttempts {
+			return ErrConflict
+		}
+	}
+}
+
+// setter adapts a KV lacking CompareAndSwap to serve as a CompareAndSwapper, swapping
+// unconditionally.
+type setter struct {
+	KV
+}
+
+func (s setter) CompareAndSwap(ctx context.Context, key string, _, new []byte, ttl time.Duration) (bool, error) {
+	return true, s.Set(ctx, key, new, ttl)
+}
+
+type baselineKey struct{}
+
+// versionedStore is a sessions.Store that saves sessions loaded by a Store with OnConflict set,
+// retaining the values last loaded or saved as the base from which concurrent changes diverge.
+type versionedStore struct {
+	*Store
+	baseline map[interface{}]interface{}
+}
+
+// CloneStore implements handler.StoreCloner, retaining the same baseline, which saving replaces
+// rather than modifies.
+func (v *versionedStore) CloneStore() sessions.Store {
+	return &versionedStore{Store: v.Store, baseline: v.baseline}
+}
+
+func (v *versionedStore) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	return v.Store.Save(r.WithContext(context.WithValue(r.Context(), baselineKey{}, v)), w, session)
+}
+
+// MergeConflicts returns a function suitable for use as a Store's OnConflict field that reconciles
+// the session's values with those saved concurrently per handler.MergeConcurrent, using the given
+// strategy for keys that both saves changed. For example, handler.KeepUser lets the later save win
+// for each such key, and handler.Additive adds concurrent increments to counters.
+//
+// For sessions created by NewProjected or saved via SaveDetached, the values from which the two
+// saves diverged are unknown, so it merges the concurrently saved values per handler.MergeValues.
+// If the session was deleted concurrently, the session's values prevail.
+func MergeConflicts(strategy handler.MergeStrategy) func(context.Context, *sessions.Session, map[interface{}]interface{}) error {
+	return func(ctx context.Context, session *sessions.Session, stored map[interface{}]interface{}) error {
+		var base map[interface{}]interface{}
+		if v, ok := ctx.Value(baselineKey{}).(*versionedStore); ok {
+			base = v.baseline
+		}
+		if stored == nil {
+			return nil
+		}
+		handler.MergeConcurrent(session.Values, base, stored, strategy)
+		return nil
+	}
+}
@@ -0,0 +1,184 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package kvstore_test
+
+import (
+	"context"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestMemoryCompareAndSwap(t *testing.T) {
+	ctx := context.Background()
+	m := kvstore.NewMemory()
+	if ok, err := m.CompareAndSwap(ctx, "k", nil, []byte("a"), 0); !ok || err != nil {
+		t.Errorf("swap with absent value: got (%t, %v), want (true, nil)", ok, err)
+	}
+	if ok, _ := m.CompareAndSwap(ctx, "k", nil, []byte("b"), 0); ok {
+		t.Error("swap expecting absence succeeded with a value present")
+	}
+	if ok, _ := m.CompareAndSwap(ctx, "k", []byte("x"), []byte("b"), 0); ok {
+		t.Error("swap expecting a different value succeeded")
+	}
+	if ok, _ := m.CompareAndSwap(ctx, "k", []byte("a"), []byte("b"), time.Minute); !ok {
+		t.Error("swap expecting the current value failed")
+	}
+	if v, _ := m.Get(ctx, "k"); string(v) != "b" {
+		t.Errorf("value: got %q, want b", v)
+	}
+}
+
+var _ kvstore.CompareAndSwapper = (*kvstore.Memory)(nil)
+
+func TestOptimisticConcurrency(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		kv          kvstore.KV
+	}{
+		{"atomic", kvstore.NewMemory()},
+		{"non-atomic", struct{ kvstore.KV }{kvstore.NewMemory()}},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			store := makeStore(test.kv)
+			var conflicts []map[interface{}]interface{}
+			store.OnConflict = func(_ context.Context, session *sessions.Session, stored map[interface{}]interface{}) error {
+				conflicts = append(conflicts, stored)
+				if session.Values["mine"] == "reject" {
+					return kvstore.ErrConflict
+				}
+				return nil
+			}
+			r := httptest.NewRequest("", "/", nil)
+			session, _ := store.New(r, "s")
+			recorder := httptest.NewRecorder()
+			if err := session.Save(r, recorder); err != nil {
+				t.Fatalf("failed to save session: %v", err)
+			}
+			if len(conflicts) != 0 {
+				t.Fatalf("first save conflicted: %v", conflicts)
+			}
+
+			first, _ := store.New(requestBearingCookiesFrom(recorder), "s")
+			second, _ := store.New(requestBearingCookiesFrom(recorder), "s")
+			first.Values["theirs"] = "1"
+			if err := first.Save(r, httptest.NewRecorder()); err != nil {
+				t.Fatalf("failed to save session: %v", err)
+			}
+			if len(conflicts) != 0 {
+				t.Fatalf("save without intervening saves conflicted: %v", conflicts)
+			}
+			second.Values["mine"] = "reject"
+			if err := second.Save(r, httptest.NewRecorder()); err != kvstore.ErrConflict {
+				t.Errorf("error from conflicting save: got %v, want %v", err, kvstore.ErrConflict)
+			}
+			if len(conflicts) != 1 || conflicts[0]["theirs"] != "1" {
+				t.Errorf("conflicts: got %v, want one bearing the other save's values", conflicts)
+			}
+			second.Values["mine"] = "accept"
+			if err := second.Save(r, httptest.NewRecorder()); err != nil {
+				t.Errorf("failed to save accepted conflict: %v", err)
+			}
+			values, _ := store.Values(context.Background(), second.ID)
+			if values["mine"] != "accept" || values[kvstore.VersionKey] != int64(3) {
+				t.Errorf("stored values: got %v, want the accepted values at version 3", values)
+			}
+		})
+	}
+}
+
+func TestMergeConflicts(t *testing.T) {
+	store := makeStore(kvstore.NewMemory())
+	store.OnConflict = kvstore.MergeConflicts(handler.Additive)
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	session.Values["visits"] = 1
+	session.Values["theme"] = "light"
+	recorder := httptest.NewRecorder()
+	if err := session.Save(r, recorder); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	first, _ := store.New(requestBearingCookiesFrom(recorder), "s")
+	second, _ := store.New(requestBearingCookiesFrom(recorder), "s")
+	first.Values["visits"] = 2
+	first.Values["theme"] = "dark"
+	if err := first.Save(r, httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	second.Values["visits"] = 2
+	second.Values["cart"] = "book"
+	if err := second.Save(r, httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	values, _ := store.Values(context.Background(), session.ID)
+	for k, want := range map[string]interface{}{"visits": 3, "theme": "dark", "cart": "book"} {
+		if got := values[k]; got != want {
+			t.Errorf("value for %q: got %v, want %v", k, got, want)
+		}
+	}
+
+	// A later save in the same request merges relative to the values it last saved.
+	second.Values["visits"] = 4
+	if err := second.Save(r, httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if values, _ := store.Values(context.Background(), session.ID); values["visits"] != 4 {
+		t.Errorf("visits after resave: got %v, want 4", values["visits"])
+	}
+}
+
+// interceptingKV is a KV that calls intercept, once, just before its first compare-and-swap.
+type interceptingKV struct {
+	*kvstore.Memory
+	intercept func()
+}
+
+func (kv *interceptingKV) CompareAndSwap(ctx context.Context, key string, old, new []byte, ttl time.Duration) (bool, error) {
+	if f := kv.intercept; f != nil {
+		kv.intercept = nil
+		f()
+	}
+	return kv.Memory.CompareAndSwap(ctx, key, old, new, ttl)
+}
+
+func TestOptimisticConcurrencyWithSaveBetweenReadAndSwap(t *testing.T) {
+	kv := &interceptingKV{Memory: kvstore.NewMemory()}
+	store := makeStore(kv)
+	var conflicts []map[interface{}]interface{}
+	store.OnConflict = func(ctx context.Context, session *sessions.Session, stored map[interface{}]interface{}) error {
+		conflicts = append(conflicts, stored)
+		return kvstore.MergeConflicts(handler.KeepUser)(ctx, session, stored)
+	}
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	recorder := httptest.NewRecorder()
+	if err := session.Save(r, recorder); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	first, _ := store.New(requestBearingCookiesFrom(recorder), "s")
+	second, _ := store.New(requestBearingCookiesFrom(recorder), "s")
+	second.Values["theirs"] = "1"
+	kv.intercept = func() {
+		if err := second.Save(r, httptest.NewRecorder()); err != nil {
+			t.Errorf("failed to save intervening session: %v", err)
+		}
+	}
+	first.Values["mine"] = "1"
+	if err := first.Save(r, httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if len(conflicts) != 1 || conflicts[0]["theirs"] != "1" {
+		t.Errorf("conflicts: got %v, want one bearing the intervening save's values", conflicts)
+	}
+	values, _ := store.Values(context.Background(), session.ID)
+	if values["mine"] != "1" || values["theirs"] != "1" || values[kvstore.VersionKey] != int64(3) {
+		t.Errorf("stored values: got %v, want both saves' values at version 3", values)
+	}
+}
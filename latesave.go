@@ -0,0 +1,68 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"log"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// lateSaveGuardStore is a sessions.Store that detects saves of a single session whose cookies can
+// no longer reach the client, since the response header was already written.
+type lateSaveGuardStore struct {
+	sessions.Store
+	report func(r *http.Request, s *sessions.Session) error
+}
+
+func (g *lateSaveGuardStore) unwrapStore() sessions.Store {
+	return g.Store
+}
+
+func (g *lateSaveGuardStore) Save(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	before := len(w.Header()["Set-Cookie"])
+	if err := g.Store.Save(r, w, s); err != nil {
+		return err
+	}
+	hw, ok := r.Context().Value(hookedResponseWriterKey{}).(*hookedResponseWriter)
+	if !ok || !hw.headerSent || len(w.Header()["Set-Cookie"]) == before {
+		return nil
+	}
+	return g.report(r, s)
+}
+
+// GuardLateSaves returns an Option that detects bound sessions saved after the response header
+// was written, such as by a handler that saves a session only after writing its response body,
+// whose cookies therefore never reach the client. Stores that keep values server-side, such as
+// kvstore.Store, retain the values so saved, but a fresh session or one whose ID changed is lost
+// all the same, as is any session kept in its cookie.
+//
+// Saving such a session fails with an error matching ErrHeaderWritten, which it also reports via
+// the supplied logf function—or log.Printf, if nil—and counts as a LateSaveError per CountErrors.
+// Saves that set no cookie, and those made by AutoSave, which saves just before the header is
+// written, are unaffected. To avoid the problem altogether, save sessions before writing the
+// response, or supply the AutoSave Option and let it save them.
+func GuardLateSaves(logf func(format string, v ...interface{})) Option {
+	if logf == nil {
+		logf = log.Printf
+	}
+	return func(c *bindingConfig) {
+		c.guardLateSaves = true
+		c.decorators = append(c.decorators, func(r *http.Request, s *sessions.Session) *sessions.Session {
+			if s.Store() == nil {
+				return s
+			}
+			return rebindSession(s, &lateSaveGuardStore{
+				Store: s.Store(),
+				report: func(r *http.Request, s *sessions.Session) error {
+					err := saveError(s.Name(), ErrHeaderWritten)
+					logf("handler: %v for request to %s; its cookie was not sent", err, r.URL.Path)
+					c.countError(s.Name(), err)
+					return err
+				},
+			})
+		})
+	}
+}
@@ -0,0 +1,114 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestGuardLateSaves(t *testing.T) {
+	tests := []struct {
+		description string
+		autoSave    bool
+		serve       func(w http.ResponseWriter, r *http.Request) error
+		wantErr     bool
+		wantCookie  bool
+	}{
+		{
+			"save before writing",
+			false,
+			func(w http.ResponseWriter, r *http.Request) error {
+				s := handler.MustExtractSession(r)
+				s.Values["k"] = "v"
+				err := s.Save(r, w)
+				io.WriteString(w, "body")
+				return err
+			},
+			false, true,
+		},
+		{
+			"save after writing",
+			false,
+			func(w http.ResponseWriter, r *http.Request) error {
+				io.WriteString(w, "body")
+				s := handler.MustExtractSession(r)
+				s.Values["k"] = "v"
+				return s.Save(r, w)
+			},
+			true, false,
+		},
+		{
+			"auto-save after informational response",
+			true,
+			func(w http.ResponseWriter, r *http.Request) error {
+				w.WriteHeader(http.StatusEarlyHints)
+				handler.MustExtractSession(r).Values["k"] = "v"
+				io.WriteString(w, "body")
+				return nil
+			},
+			false, true,
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			var logged []string
+			logf := func(format string, v ...interface{}) {
+				logged = append(logged, fmt.Sprintf(format, v...))
+			}
+			counts := make(map[handler.ErrorCategory]int64)
+			opts := []handler.Option{
+				handler.GuardLateSaves(logf),
+				handler.CountErrors(func(category handler.ErrorCategory, _ string) handler.Counter {
+					return handler.CounterFunc(func(delta int64) { counts[category] += delta })
+				}),
+			}
+			if test.autoSave {
+				opts = append(opts, handler.AutoSave(nil))
+			}
+			store := kvstore.New(kvstore.NewMemory(), securecookie.GenerateRandomKey(32))
+			var err error
+			done := make(chan struct{})
+			h := handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				err = test.serve(w, r)
+			}), nil, opts...)
+			// Serve over a connection, as httptest.ResponseRecorder doesn't support informational
+			// responses.
+			server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				defer close(done)
+				h.ServeHTTP(w, r)
+			}))
+			defer server.Close()
+			res, getErr := http.Get(server.URL)
+			if getErr != nil {
+				t.Fatal(getErr)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			<-done
+
+			if got := errors.Is(err, handler.ErrHeaderWritten); got != test.wantErr {
+				t.Errorf("save error: got %v, want late save: %t", err, test.wantErr)
+			}
+			if got, want := len(logged), 0; test.wantErr {
+				if len(logged) != 1 || counts[handler.LateSaveError] != 1 {
+					t.Errorf("reports: got %v logged and %v counted, want one each", logged, counts)
+				}
+			} else if got != want || len(counts) != 0 {
+				t.Errorf("reports: got %v logged and %v counted, want none", logged, counts)
+			}
+			if got := len(res.Cookies()) != 0; got != test.wantCookie {
+				t.Errorf("cookie sent: got %t, want %t", got, test.wantCookie)
+			}
+		})
+	}
+}
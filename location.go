@@ -0,0 +1,87 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"net"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// LastLocationKey is the session value key under which the DetectLocationChange Option records
+// the location from which the client last made a request.
+const LastLocationKey = "handler.last_location"
+
+// LocationResolver maps a request to a label for the location from which the client made it, such
+// as its IP address, network, or country. It returns an empty string if it can't determine the
+// location. The coarser the label, the more drastic a change between requests must be to count as
+// anomalous.
+type LocationResolver func(r *http.Request) string
+
+func remoteIP(r *http.Request) net.IP {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		host = r.RemoteAddr
+	}
+	return net.ParseIP(host)
+}
+
+// RemoteIP is a LocationResolver that labels a request's location with the IP address of the
+// peer that sent it, per the request's RemoteAddr field. Behind a proxy, that's the proxy's address,
+// unless other middleware rewrites RemoteAddr from forwarding headers.
+func RemoteIP(r *http.Request) string {
+	ip := remoteIP(r)
+	if ip == nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// RemoteNetwork returns a LocationResolver that labels a request's location with the network
+// containing the peer's IP address, per the request's RemoteAddr field, using the given prefix
+// lengths for IPv4 and IPv6 addresses. For example, prefix lengths of 16 and 48 tolerate a client
+// moving among addresses assigned by the same provider, while noticing moves between providers.
+func RemoteNetwork(ipv4Bits, ipv6Bits int) LocationResolver {
+	return func(r *http.Request) string {
+		ip := remoteIP(r)
+		if ip == nil {
+			return ""
+		}
+		if v4 := ip.To4(); v4 != nil {
+			return (&net.IPNet{IP: v4.Mask(net.CIDRMask(ipv4Bits, 32)), Mask: net.CIDRMask(ipv4Bits, 32)}).String()
+		}
+		return (&net.IPNet{IP: ip.Mask(net.CIDRMask(ipv6Bits, 128)), Mask: net.CIDRMask(ipv6Bits, 128)}).String()
+	}
+}
+
+// DetectLocationChange returns an Option that records the location from which the client made
+// each request in the bound session under LastLocationKey, per the given LocationResolver, and
+// calls onAnomaly when that location differs from the one recorded by an earlier request. The
+// onAnomaly function receives the session before its recorded location is updated, and may respond
+// by, for example, clearing the session's principal to demand that the user authenticate again, or
+// by raising an alert. Requests whose location the resolver can't determine are ignored.
+//
+// The recorded location persists only if the session is saved. It panics if either the resolver or
+// onAnomaly function is nil.
+func DetectLocationChange(resolve LocationResolver, onAnomaly func(r *http.Request, s *sessions.Session, previous, current string)) Option {
+	if resolve == nil {
+		panic("no location resolver supplied")
+	}
+	if onAnomaly == nil {
+		panic("no anomaly handler supplied")
+	}
+	return func(c *bindingConfig) {
+		c.preparers = append(c.preparers, func(r *http.Request, s *sessions.Session) {
+			current := resolve(r)
+			if len(current) == 0 || s.Values == nil {
+				return
+			}
+			if previous, ok := s.Values[LastLocationKey].(string); ok && previous != current {
+				onAnomaly(r, s, previous, current)
+			}
+			s.Values[LastLocationKey] = current
+		})
+	}
+}
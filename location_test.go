@@ -0,0 +1,86 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestLocationResolvers(t *testing.T) {
+	tests := []struct {
+		remoteAddr string
+		ip         string
+		network    string
+	}{
+		{"192.0.2.77:1234", "192.0.2.77", "192.0.0.0/16"},
+		{"[2001:db8:1:2::7]:1234", "2001:db8:1:2::7", "2001:db8:1::/48"},
+		{"garbage", "", ""},
+	}
+	network := handler.RemoteNetwork(16, 48)
+	for _, test := range tests {
+		r := httptest.NewRequest("", "/", nil)
+		r.RemoteAddr = test.remoteAddr
+		if got := handler.RemoteIP(r); got != test.ip {
+			t.Errorf("IP for %q: got %q, want %q", test.remoteAddr, got, test.ip)
+		}
+		if got := network(r); got != test.network {
+			t.Errorf("network for %q: got %q, want %q", test.remoteAddr, got, test.network)
+		}
+	}
+}
+
+// valuesSource supplies sessions bearing copies of the given values, as if loaded from a store.
+type valuesSource map[interface{}]interface{}
+
+func (v valuesSource) New(r *http.Request, name string) (*sessions.Session, error) {
+	s, _ := simpleStore{}.New(r, name)
+	s.IsNew = false
+	for k, val := range v {
+		s.Values[k] = val
+	}
+	return s, nil
+}
+
+func TestDetectLocationChange(t *testing.T) {
+	tests := []struct {
+		description string
+		previous    interface{}
+		remoteAddr  string
+		anomaly     bool
+	}{
+		{"first", nil, "192.0.2.1:1", false},
+		{"same", "192.0.0.0/16", "192.0.2.1:1", false},
+		{"changed", "198.51.0.0/16", "192.0.2.1:1", true},
+		{"unknown", "198.51.0.0/16", "garbage", false},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			values := valuesSource{}
+			if test.previous != nil {
+				values[handler.LastLocationKey] = test.previous
+			}
+			anomaly := false
+			r := httptest.NewRequest("", "/", nil)
+			r.RemoteAddr = test.remoteAddr
+			handler.WithSession("s", values, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got, want := handler.MustExtractSession(r).Values[handler.LastLocationKey], "192.0.0.0/16"; test.remoteAddr != "garbage" && got != want {
+					t.Errorf("recorded location: got %v, want %v", got, want)
+				}
+			}), nil, handler.DetectLocationChange(handler.RemoteNetwork(16, 48), func(r *http.Request, s *sessions.Session, previous, current string) {
+				anomaly = true
+				if previous != test.previous || current != "192.0.0.0/16" {
+					t.Errorf("anomaly locations: got (%q, %q)", previous, current)
+				}
+			})).ServeHTTP(httptest.NewRecorder(), r)
+			if anomaly != test.anomaly {
+				t.Errorf("anomaly: got %t, want %t", anomaly, test.anomaly)
+			}
+		})
+	}
+}
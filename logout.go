@@ -0,0 +1,111 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// LoggedOutAtKey is the session value key under which Logout records when a soft logout took
+// place, in seconds since the Unix epoch.
+const LoggedOutAtKey = "handler.logged_out_at"
+
+// authenticationKeys lists the keys of the session values that record authentication, which a
+// soft logout removes.
+var authenticationKeys = []string{
+	PrincipalKey,
+	PendingMFAKey,
+	pendingMFAExpiresKey,
+	MFACompletedAtKey,
+	mfaCompletedPrincipalKey,
+	PendingEnrollmentKey,
+	pendingEnrollmentExpiresKey,
+	StartedAtKey,
+	TouchedAtKey,
+	ProfilesKey,
+	ActiveProfileKey,
+}
+
+// LogoutScope determines how much of a session Logout discards.
+type LogoutScope int
+
+const (
+	// SoftLogout removes the values recording authentication, such as the principal and the
+	// state of multi-factor authentication, preserving the rest, such as preferences or the
+	// contents of a shopping cart, and records that the logout took place.
+	SoftLogout LogoutScope = iota
+	// HardLogout removes all of the session's values and marks the session for deletion, so that
+	// saving it deletes its cookie and any values its store holds.
+	HardLogout
+)
+
+// Logout logs the session out, to the extent the scope demands. Either way, it discards the
+// session's ID, for stores that issue one, so that saving the session issues a fresh one, keeping
+// an ID captured beforehand from being of any use afterward. The caller must save the session for
+// the logout to take effect. If the Clock is nil, it uses SystemClock.
+func Logout(s *sessions.Session, scope LogoutScope, clock Clock) {
+	s.ID = ""
+	if scope == HardLogout {
+		for k := range s.Values {
+			delete(s.Values, k)
+		}
+		options := sessions.Options{}
+		if s.Options != nil {
+			options = *s.Options
+		}
+		options.MaxAge = -1
+		s.Options = &options
+		return
+	}
+	for _, k := range authenticationKeys {
+		delete(s.Values, k)
+	}
+	s.Values[LoggedOutAtKey] = clockOrSystem(clock).Now().Unix()
+}
+
+// LoggedOut reports whether the session was logged out via a soft logout and has yet to be
+// associated with a principal again via SetPrincipal.
+func LoggedOut(s *sessions.Session) bool {
+	_, ok := s.Values[LoggedOutAtKey]
+	return ok
+}
+
+// RequireAuthenticated returns an HTTP handler that delegates requests whose singular session bound
+// via WithSession has a principal, per SessionPrincipal, to the supplied handler. It delegates
+// requests whose sessions were logged out via a soft logout to the onLoggedOut handler, which might
+// offer to log the user back in while mentioning the preferences the session retains, and all other
+// requests to the onUnauthenticated handler. If no such onUnauthenticated handler is supplied, it
+// will respond with HTTP status code 401 with no body. If no such onLoggedOut handler is supplied,
+// it delegates those requests to the onUnauthenticated handler. It panics if the supplied handler is
+// nil.
+func RequireAuthenticated(h http.Handler, onUnauthenticated, onLoggedOut http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	if onUnauthenticated == nil {
+		onUnauthenticated = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	}
+	if onLoggedOut == nil {
+		onLoggedOut = onUnauthenticated
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := ExtractSession(r)
+		switch {
+		case !ok:
+			onUnauthenticated.ServeHTTP(w, r)
+		case LoggedOut(s):
+			onLoggedOut.ServeHTTP(w, r)
+		default:
+			if _, ok := SessionPrincipal(s); !ok {
+				onUnauthenticated.ServeHTTP(w, r)
+				return
+			}
+			h.ServeHTTP(w, r)
+		}
+	})
+}
@@ -0,0 +1,84 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestLogout(t *testing.T) {
+	newSession := func() *sessions.Session {
+		s := sessions.NewSession(simpleStore{}, "s")
+		s.ID = "id"
+		s.Options = &sessions.Options{MaxAge: 3600}
+		handler.StorePendingMFA(s, "alice", time.Minute, nil)
+		handler.CompleteMFA(s, nil)
+		s.Values["cart"] = "book"
+		return s
+	}
+
+	s := newSession()
+	handler.Logout(s, handler.SoftLogout, nil)
+	if _, ok := handler.SessionPrincipal(s); ok {
+		t.Error("soft logout retained principal")
+	}
+	if handler.MFACompleted(s) {
+		t.Error("soft logout retained completion of multi-factor authentication")
+	}
+	if s.Values["cart"] != "book" {
+		t.Error("soft logout discarded other values")
+	}
+	if !handler.LoggedOut(s) || len(s.ID) != 0 {
+		t.Errorf("soft logout: got logged out %t with ID %q, want logged out with no ID", handler.LoggedOut(s), s.ID)
+	}
+	handler.SetPrincipal(s, "alice")
+	if handler.LoggedOut(s) {
+		t.Error("session remains logged out after setting principal")
+	}
+
+	s = newSession()
+	handler.Logout(s, handler.HardLogout, nil)
+	if len(s.Values) != 0 || s.Options.MaxAge >= 0 || len(s.ID) != 0 {
+		t.Errorf("hard logout: got values %v, max age %d, and ID %q, want none, negative, and none", s.Values, s.Options.MaxAge, s.ID)
+	}
+}
+
+func TestRequireAuthenticatedPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.RequireAuthenticated(nil, nil, nil)
+}
+
+func TestRequireAuthenticated(t *testing.T) {
+	respond := func(code int) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(code)
+		})
+	}
+	for _, test := range []struct {
+		description string
+		source      handler.SessionSource
+		onLoggedOut http.Handler
+		want        int
+	}{
+		{"authenticated", valuesSource{handler.PrincipalKey: "alice"}, nil, http.StatusNoContent},
+		{"anonymous", simpleStore{}, respond(http.StatusGone), http.StatusUnauthorized},
+		{"logged out", valuesSource{handler.LoggedOutAtKey: int64(0)}, respond(http.StatusGone), http.StatusGone},
+		{"logged out with no handler", valuesSource{handler.LoggedOutAtKey: int64(0)}, nil, http.StatusUnauthorized},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			h := handler.WithSession("s", test.source, handler.RequireAuthenticated(respond(http.StatusNoContent), nil, test.onLoggedOut), nil)
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+			if got, want := recorder.Code, test.want; got != want {
+				t.Errorf("status code: got %d, want %d", got, want)
+			}
+		})
+	}
+}
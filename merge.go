@@ -0,0 +1,225 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"reflect"
+
+	"github.com/gorilla/sessions"
+)
+
+// MergeStrategy reconciles conflicting session values, such as when a guest who has accumulated
+// session values logs in as a user whose session holds values of its own.
+type MergeStrategy interface {
+	// Merge resolves the conflict between the guest's and user's values for the given key,
+	// returning the value to retain.
+	Merge(key, guest, user interface{}) interface{}
+}
+
+// MergeFunc adapts an ordinary function to serve as a MergeStrategy.
+type MergeFunc func(key, guest, user interface{}) interface{}
+
+// Merge calls f(key, guest, user).
+func (f MergeFunc) Merge(key, guest, user interface{}) interface{} {
+	return f(key, guest, user)
+}
+
+var (
+	// KeepUser is a MergeStrategy that retains the user's value.
+	KeepUser MergeStrategy = MergeFunc(func(_, _, user interface{}) interface{} { return user })
+	// KeepGuest is a MergeStrategy that retains the guest's value.
+	KeepGuest MergeStrategy = MergeFunc(func(_, guest, _ interface{}) interface{} { return guest })
+	// Combine is a MergeStrategy that concatenates slices of the same type, user's elements first,
+	// and unites maps of the same type, preferring the user's entries where keys collide. For
+	// values of any other kind, it retains the user's value.
+	Combine MergeStrategy = MergeFunc(combine)
+)
+
+func combine(_, guest, user interface{}) interface{} {
+	g, u := reflect.ValueOf(guest), reflect.ValueOf(user)
+	if !g.IsValid() || !u.IsValid() || g.Type() != u.Type() {
+		return user
+	}
+	switch u.Kind() {
+	case reflect.Slice:
+		c := reflect.MakeSlice(u.Type(), 0, u.Len()+g.Len())
+		return reflect.AppendSlice(reflect.AppendSlice(c, u), g).Interface()
+	case reflect.Map:
+		c := reflect.MakeMapWithSize(u.Type(), u.Len()+g.Len())
+		for _, m := range []reflect.Value{g, u} {
+			for _, k := range m.MapKeys() {
+				c.SetMapIndex(k, m.MapIndex(k))
+			}
+		}
+		return c.Interface()
+	default:
+		return user
+	}
+}
+
+// PerKey returns a MergeStrategy that applies the strategy given for each key in overrides, and
+// the fallback strategy for all other keys.
+func PerKey(fallback MergeStrategy, overrides map[interface{}]MergeStrategy) MergeStrategy {
+	return MergeFunc(func(key, guest, user interface{}) interface{} {
+		if s, ok := overrides[key]; ok {
+			return s.Merge(key, guest, user)
+		}
+		return fallback.Merge(key, guest, user)
+	})
+}
+
+// MergeValues merges the guest's values into the user's values in place, consulting the given
+// strategy only for keys present in both.
+func MergeValues(user, guest map[interface{}]interface{}, strategy MergeStrategy) {
+	for k, gv := range guest {
+		if uv, ok := user[k]; ok {
+			user[k] = strategy.Merge(k, gv, uv)
+		} else {
+			user[k] = gv
+		}
+	}
+}
+
+// PromoteGuest merges the values of the guest session into those of the user session per
+// MergeValues, and then empties the guest session and marks it for deletion, so that saving it
+// removes it from its store and instructs the client to discard its cookie. Save both sessions
+// afterward.
+//
+// If the two sessions bear the same name, save only the user session; it supersedes the guest
+// session.
+func PromoteGuest(user, guest *sessions.Session, strategy MergeStrategy) {
+	MergeValues(user.Values, guest.Values, strategy)
+	guest.Values = make(map[interface{}]interface{})
+	if guest.Options == nil {
+		guest.Options = &sessions.Options{}
+	} else {
+		opts := *guest.Options
+		guest.Options = &opts
+	}
+	guest.Options.MaxAge = -1
+}
+
+// BaseMergeStrategy is implemented by MergeStrategies that can make use of the value from which
+// two conflicting values diverged, such as when two requests change the same session concurrently.
+type BaseMergeStrategy interface {
+	MergeStrategy
+	// MergeBase resolves the conflict between the guest's and user's values for the given key,
+	// both derived from the base value, returning the value to retain.
+	MergeBase(key, base, guest, user interface{}) interface{}
+}
+
+// Additive is a MergeStrategy that adds numbers of the same type, treating them as counters, and
+// otherwise behaves like Combine. As a BaseMergeStrategy, it adds each side's change from the base
+// value to numbers, and applies the guest's additions and removals relative to the base value to
+// the user's maps, treating them as sets.
+var Additive BaseMergeStrategy = additive{}
+
+type additive struct{}
+
+func (additive) Merge(key, guest, user interface{}) interface{} {
+	g, u := reflect.ValueOf(guest), reflect.ValueOf(user)
+	if g.IsValid() && u.IsValid() && g.Type() == u.Type() {
+		if sum, ok := addNumbers(u, g, 1); ok {
+			return sum
+		}
+	}
+	return combine(key, guest, user)
+}
+
+func (a additive) MergeBase(key, base, guest, user interface{}) interface{} {
+	b, g, u := reflect.ValueOf(base), reflect.ValueOf(guest), reflect.ValueOf(user)
+	if !b.IsValid() || !g.IsValid() || !u.IsValid() || b.Type() != u.Type() || g.Type() != u.Type() {
+		return a.Merge(key, guest, user)
+	}
+	// user + (guest - base)
+	if sum, ok := addNumbers(u, g, 1); ok {
+		v, _ := addNumbers(reflect.ValueOf(sum), b, -1)
+		return v
+	}
+	if u.Kind() != reflect.Map {
+		return user
+	}
+	c := reflect.MakeMapWithSize(u.Type(), u.Len())
+	for _, k := range u.MapKeys() {
+		c.SetMapIndex(k, u.MapIndex(k))
+	}
+	for _, k := range g.MapKeys() {
+		if !b.MapIndex(k).IsValid() {
+			c.SetMapIndex(k, g.MapIndex(k))
+		}
+	}
+	for _, k := range b.MapKeys() {
+		if !g.MapIndex(k).IsValid() {
+			c.SetMapIndex(k, reflect.Value{})
+		}
+	}
+	return c.Interface()
+}
+
+// addNumbers returns x + sign*y, if x and y are numbers of the same type.
+func addNumbers(x, y reflect.Value, sign int64) (interface{}, bool) {
+	r := reflect.New(x.Type()).Elem()
+	switch x.Kind() {
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		r.SetInt(x.Int() + sign*y.Int())
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64:
+		if sign < 0 {
+			r.SetUint(x.Uint() - y.Uint())
+		} else {
+			r.SetUint(x.Uint() + y.Uint())
+		}
+	case reflect.Float32, reflect.Float64:
+		r.SetFloat(x.Float() + float64(sign)*y.Float())
+	default:
+		return nil, false
+	}
+	return r.Interface(), true
+}
+
+// MergeConcurrent reconciles the user's values in place with the guest's values, where both
+// derive concurrently from the base values, such as when two requests change the same session. It
+// retains each change that only one side made, including removals, and consults the given
+// strategy for keys that both sides changed differently. If the strategy is a BaseMergeStrategy, it
+// consults its MergeBase method instead, even when both sides made the same change, so that
+// counters incremented by both sides add up. If the base values are nil, it defers to MergeValues.
+func MergeConcurrent(user, base, guest map[interface{}]interface{}, strategy MergeStrategy) {
+	if base == nil {
+		MergeValues(user, guest, strategy)
+		return
+	}
+	keys := make(map[interface{}]struct{}, len(user)+len(guest))
+	for _, m := range []map[interface{}]interface{}{base, guest, user} {
+		for k := range m {
+			keys[k] = struct{}{}
+		}
+	}
+	bs, hasBase := strategy.(BaseMergeStrategy)
+	for k := range keys {
+		bv, inBase := base[k]
+		gv, inGuest := guest[k]
+		uv, inUser := user[k]
+		guestChanged := inGuest != inBase || !reflect.DeepEqual(gv, bv)
+		userChanged := inUser != inBase || !reflect.DeepEqual(uv, bv)
+		switch {
+		case !guestChanged:
+		case !userChanged, !hasBase && inGuest == inUser && reflect.DeepEqual(gv, uv):
+			if inGuest {
+				user[k] = gv
+			} else {
+				delete(user, k)
+			}
+		case !inGuest || !inUser:
+			// One side removed the value while the other changed it; the change prevails.
+			if inGuest {
+				user[k] = gv
+			}
+		default:
+			if hasBase && inBase {
+				user[k] = bs.MergeBase(k, bv, gv, uv)
+			} else {
+				user[k] = strategy.Merge(k, gv, uv)
+			}
+		}
+	}
+}
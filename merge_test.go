@@ -0,0 +1,108 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestMergeStrategies(t *testing.T) {
+	tests := []struct {
+		description string
+		strategy    handler.MergeStrategy
+		guest, user interface{}
+		want        interface{}
+	}{
+		{"keep user", handler.KeepUser, 1, 2, 2},
+		{"keep guest", handler.KeepGuest, 1, 2, 1},
+		{"combine slices", handler.Combine, []string{"b"}, []string{"a"}, []string{"a", "b"}},
+		{"combine maps", handler.Combine, map[string]int{"a": 1, "b": 1}, map[string]int{"a": 2}, map[string]int{"a": 2, "b": 1}},
+		{"combine mismatched types", handler.Combine, []int{1}, []string{"a"}, []string{"a"}},
+		{"combine scalars", handler.Combine, 1, 2, 2},
+		{"combine nil", handler.Combine, nil, 2, 2},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if got := test.strategy.Merge("k", test.guest, test.user); !reflect.DeepEqual(got, test.want) {
+				t.Errorf("merged value: got %v, want %v", got, test.want)
+			}
+		})
+	}
+}
+
+func TestPromoteGuest(t *testing.T) {
+	user := sessions.NewSession(simpleStore{}, "user")
+	user.Values["theme"] = "dark"
+	user.Values["cart"] = []string{"book"}
+	guest := sessions.NewSession(simpleStore{}, "guest")
+	opts := &sessions.Options{Path: "/", MaxAge: 60}
+	guest.Options = opts
+	guest.Values["theme"] = "light"
+	guest.Values["cart"] = []string{"pen"}
+	guest.Values["locale"] = "fr"
+
+	handler.PromoteGuest(user, guest, handler.PerKey(handler.KeepUser, map[interface{}]handler.MergeStrategy{
+		"cart": handler.Combine,
+	}))
+	want := map[interface{}]interface{}{
+		"theme":  "dark",
+		"cart":   []string{"book", "pen"},
+		"locale": "fr",
+	}
+	if !reflect.DeepEqual(user.Values, want) {
+		t.Errorf("user values: got %v, want %v", user.Values, want)
+	}
+	if len(guest.Values) != 0 {
+		t.Errorf("guest values: got %v, want none", guest.Values)
+	}
+	if guest.Options.MaxAge >= 0 {
+		t.Errorf("guest MaxAge: got %d, want a negative value", guest.Options.MaxAge)
+	}
+	if opts.MaxAge != 60 {
+		t.Error("promotion mutated the guest's original options")
+	}
+}
+
+func TestAdditive(t *testing.T) {
+	if got := handler.Additive.Merge("n", 2, 3); got != 5 {
+		t.Errorf("two-way sum: got %v, want 5", got)
+	}
+	if got := handler.Additive.MergeBase("n", 10, 12, 13); got != 15 {
+		t.Errorf("three-way sum: got %v, want 15", got)
+	}
+	if got := handler.Additive.MergeBase("n", uint(10), uint(12), uint(13)); got != uint(15) {
+		t.Errorf("three-way unsigned sum: got %v, want 15", got)
+	}
+	base := map[string]bool{"a": true, "b": true}
+	guest := map[string]bool{"a": true, "c": true}
+	user := map[string]bool{"a": true, "b": true, "d": true}
+	want := map[string]bool{"a": true, "c": true, "d": true}
+	if got := handler.Additive.MergeBase("set", base, guest, user); !reflect.DeepEqual(got, want) {
+		t.Errorf("three-way set: got %v, want %v", got, want)
+	}
+	if got := handler.Additive.MergeBase("s", "a", "b", "c"); got != "c" {
+		t.Errorf("three-way string: got %v, want c", got)
+	}
+}
+
+func TestMergeConcurrent(t *testing.T) {
+	base := map[interface{}]interface{}{"theirs": 1, "mine": 1, "both": 1, "gone": 1, "count": 5}
+	guest := map[interface{}]interface{}{"theirs": 2, "mine": 1, "both": 2, "count": 6, "added": true}
+	user := map[interface{}]interface{}{"theirs": 1, "mine": 2, "both": 3, "gone": 1, "count": 7}
+	handler.MergeConcurrent(user, base, guest, handler.Additive)
+	want := map[interface{}]interface{}{"theirs": 2, "mine": 2, "both": 4, "count": 8, "added": true}
+	if !reflect.DeepEqual(user, want) {
+		t.Errorf("merged: got %v, want %v", user, want)
+	}
+
+	user = map[interface{}]interface{}{"both": 3}
+	handler.MergeConcurrent(user, nil, map[interface{}]interface{}{"both": 2, "other": 1}, handler.KeepUser)
+	if want := (map[interface{}]interface{}{"both": 3, "other": 1}); !reflect.DeepEqual(user, want) {
+		t.Errorf("merged without base: got %v, want %v", user, want)
+	}
+}
@@ -0,0 +1,116 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// Gauge records the latest value of a measurement that can rise and fall. *expvar.Int satisfies
+// Gauge; other metrics libraries' gauges can be adapted with GaugeFunc.
+type Gauge interface {
+	Set(value int64)
+}
+
+// GaugeFunc adapts an ordinary function to serve as a Gauge.
+type GaugeFunc func(value int64)
+
+// Set calls f(value).
+func (f GaugeFunc) Set(value int64) {
+	f(value)
+}
+
+// Counter records a running total of occurrences. *expvar.Int satisfies Counter; other metrics
+// libraries' counters can be adapted with CounterFunc.
+type Counter interface {
+	Add(delta int64)
+}
+
+// CounterFunc adapts an ordinary function to serve as a Counter.
+type CounterFunc func(delta int64)
+
+// Add calls f(delta).
+func (f CounterFunc) Add(delta int64) {
+	f(delta)
+}
+
+// SessionCounter is implemented by session stores that can count the sessions they hold.
+type SessionCounter interface {
+	// ActiveSessions returns the number of unexpired sessions the store holds.
+	ActiveSessions(ctx context.Context) (int, error)
+}
+
+// ReportActiveSessions sets the given Gauge to the number of sessions that the SessionCounter
+// holds, once immediately and then at the given interval until the supplied context is done. It
+// reports any errors encountered to the onError function, if supplied, leaving the Gauge's value
+// intact after such a failure.
+func ReportActiveSessions(ctx context.Context, c SessionCounter, g Gauge, interval time.Duration, onError func(error)) {
+	report := func() {
+		n, err := c.ActiveSessions(ctx)
+		if err != nil {
+			if onError != nil {
+				onError(err)
+			}
+			return
+		}
+		g.Set(int64(n))
+	}
+	report()
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			report()
+		}
+	}
+}
+
+// ErrorCounters supplies the Counter for errors of the given category arising from sessions with
+// the given name, or nil to leave such errors uncounted.
+type ErrorCounters func(category ErrorCategory, name string) Counter
+
+func (f ErrorCounters) count(name string, err error) {
+	if c := f(CategorizeError(err), name); c != nil {
+		c.Add(1)
+	}
+}
+
+// CountErrors returns an Option that adds one to the Counter that the ErrorCounters supplies for
+// each error arising from acquiring a bound session, including the decoding errors that WithSession
+// and the like otherwise treat as the absence of a session cookie, and from saving or refreshing
+// one via AutoSave or RefreshNearExpiry, categorized per CategorizeError. Counting by category
+// distinguishes clients bearing stale cookies from a session store's backend being down.
+func CountErrors(counters ErrorCounters) Option {
+	return func(c *bindingConfig) {
+		c.errorCounters = counters
+	}
+}
+
+// countError counts the error that arose from the named session, if counting errors.
+func (c *bindingConfig) countError(name string, err error) {
+	if c.errorCounters != nil {
+		c.errorCounters.count(name, err)
+	}
+}
+
+// errorCountingSource is a SessionSource that counts the errors arising from acquiring sessions.
+type errorCountingSource struct {
+	SessionSource
+	counters ErrorCounters
+}
+
+func (s errorCountingSource) New(r *http.Request, name string) (*sessions.Session, error) {
+	session, err := s.SessionSource.New(r, name)
+	if err != nil && err != http.ErrNoCookie {
+		s.counters.count(name, err)
+	}
+	return session, err
+}
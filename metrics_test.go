@@ -0,0 +1,137 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+type sessionCounterFunc func(ctx context.Context) (int, error)
+
+func (f sessionCounterFunc) ActiveSessions(ctx context.Context) (int, error) {
+	return f(ctx)
+}
+
+var _ handler.Gauge = (*expvar.Int)(nil)
+
+func TestReportActiveSessions(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	expectedError := errors.New("")
+	calls := 0
+	counter := sessionCounterFunc(func(context.Context) (int, error) {
+		calls++
+		switch calls {
+		case 1:
+			return 3, nil
+		case 2:
+			return 0, expectedError
+		default:
+			cancel()
+			return 5, nil
+		}
+	})
+	var values []int64
+	var errs []error
+	handler.ReportActiveSessions(ctx, counter, handler.GaugeFunc(func(v int64) {
+		values = append(values, v)
+	}), time.Millisecond, func(err error) {
+		errs = append(errs, err)
+	})
+	if len(values) < 2 || values[0] != 3 || values[1] != 5 {
+		t.Errorf("gauge values: got %v, want to start with [3 5]", values)
+	}
+	if len(errs) != 1 || errs[0] != expectedError {
+		t.Errorf("errors: got %v, want [%v]", errs, expectedError)
+	}
+}
+
+// failingSaveStore is a sessions.Store that supplies fresh sessions, but fails to save them.
+type failingSaveStore struct {
+	err error
+}
+
+func (s failingSaveStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return s.New(r, name)
+}
+
+func (s failingSaveStore) New(_ *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.IsNew = true
+	return session, nil
+}
+
+func (s failingSaveStore) Save(*http.Request, http.ResponseWriter, *sessions.Session) error {
+	return s.err
+}
+
+func TestCategorizeError(t *testing.T) {
+	for _, test := range []struct {
+		err  error
+		want handler.ErrorCategory
+	}{
+		{errors.New(""), handler.OtherError},
+		{fakeSecureCookieError(true), handler.DecodeError},
+		{fmt.Errorf("connecting: %w", context.DeadlineExceeded), handler.TimeoutError},
+		{fmt.Errorf("%w: primary down", handler.ErrSourceUnavailable), handler.UnavailableError},
+	} {
+		if got := handler.CategorizeError(test.err); got != test.want {
+			t.Errorf("CategorizeError(%v): got %v, want %v", test.err, got, test.want)
+		}
+	}
+	if got, want := handler.UnavailableError.String(), "backend-unavailable"; got != want {
+		t.Errorf("category name: got %q, want %q", got, want)
+	}
+}
+
+func TestCountErrors(t *testing.T) {
+	counts := make(map[string]int64)
+	counters := handler.CountErrors(func(category handler.ErrorCategory, name string) handler.Counter {
+		return handler.CounterFunc(func(delta int64) {
+			counts[category.String()+"/"+name] += delta
+		})
+	})
+	serve := func(name string, s handler.SessionSource, opts ...handler.Option) {
+		h := handler.WithSession(name, s, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			s := handler.MustExtractSession(r)
+			if s.Values == nil {
+				s.Values = make(map[interface{}]interface{})
+			}
+			s.Values["k"] = "v"
+			w.WriteHeader(http.StatusNoContent)
+		}), func(w http.ResponseWriter, _ *http.Request, _ error) {
+			w.WriteHeader(http.StatusServiceUnavailable)
+		}, append(opts, counters)...)
+		h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+	}
+	serve("a", failingSessionSource{fakeSecureCookieError(true)})
+	serve("a", failingSessionSource{fakeSecureCookieError(true)})
+	serve("b", failingSessionSource{fmt.Errorf("%w: primary down", handler.ErrSourceUnavailable)})
+	serve("c", failingSaveStore{errors.New("disk full")}, handler.AutoSave(nil))
+	serve("d", simpleStore{}, handler.AutoSave(nil))
+
+	want := map[string]int64{
+		"decode/a":              2,
+		"backend-unavailable/b": 1,
+		"save-failure/c":        1,
+	}
+	if len(counts) != len(want) {
+		t.Errorf("counts: got %v, want %v", counts, want)
+	}
+	for k, v := range want {
+		if got := counts[k]; got != v {
+			t.Errorf("count of %s: got %d, want %d", k, got, v)
+		}
+	}
+}
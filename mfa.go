@@ -0,0 +1,158 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// Session value keys under which the multi-factor authentication helpers record their state.
+const (
+	// PendingMFAKey is the session value key under which StorePendingMFA records the principal
+	// that passed the first authentication factor but has yet to pass the second.
+	PendingMFAKey = "handler.mfa.pending"
+	// MFACompletedAtKey is the session value key under which CompleteMFA records when the
+	// principal passed the second authentication factor, in seconds since the Unix epoch.
+	MFACompletedAtKey = "handler.mfa.completed_at"
+	// PendingEnrollmentKey is the session value key under which StorePendingEnrollment records
+	// the secret for a second authentication factor that the user has yet to confirm.
+	PendingEnrollmentKey = "handler.mfa.enrollment"
+
+	pendingMFAExpiresKey        = "handler.mfa.pending_expires"
+	pendingEnrollmentExpiresKey = "handler.mfa.enrollment_expires"
+	mfaCompletedPrincipalKey    = "handler.mfa.completed_principal"
+)
+
+// ErrNoPendingMFA is the error that CompleteMFA returns when the session bears no challenge for a
+// second authentication factor, or only one that has expired.
+var ErrNoPendingMFA = errors.New("no pending multi-factor authentication challenge")
+
+func clockOrSystem(clock Clock) Clock {
+	if clock != nil {
+		return clock
+	}
+	return SystemClock
+}
+
+// StorePendingMFA records in the session that the given principal passed the first authentication
+// factor, such as a password, and must pass a second within the given time to live. It clears any
+// principal already associated with the session, so that the session remains unauthenticated until
+// CompleteMFA succeeds. If the Clock is nil, it uses SystemClock.
+func StorePendingMFA(s *sessions.Session, principal string, ttl time.Duration, clock Clock) {
+	ClearPrincipal(s)
+	delete(s.Values, MFACompletedAtKey)
+	delete(s.Values, mfaCompletedPrincipalKey)
+	s.Values[PendingMFAKey] = principal
+	s.Values[pendingMFAExpiresKey] = clockOrSystem(clock).Now().Add(ttl).Unix()
+}
+
+// PendingMFA returns the principal recorded in the session by StorePendingMFA, together with a
+// boolean indicating whether it's present and its challenge has yet to expire. If the Clock is nil,
+// it uses SystemClock.
+func PendingMFA(s *sessions.Session, clock Clock) (string, bool) {
+	p, ok := s.Values[PendingMFAKey].(string)
+	if !ok || len(p) == 0 {
+		return "", false
+	}
+	expires, ok := unixTimeValue(s.Values[pendingMFAExpiresKey])
+	if !ok || !clockOrSystem(clock).Now().Before(expires) {
+		return "", false
+	}
+	return p, true
+}
+
+// CompleteMFA promotes the principal recorded in the session by StorePendingMFA to be the session's
+// principal, per SetPrincipal, once the caller has verified the second authentication factor,
+// recording when it did so under MFACompletedAtKey. It clears the ID of the session, so that saving
+// it issues a fresh ID. It returns ErrNoPendingMFA, clearing the expired challenge if any, if no
+// challenge is pending. If the Clock is nil, it uses SystemClock.
+func CompleteMFA(s *sessions.Session, clock Clock) (string, error) {
+	p, ok := PendingMFA(s, clock)
+	delete(s.Values, PendingMFAKey)
+	delete(s.Values, pendingMFAExpiresKey)
+	if !ok {
+		return "", ErrNoPendingMFA
+	}
+	SetPrincipal(s, p)
+	s.Values[MFACompletedAtKey] = clockOrSystem(clock).Now().Unix()
+	s.Values[mfaCompletedPrincipalKey] = p
+	// Rotate the session ID upon authentication, defeating session fixation.
+	s.ID = ""
+	return p, nil
+}
+
+// MFACompleted reports whether the session's principal passed the second authentication factor
+// per CompleteMFA. A principal associated with the session by other means, such as SetPrincipal,
+// doesn't inherit the completion recorded for its predecessor.
+func MFACompleted(s *sessions.Session) bool {
+	p, ok := SessionPrincipal(s)
+	if !ok {
+		return false
+	}
+	if completed, ok := s.Values[mfaCompletedPrincipalKey].(string); !ok || completed != p {
+		return false
+	}
+	_, ok = unixTimeValue(s.Values[MFACompletedAtKey])
+	return ok
+}
+
+// StorePendingEnrollment records in the session the secret for a second authentication factor,
+// such as the seed for time-based one-time passwords, that the user must confirm, such as by
+// submitting a valid code, within the given time to live before the application enrolls it. Note
+// that stores that keep session values in cookies expose the secret to the user's browser unless
+// they encrypt their cookies. If the Clock is nil, it uses SystemClock.
+func StorePendingEnrollment(s *sessions.Session, secret []byte, ttl time.Duration, clock Clock) {
+	s.Values[PendingEnrollmentKey] = secret
+	s.Values[pendingEnrollmentExpiresKey] = clockOrSystem(clock).Now().Add(ttl).Unix()
+}
+
+// PendingEnrollment returns the secret recorded in the session by StorePendingEnrollment, together
+// with a boolean indicating whether it's present and has yet to expire. If the Clock is nil, it uses
+// SystemClock.
+func PendingEnrollment(s *sessions.Session, clock Clock) ([]byte, bool) {
+	secret, ok := s.Values[PendingEnrollmentKey].([]byte)
+	if !ok || len(secret) == 0 {
+		return nil, false
+	}
+	expires, ok := unixTimeValue(s.Values[pendingEnrollmentExpiresKey])
+	if !ok || !clockOrSystem(clock).Now().Before(expires) {
+		return nil, false
+	}
+	return secret, true
+}
+
+// ClearPendingEnrollment removes any secret recorded in the session by StorePendingEnrollment, as
+// the application should do once the user confirms it or abandons the enrollment.
+func ClearPendingEnrollment(s *sessions.Session) {
+	delete(s.Values, PendingEnrollmentKey)
+	delete(s.Values, pendingEnrollmentExpiresKey)
+}
+
+// RequireMFACompleted returns an HTTP handler that delegates requests whose singular session bound
+// via WithSession has a principal that passed the second authentication factor per CompleteMFA to
+// the supplied handler. It delegates all other requests, including those whose sessions bear only a
+// pending challenge, to the onIncomplete handler, which might redirect to a page prompting for the
+// second factor. If no such onIncomplete handler is supplied, it will respond with HTTP status code
+// 401 with no body. It panics if the supplied handler is nil.
+func RequireMFACompleted(h http.Handler, onIncomplete http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	if onIncomplete == nil {
+		onIncomplete = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusUnauthorized)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if s, ok := ExtractSession(r); !ok || !MFACompleted(s) {
+			onIncomplete.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
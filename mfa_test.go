@@ -0,0 +1,127 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+)
+
+func TestRequireMFACompletedPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.RequireMFACompleted(nil, nil)
+}
+
+func TestMFA(t *testing.T) {
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	s := sessions.NewSession(simpleStore{}, "s")
+	s.ID = "before"
+	handler.SetPrincipal(s, "mallory")
+	handler.StorePendingMFA(s, "alice", time.Minute, clock)
+	if p, ok := handler.SessionPrincipal(s); ok {
+		t.Errorf("principal while pending: got %q, want none", p)
+	}
+	if p, ok := handler.PendingMFA(s, clock); !ok || p != "alice" {
+		t.Errorf("pending principal: got (%q, %t), want (%q, true)", p, ok, "alice")
+	}
+	if handler.MFACompleted(s) {
+		t.Error("MFA completed while pending")
+	}
+	p, err := handler.CompleteMFA(s, clock)
+	if err != nil {
+		t.Fatalf("failed to complete MFA: %v", err)
+	}
+	if got, ok := handler.SessionPrincipal(s); p != "alice" || !ok || got != "alice" {
+		t.Errorf("principal: got (%q, %q, %t), want %q", p, got, ok, "alice")
+	}
+	if !handler.MFACompleted(s) {
+		t.Error("MFA not completed")
+	}
+	if len(s.ID) != 0 {
+		t.Errorf("session ID: got %q, want it cleared", s.ID)
+	}
+	if _, err := handler.CompleteMFA(s, clock); err != handler.ErrNoPendingMFA {
+		t.Errorf("error completing again: got %v, want %v", err, handler.ErrNoPendingMFA)
+	}
+
+	// Another principal logging in by other means doesn't inherit the completion.
+	handler.ClearPrincipal(s)
+	handler.SetPrincipal(s, "mallory")
+	if handler.MFACompleted(s) {
+		t.Error("MFA completed for a principal that didn't pass the second factor")
+	}
+	handler.SetPrincipal(s, "alice")
+	if !handler.MFACompleted(s) {
+		t.Error("MFA not completed for the principal that passed the second factor")
+	}
+
+	handler.StorePendingMFA(s, "bob", time.Minute, clock)
+	if handler.MFACompleted(s) {
+		t.Error("MFA completed after new challenge")
+	}
+	clock.Advance(time.Minute)
+	if _, err := handler.CompleteMFA(s, clock); err != handler.ErrNoPendingMFA {
+		t.Errorf("error completing expired challenge: got %v, want %v", err, handler.ErrNoPendingMFA)
+	}
+	if _, ok := handler.PendingMFA(s, clock); ok {
+		t.Error("expired challenge remains pending")
+	}
+}
+
+func TestPendingEnrollment(t *testing.T) {
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	s := sessions.NewSession(simpleStore{}, "s")
+	if _, ok := handler.PendingEnrollment(s, clock); ok {
+		t.Error("enrollment pending in fresh session")
+	}
+	handler.StorePendingEnrollment(s, []byte("seed"), time.Minute, clock)
+	if secret, ok := handler.PendingEnrollment(s, clock); !ok || !bytes.Equal(secret, []byte("seed")) {
+		t.Errorf("pending secret: got (%q, %t), want (%q, true)", secret, ok, "seed")
+	}
+	clock.Advance(time.Minute)
+	if _, ok := handler.PendingEnrollment(s, clock); ok {
+		t.Error("expired enrollment remains pending")
+	}
+	handler.StorePendingEnrollment(s, []byte("seed"), time.Minute, clock)
+	handler.ClearPendingEnrollment(s)
+	if _, ok := handler.PendingEnrollment(s, clock); ok {
+		t.Error("cleared enrollment remains pending")
+	}
+}
+
+func TestRequireMFACompleted(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		prepare     func(s *sessions.Session)
+		want        int
+	}{
+		{"no principal", func(s *sessions.Session) {}, http.StatusUnauthorized},
+		{"principal without MFA", func(s *sessions.Session) { handler.SetPrincipal(s, "alice") }, http.StatusUnauthorized},
+		{"pending MFA", func(s *sessions.Session) { handler.StorePendingMFA(s, "alice", time.Minute, nil) }, http.StatusUnauthorized},
+		{"completed MFA", func(s *sessions.Session) {
+			handler.StorePendingMFA(s, "alice", time.Minute, nil)
+			handler.CompleteMFA(s, nil)
+		}, http.StatusNoContent},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.WithSession("s", simpleStore{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				test.prepare(handler.MustExtractSession(r))
+				handler.RequireMFACompleted(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+					w.WriteHeader(http.StatusNoContent)
+				}), nil).ServeHTTP(w, r)
+			}), nil).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/", nil))
+			if recorder.Code != test.want {
+				t.Errorf("status: got %d, want %d", recorder.Code, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,176 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+
+	"github.com/gorilla/securecookie"
+)
+
+// JSONValuesSerializer is a securecookie.Serializer that encodes session values as a JSON object.
+// Unlike securecookie.JSONEncoder, it can encode the map of values that sessions hold, provided
+// that all their keys are strings. Values decode as encoding/json decodes into an empty interface:
+// numbers become float64 values, and structs become maps.
+type JSONValuesSerializer struct{}
+
+// Serialize encodes src as JSON. If src is a map of session values, it fails if any key is not a
+// string.
+func (JSONValuesSerializer) Serialize(src interface{}) ([]byte, error) {
+	if values, ok := src.(map[interface{}]interface{}); ok {
+		m := make(map[string]interface{}, len(values))
+		for k, v := range values {
+			s, ok := k.(string)
+			if !ok {
+				return nil, fmt.Errorf("handler: session value key %v of type %T is not a string", k, k)
+			}
+			m[s] = v
+		}
+		src = m
+	}
+	return json.Marshal(src)
+}
+
+// Deserialize decodes the JSON in src into dst. If dst points to a map of session values, it
+// replaces that map's entries.
+func (JSONValuesSerializer) Deserialize(src []byte, dst interface{}) error {
+	values, ok := dst.(*map[interface{}]interface{})
+	if !ok {
+		return json.Unmarshal(src, dst)
+	}
+	var m map[string]interface{}
+	if err := json.Unmarshal(src, &m); err != nil {
+		return err
+	}
+	*values = make(map[interface{}]interface{}, len(m))
+	for k, v := range m {
+		(*values)[k] = v
+	}
+	return nil
+}
+
+// decodeMigrating calls current, falling back to legacy if that fails, and counts the outcome. If
+// both fail, it returns the error from current.
+func decodeMigrating(decoded, fellBack Counter, current, legacy func() error) error {
+	err := current()
+	if err != nil {
+		if legacy() != nil {
+			return err
+		}
+		if fellBack != nil {
+			fellBack.Add(1)
+		}
+	}
+	if decoded != nil {
+		decoded.Add(1)
+	}
+	return nil
+}
+
+// decodesAsSessionData reports whether decode succeeds for a destination of either kind that stores
+// encode: a map of session values, as kept by stores that hold sessions in cookies, or a string,
+// such as a session ID kept by a server-side store.
+func decodesAsSessionData(decode func(dst interface{}) error) bool {
+	for _, dst := range []interface{}{new(map[interface{}]interface{}), new(string)} {
+		if decode(dst) == nil {
+			return true
+		}
+	}
+	return false
+}
+
+// staleMigrating reports whether a value decodes only in the legacy form.
+func staleMigrating(current, legacy func(dst interface{}) error) bool {
+	return !decodesAsSessionData(current) && decodesAsSessionData(legacy)
+}
+
+// MigratingSerializer is a securecookie.Serializer that changes the serialization format of session
+// values in place, such as from securecookie.GobEncoder to JSONValuesSerializer. It encodes values
+// in the current format, and decodes values in the current format or, failing that, the legacy
+// format, so that sessions migrate as they're next saved. Use it as a store's serializer, such as
+// via kvstore.Store's Serializer field or securecookie.SecureCookie's SetSerializer method.
+//
+// It implements StaleChecker for values held in the serialized form itself. Cookie values that a
+// codec such as securecookie.SecureCookie signs or encrypts after serializing aren't in that form;
+// to re-encode such cookies per ReencodeStale, use a MigratingCodec whose codecs use the current
+// and legacy serializers instead.
+//
+// Once FellBack stops rising, the legacy format can be retired.
+type MigratingSerializer struct {
+	Current securecookie.Serializer
+	Legacy  securecookie.Serializer
+	// Decoded, if not nil, counts the values decoded successfully in either form.
+	Decoded Counter
+	// FellBack, if not nil, counts the values decoded successfully only in the legacy form. Its
+	// ratio to Decoded is the share of sessions yet to migrate.
+	FellBack Counter
+}
+
+// Serialize encodes src in the current format.
+func (s *MigratingSerializer) Serialize(src interface{}) ([]byte, error) {
+	return s.Current.Serialize(src)
+}
+
+// Deserialize decodes src into dst in the current format or, failing that, the legacy format.
+func (s *MigratingSerializer) Deserialize(src []byte, dst interface{}) error {
+	return decodeMigrating(s.Decoded, s.FellBack, func() error {
+		return s.Current.Deserialize(src, dst)
+	}, func() error {
+		return s.Legacy.Deserialize(src, dst)
+	})
+}
+
+// IsStale reports whether the value decodes only in the legacy format, and so should be encoded
+// anew in the current format. The name is ignored.
+func (s *MigratingSerializer) IsStale(_, value string) bool {
+	return staleMigrating(func(dst interface{}) error {
+		return s.Current.Deserialize([]byte(value), dst)
+	}, func(dst interface{}) error {
+		return s.Legacy.Deserialize([]byte(value), dst)
+	})
+}
+
+// MigratingCodec is a securecookie.Codec that changes how cookie values are encoded in place, such
+// as when replacing a securecookie.SecureCookie using gob with one using JSONValuesSerializer. It
+// encodes values with the current codec, and decodes values with the current codec or, failing
+// that, the legacy codec, so that sessions migrate as their cookies are next written. It implements
+// StaleChecker, so that supplying it via ReencodeStale together with AutoSave migrates sessions as
+// soon as they're next used, rather than only once the request handler changes them.
+//
+// Once FellBack stops rising, the legacy codec can be retired.
+type MigratingCodec struct {
+	Current securecookie.Codec
+	Legacy  securecookie.Codec
+	// Decoded, if not nil, counts the values decoded successfully in either form.
+	Decoded Counter
+	// FellBack, if not nil, counts the values decoded successfully only in the legacy form. Its
+	// ratio to Decoded is the share of sessions yet to migrate.
+	FellBack Counter
+}
+
+// Encode encodes the value with the current codec.
+func (c *MigratingCodec) Encode(name string, value interface{}) (string, error) {
+	return c.Current.Encode(name, value)
+}
+
+// Decode decodes the value with the current codec or, failing that, the legacy codec. If both
+// fail, it returns the error from the current codec.
+func (c *MigratingCodec) Decode(name, value string, dst interface{}) error {
+	return decodeMigrating(c.Decoded, c.FellBack, func() error {
+		return c.Current.Decode(name, value, dst)
+	}, func() error {
+		return c.Legacy.Decode(name, value, dst)
+	})
+}
+
+// IsStale reports whether the value, encoded for the given name, decodes only with the legacy
+// codec, and so should be encoded anew with the current codec.
+func (c *MigratingCodec) IsStale(name, value string) bool {
+	return staleMigrating(func(dst interface{}) error {
+		return c.Current.Decode(name, value, dst)
+	}, func(dst interface{}) error {
+		return c.Legacy.Decode(name, value, dst)
+	})
+}
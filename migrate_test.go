@@ -0,0 +1,169 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"expvar"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestJSONValuesSerializer(t *testing.T) {
+	var s handler.JSONValuesSerializer
+	b, err := s.Serialize(map[interface{}]interface{}{"uid": "alice", "visits": 3})
+	if err != nil {
+		t.Fatalf("failed to serialize values: %v", err)
+	}
+	values := map[interface{}]interface{}{"stale": true}
+	if err := s.Deserialize(b, &values); err != nil {
+		t.Fatalf("failed to deserialize values: %v", err)
+	}
+	if len(values) != 2 || values["uid"] != "alice" || values["visits"] != 3.0 {
+		t.Errorf("values: got %v", values)
+	}
+	if _, err := s.Serialize(map[interface{}]interface{}{1: "one"}); err == nil {
+		t.Error("serializing a value with a non-string key succeeded")
+	}
+}
+
+func TestMigratingSerializer(t *testing.T) {
+	var decoded, fellBack expvar.Int
+	s := &handler.MigratingSerializer{
+		Current:  handler.JSONValuesSerializer{},
+		Legacy:   securecookie.GobEncoder{},
+		Decoded:  &decoded,
+		FellBack: &fellBack,
+	}
+	legacy, err := securecookie.GobEncoder{}.Serialize(map[interface{}]interface{}{"uid": "alice"})
+	if err != nil {
+		t.Fatalf("failed to serialize values: %v", err)
+	}
+	current, err := s.Serialize(map[interface{}]interface{}{"uid": "bob"})
+	if err != nil {
+		t.Fatalf("failed to serialize values: %v", err)
+	}
+	for _, test := range []struct {
+		b    []byte
+		want string
+	}{
+		{legacy, "alice"},
+		{current, "bob"},
+	} {
+		var values map[interface{}]interface{}
+		if err := s.Deserialize(test.b, &values); err != nil {
+			t.Fatalf("failed to deserialize values: %v", err)
+		}
+		if got := values["uid"]; got != test.want {
+			t.Errorf("value: got %v, want %v", got, test.want)
+		}
+	}
+	var values map[interface{}]interface{}
+	if err := s.Deserialize([]byte("garbage"), &values); err == nil {
+		t.Error("deserializing garbage succeeded")
+	}
+	if got, want := decoded.Value(), int64(2); got != want {
+		t.Errorf("decoded: got %d, want %d", got, want)
+	}
+	if got, want := fellBack.Value(), int64(1); got != want {
+		t.Errorf("fell back: got %d, want %d", got, want)
+	}
+}
+
+func TestMigratingCodec(t *testing.T) {
+	hashKey := securecookie.GenerateRandomKey(32)
+	legacy := securecookie.New(hashKey, nil)
+	var fellBack int64
+	c := &handler.MigratingCodec{
+		Current:  securecookie.New(hashKey, nil).SetSerializer(handler.JSONValuesSerializer{}),
+		Legacy:   legacy,
+		FellBack: handler.CounterFunc(func(delta int64) { fellBack += delta }),
+	}
+	encoded, err := legacy.Encode("s", map[interface{}]interface{}{"uid": "alice"})
+	if err != nil {
+		t.Fatalf("failed to encode values: %v", err)
+	}
+	var values map[interface{}]interface{}
+	if err := c.Decode("s", encoded, &values); err != nil {
+		t.Fatalf("failed to decode legacy values: %v", err)
+	}
+	if fellBack != 1 {
+		t.Errorf("fell back: got %d, want 1", fellBack)
+	}
+	if encoded, err = c.Encode("s", values); err != nil {
+		t.Fatalf("failed to encode values: %v", err)
+	}
+	if err := legacy.Decode("s", encoded, &values); err == nil {
+		t.Error("legacy codec decoded migrated values")
+	}
+	if err := c.Decode("s", encoded, &values); err != nil {
+		t.Fatalf("failed to decode migrated values: %v", err)
+	}
+	if got, want := values["uid"], "alice"; got != want || fellBack != 1 {
+		t.Errorf("value: got %v with %d fallbacks, want %v with 1", got, fellBack, want)
+	}
+}
+
+func TestMigratingSerializerIsStale(t *testing.T) {
+	s := &handler.MigratingSerializer{
+		Current: handler.JSONValuesSerializer{},
+		Legacy:  securecookie.GobEncoder{},
+	}
+	legacy, _ := securecookie.GobEncoder{}.Serialize(map[interface{}]interface{}{"uid": "alice"})
+	current, _ := s.Serialize(map[interface{}]interface{}{"uid": "alice"})
+	for _, test := range []struct {
+		description string
+		value       string
+		want        bool
+	}{
+		{"legacy", string(legacy), true},
+		{"current", string(current), false},
+		{"garbage", "garbage", false},
+	} {
+		if got := s.IsStale("s", test.value); got != test.want {
+			t.Errorf("%s value stale: got %t, want %t", test.description, got, test.want)
+		}
+	}
+}
+
+func TestMigratingCodecReencodesStaleSessions(t *testing.T) {
+	hashKey := securecookie.GenerateRandomKey(32)
+	legacy := securecookie.New(hashKey, nil)
+	current := securecookie.New(hashKey, nil).SetSerializer(handler.JSONValuesSerializer{})
+	c := &handler.MigratingCodec{Current: current, Legacy: legacy}
+	store := sessions.NewCookieStore()
+	store.Codecs = []securecookie.Codec{c}
+	h := handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if got, want := handler.MustExtractSession(r).Values["uid"], "alice"; got != want {
+			t.Errorf("value: got %v, want %v", got, want)
+		}
+	}), nil, handler.AutoSave(nil), handler.ReencodeStale(c))
+
+	serve := func(encoded string) []*http.Cookie {
+		r := httptest.NewRequest("", "/", nil)
+		r.AddCookie(&http.Cookie{Name: "s", Value: encoded})
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		return recorder.Result().Cookies()
+	}
+	encoded, err := legacy.Encode("s", map[interface{}]interface{}{"uid": "alice"})
+	if err != nil {
+		t.Fatalf("failed to encode values: %v", err)
+	}
+	cookies := serve(encoded)
+	if len(cookies) != 1 {
+		t.Fatalf("cookies for legacy session: got %v, want one", cookies)
+	}
+	var values map[interface{}]interface{}
+	if err := current.Decode("s", cookies[0].Value, &values); err != nil {
+		t.Errorf("failed to decode re-encoded session with current codec: %v", err)
+	}
+	if cookies := serve(cookies[0].Value); len(cookies) != 0 {
+		t.Errorf("cookies for migrated session: got %v, want none", cookies)
+	}
+}
@@ -0,0 +1,104 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// MissingSessionError describes an attempt to extract a session that's not bound to the request.
+// MustExtractSession and MustExtractSessionNamed panic with a *MissingSessionError by default.
+type MissingSessionError struct {
+	// Name is the name of the session sought, or empty when sought via MustExtractSession.
+	Name string
+}
+
+func (e *MissingSessionError) Error() string {
+	if len(e.Name) == 0 {
+		return "no session available"
+	}
+	return fmt.Sprintf("no session named %q available", e.Name)
+}
+
+// Is reports whether target is ErrNoSession, so that errors.Is can match MissingSessionError values.
+func (e *MissingSessionError) Is(target error) bool {
+	return target == ErrNoSession
+}
+
+// OnMissingSession determines how MustExtractSession and MustExtractSessionNamed behave when the
+// session they seek is not bound to the request. The name is that of the session sought, or empty
+// when sought via MustExtractSession. It must either return a non-nil session or panic.
+//
+// By default it's PanicOnMissingSession. Replace it only during program initialization, before
+// serving any requests.
+var OnMissingSession func(r *http.Request, name string) *sessions.Session = PanicOnMissingSession
+
+// PanicOnMissingSession panics with a *MissingSessionError identifying the missing session. Pair
+// it with RecoverMissingSession to respond with an error instead of crashing the request.
+func PanicOnMissingSession(_ *http.Request, name string) *sessions.Session {
+	panic(&MissingSessionError{Name: name})
+}
+
+// unboundStore is the store for sessions created by LogAndCreateSession, which belong to no store
+// and hence can't be saved.
+type unboundStore struct{}
+
+func (unboundStore) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return unboundStore{}.New(r, name)
+}
+
+func (s unboundStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.IsNew = true
+	return session, nil
+}
+
+func (unboundStore) Save(*http.Request, http.ResponseWriter, *sessions.Session) error {
+	return ErrNoSession
+}
+
+// LogAndCreateSession returns a function suitable for use as OnMissingSession that reports the
+// missing session via the supplied logf function—or log.Printf, if nil—and returns a fresh session
+// bound to neither the request nor any store. Attempting to save such a session yields
+// ErrNoSession.
+func LogAndCreateSession(logf func(format string, v ...interface{})) func(r *http.Request, name string) *sessions.Session {
+	if logf == nil {
+		logf = log.Printf
+	}
+	return func(r *http.Request, name string) *sessions.Session {
+		logf("handler: %v for request to %s; using a fresh unbound session", &MissingSessionError{Name: name}, r.URL.Path)
+		session, _ := unboundStore{}.New(r, name)
+		return session
+	}
+}
+
+// RecoverMissingSession returns an HTTP handler that delegates to the supplied handler, recovering
+// from any panic with a *MissingSessionError—such as that raised by PanicOnMissingSession—and
+// delegating further request processing to the onError handler. If no such onError handler is
+// supplied, it will respond with HTTP status code 500 with no body. It propagates all other
+// panics. It panics if the supplied handler is nil.
+func RecoverMissingSession(h http.Handler, onError func(w http.ResponseWriter, r *http.Request, err error)) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	if onError == nil {
+		onError = func(w http.ResponseWriter, _ *http.Request, _ error) { sendDefaultResponse(w) }
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		defer func() {
+			if p := recover(); p != nil {
+				err, ok := p.(*MissingSessionError)
+				if !ok {
+					panic(p)
+				}
+				onError(w, r, err)
+			}
+		}()
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,86 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func withMissingSessionHandler(t *testing.T, f func(r *http.Request, name string) *sessions.Session) {
+	prior := handler.OnMissingSession
+	handler.OnMissingSession = f
+	t.Cleanup(func() { handler.OnMissingSession = prior })
+}
+
+func TestMustExtractSessionPanicsWithMissingSessionError(t *testing.T) {
+	defer func() {
+		err, ok := recover().(*handler.MissingSessionError)
+		if !ok {
+			t.Fatalf("panic value: got %T, want *handler.MissingSessionError", err)
+		}
+		if got, want := err.Name, "s"; got != want {
+			t.Errorf("name: got %q, want %q", got, want)
+		}
+		if !errors.Is(err, handler.ErrNoSession) {
+			t.Errorf("error %v does not match %v", err, handler.ErrNoSession)
+		}
+	}()
+	handler.MustExtractSessionNamed("s", httptest.NewRequest("", "/", nil))
+}
+
+func TestLogAndCreateSession(t *testing.T) {
+	var logged []string
+	withMissingSessionHandler(t, handler.LogAndCreateSession(func(format string, v ...interface{}) {
+		logged = append(logged, fmt.Sprintf(format, v...))
+	}))
+	r := httptest.NewRequest("", "/", nil)
+	session := handler.MustExtractSession(r)
+	if session == nil || !session.IsNew {
+		t.Fatalf("session: got %v, want a new session", session)
+	}
+	if err := session.Save(r, httptest.NewRecorder()); err != handler.ErrNoSession {
+		t.Errorf("error from Save: got %v, want %v", err, handler.ErrNoSession)
+	}
+	if session := handler.MustExtractSessionNamed("s", r); session.Name() != "s" {
+		t.Errorf("session name: got %q, want %q", session.Name(), "s")
+	}
+	if got, want := len(logged), 2; got != want {
+		t.Errorf("log message count: got %d, want %d", got, want)
+	}
+}
+
+func TestRecoverMissingSession(t *testing.T) {
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.MustExtractSession(r)
+		t.Error("extraction did not panic")
+	})
+	ensureResponseIsInternalError(t, handler.RecoverMissingSession(h, nil))
+
+	var recovered error
+	recorder := httptest.NewRecorder()
+	handler.RecoverMissingSession(h, func(w http.ResponseWriter, r *http.Request, err error) {
+		recovered = err
+		w.WriteHeader(http.StatusServiceUnavailable)
+	}).ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+	if !errors.Is(recovered, handler.ErrNoSession) {
+		t.Errorf("error: got %v, want %v", recovered, handler.ErrNoSession)
+	}
+	if got, want := recorder.Code, http.StatusServiceUnavailable; got != want {
+		t.Errorf("status code: got %d, want %d", got, want)
+	}
+}
+
+func TestRecoverMissingSessionPropagatesOtherPanics(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.RecoverMissingSession(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		panic("other")
+	}), nil).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+}
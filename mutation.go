@@ -0,0 +1,130 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/http"
+	"sort"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// valueDigests returns a keyed digest of each of the given session values, keyed by the values'
+// keys. It retains the keys themselves, since distinct keys, such as 1 and "1", may print alike.
+func valueDigests(key []byte, values map[interface{}]interface{}) map[interface{}]string {
+	digests := make(map[interface{}]string, len(values))
+	for k, v := range values {
+		mac := hmac.New(sha256.New, key)
+		// fmt prints maps with their keys sorted, making this representation deterministic for
+		// most values.
+		fmt.Fprintf(mac, "%T:%#v", v, v)
+		digests[k] = hex.EncodeToString(mac.Sum(nil)[:12])
+	}
+	return digests
+}
+
+// diffDigests returns the changes between two sets of value digests, ordered by the printed form
+// of their keys, and then by the keys' types.
+func diffDigests(before, after map[interface{}]string) []AuditKeyChange {
+	type change struct {
+		AuditKeyChange
+		keyType string
+	}
+	var changes []change
+	add := func(k interface{}, old, current string) {
+		changes = append(changes, change{AuditKeyChange{fmt.Sprintf("%v", k), old, current}, fmt.Sprintf("%T", k)})
+	}
+	for k, old := range before {
+		if current := after[k]; current != old {
+			add(k, old, current)
+		}
+	}
+	for k, current := range after {
+		if _, ok := before[k]; !ok {
+			add(k, "", current)
+		}
+	}
+	sort.Slice(changes, func(i, j int) bool {
+		if changes[i].Key != changes[j].Key {
+			return changes[i].Key < changes[j].Key
+		}
+		return changes[i].keyType < changes[j].keyType
+	})
+	var result []AuditKeyChange
+	for _, c := range changes {
+		result = append(result, c.AuditKeyChange)
+	}
+	return result
+}
+
+// mutationAuditingStore is a sessions.Store that reports changes to the values of a single session
+// to an AuditSink upon saving it.
+type mutationAuditingStore struct {
+	sessions.Store
+	sink    AuditSink
+	clock   Clock
+	key     []byte
+	digests map[interface{}]string
+}
+
+func (m *mutationAuditingStore) unwrapStore() sessions.Store {
+	return m.Store
+}
+
+func (m *mutationAuditingStore) Save(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	if err := m.Store.Save(r, w, s); err != nil {
+		return err
+	}
+	if s.Options != nil && s.Options.MaxAge < 0 {
+		return nil
+	}
+	digests := valueDigests(m.key, s.Values)
+	if changes := diffDigests(m.digests, digests); len(changes) != 0 {
+		e := newAuditEvent(AuditSessionMutated, m.clock, r, s)
+		e.Changes = changes
+		m.sink.Audit(r.Context(), e)
+	}
+	// Compare subsequent saves of this session against this one.
+	m.digests = digests
+	return nil
+}
+
+// AuditMutations returns an Option that reports which values of bound sessions changed to the
+// given AuditSink, each time a session is saved, as AuditSessionMutated events. It identifies
+// values only by digests keyed with the given hash key, so that the audit trail can show when a
+// value changed, and whether it reverted to an earlier value, without revealing the values
+// themselves. Keep the hash key secret and consistent across processes so that digests remain
+// comparable; if it's empty, AuditMutations uses a random key, making the digests comparable only
+// within this process. It records the time of each event per the Clock supplied via UseClock, if
+// any. It panics if the sink is nil.
+//
+// Note that it digests values by their printed representations, so values containing pointers
+// may appear to change when only their addresses do.
+func AuditMutations(sink AuditSink, hashKey []byte) Option {
+	if sink == nil {
+		panic("no audit sink supplied")
+	}
+	if len(hashKey) == 0 {
+		hashKey = securecookie.GenerateRandomKey(32)
+	}
+	return func(c *bindingConfig) {
+		c.decorators = append(c.decorators, func(r *http.Request, s *sessions.Session) *sessions.Session {
+			if s.Store() == nil {
+				return s
+			}
+			return rebindSession(s, &mutationAuditingStore{
+				Store:   s.Store(),
+				sink:    sink,
+				clock:   clockOrSystem(c.clock),
+				key:     hashKey,
+				digests: valueDigests(hashKey, s.Values),
+			})
+		})
+	}
+}
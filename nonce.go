@@ -0,0 +1,147 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"crypto/subtle"
+	"encoding/base64"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// NoncesKey is the session value key under which IssueNonce records the session's outstanding
+// nonces, each with its expiration time.
+const NoncesKey = "handler.nonces"
+
+// maxOutstandingNonces bounds how many nonces a session holds at once, keeping the session small
+// even when clients request forms they never submit. IssueNonce discards the oldest beyond it.
+const maxOutstandingNonces = 16
+
+// stringsValue interprets a session value recorded as a slice of strings.
+func stringsValue(v interface{}) []string {
+	switch v := v.(type) {
+	case []string:
+		return v
+	case []interface{}:
+		// Some serializers, such as JSON ones, decode arrays as []interface{} values.
+		strs := make([]string, 0, len(v))
+		for _, e := range v {
+			if e, ok := e.(string); ok {
+				strs = append(strs, e)
+			}
+		}
+		return strs
+	}
+	return nil
+}
+
+// outstandingNonces returns the session's unexpired nonces, each encoded as its expiration time in
+// seconds since the Unix epoch, followed by a period and the nonce.
+func outstandingNonces(s *sessions.Session, now time.Time) []string {
+	entries := stringsValue(s.Values[NoncesKey])
+	live := make([]string, 0, len(entries)+1)
+	for _, e := range entries {
+		i := strings.IndexByte(e, '.')
+		if i < 0 {
+			continue
+		}
+		expires, err := strconv.ParseInt(e[:i], 10, 64)
+		if err != nil || !now.Before(time.Unix(expires, 0)) {
+			continue
+		}
+		live = append(live, e)
+	}
+	return live
+}
+
+// IssueNonce records a fresh nonce in the session, valid for the given time to live, and returns
+// it for inclusion in a form or request, to be consumed once by ConsumeNonce. The session holds at
+// most sixteen outstanding nonces, discarding the oldest beyond that. If the Clock is nil, it uses
+// SystemClock.
+func IssueNonce(s *sessions.Session, ttl time.Duration, clock Clock) string {
+	now := clockOrSystem(clock).Now()
+	nonce := base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(16))
+	nonces := append(outstandingNonces(s, now), strconv.FormatInt(now.Add(ttl).Unix(), 10)+"."+nonce)
+	if excess := len(nonces) - maxOutstandingNonces; excess > 0 {
+		nonces = nonces[excess:]
+	}
+	s.Values[NoncesKey] = nonces
+	return nonce
+}
+
+// ConsumeNonce removes the given nonce from those outstanding in the session, reporting whether it
+// was outstanding and unexpired. It also discards any expired nonces. If the Clock is nil, it uses
+// SystemClock.
+//
+// Note that the caller must save the session for the nonce to remain consumed, and that consuming
+// a nonce resists replays only as well as saving the session resists concurrent saves. Stores that
+// keep session values in cookies can't prevent a client from replaying a request with the cookie
+// that bore the nonce before its consumption. Stores that keep session values on the server let
+// concurrent requests bearing the same nonce each load the session before the other saves it, so
+// that both consume the nonce, unless saving detects the intervening save and fails, as
+// kvstore.Store does with its OnConflict field set to kvstore.RejectConflicts and a KV implementing
+// kvstore.CompareAndSwapper. For replay protection with other stores, issue single-use tokens held
+// by the server, such as with OneTimeTokens, instead.
+func ConsumeNonce(s *sessions.Session, nonce string, clock Clock) bool {
+	nonces := outstandingNonces(s, clockOrSystem(clock).Now())
+	found := false
+	kept := nonces[:0]
+	for _, e := range nonces {
+		if !found && subtle.ConstantTimeCompare([]byte(e[strings.IndexByte(e, '.')+1:]), []byte(nonce)) == 1 {
+			found = true
+			continue
+		}
+		kept = append(kept, e)
+	}
+	if len(kept) == 0 {
+		delete(s.Values, NoncesKey)
+	} else {
+		s.Values[NoncesKey] = kept
+	}
+	return found
+}
+
+// RequireNonce returns an HTTP handler that consumes the nonce borne by each request, per
+// ConsumeNonce, from the singular session bound to the request via WithSession, and saves the
+// session before delegating further request processing to the supplied handler. It looks for the
+// nonce first in the X-Nonce header, and then in the "nonce" query or form parameter. Applied to
+// endpoints that change state, it rejects duplicate submissions of a form and replays of a request,
+// subject to the store's limitations described for ConsumeNonce.
+//
+// It delegates requests bearing no nonce, or one that isn't outstanding, to the onReject handler,
+// as well as requests with no bound session. If no such onReject handler is supplied, it will
+// respond with HTTP status code 409 with no body. If saving the session fails, it responds with
+// HTTP status code 500 with no body. If the Clock is nil, it uses SystemClock. It panics if the
+// supplied handler is nil.
+func RequireNonce(h http.Handler, clock Clock, onReject http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	if onReject == nil {
+		onReject = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusConflict)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		nonce := r.Header.Get("X-Nonce")
+		if len(nonce) == 0 {
+			nonce = r.FormValue("nonce")
+		}
+		s, ok := ExtractSession(r)
+		if !ok || len(nonce) == 0 || !ConsumeNonce(s, nonce, clock) {
+			onReject.ServeHTTP(w, r)
+			return
+		}
+		if err := s.Save(r, w); err != nil {
+			sendDefaultResponse(w)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
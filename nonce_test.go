@@ -0,0 +1,144 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestRequireNoncePanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.RequireNonce(nil, nil, nil)
+}
+
+func TestNonces(t *testing.T) {
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	s := sessions.NewSession(simpleStore{}, "s")
+	first := handler.IssueNonce(s, time.Minute, clock)
+	second := handler.IssueNonce(s, 2*time.Minute, clock)
+	if first == second {
+		t.Fatalf("nonces are not distinct: %q", first)
+	}
+	if handler.ConsumeNonce(s, "bogus", clock) {
+		t.Error("consumed bogus nonce")
+	}
+	if !handler.ConsumeNonce(s, first, clock) {
+		t.Error("failed to consume nonce")
+	}
+	if handler.ConsumeNonce(s, first, clock) {
+		t.Error("consumed nonce twice")
+	}
+	clock.Advance(2 * time.Minute)
+	if handler.ConsumeNonce(s, second, clock) {
+		t.Error("consumed expired nonce")
+	}
+	if _, ok := s.Values[handler.NoncesKey]; ok {
+		t.Error("expired nonces remain in session")
+	}
+
+	oldest := handler.IssueNonce(s, time.Minute, clock)
+	for i := 0; i < 16; i++ {
+		handler.IssueNonce(s, time.Minute, clock)
+	}
+	if handler.ConsumeNonce(s, oldest, clock) {
+		t.Error("consumed nonce beyond the outstanding limit")
+	}
+}
+
+func TestRequireNonce(t *testing.T) {
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	var nonce string
+	issue := handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := handler.MustExtractSession(r)
+		nonce = handler.IssueNonce(s, time.Minute, nil)
+		s.Save(r, w)
+	}), nil)
+	recorder := httptest.NewRecorder()
+	issue.ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/form", nil))
+	cookie := recorder.Result().Cookies()[0]
+
+	submit := handler.WithSession("s", store, handler.RequireNonce(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), nil, nil), nil)
+	post := func(c *http.Cookie, nonce string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/submit", nil)
+		r.AddCookie(c)
+		r.Header.Set("X-Nonce", nonce)
+		recorder := httptest.NewRecorder()
+		submit.ServeHTTP(recorder, r)
+		return recorder
+	}
+	if got, want := post(cookie, "").Code, http.StatusConflict; got != want {
+		t.Errorf("status without nonce: got %d, want %d", got, want)
+	}
+	recorder = post(cookie, nonce)
+	if got, want := recorder.Code, http.StatusNoContent; got != want {
+		t.Fatalf("status with nonce: got %d, want %d", got, want)
+	}
+	if got, want := post(recorder.Result().Cookies()[0], nonce).Code, http.StatusConflict; got != want {
+		t.Errorf("status for duplicate submission: got %d, want %d", got, want)
+	}
+}
+
+func TestRequireNonceWithConcurrentReplays(t *testing.T) {
+	store := kvstore.New(kvstore.NewMemory(), securecookie.GenerateRandomKey(32))
+	store.OnConflict = kvstore.RejectConflicts
+	var nonce string
+	recorder := httptest.NewRecorder()
+	handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := handler.MustExtractSession(r)
+		nonce = handler.IssueNonce(s, time.Minute, nil)
+		if err := s.Save(r, w); err != nil {
+			t.Fatalf("failed to save session: %v", err)
+		}
+	}), nil).ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/form", nil))
+	cookies := recorder.Result().Cookies()
+
+	// Let both requests load the session before either consumes the nonce.
+	var loaded sync.WaitGroup
+	loaded.Add(2)
+	submit := handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		loaded.Done()
+		loaded.Wait()
+		handler.RequireNonce(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		}), nil, nil).ServeHTTP(w, r)
+	}), nil)
+	codes := make([]int, 2)
+	var wg sync.WaitGroup
+	for i := range codes {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/submit", nil)
+			for _, c := range cookies {
+				r.AddCookie(c)
+			}
+			r.Header.Set("X-Nonce", nonce)
+			recorder := httptest.NewRecorder()
+			submit.ServeHTTP(recorder, r)
+			codes[i] = recorder.Code
+		}(i)
+	}
+	wg.Wait()
+	accepted := 0
+	for _, code := range codes {
+		if code == http.StatusNoContent {
+			accepted++
+		}
+	}
+	if accepted != 1 {
+		t.Errorf("statuses: got %v, want exactly one %d", codes, http.StatusNoContent)
+	}
+}
@@ -0,0 +1,289 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"strings"
+	"time"
+)
+
+// ErrInvalidIDToken is the error that CallbackHandler reports when the provider issues an ID token
+// that's malformed, bears an invalid signature, or bears claims that don't match the login.
+var ErrInvalidIDToken = errors.New("oidc: invalid ID token")
+
+func invalidIDToken(format string, args ...interface{}) error {
+	return fmt.Errorf("%w: "+format, append([]interface{}{ErrInvalidIDToken}, args...)...)
+}
+
+// Claims holds the claims of a verified ID token, keyed by name.
+type Claims map[string]interface{}
+
+// String returns the value of the named claim if it's a string, or the empty string otherwise.
+func (c Claims) String(name string) string {
+	s, _ := c[name].(string)
+	return s
+}
+
+// Subject returns the "sub" claim, identifying the user at the issuer.
+func (c Claims) Subject() string {
+	return c.String("sub")
+}
+
+func (c Claims) time(name string) (time.Time, bool) {
+	v, ok := c[name].(float64)
+	if !ok {
+		return time.Time{}, false
+	}
+	return time.Unix(int64(v), 0), true
+}
+
+// audience returns the "aud" claim, which may be either a single string or an array of them.
+func (c Claims) audience() []string {
+	switch v := c["aud"].(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		aud := make([]string, 0, len(v))
+		for _, a := range v {
+			if s, ok := a.(string); ok {
+				aud = append(aud, s)
+			}
+		}
+		return aud
+	}
+	return nil
+}
+
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Use string `json:"use"`
+	N   string `json:"n"`
+	E   string `json:"e"`
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+	Y   string `json:"y"`
+}
+
+func decodeBigInt(s string) (*big.Int, error) {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return nil, err
+	}
+	return new(big.Int).SetBytes(b), nil
+}
+
+// publicKey returns the public key that the JWK describes, or false if it describes a kind of key
+// not supported for verifying ID tokens.
+func (k jwk) publicKey() (crypto.PublicKey, bool) {
+	if len(k.Use) != 0 && k.Use != "sig" {
+		return nil, false
+	}
+	switch k.Kty {
+	case "RSA":
+		n, err := decodeBigInt(k.N)
+		if err != nil {
+			return nil, false
+		}
+		e, err := decodeBigInt(k.E)
+		if err != nil || !e.IsInt64() {
+			return nil, false
+		}
+		return &rsa.PublicKey{N: n, E: int(e.Int64())}, true
+	case "EC":
+		var curve elliptic.Curve
+		switch k.Crv {
+		case "P-256":
+			curve = elliptic.P256()
+		case "P-384":
+			curve = elliptic.P384()
+		default:
+			return nil, false
+		}
+		x, err := decodeBigInt(k.X)
+		if err != nil {
+			return nil, false
+		}
+		y, err := decodeBigInt(k.Y)
+		if err != nil {
+			return nil, false
+		}
+		return &ecdsa.PublicKey{Curve: curve, X: x, Y: y}, true
+	}
+	return nil, false
+}
+
+// fetchKeys retrieves the provider's signing keys from its JWKS document, keyed by key ID.
+func (p *Provider) fetchKeys(ctx context.Context) (map[string]crypto.PublicKey, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJSON(ctx, p.metadata.JWKSURI, &set); err != nil {
+		return nil, err
+	}
+	keys := make(map[string]crypto.PublicKey, len(set.Keys))
+	for _, k := range set.Keys {
+		if key, ok := k.publicKey(); ok {
+			keys[k.Kid] = key
+		}
+	}
+	return keys, nil
+}
+
+// minKeyRefreshInterval bounds how often a Provider refetches its signing keys upon encountering
+// an ID token signed with an unknown key.
+const minKeyRefreshInterval = 10 * time.Second
+
+// key returns the provider's signing key with the given ID, refetching the provider's keys if it's
+// not among those fetched previously.
+func (p *Provider) key(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if key, ok := p.keys[kid]; ok {
+		return key, nil
+	}
+	now := p.now()
+	if p.keys != nil && now.Sub(p.keysFetched) < minKeyRefreshInterval {
+		return nil, invalidIDToken("unknown signing key %q", kid)
+	}
+	keys, err := p.fetchKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	p.keys, p.keysFetched = keys, now
+	if key, ok := keys[kid]; ok {
+		return key, nil
+	}
+	return nil, invalidIDToken("unknown signing key %q", kid)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed, sig []byte) bool {
+	var hash crypto.Hash
+	switch alg {
+	case "RS256", "ES256":
+		hash = crypto.SHA256
+	case "RS384", "ES384":
+		hash = crypto.SHA384
+	case "RS512":
+		hash = crypto.SHA512
+	default:
+		return false
+	}
+	h := hash.New()
+	h.Write(signed)
+	digest := h.Sum(nil)
+	switch key := key.(type) {
+	case *rsa.PublicKey:
+		return strings.HasPrefix(alg, "RS") && rsa.VerifyPKCS1v15(key, hash, digest, sig) == nil
+	case *ecdsa.PublicKey:
+		size := (key.Curve.Params().BitSize + 7) / 8
+		if !strings.HasPrefix(alg, "ES") || len(sig) != 2*size {
+			return false
+		}
+		r, s := new(big.Int).SetBytes(sig[:size]), new(big.Int).SetBytes(sig[size:])
+		return ecdsa.Verify(key, digest, r, s)
+	}
+	return false
+}
+
+// clockSkew is how far the clocks of a Provider and its issuer may disagree when checking the
+// expiration of ID tokens.
+const clockSkew = time.Minute
+
+// verifyIDToken verifies the signature of the raw ID token and checks its issuer, audience,
+// expiration, and nonce, returning its claims.
+func (p *Provider) verifyIDToken(ctx context.Context, raw, nonce string) (Claims, error) {
+	parts := strings.Split(raw, ".")
+	if len(parts) != 3 {
+		return nil, invalidIDToken("malformed token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, invalidIDToken("malformed header: %v", err)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, invalidIDToken("malformed signature: %v", err)
+	}
+	key, err := p.key(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if !verifySignature(header.Alg, key, []byte(parts[0]+"."+parts[1]), sig) {
+		return nil, invalidIDToken("bad signature")
+	}
+	var claims Claims
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, invalidIDToken("malformed claims: %v", err)
+	}
+	if iss := claims.String("iss"); iss != p.metadata.Issuer {
+		return nil, invalidIDToken("issuer %q does not match %q", iss, p.metadata.Issuer)
+	}
+	aud := claims.audience()
+	found := false
+	for _, a := range aud {
+		if a == p.config.ClientID {
+			found = true
+			break
+		}
+	}
+	if !found {
+		return nil, invalidIDToken("audience %v does not include client %q", aud, p.config.ClientID)
+	}
+	if azp := claims.String("azp"); len(aud) > 1 && azp != p.config.ClientID {
+		return nil, invalidIDToken("authorized party %q is not client %q", azp, p.config.ClientID)
+	}
+	exp, ok := claims.time("exp")
+	if !ok {
+		return nil, invalidIDToken("no expiration")
+	}
+	if !p.now().Before(exp.Add(clockSkew)) {
+		return nil, invalidIDToken("expired at %v", exp)
+	}
+	if got := claims.String("nonce"); got != nonce {
+		return nil, invalidIDToken("nonce does not match")
+	}
+	if len(claims.Subject()) == 0 {
+		return nil, invalidIDToken("no subject")
+	}
+	return claims, nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := base64.RawURLEncoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
+
+func (p *Provider) getJSON(ctx context.Context, url string, v interface{}) error {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	res, err := p.client().Do(r)
+	if err != nil {
+		return err
+	}
+	defer res.Body.Close()
+	if res.StatusCode != http.StatusOK {
+		return fmt.Errorf("oidc: fetching %s: unexpected status %s", url, res.Status)
+	}
+	return json.NewDecoder(res.Body).Decode(v)
+}
@@ -0,0 +1,373 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+/*
+Package oidc authenticates users with an OpenID Connect provider using the authorization code
+flow, establishing the authenticated user as the principal of the session bound by package
+handler.
+*/
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/gob"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+// Session value keys under which LoginHandler records the pending login for CallbackHandler.
+const (
+	stateKey    = "oidc.state"
+	nonceKey    = "oidc.nonce"
+	verifierKey = "oidc.verifier"
+	returnToKey = "oidc.return_to"
+)
+
+// TokenKey is the session value key under which CallbackHandler records the Token issued at login,
+// if Config.StoreTokens is true.
+const TokenKey = "oidc.token"
+
+// Token holds the tokens that a provider issued.
+type Token struct {
+	AccessToken  string
+	TokenType    string
+	RefreshToken string
+	// Expiry is when the access token expires, or the zero time if the provider didn't say.
+	Expiry  time.Time
+	IDToken string
+}
+
+func init() {
+	gob.Register(Token{})
+}
+
+// ErrInvalidState is the error that CallbackHandler reports when a request's state parameter
+// doesn't match that of the login pending in the bound session, such as when the login began in a
+// different browser, or was forged.
+var ErrInvalidState = errors.New("oidc: state does not match pending login")
+
+// ProviderError is the error that CallbackHandler reports when the provider returns an error
+// instead of an authorization code or tokens.
+type ProviderError struct {
+	// Code is the OAuth 2.0 error code, such as "access_denied".
+	Code string
+	// Description is the provider's optional human-readable explanation.
+	Description string
+}
+
+func (e *ProviderError) Error() string {
+	if len(e.Description) == 0 {
+		return "oidc: provider returned error " + e.Code
+	}
+	return fmt.Sprintf("oidc: provider returned error %s: %s", e.Code, e.Description)
+}
+
+// Config configures a Provider.
+type Config struct {
+	// Issuer is the URL of the OpenID Connect provider, at which it publishes its configuration
+	// under "/.well-known/openid-configuration". It's required.
+	Issuer string
+	// ClientID identifies this application to the provider. It's required.
+	ClientID string
+	// ClientSecret authenticates this application to the provider. If empty, the application
+	// acts as a public client, relying on PKCE alone.
+	ClientSecret string
+	// RedirectURL is the absolute URL at which this application serves CallbackHandler, as
+	// registered with the provider. It's required.
+	RedirectURL string
+	// Scopes lists the scopes to request beyond "openid", such as "email" or "profile".
+	Scopes []string
+	// HTTPClient issues requests to the provider. If nil, Provider uses http.DefaultClient.
+	HTTPClient *http.Client
+	// Clock reports the current time, used to check the expiration of ID tokens and access tokens.
+	// If nil, Provider uses handler.SystemClock.
+	Clock handler.Clock
+	// Principal derives the principal to record in the session from the claims of a verified ID
+	// token, or rejects the login by returning an error. If nil, Provider uses the issuer and
+	// subject, which together identify the user uniquely.
+	Principal func(claims Claims) (string, error)
+	// StoreTokens records the tokens that the provider issues at login in the session under
+	// TokenKey, for use in calling APIs on the user's behalf. Note that the tokens may be too
+	// large to fit in the cookie of a sessions.CookieStore.
+	StoreTokens bool
+	// DefaultReturnTo is the path to which CallbackHandler redirects after a login whose request
+	// to LoginHandler named no return path. If empty, it redirects to "/".
+	DefaultReturnTo string
+}
+
+type providerMetadata struct {
+	Issuer                string `json:"issuer"`
+	AuthorizationEndpoint string `json:"authorization_endpoint"`
+	TokenEndpoint         string `json:"token_endpoint"`
+	JWKSURI               string `json:"jwks_uri"`
+}
+
+// Provider authenticates users with an OpenID Connect provider, per its LoginHandler and
+// CallbackHandler methods.
+//
+// Create a Provider with NewProvider.
+type Provider struct {
+	config      Config
+	metadata    providerMetadata
+	mu          sync.Mutex
+	keys        map[string]crypto.PublicKey
+	keysFetched time.Time
+	refreshes   map[string]*refreshCall
+}
+
+// NewProvider retrieves the configuration that the issuer publishes and returns a Provider using
+// it. It returns an error if the Config lacks required fields, or if the issuer's configuration is
+// unavailable or names a different issuer.
+func NewProvider(ctx context.Context, c Config) (*Provider, error) {
+	var missing []string
+	for _, f := range []struct{ name, value string }{
+		{"issuer", c.Issuer},
+		{"client ID", c.ClientID},
+		{"redirect URL", c.RedirectURL},
+	} {
+		if len(f.value) == 0 {
+			missing = append(missing, f.name)
+		}
+	}
+	if len(missing) != 0 {
+		return nil, errors.New("oidc: no " + strings.Join(missing, ", ") + " supplied")
+	}
+	p := &Provider{config: c}
+	if err := p.getJSON(ctx, strings.TrimSuffix(c.Issuer, "/")+"/.well-known/openid-configuration", &p.metadata); err != nil {
+		return nil, err
+	}
+	if p.metadata.Issuer != c.Issuer {
+		return nil, fmt.Errorf("oidc: provider configuration names issuer %q, not %q", p.metadata.Issuer, c.Issuer)
+	}
+	return p, nil
+}
+
+func (p *Provider) client() *http.Client {
+	if p.config.HTTPClient != nil {
+		return p.config.HTTPClient
+	}
+	return http.DefaultClient
+}
+
+func (p *Provider) now() time.Time {
+	if p.config.Clock != nil {
+		return p.config.Clock.Now()
+	}
+	return handler.SystemClock.Now()
+}
+
+func (p *Provider) principal(claims Claims) (string, error) {
+	if p.config.Principal != nil {
+		return p.config.Principal(claims)
+	}
+	return claims.String("iss") + "|" + claims.Subject(), nil
+}
+
+func randomString() string {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		panic(err)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+// isLocalPath reports whether the path refers to a resource on this site, rather than on some
+// other site, as "//evil.example" and "/\evil.example" would to browsers.
+func isLocalPath(path string) bool {
+	return strings.HasPrefix(path, "/") && !strings.HasPrefix(path, "//") && !strings.HasPrefix(path, "/\\")
+}
+
+func defaultOnError(w http.ResponseWriter, _ *http.Request, _ error) {
+	w.WriteHeader(http.StatusUnauthorized)
+}
+
+// LoginHandler returns an HTTP handler that begins a login, recording a fresh state, nonce, and
+// PKCE code verifier in the singular session bound to the request via WithSession, and redirecting
+// to the provider's authorization endpoint. If the request bears a "return_to" query parameter
+// naming a path on this site, CallbackHandler redirects there once the login completes.
+//
+// If no session is bound to the request or saving the session fails, it delegates further request
+// processing to the onError handler. If no such onError handler is supplied, it will respond with
+// HTTP status code 401 with no body.
+func (p *Provider) LoginHandler(onError func(w http.ResponseWriter, r *http.Request, err error)) http.Handler {
+	if onError == nil {
+		onError = defaultOnError
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := handler.ExtractSessionE(r)
+		if err != nil {
+			onError(w, r, err)
+			return
+		}
+		state, nonce, verifier := randomString(), randomString(), randomString()
+		session.Values[stateKey] = state
+		session.Values[nonceKey] = nonce
+		session.Values[verifierKey] = verifier
+		if returnTo := r.URL.Query().Get("return_to"); isLocalPath(returnTo) {
+			session.Values[returnToKey] = returnTo
+		} else {
+			delete(session.Values, returnToKey)
+		}
+		if err := session.Save(r, w); err != nil {
+			onError(w, r, err)
+			return
+		}
+		challenge := sha256.Sum256([]byte(verifier))
+		q := url.Values{
+			"response_type":         {"code"},
+			"client_id":             {p.config.ClientID},
+			"redirect_uri":          {p.config.RedirectURL},
+			"scope":                 {strings.Join(append([]string{"openid"}, p.config.Scopes...), " ")},
+			"state":                 {state},
+			"nonce":                 {nonce},
+			"code_challenge":        {base64.RawURLEncoding.EncodeToString(challenge[:])},
+			"code_challenge_method": {"S256"},
+		}
+		target := p.metadata.AuthorizationEndpoint
+		if strings.Contains(target, "?") {
+			target += "&" + q.Encode()
+		} else {
+			target += "?" + q.Encode()
+		}
+		http.Redirect(w, r, target, http.StatusFound)
+	})
+}
+
+type tokenResponse struct {
+	AccessToken      string `json:"access_token"`
+	TokenType        string `json:"token_type"`
+	RefreshToken     string `json:"refresh_token"`
+	ExpiresIn        int64  `json:"expires_in"`
+	IDToken          string `json:"id_token"`
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description"`
+}
+
+// requestTokens submits the form to the provider's token endpoint, authenticating as the client.
+func (p *Provider) requestTokens(ctx context.Context, form url.Values) (*Token, error) {
+	if len(p.config.ClientSecret) == 0 {
+		form.Set("client_id", p.config.ClientID)
+	}
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, p.metadata.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	r.Header.Set("Accept", "application/json")
+	if len(p.config.ClientSecret) != 0 {
+		r.SetBasicAuth(url.QueryEscape(p.config.ClientID), url.QueryEscape(p.config.ClientSecret))
+	}
+	res, err := p.client().Do(r)
+	if err != nil {
+		return nil, err
+	}
+	defer res.Body.Close()
+	var tr tokenResponse
+	if err := json.NewDecoder(res.Body).Decode(&tr); err != nil {
+		return nil, fmt.Errorf("oidc: decoding token response with status %s: %w", res.Status, err)
+	}
+	if len(tr.Error) != 0 {
+		return nil, &ProviderError{Code: tr.Error, Description: tr.ErrorDescription}
+	}
+	if res.StatusCode != http.StatusOK || len(tr.AccessToken) == 0 {
+		return nil, fmt.Errorf("oidc: unexpected token response with status %s", res.Status)
+	}
+	t := &Token{
+		AccessToken:  tr.AccessToken,
+		TokenType:    tr.TokenType,
+		RefreshToken: tr.RefreshToken,
+		IDToken:      tr.IDToken,
+	}
+	if tr.ExpiresIn > 0 {
+		t.Expiry = p.now().Add(time.Duration(tr.ExpiresIn) * time.Second)
+	}
+	return t, nil
+}
+
+// CallbackHandler returns an HTTP handler that completes a login begun by LoginHandler, serving
+// the Config's RedirectURL. It checks that the request's state matches that recorded in the
+// singular session bound to the request via WithSession, exchanges the authorization code for
+// tokens, and verifies the ID token. It then records the principal derived from the ID token's
+// claims in the session per handler.SetPrincipal, saves the session under a fresh ID, so that a
+// session ID planted before the login can't acquire the principal, and redirects to the path
+// named when the login began.
+//
+// If any step fails, it delegates further request processing to the onError handler, with an
+// error such as ErrInvalidState, ErrInvalidIDToken, or a *ProviderError. If no such onError
+// handler is supplied, it will respond with HTTP status code 401 with no body.
+func (p *Provider) CallbackHandler(onError func(w http.ResponseWriter, r *http.Request, err error)) http.Handler {
+	if onError == nil {
+		onError = defaultOnError
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, err := handler.ExtractSessionE(r)
+		if err != nil {
+			onError(w, r, err)
+			return
+		}
+		q := r.URL.Query()
+		if code := q.Get("error"); len(code) != 0 {
+			onError(w, r, &ProviderError{Code: code, Description: q.Get("error_description")})
+			return
+		}
+		state, _ := session.Values[stateKey].(string)
+		if len(state) == 0 || subtle.ConstantTimeCompare([]byte(state), []byte(q.Get("state"))) != 1 {
+			onError(w, r, ErrInvalidState)
+			return
+		}
+		nonce, _ := session.Values[nonceKey].(string)
+		verifier, _ := session.Values[verifierKey].(string)
+		returnTo, _ := session.Values[returnToKey].(string)
+		token, err := p.requestTokens(r.Context(), url.Values{
+			"grant_type":    {"authorization_code"},
+			"code":          {q.Get("code")},
+			"redirect_uri":  {p.config.RedirectURL},
+			"code_verifier": {verifier},
+		})
+		if err != nil {
+			onError(w, r, err)
+			return
+		}
+		claims, err := p.verifyIDToken(r.Context(), token.IDToken, nonce)
+		if err != nil {
+			onError(w, r, err)
+			return
+		}
+		principal, err := p.principal(claims)
+		if err != nil {
+			onError(w, r, err)
+			return
+		}
+		for _, k := range []string{stateKey, nonceKey, verifierKey, returnToKey} {
+			delete(session.Values, k)
+		}
+		handler.SetPrincipal(session, principal)
+		if p.config.StoreTokens {
+			session.Values[TokenKey] = *token
+		}
+		session.ID = ""
+		if err := session.Save(r, w); err != nil {
+			onError(w, r, err)
+			return
+		}
+		if !isLocalPath(returnTo) {
+			if returnTo = p.config.DefaultReturnTo; len(returnTo) == 0 {
+				returnTo = "/"
+			}
+		}
+		http.Redirect(w, r, returnTo, http.StatusSeeOther)
+	})
+}
@@ -0,0 +1,376 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package oidc_test
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/kvstore"
+	"github.com/seh/handler/oidc"
+)
+
+type pendingCode struct {
+	nonce       string
+	challenge   string
+	redirectURI string
+}
+
+// fakeIssuer is an OpenID Connect provider that authenticates every user as "alice".
+type fakeIssuer struct {
+	server   *httptest.Server
+	key      crypto.Signer
+	alg      string
+	clientID string
+	secret   string
+	// adjustClaims, if not nil, adjusts the claims of each ID token before signing it.
+	adjustClaims func(claims map[string]interface{})
+	// forger, if not nil, signs ID tokens in place of the published key.
+	forger    crypto.Signer
+	mu        sync.Mutex
+	codes     map[string]pendingCode
+	issued    int
+	refreshes int
+}
+
+func newFakeIssuer(t *testing.T, key crypto.Signer, alg string) *fakeIssuer {
+	f := &fakeIssuer{key: key, alg: alg, clientID: "app", secret: "s3cret", codes: make(map[string]pendingCode)}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", f.serveConfiguration)
+	mux.HandleFunc("/jwks", f.serveKeys)
+	mux.HandleFunc("/authorize", f.serveAuthorize)
+	mux.HandleFunc("/token", f.serveToken)
+	f.server = httptest.NewServer(mux)
+	t.Cleanup(f.server.Close)
+	return f
+}
+
+func encodeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func (f *fakeIssuer) serveConfiguration(w http.ResponseWriter, r *http.Request) {
+	encodeJSON(w, http.StatusOK, map[string]string{
+		"issuer":                 f.server.URL,
+		"authorization_endpoint": f.server.URL + "/authorize",
+		"token_endpoint":         f.server.URL + "/token",
+		"jwks_uri":               f.server.URL + "/jwks",
+	})
+}
+
+func encodeBigInt(i *big.Int, size int) string {
+	b := i.Bytes()
+	if len(b) < size {
+		b = append(make([]byte, size-len(b)), b...)
+	}
+	return base64.RawURLEncoding.EncodeToString(b)
+}
+
+func (f *fakeIssuer) serveKeys(w http.ResponseWriter, r *http.Request) {
+	key := map[string]string{"kid": "k1", "use": "sig"}
+	switch pub := f.key.Public().(type) {
+	case *rsa.PublicKey:
+		key["kty"] = "RSA"
+		key["n"] = encodeBigInt(pub.N, 0)
+		key["e"] = encodeBigInt(big.NewInt(int64(pub.E)), 0)
+	case *ecdsa.PublicKey:
+		key["kty"] = "EC"
+		key["crv"] = "P-256"
+		key["x"] = encodeBigInt(pub.X, 32)
+		key["y"] = encodeBigInt(pub.Y, 32)
+	}
+	encodeJSON(w, http.StatusOK, map[string]interface{}{"keys": []interface{}{key}})
+}
+
+func (f *fakeIssuer) serveAuthorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("client_id") != f.clientID || q.Get("response_type") != "code" || q.Get("code_challenge_method") != "S256" || !strings.Contains(q.Get("scope"), "openid") {
+		http.Error(w, "bad authorization request", http.StatusBadRequest)
+		return
+	}
+	f.mu.Lock()
+	f.issued++
+	code := "code" + string(rune('0'+f.issued))
+	f.codes[code] = pendingCode{nonce: q.Get("nonce"), challenge: q.Get("code_challenge"), redirectURI: q.Get("redirect_uri")}
+	f.mu.Unlock()
+	http.Redirect(w, r, q.Get("redirect_uri")+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+}
+
+func (f *fakeIssuer) sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": f.alg, "kid": "k1", "typ": "JWT"})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	digest := sha256.Sum256([]byte(signed))
+	signer := f.key
+	if f.forger != nil {
+		signer = f.forger
+	}
+	var sig []byte
+	switch key := signer.(type) {
+	case *rsa.PrivateKey:
+		sig, _ = rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	case *ecdsa.PrivateKey:
+		r, s, _ := ecdsa.Sign(rand.Reader, key, digest[:])
+		sig = append(encodeBigIntBytes(r, 32), encodeBigIntBytes(s, 32)...)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
+
+func encodeBigIntBytes(i *big.Int, size int) []byte {
+	b := i.Bytes()
+	return append(make([]byte, size-len(b)), b...)
+}
+
+func (f *fakeIssuer) serveToken(w http.ResponseWriter, r *http.Request) {
+	if id, secret, ok := r.BasicAuth(); !ok || id != f.clientID || secret != f.secret {
+		encodeJSON(w, http.StatusUnauthorized, map[string]string{"error": "invalid_client"})
+		return
+	}
+	if r.PostFormValue("grant_type") == "refresh_token" {
+		f.serveRefresh(w, r)
+		return
+	}
+	f.mu.Lock()
+	pending, ok := f.codes[r.PostFormValue("code")]
+	delete(f.codes, r.PostFormValue("code"))
+	f.mu.Unlock()
+	challenge := sha256.Sum256([]byte(r.PostFormValue("code_verifier")))
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != pending.redirectURI ||
+		base64.RawURLEncoding.EncodeToString(challenge[:]) != pending.challenge {
+		encodeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	claims := map[string]interface{}{
+		"iss":   f.server.URL,
+		"sub":   "alice",
+		"aud":   f.clientID,
+		"exp":   time.Now().Add(time.Hour).Unix(),
+		"iat":   time.Now().Unix(),
+		"nonce": pending.nonce,
+	}
+	if f.adjustClaims != nil {
+		f.adjustClaims(claims)
+	}
+	encodeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  "access",
+		"token_type":    "Bearer",
+		"refresh_token": "refresh",
+		"expires_in":    3600,
+		"id_token":      f.sign(claims),
+	})
+}
+
+// serveRefresh issues fresh tokens in exchange for the latest refresh token, revoking it.
+func (f *fakeIssuer) serveRefresh(w http.ResponseWriter, r *http.Request) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	want := "refresh"
+	if f.refreshes != 0 {
+		want = fmt.Sprintf("refresh%d", f.refreshes)
+	}
+	if r.PostFormValue("refresh_token") != want {
+		encodeJSON(w, http.StatusBadRequest, map[string]string{"error": "invalid_grant"})
+		return
+	}
+	f.refreshes++
+	encodeJSON(w, http.StatusOK, map[string]interface{}{
+		"access_token":  fmt.Sprintf("access%d", f.refreshes),
+		"token_type":    "Bearer",
+		"refresh_token": fmt.Sprintf("refresh%d", f.refreshes),
+		"expires_in":    3600,
+	})
+}
+
+// app serves LoginHandler at "/login", CallbackHandler at "/callback", and responds to all other
+// requests with the session's principal.
+type app struct {
+	client   *handlertest.Client
+	store    *kvstore.Store
+	provider *oidc.Provider
+	errs     []error
+}
+
+func newApp(t *testing.T, issuer *fakeIssuer, adjust func(c *oidc.Config)) *app {
+	a := &app{store: kvstore.New(kvstore.NewMemory(), securecookie.GenerateRandomKey(32))}
+	mux := http.NewServeMux()
+	onError := func(w http.ResponseWriter, r *http.Request, err error) {
+		a.errs = append(a.errs, err)
+		w.WriteHeader(http.StatusUnauthorized)
+	}
+	mux.Handle("/login", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.provider.LoginHandler(onError).ServeHTTP(w, r)
+	}))
+	mux.Handle("/callback", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		a.provider.CallbackHandler(onError).ServeHTTP(w, r)
+	}))
+	mux.HandleFunc("/", func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := handler.SessionPrincipal(handler.MustExtractSession(r))
+		io.WriteString(w, r.URL.Path+" "+principal)
+	})
+	a.client = handlertest.NewClient(t, handler.WithSession("s", a.store, mux, nil), a.store)
+	c := oidc.Config{
+		Issuer:       issuer.server.URL,
+		ClientID:     issuer.clientID,
+		ClientSecret: issuer.secret,
+		RedirectURL:  a.client.Server.URL + "/callback",
+		Scopes:       []string{"email"},
+	}
+	if adjust != nil {
+		adjust(&c)
+	}
+	var err error
+	if a.provider, err = oidc.NewProvider(context.Background(), c); err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+	return a
+}
+
+func (a *app) get(t *testing.T, path string) (int, string) {
+	t.Helper()
+	res := a.client.Get(path)
+	defer res.Body.Close()
+	b, _ := io.ReadAll(res.Body)
+	return res.StatusCode, string(b)
+}
+
+func TestLogin(t *testing.T) {
+	rsaKey, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatalf("failed to generate RSA key: %v", err)
+	}
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatalf("failed to generate ECDSA key: %v", err)
+	}
+	for _, test := range []struct {
+		alg string
+		key crypto.Signer
+	}{
+		{"RS256", rsaKey},
+		{"ES256", ecKey},
+	} {
+		t.Run(test.alg, func(t *testing.T) {
+			issuer := newFakeIssuer(t, test.key, test.alg)
+			a := newApp(t, issuer, func(c *oidc.Config) { c.StoreTokens = true })
+			a.client.HTTP.CheckRedirect = func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }
+			res := a.client.Get("/login?return_to=/account")
+			res.Body.Close()
+			before := a.client.Cookie("s")
+			a.client.HTTP.CheckRedirect = nil
+			res, err := a.client.HTTP.Get(res.Header.Get("Location"))
+			if err != nil {
+				t.Fatalf("failed to follow redirect to provider: %v", err)
+			}
+			defer res.Body.Close()
+			b, _ := io.ReadAll(res.Body)
+			status, body := res.StatusCode, string(b)
+			if got, want := status, http.StatusOK; got != want {
+				t.Fatalf("status code: got %d, want %d (errors: %v)", got, want, a.errs)
+			}
+			if got, want := body, "/account "+issuer.server.URL+"|alice"; got != want {
+				t.Errorf("response: got %q, want %q", got, want)
+			}
+			if after := a.client.Cookie("s"); before == nil || after == nil || after.Value == before.Value {
+				t.Error("session was not rotated upon login")
+			}
+			token, ok := a.client.Session("s").Values[oidc.TokenKey].(oidc.Token)
+			if !ok || token.AccessToken != "access" || token.RefreshToken != "refresh" || token.Expiry.IsZero() {
+				t.Errorf("stored token: got %+v", token)
+			}
+		})
+	}
+}
+
+func TestLoginRedirectsOnlyLocally(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a := newApp(t, newFakeIssuer(t, key, "ES256"), func(c *oidc.Config) { c.DefaultReturnTo = "/home" })
+	for _, returnTo := range []string{"", "//evil.example", "https://evil.example/"} {
+		if _, body := a.get(t, "/login?return_to="+url.QueryEscape(returnTo)); !strings.HasPrefix(body, "/home ") {
+			t.Errorf("response for return path %q: got %q", returnTo, body)
+		}
+	}
+}
+
+func TestCallbackRejectsMismatchedState(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a := newApp(t, newFakeIssuer(t, key, "ES256"), nil)
+	if status, _ := a.get(t, "/callback?code=code1&state=forged"); status != http.StatusUnauthorized {
+		t.Errorf("status code: got %d, want %d", status, http.StatusUnauthorized)
+	}
+	if len(a.errs) != 1 || a.errs[0] != oidc.ErrInvalidState {
+		t.Errorf("errors: got %v, want [%v]", a.errs, oidc.ErrInvalidState)
+	}
+}
+
+func TestCallbackReportsProviderError(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	a := newApp(t, newFakeIssuer(t, key, "ES256"), nil)
+	a.get(t, "/callback?error=access_denied&error_description=no")
+	var perr *oidc.ProviderError
+	if len(a.errs) != 1 || !errors.As(a.errs[0], &perr) || perr.Code != "access_denied" {
+		t.Errorf("errors: got %v, want a provider error", a.errs)
+	}
+}
+
+func TestCallbackRejectsInvalidIDTokens(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	otherKey, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	for description, adjust := range map[string]func(issuer *fakeIssuer, claims map[string]interface{}){
+		"wrong audience": func(_ *fakeIssuer, claims map[string]interface{}) { claims["aud"] = "other" },
+		"wrong issuer":   func(_ *fakeIssuer, claims map[string]interface{}) { claims["iss"] = "https://evil.example" },
+		"wrong nonce":    func(_ *fakeIssuer, claims map[string]interface{}) { claims["nonce"] = "replayed" },
+		"expired":        func(_ *fakeIssuer, claims map[string]interface{}) { claims["exp"] = time.Now().Add(-time.Hour).Unix() },
+		"bad signature":  func(issuer *fakeIssuer, _ map[string]interface{}) { issuer.forger = otherKey },
+	} {
+		t.Run(description, func(t *testing.T) {
+			issuer := newFakeIssuer(t, key, "ES256")
+			a := newApp(t, issuer, nil)
+			issuer.adjustClaims = func(claims map[string]interface{}) { adjust(issuer, claims) }
+			if status, _ := a.get(t, "/login"); status != http.StatusUnauthorized {
+				t.Errorf("status code: got %d, want %d", status, http.StatusUnauthorized)
+			}
+			if len(a.errs) != 1 || !errors.Is(a.errs[0], oidc.ErrInvalidIDToken) {
+				t.Errorf("errors: got %v, want [%v]", a.errs, oidc.ErrInvalidIDToken)
+			}
+			if _, ok := handler.SessionPrincipal(a.client.Session("s")); ok {
+				t.Error("principal was established")
+			}
+		})
+	}
+}
+
+func TestNewProvider(t *testing.T) {
+	ctx := context.Background()
+	if _, err := oidc.NewProvider(ctx, oidc.Config{}); err == nil {
+		t.Error("created provider with empty configuration")
+	}
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	issuer := newFakeIssuer(t, key, "ES256")
+	if _, err := oidc.NewProvider(ctx, oidc.Config{Issuer: issuer.server.URL + "/", ClientID: "app", RedirectURL: "https://app.example/callback"}); err == nil {
+		t.Error("created provider for mismatched issuer")
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package oidc
+
+import (
+	"context"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+// refreshCall is a refresh of the tokens issued with a given refresh token, either in progress or
+// recently completed.
+type refreshCall struct {
+	done  chan struct{}
+	token *Token
+	err   error
+	at    time.Time
+}
+
+// recentRefreshWindow is how long a Provider remembers the outcome of refreshing the tokens issued
+// with a given refresh token, so that requests that were already in flight with the former tokens
+// receive the refreshed ones rather than attempting to use a refresh token that the provider may
+// have since revoked.
+const recentRefreshWindow = 30 * time.Second
+
+// refresh exchanges the refresh token for fresh tokens, sharing the outcome among concurrent and
+// recent callers bearing the same refresh token.
+func (p *Provider) refresh(ctx context.Context, old Token) (*Token, error) {
+	p.mu.Lock()
+	now := p.now()
+	for k, c := range p.refreshes {
+		if !c.at.IsZero() && now.Sub(c.at) > recentRefreshWindow {
+			delete(p.refreshes, k)
+		}
+	}
+	if c, ok := p.refreshes[old.RefreshToken]; ok {
+		p.mu.Unlock()
+		<-c.done
+		return c.token, c.err
+	}
+	c := &refreshCall{done: make(chan struct{})}
+	if p.refreshes == nil {
+		p.refreshes = make(map[string]*refreshCall)
+	}
+	p.refreshes[old.RefreshToken] = c
+	p.mu.Unlock()
+
+	c.token, c.err = p.requestTokens(ctx, url.Values{
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {old.RefreshToken},
+	})
+	if c.err == nil {
+		// Providers needn't issue a fresh refresh token or ID token upon each refresh.
+		if len(c.token.RefreshToken) == 0 {
+			c.token.RefreshToken = old.RefreshToken
+		}
+		if len(c.token.IDToken) == 0 {
+			c.token.IDToken = old.IDToken
+		}
+	}
+	p.mu.Lock()
+	if c.err != nil {
+		// Let the next caller try again, rather than sharing a possibly transient failure.
+		delete(p.refreshes, old.RefreshToken)
+	} else {
+		c.at = p.now()
+	}
+	p.mu.Unlock()
+	close(c.done)
+	return c.token, c.err
+}
+
+// ExtractToken returns the tokens recorded under TokenKey in the singular session bound to the
+// request via WithSession, as refreshed by RefreshTokens if it's in use, together with a boolean
+// indicating whether any such tokens are present.
+func ExtractToken(r *http.Request) (Token, bool) {
+	session, ok := handler.ExtractSession(r)
+	if !ok {
+		return Token{}, false
+	}
+	t, ok := session.Values[TokenKey].(Token)
+	return t, ok
+}
+
+// RefreshTokens returns an HTTP handler that refreshes the tokens recorded under TokenKey in the
+// singular session bound to the request via WithSession, such as by CallbackHandler with
+// Config.StoreTokens set, once the access token expires within the given leeway. It records the
+// refreshed tokens in the session and saves it before delegating further request processing to
+// the supplied handler, which can retrieve the current tokens with ExtractToken. It delegates
+// requests bearing no tokens, or tokens with no refresh token or expiration time, to the supplied
+// handler unchanged.
+//
+// Concurrent requests in the same session share a single refresh, as do requests bearing the
+// former tokens that arrive shortly after a refresh completes, since providers may revoke a
+// refresh token once they've used it.
+//
+// If refreshing the tokens or saving the session fails, it delegates further request processing
+// to the onError handler. If no such onError handler is supplied, it will respond with HTTP status
+// code 401 with no body. It panics if the supplied handler is nil.
+func (p *Provider) RefreshTokens(h http.Handler, leeway time.Duration, onError func(w http.ResponseWriter, r *http.Request, err error)) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	if onError == nil {
+		onError = defaultOnError
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session, ok := handler.ExtractSession(r)
+		if !ok {
+			h.ServeHTTP(w, r)
+			return
+		}
+		token, ok := session.Values[TokenKey].(Token)
+		if !ok || len(token.RefreshToken) == 0 || token.Expiry.IsZero() || p.now().Add(leeway).Before(token.Expiry) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		refreshed, err := p.refresh(r.Context(), token)
+		if err != nil {
+			onError(w, r, err)
+			return
+		}
+		session.Values[TokenKey] = *refreshed
+		if err := session.Save(r, w); err != nil {
+			onError(w, r, err)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,113 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package oidc_test
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+	"github.com/seh/handler/oidc"
+)
+
+func TestRefreshTokensPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	(&oidc.Provider{}).RefreshTokens(nil, 0, nil)
+}
+
+func ensurePanicWithValueOccured(t *testing.T) {
+	if p := recover(); p == nil {
+		t.Error("panic was not called with a non-nil argument")
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	key, _ := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	issuer := newFakeIssuer(t, key, "ES256")
+	clock := handlertest.NewFakeClock(time.Now())
+	provider, err := oidc.NewProvider(context.Background(), oidc.Config{
+		Issuer:       issuer.server.URL,
+		ClientID:     issuer.clientID,
+		ClientSecret: issuer.secret,
+		RedirectURL:  "https://app.example/callback",
+		Clock:        clock,
+	})
+	if err != nil {
+		t.Fatalf("failed to create provider: %v", err)
+	}
+	// A cookie store lets requests replay sessions bearing former tokens.
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	var errs []error
+	h := handler.WithSession("s", store, provider.RefreshTokens(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token, _ := oidc.ExtractToken(r)
+		io.WriteString(w, token.AccessToken)
+	}), time.Minute, func(w http.ResponseWriter, r *http.Request, err error) {
+		errs = append(errs, err)
+		w.WriteHeader(http.StatusUnauthorized)
+	}), nil)
+	serve := func(cookie *http.Cookie) *httptest.ResponseRecorder {
+		r := httptest.NewRequest("", "/", nil)
+		r.AddCookie(cookie)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		return recorder
+	}
+
+	session := sessions.NewSession(store, "s")
+	session.Options = store.Options
+	session.Values[oidc.TokenKey] = oidc.Token{AccessToken: "access", RefreshToken: "refresh", Expiry: clock.Now().Add(10 * time.Minute)}
+	recorder := httptest.NewRecorder()
+	if err := session.Save(httptest.NewRequest("", "/", nil), recorder); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	cookie := recorder.Result().Cookies()[0]
+	if got, want := serve(cookie).Body.String(), "access"; got != want {
+		t.Errorf("access token: got %q, want %q", got, want)
+	}
+
+	clock.Advance(9*time.Minute + 30*time.Second)
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			bodies[i] = serve(cookie).Body.String()
+		}(i)
+	}
+	wg.Wait()
+	for i, body := range bodies {
+		if got, want := body, "access1"; got != want {
+			t.Errorf("access token for request %d: got %q, want %q", i, got, want)
+		}
+	}
+	// A request bearing the former tokens shortly afterward shares the same refresh.
+	recorder = serve(cookie)
+	if got, want := recorder.Body.String(), "access1"; got != want {
+		t.Errorf("access token: got %q, want %q", got, want)
+	}
+	if got, want := issuer.refreshes, 1; got != want {
+		t.Errorf("refreshes: got %d, want %d", got, want)
+	}
+
+	cookie = recorder.Result().Cookies()[0]
+	clock.Advance(time.Hour)
+	if got, want := serve(cookie).Body.String(), "access2"; got != want {
+		t.Errorf("access token: got %q, want %q", got, want)
+	}
+	if len(errs) != 0 {
+		t.Errorf("errors: got %v", errs)
+	}
+}
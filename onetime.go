@@ -0,0 +1,106 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+)
+
+// OneTimeTokens issues and consumes single-use, expiring tokens that vouch for a principal for a
+// given purpose, such as resetting a password, verifying an email address, or logging in by way of
+// a link sent by email.
+type OneTimeTokens struct {
+	// Tokens holds the principal for which each outstanding token was issued.
+	Tokens TokenStore
+	// Param is the name of the query or form parameter bearing the token in requests to handlers
+	// returned by ConsumeToken. If empty, they use "token".
+	Param string
+}
+
+func (o *OneTimeTokens) param() string {
+	if len(o.Param) != 0 {
+		return o.Param
+	}
+	return "token"
+}
+
+// oneTimeTokenKey derives the key under which to store the principal for the token with the given
+// key, as returned by newToken, such that a token issued for one purpose isn't found, and so isn't
+// consumed, when presented for another.
+func oneTimeTokenKey(purpose, key string) string {
+	return purpose + "\x00" + key
+}
+
+// IssueToken returns a fresh token vouching for the given principal for the given purpose, which
+// ConsumeToken accepts once within the given time to live. It panics if the purpose or principal
+// is empty.
+func (o *OneTimeTokens) IssueToken(ctx context.Context, purpose, principal string, ttl time.Duration) (string, error) {
+	if len(purpose) == 0 {
+		panic("no token purpose supplied")
+	}
+	if len(principal) == 0 {
+		panic("no token principal supplied")
+	}
+	token, key := newToken()
+	if err := o.Tokens.Put(ctx, oneTimeTokenKey(purpose, key), []byte(principal), ttl); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+type tokenPurposeContextKey struct{}
+
+// ExtractTokenPurpose retrieves the purpose of the one-time token consumed by ConsumeToken for this
+// request, together with a boolean indicating whether any such token was consumed.
+func ExtractTokenPurpose(r *http.Request) (string, bool) {
+	p, ok := r.Context().Value(tokenPurposeContextKey{}).(string)
+	return p, ok
+}
+
+// ConsumeToken returns an HTTP handler that consumes the one-time token borne by the request's
+// query or form parameter, per OneTimeTokens.Param, provided that IssueToken issued it for the
+// given purpose and that it hasn't expired or been consumed already. It binds the principal for
+// which the token was issued to the request via BindPrincipal, and the purpose such that
+// ExtractTokenPurpose reports it, before delegating further request processing to the supplied
+// handler. The supplied handler is responsible for establishing a session for the principal, if
+// appropriate.
+//
+// Note that some mail systems fetch the links within messages to scan them, which would consume
+// tokens borne by such links in GET requests. Consider serving a page from which the user submits
+// the token in a POST request instead.
+//
+// It delegates requests bearing no such token to the onReject handler, as well as requests for
+// which consulting the TokenStore fails. If no such onReject handler is supplied, it will respond
+// with HTTP status code 403 with no body. It panics if the purpose is empty or if the supplied
+// handler is nil.
+func (o *OneTimeTokens) ConsumeToken(purpose string, h http.Handler, onReject http.Handler) http.Handler {
+	if len(purpose) == 0 {
+		panic("no token purpose supplied")
+	}
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	if onReject == nil {
+		onReject = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+	param := o.param()
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.FormValue(param)
+		if len(token) == 0 {
+			onReject.ServeHTTP(w, r)
+			return
+		}
+		principal, err := o.Tokens.Take(r.Context(), oneTimeTokenKey(purpose, tokenKey(token)))
+		if err != nil || len(principal) == 0 {
+			onReject.ServeHTTP(w, r)
+			return
+		}
+		r = BindPrincipal(r, string(principal))
+		h.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), tokenPurposeContextKey{}, purpose)))
+	})
+}
@@ -0,0 +1,87 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+)
+
+func TestConsumeTokenPanicsWithNoPurpose(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	(&handler.OneTimeTokens{}).ConsumeToken("", http.NotFoundHandler(), nil)
+}
+
+func TestConsumeTokenPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	(&handler.OneTimeTokens{}).ConsumeToken("reset", nil, nil)
+}
+
+func TestOneTimeTokens(t *testing.T) {
+	ctx := context.Background()
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	tokens := handler.OneTimeTokens{Tokens: &handler.MemoryTokenStore{Clock: clock}}
+	issue := func(purpose string) string {
+		token, err := tokens.IssueToken(ctx, purpose, "alice", time.Hour)
+		if err != nil {
+			t.Fatalf("failed to issue token: %v", err)
+		}
+		return token
+	}
+	h := tokens.ConsumeToken("reset", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, _ := handler.ExtractPrincipal(r)
+		purpose, _ := handler.ExtractTokenPurpose(r)
+		w.Write([]byte(purpose + ":" + principal))
+	}), nil)
+	consume := func(r *http.Request) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		return recorder
+	}
+	get := func(token string) *httptest.ResponseRecorder {
+		return consume(httptest.NewRequest(http.MethodGet, "/reset?"+url.Values{"token": {token}}.Encode(), nil))
+	}
+
+	token := issue("reset")
+	if recorder := get(token); recorder.Code != http.StatusOK || recorder.Body.String() != "reset:alice" {
+		t.Errorf("first use: got status %d with %q, want %d with %q", recorder.Code, recorder.Body.String(), http.StatusOK, "reset:alice")
+	}
+	if got, want := get(token).Code, http.StatusForbidden; got != want {
+		t.Errorf("status for second use: got %d, want %d", got, want)
+	}
+
+	verify := issue("verify")
+	if got, want := get(verify).Code, http.StatusForbidden; got != want {
+		t.Errorf("status for token issued for other purpose: got %d, want %d", got, want)
+	}
+	recorder := httptest.NewRecorder()
+	tokens.ConsumeToken("verify", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), nil).
+		ServeHTTP(recorder, httptest.NewRequest(http.MethodGet, "/verify?token="+verify, nil))
+	if got, want := recorder.Code, http.StatusOK; got != want {
+		t.Errorf("status for token presented for its own purpose: got %d, want %d", got, want)
+	}
+
+	r := httptest.NewRequest(http.MethodPost, "/reset", strings.NewReader(url.Values{"token": {issue("reset")}}.Encode()))
+	r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if got, want := consume(r).Code, http.StatusOK; got != want {
+		t.Errorf("status for token in form: got %d, want %d", got, want)
+	}
+
+	expiring := issue("reset")
+	clock.Advance(time.Hour)
+	if got, want := get(expiring).Code, http.StatusForbidden; got != want {
+		t.Errorf("status for expired token: got %d, want %d", got, want)
+	}
+	if got, want := get("").Code, http.StatusForbidden; got != want {
+		t.Errorf("status for missing token: got %d, want %d", got, want)
+	}
+}
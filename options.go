@@ -0,0 +1,196 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// Option adjusts how WithSession and WithSessionsNamed bind sessions to requests.
+type Option func(*bindingConfig)
+
+type bindingConfig struct {
+	disabled bool
+	// projection holds the keys of the values with which to populate sessions, if not nil.
+	projection []interface{}
+	// retainedKeys holds the keys of values that Options consult, with which to populate sessions
+	// in addition to those in any projection.
+	retainedKeys []interface{}
+	// preparers adjust each session after acquiring it, before binding it to the request.
+	preparers []func(r *http.Request, s *sessions.Session)
+	// decorators replace each session after the preparers adjust it, such as with a copy whose
+	// store intercepts saving.
+	decorators []func(r *http.Request, s *sessions.Session) *sessions.Session
+	// staleCheckers detect session cookies encoded in a way that's no longer current, for AutoSave
+	// to re-encode.
+	staleCheckers []StaleChecker
+	// saveHooks save sessions just before the response header is written, given the sessions bound
+	// to the request. They run before the responseHooks, so that those see any cookies set by
+	// saving.
+	saveHooks []func(h http.Header, r *http.Request, bound []*sessions.Session)
+	// responseHooks adjust the response header just before it's written, given the sessions bound
+	// to the request.
+	responseHooks []func(h http.Header, r *http.Request, bound []*sessions.Session)
+	// fallbackSuffix, if not empty, is the suffix of the names of the cookies that
+	// SameSiteNoneFallback pairs with session cookies.
+	fallbackSuffix string
+	// recycle requests that fresh sessions return to their source for reuse, via recycler, once
+	// the consuming handler returns.
+	recycle  bool
+	recycler Recycler
+	// errorCounters, if not nil, counts errors arising from acquiring and saving sessions.
+	errorCounters ErrorCounters
+	// statusMapper, if not nil, chooses the status codes of responses to errors arising from
+	// acquiring sessions when no onError handler is supplied.
+	statusMapper *StatusMapper
+	// overloadDetector, if not nil, detects errors arising from acquiring sessions that indicate
+	// overload, in place of DetectRetryAdvisor.
+	overloadDetector OverloadDetector
+	// clock, if not nil, reports the time for Options that record it, such as Audit, in place of
+	// SystemClock.
+	clock Clock
+	// guardLateSaves requests that the response writer be made available to GuardLateSaves through
+	// the request's context.
+	guardLateSaves bool
+}
+
+func newBindingConfig(opts []Option) *bindingConfig {
+	c := &bindingConfig{}
+	for _, o := range opts {
+		o(c)
+	}
+	return c
+}
+
+// source returns the SessionSource from which to acquire sessions, in place of the supplied one.
+func (c *bindingConfig) source(s SessionSource) SessionSource {
+	if c.disabled {
+		return NopSource{}
+	}
+	if c.recycle {
+		c.recycler, _ = s.(Recycler)
+	}
+	if c.projection != nil {
+		if ps, ok := s.(ProjectingSource); ok {
+			s = projectingSource{ps, append(append([]interface{}(nil), c.projection...), c.retainedKeys...)}
+		}
+	}
+	if len(c.fallbackSuffix) != 0 {
+		s = fallbackSource{s, c.fallbackSuffix}
+	}
+	if c.errorCounters != nil {
+		s = errorCountingSource{s, c.errorCounters}
+	}
+	return s
+}
+
+func (c *bindingConfig) prepare(r *http.Request, s *sessions.Session) *sessions.Session {
+	for _, p := range c.preparers {
+		p(r, s)
+	}
+	for _, d := range c.decorators {
+		s = d(r, s)
+	}
+	return s
+}
+
+func (c *bindingConfig) hooksResponse() bool {
+	return len(c.saveHooks) != 0 || len(c.responseHooks) != 0 || c.guardLateSaves
+}
+
+// serveHooked delegates to the given handler with a response writer that calls the response hooks
+// just before writing the response header.
+func (c *bindingConfig) serveHooked(h http.Handler, w http.ResponseWriter, r *http.Request, bound []*sessions.Session) {
+	hw := &hookedResponseWriter{
+		ResponseWriter: w,
+		beforeHeader: func(header http.Header) {
+			for _, hook := range c.saveHooks {
+				hook(header, r, bound)
+			}
+			for _, hook := range c.responseHooks {
+				hook(header, r, bound)
+			}
+		},
+	}
+	if c.guardLateSaves {
+		r = r.WithContext(context.WithValue(r.Context(), hookedResponseWriterKey{}, hw))
+	}
+	h.ServeHTTP(hw, r)
+	hw.finish()
+}
+
+// UseClock returns an Option that supplies the Clock that other Options consult when recording the
+// time of events, such as Audit and AuditMutations, in place of SystemClock, such as to substitute
+// a fake clock in tests. Options that accept a Clock of their own, such as RefreshNearExpiry, use
+// that one instead.
+func UseClock(clock Clock) Option {
+	return func(c *bindingConfig) {
+		c.clock = clock
+	}
+}
+
+// Disabled returns an Option that, if disabled is true, binds sessions acquired from NopSource in
+// place of those from the supplied SessionSource, which may then be nil. Request handlers can
+// still extract the bound sessions, but nothing they store in them persists beyond the request.
+//
+// It's intended for tests and for deployments that turn off session handling via configuration.
+func Disabled(disabled bool) Option {
+	return func(c *bindingConfig) {
+		c.disabled = disabled
+	}
+}
+
+// NopSource is a sessions.Store that always supplies fresh, empty sessions, and never persists
+// them. Saving its sessions succeeds, but does nothing.
+type NopSource struct{}
+
+// Get returns a fresh session with the given name.
+func (s NopSource) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return s.New(r, name)
+}
+
+// New returns a fresh session with the given name.
+func (s NopSource) New(_ *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.Options = &sessions.Options{Path: "/"}
+	session.IsNew = true
+	return session, nil
+}
+
+// Save does nothing.
+func (NopSource) Save(*http.Request, http.ResponseWriter, *sessions.Session) error {
+	return nil
+}
+
+// SecureFromRequest returns an Option that sets the Secure attribute of each bound session's
+// options, governing whether its cookie is restricted to HTTPS, according to whether the request
+// arrived over TLS. If trustForwardedProto is true, it also treats requests bearing an
+// X-Forwarded-Proto header with the value "https" as having arrived over TLS; enable that only
+// behind a proxy that sets or strips that header.
+//
+// This allows the same store configuration to serve both plain HTTP during local development and
+// HTTPS behind a TLS-terminating proxy in production.
+func SecureFromRequest(trustForwardedProto bool) Option {
+	return func(c *bindingConfig) {
+		c.preparers = append(c.preparers, func(r *http.Request, s *sessions.Session) {
+			if s.Options == nil {
+				return
+			}
+			s.Options.Secure = r.TLS != nil || trustForwardedProto && forwardedOverHTTPS(r)
+		})
+	}
+}
+
+func forwardedOverHTTPS(r *http.Request) bool {
+	proto := r.Header.Get("X-Forwarded-Proto")
+	// Proxies chained together may each append their own value.
+	if i := strings.IndexByte(proto, ','); i >= 0 {
+		proto = proto[:i]
+	}
+	return strings.EqualFold(strings.TrimSpace(proto), "https")
+}
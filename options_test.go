@@ -0,0 +1,97 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+var _ sessions.Store = handler.NopSource{}
+
+func TestNopSource(t *testing.T) {
+	r := httptest.NewRequest("", "/", nil)
+	session, err := handler.NopSource{}.New(r, "s")
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if !session.IsNew || session.Name() != "s" || len(session.Values) != 0 {
+		t.Errorf("session: got %+v, want a fresh session named %q", session, "s")
+	}
+	session.Values["k"] = "v"
+	recorder := httptest.NewRecorder()
+	if err := session.Save(r, recorder); err != nil {
+		t.Errorf("failed to save session: %v", err)
+	}
+	if got := len(recorder.Result().Cookies()); got != 0 {
+		t.Errorf("cookie count: got %d, want 0", got)
+	}
+}
+
+func TestDisabled(t *testing.T) {
+	var source countingSessionSource
+	h := func(extract func(r *http.Request) *sessions.Session) http.Handler {
+		return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			if _, ok := extract(r).Store().(handler.NopSource); !ok {
+				t.Error("bound session did not come from NopSource")
+			}
+		})
+	}
+	r := httptest.NewRequest("", "/", nil)
+	handler.WithSession("s", &source, h(handler.MustExtractSession), nil, handler.Disabled(true)).ServeHTTP(httptest.NewRecorder(), r)
+	handler.WithSessionsNamed([]string{"s"}, nil, h(func(r *http.Request) *sessions.Session {
+		return handler.MustExtractSessionNamed("s", r)
+	}), nil, handler.Disabled(true)).ServeHTTP(httptest.NewRecorder(), r)
+	if got := source.callCount(); got != 0 {
+		t.Errorf("source call count: got %d, want 0", got)
+	}
+
+	handler.WithSession("s", &source, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), nil, handler.Disabled(false)).ServeHTTP(httptest.NewRecorder(), r)
+	if got, want := source.callCount(), uint(1); got != want {
+		t.Errorf("source call count: got %d, want %d", got, want)
+	}
+}
+
+func TestSecureFromRequest(t *testing.T) {
+	tests := []struct {
+		description string
+		tls         bool
+		proto       string
+		trust       bool
+		want        bool
+	}{
+		{"plain", false, "", true, false},
+		{"TLS", true, "", false, true},
+		{"trusted proxy", false, "https", true, true},
+		{"trusted chained proxies", false, "HTTPS, http", true, true},
+		{"trusted plain proxy", false, "http", true, false},
+		{"untrusted proxy", false, "https", false, false},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			target := "http://example.com/"
+			if test.tls {
+				target = "https://example.com/"
+			}
+			r := httptest.NewRequest("", target, nil)
+			if len(test.proto) != 0 {
+				r.Header.Set("X-Forwarded-Proto", test.proto)
+			}
+			called := false
+			handler.WithSession("s", handler.NopSource{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				if got := handler.MustExtractSession(r).Options.Secure; got != test.want {
+					t.Errorf("secure: got %t, want %t", got, test.want)
+				}
+			}), nil, handler.SecureFromRequest(test.trust)).ServeHTTP(httptest.NewRecorder(), r)
+			if !called {
+				t.Error("delegate handler was not called")
+			}
+		})
+	}
+}
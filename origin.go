@@ -0,0 +1,98 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// normalizeOrigin returns the scheme and host of the URL in lowercase, omitting the port if it's
+// the scheme's default, or false if the URL is not absolute.
+func normalizeOrigin(s string) (string, bool) {
+	u, err := url.Parse(s)
+	if err != nil || len(u.Scheme) == 0 || len(u.Host) == 0 {
+		return "", false
+	}
+	scheme, host := strings.ToLower(u.Scheme), strings.ToLower(u.Host)
+	switch {
+	case scheme == "http" && strings.HasSuffix(host, ":80"):
+		host = strings.TrimSuffix(host, ":80")
+	case scheme == "https" && strings.HasSuffix(host, ":443"):
+		host = strings.TrimSuffix(host, ":443")
+	}
+	return scheme + "://" + host, true
+}
+
+// requestOrigin returns the origin claimed by the request's Origin header or, absent that, by its
+// Referer header, or false if it bears neither or the claimed origin is opaque.
+func requestOrigin(r *http.Request) (string, bool) {
+	if origin := r.Header.Get("Origin"); len(origin) != 0 {
+		return normalizeOrigin(origin)
+	}
+	if referer := r.Header.Get("Referer"); len(referer) != 0 {
+		return normalizeOrigin(referer)
+	}
+	return "", false
+}
+
+func isSafeMethod(method string) bool {
+	switch method {
+	case "", http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodTrace:
+		return true
+	}
+	return false
+}
+
+// SameOriginGuard returns an HTTP handler that rejects state-changing requests—those with methods
+// other than GET, HEAD, OPTIONS, and TRACE—that don't come from the request's own host or from one
+// of the allowed origins, such as "https://app.example.com", before delegating other requests to
+// the supplied handler. It judges a request's origin by its Origin header or, absent that, by its
+// Referer header, and rejects requests bearing neither, or bearing an opaque "null" origin.
+//
+// It's intended as defense in depth alongside session cookies bearing the SameSite attribute and
+// per-session CSRF tokens, not as a replacement for them. It delegates rejected requests to the
+// onReject handler. If no such onReject handler is supplied, it will respond with HTTP status code
+// 403 with no body. It panics if the supplied handler is nil or if any of the allowed origins is
+// not an absolute URL.
+func SameOriginGuard(allowed []string, h http.Handler, onReject http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	origins := make(map[string]struct{}, len(allowed))
+	for _, o := range allowed {
+		origin, ok := normalizeOrigin(o)
+		if !ok {
+			panic("allowed origin " + o + " is not an absolute URL")
+		}
+		origins[origin] = struct{}{}
+	}
+	if onReject == nil {
+		onReject = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if isSafeMethod(r.Method) {
+			h.ServeHTTP(w, r)
+			return
+		}
+		origin, ok := requestOrigin(r)
+		if ok {
+			if _, allowed := origins[origin]; !allowed {
+				// Compare the request's own host under the claimed origin's scheme, as a proxy
+				// terminating TLS in front of the server obscures the request's own scheme.
+				scheme := origin[:strings.Index(origin, "://")]
+				own, _ := normalizeOrigin(scheme + "://" + r.Host)
+				ok = origin == own
+			}
+		}
+		if !ok {
+			onReject.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,62 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/seh/handler"
+)
+
+func TestSameOriginGuardPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.SameOriginGuard(nil, nil, nil)
+}
+
+func TestSameOriginGuardPanicsWithRelativeOrigin(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.SameOriginGuard([]string{"app.example.com"}, http.NotFoundHandler(), nil)
+}
+
+func TestSameOriginGuard(t *testing.T) {
+	h := handler.SameOriginGuard([]string{"https://app.example.com"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), nil)
+	tests := []struct {
+		description string
+		method      string
+		header      string
+		value       string
+		want        int
+	}{
+		{"safe method without origin", http.MethodGet, "", "", http.StatusNoContent},
+		{"safe method from elsewhere", http.MethodGet, "Origin", "https://evil.example", http.StatusNoContent},
+		{"own origin", http.MethodPost, "Origin", "https://example.com", http.StatusNoContent},
+		{"own origin with default port", http.MethodPost, "Origin", "https://EXAMPLE.com:443", http.StatusNoContent},
+		{"own origin over plain HTTP", http.MethodPost, "Origin", "http://example.com", http.StatusNoContent},
+		{"own origin with other port", http.MethodPost, "Origin", "https://example.com:8443", http.StatusForbidden},
+		{"allowed origin", http.MethodPut, "Origin", "https://app.example.com", http.StatusNoContent},
+		{"allowed origin over plain HTTP", http.MethodPut, "Origin", "http://app.example.com", http.StatusForbidden},
+		{"other origin", http.MethodDelete, "Origin", "https://evil.example", http.StatusForbidden},
+		{"opaque origin", http.MethodPost, "Origin", "null", http.StatusForbidden},
+		{"own referer", http.MethodPost, "Referer", "https://example.com/form?x=1", http.StatusNoContent},
+		{"other referer", http.MethodPost, "Referer", "https://evil.example/form", http.StatusForbidden},
+		{"no origin or referer", http.MethodPost, "", "", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			r := httptest.NewRequest(test.method, "https://example.com/", nil)
+			if len(test.header) != 0 {
+				r.Header.Set(test.header, test.value)
+			}
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, r)
+			if got := recorder.Code; got != test.want {
+				t.Errorf("status code: got %d, want %d", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,38 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"github.com/gorilla/sessions"
+)
+
+// Recycler is implemented by SessionSources that can reuse the sessions they supply, such as
+// kvstore.Store, sparing the allocation of a session and its values for each request that arrives
+// with no session cookie.
+type Recycler interface {
+	// Recycle reclaims the session for reuse, discarding its values. Sources ignore sessions that
+	// they didn't supply or can't reuse.
+	Recycle(s *sessions.Session)
+}
+
+// RecycleSessions returns an Option that hands each bound session that was fresh when acquired
+// back to the SessionSource for reuse once the consuming handler returns, if the SessionSource
+// implements Recycler. Fresh sessions are those that carry no stored values, as for requests
+// bearing no session cookie, which are common on endpoints serving new visitors.
+//
+// Use it only where no handler retains a bound session, or its Values map, beyond the request,
+// such as in a goroutine that outlives the request; use Detach to take a copy that survives the
+// request instead.
+func RecycleSessions() Option {
+	return func(c *bindingConfig) {
+		c.recycle = true
+	}
+}
+
+// recycleSession hands the session back to the recycler if it was fresh when acquired.
+func (c *bindingConfig) recycleSession(s *sessions.Session, fresh bool) {
+	if fresh && c.recycler != nil {
+		c.recycler.Recycle(s)
+	}
+}
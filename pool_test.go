@@ -0,0 +1,71 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+// recyclingStore supplies fresh sessions for requests bearing no cookie, and otherwise sessions
+// that aren't new, recording those recycled.
+type recyclingStore struct {
+	simpleStore
+	recycled []*sessions.Session
+}
+
+func (s *recyclingStore) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	_, err := r.Cookie(name)
+	session.IsNew = err != nil
+	return session, nil
+}
+
+func (s *recyclingStore) Recycle(session *sessions.Session) {
+	s.recycled = append(s.recycled, session)
+}
+
+func TestRecycleSessions(t *testing.T) {
+	var store recyclingStore
+	h := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(store.recycled) != 0 {
+			t.Error("session recycled before the handler returned")
+		}
+	})
+
+	tests := []struct {
+		name    string
+		h       http.Handler
+		cookies []string
+		want    int
+	}{
+		{"single without option", handler.WithSession("a", &store, h, nil), nil, 0},
+		{"single", handler.WithSession("a", &store, h, nil, handler.RecycleSessions()), nil, 1},
+		{"single with cookie", handler.WithSession("a", &store, h, nil, handler.RecycleSessions()), []string{"a"}, 0},
+		{"single with hooks", handler.WithSession("a", &store, h, nil, handler.RecycleSessions(), handler.AutoSave(nil)), nil, 1},
+		{"named", handler.WithSessionsNamed([]string{"a", "b"}, &store, h, nil, handler.RecycleSessions()), []string{"b"}, 1},
+		{"disabled", handler.WithSession("a", &store, h, nil, handler.RecycleSessions(), handler.Disabled(true)), nil, 0},
+	}
+	for _, test := range tests {
+		store.recycled = nil
+		r := httptest.NewRequest("", "/", nil)
+		for _, c := range test.cookies {
+			r.AddCookie(&http.Cookie{Name: c, Value: "v"})
+		}
+		test.h.ServeHTTP(httptest.NewRecorder(), r)
+		if got := len(store.recycled); got != test.want {
+			t.Errorf("%s: recycled sessions: got %d, want %d", test.name, got, test.want)
+			continue
+		}
+		for _, s := range store.recycled {
+			if !s.IsNew || s.Name() != "a" {
+				t.Errorf("%s: recycled session %q that was not fresh", test.name, s.Name())
+			}
+		}
+	}
+}
@@ -0,0 +1,61 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// PrincipalKey is the session value key under which SetPrincipal records the principal—the
+// identity of the authenticated user—associated with a session.
+const PrincipalKey = "handler.principal"
+
+// SetPrincipal records the given principal as that associated with the session, clearing any
+// record of a soft logout made via Logout.
+func SetPrincipal(s *sessions.Session, principal string) {
+	delete(s.Values, LoggedOutAtKey)
+	s.Values[PrincipalKey] = principal
+}
+
+// ClearPrincipal removes any principal associated with the session.
+func ClearPrincipal(s *sessions.Session) {
+	delete(s.Values, PrincipalKey)
+}
+
+// SessionPrincipal returns the principal associated with the session via SetPrincipal, together
+// with a boolean indicating whether any such principal is present.
+func SessionPrincipal(s *sessions.Session) (string, bool) {
+	return principalIn(s.Values)
+}
+
+func principalIn(values map[interface{}]interface{}) (string, bool) {
+	p, ok := values[PrincipalKey].(string)
+	return p, ok && len(p) != 0
+}
+
+type principalContextKey struct{}
+
+// BindPrincipal returns a shallow copy of the request bound to the given principal, which
+// ExtractPrincipal then reports in preference to that of any bound session. Middleware that
+// authenticates each request, such as WithBasicAuth, uses it to identify the user to the handlers
+// it delegates to.
+func BindPrincipal(r *http.Request, principal string) *http.Request {
+	return r.WithContext(context.WithValue(r.Context(), principalContextKey{}, principal))
+}
+
+// ExtractPrincipal retrieves the principal bound to this request via BindPrincipal or, failing
+// that, the principal associated via SetPrincipal with the singular session bound to this request
+// via WithSession, together with a boolean indicating whether any such principal is available.
+func ExtractPrincipal(r *http.Request) (string, bool) {
+	if p, ok := r.Context().Value(principalContextKey{}).(string); ok && len(p) != 0 {
+		return p, true
+	}
+	if s, ok := ExtractSession(r); ok {
+		return SessionPrincipal(s)
+	}
+	return "", false
+}
@@ -0,0 +1,52 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestSessionPrincipal(t *testing.T) {
+	s := sessions.NewSession(simpleStore{}, "s")
+	if p, ok := handler.SessionPrincipal(s); ok {
+		t.Errorf("principal: got %q, want none", p)
+	}
+	handler.SetPrincipal(s, "alice")
+	if p, ok := handler.SessionPrincipal(s); !ok || p != "alice" {
+		t.Errorf("principal: got (%q, %t), want (%q, true)", p, ok, "alice")
+	}
+	handler.ClearPrincipal(s)
+	if p, ok := handler.SessionPrincipal(s); ok {
+		t.Errorf("principal: got %q, want none", p)
+	}
+}
+
+func TestExtractPrincipal(t *testing.T) {
+	var got string
+	var ok bool
+	capture := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got, ok = handler.ExtractPrincipal(r)
+	})
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	capture.ServeHTTP(nil, r)
+	if ok {
+		t.Errorf("principal without binding: got %q, want none", got)
+	}
+	handler.WithSession("s", simpleStore{}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		handler.SetPrincipal(handler.MustExtractSession(r), "alice")
+		capture.ServeHTTP(w, r)
+		if !ok || got != "alice" {
+			t.Errorf("session principal: got (%q, %t), want (%q, true)", got, ok, "alice")
+		}
+		capture.ServeHTTP(w, handler.BindPrincipal(r, "bob"))
+		if !ok || got != "bob" {
+			t.Errorf("bound principal: got (%q, %t), want (%q, true)", got, ok, "bob")
+		}
+	}), nil).ServeHTTP(httptest.NewRecorder(), r)
+}
@@ -0,0 +1,103 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"errors"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// Session value keys under which the profile helpers record their state.
+const (
+	// ProfilesKey is the session value key under which StoreProfile records the profiles
+	// available to the session's user, such as their personal profile and those of the
+	// organizations on whose behalf they act.
+	ProfilesKey = "handler.profiles"
+	// ActiveProfileKey is the session value key under which SwitchActiveProfile records the
+	// profile on whose behalf the user currently acts.
+	ActiveProfileKey = "handler.profiles.active"
+)
+
+// ErrUnknownProfile is the error that SwitchActiveProfile returns when asked to activate a profile
+// not stored in the session.
+var ErrUnknownProfile = errors.New("unknown profile")
+
+// SessionProfiles returns the profiles stored in the session via StoreProfile, in the order in
+// which they were stored.
+func SessionProfiles(s *sessions.Session) []string {
+	return stringsValue(s.Values[ProfilesKey])
+}
+
+// StoreProfile records the given profile, identified as a principal is, as available to the
+// session's user, once they've proven their right to act on its behalf. The first profile stored
+// becomes the active one.
+func StoreProfile(s *sessions.Session, profile string) {
+	profiles := SessionProfiles(s)
+	for _, p := range profiles {
+		if p == profile {
+			return
+		}
+	}
+	s.Values[ProfilesKey] = append(append([]string(nil), profiles...), profile)
+	if _, ok := SessionActiveProfile(s); !ok {
+		s.Values[ActiveProfileKey] = profile
+	}
+}
+
+// SwitchActiveProfile makes the given profile, which must have been stored via StoreProfile, the
+// one on whose behalf the session's user acts, or returns ErrUnknownProfile.
+func SwitchActiveProfile(s *sessions.Session, profile string) error {
+	for _, p := range SessionProfiles(s) {
+		if p == profile {
+			s.Values[ActiveProfileKey] = profile
+			return nil
+		}
+	}
+	return ErrUnknownProfile
+}
+
+// SessionActiveProfile returns the session's active profile, together with a boolean indicating
+// whether any is present.
+func SessionActiveProfile(s *sessions.Session) (string, bool) {
+	p, ok := s.Values[ActiveProfileKey].(string)
+	return p, ok && len(p) != 0
+}
+
+// ExtractActiveProfile retrieves the active profile of the singular session bound to this request
+// via WithSession, together with a boolean indicating whether any such profile is available.
+func ExtractActiveProfile(r *http.Request) (string, bool) {
+	if s, ok := ExtractSession(r); ok {
+		return SessionActiveProfile(s)
+	}
+	return "", false
+}
+
+// RequireActiveProfile returns an HTTP handler that delegates requests whose singular session bound
+// via WithSession has an active profile that the allow function accepts to the supplied handler,
+// such as to confine administrative pages to an organization's profile. It delegates all other
+// requests to the onForbidden handler. If no such onForbidden handler is supplied, it will respond
+// with HTTP status code 403 with no body. It panics if either the allow function or the supplied
+// handler is nil.
+func RequireActiveProfile(allow func(r *http.Request, profile string) bool, h http.Handler, onForbidden http.Handler) http.Handler {
+	if allow == nil {
+		panic("no profile predicate supplied")
+	}
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	if onForbidden == nil {
+		onForbidden = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if p, ok := ExtractActiveProfile(r); !ok || !allow(r, p) {
+			onForbidden.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
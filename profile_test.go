@@ -0,0 +1,88 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestProfiles(t *testing.T) {
+	s := sessions.NewSession(simpleStore{}, "s")
+	if _, ok := handler.SessionActiveProfile(s); ok {
+		t.Error("empty session has active profile")
+	}
+	handler.StoreProfile(s, "alice")
+	handler.StoreProfile(s, "org:acme")
+	handler.StoreProfile(s, "alice")
+	if got, want := handler.SessionProfiles(s), []string{"alice", "org:acme"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("profiles: got %v, want %v", got, want)
+	}
+	if p, _ := handler.SessionActiveProfile(s); p != "alice" {
+		t.Errorf("active profile: got %q, want first stored", p)
+	}
+	if err := handler.SwitchActiveProfile(s, "org:other"); !errors.Is(err, handler.ErrUnknownProfile) {
+		t.Errorf("switching to unknown profile: got %v, want %v", err, handler.ErrUnknownProfile)
+	}
+
+	// Profiles recorded by a JSON serializer come back as []interface{}.
+	b, _ := json.Marshal(s.Values[handler.ProfilesKey])
+	var decoded []interface{}
+	json.Unmarshal(b, &decoded)
+	s.Values[handler.ProfilesKey] = decoded
+	if err := handler.SwitchActiveProfile(s, "org:acme"); err != nil {
+		t.Errorf("failed to switch profile: %v", err)
+	}
+	if p, _ := handler.SessionActiveProfile(s); p != "org:acme" {
+		t.Errorf("active profile: got %q, want %q", p, "org:acme")
+	}
+
+	handler.Logout(s, handler.SoftLogout, nil)
+	if _, ok := handler.SessionActiveProfile(s); ok || len(handler.SessionProfiles(s)) != 0 {
+		t.Error("soft logout retained profiles")
+	}
+}
+
+func TestRequireActiveProfilePanicsWithNoPredicate(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.RequireActiveProfile(nil, http.NotFoundHandler(), nil)
+}
+
+func TestRequireActiveProfilePanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.RequireActiveProfile(func(*http.Request, string) bool { return true }, nil, nil)
+}
+
+func TestRequireActiveProfile(t *testing.T) {
+	isOrg := func(_ *http.Request, p string) bool {
+		return p == "org:acme"
+	}
+	h := handler.RequireActiveProfile(isOrg, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), nil)
+	for _, test := range []struct {
+		description string
+		source      handler.SessionSource
+		want        int
+	}{
+		{"none", simpleStore{}, http.StatusForbidden},
+		{"personal", valuesSource{handler.ActiveProfileKey: "alice"}, http.StatusForbidden},
+		{"organization", valuesSource{handler.ActiveProfileKey: "org:acme"}, http.StatusNoContent},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.WithSession("s", test.source, h, nil).ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+			if got, want := recorder.Code, test.want; got != want {
+				t.Errorf("status code: got %d, want %d", got, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,76 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// ProjectingSource is implemented by SessionSources, typically server-side stores, that can create
+// a session populated with only some of its stored values.
+type ProjectingSource interface {
+	SessionSource
+	// NewProjected is like New, but populates the session with only the values for the given keys.
+	// Saving the session must preserve the stored values for all other keys.
+	NewProjected(r *http.Request, name string, keys []interface{}) (*sessions.Session, error)
+}
+
+type projectingSource struct {
+	ProjectingSource
+	keys []interface{}
+}
+
+func (s projectingSource) New(r *http.Request, name string) (*sessions.Session, error) {
+	return s.NewProjected(r, name, s.keys)
+}
+
+// Project returns an Option that binds sessions populated with only the values for the given keys,
+// such as just a user ID and roles, along with the values that other Options consult, such as
+// Canary, if the supplied SessionSource implements ProjectingSource. For hot endpoints, that
+// avoids decoding large sets of session values on every request. Request handlers may still store
+// values for other keys, and saving the session preserves the stored values for keys not
+// projected. With SessionSources that don't implement ProjectingSource, it has no effect.
+func Project(keys ...interface{}) Option {
+	keys = append(make([]interface{}, 0, len(keys)), keys...)
+	return func(c *bindingConfig) {
+		c.projection = keys
+	}
+}
+
+// ProjectingSerializer is implemented by securecookie.Serializers that can decode the values for
+// only some keys from a set of session values, without decoding the rest.
+type ProjectingSerializer interface {
+	// DeserializeProjected decodes from src the values for the given keys, replacing the entries in
+	// dst.
+	DeserializeProjected(src []byte, dst *map[interface{}]interface{}, keys []interface{}) error
+}
+
+// DeserializeProjected decodes from src the values for the given keys, replacing the entries in
+// dst. It skips over the encoded values for all other keys, and ignores keys that aren't strings.
+func (JSONValuesSerializer) DeserializeProjected(src []byte, dst *map[interface{}]interface{}, keys []interface{}) error {
+	var m map[string]json.RawMessage
+	if err := json.Unmarshal(src, &m); err != nil {
+		return err
+	}
+	*dst = make(map[interface{}]interface{}, len(keys))
+	for _, k := range keys {
+		s, ok := k.(string)
+		if !ok {
+			continue
+		}
+		raw, ok := m[s]
+		if !ok {
+			continue
+		}
+		var v interface{}
+		if err := json.Unmarshal(raw, &v); err != nil {
+			return err
+		}
+		(*dst)[s] = v
+	}
+	return nil
+}
@@ -0,0 +1,40 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"net/http/httputil"
+)
+
+// DefaultForwardedUserHeader is the request header in which ForwardIdentity names the principal
+// unless told otherwise.
+const DefaultForwardedUserHeader = "X-Forwarded-User"
+
+// ForwardIdentity returns a function for use within the Rewrite function of an
+// httputil.ReverseProxy that forwards the principal of each inbound request, per
+// ExtractPrincipal, to the upstream server, so that upstream servers without session handling of
+// their own can identify the user. Wrap the proxy with WithSession so that the inbound requests
+// bear the sessions identifying their principals.
+//
+// It names the principal in the header with the given name, or DefaultForwardedUserHeader if
+// empty, and accompanies it with an assertion from the supplied IdentityAsserter, which upstream
+// servers should verify via its Verify or VerifyIdentityAssertion methods before trusting the
+// named principal. It first removes any such headers supplied by the client, so that clients
+// can't impersonate other users, and adds neither for requests made on behalf of no principal. It
+// panics if the IdentityAsserter has no keys.
+func ForwardIdentity(a *IdentityAsserter, userHeader string) func(*httputil.ProxyRequest) {
+	a.checkKeys()
+	if len(userHeader) == 0 {
+		userHeader = DefaultForwardedUserHeader
+	}
+	assertionHeader := a.header()
+	return func(pr *httputil.ProxyRequest) {
+		pr.Out.Header.Del(userHeader)
+		pr.Out.Header.Del(assertionHeader)
+		if principal, ok := ExtractPrincipal(pr.In); ok {
+			pr.Out.Header.Set(userHeader, principal)
+			pr.Out.Header.Set(assertionHeader, a.Assert(principal))
+		}
+	}
+}
@@ -0,0 +1,64 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/http/httputil"
+	"net/url"
+	"testing"
+
+	"github.com/seh/handler"
+)
+
+func TestForwardIdentityPanicsWithNoKeys(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.ForwardIdentity(&handler.IdentityAsserter{}, "")
+}
+
+func TestForwardIdentity(t *testing.T) {
+	asserter := &handler.IdentityAsserter{Keys: [][]byte{[]byte("key")}, Audience: "legacy"}
+	upstream := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		user := r.Header.Get(handler.DefaultForwardedUserHeader)
+		assertion := r.Header.Get(handler.DefaultIdentityAssertionHeader)
+		if p, ok := asserter.Verify(assertion); len(assertion) != 0 && (!ok || p != user) {
+			t.Errorf("upstream received user %q with assertion for (%q, %t)", user, p, ok)
+		}
+		io.WriteString(w, user)
+	}))
+	defer upstream.Close()
+	target, err := url.Parse(upstream.URL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	forward := handler.ForwardIdentity(asserter, "")
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.SetURL(target)
+			forward(pr)
+		},
+	}
+
+	for _, test := range []struct {
+		description string
+		source      valuesSource
+		want        string
+	}{
+		{"authenticated", valuesSource{handler.PrincipalKey: "alice"}, "alice"},
+		{"anonymous", valuesSource{}, ""},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			r := httptest.NewRequest("", "/", nil)
+			r.Header.Set(handler.DefaultForwardedUserHeader, "mallory")
+			r.Header.Set(handler.DefaultIdentityAssertionHeader, asserter.Assert("mallory"))
+			recorder := httptest.NewRecorder()
+			handler.WithSession("s", test.source, proxy, nil).ServeHTTP(recorder, r)
+			if got := recorder.Body.String(); got != test.want {
+				t.Errorf("forwarded user: got %q, want %q", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,71 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"time"
+)
+
+// Purgeable is implemented by server-side session stores that can delete their expired sessions
+// eagerly, such as kvstore.Store.
+type Purgeable interface {
+	// PurgeExpired deletes all sessions that expired before the given time, returning the number
+	// of sessions deleted.
+	PurgeExpired(ctx context.Context, before time.Time) (int, error)
+}
+
+// Purger periodically deletes expired sessions from a store, so that stores that discard expired
+// sessions only lazily, if at all, don't grow without bound.
+type Purger struct {
+	// Store is the store from which to purge expired sessions.
+	Store Purgeable
+	// Retention is how long to retain sessions after they expire before purging them, such as to
+	// allow for investigation of recent activity. If not positive, the Purger purges sessions as
+	// soon as they expire.
+	Retention time.Duration
+	// Clock reports the current time, from which the Purger subtracts Retention to determine which
+	// sessions to purge. If nil, the Purger uses SystemClock.
+	Clock Clock
+	// Purged, if not nil, accumulates the number of sessions purged.
+	Purged Counter
+	// OnError, if not nil, receives errors encountered while purging.
+	OnError func(error)
+}
+
+// PurgeOnce deletes the sessions that expired longer than the retention period ago, returning the
+// number of sessions deleted.
+func (p *Purger) PurgeOnce(ctx context.Context) (int, error) {
+	clock := p.Clock
+	if clock == nil {
+		clock = SystemClock
+	}
+	before := clock.Now()
+	if p.Retention > 0 {
+		before = before.Add(-p.Retention)
+	}
+	n, err := p.Store.PurgeExpired(ctx, before)
+	if n > 0 && p.Purged != nil {
+		p.Purged.Add(int64(n))
+	}
+	return n, err
+}
+
+// Run calls PurgeOnce at the given interval until the supplied context is done. It reports any
+// errors encountered to the OnError function, if supplied, and continues purging at the next
+// interval.
+func (p *Purger) Run(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if _, err := p.PurgeOnce(ctx); err != nil && p.OnError != nil {
+				p.OnError(err)
+			}
+		}
+	}
+}
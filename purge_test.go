@@ -0,0 +1,72 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"expvar"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+type purgeableFunc func(ctx context.Context, before time.Time) (int, error)
+
+func (f purgeableFunc) PurgeExpired(ctx context.Context, before time.Time) (int, error) {
+	return f(ctx, before)
+}
+
+var _ handler.Counter = (*expvar.Int)(nil)
+
+func TestPurgerPurgeOnce(t *testing.T) {
+	now := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	var purged int64
+	var sawBefore time.Time
+	p := handler.Purger{
+		Store: purgeableFunc(func(_ context.Context, before time.Time) (int, error) {
+			sawBefore = before
+			return 3, nil
+		}),
+		Retention: time.Hour,
+		Clock:     handler.ClockFunc(func() time.Time { return now }),
+		Purged:    handler.CounterFunc(func(delta int64) { purged += delta }),
+	}
+	n, err := p.PurgeOnce(context.Background())
+	if err != nil || n != 3 {
+		t.Errorf("purge: got (%d, %v), want (3, nil)", n, err)
+	}
+	if want := now.Add(-time.Hour); !sawBefore.Equal(want) {
+		t.Errorf("purge cutoff: got %v, want %v", sawBefore, want)
+	}
+	if purged != 3 {
+		t.Errorf("purged counter: got %d, want 3", purged)
+	}
+}
+
+func TestPurgerRun(t *testing.T) {
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	expectedError := errors.New("")
+	calls := 0
+	var errs []error
+	p := handler.Purger{
+		Store: purgeableFunc(func(context.Context, time.Time) (int, error) {
+			calls++
+			if calls == 2 {
+				cancel()
+			}
+			return 0, expectedError
+		}),
+		OnError: func(err error) { errs = append(errs, err) },
+	}
+	p.Run(ctx, time.Millisecond)
+	if calls < 2 {
+		t.Errorf("purge call count: got %d, want at least 2", calls)
+	}
+	if len(errs) == 0 || errs[0] != expectedError {
+		t.Errorf("errors: got %v, want %v", errs, expectedError)
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// RefreshedAtKey is the session value key under which the RefreshNearExpiry Option records when
+// the session was last saved or refreshed, in seconds since the Unix epoch.
+const RefreshedAtKey = "handler.refreshed_at"
+
+// Refresher is implemented by server-side stores that can extend the lifetime of a session in two
+// separate steps: reissuing its cookie, which is quick, and extending the lifetime of its stored
+// values, which may involve a round trip to a backend.
+type Refresher interface {
+	// RefreshCookie adds a cookie to the response bearing the session's ID, with its expiration
+	// time reset per the session's options.
+	RefreshCookie(w http.ResponseWriter, s *sessions.Session) error
+	// RefreshStored extends the lifetime of the session's stored values per the session's
+	// options, without changing them.
+	RefreshStored(ctx context.Context, s *sessions.Session) error
+}
+
+// maxBackgroundRefreshes is the number of background refreshes of stored sessions that each
+// RefreshNearExpiry Option runs at once; beyond that, it defers refreshing sessions to their
+// subsequent requests.
+const maxBackgroundRefreshes = 64
+
+// refreshingStore is a sessions.Store that records when it saved a session, and whether the
+// session is due to be refreshed.
+type refreshingStore struct {
+	sessions.Store
+	clock Clock
+	due   bool
+	// refreshed, if not nil, is closed once the background refresh of the session's stored values
+	// completes.
+	refreshed chan struct{}
+}
+
+func (f *refreshingStore) unwrapStore() sessions.Store {
+	return f.Store
+}
+
+func (f *refreshingStore) Save(r *http.Request, w http.ResponseWriter, s *sessions.Session) error {
+	if s.Values == nil {
+		s.Values = make(map[interface{}]interface{})
+	}
+	s.Values[RefreshedAtKey] = f.clock.Now().Unix()
+	if f.refreshed != nil {
+		// Let the refresh finish first, lest it overwrite the values saved here with those it read
+		// before.
+		<-f.refreshed
+	}
+	if err := f.Store.Save(r, w, s); err != nil {
+		return err
+	}
+	f.due = false
+	return nil
+}
+
+// findRefresher returns the Refresher among the stores in the chain of decorators ending at the
+// session's store, if any.
+func findRefresher(s *sessions.Session) (Refresher, bool) {
+	var refresher Refresher
+	found := findStore(s, func(store sessions.Store) bool {
+		var ok bool
+		refresher, ok = store.(Refresher)
+		return ok
+	})
+	return refresher, found
+}
+
+func refreshedAt(s *sessions.Session) (time.Time, bool) {
+	return unixTimeValue(s.Values[RefreshedAtKey])
+}
+
+// unixTimeValue interprets a session value recorded as seconds since the Unix epoch.
+func unixTimeValue(v interface{}) (time.Time, bool) {
+	switch v := v.(type) {
+	case int64:
+		return time.Unix(v, 0), true
+	case float64:
+		// Some serializers, such as JSON ones, decode numbers as float64 values.
+		return time.Unix(int64(v), 0), true
+	}
+	return time.Time{}, false
+}
+
+// RefreshNearExpiry returns an Option that extends the lifetime of each bound session loaded from a
+// store implementing Refresher once less than the given fraction of its lifetime remains, such as
+// 0.25 for the last quarter, keeping active users from being logged out when their sessions
+// expire. Unless the request handler saves the session itself before the response header is
+// written, it reissues the session's cookie in the response, and extends the session's stored
+// values in the background, off the request's critical path. A handler that saves the session
+// after that waits for the background refresh to finish first, so that the refresh can't overwrite
+// the values saved. It runs at most a fixed number of background refreshes at once, leaving other
+// sessions due for refreshing to their subsequent requests.
+//
+// It reports failures to the onError function, if supplied. For failures in the background, it
+// supplies a copy of the request lacking its body, whose context is detached from the original
+// request's cancellation, since that request may be done by then. If the Clock is nil, it uses
+// SystemClock. It panics if the fraction is not between zero and one.
+//
+// It tracks each session's age by recording when it saved the session under RefreshedAtKey, so it
+// first refreshes sessions saved without it only once they're saved with it. Refreshing a session
+// leaves its stored values alone, so that they change only when saved, passing through Options
+// that adjust values upon saving, such as Canary and Compact, and hence combine with this one.
+// Since the recorded time then stays as it was, each subsequent request refreshes the session
+// anew until it's saved again.
+func RefreshNearExpiry(fraction float64, clock Clock, onError func(r *http.Request, s *sessions.Session, err error)) Option {
+	if fraction <= 0 || fraction >= 1 {
+		panic("refresh fraction must be between zero and one")
+	}
+	if clock == nil {
+		clock = SystemClock
+	}
+	slots := make(chan struct{}, maxBackgroundRefreshes)
+	return func(c *bindingConfig) {
+		c.decorators = append(c.decorators, func(r *http.Request, s *sessions.Session) *sessions.Session {
+			if s.Store() == nil {
+				return s
+			}
+			f := &refreshingStore{Store: s.Store(), clock: clock}
+			if _, ok := findRefresher(s); ok && !s.IsNew && s.Options != nil && s.Options.MaxAge > 0 {
+				if at, ok := refreshedAt(s); ok {
+					lifetime := time.Duration(s.Options.MaxAge) * time.Second
+					remaining := at.Add(lifetime).Sub(clock.Now())
+					f.due = remaining < time.Duration(fraction*float64(lifetime))
+				}
+			}
+			return rebindSession(s, f)
+		})
+		c.responseHooks = append(c.responseHooks, func(h http.Header, r *http.Request, bound []*sessions.Session) {
+			for _, s := range bound {
+				findStore(s, func(store sessions.Store) bool {
+					f, ok := store.(*refreshingStore)
+					if !ok {
+						return false
+					}
+					if !f.due || emitsCookie(h, s.Name()) {
+						return true
+					}
+					select {
+					case slots <- struct{}{}:
+					default:
+						return true
+					}
+					f.due = false
+					refresher, _ := findRefresher(s)
+					if err := refresher.RefreshCookie(headerWriter(h), s); err != nil {
+						<-slots
+						err = &saveFailure{"refreshing cookie for session", s.Name(), err}
+						c.countError(s.Name(), err)
+						if onError != nil {
+							onError(r, s, err)
+						}
+						return true
+					}
+					detached := copySession(s)
+					dr := r.Clone(context.WithoutCancel(r.Context()))
+					dr.Body = http.NoBody
+					done := make(chan struct{})
+					f.refreshed = done
+					go func() {
+						defer close(done)
+						defer func() { <-slots }()
+						if err := refresher.RefreshStored(dr.Context(), detached); err != nil {
+							err = &saveFailure{"refreshing stored session", detached.Name(), err}
+							c.countError(detached.Name(), err)
+							if onError != nil {
+								onError(dr, detached, err)
+							}
+						}
+					}()
+					return true
+				})
+			}
+		})
+	}
+}
@@ -0,0 +1,186 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+)
+
+func TestRefreshNearExpiryPanicsWithBadFraction(t *testing.T) {
+	for _, fraction := range []float64{-0.5, 0, 1, 1.5} {
+		func() {
+			defer ensurePanicWithValueOccured(t)
+			handler.RefreshNearExpiry(fraction, nil, nil)
+		}()
+	}
+}
+
+// refresherSource is a SessionSource implementing handler.Refresher, whose sessions were all last
+// refreshed at the same time, and which logs the operations performed on them.
+type refresherSource struct {
+	refreshedAt time.Time
+	// release, if not nil, holds RefreshStored until it's closed.
+	release chan struct{}
+	err     error
+	mu      sync.Mutex
+	log     []string
+	stored  chan struct{}
+}
+
+func newRefresherSource(refreshedAt time.Time) *refresherSource {
+	return &refresherSource{refreshedAt: refreshedAt, stored: make(chan struct{}, 1)}
+}
+
+func (s *refresherSource) record(op string) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.log = append(s.log, op)
+}
+
+func (s *refresherSource) operations() []string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return append([]string(nil), s.log...)
+}
+
+func (s *refresherSource) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.Options = &sessions.Options{Path: "/", MaxAge: 100}
+	session.Values[handler.RefreshedAtKey] = s.refreshedAt.Unix()
+	return session, nil
+}
+
+func (s *refresherSource) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return s.New(r, name)
+}
+
+func (s *refresherSource) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	s.record("save")
+	http.SetCookie(w, &http.Cookie{Name: session.Name(), Value: "saved"})
+	return nil
+}
+
+func (s *refresherSource) RefreshCookie(w http.ResponseWriter, session *sessions.Session) error {
+	s.record("refresh cookie")
+	http.SetCookie(w, &http.Cookie{Name: session.Name(), Value: "refreshed"})
+	return nil
+}
+
+func (s *refresherSource) RefreshStored(ctx context.Context, session *sessions.Session) error {
+	if s.release != nil {
+		<-s.release
+	}
+	s.record("refresh stored")
+	s.stored <- struct{}{}
+	return s.err
+}
+
+func TestRefreshNearExpiry(t *testing.T) {
+	start := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := handlertest.NewFakeClock(start.Add(80 * time.Second))
+	tests := []struct {
+		description string
+		refreshedAt time.Time
+		serve       func(w http.ResponseWriter, r *http.Request)
+		want        []string
+		wantCookie  string
+	}{
+		{
+			"not near expiry",
+			start.Add(50 * time.Second),
+			func(w http.ResponseWriter, r *http.Request) {},
+			nil,
+			"",
+		},
+		{
+			"near expiry",
+			start,
+			func(w http.ResponseWriter, r *http.Request) {},
+			[]string{"refresh cookie", "refresh stored"},
+			"refreshed",
+		},
+		{
+			"near expiry, saved",
+			start,
+			func(w http.ResponseWriter, r *http.Request) {
+				handler.MustExtractSession(r).Save(r, w)
+			},
+			[]string{"save"},
+			"saved",
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			source := newRefresherSource(test.refreshedAt)
+			recorder := httptest.NewRecorder()
+			handler.WithSession("s", source, http.HandlerFunc(test.serve), nil, handler.RefreshNearExpiry(0.25, clock, func(_ *http.Request, _ *sessions.Session, err error) {
+				t.Errorf("failed to refresh session: %v", err)
+			})).ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+			if len(test.want) != 0 && test.want[len(test.want)-1] == "refresh stored" {
+				<-source.stored
+			}
+			if got, want := strings.Join(source.operations(), ", "), strings.Join(test.want, ", "); got != want {
+				t.Errorf("operations: got %q, want %q", got, want)
+			}
+			var cookie string
+			if cookies := recorder.Result().Cookies(); len(cookies) != 0 {
+				cookie = cookies[0].Value
+			}
+			if cookie != test.wantCookie {
+				t.Errorf("cookie: got %q, want %q", cookie, test.wantCookie)
+			}
+		})
+	}
+}
+
+func TestRefreshNearExpiryWithLateSave(t *testing.T) {
+	start := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := handlertest.NewFakeClock(start.Add(80 * time.Second))
+	source := newRefresherSource(start)
+	source.release = make(chan struct{})
+	handler.WithSession("s", source, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+		// The refresh is underway, but blocked; saving must wait for it.
+		time.AfterFunc(10*time.Millisecond, func() { close(source.release) })
+		handler.MustExtractSession(r).Save(r, w)
+	}), nil, handler.RefreshNearExpiry(0.25, clock, nil)).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+	<-source.stored
+	if got, want := strings.Join(source.operations(), ", "), "refresh cookie, refresh stored, save"; got != want {
+		t.Errorf("operations: got %q, want %q", got, want)
+	}
+}
+
+func TestRefreshNearExpiryReportsBackgroundFailures(t *testing.T) {
+	start := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := handlertest.NewFakeClock(start.Add(80 * time.Second))
+	source := newRefresherSource(start)
+	source.err = errors.New("backend down")
+	source.release = make(chan struct{})
+	reported := make(chan error, 1)
+	ctx, cancel := context.WithCancel(context.Background())
+	handler.WithSession("s", source, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}), nil,
+		handler.RefreshNearExpiry(0.25, clock, func(r *http.Request, _ *sessions.Session, err error) {
+			if err := r.Context().Err(); err != nil {
+				t.Errorf("request context is done: %v", err)
+			}
+			reported <- err
+		})).ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil).WithContext(ctx))
+	// The request is done once the handler returns.
+	cancel()
+	close(source.release)
+	if err := <-reported; !errors.Is(err, source.err) {
+		t.Errorf("reported error: got %v, want %v", err, source.err)
+	}
+}
@@ -0,0 +1,180 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+	"text/tabwriter"
+)
+
+// Registry records which sessions each route requires, binding them via WithSessionsNamed and
+// retaining a description of each route's requirements for reporting at startup.
+//
+// Create a Registry with NewRegistry.
+type Registry struct {
+	source   SessionSource
+	onError  func(w http.ResponseWriter, r *http.Request, name string, err error)
+	opts     []Option
+	mu       sync.Mutex
+	policies map[string][]Option
+	routes   []RouteRequirements
+}
+
+// RouteRequirements describes the sessions bound for a route registered with a Registry.
+type RouteRequirements struct {
+	// Route identifies the route, as supplied to Requirement.ForRoute.
+	Route string
+	// Sessions holds the names of the sessions bound for the route, in lexicographic order.
+	Sessions []string
+	// Policies holds the names of the policies applied to the route, in the order applied.
+	Policies []string
+}
+
+// NewRegistry returns a Registry that binds sessions acquired from the given SessionSource,
+// handling errors with the given onError handler and applying the given Options, per
+// WithSessionsNamed. It panics if the supplied SessionSource is nil.
+func NewRegistry(s SessionSource, onError func(w http.ResponseWriter, r *http.Request, name string, err error), opts ...Option) *Registry {
+	if s == nil {
+		panic("no session source supplied")
+	}
+	return &Registry{
+		source:   s,
+		onError:  onError,
+		opts:     opts,
+		policies: make(map[string][]Option),
+	}
+}
+
+// DefinePolicy associates the given name with a set of Options, which routes can then apply by
+// name via Requirement.Policy. Redefining a policy affects only routes wrapped afterward.
+func (g *Registry) DefinePolicy(name string, opts ...Option) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.policies[name] = opts
+}
+
+// Require begins describing a route's session requirements, starting with the sessions with the
+// given names.
+func (g *Registry) Require(names ...string) Requirement {
+	return Requirement{
+		registry: g,
+		names:    append([]string(nil), names...),
+	}
+}
+
+// Requirements returns a description of the requirements of each route wrapped so far, in the
+// order in which they were wrapped.
+func (g *Registry) Requirements() []RouteRequirements {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	return append([]RouteRequirements(nil), g.routes...)
+}
+
+// WriteReport writes a table describing the requirements of each route wrapped so far to w.
+func (g *Registry) WriteReport(w io.Writer) error {
+	tw := tabwriter.NewWriter(w, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(tw, "ROUTE\tSESSIONS\tPOLICIES")
+	for _, rr := range g.Requirements() {
+		fmt.Fprintf(tw, "%s\t%s\t%s\n", orNone(rr.Route), orNone(strings.Join(rr.Sessions, ",")), orNone(strings.Join(rr.Policies, ",")))
+	}
+	return tw.Flush()
+}
+
+// Validate checks that the Registry's SessionSource is suitable for binding the sessions required
+// by the routes wrapped so far, per ValidateSource. Call it once all routes are wrapped, before
+// accepting requests.
+func (g *Registry) Validate() error {
+	var names []string
+	for _, rr := range g.Requirements() {
+		names = append(names, rr.Sessions...)
+	}
+	return ValidateSource(g.source, uniqueSorted(names)...)
+}
+
+// Warmup prepares the Registry's SessionSource to serve requests, per Warmup.
+func (g *Registry) Warmup(ctx context.Context) error {
+	return Warmup(ctx, g.source)
+}
+
+func orNone(s string) string {
+	if len(s) == 0 {
+		return "-"
+	}
+	return s
+}
+
+// Requirement describes the sessions that a route requires. Its methods return modified copies,
+// leaving the original Requirement intact, so that a partial Requirement can be shared among
+// several routes.
+type Requirement struct {
+	registry *Registry
+	route    string
+	names    []string
+	policies []string
+}
+
+// Require returns a copy of the Requirement that also requires the sessions with the given names.
+func (q Requirement) Require(names ...string) Requirement {
+	q.names = append(append([]string(nil), q.names...), names...)
+	return q
+}
+
+// Policy returns a copy of the Requirement that also applies the Options of the policies with the
+// given names, which must be defined in the Registry by the time the Requirement wraps a handler.
+func (q Requirement) Policy(names ...string) Requirement {
+	q.policies = append(append([]string(nil), q.policies...), names...)
+	return q
+}
+
+// ForRoute returns a copy of the Requirement that identifies its route as given in the Registry's
+// report.
+func (q Requirement) ForRoute(route string) Requirement {
+	q.route = route
+	return q
+}
+
+// Wrap returns an HTTP handler that binds the required sessions to each request before delegating
+// to the supplied handler, per WithSessionsNamed, and records the route's requirements in the
+// Registry. It panics if the supplied handler is nil or if any of the required policies is not
+// defined.
+func (q Requirement) Wrap(h http.Handler) http.Handler {
+	g := q.registry
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	opts := append([]Option(nil), g.opts...)
+	for _, p := range q.policies {
+		popts, ok := g.policies[p]
+		if !ok {
+			panic(fmt.Sprintf("session policy %q is not defined", p))
+		}
+		opts = append(opts, popts...)
+	}
+	wrapped := WithSessionsNamed(q.names, g.source, h, g.onError, opts...)
+	names := uniqueSorted(q.names)
+	g.routes = append(g.routes, RouteRequirements{
+		Route:    q.route,
+		Sessions: names,
+		Policies: append([]string(nil), q.policies...),
+	})
+	return wrapped
+}
+
+func uniqueSorted(names []string) []string {
+	s := append([]string(nil), names...)
+	sort.Strings(s)
+	n := 0
+	for i, name := range s {
+		if i == 0 || name != s[n-1] {
+			s[n] = name
+			n++
+		}
+	}
+	return s[:n]
+}
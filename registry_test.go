@@ -0,0 +1,71 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/seh/handler"
+)
+
+func TestNewRegistryPanicsWithNoSource(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.NewRegistry(nil, nil)
+}
+
+func TestRegistry(t *testing.T) {
+	var source countingSessionSource
+	registry := handler.NewRegistry(&source, nil)
+	registry.DefinePolicy("off", handler.Disabled(true))
+	auth := registry.Require("auth")
+
+	called := false
+	h := auth.Require("prefs", "auth").ForRoute("/account").Wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		called = true
+		for _, name := range []string{"auth", "prefs"} {
+			if _, ok := handler.ExtractSessionNamed(name, r); !ok {
+				t.Errorf("session %q is not available in request", name)
+			}
+		}
+	}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/account", nil))
+	if !called {
+		t.Error("delegate handler was not called")
+	}
+	if got, want := source.callCount(), uint(2); got != want {
+		t.Errorf("source call count: got %d, want %d", got, want)
+	}
+
+	auth.Policy("off").Wrap(http.HandlerFunc(func(http.ResponseWriter, *http.Request) {})).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+	if got, want := source.callCount(), uint(2); got != want {
+		t.Errorf("source call count: got %d, want %d", got, want)
+	}
+
+	want := []handler.RouteRequirements{
+		{Route: "/account", Sessions: []string{"auth", "prefs"}},
+		{Sessions: []string{"auth"}, Policies: []string{"off"}},
+	}
+	if got := registry.Requirements(); !reflect.DeepEqual(got, want) {
+		t.Errorf("requirements: got %+v, want %+v", got, want)
+	}
+	var report bytes.Buffer
+	if err := registry.WriteReport(&report); err != nil {
+		t.Fatalf("failed to write report: %v", err)
+	}
+	if got, want := strings.Count(report.String(), "\n"), 3; got != want {
+		t.Errorf("report line count: got %d, want %d in:\n%s", got, want, report.String())
+	}
+}
+
+func TestRegistryPanicsWithUndefinedPolicy(t *testing.T) {
+	registry := handler.NewRegistry(handler.NopSource{}, nil)
+	defer ensurePanicWithValueOccured(t)
+	registry.Require("s").Policy("missing").Wrap(http.NotFoundHandler())
+}
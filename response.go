@@ -0,0 +1,77 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"bufio"
+	"net"
+	"net/http"
+)
+
+// hookedResponseWriter is an http.ResponseWriter that calls a function just before writing the
+// response header, allowing it to adjust the header after the handler is done composing it.
+type hookedResponseWriter struct {
+	http.ResponseWriter
+	beforeHeader func(h http.Header)
+	wroteHeader  bool
+	// headerSent records that the beforeHeader function returned, after which changes to the
+	// header no longer reach the client.
+	headerSent bool
+}
+
+type hookedResponseWriterKey struct{}
+
+func (w *hookedResponseWriter) WriteHeader(code int) {
+	// Informational responses, such as 103 Early Hints, precede the final response header, which
+	// the handler may still adjust.
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.finish()
+	w.ResponseWriter.WriteHeader(code)
+}
+
+// finish calls the beforeHeader function if it hasn't been called already. Call it once the
+// handler returns, in case the handler never wrote the response header itself.
+func (w *hookedResponseWriter) finish() {
+	if !w.wroteHeader {
+		w.wroteHeader = true
+		w.beforeHeader(w.Header())
+		w.headerSent = true
+	}
+}
+
+func (w *hookedResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, writing the response header first if necessary, and flushing the
+// underlying http.ResponseWriter if it supports doing so.
+func (w *hookedResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Hijack implements http.Hijacker, calling the beforeHeader function first, so that sessions get
+// saved, before taking over the connection from the underlying http.ResponseWriter, if it supports
+// doing so. Cookies set in the header by then don't reach the client unless the caller writes them
+// to the connection itself.
+func (w *hookedResponseWriter) Hijack() (net.Conn, *bufio.ReadWriter, error) {
+	w.finish()
+	return http.NewResponseController(w.ResponseWriter).Hijack()
+}
+
+// Unwrap returns the underlying http.ResponseWriter, for use by http.ResponseController and by other
+// middleware that composes with this package's.
+func (w *hookedResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
@@ -0,0 +1,121 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"bufio"
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/seh/handler"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestResponseWritersSupportResponseController(t *testing.T) {
+	store := kvstore.New(kvstore.NewMemory(), securecookie.GenerateRandomKey(32))
+	wrappers := []struct {
+		description string
+		wrap        func(h http.Handler) http.Handler
+	}{
+		{"hooked", func(h http.Handler) http.Handler {
+			return handler.WithSession("s", store, h, nil, handler.AutoSave(nil))
+		}},
+		{"body limit", func(h http.Handler) http.Handler {
+			return handler.WithBodyLimit(1<<10, h, nil, nil)
+		}},
+		{"compress", func(h http.Handler) http.Handler {
+			return handler.Compress(handler.CompressionPolicy{}, h)
+		}},
+		{"entity tag", func(h http.Handler) http.Handler {
+			return handler.WithETag(handler.ETagShared, h)
+		}},
+		{"throttle", func(h http.Handler) http.Handler {
+			return (&handler.LoginThrottle{Attempts: &handler.MemoryAttemptStore{}}).ThrottleLogins(h, nil)
+		}},
+	}
+	for _, wrapper := range wrappers {
+		t.Run(wrapper.description, func(t *testing.T) {
+			errs := make(chan error, 3)
+			server := httptest.NewServer(wrapper.wrap(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				rc := http.NewResponseController(w)
+				errs <- rc.SetReadDeadline(time.Now().Add(time.Minute))
+				errs <- rc.SetWriteDeadline(time.Now().Add(time.Minute))
+				io.WriteString(w, "body")
+				errs <- rc.Flush()
+			})))
+			defer server.Close()
+			res, err := http.Get(server.URL)
+			if err != nil {
+				t.Fatal(err)
+			}
+			io.Copy(io.Discard, res.Body)
+			res.Body.Close()
+			for i := 0; i < cap(errs); i++ {
+				if err := <-errs; err != nil {
+					t.Errorf("response controller: %v", err)
+				}
+			}
+		})
+	}
+}
+
+func TestHijackSavesSessions(t *testing.T) {
+	store := kvstore.New(kvstore.NewMemory(), securecookie.GenerateRandomKey(32))
+	done := make(chan error, 1)
+	server := httptest.NewServer(handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := handler.MustExtractSession(r)
+		s.Values["k"] = "v"
+		conn, rw, err := http.NewResponseController(w).Hijack()
+		if err != nil {
+			done <- err
+			return
+		}
+		defer conn.Close()
+		// Write the header that the hooks composed, as a WebSocket upgrade might.
+		rw.WriteString("HTTP/1.1 200 OK\r\nConnection: close\r\n")
+		w.Header().Write(rw)
+		rw.WriteString("\r\n")
+		done <- rw.Flush()
+	}), nil, handler.AutoSave(nil)))
+	defer server.Close()
+
+	conn, err := net.Dial("tcp", server.Listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	req := httptest.NewRequest(http.MethodGet, "/", nil)
+	if err := req.Write(conn); err != nil {
+		t.Fatal(err)
+	}
+	res, err := http.ReadResponse(bufio.NewReader(conn), req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	res.Body.Close()
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	cookies := res.Cookies()
+	if len(cookies) != 1 {
+		t.Fatalf("cookies: got %v, want one", cookies)
+	}
+	var id string
+	if err := securecookie.DecodeMulti("s", cookies[0].Value, &id, store.Codecs...); err != nil {
+		t.Fatal(err)
+	}
+	values, err := store.Values(context.Background(), id)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got, want := values["k"], "v"; got != want {
+		t.Errorf("saved value: got %v, want %q", got, want)
+	}
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"net/http"
+
+	"github.com/gorilla/sessions"
+)
+
+// fallbackSource is a SessionSource that, when a request lacks a session's cookie but bears its
+// fallback cookie, acquires the session as though the request bore the fallback cookie's value
+// under the session cookie's name.
+type fallbackSource struct {
+	SessionSource
+	suffix string
+}
+
+func (s fallbackSource) New(r *http.Request, name string) (*sessions.Session, error) {
+	if _, err := r.Cookie(name); err == http.ErrNoCookie {
+		if fallback, err := r.Cookie(name + s.suffix); err == nil {
+			r = r.Clone(r.Context())
+			r.AddCookie(&http.Cookie{Name: name, Value: fallback.Value})
+		}
+	}
+	return s.SessionSource.New(r, name)
+}
+
+// SameSiteNoneFallback returns an Option that pairs each bound session's cookie bearing the
+// SameSite=None attribute, as needed by applications embedded in frames on other sites, with a
+// fallback cookie bearing the same value without the SameSite attribute, named with the given
+// suffix appended to the session's name, such as "-legacy". Some older browsers mishandle
+// SameSite=None, either rejecting such cookies outright or treating them as SameSite=Strict, and
+// so rely on the fallback cookie instead.
+//
+// When a request lacks a session's cookie but bears its fallback cookie, it acquires the session
+// from the fallback cookie. The fallback cookie follows the session cookie whenever the response
+// sets it, including when deleting it. It panics if the suffix is empty.
+func SameSiteNoneFallback(suffix string) Option {
+	if len(suffix) == 0 {
+		panic("fallback cookie name suffix must not be empty")
+	}
+	return func(c *bindingConfig) {
+		c.fallbackSuffix = suffix
+		c.responseHooks = append(c.responseHooks, func(h http.Header, r *http.Request, bound []*sessions.Session) {
+			names := make(map[string]struct{}, len(bound))
+			for _, s := range bound {
+				names[s.Name()] = struct{}{}
+			}
+			for _, cookie := range (&http.Response{Header: h}).Cookies() {
+				if _, ok := names[cookie.Name]; !ok || cookie.SameSite != http.SameSiteNoneMode {
+					continue
+				}
+				fallback := *cookie
+				fallback.Name += suffix
+				fallback.SameSite = http.SameSiteDefaultMode
+				fallback.Raw, fallback.Unparsed = "", nil
+				h.Add("Set-Cookie", fallback.String())
+			}
+		})
+	}
+}
@@ -0,0 +1,70 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestSameSiteNoneFallbackPanicsWithNoSuffix(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.SameSiteNoneFallback("")
+}
+
+func TestSameSiteNoneFallback(t *testing.T) {
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	// Don't rely on the library's default options, which vary across its versions.
+	store.Options.Secure = true
+	store.Options.SameSite = http.SameSiteLaxMode
+	serve := func(r *http.Request, f func(s *sessions.Session, w http.ResponseWriter)) *httptest.ResponseRecorder {
+		recorder := httptest.NewRecorder()
+		handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			f(handler.MustExtractSession(r), w)
+			w.WriteHeader(http.StatusNoContent)
+		}), nil, handler.SameSiteNoneFallback("-legacy")).ServeHTTP(recorder, r)
+		return recorder
+	}
+	save := func(s *sessions.Session, w http.ResponseWriter) {
+		s.Values["uid"] = "alice"
+		if err := s.Save(nil, w); err != nil {
+			t.Errorf("failed to save session: %v", err)
+		}
+	}
+
+	if cookies := serve(httptest.NewRequest("", "/", nil), save).Result().Cookies(); len(cookies) != 1 {
+		t.Errorf("cookies without SameSite=None: got %d, want 1", len(cookies))
+	}
+
+	store.Options.SameSite = http.SameSiteNoneMode
+	cookies := serve(httptest.NewRequest("", "/", nil), save).Result().Cookies()
+	if len(cookies) != 2 {
+		t.Fatalf("cookies: got %d, want 2", len(cookies))
+	}
+	primary, fallback := cookies[0], cookies[1]
+	if got, want := fallback.Name, "s-legacy"; got != want {
+		t.Errorf("fallback cookie name: got %q, want %q", got, want)
+	}
+	if fallback.Value != primary.Value || fallback.MaxAge != primary.MaxAge || !fallback.Secure {
+		t.Errorf("fallback cookie %v does not match %v", fallback, primary)
+	}
+	if got := fallback.SameSite; got == http.SameSiteNoneMode {
+		t.Errorf("fallback cookie bears SameSite=None")
+	}
+
+	for _, c := range []*http.Cookie{primary, fallback} {
+		r := httptest.NewRequest("", "/", nil)
+		r.AddCookie(c)
+		serve(r, func(s *sessions.Session, _ http.ResponseWriter) {
+			if got, want := s.Values["uid"], "alice"; got != want {
+				t.Errorf("value with cookie %q: got %v, want %v", c.Name, got, want)
+			}
+		})
+	}
+}
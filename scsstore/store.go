@@ -0,0 +1,157 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+/*
+Package scsstore adapts a session manager from the alexedwards/scs package to serve as a
+gorilla/sessions Store, and hence as a handler.SessionSource, easing a transition between the two
+styles of session handling.
+*/
+package scsstore
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"time"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/gorilla/sessions"
+)
+
+// Store is a sessions.Store that keeps its session data in an scs.SessionManager, using the
+// manager's own token cookie, lifetime, and backing store.
+//
+// Since an scs session manager maintains only one session per request, every session that Store
+// creates for a given request shares the same underlying data, regardless of the name requested.
+// The manager's data is keyed by strings, so Store rejects any attempt to save a session bearing a
+// value with a key of any other type.
+//
+// If the scs manager's LoadAndSave middleware already loaded the session data for a request, Store
+// reads and writes that same data, leaving it to that middleware to commit the data and write the
+// session cookie.
+type Store struct {
+	m *scs.SessionManager
+}
+
+// New returns a Store that keeps its session data in the given scs.SessionManager. It panics if
+// the supplied manager is nil.
+func New(m *scs.SessionManager) *Store {
+	if m == nil {
+		panic("no session manager supplied")
+	}
+	return &Store{m}
+}
+
+// Get returns a cached session with the given name, creating it via New if the request has no such
+// session cached yet.
+func (s *Store) Get(r *http.Request, name string) (*sessions.Session, error) {
+	return sessions.GetRegistry(r).Get(s, name)
+}
+
+func (s *Store) cookieOptions() *sessions.Options {
+	c := s.m.Cookie
+	o := &sessions.Options{
+		Path:     c.Path,
+		Domain:   c.Domain,
+		Secure:   c.Secure,
+		HttpOnly: c.HttpOnly,
+		SameSite: c.SameSite,
+	}
+	if c.Persist {
+		o.MaxAge = int(s.m.Lifetime / time.Second)
+	}
+	return o
+}
+
+func (s *Store) token(r *http.Request) string {
+	if c, err := r.Cookie(s.m.Cookie.Name); err == nil {
+		return c.Value
+	}
+	return ""
+}
+
+// New creates a session with the given name, populated with the values that the scs session
+// manager holds for the token submitted with the request, if any.
+func (s *Store) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(s, name)
+	session.Options = s.cookieOptions()
+	session.IsNew = true
+	token := s.token(r)
+	ctx, err := s.m.Load(r.Context(), token)
+	if err != nil {
+		return session, err
+	}
+	keys := s.m.Keys(ctx)
+	for _, k := range keys {
+		session.Values[k] = s.m.Get(ctx, k)
+	}
+	if token := s.m.Token(ctx); len(token) != 0 {
+		session.ID = token
+		session.IsNew = len(keys) == 0
+	}
+	return session, nil
+}
+
+// ErrNonStringKey is the error that Store's Save method returns when a session holds a value with
+// a key that is not a string.
+var ErrNonStringKey = errors.New("session value key is not a string")
+
+// Save replaces the data that the scs session manager holds for the session with the session's
+// current values, committing the data to the manager's store and writing the session cookie,
+// unless the manager's LoadAndSave middleware is responsible for doing so for this request. If the
+// session's MaxAge option is negative, it instead destroys the session data.
+func (s *Store) Save(r *http.Request, w http.ResponseWriter, session *sessions.Session) error {
+	for k := range session.Values {
+		if _, ok := k.(string); !ok {
+			return ErrNonStringKey
+		}
+	}
+	rctx := r.Context()
+	ctx, err := s.m.Load(rctx, session.ID)
+	if err != nil {
+		return err
+	}
+	managed := ctx == rctx
+	if session.Options != nil && session.Options.MaxAge < 0 {
+		if err := s.m.Destroy(ctx); err != nil {
+			return err
+		}
+		session.ID = ""
+		if !managed {
+			s.m.WriteSessionCookie(ctx, w, "", time.Time{})
+		}
+		return nil
+	}
+	for _, k := range s.m.Keys(ctx) {
+		if _, ok := session.Values[k]; !ok {
+			s.m.Remove(ctx, k)
+		}
+	}
+	for k, v := range session.Values {
+		s.m.Put(ctx, k.(string), v)
+	}
+	if managed {
+		return nil
+	}
+	token, expiry, err := s.m.Commit(ctx)
+	if err != nil {
+		return err
+	}
+	session.ID = token
+	s.m.WriteSessionCookie(ctx, w, token, expiry)
+	return nil
+}
+
+// healthProbeToken is the token that Health attempts to find in the scs manager's store.
+const healthProbeToken = "health-probe"
+
+// Health reports whether the scs session manager's store is reachable, by attempting to find a
+// token that's not expected to be present.
+func (s *Store) Health(ctx context.Context) error {
+	if cs, ok := s.m.Store.(scs.CtxStore); ok {
+		_, _, err := cs.FindCtx(ctx, healthProbeToken)
+		return err
+	}
+	_, _, err := s.m.Store.Find(healthProbeToken)
+	return err
+}
@@ -0,0 +1,130 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package scsstore_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/alexedwards/scs/v2"
+	"github.com/seh/handler"
+	"github.com/seh/handler/scsstore"
+)
+
+func ensurePanicWithValueOccured(t *testing.T) {
+	if p := recover(); p == nil {
+		t.Error("panic was not called with a non-nil argument")
+	}
+}
+
+func TestNewPanicsWithNoManager(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	scsstore.New(nil)
+}
+
+func requestBearingCookiesFrom(recorder *httptest.ResponseRecorder) *http.Request {
+	r := httptest.NewRequest("", "/", nil)
+	for _, c := range recorder.Result().Cookies() {
+		r.AddCookie(c)
+	}
+	return r
+}
+
+func TestRoundTrip(t *testing.T) {
+	store := scsstore.New(scs.New())
+	r := httptest.NewRequest("", "/", nil)
+	session, err := store.New(r, "s")
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	if !session.IsNew {
+		t.Error("session is not new")
+	}
+	session.Values["k"] = "v"
+	recorder := httptest.NewRecorder()
+	if err := session.Save(r, recorder); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	if len(session.ID) == 0 {
+		t.Error("saved session has no ID")
+	}
+
+	r = requestBearingCookiesFrom(recorder)
+	session, err = store.New(r, "s")
+	if err != nil {
+		t.Fatalf("failed to recover session: %v", err)
+	}
+	if session.IsNew {
+		t.Error("recovered session is new")
+	}
+	if got, want := session.Values["k"], "v"; got != want {
+		t.Errorf("value: got %v, want %v", got, want)
+	}
+}
+
+func TestSaveRejectsNonStringKeys(t *testing.T) {
+	store := scsstore.New(scs.New())
+	r := httptest.NewRequest("", "/", nil)
+	session, err := store.New(r, "s")
+	if err != nil {
+		t.Fatalf("failed to create session: %v", err)
+	}
+	session.Values[1] = "v"
+	if got, want := session.Save(r, httptest.NewRecorder()), scsstore.ErrNonStringKey; got != want {
+		t.Errorf("error: got %v, want %v", got, want)
+	}
+}
+
+func TestSaveWithNegativeMaxAgeDestroysSession(t *testing.T) {
+	store := scsstore.New(scs.New())
+	r := httptest.NewRequest("", "/", nil)
+	session, _ := store.New(r, "s")
+	session.Values["k"] = "v"
+	recorder := httptest.NewRecorder()
+	if err := session.Save(r, recorder); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+
+	r = requestBearingCookiesFrom(recorder)
+	session, _ = store.New(r, "s")
+	session.Options.MaxAge = -1
+	if err := session.Save(r, httptest.NewRecorder()); err != nil {
+		t.Fatalf("failed to destroy session: %v", err)
+	}
+
+	session, _ = store.New(r, "s")
+	if !session.IsNew {
+		t.Error("session survived destruction")
+	}
+}
+
+func TestDefersToLoadAndSave(t *testing.T) {
+	m := scs.New()
+	store := scsstore.New(m)
+	var h http.Handler = http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		session := handler.MustExtractSession(r)
+		session.Values["k"] = "v"
+		if err := session.Save(r, w); err != nil {
+			t.Errorf("failed to save session: %v", err)
+		}
+		if got, want := m.GetString(r.Context(), "k"), "v"; got != want {
+			t.Errorf("value seen by scs: got %q, want %q", got, want)
+		}
+	})
+	h = m.LoadAndSave(handler.WithSession("s", store, h, nil))
+	recorder := httptest.NewRecorder()
+	h.ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+	if got, want := len(recorder.Result().Cookies()), 1; got != want {
+		t.Errorf("cookie count: got %d, want %d", got, want)
+	}
+}
+
+func TestHealth(t *testing.T) {
+	var h handler.Healther = scsstore.New(scs.New())
+	if err := h.Health(context.Background()); err != nil {
+		t.Errorf("failed health check: %v", err)
+	}
+}
@@ -0,0 +1,151 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// FramePolicy governs which pages may embed a response in a frame.
+type FramePolicy int
+
+const (
+	// FrameAny leaves framing unrestricted, sending no header to govern it.
+	FrameAny FramePolicy = iota
+	// FrameDeny forbids all pages from framing the response.
+	FrameDeny
+	// FrameSameOrigin permits only pages from the response's own origin to frame it.
+	FrameSameOrigin
+)
+
+// SecurityPolicy describes the security-related headers that SecurityHeaders adds to responses.
+// The zero value adds none of them; DefaultSecurityPolicy returns a hardened starting point.
+type SecurityPolicy struct {
+	// HSTSMaxAge, if positive, is how long browsers should insist on reaching the site only over
+	// HTTPS, sent in the Strict-Transport-Security header. It's sent only in responses to requests
+	// that arrived over TLS, or that a proxy reports arrived over HTTPS if TrustForwardedProto is
+	// true, as browsers ignore it otherwise.
+	HSTSMaxAge time.Duration
+	// HSTSIncludeSubdomains extends the Strict-Transport-Security header to all subdomains.
+	HSTSIncludeSubdomains bool
+	// HSTSPreload requests inclusion in browsers' preload lists of HTTPS-only sites.
+	HSTSPreload bool
+	// TrustForwardedProto consults the X-Forwarded-Proto header set by a proxy terminating TLS to
+	// decide whether a request arrived over HTTPS.
+	TrustForwardedProto bool
+	// NoSniff forbids browsers from guessing content types other than the one declared, sending
+	// "nosniff" in the X-Content-Type-Options header.
+	NoSniff bool
+	// Framing governs which pages may frame responses, sent both in the X-Frame-Options header and
+	// in the frame-ancestors directive of a Content-Security-Policy header.
+	Framing FramePolicy
+	// ReferrerPolicy, if not empty, is sent in the Referrer-Policy header, such as
+	// "strict-origin-when-cross-origin" or "no-referrer".
+	ReferrerPolicy string
+	// PermissionsPolicy maps browser features, such as "camera" or "geolocation", to the origins
+	// permitted to use them, sent in the Permissions-Policy header. An empty list of origins
+	// disables the feature entirely; use "self" to permit the response's own origin, and "*" for all
+	// origins.
+	PermissionsPolicy map[string][]string
+}
+
+// DefaultSecurityPolicy returns a SecurityPolicy suitable for most applications bearing sessions:
+// it enforces HTTPS for two years across subdomains, forbids content type sniffing and all
+// framing, sends only the origin in the Referer header for cross-origin requests, and disables
+// access to the camera, microphone, and geolocation.
+func DefaultSecurityPolicy() SecurityPolicy {
+	return SecurityPolicy{
+		HSTSMaxAge:            2 * 365 * 24 * time.Hour,
+		HSTSIncludeSubdomains: true,
+		NoSniff:               true,
+		Framing:               FrameDeny,
+		ReferrerPolicy:        "strict-origin-when-cross-origin",
+		PermissionsPolicy: map[string][]string{
+			"camera":      nil,
+			"microphone":  nil,
+			"geolocation": nil,
+		},
+	}
+}
+
+func (p SecurityPolicy) hsts() string {
+	if p.HSTSMaxAge <= 0 {
+		return ""
+	}
+	v := "max-age=" + strconv.FormatInt(int64(p.HSTSMaxAge/time.Second), 10)
+	if p.HSTSIncludeSubdomains {
+		v += "; includeSubDomains"
+	}
+	if p.HSTSPreload {
+		v += "; preload"
+	}
+	return v
+}
+
+func (p SecurityPolicy) permissions() string {
+	features := make([]string, 0, len(p.PermissionsPolicy))
+	for feature := range p.PermissionsPolicy {
+		features = append(features, feature)
+	}
+	sort.Strings(features)
+	directives := make([]string, len(features))
+	for i, feature := range features {
+		origins := make([]string, len(p.PermissionsPolicy[feature]))
+		for j, o := range p.PermissionsPolicy[feature] {
+			switch o {
+			case "self", "*":
+				origins[j] = o
+			default:
+				origins[j] = strconv.Quote(o)
+			}
+		}
+		directives[i] = feature + "=(" + strings.Join(origins, " ") + ")"
+	}
+	return strings.Join(directives, ", ")
+}
+
+// SecurityHeaders returns an HTTP handler that adds the headers described by the SecurityPolicy to
+// each response before delegating to the supplied handler, which may then replace any of them for
+// its own responses. It panics if the supplied handler is nil.
+//
+// Note that for FrameDeny and FrameSameOrigin, it sends a Content-Security-Policy header bearing
+// only the frame-ancestors directive. A handler that sets its own Content-Security-Policy header
+// replaces this one, and so should include its own frame-ancestors directive.
+func SecurityHeaders(p SecurityPolicy, h http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	hsts, permissions := p.hsts(), p.permissions()
+	var frameOptions, frameAncestors string
+	switch p.Framing {
+	case FrameDeny:
+		frameOptions, frameAncestors = "DENY", "frame-ancestors 'none'"
+	case FrameSameOrigin:
+		frameOptions, frameAncestors = "SAMEORIGIN", "frame-ancestors 'self'"
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		header := w.Header()
+		if len(hsts) != 0 && (r.TLS != nil || p.TrustForwardedProto && forwardedOverHTTPS(r)) {
+			header.Set("Strict-Transport-Security", hsts)
+		}
+		if p.NoSniff {
+			header.Set("X-Content-Type-Options", "nosniff")
+		}
+		if len(frameOptions) != 0 {
+			header.Set("X-Frame-Options", frameOptions)
+			header.Set("Content-Security-Policy", frameAncestors)
+		}
+		if len(p.ReferrerPolicy) != 0 {
+			header.Set("Referrer-Policy", p.ReferrerPolicy)
+		}
+		if len(permissions) != 0 {
+			header.Set("Permissions-Policy", permissions)
+		}
+		h.ServeHTTP(w, r)
+	})
+}
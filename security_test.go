@@ -0,0 +1,72 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+func TestSecurityHeadersPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.SecurityHeaders(handler.DefaultSecurityPolicy(), nil)
+}
+
+func TestSecurityHeaders(t *testing.T) {
+	serve := func(p handler.SecurityPolicy, r *http.Request) http.Header {
+		recorder := httptest.NewRecorder()
+		handler.SecurityHeaders(p, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusNoContent)
+		})).ServeHTTP(recorder, r)
+		return recorder.Header()
+	}
+
+	header := serve(handler.DefaultSecurityPolicy(), httptest.NewRequest("", "https://example.com/", nil))
+	for name, want := range map[string]string{
+		"Strict-Transport-Security": "max-age=63072000; includeSubDomains",
+		"X-Content-Type-Options":    "nosniff",
+		"X-Frame-Options":           "DENY",
+		"Content-Security-Policy":   "frame-ancestors 'none'",
+		"Referrer-Policy":           "strict-origin-when-cross-origin",
+		"Permissions-Policy":        "camera=(), geolocation=(), microphone=()",
+	} {
+		if got := header.Get(name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	p := handler.SecurityPolicy{
+		HSTSMaxAge:          time.Hour,
+		HSTSPreload:         true,
+		TrustForwardedProto: true,
+		Framing:             handler.FrameSameOrigin,
+		PermissionsPolicy:   map[string][]string{"fullscreen": {"self", "https://cdn.example.com"}},
+	}
+	r := httptest.NewRequest("", "http://example.com/", nil)
+	if got := serve(p, r).Get("Strict-Transport-Security"); len(got) != 0 {
+		t.Errorf("sent HSTS header over plain HTTP: %q", got)
+	}
+	r.Header.Set("X-Forwarded-Proto", "https")
+	header = serve(p, r)
+	for name, want := range map[string]string{
+		"Strict-Transport-Security": "max-age=3600; preload",
+		"X-Content-Type-Options":    "",
+		"X-Frame-Options":           "SAMEORIGIN",
+		"Content-Security-Policy":   "frame-ancestors 'self'",
+		"Referrer-Policy":           "",
+		"Permissions-Policy":        `fullscreen=(self "https://cdn.example.com")`,
+	} {
+		if got := header.Get(name); got != want {
+			t.Errorf("%s: got %q, want %q", name, got, want)
+		}
+	}
+
+	if got := serve(handler.SecurityPolicy{}, r); len(got) != 0 {
+		t.Errorf("zero policy sent headers: %v", got)
+	}
+}
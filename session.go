@@ -5,9 +5,9 @@ package handler
 
 import (
 	"context"
+	"fmt"
 	"net/http"
 
-	"github.com/gorilla/securecookie"
 	"github.com/gorilla/sessions"
 )
 
@@ -22,23 +22,28 @@ type SessionSource interface {
 
 func getValidOrNewSessionFrom(name string, s SessionSource, r *http.Request) (*sessions.Session, error) {
 	session, err := s.New(r, name)
-	if err != nil && err != http.ErrNoCookie {
-		if serr, ok := err.(securecookie.Error); !ok || !serr.IsDecode() {
-			return session, err
-		}
+	if err != nil && err != http.ErrNoCookie && !IsDecodeError(err) {
+		return session, fmt.Errorf("acquiring session %q: %w", name, err)
 	}
 	return session, nil
 }
 
-func makeSingleKeyHandler(name string, contextKey interface{}, s SessionSource, h http.Handler, onError func(w http.ResponseWriter, r *http.Request, err error)) http.Handler {
+func makeSingleKeyHandler(name string, contextKey interface{}, s SessionSource, h http.Handler, onError func(w http.ResponseWriter, r *http.Request, err error), c *bindingConfig) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		session, err := getValidOrNewSessionFrom(name, s, r)
 		if err != nil {
 			onError(w, r, err)
 			return
 		}
+		acquired, fresh := session, session.IsNew
+		session = c.prepare(r, session)
 		ctx := context.WithValue(r.Context(), contextKey, session)
-		h.ServeHTTP(w, r.WithContext(ctx))
+		if c.hooksResponse() {
+			c.serveHooked(h, w, r.WithContext(ctx), []*sessions.Session{session})
+		} else {
+			h.ServeHTTP(w, r.WithContext(ctx))
+		}
+		c.recycleSession(acquired, fresh)
 	})
 }
 
@@ -61,10 +66,12 @@ type sessionContextKey struct{}
 // WithSession returns an HTTP handler that binds a session with the given name to each submitted
 // request, delegating further request processing to the supplied HTTP handler, which can then
 // retrieve this bound session with either ExtractSession or MustExtractSession. It panics if either
-// the supplied SessionSource or handler is nil. If the SessionSource yields an error instead of a
-// session, it delegates further request processing to the onError handler. If no such onError
-// handler is supplied and an error arises acquiring a session, it will respond with HTTP status
-// code 500 with no body.
+// the supplied SessionSource or handler is nil, unless the Disabled option permits a nil
+// SessionSource. If the SessionSource yields an error instead of a session, it delegates further
+// request processing to the onError handler. If no such onError handler is supplied and an error
+// arises acquiring a session, it will respond with HTTP status code 500 with no body, or per the
+// MapErrorStatuses Option, unless the error indicates overload per DetectOverload, in which case it
+// responds with status code 503. The supplied Options further adjust how it binds sessions.
 //
 // Note that even though this bound session has a name, supplied for consumption by the
 // SessionSource, WithSession binds at most one session to a given request (as an anonymous
@@ -72,7 +79,9 @@ type sessionContextKey struct{}
 // the session more efficient than the multiple sessions that the similar WithSessionsNamed
 // binds. To bind multiple sessions with different names to a given request, use WithSessionsNamed
 // instead.
-func WithSession(name string, s SessionSource, h http.Handler, onError func(w http.ResponseWriter, r *http.Request, err error)) http.Handler {
+func WithSession(name string, s SessionSource, h http.Handler, onError func(w http.ResponseWriter, r *http.Request, err error), opts ...Option) http.Handler {
+	c := newBindingConfig(opts)
+	s = c.source(s)
 	if s == nil {
 		panic("no session source supplied")
 	}
@@ -80,9 +89,9 @@ func WithSession(name string, s SessionSource, h http.Handler, onError func(w ht
 		panic("no consuming HTTP handler supplied")
 	}
 	if onError == nil {
-		onError = func(w http.ResponseWriter, _ *http.Request, _ error) { sendDefaultResponse(w) }
+		onError = c.respondToError
 	}
-	return makeSingleKeyHandler(name, sessionContextKey{}, s, h, onError)
+	return makeSingleKeyHandler(name, sessionContextKey{}, s, h, onError, c)
 }
 
 // ExtractSession retrieves the singular session most recently bound to this request via
@@ -92,12 +101,13 @@ func ExtractSession(r *http.Request) (s *sessions.Session, ok bool) {
 }
 
 // MustExtractSession retrieves the singular session most recently bound to this request via
-// WithSession, or panics if no such session is available.
+// WithSession. If no such session is available, it defers to OnMissingSession, which panics by
+// default.
 func MustExtractSession(r *http.Request) *sessions.Session {
 	if s, ok := ExtractSession(r); ok {
 		return s
 	}
-	panic("no session available")
+	return OnMissingSession(r, "")
 }
 
 type namedSessionContextKey string
@@ -105,17 +115,27 @@ type namedSessionContextKey string
 // WithSessionsNamed returns an HTTP handler that binds any number of sessions with the given set of
 // names to each submitted request, delegating further request processing to the supplied HTTP
 // handler, which can then retrieve these bound sessions with either ExtractSessionNamed or
-// MustExtractSessionNamed. It panics if either the supplied SessionSource or handler is nil. If the
-// SessionSource yields an error instead of a session, it delegates further request processing to
-// the onError handler. If no such onError handler is supplied and an error arises acquiring a
-// session, it will respond with HTTP status code 500 with no body.
+// MustExtractSessionNamed. It panics if either the supplied SessionSource or handler is nil, unless
+// the Disabled option permits a nil SessionSource. If the SessionSource yields an error instead of
+// a session, it delegates further request processing to the onError handler. If no such onError
+// handler is supplied and an error arises acquiring a session, it will respond with HTTP status
+// code 500 with no body, or per the MapErrorStatuses Option, unless the error indicates overload
+// per DetectOverload, in which case it responds with status code 503. The supplied Options further
+// adjust how it binds sessions.
 //
 // It reduces the sequence of names supplied to a set, with no duplicate entries, but it does not
 // mutate the supplied slice in place. If no names are supplied, it returns the supplied HTTP
 // handler.
 //
+// It acquires the sessions in the order in which their names first appear in the supplied
+// sequence, stopping at the first error. The request that it passes to the onError handler bears
+// the sessions acquired before that error, so that an error page can still use them, such as to
+// personalize its content; ExtractSessionNamed reports the rest as unavailable.
+//
 // To bind only a single session to a given request, consider using WithSession instead.
-func WithSessionsNamed(names []string, s SessionSource, h http.Handler, onError func(w http.ResponseWriter, r *http.Request, name string, err error)) http.Handler {
+func WithSessionsNamed(names []string, s SessionSource, h http.Handler, onError func(w http.ResponseWriter, r *http.Request, name string, err error), opts ...Option) http.Handler {
+	c := newBindingConfig(opts)
+	s = c.source(s)
 	if s == nil {
 		panic("no session source supplied")
 	}
@@ -123,10 +143,10 @@ func WithSessionsNamed(names []string, s SessionSource, h http.Handler, onError
 		panic("no consuming HTTP handler supplied")
 	}
 	if onError == nil {
-		onError = func(w http.ResponseWriter, _ *http.Request, _ string, _ error) { sendDefaultResponse(w) }
+		onError = func(w http.ResponseWriter, r *http.Request, _ string, err error) { c.respondToError(w, r, err) }
 	}
-	// If there is more than one name supplied, whittle them down to a set, without bothering to
-	// preserve order.
+	// If there is more than one name supplied, whittle them down to a set, preserving the order in
+	// which each name first appears.
 	switch len(names) {
 	case 0:
 		return h
@@ -137,42 +157,55 @@ func WithSessionsNamed(names []string, s SessionSource, h http.Handler, onError
 			names = names[:1]
 		}
 	default:
-		// Assume that we can't mutate "names" in place, or we could sort it and then eliminate
-		// adjacent duplicates.
+		// Assume that we can't mutate "names" in place.
 		m := make(map[string]struct{}, len(names))
-		present := struct{}{}
+		unique := make([]string, 0, len(names))
 		for _, n := range names {
-			m[n] = present
-		}
-		switch n := len(m); {
-		case n == 1: // All duplicates.
-			goto single
-		case n != len(names): // At least one duplicate.
-			names = make([]string, n)
-			i := 0
-			for k := range m {
-				names[i] = k
-				i++
+			if _, ok := m[n]; !ok {
+				m[n] = struct{}{}
+				unique = append(unique, n)
 			}
 		}
+		names = unique
+	}
+	if len(names) == 1 { // All duplicates.
+		goto single
 	}
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
 		ctx := r.Context()
+		var bound, fresh []*sessions.Session
+		if c.hooksResponse() {
+			bound = make([]*sessions.Session, 0, len(names))
+		}
 		for _, name := range names {
 			session, err := getValidOrNewSessionFrom(name, s, r)
 			if err != nil {
-				onError(w, r, name, err)
+				onError(w, r.WithContext(ctx), name, err)
 				return
 			}
+			if c.recycler != nil && session.IsNew {
+				fresh = append(fresh, session)
+			}
+			session = c.prepare(r, session)
+			if bound != nil {
+				bound = append(bound, session)
+			}
 			ctx = context.WithValue(ctx, namedSessionContextKey(name), session)
 		}
-		h.ServeHTTP(w, r.WithContext(ctx))
+		if bound != nil {
+			c.serveHooked(h, w, r.WithContext(ctx), bound)
+		} else {
+			h.ServeHTTP(w, r.WithContext(ctx))
+		}
+		for _, session := range fresh {
+			c.recycleSession(session, true)
+		}
 	})
 
 single:
 	name := names[0]
 	return makeSingleKeyHandler(name, namedSessionContextKey(name), s, h,
-		func(w http.ResponseWriter, r *http.Request, err error) { onError(w, r, name, err) })
+		func(w http.ResponseWriter, r *http.Request, err error) { onError(w, r, name, err) }, c)
 }
 
 // ExtractSessionNamed retrieves the session most recently bound to this request with the given name
@@ -182,10 +215,11 @@ func ExtractSessionNamed(name string, r *http.Request) (s *sessions.Session, ok
 }
 
 // MustExtractSessionNamed retrieves the session most recently bound to this request with the given
-// name via WithSessionsNamed, or panics if no such session is available.
+// name via WithSessionsNamed. If no such session is available, it defers to OnMissingSession, which
+// panics by default.
 func MustExtractSessionNamed(name string, r *http.Request) *sessions.Session {
 	if s, ok := ExtractSessionNamed(name, r); ok {
 		return s
 	}
-	panic("no session available")
+	return OnMissingSession(r, name)
 }
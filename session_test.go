@@ -8,6 +8,7 @@ import (
 	"io/ioutil"
 	"net/http"
 	"net/http/httptest"
+	"strings"
 	"testing"
 
 	"github.com/gorilla/sessions"
@@ -95,7 +96,7 @@ func TestWithSessionSourceFailure(t *testing.T) {
 			called := false
 			onError := func(w http.ResponseWriter, r *http.Request, err error) {
 				called = true
-				if err != test.expectedError {
+				if !errors.Is(err, test.expectedError) {
 					t.Error("onError handler received wrong error")
 				}
 			}
@@ -292,7 +293,7 @@ func TestWithSessionsNamedSourceFailure(t *testing.T) {
 					called := false
 					onError := func(w http.ResponseWriter, r *http.Request, name string, err error) {
 						called = true
-						if err != expectedError {
+						if !errors.Is(err, expectedError) {
 							t.Error("onError handler received wrong error")
 						}
 					}
@@ -311,6 +312,53 @@ func TestWithSessionsNamedSourceFailure(t *testing.T) {
 	}
 }
 
+// nameFailingSessionSource fails to supply sessions with one name, recording the order in which
+// sessions are requested.
+type nameFailingSessionSource struct {
+	failing   string
+	requested []string
+}
+
+func (s *nameFailingSessionSource) New(r *http.Request, name string) (*sessions.Session, error) {
+	s.requested = append(s.requested, name)
+	if name == s.failing {
+		return failingSessionSource{errors.New("unavailable")}.New(r, name)
+	}
+	return simpleStore{}.New(r, name)
+}
+
+func TestWithSessionsNamedErrorBearsBoundSessions(t *testing.T) {
+	source := nameFailingSessionSource{failing: "bad"}
+	called := false
+	onError := func(w http.ResponseWriter, r *http.Request, name string, err error) {
+		called = true
+		if name != "bad" {
+			t.Errorf("onError handler received name %q, want %q", name, "bad")
+		}
+		for _, bound := range []string{"a", "b"} {
+			if _, ok := handler.ExtractSessionNamed(bound, r); !ok {
+				t.Errorf("session %q acquired before the error is not bound", bound)
+			}
+		}
+		for _, unbound := range []string{"bad", "c"} {
+			if _, ok := handler.ExtractSessionNamed(unbound, r); ok {
+				t.Errorf("session %q is bound despite the error", unbound)
+			}
+		}
+	}
+	delegate := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+		t.Error("HTTP handler should not have been called")
+	})
+	handler.WithSessionsNamed([]string{"b", "a", "b", "bad", "c", "a"}, &source, delegate, onError).
+		ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+	if !called {
+		t.Error("onError handler was not called")
+	}
+	if got, want := strings.Join(source.requested, ","), "b,a,bad"; got != want {
+		t.Errorf("sessions requested: got %s, want %s", got, want)
+	}
+}
+
 func TestWithSessionsNamedCookieExtractionError(t *testing.T) {
 	tests := []struct {
 		description   string
@@ -0,0 +1,67 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+)
+
+// sessionInfo is the body of the responses from SessionInfoHandler.
+type sessionInfo struct {
+	Authenticated bool       `json:"authenticated"`
+	Principal     string     `json:"principal,omitempty"`
+	ExpiresAt     *time.Time `json:"expires_at,omitempty"`
+	IdleDeadline  *time.Time `json:"idle_deadline,omitempty"`
+}
+
+func timeOrNil(t time.Time) *time.Time {
+	if t.IsZero() {
+		return nil
+	}
+	t = t.UTC()
+	return &t
+}
+
+// SessionInfoHandler returns an HTTP handler that describes the singular session bound to each
+// request via WithSession, without disclosing its values, for single-page applications to drive
+// interfaces such as a warning that the user is about to be signed out. It responds with a JSON
+// object with the following members:
+//
+//	authenticated  whether a principal is associated with the session, per SessionPrincipal
+//	principal      the principal's display name, omitted if not authenticated
+//	expires_at     when the session expires, per the policy's Deadlines, omitted if unknown
+//	idle_deadline  when the session expires unless extended, omitted if unknown
+//
+// such as {"authenticated":true,"principal":"Alice","expires_at":"2017-01-01T01:00:00Z",
+// "idle_deadline":"2017-01-01T00:30:00Z"}. It describes fresh sessions as unauthenticated, with no
+// deadlines. The displayName function, if supplied, transforms principals into the names to
+// display; otherwise it reports the principals themselves. It responds to requests with no bound
+// session with HTTP status code 500 with no body.
+func SessionInfoHandler(p TouchPolicy, displayName func(principal string) string) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := ExtractSession(r)
+		if !ok {
+			sendDefaultResponse(w)
+			return
+		}
+		var info sessionInfo
+		if !s.IsNew {
+			if principal, ok := SessionPrincipal(s); ok {
+				info.Authenticated = true
+				if displayName != nil {
+					principal = displayName(principal)
+				}
+				info.Principal = principal
+			}
+			d := p.Deadlines(s)
+			info.ExpiresAt = timeOrNil(d.Expires())
+			info.IdleDeadline = timeOrNil(d.Idle)
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(info)
+	})
+}
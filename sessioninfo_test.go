@@ -0,0 +1,49 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+func TestSessionInfoHandler(t *testing.T) {
+	start := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	p := handler.TouchPolicy{IdleTimeout: 30 * time.Minute, AbsoluteLifetime: time.Hour}
+	info := handler.SessionInfoHandler(p, strings.ToUpper)
+	for _, test := range []struct {
+		description string
+		source      handler.SessionSource
+		want        string
+	}{
+		{"fresh", simpleStore{}, `{"authenticated":false}`},
+		{"anonymous", valuesSource{"secret": "value"}, `{"authenticated":false}`},
+		{"authenticated", valuesSource{
+			handler.PrincipalKey:   "alice",
+			handler.StartedAtKey:   start.Unix(),
+			handler.TouchedAtKey:   start.Add(10 * time.Minute).Unix(),
+			handler.RefreshedAtKey: start.Add(5 * time.Minute).Unix(),
+		}, `{"authenticated":true,"principal":"ALICE","expires_at":"2017-01-01T00:40:00Z","idle_deadline":"2017-01-01T00:40:00Z"}`},
+		{"near absolute expiry", valuesSource{
+			handler.PrincipalKey:   "alice",
+			handler.StartedAtKey:   start.Unix(),
+			handler.RefreshedAtKey: start.Add(50 * time.Minute).Unix(),
+		}, `{"authenticated":true,"principal":"ALICE","expires_at":"2017-01-01T01:00:00Z","idle_deadline":"2017-01-01T01:20:00Z"}`},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			recorder := httptest.NewRecorder()
+			handler.WithSession("s", test.source, info, nil).ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+			if got, want := strings.TrimSpace(recorder.Body.String()), test.want; got != want {
+				t.Errorf("body: got %s, want %s", got, want)
+			}
+			if got, want := recorder.Header().Get("Cache-Control"), "no-store"; got != want {
+				t.Errorf("Cache-Control: got %q, want %q", got, want)
+			}
+		})
+	}
+}
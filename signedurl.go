@@ -0,0 +1,150 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// Query parameters that SignURL adds to the URLs it signs.
+const (
+	signedURLExpiresParam   = "expires"
+	signedURLSignatureParam = "signature"
+)
+
+// ErrNoIdentity is the error that URLSigner.SignURL returns when the request has neither a
+// principal nor a bound session with an ID to which to tie the signed URL.
+var ErrNoIdentity = errors.New("no principal or session identity available")
+
+// URLSigner signs URLs such that they remain valid only until they expire, and only for requests
+// made on behalf of the same principal or, absent one, in the same session, as the request for
+// which it signed them. Such URLs suit links to downloads or callbacks that shouldn't be shared,
+// without needing to store any state per link.
+type URLSigner struct {
+	// Keys holds the HMAC keys with which to sign URLs. The URLSigner signs with the first key, and
+	// accepts signatures made with any of them, permitting rotation of the keys. It's required.
+	Keys [][]byte
+	// Clock reports the current time. If nil, the URLSigner uses SystemClock.
+	Clock Clock
+}
+
+func (s *URLSigner) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock.Now()
+	}
+	return SystemClock.Now()
+}
+
+// requestIdentity identifies the principal of the request or, absent one, the ID of the singular
+// session bound to the request via WithSession.
+func requestIdentity(r *http.Request) (string, bool) {
+	if p, ok := ExtractPrincipal(r); ok {
+		return "principal:" + p, true
+	}
+	if s, ok := ExtractSession(r); ok && len(s.ID) != 0 {
+		return "session:" + s.ID, true
+	}
+	return "", false
+}
+
+func signURL(key []byte, identity string, u *url.URL) []byte {
+	mac := hmac.New(sha256.New, key)
+	mac.Write([]byte(identity))
+	mac.Write([]byte{0})
+	mac.Write([]byte(u.EscapedPath()))
+	mac.Write([]byte{0})
+	// Encode sorts the parameters by key, making the signature independent of their order.
+	mac.Write([]byte(u.Query().Encode()))
+	return mac.Sum(nil)
+}
+
+// SignURL returns the supplied URL with its expiration time and signature added as the "expires"
+// and "signature" query parameters, valid for the given time to live. It ties the signature to
+// the principal of the request, per ExtractPrincipal, or, absent one, to the ID of the singular
+// session bound to the request via WithSession, returning ErrNoIdentity if neither is available.
+// Note that sessions.CookieStore doesn't assign IDs to sessions. It panics if the URLSigner has no
+// keys.
+func (s *URLSigner) SignURL(r *http.Request, rawURL string, ttl time.Duration) (string, error) {
+	if len(s.Keys) == 0 {
+		panic("no URL signing keys supplied")
+	}
+	identity, ok := requestIdentity(r)
+	if !ok {
+		return "", ErrNoIdentity
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return "", err
+	}
+	q := u.Query()
+	q.Del(signedURLSignatureParam)
+	q.Set(signedURLExpiresParam, strconv.FormatInt(s.now().Add(ttl).Unix(), 10))
+	u.RawQuery = q.Encode()
+	q.Set(signedURLSignatureParam, base64.RawURLEncoding.EncodeToString(signURL(s.Keys[0], identity, u)))
+	u.RawQuery = q.Encode()
+	return u.String(), nil
+}
+
+// verify reports whether the request's URL bears a valid, unexpired signature made by SignURL for
+// the request's principal or session.
+func (s *URLSigner) verify(r *http.Request) bool {
+	identity, ok := requestIdentity(r)
+	if !ok {
+		return false
+	}
+	q := r.URL.Query()
+	sig, err := base64.RawURLEncoding.DecodeString(q.Get(signedURLSignatureParam))
+	if err != nil || len(sig) == 0 {
+		return false
+	}
+	expires, err := strconv.ParseInt(q.Get(signedURLExpiresParam), 10, 64)
+	if err != nil || !s.now().Before(time.Unix(expires, 0)) {
+		return false
+	}
+	q.Del(signedURLSignatureParam)
+	u := *r.URL
+	u.RawQuery = q.Encode()
+	for _, key := range s.Keys {
+		if hmac.Equal(sig, signURL(key, identity, &u)) {
+			return true
+		}
+	}
+	return false
+}
+
+// VerifySignedURL returns an HTTP handler that accepts only requests whose URLs SignURL signed for
+// the same principal or session, and that haven't yet expired, delegating them to the supplied
+// handler. Since it identifies the session by the singular session bound to the request via
+// WithSession, it must run within WithSession for URLs tied to sessions.
+//
+// It delegates rejected requests to the onReject handler. If no such onReject handler is supplied,
+// it will respond with HTTP status code 403 with no body. It panics if the supplied handler is nil
+// or if the URLSigner has no keys.
+func (s *URLSigner) VerifySignedURL(h http.Handler, onReject http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	if len(s.Keys) == 0 {
+		panic("no URL signing keys supplied")
+	}
+	if onReject == nil {
+		onReject = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusForbidden)
+		})
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !s.verify(r) {
+			onReject.ServeHTTP(w, r)
+			return
+		}
+		h.ServeHTTP(w, r)
+	})
+}
@@ -0,0 +1,85 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+)
+
+func TestVerifySignedURLPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	(&handler.URLSigner{Keys: [][]byte{[]byte("k")}}).VerifySignedURL(nil, nil)
+}
+
+func TestVerifySignedURLPanicsWithNoKeys(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	(&handler.URLSigner{}).VerifySignedURL(http.NotFoundHandler(), nil)
+}
+
+func TestSignURLRequiresIdentity(t *testing.T) {
+	s := handler.URLSigner{Keys: [][]byte{[]byte("k")}}
+	if _, err := s.SignURL(httptest.NewRequest(http.MethodGet, "/", nil), "/download", time.Minute); err != handler.ErrNoIdentity {
+		t.Errorf("error: got %v, want %v", err, handler.ErrNoIdentity)
+	}
+}
+
+func TestSignedURL(t *testing.T) {
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	signer := &handler.URLSigner{Keys: [][]byte{[]byte("current"), []byte("former")}, Clock: clock}
+	former := &handler.URLSigner{Keys: [][]byte{[]byte("former")}, Clock: clock}
+	other := &handler.URLSigner{Keys: [][]byte{[]byte("other")}, Clock: clock}
+	as := func(principal string, r *http.Request) *http.Request {
+		return handler.BindPrincipal(r, principal)
+	}
+	sign := func(s *handler.URLSigner, principal, u string) string {
+		signed, err := s.SignURL(as(principal, httptest.NewRequest(http.MethodGet, "/", nil)), u, time.Hour)
+		if err != nil {
+			t.Fatalf("failed to sign URL: %v", err)
+		}
+		return signed
+	}
+	h := signer.VerifySignedURL(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}), nil)
+	serve := func(principal, u string) int {
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, as(principal, httptest.NewRequest(http.MethodGet, u, nil)))
+		return recorder.Code
+	}
+
+	signed := sign(signer, "alice", "/download?file=report.pdf&format=a4")
+	tests := []struct {
+		description string
+		principal   string
+		url         string
+		want        int
+	}{
+		{"signed URL", "alice", signed, http.StatusNoContent},
+		{"signed with former key", "alice", sign(former, "alice", "/download?file=report.pdf"), http.StatusNoContent},
+		{"signed with unknown key", "alice", sign(other, "alice", "/download?file=report.pdf"), http.StatusForbidden},
+		{"other principal", "bob", signed, http.StatusForbidden},
+		{"altered parameter", "alice", strings.Replace(signed, "report", "secret", 1), http.StatusForbidden},
+		{"altered path", "alice", strings.Replace(signed, "/download", "/delete", 1), http.StatusForbidden},
+		{"unsigned URL", "alice", "/download?file=report.pdf", http.StatusForbidden},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if got := serve(test.principal, test.url); got != test.want {
+				t.Errorf("status: got %d, want %d", got, test.want)
+			}
+		})
+	}
+
+	clock.Advance(time.Hour)
+	if got, want := serve("alice", signed), http.StatusForbidden; got != want {
+		t.Errorf("status after expiry: got %d, want %d", got, want)
+	}
+}
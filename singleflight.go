@@ -0,0 +1,81 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"net/http"
+	"sync"
+
+	"github.com/gorilla/sessions"
+)
+
+// flight is a session acquisition in progress, shared by all the requests awaiting it.
+type flight struct {
+	done    chan struct{}
+	session *sessions.Session
+	err     error
+}
+
+// StoreCloner is implemented by session stores that hold state particular to a single session, such
+// as the values that it was loaded with, so that SingleflightSource can give each of the requests
+// sharing an acquisition of the session a store of its own.
+type StoreCloner interface {
+	// CloneStore returns a copy of the store whose state is independent of this one's.
+	CloneStore() sessions.Store
+}
+
+type singleflightSource struct {
+	SessionSource
+	mu      sync.Mutex
+	flights map[string]*flight
+}
+
+// SingleflightSource returns a SessionSource that coalesces concurrent acquisitions of the same
+// session—those with the same name, by requests bearing the same cookie for it—into a single call
+// to the supplied SessionSource. When a burst of requests arrives for one session at once, such as
+// over a multiplexed HTTP/2 connection or from a single-page application fanning out its API calls,
+// only one of them fetches the session from the backend. Requests bearing no cookie for the session
+// acquire it directly. It panics if the supplied SessionSource is nil.
+//
+// Each request receives its own deep copy of the session, as DetachSession makes, so that request
+// handlers can't observe each other's changes. If the session's store implements StoreCloner, each
+// copy is bound to its own clone of the store, so that saving the copies concurrently is safe.
+func SingleflightSource(s SessionSource) SessionSource {
+	if s == nil {
+		panic("no session source supplied")
+	}
+	return &singleflightSource{SessionSource: s, flights: make(map[string]*flight)}
+}
+
+func (s *singleflightSource) New(r *http.Request, name string) (*sessions.Session, error) {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return s.SessionSource.New(r, name)
+	}
+	key := name + "\x00" + c.Value
+	s.mu.Lock()
+	f, ok := s.flights[key]
+	if !ok {
+		f = &flight{done: make(chan struct{})}
+		s.flights[key] = f
+	}
+	s.mu.Unlock()
+	if ok {
+		<-f.done
+	} else {
+		f.session, f.err = s.SessionSource.New(r, name)
+		s.mu.Lock()
+		delete(s.flights, key)
+		s.mu.Unlock()
+		close(f.done)
+	}
+	if f.session == nil {
+		return nil, f.err
+	}
+	session := copySession(f.session)
+	if sc, ok := session.Store().(StoreCloner); ok {
+		session = rebindSession(session, sc.CloneStore())
+	}
+	return session, f.err
+}
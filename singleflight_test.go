@@ -0,0 +1,142 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/kvstore"
+)
+
+func TestSingleflightSourcePanicsWithNoSource(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.SingleflightSource(nil)
+}
+
+// blockingSource is a SessionSource that counts its calls, and blocks each one until released.
+type blockingSource struct {
+	calls   int32
+	release chan struct{}
+}
+
+func (b *blockingSource) New(r *http.Request, name string) (*sessions.Session, error) {
+	atomic.AddInt32(&b.calls, 1)
+	<-b.release
+	s := sessions.NewSession(nil, name)
+	s.Values["uid"] = "alice"
+	return s, nil
+}
+
+func TestSingleflightSource(t *testing.T) {
+	b := &blockingSource{release: make(chan struct{})}
+	source := handler.SingleflightSource(b)
+	r := httptest.NewRequest("", "/", nil)
+	r.AddCookie(&http.Cookie{Name: "s", Value: "v"})
+
+	const n = 8
+	results := make([]*sessions.Session, n)
+	var wg sync.WaitGroup
+	started := make(chan struct{})
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			if i == 0 {
+				close(started)
+			}
+			results[i], _ = source.New(r, "s")
+		}(i)
+	}
+	<-started
+	// Let the other requests join the first one's acquisition.
+	time.Sleep(20 * time.Millisecond)
+	close(b.release)
+	wg.Wait()
+	if calls := atomic.LoadInt32(&b.calls); calls >= n {
+		t.Errorf("calls: got %d, want fewer than %d", calls, n)
+	}
+	results[0].Values["uid"] = "bob"
+	for i, s := range results[1:] {
+		if s == results[0] || s.Values["uid"] != "alice" {
+			t.Errorf("session %d shares state with another", i+1)
+		}
+	}
+
+	// Requests without a cookie acquire their sessions directly.
+	before := atomic.LoadInt32(&b.calls)
+	source.New(httptest.NewRequest("", "/", nil), "s")
+	if got := atomic.LoadInt32(&b.calls); got != before+1 {
+		t.Errorf("calls without a cookie: got %d, want %d", got-before, 1)
+	}
+}
+
+// delayedSource is a SessionSource that waits for a signal before acquiring each session from
+// another source.
+type delayedSource struct {
+	handler.SessionSource
+	release chan struct{}
+}
+
+func (d delayedSource) New(r *http.Request, name string) (*sessions.Session, error) {
+	<-d.release
+	return d.SessionSource.New(r, name)
+}
+
+func TestSingleflightSourceWithConcurrentSaves(t *testing.T) {
+	store := kvstore.New(kvstore.NewMemory(), securecookie.GenerateRandomKey(32))
+	store.OnConflict = kvstore.MergeConflicts(handler.KeepUser)
+	r := httptest.NewRequest("", "/", nil)
+	initial, _ := store.New(r, "s")
+	recorder := httptest.NewRecorder()
+	if err := initial.Save(r, recorder); err != nil {
+		t.Fatalf("failed to save session: %v", err)
+	}
+	r = httptest.NewRequest("", "/", nil)
+	for _, c := range recorder.Result().Cookies() {
+		r.AddCookie(c)
+	}
+
+	release := make(chan struct{})
+	source := handler.SingleflightSource(delayedSource{store, release})
+	keys := []string{"a", "b"}
+	var wg sync.WaitGroup
+	for _, k := range keys {
+		wg.Add(1)
+		go func(k string) {
+			defer wg.Done()
+			s, err := source.New(r, "s")
+			if err != nil {
+				t.Errorf("failed to acquire session: %v", err)
+				return
+			}
+			s.Values[k] = true
+			if err := s.Save(r, httptest.NewRecorder()); err != nil {
+				t.Errorf("failed to save session: %v", err)
+			}
+		}(k)
+	}
+	// Let both requests join the same acquisition.
+	time.Sleep(20 * time.Millisecond)
+	close(release)
+	wg.Wait()
+
+	values, err := store.Values(context.Background(), initial.ID)
+	if err != nil {
+		t.Fatalf("failed to read session: %v", err)
+	}
+	for _, k := range keys {
+		if values[k] != true {
+			t.Errorf("value for %q: got %v, want true", k, values[k])
+		}
+	}
+}
@@ -0,0 +1,139 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// Validator is implemented by session stores and other components that can check their own
+// configuration, so that a program can fail at startup rather than upon the first request that
+// needs a session.
+type Validator interface {
+	// Validate returns nil if the component's configuration is suitable for use, or an error
+	// describing all the problems with it otherwise.
+	Validate() error
+}
+
+// Warmer is implemented by session stores and other components that depend on a backend, which
+// can establish their connections to that backend ahead of the first request that needs them.
+type Warmer interface {
+	// Warmup establishes connections to the component's backend, returning an error if it's not
+	// reachable.
+	Warmup(ctx context.Context) error
+}
+
+// ValidateCookieOptions reports the problems with the given cookie attributes for cookies with
+// the given name, or returns nil if they're consistent. It rejects SameSite=None cookies that are
+// not Secure, which browsers discard, and cookies whose names bear the "__Secure-" or "__Host-"
+// prefixes without the attributes that those prefixes demand. If the name is empty, it skips the
+// checks specific to the name.
+func ValidateCookieOptions(name string, o *sessions.Options) error {
+	if o == nil {
+		return errors.New("no cookie options supplied")
+	}
+	var problems []string
+	if o.SameSite == http.SameSiteNoneMode && !o.Secure {
+		problems = append(problems, "SameSite=None cookies must also be Secure")
+	}
+	switch {
+	case strings.HasPrefix(name, "__Host-"):
+		if !o.Secure {
+			problems = append(problems, fmt.Sprintf("cookie %q must be Secure", name))
+		}
+		if o.Path != "/" {
+			problems = append(problems, fmt.Sprintf("cookie %q must have path \"/\"", name))
+		}
+		if len(o.Domain) != 0 {
+			problems = append(problems, fmt.Sprintf("cookie %q must not have a domain", name))
+		}
+	case strings.HasPrefix(name, "__Secure-"):
+		if !o.Secure {
+			problems = append(problems, fmt.Sprintf("cookie %q must be Secure", name))
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(problems, "; "))
+}
+
+// ValidateSource checks that the SessionSource is suitable for binding sessions with the given
+// names, reporting every problem found: empty or duplicate names, and problems with the
+// SessionSource's own configuration. It defers to SessionSources that implement Validator, and
+// checks that a sessions.CookieStore or sessions.FilesystemStore has codecs and cookie attributes
+// consistent per ValidateCookieOptions for each name.
+func ValidateSource(s SessionSource, names ...string) error {
+	var problems []string
+	addProblem := func(err error) {
+		problems = append(problems, err.Error())
+	}
+	if s == nil {
+		addProblem(errors.New("no session source supplied"))
+	}
+	seen := make(map[string]struct{}, len(names))
+	unique := make([]string, 0, len(names))
+	for _, name := range names {
+		if len(name) == 0 {
+			addProblem(errors.New("empty session name"))
+			continue
+		}
+		if _, ok := seen[name]; ok {
+			addProblem(fmt.Errorf("duplicate session name %q", name))
+			continue
+		}
+		seen[name] = struct{}{}
+		unique = append(unique, name)
+	}
+	var codecs []securecookie.Codec
+	var opts *sessions.Options
+	switch store := s.(type) {
+	case Validator:
+		if err := store.Validate(); err != nil {
+			addProblem(err)
+		}
+	case *sessions.CookieStore:
+		codecs, opts = store.Codecs, store.Options
+	case *sessions.FilesystemStore:
+		codecs, opts = store.Codecs, store.Options
+	}
+	if opts != nil {
+		if len(codecs) == 0 {
+			addProblem(errors.New("store has no codecs"))
+		}
+		if len(unique) == 0 {
+			unique = append(unique, "")
+		}
+		for _, name := range unique {
+			if err := ValidateCookieOptions(name, opts); err != nil {
+				addProblem(err)
+			}
+		}
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid session configuration: " + strings.Join(problems, "; "))
+}
+
+// Warmup prepares the SessionSource to serve requests, deferring to SessionSources that implement
+// Warmer and otherwise checking the health of those that implement Healther, returning an error if
+// the SessionSource's backend is not reachable. Call it before accepting requests, so as to fail
+// fast rather than upon the first request that needs a session.
+func Warmup(ctx context.Context, s SessionSource) error {
+	switch source := s.(type) {
+	case Warmer:
+		return source.Warmup(ctx)
+	case Healther:
+		return source.Health(ctx)
+	}
+	return nil
+}
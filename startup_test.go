@@ -0,0 +1,105 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestValidateCookieOptions(t *testing.T) {
+	tests := []struct {
+		description string
+		name        string
+		options     sessions.Options
+		valid       bool
+	}{
+		{"plain", "s", sessions.Options{Path: "/"}, true},
+		{"SameSite=None without Secure", "s", sessions.Options{SameSite: http.SameSiteNoneMode}, false},
+		{"SameSite=None with Secure", "s", sessions.Options{SameSite: http.SameSiteNoneMode, Secure: true}, true},
+		{"secure prefix without Secure", "__Secure-s", sessions.Options{}, false},
+		{"secure prefix with Secure", "__Secure-s", sessions.Options{Secure: true}, true},
+		{"host prefix with domain", "__Host-s", sessions.Options{Path: "/", Domain: "example.com", Secure: true}, false},
+		{"host prefix with narrow path", "__Host-s", sessions.Options{Path: "/a", Secure: true}, false},
+		{"host prefix", "__Host-s", sessions.Options{Path: "/", Secure: true}, true},
+		{"no name", "", sessions.Options{}, true},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			if err := handler.ValidateCookieOptions(test.name, &test.options); (err == nil) != test.valid {
+				t.Errorf("error: got %v, want valid: %t", err, test.valid)
+			}
+		})
+	}
+	if err := handler.ValidateCookieOptions("s", nil); err == nil {
+		t.Error("missing options were validated")
+	}
+}
+
+func TestValidateSource(t *testing.T) {
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	// Don't rely on the library's default options, which vary across its versions.
+	store.Options.Secure = false
+	store.Options.SameSite = http.SameSiteLaxMode
+	if err := handler.ValidateSource(store, "a", "b"); err != nil {
+		t.Errorf("failed to validate source: %v", err)
+	}
+	store.Codecs = nil
+	err := handler.ValidateSource(store, "a", "", "a", "__Host-b")
+	if err == nil {
+		t.Fatal("invalid source was validated")
+	}
+	for _, want := range []string{"codecs", "empty session name", `duplicate session name "a"`, `"__Host-b" must be Secure`} {
+		if !strings.Contains(err.Error(), want) {
+			t.Errorf("error %q does not mention %q", err, want)
+		}
+	}
+	if err := handler.ValidateSource(nil); err == nil {
+		t.Error("missing source was validated")
+	}
+}
+
+type validatingSource struct {
+	handler.NopSource
+	err error
+}
+
+func (s validatingSource) Validate() error {
+	return s.err
+}
+
+func (s validatingSource) Warmup(context.Context) error {
+	return s.err
+}
+
+func TestValidateAndWarmUpRegistry(t *testing.T) {
+	ctx := context.Background()
+	expectedError := errors.New("unreachable")
+	registry := handler.NewRegistry(validatingSource{err: expectedError}, nil)
+	registry.Require("a").Wrap(http.NotFoundHandler())
+	registry.Require("a", "b").Wrap(http.NotFoundHandler())
+	if err := registry.Validate(); err == nil || !strings.Contains(err.Error(), expectedError.Error()) {
+		t.Errorf("error: got %v, want one mentioning %q", err, expectedError)
+	}
+	if err := registry.Warmup(ctx); err != expectedError {
+		t.Errorf("error: got %v, want %v", err, expectedError)
+	}
+
+	registry = handler.NewRegistry(validatingSource{}, nil)
+	registry.Require("a").Wrap(http.NotFoundHandler())
+	registry.Require("a", "b").Wrap(http.NotFoundHandler())
+	if err := registry.Validate(); err != nil {
+		t.Errorf("failed to validate registry: %v", err)
+	}
+	if err := handler.Warmup(ctx, handler.NopSource{}); err != nil {
+		t.Errorf("failed to warm up source: %v", err)
+	}
+}
@@ -0,0 +1,125 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// StatusMapper translates errors, by their ErrorCategory per CategorizeError, to the HTTP status
+// codes with which to respond to them.
+type StatusMapper struct {
+	// Statuses maps error categories to status codes. Errors in categories it omits yield status
+	// code 500.
+	Statuses map[ErrorCategory]int
+	// RetryAfter, if positive, is how long clients receiving status code 503 should wait before
+	// retrying, sent in the Retry-After header in whole seconds, rounded up.
+	RetryAfter time.Duration
+}
+
+// DefaultStatusMapper returns a StatusMapper that responds to errors matching ErrSourceUnavailable
+// and to timeouts with status code 503, advising clients to retry after five seconds, and to errors
+// matching ErrSessionTooLarge with status code 400.
+func DefaultStatusMapper() StatusMapper {
+	return StatusMapper{
+		Statuses: map[ErrorCategory]int{
+			UnavailableError: http.StatusServiceUnavailable,
+			TimeoutError:     http.StatusServiceUnavailable,
+			OversizedError:   http.StatusBadRequest,
+		},
+		RetryAfter: 5 * time.Second,
+	}
+}
+
+// Status returns the HTTP status code with which to respond to the error.
+func (m StatusMapper) Status(err error) int {
+	if code, ok := m.Statuses[CategorizeError(err)]; ok {
+		return code
+	}
+	return http.StatusInternalServerError
+}
+
+// Respond responds to the error with its HTTP status code and no body, setting the Retry-After
+// header for status code 503 per the RetryAfter field. It always reports true, so that it can
+// serve as the last ErrorHandler supplied to ErrorHandlers.
+func (m StatusMapper) Respond(w http.ResponseWriter, _ *http.Request, err error) bool {
+	code := m.Status(err)
+	if code == http.StatusServiceUnavailable && m.RetryAfter > 0 {
+		setRetryAfter(w.Header(), m.RetryAfter)
+	}
+	w.WriteHeader(code)
+	return true
+}
+
+// setRetryAfter sets the Retry-After header to the delay in whole seconds, rounded up.
+func setRetryAfter(h http.Header, delay time.Duration) {
+	h.Set("Retry-After", strconv.FormatInt(int64((delay+time.Second-1)/time.Second), 10))
+}
+
+// RetryAdvisor is implemented by errors that report a session source, or the storage behind it, as
+// throttling requests or overloaded, advising how long clients should wait before retrying.
+type RetryAdvisor interface {
+	// RetryAfter returns how long clients should wait before retrying, or zero if unknown.
+	RetryAfter() time.Duration
+}
+
+// OverloadDetector reports whether an error indicates that a session source is throttling requests
+// or overloaded, and if so, how long clients should wait before retrying, or zero if unknown.
+type OverloadDetector func(err error) (retryAfter time.Duration, overloaded bool)
+
+// DetectRetryAdvisor is an OverloadDetector that detects errors that are or wrap a RetryAdvisor.
+func DetectRetryAdvisor(err error) (time.Duration, bool) {
+	var advisor RetryAdvisor
+	if !errors.As(err, &advisor) {
+		return 0, false
+	}
+	return advisor.RetryAfter(), true
+}
+
+// DetectOverload returns an Option that makes WithSession and WithSessionsNamed, when supplied no
+// onError handler, respond to errors arising from acquiring sessions that the OverloadDetector
+// deems to indicate overload with HTTP status code 503, setting the Retry-After header to the
+// delay it computes, or to one second if it computes none, so that well-behaved clients back off
+// rather than adding to the load on a struggling store. Without this Option they detect overload
+// with DetectRetryAdvisor. Overload takes precedence over any MapErrorStatuses Option.
+func DetectOverload(d OverloadDetector) Option {
+	return func(c *bindingConfig) {
+		c.overloadDetector = d
+	}
+}
+
+// MapErrorStatuses returns an Option that makes WithSession and WithSessionsNamed, when supplied no
+// onError handler, respond to errors arising from acquiring sessions per the StatusMapper, in
+// place of responding with status code 500 to them all. Errors from saving sessions arise too late
+// to affect the response's status.
+func MapErrorStatuses(m StatusMapper) Option {
+	return func(c *bindingConfig) {
+		c.statusMapper = &m
+	}
+}
+
+// respondToError responds to an error arising from acquiring a session when no onError handler is
+// supplied.
+func (c *bindingConfig) respondToError(w http.ResponseWriter, r *http.Request, err error) {
+	detect := c.overloadDetector
+	if detect == nil {
+		detect = DetectRetryAdvisor
+	}
+	if retryAfter, ok := detect(err); ok {
+		if retryAfter <= 0 {
+			retryAfter = time.Second
+		}
+		setRetryAfter(w.Header(), retryAfter)
+		w.WriteHeader(http.StatusServiceUnavailable)
+		return
+	}
+	if c.statusMapper != nil {
+		c.statusMapper.Respond(w, r, err)
+		return
+	}
+	sendDefaultResponse(w)
+}
@@ -0,0 +1,101 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+)
+
+func TestMapErrorStatuses(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		err         error
+		code        int
+		retryAfter  string
+	}{
+		{"unavailable", fmt.Errorf("%w: primary down", handler.ErrSourceUnavailable), http.StatusServiceUnavailable, "5"},
+		{"timeout", context.DeadlineExceeded, http.StatusServiceUnavailable, "5"},
+		{"oversized", handler.ErrSessionTooLarge, http.StatusBadRequest, ""},
+		{"other", errors.New(""), http.StatusInternalServerError, ""},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			delegate := http.HandlerFunc(func(http.ResponseWriter, *http.Request) {
+				t.Error("delegate handler called unexpectedly")
+			})
+			h := handler.WithSession("s", failingSessionSource{test.err}, delegate, nil,
+				handler.MapErrorStatuses(handler.DefaultStatusMapper()))
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+			if got, want := recorder.Code, test.code; got != want {
+				t.Errorf("status code: got %d, want %d", got, want)
+			}
+			if got, want := recorder.Header().Get("Retry-After"), test.retryAfter; got != want {
+				t.Errorf("Retry-After: got %q, want %q", got, want)
+			}
+		})
+	}
+}
+
+func TestStatusMapperAsErrorHandler(t *testing.T) {
+	m := handler.StatusMapper{
+		Statuses:   map[handler.ErrorCategory]int{handler.UnavailableError: http.StatusServiceUnavailable},
+		RetryAfter: 1500 * time.Millisecond,
+	}
+	onError := handler.ErrorHandlers(handler.JSONError(http.StatusTeapot), m.Respond)
+	recorder := httptest.NewRecorder()
+	onError(recorder, httptest.NewRequest("", "/", nil), handler.ErrSourceUnavailable)
+	if recorder.Code != http.StatusServiceUnavailable || recorder.Header().Get("Retry-After") != "2" {
+		t.Errorf("got status %d with Retry-After %q, want %d with %q", recorder.Code, recorder.Header().Get("Retry-After"), http.StatusServiceUnavailable, "2")
+	}
+}
+
+// throttledError is an error reporting that a backend is throttling requests.
+type throttledError time.Duration
+
+func (throttledError) Error() string {
+	return "throttled"
+}
+
+func (e throttledError) RetryAfter() time.Duration {
+	return time.Duration(e)
+}
+
+func TestDetectOverload(t *testing.T) {
+	errBusy := errors.New("busy")
+	for _, test := range []struct {
+		description string
+		err         error
+		opts        []handler.Option
+		code        int
+		retryAfter  string
+	}{
+		{"advised", fmt.Errorf("loading: %w", throttledError(2500*time.Millisecond)), nil, http.StatusServiceUnavailable, "3"},
+		{"unadvised", throttledError(0), nil, http.StatusServiceUnavailable, "1"},
+		{"advised over mapping", throttledError(time.Minute), []handler.Option{handler.MapErrorStatuses(handler.DefaultStatusMapper())}, http.StatusServiceUnavailable, "60"},
+		{"undetected", errBusy, nil, http.StatusInternalServerError, ""},
+		{"custom", errBusy, []handler.Option{handler.DetectOverload(func(err error) (time.Duration, bool) {
+			return 10 * time.Second, errors.Is(err, errBusy)
+		})}, http.StatusServiceUnavailable, "10"},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			h := handler.WithSession("s", failingSessionSource{test.err}, http.HandlerFunc(func(http.ResponseWriter, *http.Request) {}), nil, test.opts...)
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+			if got, want := recorder.Code, test.code; got != want {
+				t.Errorf("status code: got %d, want %d", got, want)
+			}
+			if got, want := recorder.Header().Get("Retry-After"), test.retryAfter; got != want {
+				t.Errorf("Retry-After: got %q, want %q", got, want)
+			}
+		})
+	}
+}
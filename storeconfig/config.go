@@ -0,0 +1,161 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+/*
+Package storeconfig builds session stores from a declarative configuration, supplied either as a
+struct or through environment variables, validating the configuration when the program starts
+rather than failing upon the first request that needs a session.
+*/
+package storeconfig
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler/kvstore"
+)
+
+// Kinds of session stores that NewStoreFromConfig can build.
+const (
+	// KindCookie builds a sessions.CookieStore, keeping session values in the cookie itself.
+	KindCookie = "cookie"
+	// KindMemory builds a kvstore.Store backed by a kvstore.Memory, keeping session values in
+	// process memory.
+	KindMemory = "memory"
+	// KindKV builds a kvstore.Store backed by the KV supplied in Config.KV. Use this kind to keep
+	// session values in external storage such as Redis or a SQL database, supplying a KV that
+	// adapts a client for that storage.
+	KindKV = "kv"
+)
+
+// KeyPair holds the keys used to authenticate and optionally encrypt session cookies.
+type KeyPair struct {
+	// Hash authenticates cookie values using HMAC. It's required, and should be 32 or 64 bytes
+	// long.
+	Hash []byte
+	// Block encrypts cookie values using AES. It's optional, but if present must be 16, 24, or
+	// 32 bytes long.
+	Block []byte
+}
+
+// Config describes a session store to build.
+type Config struct {
+	// Kind selects the kind of store to build; see KindCookie, KindMemory, and KindKV.
+	Kind string
+	// KeyPairs supply the keys for the store's cookie codecs. The first pair encodes new cookies;
+	// all pairs are tried in turn when decoding cookies, supporting key rotation.
+	KeyPairs []KeyPair
+	// MaxAge bounds the lifetime of sessions and their cookies. If zero, the store's default
+	// lifetime applies.
+	MaxAge time.Duration
+	// Cookie attributes.
+	Path     string
+	Domain   string
+	Secure   bool
+	HTTPOnly bool
+	SameSite http.SameSite
+	// KeyPrefix is prepended to session IDs to form keys in server-side stores.
+	KeyPrefix string
+	// KV is the storage for a store of kind KindKV.
+	KV kvstore.KV
+}
+
+// Validate reports all the problems with the configuration, or returns nil if it's suitable for
+// building a store.
+func (c Config) Validate() error {
+	var problems []string
+	addProblem := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	switch c.Kind {
+	case KindCookie, KindMemory:
+	case KindKV:
+		if c.KV == nil {
+			addProblem("store kind %q requires a KV", c.Kind)
+		}
+	case "":
+		addProblem("no store kind specified")
+	default:
+		addProblem("unsupported store kind %q", c.Kind)
+	}
+	if len(c.KeyPairs) == 0 {
+		addProblem("no key pairs supplied")
+	}
+	for i, p := range c.KeyPairs {
+		switch n := len(p.Hash); {
+		case n == 0:
+			addProblem("key pair %d has no hash key", i)
+		case n < 32:
+			addProblem("key pair %d has a hash key of only %d bytes; want at least 32", i, n)
+		}
+		switch n := len(p.Block); n {
+		case 0, 16, 24, 32:
+		default:
+			addProblem("key pair %d has a block key of %d bytes; want 16, 24, or 32", i, n)
+		}
+	}
+	if c.MaxAge < 0 {
+		addProblem("negative maximum age %v", c.MaxAge)
+	} else if c.MaxAge%time.Second != 0 {
+		addProblem("maximum age %v is not a whole number of seconds", c.MaxAge)
+	}
+	if c.SameSite == http.SameSiteNoneMode && !c.Secure {
+		addProblem("SameSite=None cookies must also be Secure")
+	}
+	if len(problems) == 0 {
+		return nil
+	}
+	return errors.New("invalid session store configuration: " + strings.Join(problems, "; "))
+}
+
+func (c Config) keyPairs() [][]byte {
+	pairs := make([][]byte, 0, 2*len(c.KeyPairs))
+	for _, p := range c.KeyPairs {
+		pairs = append(pairs, p.Hash, p.Block)
+	}
+	return pairs
+}
+
+func (c Config) applyOptions(o *sessions.Options) {
+	if len(c.Path) != 0 {
+		o.Path = c.Path
+	}
+	o.Domain = c.Domain
+	o.Secure = c.Secure
+	o.HttpOnly = c.HTTPOnly
+	o.SameSite = c.SameSite
+}
+
+// NewStoreFromConfig validates the supplied configuration and builds the store it describes,
+// returning an error describing every problem with the configuration if it's invalid.
+func NewStoreFromConfig(c Config) (sessions.Store, error) {
+	if err := c.Validate(); err != nil {
+		return nil, err
+	}
+	maxAge := int(c.MaxAge / time.Second)
+	switch c.Kind {
+	case KindCookie:
+		s := sessions.NewCookieStore(c.keyPairs()...)
+		c.applyOptions(s.Options)
+		if maxAge != 0 {
+			s.MaxAge(maxAge)
+		}
+		return s, nil
+	default:
+		kv := c.KV
+		if c.Kind == KindMemory {
+			kv = kvstore.NewMemory()
+		}
+		s := kvstore.New(kv, c.keyPairs()...)
+		s.KeyPrefix = c.KeyPrefix
+		c.applyOptions(s.Options)
+		if maxAge != 0 {
+			s.MaxAge(maxAge)
+		}
+		return s, nil
+	}
+}
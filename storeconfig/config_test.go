@@ -0,0 +1,116 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package storeconfig_test
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler/kvstore"
+	"github.com/seh/handler/storeconfig"
+)
+
+func validKeyPairs() []storeconfig.KeyPair {
+	return []storeconfig.KeyPair{{
+		Hash:  securecookie.GenerateRandomKey(32),
+		Block: securecookie.GenerateRandomKey(16),
+	}}
+}
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		description string
+		config      storeconfig.Config
+		problems    []string
+	}{
+		{"valid cookie", storeconfig.Config{Kind: storeconfig.KindCookie, KeyPairs: validKeyPairs()}, nil},
+		{"valid kv", storeconfig.Config{Kind: storeconfig.KindKV, KeyPairs: validKeyPairs(), KV: kvstore.NewMemory()}, nil},
+		{"no kind", storeconfig.Config{KeyPairs: validKeyPairs()}, []string{"no store kind"}},
+		{"unknown kind", storeconfig.Config{Kind: "redis", KeyPairs: validKeyPairs()}, []string{`unsupported store kind "redis"`}},
+		{"kv without KV", storeconfig.Config{Kind: storeconfig.KindKV, KeyPairs: validKeyPairs()}, []string{"requires a KV"}},
+		{"no keys", storeconfig.Config{Kind: storeconfig.KindMemory}, []string{"no key pairs"}},
+		{"bad keys", storeconfig.Config{Kind: storeconfig.KindMemory, KeyPairs: []storeconfig.KeyPair{
+			{Hash: make([]byte, 8), Block: make([]byte, 10)},
+			{},
+		}}, []string{"only 8 bytes", "block key of 10 bytes", "key pair 1 has no hash key"}},
+		{"bad max age", storeconfig.Config{Kind: storeconfig.KindMemory, KeyPairs: validKeyPairs(), MaxAge: -time.Second}, []string{"negative maximum age"}},
+		{"fractional max age", storeconfig.Config{Kind: storeconfig.KindMemory, KeyPairs: validKeyPairs(), MaxAge: time.Millisecond}, []string{"whole number of seconds"}},
+		{"insecure SameSite=None", storeconfig.Config{Kind: storeconfig.KindMemory, KeyPairs: validKeyPairs(), SameSite: http.SameSiteNoneMode}, []string{"must also be Secure"}},
+	}
+	for _, test := range tests {
+		t.Run(test.description, func(t *testing.T) {
+			err := test.config.Validate()
+			if len(test.problems) == 0 {
+				if err != nil {
+					t.Fatalf("got error %v, want none", err)
+				}
+				return
+			}
+			if err == nil {
+				t.Fatal("got no error, want one")
+			}
+			for _, p := range test.problems {
+				if !strings.Contains(err.Error(), p) {
+					t.Errorf("error %q does not mention %q", err, p)
+				}
+			}
+			if _, err := storeconfig.NewStoreFromConfig(test.config); err == nil {
+				t.Error("built a store from an invalid configuration")
+			}
+		})
+	}
+}
+
+func TestNewStoreFromConfig(t *testing.T) {
+	base := storeconfig.Config{
+		KeyPairs: validKeyPairs(),
+		MaxAge:   time.Hour,
+		Domain:   "example.com",
+		Secure:   true,
+		HTTPOnly: true,
+		SameSite: http.SameSiteStrictMode,
+	}
+	tests := []struct {
+		kind  string
+		check func(sessions.Store) *sessions.Options
+	}{
+		{storeconfig.KindCookie, func(s sessions.Store) *sessions.Options { return s.(*sessions.CookieStore).Options }},
+		{storeconfig.KindMemory, func(s sessions.Store) *sessions.Options { return s.(*kvstore.Store).Options }},
+	}
+	for _, test := range tests {
+		t.Run(test.kind, func(t *testing.T) {
+			c := base
+			c.Kind = test.kind
+			s, err := storeconfig.NewStoreFromConfig(c)
+			if err != nil {
+				t.Fatalf("failed to build store: %v", err)
+			}
+			o := test.check(s)
+			want := sessions.Options{
+				Path:     "/",
+				Domain:   "example.com",
+				MaxAge:   3600,
+				Secure:   true,
+				HttpOnly: true,
+				SameSite: http.SameSiteStrictMode,
+			}
+			if *o != want {
+				t.Errorf("options: got %+v, want %+v", *o, want)
+			}
+			r := httptest.NewRequest("", "/", nil)
+			session, err := s.New(r, "s")
+			if err != nil {
+				t.Fatalf("failed to create session: %v", err)
+			}
+			if err := session.Save(r, httptest.NewRecorder()); err != nil {
+				t.Errorf("failed to save session: %v", err)
+			}
+		})
+	}
+}
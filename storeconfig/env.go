@@ -0,0 +1,137 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package storeconfig
+
+import (
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+var sameSiteModes = map[string]http.SameSite{
+	"":        http.SameSiteDefaultMode,
+	"default": http.SameSiteDefaultMode,
+	"lax":     http.SameSiteLaxMode,
+	"strict":  http.SameSiteStrictMode,
+	"none":    http.SameSiteNoneMode,
+}
+
+func decodeKeys(s string) ([][]byte, error) {
+	if len(s) == 0 {
+		return nil, nil
+	}
+	fields := strings.Split(s, ",")
+	keys := make([][]byte, len(fields))
+	for i, f := range fields {
+		f = strings.TrimSpace(f)
+		if len(f) == 0 {
+			continue
+		}
+		k, err := base64.StdEncoding.DecodeString(f)
+		if err != nil {
+			return nil, fmt.Errorf("key %d is not valid base64: %v", i, err)
+		}
+		keys[i] = k
+	}
+	return keys, nil
+}
+
+func configFromEnv(prefix string, lookup func(string) (string, bool)) (Config, error) {
+	get := func(name string) string {
+		v, _ := lookup(prefix + name)
+		return v
+	}
+	var c Config
+	var problems []string
+	addProblem := func(name string, err error) {
+		problems = append(problems, fmt.Sprintf("%s%s: %v", prefix, name, err))
+	}
+	c.Kind = strings.ToLower(get("KIND"))
+	hashKeys, err := decodeKeys(get("HASH_KEYS"))
+	if err != nil {
+		addProblem("HASH_KEYS", err)
+	}
+	blockKeys, err := decodeKeys(get("BLOCK_KEYS"))
+	if err != nil {
+		addProblem("BLOCK_KEYS", err)
+	}
+	if len(blockKeys) > len(hashKeys) {
+		addProblem("BLOCK_KEYS", errors.New("more block keys than hash keys"))
+	}
+	for i, h := range hashKeys {
+		p := KeyPair{Hash: h}
+		if i < len(blockKeys) {
+			p.Block = blockKeys[i]
+		}
+		c.KeyPairs = append(c.KeyPairs, p)
+	}
+	if v := get("MAX_AGE"); len(v) != 0 {
+		if c.MaxAge, err = time.ParseDuration(v); err != nil {
+			addProblem("MAX_AGE", err)
+		}
+	}
+	c.Path = get("COOKIE_PATH")
+	c.Domain = get("COOKIE_DOMAIN")
+	for _, f := range []struct {
+		name string
+		dst  *bool
+	}{
+		{"COOKIE_SECURE", &c.Secure},
+		{"COOKIE_HTTP_ONLY", &c.HTTPOnly},
+	} {
+		if v := get(f.name); len(v) != 0 {
+			if *f.dst, err = strconv.ParseBool(v); err != nil {
+				addProblem(f.name, err)
+			}
+		}
+	}
+	sameSite := get("COOKIE_SAME_SITE")
+	if mode, ok := sameSiteModes[strings.ToLower(sameSite)]; ok {
+		c.SameSite = mode
+	} else {
+		addProblem("COOKIE_SAME_SITE", fmt.Errorf("unknown mode %q", sameSite))
+	}
+	c.KeyPrefix = get("KEY_PREFIX")
+	if len(problems) != 0 {
+		return c, errors.New("invalid session store environment: " + strings.Join(problems, "; "))
+	}
+	return c, nil
+}
+
+// ConfigFromEnv reads a store configuration from environment variables whose names begin with the
+// given prefix, such as "SESSION_":
+//
+//	KIND              store kind: cookie or memory
+//	HASH_KEYS         comma-separated, base64-encoded hash keys, newest first
+//	BLOCK_KEYS        comma-separated, base64-encoded block keys, paired with HASH_KEYS
+//	MAX_AGE           session lifetime, as parsed by time.ParseDuration
+//	COOKIE_PATH       cookie Path attribute
+//	COOKIE_DOMAIN     cookie Domain attribute
+//	COOKIE_SECURE     cookie Secure attribute, as parsed by strconv.ParseBool
+//	COOKIE_HTTP_ONLY  cookie HttpOnly attribute, as parsed by strconv.ParseBool
+//	COOKIE_SAME_SITE  cookie SameSite attribute: default, lax, strict, or none
+//	KEY_PREFIX        prefix for keys in server-side stores
+//
+// Stores of kind KindKV can't be configured entirely through the environment; for those, adjust
+// the returned Config by supplying its KV before calling NewStoreFromConfig.
+func ConfigFromEnv(prefix string) (Config, error) {
+	return configFromEnv(prefix, os.LookupEnv)
+}
+
+// NewStoreFromEnv builds a store from the configuration read by ConfigFromEnv, validating it per
+// NewStoreFromConfig.
+func NewStoreFromEnv(prefix string) (sessions.Store, error) {
+	c, err := ConfigFromEnv(prefix)
+	if err != nil {
+		return nil, err
+	}
+	return NewStoreFromConfig(c)
+}
@@ -0,0 +1,91 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package storeconfig
+
+import (
+	"bytes"
+	"encoding/base64"
+	"net/http"
+	"strings"
+	"testing"
+	"time"
+)
+
+func lookupIn(env map[string]string) func(string) (string, bool) {
+	return func(name string) (string, bool) {
+		v, ok := env[name]
+		return v, ok
+	}
+}
+
+func TestConfigFromEnv(t *testing.T) {
+	hash1, hash2 := bytes.Repeat([]byte{1}, 32), bytes.Repeat([]byte{2}, 32)
+	block1 := bytes.Repeat([]byte{3}, 16)
+	enc := base64.StdEncoding.EncodeToString
+	c, err := configFromEnv("S_", lookupIn(map[string]string{
+		"S_KIND":             "Memory",
+		"S_HASH_KEYS":        enc(hash1) + ", " + enc(hash2),
+		"S_BLOCK_KEYS":       enc(block1),
+		"S_MAX_AGE":          "2h",
+		"S_COOKIE_PATH":      "/app",
+		"S_COOKIE_DOMAIN":    "example.com",
+		"S_COOKIE_SECURE":    "true",
+		"S_COOKIE_HTTP_ONLY": "1",
+		"S_COOKIE_SAME_SITE": "Lax",
+		"S_KEY_PREFIX":       "session:",
+	}))
+	if err != nil {
+		t.Fatalf("failed to read configuration: %v", err)
+	}
+	if got, want := c.Kind, KindMemory; got != want {
+		t.Errorf("kind: got %q, want %q", got, want)
+	}
+	if got, want := len(c.KeyPairs), 2; got != want {
+		t.Fatalf("key pair count: got %d, want %d", got, want)
+	}
+	if !bytes.Equal(c.KeyPairs[0].Hash, hash1) || !bytes.Equal(c.KeyPairs[0].Block, block1) ||
+		!bytes.Equal(c.KeyPairs[1].Hash, hash2) || len(c.KeyPairs[1].Block) != 0 {
+		t.Errorf("key pairs: got %v", c.KeyPairs)
+	}
+	if got, want := c.MaxAge, 2*time.Hour; got != want {
+		t.Errorf("maximum age: got %v, want %v", got, want)
+	}
+	if c.Path != "/app" || c.Domain != "example.com" || !c.Secure || !c.HTTPOnly || c.SameSite != http.SameSiteLaxMode {
+		t.Errorf("cookie attributes: got %+v", c)
+	}
+	if got, want := c.KeyPrefix, "session:"; got != want {
+		t.Errorf("key prefix: got %q, want %q", got, want)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("configuration is invalid: %v", err)
+	}
+}
+
+func TestConfigFromEnvReportsProblems(t *testing.T) {
+	_, err := configFromEnv("S_", lookupIn(map[string]string{
+		"S_HASH_KEYS":        "!",
+		"S_MAX_AGE":          "forever",
+		"S_COOKIE_SECURE":    "maybe",
+		"S_COOKIE_SAME_SITE": "sideways",
+	}))
+	if err == nil {
+		t.Fatal("got no error, want one")
+	}
+	for _, name := range []string{"S_HASH_KEYS", "S_MAX_AGE", "S_COOKIE_SECURE", "S_COOKIE_SAME_SITE"} {
+		if !strings.Contains(err.Error(), name) {
+			t.Errorf("error %q does not mention %s", err, name)
+		}
+	}
+}
+
+func TestNewStoreFromEnvValidates(t *testing.T) {
+	t.Setenv("HANDLER_TEST_KIND", "cookie")
+	if _, err := NewStoreFromEnv("HANDLER_TEST_"); err == nil {
+		t.Error("built a store without keys")
+	}
+	t.Setenv("HANDLER_TEST_HASH_KEYS", base64.StdEncoding.EncodeToString(bytes.Repeat([]byte{1}, 32)))
+	if _, err := NewStoreFromEnv("HANDLER_TEST_"); err != nil {
+		t.Errorf("failed to build store: %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// SwitchingSource is a SessionSource that acquires sessions from a primary SessionSource while it's
+// healthy, and from a standby SessionSource while it's not, as judged by periodic health checks;
+// see Check. Since every handler binding sessions from the same SwitchingSource consults it for
+// each request, switching takes effect for all of them at once.
+//
+// To avoid flapping between the two when the primary's health fluctuates, it switches to the
+// standby only after FailAfter consecutive failed checks, and back to the primary only after
+// RecoverAfter consecutive successful checks. Sessions acquired from either SessionSource remain
+// bound to the store from which they came, so saving a session acquired before a switch still
+// saves it there.
+//
+// Create a SwitchingSource with NewSwitchingSource.
+type SwitchingSource struct {
+	// FailAfter is the number of consecutive failed health checks of the primary after which to
+	// switch to the standby. If not positive, SwitchingSource switches after three failures.
+	FailAfter int
+	// RecoverAfter is the number of consecutive successful health checks of the primary after
+	// which to switch back to it. If not positive, SwitchingSource switches back after three
+	// successes.
+	RecoverAfter int
+	// OnSwitch, if not nil, is called whenever SwitchingSource switches sources: to the standby,
+	// with the error that the primary's last health check failed with, or back to the primary, with
+	// a nil error.
+	OnSwitch  func(err error)
+	primary   SessionSource
+	standby   SessionSource
+	mu        sync.RWMutex
+	onStandby bool
+	streak    int
+}
+
+// NewSwitchingSource returns a SwitchingSource that acquires sessions from the primary
+// SessionSource, switching to the standby SessionSource while the primary is unhealthy. It panics
+// if either SessionSource is nil.
+func NewSwitchingSource(primary, standby SessionSource) *SwitchingSource {
+	if primary == nil {
+		panic("no primary session source supplied")
+	}
+	if standby == nil {
+		panic("no standby session source supplied")
+	}
+	return &SwitchingSource{primary: primary, standby: standby}
+}
+
+func (s *SwitchingSource) failAfter() int {
+	if s.FailAfter > 0 {
+		return s.FailAfter
+	}
+	return 3
+}
+
+func (s *SwitchingSource) recoverAfter() int {
+	if s.RecoverAfter > 0 {
+		return s.RecoverAfter
+	}
+	return 3
+}
+
+func (s *SwitchingSource) active() SessionSource {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.onStandby {
+		return s.standby
+	}
+	return s.primary
+}
+
+// New acquires a session from the active SessionSource.
+func (s *SwitchingSource) New(r *http.Request, name string) (*sessions.Session, error) {
+	return s.active().New(r, name)
+}
+
+// UsingStandby reports whether the SwitchingSource is acquiring sessions from the standby.
+func (s *SwitchingSource) UsingStandby() bool {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	return s.onStandby
+}
+
+func checkSource(ctx context.Context, s SessionSource) error {
+	if h, ok := s.(Healther); ok {
+		return h.Health(ctx)
+	}
+	return nil
+}
+
+// Check checks the health of the primary SessionSource, if it implements Healther, switching to
+// the standby or back to the primary once enough consecutive checks agree. It doesn't switch to a
+// standby that implements Healther and is itself unhealthy.
+func (s *SwitchingSource) Check(ctx context.Context) {
+	err := checkSource(ctx, s.primary)
+	s.mu.Lock()
+	// The streak counts consecutive checks disagreeing with the current choice of source.
+	if (err != nil) != s.onStandby {
+		s.streak++
+	} else {
+		s.streak = 0
+	}
+	threshold := s.failAfter()
+	if s.onStandby {
+		threshold = s.recoverAfter()
+	}
+	if s.streak < threshold {
+		s.mu.Unlock()
+		return
+	}
+	s.mu.Unlock()
+	if err != nil && checkSource(ctx, s.standby) != nil {
+		return
+	}
+	s.mu.Lock()
+	switched := s.onStandby != (err != nil)
+	s.onStandby = err != nil
+	s.streak = 0
+	s.mu.Unlock()
+	if switched && s.OnSwitch != nil {
+		s.OnSwitch(err)
+	}
+}
+
+// CheckEvery calls Check at the given interval until the supplied context is done.
+func (s *SwitchingSource) CheckEvery(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			s.Check(ctx)
+		}
+	}
+}
+
+// Health reports the health of the active SessionSource, if it implements Healther.
+func (s *SwitchingSource) Health(ctx context.Context) error {
+	return checkSource(ctx, s.active())
+}
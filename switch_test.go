@@ -0,0 +1,101 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+// taggedSource is a SessionSource whose sessions identify it, and whose health can be switched off.
+type taggedSource struct {
+	tag string
+	err error
+}
+
+func (s *taggedSource) New(r *http.Request, name string) (*sessions.Session, error) {
+	session := sessions.NewSession(nil, name)
+	session.Values["source"] = s.tag
+	return session, nil
+}
+
+func (s *taggedSource) Health(context.Context) error {
+	return s.err
+}
+
+func TestNewSwitchingSourcePanicsWithNoPrimary(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.NewSwitchingSource(nil, &taggedSource{})
+}
+
+func TestNewSwitchingSourcePanicsWithNoStandby(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	handler.NewSwitchingSource(&taggedSource{}, nil)
+}
+
+func TestSwitchingSource(t *testing.T) {
+	ctx := context.Background()
+	expectedError := errors.New("unreachable")
+	primary := &taggedSource{tag: "primary"}
+	standby := &taggedSource{tag: "standby"}
+	source := handler.NewSwitchingSource(primary, standby)
+	source.FailAfter = 2
+	source.RecoverAfter = 3
+	var switches []error
+	source.OnSwitch = func(err error) {
+		switches = append(switches, err)
+	}
+	h := handler.WithSession("s", source, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Source", handler.MustExtractSession(r).Values["source"].(string))
+	}), nil)
+	expectSource := func(want string) {
+		t.Helper()
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, httptest.NewRequest("", "/", nil))
+		if got := recorder.Header().Get("Source"); got != want {
+			t.Errorf("source: got %q, want %q", got, want)
+		}
+	}
+
+	primary.err = expectedError
+	source.Check(ctx)
+	expectSource("primary")
+	source.Check(ctx)
+	expectSource("standby")
+	if !source.UsingStandby() {
+		t.Error("switching source is not using its standby")
+	}
+	if err := source.Health(ctx); err != nil {
+		t.Errorf("failed health check while using standby: %v", err)
+	}
+
+	primary.err = nil
+	source.Check(ctx)
+	primary.err = expectedError
+	source.Check(ctx)
+	primary.err = nil
+	source.Check(ctx)
+	source.Check(ctx)
+	expectSource("standby")
+	source.Check(ctx)
+	expectSource("primary")
+	if len(switches) != 2 || switches[0] != expectedError || switches[1] != nil {
+		t.Errorf("switches: got %v, want [%v <nil>]", switches, expectedError)
+	}
+
+	primary.err = expectedError
+	standby.err = expectedError
+	for i := 0; i < 3; i++ {
+		source.Check(ctx)
+	}
+	expectSource("primary")
+}
+
+var _ handler.Healther = (*handler.SwitchingSource)(nil)
@@ -0,0 +1,92 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/gorilla/sessions"
+)
+
+// TagsKey is the session value key under which SetTag records the session's tags, each encoded as
+// its name, followed by an equals sign and its value.
+const TagsKey = "handler.tags"
+
+// Limits on session tags, keeping them small enough not to bloat sessions or the events that bear
+// them.
+const (
+	maxTags      = 16
+	maxTagLength = 64
+)
+
+// ErrInvalidTag is the error that SetTag returns when given a tag name that's empty or contains an
+// equals sign, a name or value longer than 64 bytes, or a new tag for a session already bearing
+// sixteen.
+var ErrInvalidTag = errors.New("invalid session tag")
+
+// SetTag records a small label on the session under the given name, such as the type of client,
+// the application's version, or the marketing campaign that brought the user, replacing any value
+// already recorded under that name, or removing it if the value is empty. Tags accompany the
+// session's AuditEvents and AccessLogEntries, and can be counted via CountTags, letting analytics
+// segment traffic without consulting the values that the application stores.
+func SetTag(s *sessions.Session, name, value string) error {
+	if len(name) == 0 || strings.ContainsRune(name, '=') || len(name) > maxTagLength || len(value) > maxTagLength {
+		return ErrInvalidTag
+	}
+	entries := stringsValue(s.Values[TagsKey])
+	kept := make([]string, 0, len(entries)+1)
+	for _, e := range entries {
+		if n, _, _ := strings.Cut(e, "="); n != name {
+			kept = append(kept, e)
+		}
+	}
+	if len(value) != 0 {
+		if len(kept) >= maxTags {
+			return ErrInvalidTag
+		}
+		kept = append(kept, name+"="+value)
+	}
+	if len(kept) == 0 {
+		delete(s.Values, TagsKey)
+	} else {
+		s.Values[TagsKey] = kept
+	}
+	return nil
+}
+
+// Tags returns the tags recorded on the session via SetTag, keyed by name, or nil if there are
+// none.
+func Tags(s *sessions.Session) map[string]string {
+	return tagsIn(s.Values)
+}
+
+func tagsIn(values map[interface{}]interface{}) map[string]string {
+	entries := stringsValue(values[TagsKey])
+	if len(entries) == 0 {
+		return nil
+	}
+	tags := make(map[string]string, len(entries))
+	for _, e := range entries {
+		if name, value, ok := strings.Cut(e, "="); ok {
+			tags[name] = value
+		}
+	}
+	return tags
+}
+
+// CountTags returns an Option that adds one, for each session bound to a request, to the Counter
+// that the counters function supplies for the value of the session's tag with the given name, or
+// for the empty value if the session bears no such tag. The function may return nil to leave a
+// value uncounted, such as to bound the number of distinct values reported.
+func CountTags(name string, counters func(value string) Counter) Option {
+	return func(c *bindingConfig) {
+		c.preparers = append(c.preparers, func(_ *http.Request, s *sessions.Session) {
+			if counter := counters(Tags(s)[name]); counter != nil {
+				counter.Add(1)
+			}
+		})
+	}
+}
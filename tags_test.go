@@ -0,0 +1,92 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"testing"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+)
+
+func TestTags(t *testing.T) {
+	s := sessions.NewSession(simpleStore{}, "s")
+	if tags := handler.Tags(s); tags != nil {
+		t.Errorf("tags of untagged session: got %v, want none", tags)
+	}
+	for _, test := range []struct {
+		name, value string
+	}{
+		{"", "web"},
+		{"a=b", "web"},
+		{strings.Repeat("n", 65), "web"},
+		{"client", strings.Repeat("v", 65)},
+	} {
+		if err := handler.SetTag(s, test.name, test.value); !errors.Is(err, handler.ErrInvalidTag) {
+			t.Errorf("SetTag(%q, %q): got %v, want %v", test.name, test.value, err, handler.ErrInvalidTag)
+		}
+	}
+	handler.SetTag(s, "client", "web")
+	handler.SetTag(s, "version", "1.2")
+	handler.SetTag(s, "client", "ios")
+	handler.SetTag(s, "version", "")
+	if got, want := handler.Tags(s), map[string]string{"client": "ios"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("tags: got %v, want %v", got, want)
+	}
+	for i := 1; i < 16; i++ {
+		if err := handler.SetTag(s, strings.Repeat("t", i), "x"); err != nil {
+			t.Fatalf("failed to set tag %d: %v", i, err)
+		}
+	}
+	if err := handler.SetTag(s, "campaign", "spring"); !errors.Is(err, handler.ErrInvalidTag) {
+		t.Errorf("seventeenth tag: got %v, want %v", err, handler.ErrInvalidTag)
+	}
+	if err := handler.SetTag(s, "client", "android"); err != nil {
+		t.Errorf("failed to replace tag on fully tagged session: %v", err)
+	}
+}
+
+func TestTagsSurface(t *testing.T) {
+	source := valuesSource{handler.TagsKey: []string{"client=ios"}}
+	counts := make(map[string]int64)
+	var events []handler.AuditEvent
+	var entries []handler.AccessLogEntry
+	h := handler.WithAccessLog(nil, handler.AccessLoggerFunc(func(_ context.Context, e handler.AccessLogEntry) {
+		entries = append(entries, e)
+	}), http.NotFoundHandler())
+	h = handler.WithSession("s", source, h, nil,
+		handler.Audit(handler.AuditSinkFunc(func(_ context.Context, e handler.AuditEvent) {
+			events = append(events, e)
+		})),
+		handler.CountTags("client", func(value string) handler.Counter {
+			return handler.CounterFunc(func(delta int64) {
+				counts[value] += delta
+			})
+		}),
+		handler.CountTags("campaign", func(value string) handler.Counter {
+			if len(value) == 0 {
+				return nil
+			}
+			t.Errorf("counted absent tag value %q", value)
+			return nil
+		}))
+	h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+
+	want := map[string]string{"client": "ios"}
+	if len(events) != 1 || !reflect.DeepEqual(events[0].Tags, want) {
+		t.Errorf("audit events: got %+v, want one bearing tags %v", events, want)
+	}
+	if len(entries) != 1 || !reflect.DeepEqual(entries[0].Tags, want) {
+		t.Errorf("access log entries: got %+v, want one bearing tags %v", entries, want)
+	}
+	if got, want := counts, map[string]int64{"ios": 1}; !reflect.DeepEqual(got, want) {
+		t.Errorf("counts: got %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,353 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+)
+
+// AttemptStore counts the consecutive failed login attempts made under each of a set of keys, such
+// as a client's IP address. Implementations that share their counts among instances of an
+// application, such as that returned by kvstore.NewAttemptStore, throttle logins across all of
+// them.
+type AttemptStore interface {
+	// Failures returns the number of consecutive failures recorded for the given key and when the
+	// latest of them occurred, or zero if none are recorded.
+	Failures(ctx context.Context, key string) (count int, latest time.Time, err error)
+	// RecordFailure increments the number of failures recorded for the given key, recording now as
+	// the time of the latest, and retaining the record for at least ttl. It returns the new number.
+	RecordFailure(ctx context.Context, key string, now time.Time, ttl time.Duration) (int, error)
+	// Reset removes the failures recorded for the given key.
+	Reset(ctx context.Context, key string) error
+}
+
+type attemptRecord struct {
+	count   int
+	latest  time.Time
+	expires time.Time
+}
+
+// MemoryAttemptStore is an AttemptStore that holds its counts in process memory, suitable for tests
+// and for single-process deployments. The zero value is ready for use.
+type MemoryAttemptStore struct {
+	mu      sync.Mutex
+	records map[string]attemptRecord
+}
+
+// Failures returns the number of consecutive failures recorded for the given key and when the
+// latest of them occurred.
+func (s *MemoryAttemptStore) Failures(_ context.Context, key string) (int, time.Time, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	rec := s.records[key]
+	return rec.count, rec.latest, nil
+}
+
+// RecordFailure increments the number of failures recorded for the given key. It discards any
+// expired records it encounters along the way.
+func (s *MemoryAttemptStore) RecordFailure(_ context.Context, key string, now time.Time, ttl time.Duration) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.records == nil {
+		s.records = make(map[string]attemptRecord)
+	}
+	for k, rec := range s.records {
+		if !now.Before(rec.expires) {
+			delete(s.records, k)
+		}
+	}
+	rec := s.records[key]
+	rec.count++
+	rec.latest = now
+	rec.expires = now.Add(ttl)
+	s.records[key] = rec
+	return rec.count, nil
+}
+
+// Reset removes the failures recorded for the given key.
+func (s *MemoryAttemptStore) Reset(_ context.Context, key string) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	delete(s.records, key)
+	return nil
+}
+
+// LoginThrottle limits how quickly clients may retry failed logins, locking out each client for a
+// period that doubles with each failure beyond a threshold. It counts failures both by the
+// client's IP address and by the ID of the session bound to the request. Since clients can shed
+// their sessions at will, only the count by IP address limits a determined attacker; the count by
+// session catches only clients that keep their session while switching addresses.
+type LoginThrottle struct {
+	// Attempts counts the failures. It's required.
+	Attempts AttemptStore
+	// Threshold is the number of consecutive failures tolerated before locking out a client. If not
+	// positive, the LoginThrottle uses 5.
+	Threshold int
+	// Lockout is how long the LoginThrottle locks out a client upon reaching the threshold,
+	// doubling with each further failure. If not positive, it uses one second.
+	Lockout time.Duration
+	// MaxLockout caps the lockout period. If not positive, the LoginThrottle uses 15 minutes.
+	MaxLockout time.Duration
+	// Clock reports the current time. If nil, the LoginThrottle uses SystemClock.
+	Clock Clock
+}
+
+func (t *LoginThrottle) threshold() int {
+	if t.Threshold > 0 {
+		return t.Threshold
+	}
+	return 5
+}
+
+func (t *LoginThrottle) lockout() time.Duration {
+	if t.Lockout > 0 {
+		return t.Lockout
+	}
+	return time.Second
+}
+
+func (t *LoginThrottle) maxLockout() time.Duration {
+	if t.MaxLockout > 0 {
+		return t.MaxLockout
+	}
+	return 15 * time.Minute
+}
+
+// lockoutAfter returns how long the LoginThrottle locks out a client after the given number of
+// consecutive failures.
+func (t *LoginThrottle) lockoutAfter(failures int) time.Duration {
+	excess := failures - t.threshold()
+	if excess < 0 {
+		return 0
+	}
+	d, max := t.lockout(), t.maxLockout()
+	for ; excess > 0 && d < max; excess-- {
+		d *= 2
+	}
+	if d > max {
+		d = max
+	}
+	return d
+}
+
+// attemptKeys returns the keys under which to count the request's failures.
+func attemptKeys(r *http.Request) []string {
+	var keys []string
+	if ip := RemoteIP(r); len(ip) != 0 {
+		keys = append(keys, "ip:"+ip)
+	}
+	if s, ok := ExtractSession(r); ok && len(s.ID) != 0 {
+		keys = append(keys, "session:"+s.ID)
+	}
+	return keys
+}
+
+// lockedFor returns how much longer the request's client remains locked out, if at all, together
+// with the number of failures recorded under each of the keys.
+func (t *LoginThrottle) lockedFor(ctx context.Context, keys []string, now time.Time) (time.Duration, []int, error) {
+	var remaining time.Duration
+	counts := make([]int, len(keys))
+	for i, key := range keys {
+		count, latest, err := t.Attempts.Failures(ctx, key)
+		if err != nil {
+			return 0, nil, err
+		}
+		counts[i] = count
+		if d := latest.Add(t.lockoutAfter(count)).Sub(now); d > remaining {
+			remaining = d
+		}
+	}
+	return remaining, counts, nil
+}
+
+// reserve counts an attempt as a failure under each of the keys in advance of making it, and
+// returns how long the client is locked out by other attempts reserved since it observed the given
+// counts, if at all.
+func (t *LoginThrottle) reserve(ctx context.Context, keys []string, counts []int, now time.Time) (time.Duration, error) {
+	// Retain the record long enough to outlast the longest lockout.
+	ttl := t.maxLockout() + t.lockout()
+	var remaining time.Duration
+	for i, key := range keys {
+		count, err := t.Attempts.RecordFailure(ctx, key, now, ttl)
+		if err != nil {
+			return 0, err
+		}
+		if previous := count - 1; previous > counts[i] {
+			if d := t.lockoutAfter(previous); d > remaining {
+				remaining = d
+			}
+		}
+	}
+	return remaining, nil
+}
+
+// loginResponseWriter is an http.ResponseWriter that calls a function just before writing the
+// header of a successful response, responding instead with HTTP status code 500 with no body if
+// that function fails.
+type loginResponseWriter struct {
+	http.ResponseWriter
+	onSuccess   func() error
+	wroteHeader bool
+	failed      bool
+}
+
+func (w *loginResponseWriter) WriteHeader(code int) {
+	if w.wroteHeader {
+		return
+	}
+	if code >= 100 && code < 200 && code != http.StatusSwitchingProtocols {
+		w.ResponseWriter.WriteHeader(code)
+		return
+	}
+	w.wroteHeader = true
+	if code < http.StatusBadRequest {
+		if err := w.onSuccess(); err != nil {
+			w.failed = true
+			// Withhold whatever the handler meant to send, such as a fresh session's cookie.
+			h := w.Header()
+			for k := range h {
+				delete(h, k)
+			}
+			sendDefaultResponse(w.ResponseWriter)
+			return
+		}
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *loginResponseWriter) Write(b []byte) (int, error) {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if w.failed {
+		return len(b), nil
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+// Flush implements http.Flusher, writing the response header first if necessary, and flushing the
+// underlying http.ResponseWriter if it supports doing so.
+func (w *loginResponseWriter) Flush() {
+	if !w.wroteHeader {
+		w.WriteHeader(http.StatusOK)
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok && !w.failed {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that http.ResponseController can reach
+// the methods that loginResponseWriter doesn't implement itself.
+func (w *loginResponseWriter) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// statusRecorder is an http.ResponseWriter that records the status code of the response and the
+// number of bytes written in its body.
+type statusRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (w *statusRecorder) WriteHeader(code int) {
+	if w.status == 0 {
+		w.status = code
+	}
+	w.ResponseWriter.WriteHeader(code)
+}
+
+func (w *statusRecorder) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	n, err := w.ResponseWriter.Write(b)
+	w.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher, flushing the underlying http.ResponseWriter if it supports doing
+// so.
+func (w *statusRecorder) Flush() {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	if f, ok := w.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
+
+// Unwrap returns the underlying http.ResponseWriter, so that http.ResponseController can reach
+// the methods that statusRecorder doesn't implement itself.
+func (w *statusRecorder) Unwrap() http.ResponseWriter {
+	return w.ResponseWriter
+}
+
+// ThrottleLogins returns an HTTP handler that rejects login attempts from clients currently locked
+// out, delegating other requests to the supplied handler, which attempts the login, such as one
+// returned by WithBasicAuth. So that concurrent attempts can't all slip past the threshold, it
+// counts each attempt as a failure before delegating it, rejecting it too if other attempts counted
+// meanwhile lock the client out. It judges the outcome of each attempt by the status code with which
+// the supplied handler responds: any status code below 400 counts as success, which clears the
+// client's failures just before the response header is written, while any other status code,
+// such as 401 or 403, leaves the attempt counted as a failure.
+//
+// It delegates requests from clients locked out to the onLocked handler, after setting the
+// Retry-After header to the number of seconds remaining in the lockout. If no such onLocked handler
+// is supplied, it will respond with HTTP status code 429 with no body. If consulting or updating
+// the AttemptStore fails, it responds with HTTP status code 500 with no body, discarding the header
+// of any successful response. It panics if the supplied handler is nil.
+func (t *LoginThrottle) ThrottleLogins(h http.Handler, onLocked http.Handler) http.Handler {
+	if h == nil {
+		panic("no consuming HTTP handler supplied")
+	}
+	if onLocked == nil {
+		onLocked = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		})
+	}
+	clock := clockOrSystem(t.Clock)
+	locked := func(w http.ResponseWriter, r *http.Request, remaining time.Duration) {
+		w.Header().Set("Retry-After", strconv.FormatInt(int64((remaining+time.Second-1)/time.Second), 10))
+		onLocked.ServeHTTP(w, r)
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ctx := r.Context()
+		keys := attemptKeys(r)
+		remaining, counts, err := t.lockedFor(ctx, keys, clock.Now())
+		if err != nil {
+			sendDefaultResponse(w)
+			return
+		}
+		if remaining > 0 {
+			locked(w, r, remaining)
+			return
+		}
+		remaining, err = t.reserve(ctx, keys, counts, clock.Now())
+		if err != nil {
+			sendDefaultResponse(w)
+			return
+		}
+		if remaining > 0 {
+			locked(w, r, remaining)
+			return
+		}
+		lw := &loginResponseWriter{ResponseWriter: w, onSuccess: func() error {
+			for _, key := range keys {
+				if err := t.Attempts.Reset(ctx, key); err != nil {
+					return err
+				}
+			}
+			return nil
+		}}
+		h.ServeHTTP(lw, r)
+		if !lw.wroteHeader {
+			lw.WriteHeader(http.StatusOK)
+		}
+	})
+}
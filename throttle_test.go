@@ -0,0 +1,168 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+)
+
+func TestThrottleLoginsPanicsWithNoHandler(t *testing.T) {
+	defer ensurePanicWithValueOccured(t)
+	(&handler.LoginThrottle{}).ThrottleLogins(nil, nil)
+}
+
+func TestThrottleLogins(t *testing.T) {
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	throttle := &handler.LoginThrottle{
+		Attempts:   &handler.MemoryAttemptStore{},
+		Threshold:  2,
+		Lockout:    time.Second,
+		MaxLockout: 3 * time.Second,
+		Clock:      clock,
+	}
+	h := throttle.ThrottleLogins(handler.WithBasicAuth(handler.StaticCredentials(map[string]string{"alice": "s3cret"}), "app", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	})), nil)
+	login := func(addr, password string) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/login", nil)
+		r.RemoteAddr = addr
+		r.SetBasicAuth("alice", password)
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		return recorder
+	}
+	const attacker, other = "192.0.2.1:1234", "198.51.100.1:1234"
+
+	for i, want := range []int{http.StatusUnauthorized, http.StatusUnauthorized, http.StatusTooManyRequests} {
+		if got := login(attacker, "guess").Code; got != want {
+			t.Errorf("status for attempt %d: got %d, want %d", i+1, got, want)
+		}
+	}
+	if recorder := login(attacker, "s3cret"); recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "1" {
+		t.Errorf("locked out: got status %d with Retry-After %q, want %d with %q", recorder.Code, recorder.Header().Get("Retry-After"), http.StatusTooManyRequests, "1")
+	}
+	if got, want := login(other, "s3cret").Code, http.StatusNoContent; got != want {
+		t.Errorf("status for other client: got %d, want %d", got, want)
+	}
+
+	// The lockout doubles with each further failure, up to the maximum.
+	for _, lockout := range []time.Duration{time.Second, 2 * time.Second, 3 * time.Second, 3 * time.Second} {
+		clock.Advance(lockout)
+		if got, want := login(attacker, "guess").Code, http.StatusUnauthorized; got != want {
+			t.Fatalf("status after %v lockout: got %d, want %d", lockout, got, want)
+		}
+	}
+	clock.Advance(3 * time.Second)
+	if got, want := login(attacker, "s3cret").Code, http.StatusNoContent; got != want {
+		t.Errorf("status after lockout: got %d, want %d", got, want)
+	}
+	if got, want := login(attacker, "guess").Code, http.StatusUnauthorized; got != want {
+		t.Errorf("status after success: got %d, want %d", got, want)
+	}
+}
+
+func TestThrottleLoginsWithConcurrentAttempts(t *testing.T) {
+	throttle := &handler.LoginThrottle{
+		Attempts:  &handler.MemoryAttemptStore{},
+		Threshold: 2,
+	}
+	const n = 8
+	var admitted, rejected int32
+	var pending sync.WaitGroup
+	pending.Add(n)
+	release := make(chan struct{})
+	h := throttle.ThrottleLogins(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&admitted, 1)
+		pending.Done()
+		<-release
+		w.WriteHeader(http.StatusUnauthorized)
+	}), http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&rejected, 1)
+		pending.Done()
+		w.WriteHeader(http.StatusTooManyRequests)
+	}))
+	var wg sync.WaitGroup
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			r := httptest.NewRequest(http.MethodPost, "/login", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			h.ServeHTTP(httptest.NewRecorder(), r)
+		}()
+	}
+	// Hold the admitted attempts until every attempt is either admitted or rejected.
+	pending.Wait()
+	close(release)
+	wg.Wait()
+	if got, want := atomic.LoadInt32(&admitted), int32(throttle.Threshold); got != want {
+		t.Errorf("attempts admitted: got %d, want %d", got, want)
+	}
+	if got, want := atomic.LoadInt32(&rejected), int32(n-throttle.Threshold); got != want {
+		t.Errorf("attempts rejected: got %d, want %d", got, want)
+	}
+}
+
+// failingAttemptStore is an AttemptStore that fails to record or reset failures.
+type failingAttemptStore struct {
+	handler.MemoryAttemptStore
+	recordErr, resetErr error
+}
+
+func (s *failingAttemptStore) RecordFailure(ctx context.Context, key string, now time.Time, ttl time.Duration) (int, error) {
+	if s.recordErr != nil {
+		return 0, s.recordErr
+	}
+	return s.MemoryAttemptStore.RecordFailure(ctx, key, now, ttl)
+}
+
+func (s *failingAttemptStore) Reset(ctx context.Context, key string) error {
+	if s.resetErr != nil {
+		return s.resetErr
+	}
+	return s.MemoryAttemptStore.Reset(ctx, key)
+}
+
+func TestThrottleLoginsWithFailingAttemptStore(t *testing.T) {
+	for _, test := range []struct {
+		description string
+		store       *failingAttemptStore
+		wantCalled  bool
+	}{
+		{"recording", &failingAttemptStore{recordErr: errors.New("disk full")}, false},
+		{"resetting", &failingAttemptStore{resetErr: errors.New("disk full")}, true},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			called := false
+			h := (&handler.LoginThrottle{Attempts: test.store}).ThrottleLogins(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				called = true
+				http.SetCookie(w, &http.Cookie{Name: "s", Value: "alice"})
+				w.Write([]byte("welcome"))
+			}), nil)
+			r := httptest.NewRequest(http.MethodPost, "/login", nil)
+			r.RemoteAddr = "192.0.2.1:1234"
+			recorder := httptest.NewRecorder()
+			h.ServeHTTP(recorder, r)
+			if called != test.wantCalled {
+				t.Errorf("handler called: got %t, want %t", called, test.wantCalled)
+			}
+			if got, want := recorder.Code, http.StatusInternalServerError; got != want {
+				t.Errorf("status: got %d, want %d", got, want)
+			}
+			if cookie := recorder.Header().Get("Set-Cookie"); len(cookie) != 0 || recorder.Body.Len() != 0 {
+				t.Errorf("response: got cookie %q and body %q, want neither", cookie, recorder.Body.String())
+			}
+		})
+	}
+}
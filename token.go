@@ -0,0 +1,100 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"sync"
+	"time"
+
+	"github.com/gorilla/securecookie"
+)
+
+// ErrTokenNotFound is the error that a TokenStore returns when asked to take a token that it
+// doesn't hold, whether because it was never issued, has expired, or was already taken.
+var ErrTokenNotFound = errors.New("token not found")
+
+// TokenStore holds values keyed by single-use tokens. To keep such values in a session instead,
+// with no separate storage, use a TokenMap.
+type TokenStore interface {
+	// Put stores the value under the given token, expiring after ttl.
+	Put(ctx context.Context, token string, value []byte, ttl time.Duration) error
+	// Take retrieves and removes the value stored under the given token, or returns
+	// ErrTokenNotFound if no such value is present or the value has expired. It must ensure that
+	// at most one call succeeds for each token, even when called concurrently.
+	Take(ctx context.Context, token string) ([]byte, error)
+}
+
+type memoryToken struct {
+	value   []byte
+	expires time.Time
+}
+
+// MemoryTokenStore is a TokenStore that holds its tokens in process memory, suitable for tests and
+// for single-process deployments. The zero value is ready for use.
+type MemoryTokenStore struct {
+	// Clock reports the current time, used to determine when tokens expire. If nil, the store uses
+	// SystemClock.
+	Clock  Clock
+	mu     sync.Mutex
+	tokens map[string]memoryToken
+}
+
+func (s *MemoryTokenStore) now() time.Time {
+	if s.Clock != nil {
+		return s.Clock.Now()
+	}
+	return SystemClock.Now()
+}
+
+// Put stores a copy of the value under the given token, expiring after ttl. It discards any expired
+// tokens it encounters along the way.
+func (s *MemoryTokenStore) Put(_ context.Context, token string, value []byte, ttl time.Duration) error {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.tokens == nil {
+		s.tokens = make(map[string]memoryToken)
+	}
+	for k, t := range s.tokens {
+		if !now.Before(t.expires) {
+			delete(s.tokens, k)
+		}
+	}
+	s.tokens[token] = memoryToken{append([]byte(nil), value...), now.Add(ttl)}
+	return nil
+}
+
+// Take retrieves and removes the value stored under the given token, or returns ErrTokenNotFound if
+// no such value is present or the value has expired.
+func (s *MemoryTokenStore) Take(_ context.Context, token string) ([]byte, error) {
+	now := s.now()
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	t, ok := s.tokens[token]
+	if !ok {
+		return nil, ErrTokenNotFound
+	}
+	delete(s.tokens, token)
+	if !now.Before(t.expires) {
+		return nil, ErrTokenNotFound
+	}
+	return t.value, nil
+}
+
+// newToken returns a fresh random token suitable for use in URLs, together with the key under which
+// to store its value. Storing only a digest of the token keeps a leaked TokenStore from revealing
+// redeemable tokens.
+func newToken() (token, key string) {
+	token = base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(32))
+	return token, tokenKey(token)
+}
+
+func tokenKey(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
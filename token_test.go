@@ -0,0 +1,39 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"bytes"
+	"context"
+	"testing"
+	"time"
+
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+)
+
+func TestMemoryTokenStore(t *testing.T) {
+	ctx := context.Background()
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	s := handler.MemoryTokenStore{Clock: clock}
+	if _, err := s.Take(ctx, "absent"); err != handler.ErrTokenNotFound {
+		t.Errorf("error for absent token: got %v, want %v", err, handler.ErrTokenNotFound)
+	}
+	s.Put(ctx, "a", []byte("v"), time.Minute)
+	s.Put(ctx, "b", []byte("w"), time.Minute)
+	got, err := s.Take(ctx, "a")
+	if err != nil {
+		t.Fatalf("failed to take token: %v", err)
+	}
+	if want := []byte("v"); !bytes.Equal(got, want) {
+		t.Errorf("value: got %q, want %q", got, want)
+	}
+	if _, err := s.Take(ctx, "a"); err != handler.ErrTokenNotFound {
+		t.Errorf("error for taken token: got %v, want %v", err, handler.ErrTokenNotFound)
+	}
+	clock.Advance(time.Minute)
+	if _, err := s.Take(ctx, "b"); err != handler.ErrTokenNotFound {
+		t.Errorf("error for expired token: got %v, want %v", err, handler.ErrTokenNotFound)
+	}
+}
@@ -0,0 +1,134 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"encoding/base64"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+)
+
+// TokenMap keeps short-lived values in a session, each under a token and with its own expiration
+// time, suiting patterns such as pending invitations, draft IDs, and checkout intents that would
+// otherwise call for separate storage. Each value can be taken only once.
+//
+// Like the nonces that IssueNonce records, its entries persist only once the caller saves the
+// session, and stores that keep session values in cookies can't prevent a client from replaying a
+// cookie that bore an entry before it was taken. For values that must be redeemable at most once
+// regardless of the store, keep them in a TokenStore instead.
+type TokenMap struct {
+	// Key is the session value key under which the map records its entries.
+	Key string
+	// MaxEntries bounds how many unexpired entries the map holds, discarding the oldest beyond it.
+	// If not positive, the map holds at most sixteen.
+	MaxEntries int
+	// Clock reports the current time, used to determine when entries expire. If nil, the map uses
+	// SystemClock.
+	Clock Clock
+}
+
+func (m TokenMap) maxEntries() int {
+	if m.MaxEntries > 0 {
+		return m.MaxEntries
+	}
+	return 16
+}
+
+// tokenMapEntry is an entry in a TokenMap, recorded in the session as its expiration time in
+// seconds since the Unix epoch, followed by a period, the query-escaped token, an equals sign, and
+// the value.
+type tokenMapEntry struct {
+	encoded string
+	token   string
+	value   string
+}
+
+// entries returns the session's unexpired entries, in the order in which they were put.
+func (m TokenMap) entries(s *sessions.Session, now time.Time) []tokenMapEntry {
+	encoded := stringsValue(s.Values[m.Key])
+	live := make([]tokenMapEntry, 0, len(encoded)+1)
+	for _, e := range encoded {
+		expiresPart, rest, ok := strings.Cut(e, ".")
+		if !ok {
+			continue
+		}
+		expires, err := strconv.ParseInt(expiresPart, 10, 64)
+		if err != nil || !now.Before(time.Unix(expires, 0)) {
+			continue
+		}
+		escaped, value, ok := strings.Cut(rest, "=")
+		if !ok {
+			continue
+		}
+		token, err := url.QueryUnescape(escaped)
+		if err != nil {
+			continue
+		}
+		live = append(live, tokenMapEntry{e, token, value})
+	}
+	return live
+}
+
+// store records the entries in the session, removing its value if there are none.
+func (m TokenMap) store(s *sessions.Session, entries []tokenMapEntry) {
+	if len(entries) == 0 {
+		delete(s.Values, m.Key)
+		return
+	}
+	encoded := make([]string, len(entries))
+	for i, e := range entries {
+		encoded[i] = e.encoded
+	}
+	s.Values[m.Key] = encoded
+}
+
+// Put records the value in the session under the given token, replacing any value already recorded
+// under it, to expire after the given time to live. It also discards any expired entries, and the
+// oldest entries beyond the map's MaxEntries.
+func (m TokenMap) Put(s *sessions.Session, token, value string, ttl time.Duration) {
+	now := clockOrSystem(m.Clock).Now()
+	entries := m.entries(s, now)
+	kept := entries[:0]
+	for _, e := range entries {
+		if e.token != token {
+			kept = append(kept, e)
+		}
+	}
+	kept = append(kept, tokenMapEntry{
+		encoded: strconv.FormatInt(now.Add(ttl).Unix(), 10) + "." + url.QueryEscape(token) + "=" + value,
+		token:   token,
+		value:   value,
+	})
+	if excess := len(kept) - m.maxEntries(); excess > 0 {
+		kept = kept[excess:]
+	}
+	m.store(s, kept)
+}
+
+// Issue records the value in the session under a fresh random token, as Put does, and returns the
+// token, suitable for use in URLs.
+func (m TokenMap) Issue(s *sessions.Session, value string, ttl time.Duration) string {
+	token := base64.RawURLEncoding.EncodeToString(securecookie.GenerateRandomKey(16))
+	m.Put(s, token, value, ttl)
+	return token
+}
+
+// Take removes the value recorded in the session under the given token, returning it together
+// with whether it was present and unexpired. It also discards any expired entries.
+func (m TokenMap) Take(s *sessions.Session, token string) (string, bool) {
+	entries := m.entries(s, clockOrSystem(m.Clock).Now())
+	for i, e := range entries {
+		if e.token == token {
+			m.store(s, append(entries[:i], entries[i+1:]...))
+			return e.value, true
+		}
+	}
+	m.store(s, entries)
+	return "", false
+}
@@ -0,0 +1,63 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+)
+
+func TestTokenMap(t *testing.T) {
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	m := handler.TokenMap{Key: "invitations", MaxEntries: 3, Clock: clock}
+	s := sessions.NewSession(simpleStore{}, "s")
+
+	m.Put(s, "team.a=b", "alice@example.com", time.Minute)
+	m.Put(s, "team-b", "bob@example.com", 2*time.Minute)
+	m.Put(s, "team.a=b", "carol@example.com", 2*time.Minute)
+	if _, ok := m.Take(s, "bogus"); ok {
+		t.Error("took value under bogus token")
+	}
+	if v, ok := m.Take(s, "team.a=b"); !ok || v != "carol@example.com" {
+		t.Errorf("took (%q, %t), want replaced value", v, ok)
+	}
+	if _, ok := m.Take(s, "team.a=b"); ok {
+		t.Error("took value twice")
+	}
+
+	// Entries recorded by a JSON serializer come back as []interface{}.
+	b, err := json.Marshal(s.Values["invitations"])
+	if err != nil {
+		t.Fatal(err)
+	}
+	var decoded []interface{}
+	if err := json.Unmarshal(b, &decoded); err != nil {
+		t.Fatal(err)
+	}
+	s.Values["invitations"] = decoded
+	clock.Advance(2 * time.Minute)
+	if _, ok := m.Take(s, "team-b"); ok {
+		t.Error("took expired value")
+	}
+	if _, ok := s.Values["invitations"]; ok {
+		t.Error("expired entries remain in session")
+	}
+
+	oldest := m.Issue(s, "draft 1", time.Minute)
+	newest := oldest
+	for i := 0; i < 3; i++ {
+		newest = m.Issue(s, "draft", time.Minute)
+	}
+	if _, ok := m.Take(s, oldest); ok {
+		t.Error("took value beyond the entry limit")
+	}
+	if v, ok := m.Take(s, newest); !ok || v != "draft" {
+		t.Errorf("took (%q, %t) under issued token, want (%q, true)", v, ok, "draft")
+	}
+}
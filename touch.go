@@ -0,0 +1,190 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+const (
+	// StartedAtKey is the session value key under which RecordSessionStart records when the
+	// session began, in seconds since the Unix epoch, from which TouchHandler measures its absolute
+	// lifetime.
+	StartedAtKey = "handler.started_at"
+	// TouchedAtKey is the session value key under which TouchHandler records when it last extended
+	// the session, in seconds since the Unix epoch.
+	TouchedAtKey = "handler.touched_at"
+)
+
+// RecordSessionStart records the current time as when the session began, such as upon login, for
+// TouchHandler to measure the session's absolute lifetime from. If the Clock is nil, it uses
+// SystemClock.
+func RecordSessionStart(s *sessions.Session, clock Clock) {
+	s.Values[StartedAtKey] = clockOrSystem(clock).Now().Unix()
+}
+
+// TouchPolicy describes how TouchHandler extends sessions.
+type TouchPolicy struct {
+	// IdleTimeout is how long a session lasts after each extension. If not positive, TouchHandler
+	// uses the session's own maximum age, per its options.
+	IdleTimeout time.Duration
+	// AbsoluteLifetime, if positive, bounds how long a session lasts after it began, per
+	// RecordSessionStart, no matter how often it's extended. Sessions lacking a record of when
+	// they began are deemed to begin when first extended.
+	AbsoluteLifetime time.Duration
+	// MinInterval is the shortest time between extensions of a given session; requests arriving
+	// sooner are rejected. If not positive, TouchHandler uses thirty seconds.
+	MinInterval time.Duration
+	// Clock reports the current time. If nil, TouchHandler uses SystemClock.
+	Clock Clock
+}
+
+func (p TouchPolicy) minInterval() time.Duration {
+	if p.MinInterval > 0 {
+		return p.MinInterval
+	}
+	return 30 * time.Second
+}
+
+func (p TouchPolicy) idleTimeout(s *sessions.Session) time.Duration {
+	if p.IdleTimeout <= 0 && s.Options != nil {
+		return time.Duration(s.Options.MaxAge) * time.Second
+	}
+	return p.IdleTimeout
+}
+
+// SessionDeadlines holds when a session expires.
+type SessionDeadlines struct {
+	// Idle is when the session expires unless extended, or zero if unknown.
+	Idle time.Time
+	// Absolute is when the session expires no matter how often it's extended, or zero if its
+	// lifetime is unbounded or unknown.
+	Absolute time.Time
+}
+
+// Expires returns the earlier of the nonzero deadlines, or zero if both are zero.
+func (d SessionDeadlines) Expires() time.Time {
+	if d.Idle.IsZero() || !d.Absolute.IsZero() && d.Absolute.Before(d.Idle) {
+		return d.Absolute
+	}
+	return d.Idle
+}
+
+// Deadlines returns when the session expires under the policy. It measures the idle deadline from
+// when TouchHandler last extended the session or RefreshNearExpiry last saved it, whichever is
+// later, and so can't tell the idle deadline of sessions that neither has recorded.
+func (p TouchPolicy) Deadlines(s *sessions.Session) SessionDeadlines {
+	var d SessionDeadlines
+	last, ok := unixTimeValue(s.Values[TouchedAtKey])
+	if refreshed, refreshedOK := refreshedAt(s); refreshedOK && (!ok || refreshed.After(last)) {
+		last, ok = refreshed, true
+	}
+	if idle := p.idleTimeout(s); ok && idle > 0 {
+		d.Idle = last.Add(idle)
+	}
+	if started, ok := unixTimeValue(s.Values[StartedAtKey]); ok && p.AbsoluteLifetime > 0 {
+		d.Absolute = started.Add(p.AbsoluteLifetime)
+	}
+	return d
+}
+
+// touchResponse is the body of the responses from TouchHandler.
+type touchResponse struct {
+	Expires time.Time `json:"expires"`
+}
+
+// TouchHandler returns an HTTP handler for frontends' heartbeat requests that extends the singular
+// session bound to each request via WithSession by the policy's idle timeout, but no further than
+// the end of its absolute lifetime, and saves it, responding with a JSON object whose "expires"
+// member holds the session's new expiration time, such as {"expires":"2017-01-01T00:30:00Z"}.
+// Once a session reaches the end of its absolute lifetime, it responds with that time, leaving the
+// session to expire.
+//
+// It delegates requests that would extend a session sooner than the policy's minimum interval
+// since its last extension to the onReject handler, after setting the Retry-After header to the
+// number of seconds remaining until the session may be extended again. If no such onReject handler
+// is supplied, it will respond with HTTP status code 429 with no body. It responds to requests
+// bound to a fresh session, for which there's nothing to keep alive, with HTTP status code 401 with
+// no body, and to requests with no bound session or whose session it fails to save with HTTP
+// status code 500 with no body.
+func TouchHandler(p TouchPolicy, onReject http.Handler) http.Handler {
+	if onReject == nil {
+		onReject = http.HandlerFunc(func(w http.ResponseWriter, _ *http.Request) {
+			w.WriteHeader(http.StatusTooManyRequests)
+		})
+	}
+	clock := clockOrSystem(p.Clock)
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s, ok := ExtractSession(r)
+		if !ok {
+			sendDefaultResponse(w)
+			return
+		}
+		if s.IsNew {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		now := clock.Now()
+		if at, ok := unixTimeValue(s.Values[TouchedAtKey]); ok {
+			if remaining := at.Add(p.minInterval()).Sub(now); remaining > 0 {
+				setRetryAfter(w.Header(), remaining)
+				onReject.ServeHTTP(w, r)
+				return
+			}
+		}
+		expires := now.Add(p.idleTimeout(s))
+		if p.AbsoluteLifetime > 0 {
+			started, ok := unixTimeValue(s.Values[StartedAtKey])
+			if !ok {
+				started = now
+				s.Values[StartedAtKey] = now.Unix()
+			}
+			if end := started.Add(p.AbsoluteLifetime); end.Before(expires) {
+				expires = end
+			}
+		}
+		if maxAge := int(expires.Sub(now) / time.Second); maxAge > 0 {
+			expires = now.Add(time.Duration(maxAge) * time.Second)
+			options := sessions.Options{}
+			if s.Options != nil {
+				options = *s.Options
+			}
+			options.MaxAge = maxAge
+			s.Options = &options
+			s.Values[TouchedAtKey] = now.Unix()
+			if err := s.Save(r, w); err != nil {
+				sendDefaultResponse(w)
+				return
+			}
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		json.NewEncoder(w).Encode(touchResponse{expires.UTC()})
+	})
+}
+
+// OnNearExpiry returns an Option that calls fn with each bound session loaded from its store that
+// expires within the given threshold, per the policy's Deadlines, along with when it expires, before
+// the request handler sees it, letting applications show a warning or start reauthenticating
+// silently. The function may change the session, such as by adding a flash message; supply this
+// Option after AutoSave for AutoSave to save such changes. If the policy's Clock is nil, it uses
+// SystemClock.
+func OnNearExpiry(p TouchPolicy, threshold time.Duration, fn func(r *http.Request, s *sessions.Session, expires time.Time)) Option {
+	clock := clockOrSystem(p.Clock)
+	return func(c *bindingConfig) {
+		c.decorators = append(c.decorators, func(r *http.Request, s *sessions.Session) *sessions.Session {
+			if s.IsNew {
+				return s
+			}
+			if expires := p.Deadlines(s).Expires(); !expires.IsZero() && expires.Sub(clock.Now()) < threshold {
+				fn(r, s, expires)
+			}
+			return s
+		})
+	}
+}
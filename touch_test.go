@@ -0,0 +1,116 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/gorilla/securecookie"
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+)
+
+func TestTouchHandler(t *testing.T) {
+	start := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := handlertest.NewFakeClock(start)
+	store := sessions.NewCookieStore(securecookie.GenerateRandomKey(32))
+	login := handler.WithSession("s", store, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		s := handler.MustExtractSession(r)
+		handler.RecordSessionStart(s, clock)
+		s.Save(r, w)
+	}), nil)
+	touch := handler.WithSession("s", store, handler.TouchHandler(handler.TouchPolicy{
+		IdleTimeout:      30 * time.Minute,
+		AbsoluteLifetime: time.Hour,
+		MinInterval:      time.Minute,
+		Clock:            clock,
+	}, nil), nil)
+	var cookie *http.Cookie
+	serve := func(h http.Handler) *httptest.ResponseRecorder {
+		r := httptest.NewRequest(http.MethodPost, "/touch", nil)
+		if cookie != nil {
+			r.AddCookie(cookie)
+		}
+		recorder := httptest.NewRecorder()
+		h.ServeHTTP(recorder, r)
+		if cookies := recorder.Result().Cookies(); len(cookies) != 0 {
+			cookie = cookies[0]
+		}
+		return recorder
+	}
+	expect := func(recorder *httptest.ResponseRecorder, want time.Time, maxAge int) {
+		t.Helper()
+		if recorder.Code != http.StatusOK {
+			t.Fatalf("status code: got %d, want %d", recorder.Code, http.StatusOK)
+		}
+		var body struct {
+			Expires time.Time `json:"expires"`
+		}
+		if err := json.NewDecoder(recorder.Body).Decode(&body); err != nil {
+			t.Fatalf("failed to decode response: %v", err)
+		}
+		if !body.Expires.Equal(want) {
+			t.Errorf("expiry: got %v, want %v", body.Expires, want)
+		}
+		if cookie.MaxAge != maxAge {
+			t.Errorf("cookie max age: got %d, want %d", cookie.MaxAge, maxAge)
+		}
+	}
+
+	if got, want := serve(touch).Code, http.StatusUnauthorized; got != want {
+		t.Errorf("status code with no session: got %d, want %d", got, want)
+	}
+	serve(login)
+	clock.Advance(10 * time.Minute)
+	expect(serve(touch), start.Add(40*time.Minute), 30*60)
+
+	clock.Advance(30 * time.Second)
+	recorder := serve(touch)
+	if recorder.Code != http.StatusTooManyRequests || recorder.Header().Get("Retry-After") != "30" {
+		t.Errorf("touch too soon: got status %d with Retry-After %q, want %d with %q", recorder.Code, recorder.Header().Get("Retry-After"), http.StatusTooManyRequests, "30")
+	}
+
+	// The absolute lifetime caps the extension.
+	clock.Advance(40 * time.Minute)
+	expect(serve(touch), start.Add(time.Hour), 9*60+30)
+}
+
+func TestOnNearExpiry(t *testing.T) {
+	start := time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC)
+	clock := handlertest.NewFakeClock(start)
+	p := handler.TouchPolicy{IdleTimeout: 30 * time.Minute, AbsoluteLifetime: time.Hour, Clock: clock}
+	source := valuesSource{handler.StartedAtKey: start.Unix(), handler.TouchedAtKey: start.Add(20 * time.Minute).Unix()}
+	for _, test := range []struct {
+		description string
+		source      handler.SessionSource
+		elapsed     time.Duration
+		want        time.Time
+	}{
+		{"fresh", simpleStore{}, 55 * time.Minute, time.Time{}},
+		{"far from expiry", source, 30 * time.Minute, time.Time{}},
+		{"near idle expiry", source, 46 * time.Minute, start.Add(50 * time.Minute)},
+	} {
+		t.Run(test.description, func(t *testing.T) {
+			clock.Set(start.Add(test.elapsed))
+			var expires time.Time
+			h := handler.WithSession("s", test.source, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if got, want := len(handler.MustExtractSession(r).Flashes()) != 0, !test.want.IsZero(); got != want {
+					t.Errorf("session bears flash: got %t, want %t", got, want)
+				}
+			}), nil, handler.AutoSave(nil), handler.OnNearExpiry(p, 5*time.Minute, func(r *http.Request, s *sessions.Session, at time.Time) {
+				expires = at
+				s.AddFlash("Your session is about to expire.")
+			}))
+			h.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest("", "/", nil))
+			if !expires.Equal(test.want) {
+				t.Errorf("expiry reported: got %v, want %v", expires, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,79 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"time"
+
+	"github.com/gorilla/sessions"
+)
+
+// WebAuthnCeremony identifies a kind of WebAuthn ceremony whose challenge data a session holds
+// between beginning and finishing the ceremony.
+type WebAuthnCeremony string
+
+// The WebAuthn ceremonies whose challenge data StoreWebAuthnCeremony can hold.
+const (
+	WebAuthnRegistration   WebAuthnCeremony = "registration"
+	WebAuthnAuthentication WebAuthnCeremony = "authentication"
+)
+
+func (c WebAuthnCeremony) key() string {
+	return "handler.webauthn." + string(c)
+}
+
+// ErrNoWebAuthnCeremony is the error that TakeWebAuthnCeremony returns when the session bears no
+// challenge data for the ceremony, or only data that has expired.
+var ErrNoWebAuthnCeremony = errors.New("no pending WebAuthn ceremony")
+
+// webAuthnCeremonyData is the challenge data recorded in the session, together with its expiration
+// time in seconds since the Unix epoch.
+type webAuthnCeremonyData struct {
+	Data    json.RawMessage `json:"data"`
+	Expires int64           `json:"expires"`
+}
+
+// StoreWebAuthnCeremony records in the session the challenge data for the given ceremony, such as
+// the webauthn.SessionData that package github.com/go-webauthn/webauthn returns upon beginning a
+// registration or login, to be taken once by TakeWebAuthnCeremony within the given time to live.
+// It encodes the data as JSON, so its type needn't be registered with encoding/gob. It replaces
+// any data recorded for a ceremony of the same kind begun earlier. If the Clock is nil, it uses
+// SystemClock.
+func StoreWebAuthnCeremony(s *sessions.Session, ceremony WebAuthnCeremony, data interface{}, ttl time.Duration, clock Clock) error {
+	b, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+	b, err = json.Marshal(webAuthnCeremonyData{b, clockOrSystem(clock).Now().Add(ttl).Unix()})
+	if err != nil {
+		return err
+	}
+	s.Values[ceremony.key()] = b
+	return nil
+}
+
+// TakeWebAuthnCeremony removes the challenge data recorded in the session for the given ceremony by
+// StoreWebAuthnCeremony and decodes it into dst, such as a *webauthn.SessionData to pass to the
+// function finishing the registration or login. Removing the data ensures that each challenge can
+// be answered at most once, provided that the caller saves the session. It returns
+// ErrNoWebAuthnCeremony if the session bears no such data or the data has expired. If the Clock is
+// nil, it uses SystemClock.
+func TakeWebAuthnCeremony(s *sessions.Session, ceremony WebAuthnCeremony, dst interface{}, clock Clock) error {
+	key := ceremony.key()
+	b, ok := s.Values[key].([]byte)
+	delete(s.Values, key)
+	if !ok {
+		return ErrNoWebAuthnCeremony
+	}
+	var stored webAuthnCeremonyData
+	if err := json.Unmarshal(b, &stored); err != nil {
+		return err
+	}
+	if !clockOrSystem(clock).Now().Before(time.Unix(stored.Expires, 0)) {
+		return ErrNoWebAuthnCeremony
+	}
+	return json.Unmarshal(stored.Data, dst)
+}
@@ -0,0 +1,59 @@
+// Copyright 2017 Steven E. Harris. All rights reserved.
+// Use of this source code is governed by a BSD-style license that can be found in the LICENSE file.
+
+package handler_test
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/gorilla/sessions"
+	"github.com/seh/handler"
+	"github.com/seh/handler/handlertest"
+)
+
+// sessionData mirrors the shape of go-webauthn's webauthn.SessionData.
+type sessionData struct {
+	Challenge        string    `json:"challenge"`
+	UserID           []byte    `json:"user_id"`
+	AllowedCredIDs   [][]byte  `json:"allowed_credentials,omitempty"`
+	Expires          time.Time `json:"expires"`
+	UserVerification string    `json:"userVerification"`
+}
+
+func TestWebAuthnCeremony(t *testing.T) {
+	clock := handlertest.NewFakeClock(time.Date(2017, time.January, 1, 0, 0, 0, 0, time.UTC))
+	s := sessions.NewSession(simpleStore{}, "s")
+	want := sessionData{
+		Challenge:        "c2lnbg",
+		UserID:           []byte("alice"),
+		AllowedCredIDs:   [][]byte{[]byte("cred")},
+		Expires:          clock.Now().Add(time.Minute),
+		UserVerification: "preferred",
+	}
+	if err := handler.StoreWebAuthnCeremony(s, handler.WebAuthnRegistration, want, time.Minute, clock); err != nil {
+		t.Fatalf("failed to store ceremony: %v", err)
+	}
+	var got sessionData
+	if err := handler.TakeWebAuthnCeremony(s, handler.WebAuthnAuthentication, &got, clock); err != handler.ErrNoWebAuthnCeremony {
+		t.Errorf("error taking other ceremony: got %v, want %v", err, handler.ErrNoWebAuthnCeremony)
+	}
+	if err := handler.TakeWebAuthnCeremony(s, handler.WebAuthnRegistration, &got, clock); err != nil {
+		t.Fatalf("failed to take ceremony: %v", err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("ceremony data: got %+v, want %+v", got, want)
+	}
+	if err := handler.TakeWebAuthnCeremony(s, handler.WebAuthnRegistration, &got, clock); err != handler.ErrNoWebAuthnCeremony {
+		t.Errorf("error taking ceremony again: got %v, want %v", err, handler.ErrNoWebAuthnCeremony)
+	}
+
+	if err := handler.StoreWebAuthnCeremony(s, handler.WebAuthnAuthentication, want, time.Minute, clock); err != nil {
+		t.Fatalf("failed to store ceremony: %v", err)
+	}
+	clock.Advance(time.Minute)
+	if err := handler.TakeWebAuthnCeremony(s, handler.WebAuthnAuthentication, &got, clock); err != handler.ErrNoWebAuthnCeremony {
+		t.Errorf("error taking expired ceremony: got %v, want %v", err, handler.ErrNoWebAuthnCeremony)
+	}
+}